@@ -0,0 +1,340 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// An ActionGraph is the complete set of generated build actions with all
+// Ninja variables expanded, as written by Context.WriteActionGraph.
+type ActionGraph struct {
+	Pools   map[string]int `json:"pools,omitempty"` // Pool names to depths.
+	Actions []*Action      `json:"actions"`
+}
+
+// An Action is a single build statement in an ActionGraph.  Paths are not
+// Ninja-escaped.  Like Ninja, the Command and Variables quote the paths that
+// $in and $out expand to for the shell where necessary, except in the
+// depfile, dyndep and rspfile variables.
+type Action struct {
+	Rule        string            `json:"rule"`
+	Command     string            `json:"command"`
+	Pool        string            `json:"pool,omitempty"`
+	Outputs     []string          `json:"outputs"`
+	Inputs      []string          `json:"inputs,omitempty"`
+	Implicits   []string          `json:"implicits,omitempty"`
+	OrderOnly   []string          `json:"order_only,omitempty"`
+	Validations []string          `json:"validations,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	Variables   map[string]string `json:"variables,omitempty"` // Other rule variables, e.g. "depfile".
+	Optional    bool              `json:"optional,omitempty"`
+
+	// The outputs that are symlinks, a subset of Outputs.
+	SymlinkOutputs []string `json:"symlink_outputs,omitempty"`
+}
+
+// WriteActionGraph writes the generated build actions to w as a JSON encoded
+// ActionGraph.  If this is called before PrepareBuildActions successfully
+// completes then ErrBuildActionsNotReady is returned.
+func (c *Context) WriteActionGraph(w io.Writer) error {
+	aw := newActionGraphWriter()
+
+	err := c.WriteManifest(aw)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(&aw.graph, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// actionGraphWriter is a ManifestWriter that builds an ActionGraph.
+type actionGraphWriter struct {
+	variables map[string]string
+	rules     map[string]*ManifestRule
+	graph     ActionGraph
+}
+
+func newActionGraphWriter() *actionGraphWriter {
+	return &actionGraphWriter{
+		variables: make(map[string]string),
+		rules:     make(map[string]*ManifestRule),
+		graph: ActionGraph{
+			Pools:   make(map[string]int),
+			Actions: []*Action{},
+		},
+	}
+}
+
+func (a *actionGraphWriter) Comment(comment string) error {
+	return nil
+}
+
+func (a *actionGraphWriter) BlankLine() error {
+	return nil
+}
+
+func (a *actionGraphWriter) Variable(name, value string) error {
+	// Like Ninja, top-level variables are expanded when they are assigned.
+	expanded, err := expandNinjaValue(value, a.lookupVariable)
+	if err != nil {
+		return fmt.Errorf("error expanding variable %q: %s", name, err)
+	}
+
+	a.variables[name] = expanded
+	return nil
+}
+
+func (a *actionGraphWriter) Pool(pool *ManifestPool) error {
+	a.graph.Pools[pool.Name] = pool.Depth
+	return nil
+}
+
+func (a *actionGraphWriter) Rule(rule *ManifestRule) error {
+	a.rules[rule.Name] = rule
+	return nil
+}
+
+func (a *actionGraphWriter) Build(build *ManifestBuild) error {
+	rule, ok := a.rules[build.Rule]
+	if !ok && build.Rule == "phony" {
+		// The builtin phony rule has no command or variables.
+		rule, ok = &ManifestRule{Name: "phony"}, true
+	}
+	if !ok {
+		return fmt.Errorf("build statement uses undefined rule %q", build.Rule)
+	}
+
+	// Build arguments are expanded in the top-level scope, while paths and
+	// rule variables are expanded in the scope of the build statement.
+	args := make(map[string]string)
+	for name, value := range build.Args {
+		expanded, err := expandNinjaValue(value, a.lookupVariable)
+		if err != nil {
+			return fmt.Errorf("error expanding argument %q: %s", name, err)
+		}
+		args[name] = expanded
+	}
+
+	lookupArg := func(name string) (string, error) {
+		if value, ok := args[name]; ok {
+			return value, nil
+		}
+		return a.lookupVariable(name)
+	}
+
+	action := &Action{
+		Rule:      build.Rule,
+		Pool:      rule.Pool,
+		Variables: make(map[string]string),
+		Optional:  build.Optional,
+	}
+
+	pathLists := []struct {
+		dst *[]string
+		src []string
+	}{
+		{&action.Outputs, build.Outputs},
+		{&action.SymlinkOutputs, build.SymlinkOutputs},
+		{&action.Inputs, build.Inputs},
+		{&action.Implicits, build.Implicits},
+		{&action.OrderOnly, build.OrderOnly},
+		{&action.Validations, build.Validations},
+	}
+
+	for _, list := range pathLists {
+		for _, path := range list.src {
+			expanded, err := expandNinjaValue(path, lookupArg)
+			if err != nil {
+				return fmt.Errorf("error expanding path %q: %s", path, err)
+			}
+			*list.dst = append(*list.dst, expanded)
+		}
+	}
+
+	// Like Ninja, the paths in $in and $out are quoted for the shell, except
+	// in the variables that name files rather than being run by the shell.
+	escapePaths := true
+	pathList := func(paths []string, sep string) string {
+		if !escapePaths {
+			return strings.Join(paths, sep)
+		}
+		escaped := make([]string, len(paths))
+		for i, path := range paths {
+			escaped[i] = shellEscape(path)
+		}
+		return strings.Join(escaped, sep)
+	}
+
+	var lookup func(name string) (string, error)
+	expanding := make(map[string]bool)
+	lookup = func(name string) (string, error) {
+		switch name {
+		case "in":
+			return pathList(action.Inputs, " "), nil
+		case "in_newline":
+			return pathList(action.Inputs, "\n"), nil
+		case "out":
+			return pathList(action.Outputs, " "), nil
+		}
+
+		if value, ok := args[name]; ok {
+			return value, nil
+		}
+
+		if value, ok := rule.Variables[name]; ok {
+			if expanding[name] {
+				return "", fmt.Errorf("cycle in rule variable %q", name)
+			}
+			expanding[name] = true
+			defer delete(expanding, name)
+			return expandNinjaValue(value, lookup)
+		}
+
+		return a.lookupVariable(name)
+	}
+
+	for name := range rule.Variables {
+		escapePaths = !unescapedRuleVariables[name]
+		expanded, err := lookup(name)
+		if err != nil {
+			return fmt.Errorf("error expanding rule %q variable %q: %s",
+				build.Rule, name, err)
+		}
+
+		if name == "command" {
+			action.Command = expanded
+		} else {
+			action.Variables[name] = expanded
+		}
+	}
+
+	escapePaths = true
+	for name, value := range rule.Env {
+		expanded, err := expandNinjaValue(value, lookup)
+		if err != nil {
+			return fmt.Errorf("error expanding rule %q env %q: %s",
+				build.Rule, name, err)
+		}
+
+		if action.Env == nil {
+			action.Env = make(map[string]string)
+		}
+		action.Env[name] = expanded
+	}
+
+	a.graph.Actions = append(a.graph.Actions, action)
+	return nil
+}
+
+func (a *actionGraphWriter) lookupVariable(name string) (string, error) {
+	// Like Ninja, references to undefined variables expand to nothing.
+	return a.variables[name], nil
+}
+
+// expandNinjaValue expands the variable references in a value written in Ninja
+// syntax, calling lookup to get the expanded value of each variable.
+func expandNinjaValue(value string,
+	lookup func(name string) (string, error)) (string, error) {
+
+	buf := bytes.NewBuffer(nil)
+
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' {
+			buf.WriteByte(value[i])
+			continue
+		}
+
+		i++
+		if i == len(value) {
+			return "", fmt.Errorf("unexpected end of value after '$'")
+		}
+
+		switch c := value[i]; {
+		case c == '$' || c == ' ' || c == ':':
+			buf.WriteByte(c)
+		case c == '\n':
+			// A line continuation; skip the leading whitespace on the next
+			// line.
+			for i+1 < len(value) && value[i+1] == ' ' {
+				i++
+			}
+		case c == '{':
+			end := strings.IndexByte(value[i:], '}')
+			if end == -1 {
+				return "", fmt.Errorf("missing '}' after '${'")
+			}
+			expanded, err := lookup(value[i+1 : i+end])
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(expanded)
+			i += end
+		case isNinjaSimpleVarNameChar(c):
+			start := i
+			for i+1 < len(value) && isNinjaSimpleVarNameChar(value[i+1]) {
+				i++
+			}
+			expanded, err := lookup(value[start : i+1])
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(expanded)
+		default:
+			return "", fmt.Errorf("invalid character after '$': %q", c)
+		}
+	}
+
+	return buf.String(), nil
+}
+
+// unescapedRuleVariables are the rule variables that Ninja expands $in and $out
+// in without quoting the paths for the shell.
+var unescapedRuleVariables = map[string]bool{
+	"depfile": true,
+	"dyndep":  true,
+	"rspfile": true,
+}
+
+// shellEscape quotes s for the shell like Ninja quotes the paths in $in and
+// $out, if it contains any characters that aren't known to be safe.
+func shellEscape(s string) string {
+	for i := 0; i < len(s); i++ {
+		if !isShellSafeChar(s[i]) {
+			return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+		}
+	}
+	return s
+}
+
+func isShellSafeChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '+' || c == '-' || c == '.' || c == '/'
+}
+
+func isNinjaSimpleVarNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9' || c == '_' || c == '-'
+}
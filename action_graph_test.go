@@ -0,0 +1,172 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+var expandNinjaValueTestCases = []struct {
+	input  string
+	output string
+}{
+	{
+		input:  "abc def",
+		output: "abc def",
+	},
+	{
+		input:  "$$ $  $: $\n    x",
+		output: "$   : x",
+	},
+	{
+		input:  "$foo.c ${foo}.c ${foo.bar}",
+		output: "FOO.c FOO.c FOOBAR",
+	},
+	{
+		input:  "$undefined-x",
+		output: "",
+	},
+}
+
+func TestExpandNinjaValue(t *testing.T) {
+	vars := map[string]string{
+		"foo":     "FOO",
+		"foo.bar": "FOOBAR",
+	}
+	lookup := func(name string) (string, error) {
+		return vars[name], nil
+	}
+
+	for _, testCase := range expandNinjaValueTestCases {
+		output, err := expandNinjaValue(testCase.input, lookup)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", testCase.input, err)
+			continue
+		}
+		if output != testCase.output {
+			t.Errorf("incorrect expansion of %q", testCase.input)
+			t.Errorf("  expected: %q", testCase.output)
+			t.Errorf("       got: %q", output)
+		}
+	}
+}
+
+func TestActionGraphWriter(t *testing.T) {
+	w := newActionGraphWriter()
+
+	ck(w.Variable("g.cc.cc", "gcc"))
+	ck(w.Variable("g.cc.flags", "-Wall -I${g.cc.include}"))
+	ck(w.Pool(&ManifestPool{Name: "g.cc.pool", Depth: 4}))
+	ck(w.Rule(&ManifestRule{
+		Name: "g.cc.compile",
+		Pool: "g.cc.pool",
+		Variables: map[string]string{
+			"command": "${g.cc.cc} ${g.cc.flags} $cflags -c $in -o $out",
+			"depfile": "${out}.d",
+		},
+	}))
+	ck(w.Build(&ManifestBuild{
+		Rule:      "g.cc.compile",
+		Outputs:   []string{"out/foo$ bar.o"},
+		Inputs:    []string{"foo.c"},
+		Implicits: []string{"${g.cc.cc}"},
+		Args:      map[string]string{"cflags": "-O2"},
+		Optional:  true,
+	}))
+
+	if err := w.Build(&ManifestBuild{Rule: "undefined"}); err == nil {
+		t.Errorf("expected error for undefined rule")
+	}
+
+	expected := ActionGraph{
+		Pools: map[string]int{"g.cc.pool": 4},
+		Actions: []*Action{
+			{
+				Rule:      "g.cc.compile",
+				Command:   "gcc -Wall -I -O2 -c foo.c -o 'out/foo bar.o'",
+				Pool:      "g.cc.pool",
+				Outputs:   []string{"out/foo bar.o"},
+				Inputs:    []string{"foo.c"},
+				Implicits: []string{"gcc"},
+				Variables: map[string]string{"depfile": "out/foo bar.o.d"},
+				Optional:  true,
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(w.graph, expected) {
+		t.Errorf("incorrect action graph")
+		t.Errorf("  expected: %#v", expected.Actions[0])
+		t.Errorf("       got: %#v", w.graph.Actions)
+	}
+}
+
+func TestActionGraphWriterPhony(t *testing.T) {
+	w := newActionGraphWriter()
+
+	ck(w.Build(&ManifestBuild{
+		Rule:    "phony",
+		Outputs: []string{"all"},
+		Inputs:  []string{"foo"},
+	}))
+
+	if len(w.graph.Actions) != 1 || w.graph.Actions[0].Command != "" {
+		t.Errorf("incorrect phony action: %#v", w.graph.Actions)
+	}
+}
+
+func TestActionGraphWriterEscaping(t *testing.T) {
+	w := newActionGraphWriter()
+
+	ck(w.Rule(&ManifestRule{
+		Name: "g.cc.link",
+		Variables: map[string]string{
+			"command":         "ld -MF $depfile @$rspfile -o $out",
+			"description":     "LINK $out",
+			"depfile":         "${out}.d",
+			"rspfile":         "${out}.rsp",
+			"rspfile_content": "$in_newline",
+		},
+	}))
+	ck(w.Build(&ManifestBuild{
+		Rule:    "g.cc.link",
+		Outputs: []string{"out/my$ app"},
+		Inputs:  []string{"a.o", "dir/file-1.0+x_y.o", "it's.o", "$$HOME.o"},
+	}))
+
+	// $depfile and $rspfile are expanded in the command with the path quoted,
+	// like Ninja does, which the shell treats the same as the whole path quoted.
+	expected := &Action{
+		Rule:    "g.cc.link",
+		Command: "ld -MF 'out/my app'.d @'out/my app'.rsp -o 'out/my app'",
+		Outputs: []string{"out/my app"},
+		Inputs:  []string{"a.o", "dir/file-1.0+x_y.o", "it's.o", "$HOME.o"},
+		Variables: map[string]string{
+			"description":     "LINK 'out/my app'",
+			"depfile":         "out/my app.d",
+			"rspfile":         "out/my app.rsp",
+			"rspfile_content": "a.o\ndir/file-1.0+x_y.o\n'it'\\''s.o'\n'$HOME.o'",
+		},
+	}
+
+	if len(w.graph.Actions) != 1 || !reflect.DeepEqual(w.graph.Actions[0], expected) {
+		t.Errorf("incorrect action graph")
+		t.Errorf("  expected: %#v", expected)
+		for _, action := range w.graph.Actions {
+			t.Errorf("       got: %#v", action)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// actiontrace records how long the build actions run by Ninja take.  When the
+// bootstrap process is given -trace-actions it wraps the command of every rule
+// in the main Ninja file with
+//
+//	actiontrace -d <dir> -r <rule> <target>... -- <command>
+//
+// which runs the command and writes a span describing it to a new file in
+// <dir>.  The command is left unquoted in the Ninja file, so the shell that
+// Ninja runs has already expanded it and split it into arguments, and it is
+// run directly rather than by another shell.  After the build
+//
+//	actiontrace -merge -o trace.json <dir>
+//
+// merges the spans into a file that can be loaded into chrome://tracing.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	merge  = flag.Bool("merge", false, "merge the spans in the given directories into a Chrome trace")
+	output = flag.String("o", "", "the Chrome trace file to write with -merge")
+	dir    = flag.String("d", "", "the directory to write the span of the command to")
+	rule   = flag.String("r", "", "the rule the command belongs to")
+)
+
+// A span describes a single run of a build action.
+type span struct {
+	Target string `json:"target"`
+	Rule   string `json:"rule"`
+	Start  int64  `json:"start"` // Microseconds since the Unix epoch.
+	Dur    int64  `json:"dur"`   // Microseconds.
+	Exit   int    `json:"exit"`
+}
+
+// A traceEvent is a complete event in the Chrome trace event format.
+type traceEvent struct {
+	Name string            `json:"name"`
+	Cat  string            `json:"cat"`
+	Ph   string            `json:"ph"`
+	Ts   int64             `json:"ts"`
+	Dur  int64             `json:"dur"`
+	Pid  int               `json:"pid"`
+	Tid  int               `json:"tid"`
+	Args map[string]string `json:"args,omitempty"`
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: actiontrace -d dir -r rule target... -- command...\n")
+	fmt.Fprintf(os.Stderr, "       actiontrace -merge -o trace.json dir...\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if *merge {
+		if *output == "" || flag.NArg() == 0 {
+			usage()
+		}
+		if err := mergeSpans(*output, flag.Args()); err != nil {
+			fmt.Fprintf(os.Stderr, "actiontrace: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// The flag package stops at the first target, so the targets are the
+	// arguments before "--".
+	args := flag.Args()
+	var targets []string
+	for i, arg := range args {
+		if arg == "--" {
+			targets, args = args[:i], args[i+1:]
+			break
+		}
+	}
+
+	if *dir == "" || len(args) == 0 {
+		usage()
+	}
+
+	os.Exit(run(strings.Join(targets, " "), args))
+}
+
+// run runs the command in args, which may start with variable assignments for
+// its environment like a shell command, records its span for target and
+// returns its exit status.  Failing to record the span doesn't fail the build
+// action.
+func run(target string, args []string) int {
+	env := os.Environ()
+	for len(args) > 1 && isAssignment(args[0]) {
+		env = append(env, args[0])
+		args = args[1:]
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	end := time.Now()
+
+	exit := 0
+	if err != nil {
+		exit = 1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				exit = status.ExitStatus()
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "actiontrace: %s\n", err)
+		}
+	}
+
+	s := span{
+		Target: target,
+		Rule:   *rule,
+		Start:  start.UnixNano() / int64(time.Microsecond),
+		Dur:    end.Sub(start).Nanoseconds() / int64(time.Microsecond),
+		Exit:   exit,
+	}
+
+	if err := writeSpan(*dir, s); err != nil {
+		fmt.Fprintf(os.Stderr, "actiontrace: %s\n", err)
+	}
+
+	return exit
+}
+
+// isAssignment returns true if arg is a shell variable assignment.
+func isAssignment(arg string) bool {
+	i := strings.IndexByte(arg, '=')
+	if i <= 0 {
+		return false
+	}
+	for j := 0; j < i; j++ {
+		c := arg[j]
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			j > 0 && c >= '0' && c <= '9') {
+
+			return false
+		}
+	}
+	return true
+}
+
+// writeSpan writes s to a new file in dir.
+func writeSpan(dir string, s span) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, "span")
+	if err != nil {
+		return err
+	}
+
+	err = json.NewEncoder(f).Encode(s)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readSpans returns the spans in the files in dirs, ordered by start time.
+func readSpans(dirs []string) ([]span, error) {
+	var spans []span
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+
+			data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			var s span
+			if err := json.Unmarshal(data, &s); err != nil {
+				return nil, fmt.Errorf("%s: %s", filepath.Join(dir, file.Name()), err)
+			}
+			spans = append(spans, s)
+		}
+	}
+
+	sort.Sort(spansByStart(spans))
+	return spans, nil
+}
+
+// mergeSpans writes the spans in dirs to filename as a Chrome trace.  Each
+// span is assigned to the first thread that is idle when it starts, so the
+// threads show how many actions were running at once.
+func mergeSpans(filename string, dirs []string) error {
+	spans, err := readSpans(dirs)
+	if err != nil {
+		return err
+	}
+
+	var base int64
+	if len(spans) > 0 {
+		base = spans[0].Start
+	}
+
+	var threadEnds []int64
+	events := []traceEvent{}
+	for _, s := range spans {
+		tid := -1
+		for i, end := range threadEnds {
+			if end <= s.Start {
+				tid = i
+				break
+			}
+		}
+		if tid == -1 {
+			tid = len(threadEnds)
+			threadEnds = append(threadEnds, 0)
+		}
+		threadEnds[tid] = s.Start + s.Dur
+
+		event := traceEvent{
+			Name: s.Target,
+			Cat:  s.Rule,
+			Ph:   "X",
+			Ts:   s.Start - base,
+			Dur:  s.Dur,
+			Pid:  0,
+			Tid:  tid,
+			Args: map[string]string{"rule": s.Rule},
+		}
+		if s.Exit != 0 {
+			event.Args["exit"] = fmt.Sprint(s.Exit)
+		}
+		events = append(events, event)
+	}
+
+	data, err := json.Marshal(struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}{events})
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, data, 0666)
+}
+
+type spansByStart []span
+
+func (s spansByStart) Len() int           { return len(s) }
+func (s spansByStart) Less(i, j int) bool { return s[i].Start < s[j].Start }
+func (s spansByStart) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
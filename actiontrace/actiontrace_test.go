@@ -0,0 +1,201 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "actiontrace_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	*dir, *rule = filepath.Join(tmpDir, "spans"), "cc"
+	defer func() { *dir, *rule = "", "" }()
+
+	// The arguments have already been split by the shell Ninja runs, so
+	// they are passed on as they are.
+	if exit := run("out/a.o", []string{"FOO=a b", "/bin/sh", "-c", `test "$FOO" = "a b"`}); exit != 0 {
+		t.Errorf("expected exit status 0, got %d", exit)
+	}
+	if exit := run("out/a.o", []string{"/bin/sh", "-c", "exit 3"}); exit != 3 {
+		t.Errorf("expected exit status 3, got %d", exit)
+	}
+
+	spans, err := readSpans([]string{*dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+
+	var exits []int
+	for _, s := range spans {
+		if s.Target != "out/a.o" || s.Rule != "cc" {
+			t.Errorf("expected target %q and rule %q, got %q and %q", "out/a.o", "cc",
+				s.Target, s.Rule)
+		}
+		if s.Start <= 0 || s.Dur < 0 {
+			t.Errorf("expected a start time and duration, got %d and %d", s.Start, s.Dur)
+		}
+		exits = append(exits, s.Exit)
+	}
+	if spans[0].Start > spans[1].Start {
+		t.Errorf("expected spans ordered by start time, got %d before %d",
+			spans[0].Start, spans[1].Start)
+	}
+	if !reflect.DeepEqual(exits, []int{0, 3}) && !reflect.DeepEqual(exits, []int{3, 0}) {
+		t.Errorf("expected exit statuses 0 and 3, got %v", exits)
+	}
+}
+
+var mergeSpansTestCases = []struct {
+	name   string
+	spans  [][]span // The spans written to each directory.
+	events []traceEvent
+}{
+	{
+		name:   "no spans",
+		spans:  [][]span{nil},
+		events: []traceEvent{},
+	},
+	{
+		name: "serial",
+		spans: [][]span{{
+			{Target: "b.o", Rule: "cc", Start: 1100, Dur: 50},
+			{Target: "a.o", Rule: "cc", Start: 1000, Dur: 100},
+		}},
+		events: []traceEvent{
+			{Name: "a.o", Cat: "cc", Ph: "X", Ts: 0, Dur: 100, Tid: 0,
+				Args: map[string]string{"rule": "cc"}},
+			{Name: "b.o", Cat: "cc", Ph: "X", Ts: 100, Dur: 50, Tid: 0,
+				Args: map[string]string{"rule": "cc"}},
+		},
+	},
+	{
+		name: "parallel",
+		spans: [][]span{{
+			{Target: "a.o", Rule: "cc", Start: 1000, Dur: 100},
+			{Target: "b.o", Rule: "cc", Start: 1010, Dur: 100},
+			{Target: "c.o", Rule: "cc", Start: 1020, Dur: 100},
+			{Target: "d.o", Rule: "cc", Start: 1105, Dur: 10},
+			{Target: "e.o", Rule: "cc", Start: 1115, Dur: 10},
+		}},
+		events: []traceEvent{
+			{Name: "a.o", Cat: "cc", Ph: "X", Ts: 0, Dur: 100, Tid: 0,
+				Args: map[string]string{"rule": "cc"}},
+			{Name: "b.o", Cat: "cc", Ph: "X", Ts: 10, Dur: 100, Tid: 1,
+				Args: map[string]string{"rule": "cc"}},
+			{Name: "c.o", Cat: "cc", Ph: "X", Ts: 20, Dur: 100, Tid: 2,
+				Args: map[string]string{"rule": "cc"}},
+			{Name: "d.o", Cat: "cc", Ph: "X", Ts: 105, Dur: 10, Tid: 0,
+				Args: map[string]string{"rule": "cc"}},
+			{Name: "e.o", Cat: "cc", Ph: "X", Ts: 115, Dur: 10, Tid: 0,
+				Args: map[string]string{"rule": "cc"}},
+		},
+	},
+	{
+		name: "failed action in another directory",
+		spans: [][]span{
+			{{Target: "a.o", Rule: "cc", Start: 1000, Dur: 100}},
+			{{Target: "lib.a", Rule: "ar", Start: 1100, Dur: 20, Exit: 1}},
+		},
+		events: []traceEvent{
+			{Name: "a.o", Cat: "cc", Ph: "X", Ts: 0, Dur: 100, Tid: 0,
+				Args: map[string]string{"rule": "cc"}},
+			{Name: "lib.a", Cat: "ar", Ph: "X", Ts: 100, Dur: 20, Tid: 0,
+				Args: map[string]string{"rule": "ar", "exit": "1"}},
+		},
+	},
+}
+
+func TestMergeSpans(t *testing.T) {
+	for _, testCase := range mergeSpansTestCases {
+		func() {
+			dir, err := ioutil.TempDir("", "actiontrace_test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			var dirs []string
+			for i, spans := range testCase.spans {
+				spanDir := filepath.Join(dir, "spans", string('a'+rune(i)))
+				err := os.MkdirAll(spanDir, 0777)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, s := range spans {
+					err := writeSpan(spanDir, s)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+				dirs = append(dirs, spanDir)
+			}
+
+			traceFile := filepath.Join(dir, "trace.json")
+			err = mergeSpans(traceFile, dirs)
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", testCase.name, err)
+				return
+			}
+
+			data, err := ioutil.ReadFile(traceFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var trace struct {
+				TraceEvents []traceEvent `json:"traceEvents"`
+			}
+			err = json.Unmarshal(data, &trace)
+			if err != nil {
+				t.Errorf("%s: malformed trace: %s", testCase.name, err)
+				return
+			}
+
+			if !reflect.DeepEqual(trace.TraceEvents, testCase.events) {
+				t.Errorf("%s: expected events %+v, got %+v", testCase.name,
+					testCase.events, trace.TraceEvents)
+			}
+		}()
+	}
+}
+
+func TestReadMalformedSpan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "actiontrace_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "span"), []byte("{"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readSpans([]string{dir}); err == nil {
+		t.Errorf("expected an error reading a malformed span")
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+)
+
+// AliasModuleType is the name of the module type that every Context supports
+// for giving a module another name.  An alias module lets the modules that
+// depend on a module keep using its old name while it is renamed, for
+// example:
+//
+//	blueprint_alias {
+//	    name: "libfoo_old",
+//	    actual: "libfoo",
+//	    warn: true,
+//	}
+//
+// makes a dependency on "libfoo_old" a dependency on "libfoo".  The actual
+// module is looked up from the directory of the alias, like a dependency of a
+// module defined there, and may not be another alias.  If warn is set, each
+// module that depends on the alias is reported with a warning at the position
+// of its dependencies, so that the remaining uses of the old name can be found
+// before the alias is removed.
+const AliasModuleType = "blueprint_alias"
+
+type aliasModule struct {
+	properties struct {
+		// Actual is the name of the module that the alias refers to.
+		Actual string
+
+		// Warn reports each module that depends on the alias as a warning.
+		Warn bool
+	}
+}
+
+func newAliasModule() (Module, []interface{}) {
+	m := &aliasModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (a *aliasModule) GenerateBuildActions(ModuleContext) {
+}
+
+// addAlias adds the alias declared by the alias module.
+func (c *Context) addAlias(module *moduleInfo) []error {
+	module.namespace = c.moduleNamespace(module.relBlueprintsFile)
+	name := module.namespace.key(module.properties.Name)
+
+	if module.logicModule.(*aliasModule).properties.Actual == "" {
+		return []error{&Error{
+			Err: fmt.Errorf("alias %q must set actual", name),
+			Pos: module.pos,
+		}}
+	}
+
+	var previous *moduleInfo
+	if group, ok := c.moduleGroups[name]; ok {
+		previous = group.modules[0]
+	} else if alias, ok := c.moduleAliases[name]; ok {
+		previous = alias
+	}
+	if previous != nil {
+		return duplicateModuleErrors(name, module, previous)
+	}
+
+	if c.moduleAliases == nil {
+		c.moduleAliases = make(map[string]*moduleInfo)
+	}
+	c.moduleAliases[name] = module
+
+	return nil
+}
+
+// resolveAlias returns the group of the module that alias refers to for
+// module, which depends on it as depName, and reports the dependency if the
+// alias warns about its uses.
+func (c *Context) resolveAlias(module *moduleInfo, depName string,
+	alias *moduleInfo) (*moduleGroup, error) {
+
+	properties := alias.logicModule.(*aliasModule).properties
+
+	group, target, err := c.lookupModuleGroupOrAlias(alias, properties.Actual)
+	if err != nil {
+		return nil, err
+	}
+	if target != nil {
+		return nil, fmt.Errorf("alias %q refers to alias %q",
+			alias.properties.Name, properties.Actual)
+	}
+	if group == nil {
+		return nil, fmt.Errorf("%q depends on alias %q of undefined module %q",
+			module.properties.Name, depName, properties.Actual)
+	}
+
+	if properties.Warn {
+		key := fmt.Sprintf("%s: alias %s", module.properties.Name, depName)
+
+		c.warningsLock.Lock()
+		warned := c.warningKeys[key]
+		c.warningsLock.Unlock()
+
+		if !warned {
+			pos, ok := module.propertyPos["deps"]
+			if !ok {
+				pos = module.pos
+			}
+			warning := fmt.Errorf("%q depends on %q, which is an alias of %q",
+				module.properties.Name, depName, properties.Actual)
+			if c.warn(key, pos, warning, false) != nil {
+				// The caller reports the error at the position of the
+				// dependencies.
+				return nil, warning
+			}
+		}
+	}
+
+	return group, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func runAliasTest(files []blueprintsTestFile) (*Context, []error) {
+	return resolveTestFiles(func(ctx *Context) {
+		ctx.RegisterModuleType("foo_module", newFooModule)
+	}, files)
+}
+
+func TestAlias(t *testing.T) {
+	ctx, errs := runAliasTest([]blueprintsTestFile{
+		{"Blueprints", `
+			foo_module { name: "a", deps: ["libfoo_old", "libbar_old"] }
+			foo_module { name: "b", deps: ["libfoo_old"] }
+		`},
+		{"lib/Blueprints", `
+			foo_module { name: "libfoo" }
+			foo_module { name: "libbar" }
+			blueprint_alias { name: "libfoo_old", actual: "libfoo", warn: true }
+			blueprint_alias { name: "libbar_old", actual: "libbar" }
+		`},
+	})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	deps := make(map[string][]string)
+	for _, name := range []string{"a", "b"} {
+		for _, dep := range ctx.moduleGroups[name].modules[0].directDeps {
+			deps[name] = append(deps[name], dep.properties.Name)
+		}
+	}
+	expected := map[string][]string{
+		"a": {"libfoo", "libbar"},
+		"b": {"libfoo"},
+	}
+	if !reflect.DeepEqual(deps, expected) {
+		t.Errorf("expected dependencies %q, got %q", expected, deps)
+	}
+
+	if _, ok := ctx.moduleGroups["libfoo_old"]; ok {
+		t.Errorf("expected the alias not to be a module")
+	}
+
+	var warnings []string
+	for _, w := range ctx.Warnings() {
+		warnings = append(warnings, w.Error())
+	}
+	// The dependencies are resolved in parallel, so the warnings are
+	// reported in any order.
+	sort.Strings(warnings)
+	expectedWarnings := []string{
+		`Blueprints:2:32: "a" depends on "libfoo_old", which is an alias of "libfoo"`,
+		`Blueprints:3:32: "b" depends on "libfoo_old", which is an alias of "libfoo"`,
+	}
+	if !reflect.DeepEqual(warnings, expectedWarnings) {
+		t.Errorf("expected warnings %q, got %q", expectedWarnings, warnings)
+	}
+}
+
+func TestAliasErrors(t *testing.T) {
+	testCases := []struct {
+		bp   string
+		errs []string
+	}{
+		{
+			bp: `
+				foo_module { name: "a", deps: ["b"] }
+				blueprint_alias { name: "b", actual: "c" }
+			`,
+			errs: []string{
+				`Blueprint:2:33: "a" depends on alias "b" of undefined module "c"`,
+			},
+		},
+		{
+			bp: `
+				foo_module { name: "a", deps: ["b"] }
+				blueprint_alias { name: "b", actual: "c" }
+				blueprint_alias { name: "c", actual: "a" }
+			`,
+			errs: []string{
+				`Blueprint:2:33: alias "b" refers to alias "c"`,
+			},
+		},
+		{
+			bp: `
+				foo_module { name: "a" }
+				blueprint_alias { name: "a", actual: "b" }
+				foo_module { name: "b" }
+			`,
+			errs: []string{
+				`Blueprint:3:5: module "a" already defined`,
+				`Blueprint:2:5: <-- previous definition here`,
+			},
+		},
+		{
+			bp: `blueprint_alias { name: "a" }`,
+			errs: []string{
+				`Blueprint:1:1: alias "a" must set actual`,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		ctx := NewContext()
+		ctx.RegisterModuleType("foo_module", newFooModule)
+
+		modules, _, _, errs := ctx.parse(".", "Blueprint", bytes.NewBufferString(testCase.bp), nil)
+		if len(errs) == 0 {
+			errs = ctx.addModules(modules)
+		}
+		if len(errs) == 0 {
+			errs = ctx.ResolveDependencies(nil)
+		}
+
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		if !reflect.DeepEqual(got, testCase.errs) {
+			t.Errorf("test case: %s", testCase.bp)
+			t.Errorf("  expected errors %q, got %q", testCase.errs, got)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint/parser"
+)
+
+// analysisCacheVersion is part of the key of every analysis cache entry.  It
+// must be changed whenever the parser or the format of the entries changes,
+// so that entries written by an older version are never used.
+const analysisCacheVersion = "3"
+
+func init() {
+	gob.Register(&parser.Assignment{})
+	gob.Register(&parser.Module{})
+}
+
+// An analysisCacheEntry is the result of evaluating a Blueprints file that is
+// stored in the analysis cache.
+type analysisCacheEntry struct {
+	File *parser.File
+
+	// Appended holds the final values of the inherited variables that the
+	// file appended to with +=, which the file changes in the scope of its
+	// parent.
+	Appended map[string]parser.Value
+}
+
+// SetAnalysisCacheDir enables a cache of evaluated Blueprints files in dir,
+// which makes ParseBlueprintsFiles only parse the files that have changed
+// since it was last run with the same cache directory.  Each entry is keyed by
+// the contents of its Blueprints file and the variables it inherits, so
+// changing a file invalidates the entries of the files that inherit its
+// variables, and entries that are no longer used are removed by each
+// successful ParseBlueprintsFiles.  The module property structs are created by
+// the module factories, so they are still unpacked from the cached files.
+func (c *Context) SetAnalysisCacheDir(dir string) {
+	c.analysisCacheDir = dir
+}
+
+// parseAndEval parses and evaluates a Blueprints file like
+// parser.ParseAndEval, using the analysis cache if it is enabled.
+func (c *Context) parseAndEval(filename string, r io.Reader,
+	scope *parser.Scope) (*parser.File, []error) {
+
+	if c.analysisCacheDir == "" {
+		return parser.ParseAndEval(filename, r, scope)
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	key := analysisCacheKey(filename, data, scope)
+	c.useAnalysisCacheEntry(key)
+
+	// An entry that can't be read is treated like a missing one and
+	// replaced.
+	if entry, err := c.readAnalysisCacheEntry(key); err == nil {
+		replayAnalysisCacheEntry(entry, scope)
+
+		c.analysisCacheLock.Lock()
+		c.analysisCacheHits++
+		c.analysisCacheLock.Unlock()
+
+		return entry.File, nil
+	}
+
+	file, errs := parser.ParseAndEval(filename, bytes.NewReader(data), scope)
+	if len(errs) > 0 {
+		return file, errs
+	}
+
+	// Failing to write the cache only makes the next run slower.
+	c.writeAnalysisCacheEntry(key, newAnalysisCacheEntry(file, scope))
+
+	return file, nil
+}
+
+// analysisCacheKey returns the key of the analysis cache entry of the
+// Blueprints file with the given name and contents evaluated in scope.
+func analysisCacheKey(filename string, data []byte, scope *parser.Scope) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d\x00", analysisCacheVersion, filename, len(data))
+	h.Write(data)
+	io.WriteString(h, scope.String())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newAnalysisCacheEntry returns the analysis cache entry of file, which has
+// just been evaluated in scope.
+func newAnalysisCacheEntry(file *parser.File,
+	scope *parser.Scope) *analysisCacheEntry {
+
+	entry := &analysisCacheEntry{File: file}
+
+	own := make(map[*parser.Assignment]bool)
+	for _, def := range file.Defs {
+		if a, ok := def.(*parser.Assignment); ok {
+			own[a] = true
+		}
+	}
+
+	for _, def := range file.Defs {
+		a, ok := def.(*parser.Assignment)
+		if !ok || a.Assigner != "+=" {
+			continue
+		}
+
+		if old, err := scope.Get(a.Name.Name); err == nil && !own[old] {
+			if entry.Appended == nil {
+				entry.Appended = make(map[string]parser.Value)
+			}
+			entry.Appended[a.Name.Name] = old.Value
+		}
+	}
+
+	return entry
+}
+
+// replayAnalysisCacheEntry makes the same changes to scope that evaluating the
+// Blueprints file of entry would: it adds the variables that the file assigns,
+// appends to the ones it inherits and marks the ones it references.
+func replayAnalysisCacheEntry(entry *analysisCacheEntry, scope *parser.Scope) {
+	for _, def := range entry.File.Defs {
+		switch def := def.(type) {
+		case *parser.Assignment:
+			// A variable that is already set is either inherited, in which
+			// case it is in Appended, or was set earlier in the file, in
+			// which case the earlier assignment holds the final value.
+			if _, err := scope.Get(def.Name.Name); err != nil {
+				scope.Add(def)
+			}
+			markReferencedVariables(def.OrigValue, scope)
+			markReferencedVariables(def.Value, scope)
+		case *parser.Module:
+			for _, property := range def.Properties {
+				markReferencedVariables(property.Value, scope)
+			}
+		}
+	}
+
+	for name, value := range entry.Appended {
+		if a, err := scope.Get(name); err == nil {
+			a.Value = value
+		}
+	}
+}
+
+// markReferencedVariables marks the variables in scope that value was
+// evaluated from as referenced.
+func markReferencedVariables(value parser.Value, scope *parser.Scope) {
+	if value.Variable != "" {
+		if a, err := scope.Get(value.Variable); err == nil {
+			a.Referenced = true
+		}
+	}
+
+	if value.Expression != nil {
+		markReferencedVariables(value.Expression.Args[0], scope)
+		markReferencedVariables(value.Expression.Args[1], scope)
+	}
+
+	if value.Select != nil {
+		markReferencedVariables(value.Select.Cases, scope)
+	}
+
+	for _, elem := range value.ListValue {
+		markReferencedVariables(elem, scope)
+	}
+
+	for _, property := range value.MapValue {
+		markReferencedVariables(property.Value, scope)
+	}
+}
+
+func (c *Context) useAnalysisCacheEntry(key string) {
+	c.analysisCacheLock.Lock()
+	defer c.analysisCacheLock.Unlock()
+
+	if c.analysisCacheUsed == nil {
+		c.analysisCacheUsed = make(map[string]bool)
+	}
+	c.analysisCacheUsed[key] = true
+}
+
+func (c *Context) readAnalysisCacheEntry(key string) (*analysisCacheEntry, error) {
+	f, err := os.Open(filepath.Join(c.analysisCacheDir, key))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entry := &analysisCacheEntry{}
+	err = gob.NewDecoder(f).Decode(entry)
+	if err != nil {
+		return nil, err
+	}
+	if entry.File == nil {
+		return nil, fmt.Errorf("analysis cache entry %s has no file", key)
+	}
+
+	return entry, nil
+}
+
+// writeAnalysisCacheEntry writes entry to a temporary file that is renamed
+// into place, so that concurrent or interrupted runs never see a partial
+// entry.
+func (c *Context) writeAnalysisCacheEntry(key string, entry *analysisCacheEntry) error {
+	err := os.MkdirAll(c.analysisCacheDir, 0777)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(c.analysisCacheDir, key+".tmp")
+	if err != nil {
+		return err
+	}
+
+	err = gob.NewEncoder(f).Encode(entry)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(c.analysisCacheDir, key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// pruneAnalysisCache removes the entries of the analysis cache that weren't
+// used since the last call, which belong to Blueprints files that have changed
+// or are no longer parsed.
+func (c *Context) pruneAnalysisCache() error {
+	c.analysisCacheLock.Lock()
+	defer c.analysisCacheLock.Unlock()
+
+	used := c.analysisCacheUsed
+	c.analysisCacheUsed = nil
+
+	files, err := ioutil.ReadDir(c.analysisCacheDir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if used[name] || file.IsDir() {
+			continue
+		}
+		// Leave the temporary files of other processes alone.
+		if strings.Contains(name, ".tmp") {
+			continue
+		}
+		err := os.Remove(filepath.Join(c.analysisCacheDir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type cacheTestModule struct {
+	properties struct {
+		Foo   string
+		Flags []string
+	}
+}
+
+func newCacheTestModule() (Module, []interface{}) {
+	m := &cacheTestModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (m *cacheTestModule) GenerateBuildActions(ModuleContext) {
+}
+
+var analysisCacheTestFiles = map[string]string{
+	"Blueprints": `
+		subdirs = ["sub"]
+		flags = ["-a"]
+		sub_only = "s"
+		unused = "u"
+
+		cache_module {
+			name: "root",
+			foo: "r",
+		}
+	`,
+	"sub/Blueprints": `
+		subdirs = ["sub2"]
+		flags += ["-b"]
+		local = "l"
+
+		cache_module {
+			name: "sub",
+			foo: local + select(arch, { arm: sub_only, default: "" }),
+		}
+	`,
+	"sub/sub2/Blueprints": `
+		cache_module {
+			name: "sub2",
+			flags: flags,
+		}
+	`,
+}
+
+// parseAnalysisCacheTestTree parses the Blueprints files in dir using the
+// analysis cache in cacheDir, and returns the properties of the modules, the
+// warnings and the number of cache hits.
+func parseAnalysisCacheTestTree(t *testing.T, dir, cacheDir string) (
+	map[string]interface{}, []string, int) {
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("cache_module", newCacheTestModule)
+	ctx.SetSelectAxis("arch", "arm")
+	ctx.SetAnalysisCacheDir(cacheDir)
+
+	_, errs := ctx.ParseBlueprintsFiles(filepath.Join(dir, "Blueprints"))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	properties := make(map[string]interface{})
+	ctx.VisitAllModules(func(m Module) {
+		properties[ctx.ModuleName(m)] = m.(*cacheTestModule).properties
+	})
+
+	var warnings []string
+	for _, w := range ctx.Warnings() {
+		warnings = append(warnings, w.Error())
+	}
+
+	return properties, warnings, ctx.analysisCacheHits
+}
+
+func TestAnalysisCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "analysis_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcDir := filepath.Join(dir, "src")
+	cacheDir := filepath.Join(dir, "cache")
+
+	writeFiles := func(files map[string]string) {
+		for name, contents := range files {
+			path := filepath.Join(srcDir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	writeFiles(analysisCacheTestFiles)
+
+	expectedWarnings := []string{
+		filepath.Join(srcDir, "Blueprints") + `:5:3: variable "unused" is never used`,
+	}
+
+	properties, warnings, hits := parseAnalysisCacheTestTree(t, srcDir, cacheDir)
+	if hits != 0 {
+		t.Errorf("expected no cache hits on the first run, got %d", hits)
+	}
+	if !reflect.DeepEqual(warnings, expectedWarnings) {
+		t.Errorf("expected warnings %q, got %q", expectedWarnings, warnings)
+	}
+
+	sub2 := properties["sub2"].(struct {
+		Foo   string
+		Flags []string
+	})
+	if expected := []string{"-a", "-b"}; !reflect.DeepEqual(sub2.Flags, expected) {
+		t.Errorf("expected sub2 flags %q, got %q", expected, sub2.Flags)
+	}
+
+	// Every file is read from the cache, with the same results.
+	cachedProperties, cachedWarnings, hits := parseAnalysisCacheTestTree(t, srcDir, cacheDir)
+	if hits != 3 {
+		t.Errorf("expected 3 cache hits, got %d", hits)
+	}
+	if !reflect.DeepEqual(cachedProperties, properties) {
+		t.Errorf("expected cached properties %v, got %v", properties, cachedProperties)
+	}
+	if !reflect.DeepEqual(cachedWarnings, expectedWarnings) {
+		t.Errorf("expected cached warnings %q, got %q", expectedWarnings, cachedWarnings)
+	}
+
+	// Changing sub2 only reparses it, in the scope replayed from the cached
+	// entries of its parents.
+	writeFiles(map[string]string{
+		"sub/sub2/Blueprints": `
+			cache_module {
+				name: "sub2",
+				foo: "changed",
+				flags: flags,
+			}
+		`,
+	})
+
+	properties, warnings, hits = parseAnalysisCacheTestTree(t, srcDir, cacheDir)
+	if hits != 2 {
+		t.Errorf("expected 2 cache hits, got %d", hits)
+	}
+	if !reflect.DeepEqual(warnings, expectedWarnings) {
+		t.Errorf("expected warnings %q, got %q", expectedWarnings, warnings)
+	}
+
+	sub2 = properties["sub2"].(struct {
+		Foo   string
+		Flags []string
+	})
+	if expected := []string{"-a", "-b"}; !reflect.DeepEqual(sub2.Flags, expected) {
+		t.Errorf("expected sub2 flags %q, got %q", expected, sub2.Flags)
+	}
+	if sub2.Foo != "changed" {
+		t.Errorf("expected sub2 foo %q, got %q", "changed", sub2.Foo)
+	}
+
+	// The entry of the old sub2 was pruned.
+	entries, err := ioutil.ReadDir(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("expected 3 cache entries, got %d", len(entries))
+	}
+}
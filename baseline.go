@@ -0,0 +1,142 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/scanner"
+)
+
+// A Warning describes a problem that is reported without failing the build,
+// such as the use of a deprecated property.  Warnings that are not listed in
+// the baseline set with SetBaseline are reported as errors instead if strict
+// warnings are enabled with SetStrictWarnings.
+type Warning struct {
+	Key string           // identifies the warning independently of its position
+	Err error            // the problem that was found
+	Pos scanner.Position // the relevant Blueprints file location
+}
+
+func (w *Warning) Error() string {
+	return fmt.Sprintf("%s: %s", w.Pos, w.Err)
+}
+
+// SetBaseline sets the keys of the known warnings, which are never reported as
+// errors.  A baseline is normally created by saving the output of WarningKeys,
+// so that strict checks can be enabled for new problems before all of the
+// existing ones have been fixed.
+func (c *Context) SetBaseline(keys []string) {
+	c.baseline = make(map[string]bool)
+	for _, key := range keys {
+		c.baseline[key] = true
+	}
+}
+
+// SetStrictWarnings sets whether warnings that are not in the baseline are
+// reported as errors.
+func (c *Context) SetStrictWarnings(strict bool) {
+	c.strictWarnings = strict
+}
+
+// Warnings returns the warnings that have been found so far.  Each one is a
+// *Warning.
+func (c *Context) Warnings() []error {
+	c.warningsLock.Lock()
+	defer c.warningsLock.Unlock()
+
+	warnings := make([]error, len(c.warnings))
+	for i, w := range c.warnings {
+		warnings[i] = w
+	}
+
+	return warnings
+}
+
+// WarningKeys returns the sorted keys of every warning found so far, including
+// the ones that were reported as errors because they were not in the baseline.
+// It can be saved and passed to SetBaseline to allow the current warnings
+// while failing on new ones.
+func (c *Context) WarningKeys() []string {
+	c.warningsLock.Lock()
+	defer c.warningsLock.Unlock()
+
+	keys := make([]string, 0, len(c.warningKeys))
+	for key := range c.warningKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// warn records a warning.  If it is not in the baseline and either strict is
+// true or strict warnings are enabled, it is returned as an error instead.
+func (c *Context) warn(key string, pos scanner.Position, err error,
+	strict bool) error {
+
+	c.warningsLock.Lock()
+	defer c.warningsLock.Unlock()
+
+	if c.warningKeys == nil {
+		c.warningKeys = make(map[string]bool)
+	}
+	c.warningKeys[key] = true
+
+	if (strict || c.strictWarnings) && !c.baseline[key] {
+		return &Error{
+			Err: err,
+			Pos: pos,
+		}
+	}
+
+	c.warnings = append(c.warnings, &Warning{
+		Key: key,
+		Err: err,
+		Pos: pos,
+	})
+
+	return nil
+}
+
+// ReadBaseline reads warning keys written by WriteBaseline from r.
+func ReadBaseline(r io.Reader) ([]string, error) {
+	var keys []string
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			keys = append(keys, line)
+		}
+	}
+
+	return keys, s.Err()
+}
+
+// WriteBaseline writes warning keys to w, one per line.
+func WriteBaseline(w io.Writer, keys []string) error {
+	for _, key := range keys {
+		_, err := fmt.Fprintln(w, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var unusedVariableTestCases = []struct {
+	strict   bool
+	baseline []string
+	warnings int
+	errors   int
+}{
+	{
+		warnings: 2,
+	},
+	{
+		strict: true,
+		errors: 2,
+	},
+	{
+		strict:   true,
+		baseline: []string{"Blueprint: unused variable unused1"},
+		warnings: 1,
+		errors:   1,
+	},
+}
+
+func TestUnusedVariables(t *testing.T) {
+	for i, testCase := range unusedVariableTestCases {
+		ctx := NewContext()
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.SetStrictWarnings(testCase.strict)
+		ctx.SetBaseline(testCase.baseline)
+
+		r := bytes.NewBufferString(`
+			subdirs = ["*"]
+			used = "abc"
+			unused1 = "def"
+			unused2 = ["ghi"]
+
+			foo_module {
+				name: "MyFooModule",
+				foo: used,
+			}
+		`)
+
+		_, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+
+		errs = ctx.checkUnusedVariables(".")
+		if len(errs) != testCase.errors || len(ctx.Warnings()) != testCase.warnings {
+			t.Errorf("test case %d: expected %d errors and %d warnings", i,
+				testCase.errors, testCase.warnings)
+			t.Errorf("       got: %v and %v", errs, ctx.Warnings())
+		}
+
+		expectedKeys := []string{
+			"Blueprint: unused variable unused1",
+			"Blueprint: unused variable unused2",
+		}
+		if !reflect.DeepEqual(ctx.WarningKeys(), expectedKeys) {
+			t.Errorf("test case %d: incorrect warning keys", i)
+			t.Errorf("  expected: %q", expectedKeys)
+			t.Errorf("       got: %q", ctx.WarningKeys())
+		}
+	}
+}
+
+func TestBaselineReadWrite(t *testing.T) {
+	keys := []string{
+		"Blueprint: unused variable a",
+		"foo: deprecated module type bar",
+	}
+
+	buf := bytes.NewBuffer(nil)
+	ck(WriteBaseline(buf, keys))
+
+	input := "# Known warnings\n\n" + buf.String()
+	readKeys, err := ReadBaseline(bytes.NewBufferString(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !reflect.DeepEqual(readKeys, keys) {
+		t.Errorf("incorrect baseline read back")
+		t.Errorf("  expected: %q", keys)
+		t.Errorf("       got: %q", readKeys)
+	}
+}
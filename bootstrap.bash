@@ -11,11 +11,11 @@
 #
 #   BOOTSTRAP
 #   SRCDIR
+#   BUILDDIR
 #   BOOTSTRAP_MANIFEST
 #   GOROOT
 #   GOOS
 #   GOARCH
-#   GOCHAR
 #
 # The invoking script should then run this script, passing along all of its
 # command line arguments.
@@ -36,6 +36,13 @@ EXTRA_ARGS=""
 # the bootstrap script.
 [ -z "$SRCDIR" ] && SRCDIR=`dirname "${BOOTSTRAP}"`
 
+# BUILDDIR should be set to the path of the directory to write the build
+# outputs to, including build.ninja.  It can be either an absolute path or one
+# relative to the directory that the script and Ninja are run in.  Setting it
+# allows the source directory to be read-only, with Ninja run from it as
+# "ninja -f $BUILDDIR/build.ninja".
+[ -z "$BUILDDIR" ] && BUILDDIR=.
+
 # TOPNAME should be set to the name of the top-level Blueprints file
 [ -z "$TOPNAME" ] && TOPNAME="Blueprints"
 
@@ -49,22 +56,45 @@ EXTRA_ARGS=""
 [ -z "$GOROOT" ] && GOROOT=`go env GOROOT`
 [ -z "$GOOS" ]   && GOOS=`go env GOHOSTOS`
 [ -z "$GOARCH" ] && GOARCH=`go env GOHOSTARCH`
-[ -z "$GOCHAR" ] && GOCHAR=`go env GOCHAR`
 
 # If RUN_TESTS is set, behave like -t was passed in as an option.
 [ ! -z "$RUN_TESTS" ] && EXTRA_ARGS="$EXTRA_ARGS -t"
 
+# If BLUEPRINT_CACHE_DIR is set, share the compiled bootstrap packages and
+# binaries with other output directories through it.
+[ ! -z "$BLUEPRINT_CACHE_DIR" ] && EXTRA_ARGS="$EXTRA_ARGS -cache-dir $BLUEPRINT_CACHE_DIR"
+
+# If SKIP_DOCS is set, don't generate the build documentation.
+[ ! -z "$SKIP_DOCS" ] && EXTRA_ARGS="$EXTRA_ARGS -skip-docs"
+
+# If DOCS_FORMATS is set, generate the build documentation in those
+# comma-separated formats (html, md, json) instead of just HTML.
+[ ! -z "$DOCS_FORMATS" ] && EXTRA_ARGS="$EXTRA_ARGS -docs-formats $DOCS_FORMATS"
+
+# If DOCS_ANCHORS is set, redirect the anchors of the HTML build documentation
+# that it lists, usually a file in the source tree, when they are renamed.
+# The updated anchors are written next to the documentation, as
+# <docs>.anchors.json, to be copied over it.
+[ ! -z "$DOCS_ANCHORS" ] && EXTRA_ARGS="$EXTRA_ARGS -docs-anchors $DOCS_ANCHORS"
+
+# If TRACE_ACTIONS is set, write the span of each build action in the main
+# Ninja file to that directory.  Merge them into a Chrome trace with
+# $BUILDDIR/.bootstrap/bin/actiontrace -merge -o trace.json $TRACE_ACTIONS, or
+# with the one in $BUILDDIR/.bootstrap/debug/bin after a debug build.
+[ ! -z "$TRACE_ACTIONS" ] && EXTRA_ARGS="$EXTRA_ARGS -trace-actions $TRACE_ACTIONS"
+
 usage() {
     echo "Usage of ${BOOTSTRAP}:"
     echo "  -h: print a help message and exit"
     echo "  -r: regenerate ${BOOTSTRAP_MANIFEST}"
     echo "  -t: include tests when regenerating manifest"
+    echo "  -d: build unoptimized binaries for debugging when regenerating manifest"
 }
 
 # Parse the command line flags.
 IN="$BOOTSTRAP_MANIFEST"
 REGEN_BOOTSTRAP_MANIFEST=false
-while getopts ":hi:rt" opt; do
+while getopts ":dhi:rt" opt; do
     case $opt in
         h)
             usage
@@ -73,6 +103,7 @@ while getopts ":hi:rt" opt; do
         i) IN="$OPTARG";;
         r) REGEN_BOOTSTRAP_MANIFEST=true;;
         t) EXTRA_ARGS="$EXTRA_ARGS -t";;
+        d) EXTRA_ARGS="$EXTRA_ARGS -debug";;
         \?)
             echo "Invalid option: -$OPTARG" >&2
             usage
@@ -87,21 +118,37 @@ done
 
 if [ $REGEN_BOOTSTRAP_MANIFEST = true ]; then
     # This assumes that the script is being run from a build output directory
-    # that has been built in the past.
-    if [ -x .bootstrap/bin/minibp ]; then
+    # that has been built in the past.  A debug build keeps its binaries in a
+    # separate directory.
+    MINIBP=$BUILDDIR/.bootstrap/bin/minibp
+    [ ! -x $MINIBP ] && MINIBP=$BUILDDIR/.bootstrap/debug/bin/minibp
+    if [ -x $MINIBP ]; then
         echo "Regenerating $BOOTSTRAP_MANIFEST"
-        ./.bootstrap/bin/minibp $EXTRA_ARGS -o $BOOTSTRAP_MANIFEST $SRCDIR/$TOPNAME
+        $MINIBP -b $BUILDDIR $EXTRA_ARGS -o $BOOTSTRAP_MANIFEST $SRCDIR/$TOPNAME
     else
-        echo "Executable minibp not found at .bootstrap/bin/minibp" >&2
+        echo "Executable minibp not found in $BUILDDIR/.bootstrap/bin or $BUILDDIR/.bootstrap/debug/bin" >&2
         exit 1
     fi
 fi
 
+# Remove the files in $BUILDDIR/.bootstrap if they were laid out by a different
+# version of Blueprint than the one that wrote the bootstrap Ninja file, instead
+# of building on top of them.  The file that is copied into build.ninja when
+# moving to the main state doesn't contain a layout version.
+LAYOUT_VERSION=`sed -n 's/^g\.bootstrap\.layoutVersion = //p' "$IN"`
+if [ -n "$LAYOUT_VERSION" ] && [ -d $BUILDDIR/.bootstrap ] && \
+   [ "`cat $BUILDDIR/.bootstrap/layout_version 2>/dev/null`" != "$LAYOUT_VERSION" ]; then
+    echo "Removing $BUILDDIR/.bootstrap, which was laid out by a different version of Blueprint"
+    rm -rf $BUILDDIR/.bootstrap
+fi
+
+mkdir -p $BUILDDIR
+
 sed -e "s|@@SrcDir@@|$SRCDIR|g"                        \
+    -e "s|@@BuildDir@@|$BUILDDIR|g"                    \
     -e "s|@@GoRoot@@|$GOROOT|g"                        \
     -e "s|@@GoOS@@|$GOOS|g"                            \
     -e "s|@@GoArch@@|$GOARCH|g"                        \
-    -e "s|@@GoChar@@|$GOCHAR|g"                        \
     -e "s|@@Bootstrap@@|$BOOTSTRAP|g"                  \
     -e "s|@@BootstrapManifest@@|$BOOTSTRAP_MANIFEST|g" \
-    $IN > build.ninja
+    $IN > $BUILDDIR/build.ninja
@@ -0,0 +1,74 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint"
+)
+
+// actionTracer is a blueprint.CommandTransformer that runs every command
+// under actiontrace, which writes a span for each build action to dir.
+// Generator rules, which regenerate the Ninja files themselves, are left
+// alone.  The actiontrace binary in binDir is built by the bootstrap build
+// manifest before the main one runs.
+//
+// The command can't be quoted for actiontrace, since Ninja expands the
+// variables in it, shell-escaping the paths in $in and $out, after it is
+// written.  Instead it is left for the shell that Ninja runs to split into
+// the arguments of actiontrace, so commands that use more of the shell than
+// a single program with redirections are left alone too.
+type actionTracer struct {
+	dir    string
+	binDir string
+}
+
+func (t *actionTracer) TransformCommand(rule *blueprint.ManifestRule,
+	command string) string {
+
+	if rule.Variables["generator"] == "true" || !isSimpleCommand(command) {
+		return command
+	}
+
+	return fmt.Sprintf("%s -d %s -r %s $out -- %s",
+		filepath.Join(t.binDir, "actiontrace"), t.dir, rule.Name, command)
+}
+
+// isSimpleCommand returns true if command runs a single program, so that the
+// shell runs it the same way when it follows the arguments of actiontrace.
+func isSimpleCommand(command string) bool {
+	if strings.ContainsAny(command, ";&|()`\n") {
+		return false
+	}
+
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return false
+	}
+
+	switch fields[0] {
+	case "!", "{", "case", "for", "if", "until", "while":
+		return false
+	}
+
+	return true
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
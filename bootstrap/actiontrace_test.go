@@ -0,0 +1,85 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"testing"
+
+	"github.com/google/blueprint"
+)
+
+var transformCommandTestCases = []struct {
+	name      string
+	variables map[string]string
+	command   string
+}{
+	{
+		name:      "cc",
+		variables: map[string]string{"command": "gcc -c $in -o $out"},
+		command:   "out/.bootstrap/bin/actiontrace -d out/trace -r cc $out -- gcc -c $in -o $out",
+	},
+	{
+		name:      "quoted",
+		variables: map[string]string{"command": "echo 'a b' > $out"},
+		command:   "out/.bootstrap/bin/actiontrace -d out/trace -r quoted $out -- echo 'a b' > $out",
+	},
+	{
+		name:      "compound",
+		variables: map[string]string{"command": "cat $in > $out.tmp && mv $out.tmp $out"},
+		command:   "cat $in > $out.tmp && mv $out.tmp $out",
+	},
+	{
+		name:      "subshell",
+		variables: map[string]string{"command": "(cat $in) > $out"},
+		command:   "(cat $in) > $out",
+	},
+	{
+		name:      "keyword",
+		variables: map[string]string{"command": "! cmp -s $in $out"},
+		command:   "! cmp -s $in $out",
+	},
+	{
+		name: "generator",
+		variables: map[string]string{
+			"command":   "minibp -o $out $in",
+			"generator": "true",
+		},
+		command: "minibp -o $out $in",
+	},
+	{
+		name:      "empty",
+		variables: map[string]string{"command": ""},
+		command:   "",
+	},
+}
+
+func TestActionTracer(t *testing.T) {
+	tracer := &actionTracer{
+		dir:    "out/trace",
+		binDir: "out/.bootstrap/bin",
+	}
+
+	for _, testCase := range transformCommandTestCases {
+		rule := &blueprint.ManifestRule{
+			Name:      testCase.name,
+			Variables: testCase.variables,
+		}
+		command := tracer.TransformCommand(rule, testCase.variables["command"])
+		if command != testCase.command {
+			t.Errorf("%s: expected command %q, got %q", testCase.name, testCase.command,
+				command)
+		}
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
 
@@ -25,14 +26,59 @@ import (
 	"github.com/google/blueprint/pathtools"
 )
 
-const bootstrapDir = ".bootstrap"
+// bootstrapSubDir is the directory in the build directory that the bootstrap
+// Ninja file writes the bootstrap packages and binaries and its own files to.
+const bootstrapSubDir = ".bootstrap"
+
+// layoutVersion identifies the layout of the files in bootstrapDir.  The
+// bootstrap Ninja file writes it to layoutVersionFile, and the bootstrap
+// script removes bootstrapDir if the version in that file doesn't match the
+// one in the bootstrap Ninja file that it is given, so that the files left
+// behind by an older version of Blueprint can't break an incremental build.
+// It must be incremented whenever the layout changes incompatibly.
+const layoutVersion = "1"
+
+const (
+	// The compiler and linker find the packages that are imported in an
+	// importcfg file, which maps each import path to the archive file of the
+	// package.  It is written next to the output, and is made up of the
+	// importcfg of the standard library followed by the packageFiles, a list
+	// of path=file entries.
+	importcfgCommand = "(cat $stdImportcfg && for p in $packageFiles; " +
+		"do echo packagefile $$p; done) > $out.importcfg"
+
+	gcCommand = importcfgCommand + " && GOROOT='$goRoot' $gcCmd -o $out " +
+		"-p $pkgPath -complete $gcTrimPath $gcFlags -importcfg $out.importcfg -pack $in"
+
+	linkCommand = importcfgCommand + " && GOROOT='$goRoot' $linkCmd -o $out " +
+		"-importcfg $out.importcfg -buildid= $ldFlags $in"
+
+	// The compiler records the absolute paths of the source files in the
+	// packages it builds, which end up in the debug info and stack traces of
+	// the binaries.  Strip the absolute path of the source directory from
+	// them, and leave the build ID that the linker records empty, so that
+	// the binaries are identical no matter where the source is checked out.
+	gcTrimPathFlags = "-trimpath $$(cd $srcDir && pwd)"
+)
 
 var (
 	pctx = blueprint.NewPackageContext("github.com/google/blueprint/bootstrap")
 
-	gcCmd         = pctx.StaticVariable("gcCmd", "$goToolDir/${goChar}g")
-	linkCmd       = pctx.StaticVariable("linkCmd", "$goToolDir/${goChar}l")
-	goTestMainCmd = pctx.StaticVariable("goTestMainCmd", filepath.Join(bootstrapDir, "bin", "gotestmain"))
+	// All of the paths of the outputs are in the build directory, so that
+	// the source directory can be read-only.
+	bootstrapDir = filepath.Join("$buildDir", bootstrapSubDir)
+
+	// These are the tools run by "go tool compile" and "go tool link".  They
+	// are run directly so that they can be dependencies of the build
+	// statements that use them.
+	goCmd         = pctx.StaticVariable("goCmd", "$goRoot/bin/go")
+	gcCmd         = pctx.StaticVariable("gcCmd", "$goToolDir/compile")
+	linkCmd       = pctx.StaticVariable("linkCmd", "$goToolDir/link")
+	goTestMainCmd = pctx.StaticVariable("goTestMainCmd", filepath.Join("$binDir", "gotestmain"))
+	copyCmd       = pctx.StaticVariable("copyCmd", filepath.Join("$binDir", copyToolName))
+	coverCmd      = pctx.StaticVariable("coverCmd", "$goToolDir/cover")
+
+	gcTrimPath = pctx.StaticVariable("gcTrimPath", gcTrimPathFlags)
 
 	// Ninja only reinvokes itself once when it regenerates a .ninja file. For
 	// the re-bootstrap process we need that to happen more than once, so we
@@ -52,56 +98,180 @@ var (
 			if ninjaHasMultipass(config) {
 				return "", nil
 			} else {
-				return " && ninja", nil
+				return " && ninja -f $buildDir/build.ninja", nil
+			}
+		})
+
+	hashCmd = pctx.VariableFunc("hashCmd",
+		func(config interface{}) (string, error) {
+			if runtime.GOOS == "darwin" {
+				return "shasum", nil
+			} else {
+				return "sha1sum", nil
 			}
 		})
 
+	// The standard library isn't installed as archive files in GOROOT, so
+	// the go tool builds it into its cache and lists the archive files.  The
+	// listFlags select the build of it, e.g. with the race detector.  The
+	// archive files can be removed from the cache at any time, e.g. by "go
+	// clean -cache", so the list is rerun on every build to rebuild them.
+	// It only replaces the file when the list changes.
+	stdImportcfg = pctx.StaticVariable("stdImportcfg",
+		filepath.Join(bootstrapDir, "importcfg.std"))
+
+	stdImportcfgRule = pctx.StaticRule("stdImportcfg",
+		blueprint.RuleParams{
+			Command: "GOROOT='$goRoot' GOOS=$goOS GOARCH=$goArch $goCmd list$listFlags -export " +
+				"-f '{{if .Export}}packagefile {{.ImportPath}}={{.Export}}{{end}}' " +
+				"std > $out.tmp && (cmp -s $out.tmp $out && rm $out.tmp || mv $out.tmp $out)",
+			Description: "importcfg $out",
+			Restat:      true,
+		},
+		"listFlags")
+
 	gc = pctx.StaticRule("gc",
 		blueprint.RuleParams{
-			Command: "GOROOT='$goRoot' $gcCmd -o $out -p $pkgPath -complete " +
-				"$incFlags -pack $in",
-			Description: "${goChar}g $out",
+			Command:     gcCommand,
+			Description: "compile $out",
 		},
-		"pkgPath", "incFlags")
+		"pkgPath", "gcFlags", "packageFiles")
 
 	link = pctx.StaticRule("link",
 		blueprint.RuleParams{
-			Command:     "GOROOT='$goRoot' $linkCmd -o $out $libDirFlags $in",
-			Description: "${goChar}l $out",
+			Command:     linkCommand,
+			Description: "link $out",
+		},
+		"ldFlags", "packageFiles")
+
+	// The cached versions of the gc and link rules are used when a cache
+	// directory is configured.  They look for their output in the cache,
+	// keyed by a hash of the toolchain, the flags, and all of the files that
+	// the output is built from, and add it to the cache after building it.
+	gcCached = pctx.StaticRule("gcCached",
+		blueprint.RuleParams{
+			Command: cachedCommand(gcCommand,
+				"$goRoot $pkgPath $gcFlags $packageFiles"),
+			Description: "compile $out",
 		},
-		"libDirFlags")
+		"pkgPath", "gcFlags", "packageFiles", "cacheDir", "cacheInputs")
+
+	linkCached = pctx.StaticRule("linkCached",
+		blueprint.RuleParams{
+			Command: cachedCommand(linkCommand,
+				"$goRoot $ldFlags $packageFiles"),
+			Description: "link $out",
+		},
+		"ldFlags", "packageFiles", "cacheDir", "cacheInputs")
 
 	goTestMain = pctx.StaticRule("gotestmain",
 		blueprint.RuleParams{
-			Command:     "$goTestMainCmd -o $out -pkg $pkg $in",
+			Command:     "$goTestMainCmd -o $out -pkg $pkg$mainFlags $in",
 			Description: "gotestmain $out",
 		},
-		"pkg")
+		"pkg", "mainFlags")
+
+	// The cover rule instruments a source file for coverage, recording the
+	// counts in the package variable coverVar.
+	cover = pctx.StaticRule("cover",
+		blueprint.RuleParams{
+			Command:     "$coverCmd -mode $coverMode -var $coverVar -o $out $in",
+			Description: "cover $out",
+		},
+		"coverMode", "coverVar")
 
+	// The coverMerge rule concatenates coverage profiles, keeping only the
+	// first mode line.  The cover tool merges the counts of blocks that
+	// appear in more than one of them.
+	coverMerge = pctx.StaticRule("coverMerge",
+		blueprint.RuleParams{
+			Command: "(echo 'mode: $coverMode' && " +
+				"for f in $in; do tail -n +2 $$f; done) > $out",
+			Description: "merge coverage $out",
+		},
+		"coverMode")
+
+	// The test rules record a checksum of the test binary, its data files and
+	// the flags it is run with in the first line of their output instead of
+	// just touching it.  If the binary is relinked with the same contents,
+	// e.g. after a change to a comment or to a package it doesn't depend on,
+	// the tests are not run again and restat keeps Ninja from rebuilding
+	// anything that depends on the result.
 	test = pctx.StaticRule("test",
 		blueprint.RuleParams{
-			Command:     "(cd $pkgSrcDir && $$OLDPWD/$in -test.short) && touch $out",
+			Command: testHashCommand("$testFlags") + " && " +
+				"if [ \"$$(head -n 1 $out 2>/dev/null)\" != \"$$hash\" ]; then " +
+				"(mkdir -p $testDir && cd $testDir && " +
+				"$testEnv $$OLDPWD/$in -test.short$testFlags) && " +
+				"echo \"$$hash\" > $out || (${testDataNote}exit 1); fi",
 			Description: "test $pkg",
+			Restat:      true,
 		},
-		"pkg", "pkgSrcDir")
+		"pkg", "testDir", "testData", "testDataNote", "testEnv", "testFlags")
+
+	// The generated test main only runs every shardCount'th test, starting
+	// with the shardIndex'th.  The output of each shard is kept in its result
+	// file after the checksum, and is printed if the shard fails.
+	testShard = pctx.StaticRule("testShard",
+		blueprint.RuleParams{
+			Command: testHashCommand("$testFlags $shardIndex $shardCount") + " && " +
+				"if [ \"$$(head -n 1 $out 2>/dev/null)\" != \"$$hash\" ]; then " +
+				"(echo \"$$hash\" && mkdir -p $testDir && cd $testDir && " +
+				"TEST_TOTAL_SHARDS=$shardCount " +
+				"TEST_SHARD_INDEX=$shardIndex $testEnv $$OLDPWD/$in -test.short$testFlags " +
+				"-test.v) > $out.tmp 2>&1 && mv $out.tmp $out || " +
+				"(cat $out.tmp; rm -f $out.tmp; ${testDataNote}exit 1); fi",
+			Description: "test $pkg shard $shardIndex/$shardCount",
+			Restat:      true,
+		},
+		"pkg", "testDir", "testData", "testDataNote", "testEnv", "testFlags",
+		"shardIndex", "shardCount")
+
+	// The bootstrap script also reads the layoutVersion variable from the
+	// bootstrap Ninja file.
+	layoutVersionVar = pctx.StaticVariable("layoutVersion", layoutVersion)
+
+	writeLayoutVersion = pctx.StaticRule("writeLayoutVersion",
+		blueprint.RuleParams{
+			Command:     "echo $layoutVersion > $out",
+			Description: "layout version $out",
+		})
 
+	touch = pctx.StaticRule("touch",
+		blueprint.RuleParams{
+			Command:     "touch $out",
+			Description: "touch $out",
+		})
+
+	// cp copies files with bpcopy, which renames a complete copy into place
+	// so that an interrupted copy never leaves a truncated output that Ninja
+	// considers up to date.  The build statements that use it must have an
+	// implicit dependency on $copyCmd.
 	cp = pctx.StaticRule("cp",
 		blueprint.RuleParams{
-			Command:     "cp $in $out",
+			Command:     "$copyCmd $copyFlags $in $out",
 			Description: "cp $out",
 		},
-		"generator")
+		"generator", "copyFlags")
+
+	// cpTool copies bpcopy itself, which can't be used to copy its own
+	// binary.
+	cpTool = pctx.StaticRule("cpTool",
+		blueprint.RuleParams{
+			Command:     "cp $in $out.tmp && mv -f $out.tmp $out",
+			Description: "cp $out",
+		})
 
 	bootstrap = pctx.StaticRule("bootstrap",
 		blueprint.RuleParams{
-			Command:     "$bootstrapCmd -i $in",
+			Command:     "BUILDDIR=$buildDir $bootstrapCmd -i $in",
 			Description: "bootstrap $in",
 			Generator:   true,
 		})
 
 	rebootstrap = pctx.StaticRule("rebootstrap",
 		blueprint.RuleParams{
-			Command:     "$bootstrapCmd -i $in$runChildNinja",
+			Command:     "BUILDDIR=$buildDir $bootstrapCmd -i $in$runChildNinja",
 			Description: "re-bootstrap $in",
 			Generator:   true,
 		})
@@ -115,14 +285,46 @@ var (
 		},
 		"depfile")
 
-	BinDir     = filepath.Join(bootstrapDir, "bin")
-	minibpFile = filepath.Join(BinDir, "minibp")
+	// BinDir is the directory that the bootstrap binaries are copied to.  It
+	// is a Ninja string that refers to the $buildDir variable.  Debug builds
+	// copy them to debugBinDir instead, so that switching between debug and
+	// normal builds doesn't replace the binaries of the other.
+	BinDir      = filepath.Join(bootstrapDir, "bin")
+	debugBinDir = filepath.Join(bootstrapDir, "debug", "bin")
+
+	// binDir is the directory that the bootstrap binaries of this build are
+	// in, for the rules that run them.  It comes from the Config when that is
+	// the config the build actions are prepared with.  Main prepares them with
+	// the primary builder's config instead, so then it comes from the -debug
+	// flag, like the Config that Main creates.
+	binDir = pctx.VariableFunc("binDir", func(config interface{}) (string, error) {
+		c, ok := config.(*Config)
+		if !ok {
+			c = &Config{debugBuild: debugBuild}
+		}
+		return c.binDir(), nil
+	})
+
+	// copyToolName is the name of the bpcopy binary module, which the cp
+	// rule runs.
+	copyToolName = "bpcopy"
+
+	// The Ninja file written by a primary builder that isn't written in Go.
+	// Its dependency file has the same name with ".d" appended.
+	externalNinjaFile = filepath.Join(bootstrapDir, "external.ninja")
 
 	docsDir = filepath.Join(bootstrapDir, "docs")
+
+	layoutVersionFile = filepath.Join(bootstrapDir, "layout_version")
+
+	// The coverage profile of all of the go tests, when they are run with
+	// -test-cover.
+	coverProfileFile = filepath.Join(bootstrapDir, "coverage.coverprofile")
 )
 
 type goPackageProducer interface {
 	GoPkgRoot() string
+	GoPkgPath() string
 	GoPackageTarget() string
 }
 
@@ -140,6 +342,15 @@ func isGoTestProducer(module blueprint.Module) bool {
 	return ok
 }
 
+type goCoverProducer interface {
+	GoCoverProfile() string
+}
+
+func isGoCoverProducer(module blueprint.Module) bool {
+	_, ok := module.(goCoverProducer)
+	return ok
+}
+
 func isBootstrapModule(module blueprint.Module) bool {
 	_, isPackage := module.(*goPackage)
 	_, isBinary := module.(*goBinary)
@@ -168,8 +379,22 @@ type goPackage struct {
 		PkgPath  string
 		Srcs     []string
 		TestSrcs []string
+		Testdata []string
+
+		// Extra flags passed to the compiler when building the package
+		Gcflags []string
 	}
 
+	// vendorProperties are only used by bootstrap_go_vendored_package
+	// modules, which have vendored set.
+	vendorProperties struct {
+		// The directory containing the sources, relative to the module
+		// directory.  Defaults to the pkgPath, so that third-party packages
+		// can be checked in using the same layout as a GOPATH src directory.
+		SrcDir string
+	}
+	vendored bool
+
 	// The root dir in which the package .a file is located.  The full .a file
 	// path will be "packageRoot/PkgPath.a"
 	pkgRoot string
@@ -180,6 +405,10 @@ type goPackage struct {
 	// The path of the test .a file that is to be built.
 	testArchiveFile string
 
+	// The path of the coverage profile that the tests write, if they are
+	// run with -test-cover.
+	coverProfile string
+
 	// The bootstrap Config
 	config *Config
 }
@@ -195,10 +424,29 @@ func newGoPackageModuleFactory(config *Config) func() (blueprint.Module, []inter
 	}
 }
 
+// newGoVendoredPackageModuleFactory returns a factory for modules that build
+// third-party Go packages that are checked into the source tree.  They are
+// built like bootstrap_go_package modules, except that their sources are
+// listed relative to a srcDir property that defaults to their import path,
+// and their tests are never run.
+func newGoVendoredPackageModuleFactory(config *Config) func() (blueprint.Module, []interface{}) {
+	return func() (blueprint.Module, []interface{}) {
+		module := &goPackage{
+			config:   config,
+			vendored: true,
+		}
+		return module, []interface{}{&module.properties, &module.vendorProperties}
+	}
+}
+
 func (g *goPackage) GoPkgRoot() string {
 	return g.pkgRoot
 }
 
+func (g *goPackage) GoPkgPath() string {
+	return g.properties.PkgPath
+}
+
 func (g *goPackage) GoPackageTarget() string {
 	return g.archiveFile
 }
@@ -207,19 +455,47 @@ func (g *goPackage) GoTestTarget() string {
 	return g.testArchiveFile
 }
 
+func (g *goPackage) GoCoverProfile() string {
+	return g.coverProfile
+}
+
 func (g *goPackage) GenerateBuildActions(ctx blueprint.ModuleContext) {
 	name := ctx.ModuleName()
 
+	if g.properties.PkgPath == "" && g.config.goWorkspace != nil &&
+		len(g.properties.Srcs) > 0 {
+
+		// Find the import path from the Go module that contains the sources.
+		pkgDir := filepath.Join(ctx.ModuleDir(),
+			filepath.Dir(g.properties.Srcs[0]))
+		g.properties.PkgPath, _ = g.config.goWorkspace.pkgPath(pkgDir)
+	}
+
 	if g.properties.PkgPath == "" {
 		ctx.ModuleErrorf("module %s did not specify a valid pkgPath", name)
 		return
 	}
 
-	g.pkgRoot = packageRoot(ctx)
+	if g.vendored {
+		srcDir := g.vendorProperties.SrcDir
+		if srcDir == "" {
+			srcDir = filepath.FromSlash(g.properties.PkgPath)
+		}
+		g.properties.Srcs = pathtools.PrefixPaths(g.properties.Srcs, srcDir)
+		g.properties.TestSrcs = nil
+	}
+
+	g.properties.Srcs = filterGoSrcs(ctx, g.config, "srcs", g.properties.Srcs)
+	if g.config.runGoTests {
+		g.properties.TestSrcs = filterGoSrcs(ctx, g.config, "testSrcs",
+			g.properties.TestSrcs)
+	}
+
+	g.pkgRoot = packageRoot(ctx, g.config)
 	g.archiveFile = filepath.Join(g.pkgRoot,
 		filepath.FromSlash(g.properties.PkgPath)+".a")
 	if len(g.properties.TestSrcs) > 0 && g.config.runGoTests {
-		g.testArchiveFile = filepath.Join(testRoot(ctx),
+		g.testArchiveFile = filepath.Join(testRoot(ctx, g.config),
 			filepath.FromSlash(g.properties.PkgPath)+".a")
 	}
 
@@ -230,15 +506,19 @@ func (g *goPackage) GenerateBuildActions(ctx blueprint.ModuleContext) {
 	// be built.
 	if g.config.generatingBootstrapper {
 		var deps []string
+		gcFlags := g.config.gcFlags(g.properties.Gcflags)
 
 		if g.config.runGoTests {
-			deps = buildGoTest(ctx, testRoot(ctx), g.testArchiveFile,
+			deps, g.coverProfile = buildGoTest(ctx, g.config,
+				testRoot(ctx, g.config), g.testArchiveFile,
 				g.properties.PkgPath, g.properties.Srcs,
-				g.properties.TestSrcs)
+				g.properties.TestSrcs, g.properties.Testdata, gcFlags,
+				g.config.ldFlags(nil))
 		}
 
-		buildGoPackage(ctx, g.pkgRoot, g.properties.PkgPath, g.archiveFile,
-			g.properties.Srcs, deps)
+		buildGoPackage(ctx, g.config, g.pkgRoot, g.properties.PkgPath,
+			g.archiveFile, pathtools.PrefixPaths(g.properties.Srcs, moduleSrcDir(ctx)),
+			gcFlags, deps)
 	} else {
 		if len(g.properties.TestSrcs) > 0 && g.config.runGoTests {
 			phonyGoTarget(ctx, g.testArchiveFile, g.properties.TestSrcs, nil)
@@ -252,12 +532,22 @@ type goBinary struct {
 	properties struct {
 		Srcs           []string
 		TestSrcs       []string
+		Testdata       []string
 		PrimaryBuilder bool
+
+		// Extra flags passed to the compiler and linker when building the
+		// binary
+		Gcflags []string
+		Ldflags []string
 	}
 
 	// The path of the test .a file that is to be built.
 	testArchiveFile string
 
+	// The path of the coverage profile that the tests write, if they are
+	// run with -test-cover.
+	coverProfile string
+
 	// The bootstrap Config
 	config *Config
 }
@@ -275,17 +565,27 @@ func (g *goBinary) GoTestTarget() string {
 	return g.testArchiveFile
 }
 
+func (g *goBinary) GoCoverProfile() string {
+	return g.coverProfile
+}
+
 func (g *goBinary) GenerateBuildActions(ctx blueprint.ModuleContext) {
 	var (
 		name        = ctx.ModuleName()
-		objDir      = moduleObjDir(ctx)
+		objDir      = moduleObjDir(ctx, g.config)
 		archiveFile = filepath.Join(objDir, name+".a")
 		aoutFile    = filepath.Join(objDir, "a.out")
-		binaryFile  = filepath.Join(BinDir, name)
+		binaryFile  = filepath.Join(g.config.binDir(), name)
 	)
 
+	g.properties.Srcs = filterGoSrcs(ctx, g.config, "srcs", g.properties.Srcs)
+	if g.config.runGoTests {
+		g.properties.TestSrcs = filterGoSrcs(ctx, g.config, "testSrcs",
+			g.properties.TestSrcs)
+	}
+
 	if len(g.properties.TestSrcs) > 0 && g.config.runGoTests {
-		g.testArchiveFile = filepath.Join(testRoot(ctx), name+".a")
+		g.testArchiveFile = filepath.Join(testRoot(ctx, g.config), name+".a")
 	}
 
 	// We only actually want to build the builder modules if we're running as
@@ -295,40 +595,65 @@ func (g *goBinary) GenerateBuildActions(ctx blueprint.ModuleContext) {
 	// be built.
 	if g.config.generatingBootstrapper {
 		var deps []string
+		gcFlags := g.config.gcFlags(g.properties.Gcflags)
+
+		ldFlags := g.config.ldFlags(g.properties.Ldflags)
 
 		if g.config.runGoTests {
-			deps = buildGoTest(ctx, testRoot(ctx), g.testArchiveFile,
-				name, g.properties.Srcs, g.properties.TestSrcs)
+			deps, g.coverProfile = buildGoTest(ctx, g.config,
+				testRoot(ctx, g.config), g.testArchiveFile, name,
+				g.properties.Srcs, g.properties.TestSrcs,
+				g.properties.Testdata, gcFlags, ldFlags)
 		}
 
-		buildGoPackage(ctx, objDir, name, archiveFile, g.properties.Srcs, deps)
+		// The linker looks for the main function in the package called
+		// "main".
+		buildGoPackage(ctx, g.config, objDir, "main", archiveFile,
+			pathtools.PrefixPaths(g.properties.Srcs, moduleSrcDir(ctx)),
+			gcFlags, deps)
 
-		var libDirFlags []string
+		var packageFiles []string
+		linkDeps := []string{"$linkCmd", "$stdImportcfg"}
 		ctx.VisitDepsDepthFirstIf(isGoPackageProducer,
 			func(module blueprint.Module) {
 				dep := module.(goPackageProducer)
-				libDir := dep.GoPkgRoot()
-				libDirFlags = append(libDirFlags, "-L "+libDir)
+				packageFiles = append(packageFiles, packageFile(dep))
+				linkDeps = append(linkDeps, dep.GoPackageTarget())
 			})
 
 		linkArgs := map[string]string{}
-		if len(libDirFlags) > 0 {
-			linkArgs["libDirFlags"] = strings.Join(libDirFlags, " ")
+		if len(packageFiles) > 0 {
+			linkArgs["packageFiles"] = strings.Join(packageFiles, " ")
+		}
+		if len(ldFlags) > 0 {
+			linkArgs["ldFlags"] = strings.Join(ldFlags, " ")
 		}
 
 		ctx.Build(pctx, blueprint.BuildParams{
-			Rule:      link,
+			Rule:      cachedRule(g.config, link, linkCached, linkArgs, linkDeps),
 			Outputs:   []string{aoutFile},
 			Inputs:    []string{archiveFile},
-			Implicits: []string{"$linkCmd"},
+			Implicits: linkDeps,
 			Args:      linkArgs,
 		})
 
-		ctx.Build(pctx, blueprint.BuildParams{
-			Rule:    cp,
-			Outputs: []string{binaryFile},
-			Inputs:  []string{aoutFile},
-		})
+		if name == copyToolName {
+			ctx.Build(pctx, blueprint.BuildParams{
+				Rule:    cpTool,
+				Outputs: []string{binaryFile},
+				Inputs:  []string{aoutFile},
+			})
+		} else {
+			ctx.Build(pctx, blueprint.BuildParams{
+				Rule:      cp,
+				Outputs:   []string{binaryFile},
+				Inputs:    []string{aoutFile},
+				Implicits: []string{"$copyCmd"},
+				Args: map[string]string{
+					"copyFlags": "-m 0755",
+				},
+			})
+		}
 	} else {
 		if len(g.properties.TestSrcs) > 0 && g.config.runGoTests {
 			phonyGoTarget(ctx, g.testArchiveFile, g.properties.TestSrcs, nil)
@@ -339,33 +664,42 @@ func (g *goBinary) GenerateBuildActions(ctx blueprint.ModuleContext) {
 	}
 }
 
-func buildGoPackage(ctx blueprint.ModuleContext, pkgRoot string,
-	pkgPath string, archiveFile string, srcs []string, orderDeps []string) {
+// buildGoPackage compiles srcFiles, which are relative to the top of the source
+// tree, and the sources generated by the dependencies of the module into the
+// package archiveFile.
+func buildGoPackage(ctx blueprint.ModuleContext, config *Config,
+	pkgRoot string, pkgPath string, archiveFile string, srcFiles []string,
+	gcFlags []string, orderDeps []string) {
 
-	srcDir := moduleSrcDir(ctx)
-	srcFiles := pathtools.PrefixPaths(srcs, srcDir)
+	ctx.VisitDirectDepsIf(isGoGeneratedSrcsProducer,
+		func(module blueprint.Module) {
+			gen := module.(goGeneratedSrcsProducer)
+			srcFiles = append(srcFiles, gen.GeneratedGoSrcs()...)
+		})
 
-	var incFlags []string
-	deps := []string{"$gcCmd"}
+	var packageFiles []string
+	deps := []string{"$gcCmd", "$stdImportcfg"}
 	ctx.VisitDepsDepthFirstIf(isGoPackageProducer,
 		func(module blueprint.Module) {
 			dep := module.(goPackageProducer)
-			incDir := dep.GoPkgRoot()
-			target := dep.GoPackageTarget()
-			incFlags = append(incFlags, "-I "+incDir)
-			deps = append(deps, target)
+			packageFiles = append(packageFiles, packageFile(dep))
+			deps = append(deps, dep.GoPackageTarget())
 		})
 
 	gcArgs := map[string]string{
 		"pkgPath": pkgPath,
 	}
 
-	if len(incFlags) > 0 {
-		gcArgs["incFlags"] = strings.Join(incFlags, " ")
+	if len(packageFiles) > 0 {
+		gcArgs["packageFiles"] = strings.Join(packageFiles, " ")
+	}
+
+	if len(gcFlags) > 0 {
+		gcArgs["gcFlags"] = strings.Join(gcFlags, " ")
 	}
 
 	ctx.Build(pctx, blueprint.BuildParams{
-		Rule:      gc,
+		Rule:      cachedRule(config, gc, gcCached, gcArgs, deps),
 		Outputs:   []string{archiveFile},
 		Inputs:    srcFiles,
 		OrderOnly: orderDeps,
@@ -374,12 +708,16 @@ func buildGoPackage(ctx blueprint.ModuleContext, pkgRoot string,
 	})
 }
 
-func buildGoTest(ctx blueprint.ModuleContext, testRoot string,
+// buildGoTest builds and runs the tests of a package, and returns the files
+// that record that they passed and the coverage profile that they write, if
+// they are run with -test-cover.
+func buildGoTest(ctx blueprint.ModuleContext, config *Config, testRoot string,
 	testPkgArchive string, pkgPath string, srcs []string,
-	testSrcs []string) []string {
+	testSrcs []string, testData []string, gcFlags []string,
+	ldFlags []string) ([]string, string) {
 
 	if len(testSrcs) == 0 {
-		return nil
+		return nil, ""
 	}
 
 	srcDir := moduleSrcDir(ctx)
@@ -389,60 +727,293 @@ func buildGoTest(ctx blueprint.ModuleContext, testRoot string,
 	testArchive := filepath.Join(testRoot, "test.a")
 	testFile := filepath.Join(testRoot, "test")
 	testPassed := filepath.Join(testRoot, "test.passed")
+	testDir := filepath.Join(testRoot, "data")
 
-	buildGoPackage(ctx, testRoot, pkgPath, testPkgArchive,
-		append(srcs, testSrcs...), nil)
+	srcFiles := pathtools.PrefixPaths(srcs, srcDir)
+	mainArgs := map[string]string{
+		"pkg": pkgPath,
+	}
+
+	// The sources of the package are instrumented with a counter variable
+	// for each file, which the generated test main writes to the profile
+	// named by TEST_COVERPROFILE.
+	var coverProfile string
+	if config.testCover {
+		coverProfile = filepath.Join(testRoot, "test.coverprofile")
+
+		var coverVars []string
+		for i, src := range srcs {
+			coverFile := filepath.Join(testRoot, "cover", src)
+			coverVar := fmt.Sprintf("GoCover_%d", i)
+			ctx.Build(pctx, blueprint.BuildParams{
+				Rule:      cover,
+				Outputs:   []string{coverFile},
+				Inputs:    []string{srcFiles[i]},
+				Implicits: []string{"$coverCmd"},
+				Args: map[string]string{
+					"coverMode": config.testCoverMode(),
+					"coverVar":  coverVar,
+				},
+			})
+
+			srcFiles[i] = coverFile
+			coverVars = append(coverVars,
+				coverVar+"="+pkgPath+"/"+filepath.Base(src))
+		}
+
+		mainArgs["mainFlags"] += fmt.Sprintf(" -cover-mode %s -cover-vars %s",
+			config.testCoverMode(), strings.Join(coverVars, ","))
+	}
+
+	// Each benchmark is run for a single iteration, to check that it works
+	// without slowing down the tests.
+	if config.testBench {
+		mainArgs["mainFlags"] += " -bench"
+	}
+
+	buildGoPackage(ctx, config, testRoot, pkgPath, testPkgArchive,
+		append(srcFiles, testFiles...), gcFlags, nil)
 
 	ctx.Build(pctx, blueprint.BuildParams{
 		Rule:      goTestMain,
 		Outputs:   []string{mainFile},
 		Inputs:    testFiles,
 		Implicits: []string{"$goTestMainCmd"},
-		Args: map[string]string{
-			"pkg": pkgPath,
-		},
+		Args:      mainArgs,
 	})
 
-	libDirFlags := []string{"-L " + testRoot}
+	testPackageFile := pkgPath + "=" + testPkgArchive
+	packageFiles := []string{testPackageFile}
+	linkDeps := []string{"$linkCmd", "$stdImportcfg", testPkgArchive}
 	ctx.VisitDepsDepthFirstIf(isGoPackageProducer,
 		func(module blueprint.Module) {
 			dep := module.(goPackageProducer)
-			libDir := dep.GoPkgRoot()
-			libDirFlags = append(libDirFlags, "-L "+libDir)
+			packageFiles = append(packageFiles, packageFile(dep))
+			linkDeps = append(linkDeps, dep.GoPackageTarget())
 		})
 
+	gcArgs := map[string]string{
+		"pkgPath":      "main",
+		"packageFiles": testPackageFile,
+	}
+
+	if config.testRace {
+		gcArgs["gcFlags"] = "-race"
+	}
+
+	gcDeps := []string{"$gcCmd", "$stdImportcfg", testPkgArchive}
 	ctx.Build(pctx, blueprint.BuildParams{
-		Rule:      gc,
+		Rule:      cachedRule(config, gc, gcCached, gcArgs, gcDeps),
 		Outputs:   []string{testArchive},
 		Inputs:    []string{mainFile},
-		Implicits: []string{testPkgArchive},
-		Args: map[string]string{
-			"pkgPath":  "main",
-			"incFlags": "-I " + testRoot,
-		},
+		Implicits: gcDeps,
+		Args:      gcArgs,
 	})
 
+	linkArgs := map[string]string{
+		"packageFiles": strings.Join(packageFiles, " "),
+	}
+
+	if len(ldFlags) > 0 {
+		linkArgs["ldFlags"] = strings.Join(ldFlags, " ")
+	}
+
 	ctx.Build(pctx, blueprint.BuildParams{
-		Rule:      link,
+		Rule:      cachedRule(config, link, linkCached, linkArgs, linkDeps),
 		Outputs:   []string{testFile},
 		Inputs:    []string{testArchive},
-		Implicits: []string{"$linkCmd"},
-		Args: map[string]string{
-			"libDirFlags": strings.Join(libDirFlags, " "),
-		},
+		Implicits: linkDeps,
+		Args:      linkArgs,
 	})
 
+	// The tests are run in their own directory, which only contains the data
+	// files they declare at the same paths relative to the test sources, so
+	// that they can't depend on files in the source tree that Ninja doesn't
+	// know about.
+	testSrcDir := filepath.Dir(testSrcs[0])
+	var testDataFiles, testDataRels []string
+	for _, data := range testData {
+		rel, err := filepath.Rel(testSrcDir, data)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			ctx.PropertyErrorf("testdata",
+				"%q is not in the test directory %q", data, testSrcDir)
+			continue
+		}
+
+		dataFile := filepath.Join(testDir, rel)
+		ctx.Build(pctx, blueprint.BuildParams{
+			Rule:      cp,
+			Outputs:   []string{dataFile},
+			Inputs:    []string{filepath.Join(srcDir, data)},
+			Implicits: []string{"$copyCmd"},
+		})
+
+		testDataFiles = append(testDataFiles, dataFile)
+		testDataRels = append(testDataRels, rel)
+	}
+
+	testArgs := map[string]string{
+		"pkg":     pkgPath,
+		"testDir": testDir,
+	}
+
+	if len(testDataFiles) > 0 {
+		testArgs["testData"] = strings.Join(testDataFiles, " ")
+	}
+
+	// Name any files that the tests may need but that aren't in testdata if
+	// the tests fail.
+	moduleDir := filepath.Join(filepath.Dir(config.topLevelBlueprintsFile),
+		ctx.ModuleDir())
+	undeclared := undeclaredTestData(filepath.Join(moduleDir, testSrcDir),
+		pathtools.PrefixPaths(testSrcs, moduleDir), testDataRels)
+	if note := testDataNote(testDir, undeclared); note != "" {
+		testArgs["testDataNote"] = note
+	}
+
+	if config.testParallel > 0 {
+		testArgs["testFlags"] = fmt.Sprintf(" -test.parallel %d",
+			config.testParallel)
+	}
+
+	if config.testBench {
+		testArgs["testFlags"] += " -test.bench . -test.benchtime 1x"
+	}
+
+	if config.testShards <= 1 {
+		if coverProfile != "" {
+			testArgs["testEnv"] = coverProfileEnv(coverProfile)
+		}
+
+		ctx.Build(pctx, blueprint.BuildParams{
+			Rule:      test,
+			Outputs:   []string{testPassed},
+			Inputs:    []string{testFile},
+			Implicits: testDataFiles,
+			Args:      testArgs,
+		})
+
+		if coverProfile != "" {
+			ctx.Build(pctx, blueprint.BuildParams{
+				Rule:    blueprint.Phony,
+				Outputs: []string{coverProfile},
+				Inputs:  []string{testPassed},
+			})
+		}
+
+		return []string{testPassed}, coverProfile
+	}
+
+	// Run each shard in its own build statement so that Ninja can schedule
+	// them in parallel, then mark the package as passed once they all have.
+	// The shards write their own coverage profiles, which are merged into
+	// the profile of the package.
+	var shardResults, shardProfiles []string
+	for i := 0; i < config.testShards; i++ {
+		shardResult := filepath.Join(testRoot,
+			fmt.Sprintf("test.shard%d.passed", i))
+
+		shardArgs := map[string]string{
+			"shardIndex": strconv.Itoa(i),
+			"shardCount": strconv.Itoa(config.testShards),
+		}
+		for k, v := range testArgs {
+			shardArgs[k] = v
+		}
+
+		if coverProfile != "" {
+			shardProfile := filepath.Join(testRoot,
+				fmt.Sprintf("test.shard%d.coverprofile", i))
+			shardArgs["testEnv"] = coverProfileEnv(shardProfile)
+
+			ctx.Build(pctx, blueprint.BuildParams{
+				Rule:    blueprint.Phony,
+				Outputs: []string{shardProfile},
+				Inputs:  []string{shardResult},
+			})
+
+			shardProfiles = append(shardProfiles, shardProfile)
+		}
+
+		ctx.Build(pctx, blueprint.BuildParams{
+			Rule:      testShard,
+			Outputs:   []string{shardResult},
+			Inputs:    []string{testFile},
+			Implicits: testDataFiles,
+			Args:      shardArgs,
+		})
+
+		shardResults = append(shardResults, shardResult)
+	}
+
 	ctx.Build(pctx, blueprint.BuildParams{
-		Rule:    test,
-		Outputs: []string{testPassed},
-		Inputs:  []string{testFile},
-		Args: map[string]string{
-			"pkg":       pkgPath,
-			"pkgSrcDir": filepath.Dir(testFiles[0]),
-		},
+		Rule:      touch,
+		Outputs:   []string{testPassed},
+		Implicits: shardResults,
 	})
 
-	return []string{testPassed}
+	if coverProfile != "" {
+		ctx.Build(pctx, blueprint.BuildParams{
+			Rule:    coverMerge,
+			Outputs: []string{coverProfile},
+			Inputs:  shardProfiles,
+			Args: map[string]string{
+				"coverMode": config.testCoverMode(),
+			},
+		})
+	}
+
+	return []string{testPassed}, coverProfile
+}
+
+// testHashCommand returns a command that sets the shell variable hash to a
+// checksum of the test binary, the data files and flags.  The files are
+// checksummed one at a time, along with their names and sizes, so that
+// renaming a data file or moving data from one to another changes the hash.
+func testHashCommand(flags string) string {
+	return "hash=$$( (cksum $in $testData; echo '" + flags + "') | cksum)"
+}
+
+// coverProfileEnv returns the testEnv argument of the test rules that makes
+// the test write its coverage profile to file.  The tests are run in their
+// data directory, so the path is made absolute.
+func coverProfileEnv(file string) string {
+	return "TEST_COVERPROFILE=$$OLDPWD/" + file
+}
+
+// packageFile returns the importcfg entry that maps the import path of dep
+// to its archive file.
+func packageFile(dep goPackageProducer) string {
+	return dep.GoPkgPath() + "=" + dep.GoPackageTarget()
+}
+
+// cachedRule returns cached, after adding the arguments that it needs to args,
+// if a cache directory is configured, or rule otherwise.  cacheInputs lists
+// the files other than the inputs of the build statement that its output
+// depends on, including the tool that builds it.
+func cachedRule(config *Config, rule, cached blueprint.Rule,
+	args map[string]string, cacheInputs []string) blueprint.Rule {
+
+	if config.cacheDir == "" {
+		return rule
+	}
+
+	args["cacheDir"] = config.cacheDir
+	args["cacheInputs"] = strings.Join(cacheInputs, " ")
+
+	return cached
+}
+
+// cachedCommand returns a command that copies its output from the cache
+// directory if it is there, and otherwise runs command and adds the output to
+// the cache.  The cache key is a hash of the inputs, the cacheInputs, and
+// hashArgs, which should contain every argument that affects the output.
+func cachedCommand(command, hashArgs string) string {
+	return "key=$$( (cat $in $cacheInputs; echo '" + hashArgs + "') | " +
+		"$hashCmd | cut -d ' ' -f 1) && " +
+		"if [ -f $cacheDir/$$key ]; then cp $cacheDir/$$key $out; else " +
+		command + " && mkdir -p $cacheDir && " +
+		"cp $out $cacheDir/$$key.$$$$ && mv $cacheDir/$$key.$$$$ $cacheDir/$$key; fi"
 }
 
 func phonyGoTarget(ctx blueprint.ModuleContext, target string, srcs []string,
@@ -513,23 +1084,39 @@ func (s *singleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
 		func(module blueprint.Module) {
 			binaryModule := module.(*goBinary)
 			binaryModuleName := ctx.ModuleName(binaryModule)
-			binaryModulePath := filepath.Join(BinDir, binaryModuleName)
+			binaryModulePath := filepath.Join(s.config.binDir(), binaryModuleName)
 			rebootstrapDeps = append(rebootstrapDeps, binaryModulePath)
 			if binaryModule.properties.PrimaryBuilder {
 				primaryBuilders = append(primaryBuilders, binaryModule)
 			}
 		})
 
+	var externalPrimaryBuilders []*externalPrimaryBuilder
+	ctx.VisitAllModulesIf(isExternalPrimaryBuilder,
+		func(module blueprint.Module) {
+			externalPrimaryBuilders = append(externalPrimaryBuilders,
+				module.(*externalPrimaryBuilder))
+		})
+
+	var external *externalPrimaryBuilder
 	var primaryBuilderName, primaryBuilderExtraFlags string
-	switch len(primaryBuilders) {
-	case 0:
+	switch {
+	case len(primaryBuilders) == 0 && len(externalPrimaryBuilders) == 1:
+		// The primary builder isn't written in Go, so minibp runs it and
+		// includes the Ninja file that it writes into the one that it
+		// generates.
+		external = externalPrimaryBuilders[0]
+		primaryBuilderName = "minibp"
+		primaryBuilderExtraFlags = "-p -external-ninja " + externalNinjaFile
+
+	case len(primaryBuilders) == 0 && len(externalPrimaryBuilders) == 0:
 		// If there's no primary builder module then that means we'll use minibp
 		// as the primary builder.  We can trigger its primary builder mode with
 		// the -p flag.
 		primaryBuilderName = "minibp"
 		primaryBuilderExtraFlags = "-p"
 
-	case 1:
+	case len(primaryBuilders) == 1 && len(externalPrimaryBuilders) == 0:
 		primaryBuilderName = ctx.ModuleName(primaryBuilders[0])
 
 	default:
@@ -538,13 +1125,17 @@ func (s *singleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
 			ctx.ModuleErrorf(primaryBuilder, "<-- module %s",
 				ctx.ModuleName(primaryBuilder))
 		}
+		for _, primaryBuilder := range externalPrimaryBuilders {
+			ctx.ModuleErrorf(primaryBuilder, "<-- module %s",
+				ctx.ModuleName(primaryBuilder))
+		}
 		return
 	}
 
-	primaryBuilderFile := filepath.Join(BinDir, primaryBuilderName)
+	primaryBuilderFile := filepath.Join(s.config.binDir(), primaryBuilderName)
 
-	if s.config.runGoTests {
-		primaryBuilderExtraFlags += " -t"
+	if flags := s.config.bootstrapFlags(); flags != "" {
+		primaryBuilderExtraFlags += " " + flags
 	}
 
 	// Get the filename of the top-level Blueprints file to pass to minibp.
@@ -552,12 +1143,12 @@ func (s *singleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
 	topLevelBlueprints := filepath.Join("$srcDir",
 		filepath.Base(s.config.topLevelBlueprintsFile))
 
+	buildNinjaFile := filepath.Join("$buildDir", "build.ninja")
 	mainNinjaFile := filepath.Join(bootstrapDir, "main.ninja.in")
 	mainNinjaDepFile := mainNinjaFile + ".d"
 	bootstrapNinjaFile := filepath.Join(bootstrapDir, "bootstrap.ninja.in")
-	docsFile := filepath.Join(docsDir, primaryBuilderName+".html")
-
-	rebootstrapDeps = append(rebootstrapDeps, docsFile)
+	docsFiles := s.config.docsFiles(primaryBuilderName)
+	docsStampFile := filepath.Join(docsDir, primaryBuilderName+".stamp")
 
 	if s.config.generatingBootstrapper {
 		// We're generating a bootstrapper Ninja file, so we need to set things
@@ -570,24 +1161,90 @@ func (s *singleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
 		// two Ninja processes try to write to the same log concurrently.
 		ctx.SetBuildDir(pctx, bootstrapDir)
 
-		// Generate build system docs for the primary builder.  Generating docs reads the source
-		// files used to build the primary builder, but that dependency will be picked up through
-		// the dependency on the primary builder itself.  There are no dependencies on the
-		// Blueprints files, as any relevant changes to the Blueprints files would have caused
-		// a rebuild of the primary builder.
-		bigbpDocs := ctx.Rule(pctx, "bigbpDocs",
-			blueprint.RuleParams{
-				Command: fmt.Sprintf("%s %s --docs $out %s", primaryBuilderFile,
-					primaryBuilderExtraFlags, topLevelBlueprints),
-				Description: fmt.Sprintf("%s docs $out", primaryBuilderName),
+		// The packages built with the race detector must be compiled and
+		// linked against a build of the standard library that uses it too.
+		importcfgArgs := map[string]string{}
+		if s.config.testRace {
+			importcfgArgs["listFlags"] = " -race"
+		}
+
+		stdImportcfgAlways := filepath.Join(bootstrapDir, "importcfg.std.always")
+		ctx.Build(pctx, blueprint.BuildParams{
+			Rule:    blueprint.Phony,
+			Outputs: []string{stdImportcfgAlways},
+		})
+
+		ctx.Build(pctx, blueprint.BuildParams{
+			Rule:      stdImportcfgRule,
+			Outputs:   []string{"$stdImportcfg"},
+			Implicits: []string{"$gcCmd", stdImportcfgAlways},
+			Args:      importcfgArgs,
+		})
+
+		if s.config.testCover {
+			var coverProfiles []string
+			ctx.VisitAllModulesIf(isGoCoverProducer,
+				func(module blueprint.Module) {
+					profile := module.(goCoverProducer).GoCoverProfile()
+					if profile != "" {
+						coverProfiles = append(coverProfiles, profile)
+					}
+				})
+
+			ctx.Build(pctx, blueprint.BuildParams{
+				Rule:    coverMerge,
+				Outputs: []string{coverProfileFile},
+				Inputs:  coverProfiles,
+				Args: map[string]string{
+					"coverMode": s.config.testCoverMode(),
+				},
 			})
+		}
 
 		ctx.Build(pctx, blueprint.BuildParams{
-			Rule:      bigbpDocs,
-			Outputs:   []string{docsFile},
-			Implicits: []string{primaryBuilderFile},
+			Rule:    writeLayoutVersion,
+			Outputs: []string{layoutVersionFile},
 		})
 
+		bootstrapDeps := []string{layoutVersionFile}
+
+		// Generate build system docs for the primary builder.  Generating docs
+		// reads the module types registered by the primary builder and the
+		// source files used to build it.  Rather than depending on the primary
+		// builder itself, which changes whenever any of its code does, the docs
+		// depend on a stamp file containing a hash of those inputs that the
+		// primary builder updates when it generates the main Ninja file.
+		if !s.config.skipDocs {
+			bigbpDocs := ctx.Rule(pctx, "bigbpDocs",
+				blueprint.RuleParams{
+					Command: fmt.Sprintf("%s %s --docs $docsFile %s", primaryBuilderFile,
+						primaryBuilderExtraFlags, topLevelBlueprints),
+					Description: fmt.Sprintf("%s docs $docsFile", primaryBuilderName),
+				},
+				"docsFile")
+
+			// The anchors of the previous HTML docs are an input, so that
+			// the docs are regenerated when they change, and the updated
+			// anchors are an output.
+			docsImplicits := []string{docsStampFile}
+			if s.config.docsAnchorsFile != "" {
+				docsImplicits = append(docsImplicits, s.config.docsAnchorsFile)
+			}
+
+			for _, docsFile := range docsFiles {
+				ctx.Build(pctx, blueprint.BuildParams{
+					Rule:      bigbpDocs,
+					Outputs:   docsOutputs(docsFile),
+					Implicits: docsImplicits,
+					Args: map[string]string{
+						"docsFile": docsFile,
+					},
+				})
+			}
+
+			bootstrapDeps = append(bootstrapDeps, docsFiles...)
+		}
+
 		// We generate the depfile here that includes the dependencies for all
 		// the Blueprints files that contribute to generating the big build
 		// manifest (build.ninja file).  This depfile will be used by the non-
@@ -595,20 +1252,39 @@ func (s *singleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
 		// bootstrap.  Because the re-bootstrap rule's output is "build.ninja"
 		// we need to force the depfile to have that as its "make target"
 		// (recall that depfiles use a subset of the Makefile syntax).
+		bigbpFlags := primaryBuilderExtraFlags
+		bigbpOutputs := []string{mainNinjaFile}
+		bigbpDeps := rebootstrapDeps
+
+		if !s.config.skipDocs {
+			bigbpFlags += " -docs-stamp " + docsStampFile
+			bigbpOutputs = append(bigbpOutputs, docsStampFile)
+		}
+
+		bigbpCommand := fmt.Sprintf("%s -b $buildDir %s -d %s -m $bootstrapManifest "+
+			"-o %s $in", primaryBuilderFile, bigbpFlags, mainNinjaDepFile,
+			mainNinjaFile)
+
+		if external != nil {
+			bigbpCommand = fmt.Sprintf("%s -o %s -d %s.d $in && %s", external.cmd,
+				externalNinjaFile, externalNinjaFile, bigbpCommand)
+			bigbpDeps = append(bigbpDeps, external.cmd)
+			bigbpDeps = append(bigbpDeps, external.srcs...)
+		}
+
 		bigbp := ctx.Rule(pctx, "bigbp",
 			blueprint.RuleParams{
-				Command: fmt.Sprintf("%s %s -d %s -m $bootstrapManifest "+
-					"-o $out $in", primaryBuilderFile,
-					primaryBuilderExtraFlags, mainNinjaDepFile),
-				Description: fmt.Sprintf("%s $out", primaryBuilderName),
+				Command:     bigbpCommand,
+				Description: primaryBuilderName + " " + mainNinjaFile,
 				Depfile:     mainNinjaDepFile,
+				Restat:      true,
 			})
 
 		ctx.Build(pctx, blueprint.BuildParams{
 			Rule:      bigbp,
-			Outputs:   []string{mainNinjaFile},
+			Outputs:   bigbpOutputs,
 			Inputs:    []string{topLevelBlueprints},
-			Implicits: rebootstrapDeps,
+			Implicits: bigbpDeps,
 		})
 
 		// When the current build.ninja file is a bootstrapper, we always want
@@ -624,11 +1300,14 @@ func (s *singleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
 			Outputs: []string{notAFile},
 		})
 
+		bootstrapDeps = append(bootstrapDeps, "$bootstrapCmd", notAFile,
+			bootstrapNinjaFile)
+
 		ctx.Build(pctx, blueprint.BuildParams{
 			Rule:      bootstrap,
-			Outputs:   []string{"build.ninja"},
+			Outputs:   []string{buildNinjaFile},
 			Inputs:    []string{mainNinjaFile},
-			Implicits: []string{"$bootstrapCmd", notAFile, bootstrapNinjaFile},
+			Implicits: bootstrapDeps,
 		})
 
 		// Rebuild the bootstrap Ninja file using the minibp that we just built.
@@ -637,22 +1316,23 @@ func (s *singleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
 		// file's mtime to match that of the current one.  If they're different
 		// then the new file will have a newer timestamp than the current one
 		// and it will trigger a reboostrap by the non-boostrap build manifest.
+		minibpFile := filepath.Join(s.config.binDir(), "minibp")
 		minibp := ctx.Rule(pctx, "minibp",
 			blueprint.RuleParams{
-				Command: fmt.Sprintf("%s $runTests -c $checkFile -m $bootstrapManifest "+
+				Command: fmt.Sprintf("%s -b $buildDir $bootstrapFlags -c $checkFile -m $bootstrapManifest "+
 					"-d $out.d -o $out $in", minibpFile),
 				Description: "minibp $out",
 				Generator:   true,
 				Depfile:     "$out.d",
 			},
-			"checkFile", "runTests")
+			"checkFile", "bootstrapFlags")
 
 		args := map[string]string{
 			"checkFile": "$bootstrapManifest",
 		}
 
-		if s.config.runGoTests {
-			args["runTests"] = "-t"
+		if flags := s.config.bootstrapFlags(); flags != "" {
+			args["bootstrapFlags"] = flags
 		}
 
 		ctx.Build(pctx, blueprint.BuildParams{
@@ -663,6 +1343,14 @@ func (s *singleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
 			Args:      args,
 		})
 	} else {
+		// The Ninja log of the main build is kept in the build directory too,
+		// so that nothing is written to the directory Ninja is run in.
+		ctx.SetBuildDir(pctx, "$buildDir")
+
+		if s.config.subninjaDir != "" {
+			ctx.SetSubninjaDir(s.config.subninjaDir)
+		}
+
 		ctx.VisitAllModulesIf(isGoTestProducer,
 			func(module blueprint.Module) {
 				testModule := module.(goTestProducer)
@@ -685,10 +1373,21 @@ func (s *singleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
 		// phony rule to generate it that uses the depfile.
 		buildNinjaDeps := []string{"$bootstrapCmd", mainNinjaFile}
 		buildNinjaDeps = append(buildNinjaDeps, rebootstrapDeps...)
+		if !s.config.skipDocs {
+			buildNinjaDeps = append(buildNinjaDeps, docsFiles...)
+		}
+		if external != nil {
+			buildNinjaDeps = append(buildNinjaDeps, external.cmd)
+			buildNinjaDeps = append(buildNinjaDeps, external.srcs...)
+		}
+
+		// Recheck the globs performed by modules, the primary builder lists
+		// the files written by the glob rules in mainNinjaDepFile.
+		generateGlobBuildActions(ctx, ctx.Globs())
 
 		ctx.Build(pctx, blueprint.BuildParams{
 			Rule:      rebootstrap,
-			Outputs:   []string{"build.ninja"},
+			Outputs:   []string{buildNinjaFile},
 			Inputs:    []string{"$bootstrapManifest"},
 			Implicits: buildNinjaDeps,
 		})
@@ -702,11 +1401,18 @@ func (s *singleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
 			},
 		})
 
-		ctx.Build(pctx, blueprint.BuildParams{
-			Rule:      phony,
-			Outputs:   []string{docsFile},
-			Implicits: []string{primaryBuilderFile},
-		})
+		if !s.config.skipDocs {
+			var docsFilesOutputs []string
+			for _, docsFile := range docsFiles {
+				docsFilesOutputs = append(docsFilesOutputs, docsOutputs(docsFile)...)
+			}
+
+			ctx.Build(pctx, blueprint.BuildParams{
+				Rule:      phony,
+				Outputs:   docsFilesOutputs,
+				Implicits: []string{primaryBuilderFile},
+			})
+		}
 
 		// If the bootstrap Ninja invocation caused a new bootstrapNinjaFile to be
 		// generated then that means we need to rebootstrap using it instead of
@@ -714,9 +1420,10 @@ func (s *singleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
 		// behavior so that Ninja doesn't invoke this build just because it's
 		// missing a command line log entry for the bootstrap manifest.
 		ctx.Build(pctx, blueprint.BuildParams{
-			Rule:    cp,
-			Outputs: []string{"$bootstrapManifest"},
-			Inputs:  []string{bootstrapNinjaFile},
+			Rule:      cp,
+			Outputs:   []string{"$bootstrapManifest"},
+			Inputs:    []string{bootstrapNinjaFile},
+			Implicits: []string{"$copyCmd"},
 			Args: map[string]string{
 				"generator": "true",
 			},
@@ -725,11 +1432,15 @@ func (s *singleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
 		if primaryBuilderName == "minibp" {
 			// This is a standalone Blueprint build, so we copy the minibp
 			// binary to the "bin" directory to make it easier to find.
-			finalMinibp := filepath.Join("bin", primaryBuilderName)
+			finalMinibp := filepath.Join("$buildDir", "bin", primaryBuilderName)
 			ctx.Build(pctx, blueprint.BuildParams{
-				Rule:    cp,
-				Inputs:  []string{primaryBuilderFile},
-				Outputs: []string{finalMinibp},
+				Rule:      cp,
+				Inputs:    []string{primaryBuilderFile},
+				Outputs:   []string{finalMinibp},
+				Implicits: []string{"$copyCmd"},
+				Args: map[string]string{
+					"copyFlags": "-m 0755",
+				},
 			})
 		}
 	}
@@ -738,15 +1449,15 @@ func (s *singleton) GenerateBuildActions(ctx blueprint.SingletonContext) {
 // packageRoot returns the module-specific package root directory path.  This
 // directory is where the final package .a files are output and where dependant
 // modules search for this package via -I arguments.
-func packageRoot(ctx blueprint.ModuleContext) string {
-	return filepath.Join(bootstrapDir, ctx.ModuleName(), "pkg")
+func packageRoot(ctx blueprint.ModuleContext, config *Config) string {
+	return filepath.Join(intermediatesDir(ctx, config), "pkg")
 }
 
 // testRoot returns the module-specific package root directory path used for
 // building tests. The .a files generated here will include everything from
 // packageRoot, plus the test-only code.
-func testRoot(ctx blueprint.ModuleContext) string {
-	return filepath.Join(bootstrapDir, ctx.ModuleName(), "test")
+func testRoot(ctx blueprint.ModuleContext, config *Config) string {
+	return filepath.Join(intermediatesDir(ctx, config), "test")
 }
 
 // moduleSrcDir returns the path of the directory that all source file paths are
@@ -756,6 +1467,22 @@ func moduleSrcDir(ctx blueprint.ModuleContext) string {
 }
 
 // moduleObjDir returns the module-specific object directory path.
-func moduleObjDir(ctx blueprint.ModuleContext) string {
-	return filepath.Join(bootstrapDir, ctx.ModuleName(), "obj")
+func moduleObjDir(ctx blueprint.ModuleContext, config *Config) string {
+	return filepath.Join(intermediatesDir(ctx, config), "obj")
+}
+
+// intermediatesDir returns the module-specific directory that contains the
+// other output directories.  Debug builds use a different directory so that
+// switching between debug and normal builds doesn't rebuild everything.  It is
+// laid out in bootstrapDir like ctx.IntermediatesDir is in the build directory.
+func intermediatesDir(ctx blueprint.ModuleContext, config *Config) string {
+	dir, err := filepath.Rel(config.buildDir, ctx.IntermediatesDir())
+	if err != nil {
+		panic(err)
+	}
+
+	if config.debugBuild {
+		return filepath.Join(bootstrapDir, "debug", dir)
+	}
+	return filepath.Join(bootstrapDir, dir)
 }
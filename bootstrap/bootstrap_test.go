@@ -0,0 +1,408 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/blueprint"
+)
+
+const buildDirTestBlueprints = `
+bootstrap_go_package {
+    name: "lib",
+    pkgPath: "example/lib",
+    srcs: ["lib/lib.go"],
+    testSrcs: ["lib/lib_test.go"],
+}
+
+bootstrap_go_binary {
+    name: "builder",
+    deps: ["lib"],
+    srcs: ["builder/main.go"],
+    primaryBuilder: true,
+}
+`
+
+// writeTestSources writes files, which map paths relative to dir to their
+// contents, to dir.
+func writeTestSources(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(contents), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// setUpBuildDirTest writes the sources of buildDirTestBlueprints to a new
+// temporary directory, which the caller must remove.
+func setUpBuildDirTest(t *testing.T) string {
+	srcDir, err := ioutil.TempDir("", "bootstrap_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestSources(t, srcDir, map[string]string{
+		"Blueprints":      buildDirTestBlueprints,
+		"lib/lib.go":      "package lib\n",
+		"lib/lib_test.go": "package lib\n",
+		"builder/main.go": "package main\n\nfunc main() {}\n",
+	})
+
+	return srcDir
+}
+
+// runBuildDirTest generates the build actions for the Blueprints file in
+// srcDir with config, and returns the rule of each output with the
+// placeholders for the build and source directories replaced.
+func runBuildDirTest(t *testing.T, srcDir string, config *Config) (*blueprint.Context, map[string]string) {
+	config.topLevelBlueprintsFile = filepath.Join(srcDir, "Blueprints")
+	config.runGoTests = true
+	config.testShards = 1
+	config.docsFormats = []string{defaultDocsFormats}
+
+	ctx := blueprint.NewContext()
+	registerModuleTypes(ctx, config)
+	ctx.SetOutDir(config.buildDir)
+
+	_, errs := ctx.ParseBlueprintsFiles(config.topLevelBlueprintsFile)
+	if len(errs) == 0 {
+		errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(config)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	targets, err := ctx.AllTargets()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replacer := strings.NewReplacer("@@BuildDir@@", config.buildDir,
+		"@@SrcDir@@", srcDir, "@@BootstrapManifest@@", "$bootstrapManifest")
+	outputs := make(map[string]string)
+	for target, rule := range targets {
+		outputs[filepath.Clean(replacer.Replace(target))] = rule
+	}
+
+	return ctx, outputs
+}
+
+func TestBuildDirOutputs(t *testing.T) {
+	srcDir := setUpBuildDirTest(t)
+	defer os.RemoveAll(srcDir)
+
+	const buildDir = "out/build"
+
+	for _, generatingBootstrapper := range []bool{true, false} {
+		ctx, outputs := runBuildDirTest(t, srcDir, &Config{
+			generatingBootstrapper: generatingBootstrapper,
+			buildDir:               buildDir,
+		})
+
+		// The bootstrap manifest in the source tree is only rewritten when the
+		// checked in one is out of date, and the built-in phony rule doesn't
+		// write its outputs.
+		for output, rule := range outputs {
+			if output == "$bootstrapManifest" || rule == "phony" {
+				continue
+			}
+			if !strings.HasPrefix(output, buildDir+"/") {
+				t.Errorf("generatingBootstrapper %v: output %q is not in %q",
+					generatingBootstrapper, output, buildDir)
+			}
+		}
+
+		for _, file := range ctx.VolatileFiles() {
+			if !strings.HasPrefix(file.Path, buildDir+"/") {
+				t.Errorf("generatingBootstrapper %v: volatile file %q is not in %q",
+					generatingBootstrapper, file.Path, buildDir)
+			}
+		}
+	}
+}
+
+func TestDebugBinDir(t *testing.T) {
+	srcDir := setUpBuildDirTest(t)
+	defer os.RemoveAll(srcDir)
+
+	testCases := []struct {
+		debugBuild bool
+		binDir     string
+	}{
+		{false, "out/.bootstrap/bin"},
+		{true, "out/.bootstrap/debug/bin"},
+	}
+
+	for _, testCase := range testCases {
+		ctx, outputs := runBuildDirTest(t, srcDir, &Config{
+			generatingBootstrapper: true,
+			buildDir:               "out",
+			debugBuild:             testCase.debugBuild,
+		})
+
+		// The rules that run the bootstrap binaries find them in $binDir.
+		buf := &bytes.Buffer{}
+		if err := ctx.WriteBuildFile(buf); err != nil {
+			t.Fatal(err)
+		}
+		expected := "g.bootstrap.binDir = " +
+			strings.Replace(testCase.binDir, "out", "${g.bootstrap.buildDir}", 1) + "\n"
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("debugBuild %v: expected %q in:\n%s", testCase.debugBuild,
+				expected, buf.String())
+		}
+
+		binary := filepath.Join(testCase.binDir, "builder")
+		if _, ok := outputs[binary]; !ok {
+			t.Errorf("debugBuild %v: expected output %q", testCase.debugBuild, binary)
+		}
+
+		for output := range outputs {
+			if filepath.Base(filepath.Dir(output)) == "bin" &&
+				filepath.Dir(output) != testCase.binDir {
+				t.Errorf("debugBuild %v: output %q is not in %q",
+					testCase.debugBuild, output, testCase.binDir)
+			}
+		}
+	}
+}
+
+func TestStdImportcfgAlwaysRuns(t *testing.T) {
+	srcDir := setUpBuildDirTest(t)
+	defer os.RemoveAll(srcDir)
+
+	ctx, _ := runBuildDirTest(t, srcDir, &Config{
+		generatingBootstrapper: true,
+		buildDir:               "out",
+	})
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// The archive files listed in importcfg.std can be removed from the Go
+	// cache, so it must depend on an input that is never up to date.
+	always := "${g.bootstrap.buildDir}/.bootstrap/importcfg.std.always"
+	expected := "build " + always + ": phony\n"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected %q in:\n%s", expected, buf.String())
+	}
+	if strings.Count(buf.String(), always) < 2 {
+		t.Errorf("expected importcfg.std to depend on %q in:\n%s", always, buf.String())
+	}
+}
+
+func TestGenDepfile(t *testing.T) {
+	srcDir := setUpBuildDirTest(t)
+	defer os.RemoveAll(srcDir)
+
+	writeTestSources(t, srcDir, map[string]string{
+		"Blueprints": buildDirTestBlueprints + `
+bootstrap_go_gen {
+    name: "gen",
+    cmd: "gen -o $out -d $depfile $in",
+    srcs: ["gen.in"],
+    out: ["gen.go"],
+    depfile: true,
+}
+`,
+		"gen.in": "",
+	})
+
+	ctx, _ := runBuildDirTest(t, srcDir, &Config{
+		generatingBootstrapper: true,
+		buildDir:               "out",
+	})
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"    command = gen -o ${out} -d ${depfile} ${in}\n",
+		"    depfile = ${depfile}\n",
+		"    depfile = ${g.bootstrap.buildDir}/.bootstrap/.intermediates/gen/gen/gen.d\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %q in:\n%s", expected, buf.String())
+		}
+	}
+}
+
+var testHashTestCases = []struct {
+	name     string
+	testData map[string]string
+	flags    string
+	changed  bool
+}{
+	{
+		name:     "unchanged",
+		testData: map[string]string{"data/a": "xy", "data/b": ""},
+	},
+	{
+		name:     "data moved between files",
+		testData: map[string]string{"data/a": "x", "data/b": "y"},
+		changed:  true,
+	},
+	{
+		name:     "data file renamed",
+		testData: map[string]string{"data/a": "xy", "data/c": ""},
+		changed:  true,
+	},
+	{
+		name:     "data file removed",
+		testData: map[string]string{"data/a": "xy"},
+		changed:  true,
+	},
+	{
+		name:     "flags changed",
+		testData: map[string]string{"data/a": "xy", "data/b": ""},
+		flags:    " -test.parallel 4",
+		changed:  true,
+	},
+}
+
+func TestTestHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bootstrap_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// hash runs the command that sets the hash in the test rules in dir.
+	hash := func(testData map[string]string, flags string) string {
+		err := os.RemoveAll(filepath.Join(dir, "data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTestSources(t, dir, testData)
+
+		var files []string
+		for file := range testData {
+			files = append(files, file)
+		}
+		sort.Strings(files)
+
+		command := strings.NewReplacer("$$", "$", "$in", "test",
+			"$testData", strings.Join(files, " "), "$testFlags", flags).
+			Replace(testHashCommand("$testFlags"))
+
+		cmd := exec.Command("/bin/sh", "-c", command+` && echo "$hash"`)
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%s: %s", command, err)
+		}
+		return string(out)
+	}
+
+	writeTestSources(t, dir, map[string]string{"test": "test binary"})
+	base := hash(testHashTestCases[0].testData, testHashTestCases[0].flags)
+
+	for _, testCase := range testHashTestCases {
+		if changed := hash(testCase.testData, testCase.flags) != base; changed != testCase.changed {
+			t.Errorf("%s: expected hash changed %v, got %v", testCase.name,
+				testCase.changed, changed)
+		}
+	}
+}
+
+func TestReproducibleBuild(t *testing.T) {
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no Go toolchain found")
+	}
+	goRoot, err := exec.Command(goCmd, "env", "GOROOT").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "bootstrap_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// run runs a command of the rules in workDir with the variables in vars.
+	run := func(workDir, command string, vars ...string) {
+		command = strings.Replace(command, "$gcTrimPath", gcTrimPathFlags, -1)
+		vars = append([]string{"$$", "$",
+			"$goRoot", strings.TrimSpace(string(goRoot)),
+			"$stdImportcfg", filepath.Join("..", "importcfg.std"),
+		}, vars...)
+		command = strings.NewReplacer(vars...).Replace(command)
+
+		cmd := exec.Command("/bin/sh", "-c", command)
+		cmd.Dir = workDir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%s: %s\n%s", command, err, out)
+		}
+	}
+
+	run(dir, goCmd+" list -export -deps "+
+		"-f '{{if .Export}}packagefile {{.ImportPath}}={{.Export}}{{end}}' "+
+		"runtime > importcfg.std")
+
+	// build compiles and links the same sources checked out in srcDir, and
+	// returns the binary.
+	build := func(srcDir string) []byte {
+		writeTestSources(t, filepath.Join(dir, srcDir), map[string]string{
+			"lib/lib.go": "package lib\n\nfunc Lib() { panic(\"lib\") }\n",
+			"main/main.go": "package main\n\nimport \"example/lib\"\n\n" +
+				"func main() { lib.Lib() }\n",
+		})
+
+		run(filepath.Join(dir, srcDir), gcCommand,
+			"$gcCmd", goCmd+" tool compile", "$srcDir", ".",
+			"$out", "lib.a", "$pkgPath", "example/lib", "$gcFlags", "",
+			"$packageFiles", "", "$in", "lib/lib.go")
+		run(filepath.Join(dir, srcDir), gcCommand,
+			"$gcCmd", goCmd+" tool compile", "$srcDir", ".",
+			"$out", "main.a", "$pkgPath", "main", "$gcFlags", "",
+			"$packageFiles", "example/lib=lib.a", "$in", "main/main.go")
+		run(filepath.Join(dir, srcDir), linkCommand,
+			"$linkCmd", goCmd+" tool link", "$out", "main.bin",
+			"$packageFiles", "example/lib=lib.a", "$ldFlags", "", "$in", "main.a")
+
+		binary, err := ioutil.ReadFile(filepath.Join(dir, srcDir, "main.bin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return binary
+	}
+
+	if !bytes.Equal(build("a"), build("b")) {
+		t.Errorf("binaries built from different source directories differ")
+	}
+}
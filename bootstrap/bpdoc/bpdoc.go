@@ -2,12 +2,16 @@ package bpdoc
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"go/ast"
 	"go/doc"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strconv"
@@ -23,14 +27,38 @@ type DocCollector struct {
 	pkgFiles map[string][]string // Map of package name to source files, provided by constructor
 
 	mutex   sync.Mutex
-	pkgDocs map[string]*doc.Package        // Map of package name to parsed Go AST, protected by mutex
+	pkgDocs map[string]*packageDocsEntry   // Map of package name to parsed Go AST, protected by mutex
 	docs    map[string]*PropertyStructDocs // Map of type name to docs, protected by mutex
 }
 
+type packageDocsEntry struct {
+	once  sync.Once
+	docs  *doc.Package
+	files []*fileImports // Imports of each source file
+	err   error
+}
+
+// fileImports holds the imports of a source file, which are only in scope in that file.
+type fileImports struct {
+	pos, end   token.Pos         // Range of the file in the package's FileSet
+	imports    map[string]string // Map of import name to package path
+	dotImports []string          // Package paths of dot imports
+}
+
+// importsAt returns the imports of the file containing pos.
+func (entry *packageDocsEntry) importsAt(pos token.Pos) *fileImports {
+	for _, file := range entry.files {
+		if file.pos <= pos && pos < file.end {
+			return file
+		}
+	}
+	return &fileImports{}
+}
+
 func NewDocCollector(pkgFiles map[string][]string) *DocCollector {
 	return &DocCollector{
 		pkgFiles: pkgFiles,
-		pkgDocs:  make(map[string]*doc.Package),
+		pkgDocs:  make(map[string]*packageDocsEntry),
 		docs:     make(map[string]*PropertyStructDocs),
 	}
 }
@@ -38,33 +66,105 @@ func NewDocCollector(pkgFiles map[string][]string) *DocCollector {
 // Return the PropertyStructDocs associated with a property struct type.  The type should be in the
 // format <package path>.<type name>
 func (dc *DocCollector) Docs(pkg, name string, defaults reflect.Value) (*PropertyStructDocs, error) {
-	docs := dc.getDocs(name)
+	// Instantiations of generic types are named like Props[string], and are
+	// documented by the generic type.
+	if i := strings.IndexByte(name, '['); i >= 0 {
+		name = name[:i]
+	}
 
-	if docs == nil {
-		pkgDocs, err := dc.packageDocs(pkg)
-		if err != nil {
-			return nil, err
+	docs, err := dc.typeDocs(pkg, name)
+	if err != nil {
+		return nil, err
+	}
+
+	docs = docs.Clone()
+	docs.SetTypes(defaults)
+	docs.SetDefaults(defaults)
+
+	return docs, nil
+}
+
+// typeDocs returns the PropertyStructDocs for the struct type that the named type in pkg is
+// declared as, following type aliases, types defined from other types, generic instantiations
+// and dot imports.
+func (dc *DocCollector) typeDocs(pkg, name string) (*PropertyStructDocs, error) {
+	if docs := dc.getDocs(pkg + "." + name); docs != nil {
+		return docs, nil
+	}
+
+	entry, err := dc.packageDocs(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	var t *doc.Type
+	for _, pkgType := range entry.docs.Types {
+		if pkgType.Name == name {
+			t = pkgType
 		}
+	}
 
-		for _, t := range pkgDocs.Types {
-			if t.Name == name {
-				docs, err = newDocs(t)
-				if err != nil {
-					return nil, err
+	if t == nil {
+		return nil, fmt.Errorf("package %q type %q not found", pkg, name)
+	}
+
+	typeSpec := t.Decl.Specs[0].(*ast.TypeSpec)
+	imports := entry.importsAt(t.Decl.Pos())
+
+	var docs *PropertyStructDocs
+	switch x := genericType(typeSpec.Type).(type) {
+	case *ast.StructType:
+		docs, err = newDocs(t)
+	case *ast.Ident:
+		docs, err = dc.typeDocs(pkg, x.Name)
+		if err != nil {
+			// The type may come from a dot import of the file that declares this one.
+			for _, dotImport := range imports.dotImports {
+				if _, ok := dc.pkgFiles[dotImport]; ok {
+					if dotDocs, dotErr := dc.typeDocs(dotImport, x.Name); dotErr == nil {
+						docs, err = dotDocs, nil
+						break
+					}
 				}
-				docs = dc.putDocs(name, docs)
 			}
 		}
+	case *ast.SelectorExpr:
+		importName, ok := x.X.(*ast.Ident)
+		if !ok || imports.imports[importName.Name] == "" {
+			return nil, fmt.Errorf("package %q type %q: unknown package for %s", pkg, name,
+				types.ExprString(x))
+		}
+		docs, err = dc.typeDocs(imports.imports[importName.Name], x.Sel.Name)
+	default:
+		return nil, fmt.Errorf("type of %q is not a struct", t.Name)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	if docs == nil {
-		return nil, fmt.Errorf("package %q type %q not found", pkg, name)
+	if docs.Name != t.Name {
+		// The type is declared as another type, use its own name and any docs.
+		derived := *docs
+		derived.Name = t.Name
+		if t.Doc != "" {
+			derived.Text = t.Doc
+		}
+		docs = &derived
 	}
 
-	docs = docs.Clone()
-	docs.SetDefaults(defaults)
+	return dc.putDocs(pkg+"."+name, docs), nil
+}
 
-	return docs, nil
+// genericType returns the generic type of an instantiation of a generic type, or the type itself.
+func genericType(expr ast.Expr) ast.Expr {
+	switch x := expr.(type) {
+	case *ast.IndexExpr:
+		return x.X
+	case *ast.IndexListExpr:
+		return x.X
+	default:
+		return expr
+	}
 }
 
 func (dc *DocCollector) getDocs(name string) *PropertyStructDocs {
@@ -135,6 +235,52 @@ func (docs *PropertyStructDocs) SetDefaults(defaults reflect.Value) {
 	setDefaults(docs.Properties, defaults)
 }
 
+// SetTypes sets the types of the properties that are declared with type parameters of a generic
+// property struct from the types of the fields of an instantiation of it.
+func (docs *PropertyStructDocs) SetTypes(value reflect.Value) {
+	setTypes(docs.Properties, value.Type())
+}
+
+func setTypes(properties []PropertyDocs, t reflect.Type) {
+	for i := range properties {
+		prop := &properties[i]
+		field, ok := t.FieldByName(proptools.FieldNameForProperty(prop.Name))
+		if !ok {
+			continue
+		}
+
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		if len(prop.Properties) > 0 {
+			if fieldType.Kind() == reflect.Struct {
+				setTypes(prop.Properties, fieldType)
+			}
+		} else if prop.Type == "" {
+			prop.Type = propertyType(fieldType)
+		}
+	}
+}
+
+// propertyType returns the description of the type of a property field.
+func propertyType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return propertyType(t.Elem())
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String {
+			return "list of strings"
+		}
+		return "list of " + propertyType(t.Elem())
+	case reflect.Map:
+		return "map of strings"
+	default:
+		return t.String()
+	}
+}
+
 func setDefaults(properties []PropertyDocs, defaults reflect.Value) {
 	for i := range properties {
 		prop := &properties[i]
@@ -144,6 +290,11 @@ func setDefaults(properties []PropertyDocs, defaults reflect.Value) {
 			panic(fmt.Errorf("property %q does not exist in %q", fieldName, defaults.Type()))
 		}
 
+		if def := prop.Tag.Get("default"); def != "" {
+			prop.Default = def
+			continue
+		}
+
 		if reflect.DeepEqual(f.Interface(), reflect.Zero(f.Type()).Interface()) {
 			continue
 		}
@@ -225,8 +376,18 @@ func newDocs(t *doc.Type) (*PropertyStructDocs, error) {
 		return nil, fmt.Errorf("type of %q is not a struct", t.Name)
 	}
 
+	// The types of properties declared with type parameters are set from each instantiation.
+	typeParams := make(map[string]bool)
+	if typeSpec.TypeParams != nil {
+		for _, field := range typeSpec.TypeParams.List {
+			for _, n := range field.Names {
+				typeParams[n.Name] = true
+			}
+		}
+	}
+
 	var err error
-	docs.Properties, err = structProperties(structType)
+	docs.Properties, err = structProperties(structType, typeParams)
 	if err != nil {
 		return nil, err
 	}
@@ -234,7 +395,7 @@ func newDocs(t *doc.Type) (*PropertyStructDocs, error) {
 	return &docs, nil
 }
 
-func structProperties(structType *ast.StructType) (props []PropertyDocs, err error) {
+func structProperties(structType *ast.StructType, typeParams map[string]bool) (props []PropertyDocs, err error) {
 	for _, f := range structType.Fields.List {
 		//fmt.Printf("%T %#v\n", f, f)
 		for _, n := range f.Names {
@@ -255,12 +416,19 @@ func structProperties(structType *ast.StructType) (props []PropertyDocs, err err
 			switch a := f.Type.(type) {
 			case *ast.ArrayType:
 				typ = "list of strings"
+			case *ast.MapType:
+				typ = "map of strings"
 			case *ast.InterfaceType:
 				typ = "interface"
 			case *ast.Ident:
-				typ = a.Name
+				if !typeParams[a.Name] {
+					typ = a.Name
+				}
+			case *ast.StarExpr:
+				// The types of pointer properties are set from the types of the
+				// fields by SetTypes, like those declared with type parameters.
 			case *ast.StructType:
-				innerProps, err = structProperties(a)
+				innerProps, err = structProperties(a, typeParams)
 				if err != nil {
 					return nil, err
 				}
@@ -306,113 +474,688 @@ func filterPropsByTag(props *[]PropertyDocs, key, value string, exclude bool) {
 }
 
 // Package AST generation and storage
-func (dc *DocCollector) packageDocs(pkg string) (*doc.Package, error) {
-	pkgDocs := dc.getPackageDocs(pkg)
-	if pkgDocs == nil {
-		if files, ok := dc.pkgFiles[pkg]; ok {
-			var err error
-			pkgAST, err := NewPackageAST(files)
-			if err != nil {
-				return nil, err
+func (dc *DocCollector) packageDocs(pkg string) (*packageDocsEntry, error) {
+	files, ok := dc.pkgFiles[pkg]
+	if !ok {
+		return nil, fmt.Errorf("unknown package %q", pkg)
+	}
+
+	// Each package is parsed once, by whichever caller asks for it first, and
+	// any concurrent callers wait for the result.
+	entry := dc.getPackageDocs(pkg)
+	entry.once.Do(func() {
+		pkgAST, err := NewPackageAST(files)
+		if err != nil {
+			entry.err = err
+			return
+		}
+
+		for _, file := range pkgAST.Files {
+			imports := &fileImports{
+				pos:     file.Pos(),
+				end:     file.End(),
+				imports: make(map[string]string),
 			}
-			pkgDocs = doc.New(pkgAST, pkg, doc.AllDecls)
-			pkgDocs = dc.putPackageDocs(pkg, pkgDocs)
-		} else {
-			return nil, fmt.Errorf("unknown package %q", pkg)
+			for _, spec := range file.Imports {
+				path, err := strconv.Unquote(spec.Path.Value)
+				if err != nil {
+					entry.err = err
+					return
+				}
+
+				if spec.Name == nil {
+					imports.imports[filepath.Base(path)] = path
+				} else if spec.Name.Name == "." {
+					imports.dotImports = append(imports.dotImports, path)
+				} else {
+					imports.imports[spec.Name.Name] = path
+				}
+			}
+			entry.files = append(entry.files, imports)
 		}
-	}
-	return pkgDocs, nil
-}
 
-func (dc *DocCollector) getPackageDocs(pkg string) *doc.Package {
-	dc.mutex.Lock()
-	defer dc.mutex.Unlock()
+		entry.docs = doc.New(pkgAST, pkg, doc.AllDecls)
+	})
 
-	return dc.pkgDocs[pkg]
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	return entry, nil
 }
 
-func (dc *DocCollector) putPackageDocs(pkg string, pkgDocs *doc.Package) *doc.Package {
+func (dc *DocCollector) getPackageDocs(pkg string) *packageDocsEntry {
 	dc.mutex.Lock()
 	defer dc.mutex.Unlock()
 
-	if dc.pkgDocs[pkg] != nil {
-		return dc.pkgDocs[pkg]
-	} else {
-		dc.pkgDocs[pkg] = pkgDocs
-		return pkgDocs
+	entry := dc.pkgDocs[pkg]
+	if entry == nil {
+		entry = &packageDocsEntry{}
+		dc.pkgDocs[pkg] = entry
 	}
+	return entry
 }
 
 func NewPackageAST(files []string) (*ast.Package, error) {
-	asts := make(map[string]*ast.File)
-
 	fset := token.NewFileSet()
-	for _, file := range files {
-		ast, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
-		if err != nil {
-			return nil, err
+	fileASTs := make([]*ast.File, len(files))
+	errs := make([]error, len(files))
+
+	var wg sync.WaitGroup
+	for i, file := range files {
+		wg.Add(1)
+		go func(i int, file string) {
+			defer wg.Done()
+			fileASTs[i], errs[i] = parser.ParseFile(fset, file, nil, parser.ParseComments)
+		}(i, file)
+	}
+	wg.Wait()
+
+	asts := make(map[string]*ast.File)
+	for i, file := range files {
+		if errs[i] != nil {
+			return nil, errs[i]
 		}
-		asts[file] = ast
+		asts[file] = fileASTs[i]
 	}
 
 	pkg, _ := ast.NewPackage(fset, asts, nil, nil)
 	return pkg, nil
 }
 
-func Write(filename string, pkgFiles map[string][]string,
-	moduleTypePropertyStructs map[string][]interface{}) error {
+// A Format is an output format for the build documentation.
+type Format int
+
+const (
+	HTML Format = iota
+	Markdown
+	JSON // For editors and other tools, see jsonDocs
+)
+
+// ParseFormat returns the Format with the given name, which is also the
+// extension of the files written in that format: "html", "md" or "json".
+func ParseFormat(name string) (Format, error) {
+	switch name {
+	case "html":
+		return HTML, nil
+	case "md":
+		return Markdown, nil
+	case "json":
+		return JSON, nil
+	default:
+		return 0, fmt.Errorf("unknown docs format %q", name)
+	}
+}
+
+// Ext returns the extension, including the leading ".", of files written in
+// the format.
+func (f Format) Ext() string {
+	switch f {
+	case HTML:
+		return ".html"
+	case Markdown:
+		return ".md"
+	case JSON:
+		return ".json"
+	default:
+		panic(fmt.Errorf("unknown docs format %d", f))
+	}
+}
+
+// Write writes the documentation for the module types to filename in the
+// given format.  If split is true, the documentation for the module types
+// defined by each package is written to a separate file in the directory
+// containing filename, named after the package path, and filename is written
+// as an index of those files.  moduleTypeBases maps the name of each module
+// type that extends another to the name of the module type that it extends,
+// as returned by Context.ModuleTypeBases.
+//
+// The HTML documentation redirects the anchors of renamed and removed module
+// types and properties to their closest replacements.  The anchors of the
+// previous version of the documentation are read from anchorsFile, unless it
+// is "" or doesn't exist, and the updated anchors are written to
+// AnchorsFile(filename).  anchorsFile is normally kept in the source tree and
+// replaced with the updated file when module types or properties are renamed,
+// so that the redirects survive clean builds.
+func Write(filename string, format Format, split bool, anchorsFile string,
+	pkgFiles map[string][]string, moduleTypePropertyStructs map[string][]interface{},
+	moduleTypeBases map[string]string) error {
 
 	docSet := NewDocCollector(pkgFiles)
 
-	var moduleTypeList []*moduleTypeDoc
-	for moduleType, propertyStructs := range moduleTypePropertyStructs {
-		mtDoc, err := getModuleTypeDoc(docSet, moduleType, propertyStructs)
+	// Document the module types concurrently.  Most of the time is spent
+	// parsing the packages that define their property structs, which the
+	// DocCollector shares between them.
+	var moduleTypes []string
+	for moduleType := range moduleTypePropertyStructs {
+		moduleTypes = append(moduleTypes, moduleType)
+	}
+
+	moduleTypeList := make([]*moduleTypeDoc, len(moduleTypes))
+	errs := make([]error, len(moduleTypes))
+
+	var wg sync.WaitGroup
+	for i, moduleType := range moduleTypes {
+		wg.Add(1)
+		go func(i int, moduleType string) {
+			defer wg.Done()
+			baseName := moduleTypeBases[moduleType]
+			mtDoc, err := getModuleTypeDoc(docSet, moduleType,
+				moduleTypePropertyStructs[moduleType], baseName,
+				len(moduleTypePropertyStructs[baseName]))
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			removeEmptyPropertyStructs(mtDoc)
+			collapseDuplicatePropertyStructs(mtDoc)
+			// The JSON documentation is read by tools, which need the
+			// properties nested the same way as in the Blueprints files.
+			if format != JSON {
+				collapseNestedPropertyStructs(mtDoc)
+				combineDuplicateProperties(mtDoc)
+			}
+			moduleTypeList[i] = mtDoc
+		}(i, moduleType)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
 		if err != nil {
 			return err
 		}
-		removeEmptyPropertyStructs(mtDoc)
-		collapseDuplicatePropertyStructs(mtDoc)
-		collapseNestedPropertyStructs(mtDoc)
-		combineDuplicateProperties(mtDoc)
-		moduleTypeList = append(moduleTypeList, mtDoc)
 	}
 
 	sort.Sort(moduleTypeByName(moduleTypeList))
 
+	var redirects map[string]string
+	if format == HTML {
+		anchors, err := updateAnchors(anchorsFile, moduleTypeAnchors(moduleTypeList))
+		if err != nil {
+			return err
+		}
+
+		data, err := json.MarshalIndent(anchors, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		err = writeFile(AnchorsFile(filename), append(data, '\n'))
+		if err != nil {
+			return err
+		}
+
+		redirects = anchors.Redirects
+	}
+
+	if !split {
+		return writeModuleTypes(filename, format, moduleTypeList, redirects)
+	}
+
+	pkgModuleTypes := make(map[string][]*moduleTypeDoc)
+	for _, mtDoc := range moduleTypeList {
+		pkgModuleTypes[mtDoc.Package] = append(pkgModuleTypes[mtDoc.Package], mtDoc)
+	}
+
+	var index []*packageDoc
+	for pkg, list := range pkgModuleTypes {
+		if pkg == "" {
+			pkg = "other"
+		}
+		pkgDoc := &packageDoc{
+			Package: pkg,
+			File:    pkg + format.Ext(),
+		}
+		for _, mtDoc := range list {
+			pkgDoc.ModuleTypes = append(pkgDoc.ModuleTypes, mtDoc.Name)
+		}
+
+		err := writeModuleTypes(filepath.Join(filepath.Dir(filename), pkgDoc.File),
+			format, list, pageRedirects(redirects, list))
+		if err != nil {
+			return err
+		}
+
+		index = append(index, pkgDoc)
+	}
+
+	sort.Sort(packageByName(index))
+
+	return writeIndex(filename, format, index)
+}
+
+// WriteMarkdown writes the documentation for the module types to dir as
+// Markdown, one file per package named after the package path, with an index
+// of the packages in index.md.  The files can be published to wikis and code
+// review tools that render Markdown without any template of their own.
+func WriteMarkdown(dir string, pkgFiles map[string][]string,
+	moduleTypePropertyStructs map[string][]interface{},
+	moduleTypeBases map[string]string) error {
+
+	return Write(filepath.Join(dir, "index"+Markdown.Ext()), Markdown, true, "", pkgFiles,
+		moduleTypePropertyStructs, moduleTypeBases)
+}
+
+// AnchorsFile returns the file that Write writes the anchors of the HTML
+// documentation in filename to, which has the same name with the extension
+// replaced by ".anchors.json".
+func AnchorsFile(filename string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ".anchors.json"
+}
+
+// writeModuleTypes writes the documentation of moduleTypeList to filename.
+// The HTML documentation redirects the anchors in redirects.
+func writeModuleTypes(filename string, format Format, moduleTypeList []*moduleTypeDoc,
+	redirects map[string]string) error {
+
+	buf := &bytes.Buffer{}
+
+	switch format {
+	case HTML:
+		unique := 0
+
+		tmpl, err := template.New("file").Funcs(map[string]interface{}{
+			"unique": func() int {
+				unique++
+				return unique
+			},
+			"anchor": propertyAnchor,
+			"properties": func(prefix string, properties []PropertyDocs) propertiesData {
+				return propertiesData{prefix, properties}
+			},
+			"json": func(v interface{}) (string, error) {
+				data, err := json.Marshal(v)
+				return string(data), err
+			}}).Parse(fileTemplate)
+		if err != nil {
+			return err
+		}
+
+		err = tmpl.Execute(buf, fileData{moduleTypeList, redirects})
+		if err != nil {
+			return err
+		}
+	case Markdown:
+		fmt.Fprintln(buf, "# Build Docs")
+		for _, mtDoc := range moduleTypeList {
+			fmt.Fprintf(buf, "\n## %s\n\n", mtDoc.Name)
+			if mtDoc.Extends != "" {
+				fmt.Fprintf(buf, "Extends [%s](#%s).\n\n", mtDoc.Extends, mtDoc.Extends)
+			}
+			if mtDoc.Text != "" {
+				fmt.Fprintf(buf, "%s\n\n", markdownText(mtDoc.Text))
+			}
+			for _, psDoc := range mtDoc.PropertyStructs {
+				if psDoc.Text != "" {
+					fmt.Fprintf(buf, "%s\n\n", markdownText(psDoc.Text))
+				}
+				writeMarkdownProperties(buf, psDoc.Properties, "")
+			}
+		}
+	case JSON:
+		data, err := json.MarshalIndent(newJSONDocs(moduleTypeList), "", "  ")
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteString("\n")
+	default:
+		panic(fmt.Errorf("unknown docs format %d", format))
+	}
+
+	return writeFile(filename, buf.Bytes())
+}
+
+type fileData struct {
+	ModuleTypes []*moduleTypeDoc
+	Redirects   map[string]string
+}
+
+type propertiesData struct {
+	Prefix     string
+	Properties []PropertyDocs
+}
+
+// The HTML anchor of a module type is its name, and the anchor of a property is the anchor of
+// the module type or property that contains it followed by "." and its name.
+func propertyAnchor(prefix, name string) string {
+	return prefix + "." + name
+}
+
+func moduleTypeAnchors(moduleTypeList []*moduleTypeDoc) []string {
+	var anchors []string
+	for _, mtDoc := range moduleTypeList {
+		anchors = append(anchors, mtDoc.Name)
+		for _, psDoc := range mtDoc.PropertyStructs {
+			anchors = appendPropertyAnchors(anchors, mtDoc.Name, psDoc.Properties)
+		}
+	}
+
+	sort.Strings(anchors)
+	return anchors
+}
+
+func appendPropertyAnchors(anchors []string, prefix string, properties []PropertyDocs) []string {
+	for _, p := range properties {
+		anchor := propertyAnchor(prefix, p.Name)
+		anchors = append(anchors, anchor)
+		for _, name := range p.OtherNames {
+			anchors = append(anchors, propertyAnchor(prefix, name))
+		}
+		anchors = appendPropertyAnchors(anchors, anchor, p.Properties)
+	}
+	return anchors
+}
+
+// An anchorsState records the HTML anchors in the docs, and where the anchors that were in
+// previous versions of them, but no longer are, should redirect to, so that links into older
+// versions of the docs keep working.  Anchors start with the name of their module type, so
+// one anchorsState covers all of the files of split docs.
+type anchorsState struct {
+	Anchors   []string
+	Redirects map[string]string
+}
+
+// updateAnchors reads the anchorsState from filename, if it is set and exists, and returns it
+// updated for the current anchors.  An anchor that is no longer present redirects to the only
+// current property with the same name in the same module type, if there is one, because that
+// usually means the property was moved into or out of a nested property struct.  Otherwise it
+// redirects to the closest anchor containing it that still exists, or "" for the top of the
+// file.
+func updateAnchors(filename string, anchors []string) (*anchorsState, error) {
+	var old anchorsState
+	if filename != "" {
+		data, err := ioutil.ReadFile(filename)
+		if err == nil {
+			err = json.Unmarshal(data, &old)
+			if err != nil {
+				return nil, fmt.Errorf("error reading %s: %s", filename, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	current := make(map[string]bool)
+	for _, anchor := range anchors {
+		current[anchor] = true
+	}
+
+	redirect := func(anchor string) string {
+		parts := strings.Split(anchor, ".")
+		if len(parts) > 1 {
+			var matches []string
+			for _, a := range anchors {
+				if strings.HasPrefix(a, parts[0]+".") &&
+					a[strings.LastIndex(a, ".")+1:] == parts[len(parts)-1] {
+					matches = append(matches, a)
+				}
+			}
+			if len(matches) == 1 {
+				return matches[0]
+			}
+		}
+
+		for i := len(parts) - 1; i > 0; i-- {
+			if parent := strings.Join(parts[:i], "."); current[parent] {
+				return parent
+			}
+		}
+		return ""
+	}
+
+	state := &anchorsState{
+		Anchors:   anchors,
+		Redirects: make(map[string]string),
+	}
+
+	for anchor, target := range old.Redirects {
+		if current[anchor] {
+			continue
+		}
+		if target != "" && !current[target] {
+			target = redirect(anchor)
+		}
+		state.Redirects[anchor] = target
+	}
+
+	for _, anchor := range old.Anchors {
+		if !current[anchor] {
+			state.Redirects[anchor] = redirect(anchor)
+		}
+	}
+
+	return state, nil
+}
+
+// pageRedirects returns the redirects for the page of split docs that documents moduleTypes,
+// which are the ones from anchors of the module types or to anchors in them.
+func pageRedirects(redirects map[string]string, moduleTypes []*moduleTypeDoc) map[string]string {
+	if redirects == nil {
+		return nil
+	}
+
+	onPage := make(map[string]bool)
+	for _, mtDoc := range moduleTypes {
+		onPage[mtDoc.Name] = true
+	}
+
+	moduleType := func(anchor string) string {
+		return strings.SplitN(anchor, ".", 2)[0]
+	}
+
+	ret := make(map[string]string)
+	for anchor, target := range redirects {
+		if onPage[moduleType(anchor)] || (target != "" && onPage[moduleType(target)]) {
+			ret[anchor] = target
+		}
+	}
+	return ret
+}
+
+func writeIndex(filename string, format Format, index []*packageDoc) error {
 	buf := &bytes.Buffer{}
 
-	unique := 0
+	switch format {
+	case HTML:
+		tmpl, err := template.New("index").Parse(indexTemplate)
+		if err != nil {
+			return err
+		}
 
-	tmpl, err := template.New("file").Funcs(map[string]interface{}{
-		"unique": func() int {
-			unique++
-			return unique
-		}}).Parse(fileTemplate)
-	if err != nil {
-		return err
+		err = tmpl.Execute(buf, index)
+		if err != nil {
+			return err
+		}
+	case Markdown:
+		fmt.Fprintln(buf, "# Build Docs")
+		fmt.Fprintln(buf)
+		for _, pkgDoc := range index {
+			fmt.Fprintf(buf, "- [%s](%s): %s\n", pkgDoc.Package, pkgDoc.File,
+				strings.Join(pkgDoc.ModuleTypes, ", "))
+		}
+	case JSON:
+		data, err := json.MarshalIndent(index, "", "  ")
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteString("\n")
+	default:
+		panic(fmt.Errorf("unknown docs format %d", format))
 	}
 
-	err = tmpl.Execute(buf, moduleTypeList)
-	if err != nil {
-		return err
+	return writeFile(filename, buf.Bytes())
+}
+
+func writeMarkdownProperties(buf *bytes.Buffer, properties []PropertyDocs, indent string) {
+	for _, p := range properties {
+		names := append([]string{p.Name}, p.OtherNames...)
+		fmt.Fprintf(buf, "%s- **%s**", indent, strings.Join(names, "**, **"))
+		if len(p.Properties) == 0 {
+			fmt.Fprintf(buf, " (*%s*", p.Type)
+			if p.Default != "" {
+				fmt.Fprintf(buf, ", default *%s*", p.Default)
+			}
+			fmt.Fprint(buf, ")")
+		}
+		texts := append([]string{p.Text}, p.OtherTexts...)
+		for _, text := range texts {
+			if text != "" {
+				fmt.Fprintf(buf, " %s", markdownText(text))
+			}
+		}
+		fmt.Fprintln(buf)
+		writeMarkdownProperties(buf, p.Properties, indent+"  ")
 	}
+	if indent == "" && len(properties) > 0 {
+		fmt.Fprintln(buf)
+	}
+}
+
+// The JSON documentation lists the module types defined by each package and
+// all of their properties, so that editors can complete and describe the
+// properties in Blueprints files.  Properties that have properties are
+// property sets, e.g. "target: { host: { ... } }".  The properties of all of
+// the property structs of a module type are merged into one list, as they are
+// in the Blueprints files.
+type jsonDocs struct {
+	Packages []*jsonPackageDocs `json:"packages"`
+}
+
+type jsonPackageDocs struct {
+	Path        string                `json:"path"`
+	ModuleTypes []*jsonModuleTypeDocs `json:"module_types"`
+}
+
+type jsonModuleTypeDocs struct {
+	Name       string              `json:"name"`
+	Extends    string              `json:"extends,omitempty"`
+	Text       string              `json:"text,omitempty"`
+	Properties []*jsonPropertyDocs `json:"properties"`
+}
+
+type jsonPropertyDocs struct {
+	Name       string              `json:"name"`
+	Type       string              `json:"type,omitempty"`
+	Tags       map[string][]string `json:"tags,omitempty"`
+	Text       string              `json:"text,omitempty"`
+	Default    string              `json:"default,omitempty"`
+	Properties []*jsonPropertyDocs `json:"properties,omitempty"`
+}
+
+// newJSONDocs returns the JSON documentation of the module types, which are
+// sorted by name, grouped by the package that defines them.
+func newJSONDocs(moduleTypeList []*moduleTypeDoc) *jsonDocs {
+	docs := &jsonDocs{Packages: []*jsonPackageDocs{}}
+	packages := make(map[string]*jsonPackageDocs)
+
+	for _, mtDoc := range moduleTypeList {
+		pkg := mtDoc.Package
+		if pkg == "" {
+			pkg = "other"
+		}
+		pkgDocs := packages[pkg]
+		if pkgDocs == nil {
+			pkgDocs = &jsonPackageDocs{Path: pkg}
+			packages[pkg] = pkgDocs
+			docs.Packages = append(docs.Packages, pkgDocs)
+		}
+
+		var properties []PropertyDocs
+		for _, psDoc := range mtDoc.PropertyStructs {
+			collapseDuplicateProperties(&properties, &psDoc.Properties)
+		}
+
+		pkgDocs.ModuleTypes = append(pkgDocs.ModuleTypes, &jsonModuleTypeDocs{
+			Name:       mtDoc.Name,
+			Extends:    mtDoc.Extends,
+			Text:       strings.TrimSpace(mtDoc.Text),
+			Properties: newJSONPropertyDocs(properties),
+		})
+	}
+
+	sort.Slice(docs.Packages, func(i, j int) bool {
+		return docs.Packages[i].Path < docs.Packages[j].Path
+	})
 
-	err = ioutil.WriteFile(filename, buf.Bytes(), 0666)
+	return docs
+}
+
+func newJSONPropertyDocs(properties []PropertyDocs) []*jsonPropertyDocs {
+	ret := []*jsonPropertyDocs{}
+	for _, p := range properties {
+		ret = append(ret, &jsonPropertyDocs{
+			Name:       p.Name,
+			Type:       p.Type,
+			Tags:       structTagValues(p.Tag),
+			Text:       strings.TrimSpace(p.Text),
+			Default:    p.Default,
+			Properties: newJSONPropertyDocs(p.Properties),
+		})
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
+
+// structTagValues returns the comma-separated values of each key of a struct
+// tag, e.g. {"android": ["path", "arch_variant"]} for
+// `android:"path,arch_variant"`, or nil if it has none.  It follows the
+// conventional format that reflect.StructTag.Get parses.
+func structTagValues(tag reflect.StructTag) map[string][]string {
+	var values map[string][]string
+	s := string(tag)
+	for {
+		s = strings.TrimLeft(s, " ")
+		i := strings.Index(s, ":\"")
+		if i <= 0 {
+			break
+		}
+		key := s[:i]
+
+		quoted, err := strconv.QuotedPrefix(s[i+1:])
+		if err != nil {
+			break
+		}
+		s = s[i+1+len(quoted):]
+
+		value, _ := strconv.Unquote(quoted)
+		if values == nil {
+			values = make(map[string][]string)
+		}
+		values[key] = strings.Split(value, ",")
+	}
+	return values
+}
+
+// markdownText joins the lines of a doc comment so that it can be used in a
+// single Markdown paragraph or list item.
+func markdownText(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+func writeFile(filename string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(filename), 0777)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return ioutil.WriteFile(filename, data, 0666)
 }
 
+// getModuleTypeDoc returns the documentation of a module type.  If the module
+// type extends baseName, its first inherited property structs are those of
+// the base module type.
 func getModuleTypeDoc(docSet *DocCollector, moduleType string,
-	propertyStructs []interface{}) (*moduleTypeDoc, error) {
+	propertyStructs []interface{}, baseName string, inherited int) (*moduleTypeDoc, error) {
 	mtDoc := &moduleTypeDoc{
-		Name: moduleType,
+		Name:    moduleType,
+		Extends: baseName,
 		//Text: docSet.ModuleTypeDocs(moduleType),
 	}
 
-	for _, s := range propertyStructs {
+	for i, s := range propertyStructs {
 		v := reflect.ValueOf(s).Elem()
 		t := v.Type()
 
@@ -420,6 +1163,10 @@ func getModuleTypeDoc(docSet *DocCollector, moduleType string,
 		if t.PkgPath() == "" {
 			continue
 		}
+		// The package of an extended module type is the one that extends it
+		if mtDoc.Package == "" && i >= inherited {
+			mtDoc.Package = t.PkgPath()
+		}
 		psDoc, err := docSet.Docs(t.PkgPath(), t.Name(), v)
 		if err != nil {
 			return nil, err
@@ -620,10 +1367,24 @@ func (l moduleTypeByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
 
 type moduleTypeDoc struct {
 	Name            string
+	Extends         string // The module type that this module type extends
 	Text            string
+	Package         string // Package of the first property struct not inherited, used to split the docs
 	PropertyStructs []*PropertyStructDocs
 }
 
+type packageByName []*packageDoc
+
+func (l packageByName) Len() int           { return len(l) }
+func (l packageByName) Less(i, j int) bool { return l[i].Package < l[j].Package }
+func (l packageByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+type packageDoc struct {
+	Package     string   `json:"package"`
+	File        string   `json:"file"`
+	ModuleTypes []string `json:"module_types"`
+}
+
 var (
 	fileTemplate = `
 <html>
@@ -632,13 +1393,33 @@ var (
 <link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.5/css/bootstrap.min.css">
 <script src="https://ajax.googleapis.com/ajax/libs/jquery/2.1.4/jquery.min.js"></script>
 <script src="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.5/js/bootstrap.min.js"></script>
+<script>
+  // Follow links to anchors that have been renamed or removed, and expand the panels
+  // containing the linked module type or property.
+  var redirects = {{json .Redirects}};
+  function showAnchor() {
+    var anchor = decodeURIComponent(location.hash.substring(1));
+    if (anchor in redirects) {
+      anchor = redirects[anchor];
+      history.replaceState(null, "", anchor ? "#" + anchor : location.pathname);
+    }
+    var e = document.getElementById(anchor);
+    if (e) {
+      $(e).parents(".collapse").add($(e).next(".collapse")).collapse("show");
+      e.scrollIntoView();
+    }
+  }
+  $(showAnchor);
+  $(window).on("hashchange", showAnchor);
+</script>
 </head>
 <body>
 <h1>Build Docs</h1>
 <div class="panel-group" id="accordion" role="tablist" aria-multiselectable="true">
-  {{range .}}
+  {{range .ModuleTypes}}
     {{ $collapseIndex := unique }}
-    <div class="panel panel-default">
+    {{ $moduleType := .Name }}
+    <div class="panel panel-default" id="{{$moduleType}}">
       <div class="panel-heading" role="tab" id="heading{{$collapseIndex}}">
         <h2 class="panel-title">
           <a class="collapsed" role="button" data-toggle="collapse" data-parent="#accordion" href="#collapse{{$collapseIndex}}" aria-expanded="false" aria-controls="collapse{{$collapseIndex}}">
@@ -649,10 +1430,11 @@ var (
     </div>
     <div id="collapse{{$collapseIndex}}" class="panel-collapse collapse" role="tabpanel" aria-labelledby="heading{{$collapseIndex}}">
       <div class="panel-body">
+        {{if .Extends}}<p>Extends <a href="#{{.Extends}}">{{.Extends}}</a>.</p>{{end}}
         <p>{{.Text}}</p>
         {{range .PropertyStructs}}
           <p>{{.Text}}</p>
-          {{template "properties" .Properties}}
+          {{template "properties" properties $moduleType .Properties}}
         {{end}}
       </div>
     </div>
@@ -662,11 +1444,14 @@ var (
 </html>
 
 {{define "properties"}}
+  {{$prefix := .Prefix}}
   <div class="panel-group" id="accordion" role="tablist" aria-multiselectable="true">
-    {{range .}}
+    {{range .Properties}}
       {{$collapseIndex := unique}}
+      {{$anchor := anchor $prefix .Name}}
       {{if .Properties}}
-        <div class="panel panel-default">
+        <div class="panel panel-default" id="{{$anchor}}">
+          {{range .OtherNames}}<a id="{{anchor $prefix .}}"></a>{{end}}
           <div class="panel-heading" role="tab" id="heading{{$collapseIndex}}">
             <h4 class="panel-title">
               <a class="collapsed" role="button" data-toggle="collapse" data-parent="#accordion" href="#collapse{{$collapseIndex}}" aria-expanded="false" aria-controls="collapse{{$collapseIndex}}">
@@ -679,12 +1464,13 @@ var (
           <div class="panel-body">
             <p>{{.Text}}</p>
             {{range .OtherTexts}}<p>{{.}}</p>{{end}}
-            {{template "properties" .Properties}}
+            {{template "properties" properties $anchor .Properties}}
           </div>
         </div>
       {{else}}
-        <div>
-          <h4>{{.Name}}{{range .OtherNames}}, {{.}}{{end}}</h4>
+        <div id="{{$anchor}}">
+          {{range .OtherNames}}<a id="{{anchor $prefix .}}"></a>{{end}}
+          <h4><a href="#{{$anchor}}">{{.Name}}</a>{{range .OtherNames}}, {{.}}{{end}}</h4>
           <p>{{.Text}}</p>
           {{range .OtherTexts}}<p>{{.}}</p>{{end}}
           <p><i>Type: {{.Type}}</i></p>
@@ -694,5 +1480,26 @@ var (
     {{end}}
   </div>
 {{end}}
+`
+
+	indexTemplate = `
+<html>
+<head>
+<title>Build Docs</title>
+<link rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.5/css/bootstrap.min.css">
+</head>
+<body>
+<h1>Build Docs</h1>
+<ul class="list-group">
+  {{range .}}
+    <li class="list-group-item">
+      <a href="{{.File}}">{{.Package}}</a>
+      {{$file := .File}}
+      <p>{{range $i, $name := .ModuleTypes}}{{if $i}}, {{end}}<a href="{{$file}}#{{$name}}">{{$name}}</a>{{end}}</p>
+    </li>
+  {{end}}
+</ul>
+</body>
+</html>
 `
 )
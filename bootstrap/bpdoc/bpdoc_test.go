@@ -0,0 +1,456 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bpdoc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+var updateAnchorsTestCases = []struct {
+	name      string
+	old       *anchorsState
+	anchors   []string
+	redirects map[string]string
+}{
+	{
+		name:      "no previous anchors",
+		anchors:   []string{"foo", "foo.srcs"},
+		redirects: map[string]string{},
+	},
+	{
+		name:      "unchanged",
+		old:       &anchorsState{Anchors: []string{"foo", "foo.srcs"}},
+		anchors:   []string{"foo", "foo.srcs"},
+		redirects: map[string]string{},
+	},
+	{
+		name:    "moved into a nested struct",
+		old:     &anchorsState{Anchors: []string{"foo", "foo.srcs"}},
+		anchors: []string{"foo", "foo.target", "foo.target.srcs"},
+		redirects: map[string]string{
+			"foo.srcs": "foo.target.srcs",
+		},
+	},
+	{
+		name:    "moved out of a nested struct",
+		old:     &anchorsState{Anchors: []string{"foo", "foo.target", "foo.target.srcs"}},
+		anchors: []string{"foo", "foo.srcs"},
+		redirects: map[string]string{
+			"foo.target":      "foo",
+			"foo.target.srcs": "foo.srcs",
+		},
+	},
+	{
+		name:    "removed property",
+		old:     &anchorsState{Anchors: []string{"foo", "foo.target", "foo.target.cflags"}},
+		anchors: []string{"foo", "foo.target"},
+		redirects: map[string]string{
+			"foo.target.cflags": "foo.target",
+		},
+	},
+	{
+		name: "ambiguous property",
+		old:  &anchorsState{Anchors: []string{"foo", "foo.srcs"}},
+		anchors: []string{"foo", "foo.host", "foo.host.srcs", "foo.target",
+			"foo.target.srcs"},
+		redirects: map[string]string{
+			"foo.srcs": "foo",
+		},
+	},
+	{
+		name:    "property of another module type",
+		old:     &anchorsState{Anchors: []string{"bar", "bar.srcs", "foo"}},
+		anchors: []string{"foo", "foo.srcs"},
+		redirects: map[string]string{
+			"bar":      "",
+			"bar.srcs": "",
+		},
+	},
+	{
+		name: "previous redirects",
+		old: &anchorsState{
+			Anchors: []string{"foo", "foo.target", "foo.target.srcs"},
+			Redirects: map[string]string{
+				"foo.srcs": "foo.target.srcs",
+				"bar":      "",
+			},
+		},
+		anchors: []string{"foo", "foo.target", "foo.target.srcs"},
+		redirects: map[string]string{
+			"foo.srcs": "foo.target.srcs",
+			"bar":      "",
+		},
+	},
+	{
+		name: "previous redirect to a removed anchor",
+		old: &anchorsState{
+			Anchors: []string{"foo", "foo.target", "foo.target.srcs"},
+			Redirects: map[string]string{
+				"foo.srcs": "foo.target.srcs",
+			},
+		},
+		anchors: []string{"foo", "foo.arch", "foo.arch.srcs"},
+		redirects: map[string]string{
+			"foo.srcs":        "foo.arch.srcs",
+			"foo.target":      "foo",
+			"foo.target.srcs": "foo.arch.srcs",
+		},
+	},
+	{
+		name: "redirected anchor restored",
+		old: &anchorsState{
+			Anchors: []string{"foo", "foo.target", "foo.target.srcs"},
+			Redirects: map[string]string{
+				"foo.srcs": "foo.target.srcs",
+			},
+		},
+		anchors: []string{"foo", "foo.srcs"},
+		redirects: map[string]string{
+			"foo.target":      "foo",
+			"foo.target.srcs": "foo.srcs",
+		},
+	},
+}
+
+func TestUpdateAnchors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpdoc_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, testCase := range updateAnchorsTestCases {
+		filename := filepath.Join(dir, testCase.name+".anchors.json")
+		if testCase.old != nil {
+			data, err := json.Marshal(testCase.old)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ioutil.WriteFile(filename, data, 0666)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		state, err := updateAnchors(filename, testCase.anchors)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", testCase.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(state.Anchors, testCase.anchors) {
+			t.Errorf("%s: expected anchors %q, got %q", testCase.name,
+				testCase.anchors, state.Anchors)
+		}
+		if !reflect.DeepEqual(state.Redirects, testCase.redirects) {
+			t.Errorf("%s: expected redirects %q, got %q", testCase.name,
+				testCase.redirects, state.Redirects)
+		}
+	}
+}
+
+func TestUpdateAnchorsFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpdoc_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// No anchors file is the same as a missing one.
+	for _, filename := range []string{"", filepath.Join(dir, "missing.anchors.json")} {
+		state, err := updateAnchors(filename, []string{"foo"})
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", filename, err)
+		} else if len(state.Redirects) != 0 {
+			t.Errorf("%q: unexpected redirects %q", filename, state.Redirects)
+		}
+	}
+
+	malformed := filepath.Join(dir, "malformed.anchors.json")
+	err = ioutil.WriteFile(malformed, []byte("{"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := updateAnchors(malformed, []string{"foo"}); err == nil {
+		t.Errorf("expected an error reading %s", malformed)
+	}
+}
+
+func TestPageRedirects(t *testing.T) {
+	redirects := map[string]string{
+		"foo.srcs":   "foo.target.srcs",
+		"bar.srcs":   "bar",
+		"old":        "",
+		"old_foo":    "foo",
+		"baz.cflags": "baz",
+	}
+	moduleTypes := []*moduleTypeDoc{{Name: "foo"}, {Name: "bar"}}
+
+	expected := map[string]string{
+		"foo.srcs": "foo.target.srcs",
+		"bar.srcs": "bar",
+		"old_foo":  "foo",
+	}
+	if got := pageRedirects(redirects, moduleTypes); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected redirects %q, got %q", expected, got)
+	}
+}
+
+func TestAnchorsFile(t *testing.T) {
+	if got, expected := AnchorsFile("out/docs/soong.html"), "out/docs/soong.anchors.json"; got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+// typeDocsTestPackages are the files of the packages resolved by TestTypeDocs.
+// Both files of package foo import a package as "other", but not the same one.
+var typeDocsTestPackages = map[string]map[string]string{
+	"foo": {
+		"foo1.go": `package foo
+
+import (
+	other "bar"
+	. "dot"
+)
+
+// Alias is an alias of bar.Props.
+type Alias = other.Props
+
+type Generic other.GenericProps[string]
+
+type Dotted DotProps
+
+type Local Alias
+`,
+		"foo2.go": `package foo
+
+import other "baz"
+
+type Other other.Props
+
+type Undotted DotProps
+`,
+	},
+	"bar": {
+		"bar.go": `package bar
+
+// Props are the properties of bar.
+type Props struct {
+	Bar_prop string
+}
+
+type GenericProps[T any] struct {
+	Generic_prop T
+}
+`,
+	},
+	"baz": {
+		"baz.go": `package baz
+
+type Props struct {
+	Baz_prop string
+}
+`,
+	},
+	"dot": {
+		"dot.go": `package dot
+
+type DotProps struct {
+	Dot_prop string
+}
+`,
+	},
+}
+
+var typeDocsTestCases = []struct {
+	name       string
+	text       string
+	properties []string
+	err        string
+}{
+	{
+		name:       "Alias",
+		text:       "Alias is an alias of bar.Props.\n",
+		properties: []string{"bar_prop"},
+	},
+	{
+		name:       "Generic",
+		text:       "",
+		properties: []string{"generic_prop"},
+	},
+	{
+		name:       "Dotted",
+		properties: []string{"dot_prop"},
+	},
+	{
+		name:       "Local",
+		text:       "Alias is an alias of bar.Props.\n",
+		properties: []string{"bar_prop"},
+	},
+	{
+		name:       "Other",
+		properties: []string{"baz_prop"},
+	},
+	{
+		// Only foo1.go dot imports dot.
+		name: "Undotted",
+		err:  `package "foo" type "DotProps" not found`,
+	},
+}
+
+func TestTypeDocs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bpdoc_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pkgFiles := make(map[string][]string)
+	for pkg, files := range typeDocsTestPackages {
+		err := os.MkdirAll(filepath.Join(dir, pkg), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, contents := range files {
+			file := filepath.Join(dir, pkg, name)
+			err := ioutil.WriteFile(file, []byte(contents), 0666)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pkgFiles[pkg] = append(pkgFiles[pkg], file)
+		}
+	}
+
+	docSet := NewDocCollector(pkgFiles)
+
+	for _, testCase := range typeDocsTestCases {
+		docs, err := docSet.typeDocs("foo", testCase.name)
+		if testCase.err != "" {
+			if err == nil || err.Error() != testCase.err {
+				t.Errorf("%s: expected error %q, got %v", testCase.name, testCase.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", testCase.name, err)
+			continue
+		}
+
+		if docs.Name != testCase.name {
+			t.Errorf("%s: expected name %q, got %q", testCase.name, testCase.name, docs.Name)
+		}
+		if docs.Text != testCase.text {
+			t.Errorf("%s: expected text %q, got %q", testCase.name, testCase.text, docs.Text)
+		}
+		var properties []string
+		for _, p := range docs.Properties {
+			properties = append(properties, p.Name)
+		}
+		if !reflect.DeepEqual(properties, testCase.properties) {
+			t.Errorf("%s: expected properties %q, got %q", testCase.name,
+				testCase.properties, properties)
+		}
+	}
+}
+
+// writeBenchmarkPackages writes numPackages packages of numFiles files, each
+// defining numStructs property structs, and returns their files by package.
+func writeBenchmarkPackages(b *testing.B, dir string, numPackages, numFiles,
+	numStructs int) map[string][]string {
+
+	pkgFiles := make(map[string][]string)
+	for p := 0; p < numPackages; p++ {
+		pkg := fmt.Sprintf("pkg%d", p)
+		pkgDir := filepath.Join(dir, pkg)
+		err := os.MkdirAll(pkgDir, 0777)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		for f := 0; f < numFiles; f++ {
+			buf := &bytes.Buffer{}
+			fmt.Fprintf(buf, "package %s\n", pkg)
+			for s := 0; s < numStructs; s++ {
+				fmt.Fprintf(buf, "\n// Props%d_%d are the properties of a module type.\n", f, s)
+				fmt.Fprintf(buf, "type Props%d_%d struct {\n", f, s)
+				fmt.Fprintln(buf, "\t// Srcs is the list of source files.")
+				fmt.Fprintln(buf, "\tSrcs []string")
+				fmt.Fprintln(buf, "\t// Target contains the properties for the target.")
+				fmt.Fprintln(buf, "\tTarget struct {")
+				fmt.Fprintln(buf, "\t\t// Cflags is the list of flags for the compiler.")
+				fmt.Fprintln(buf, "\t\tCflags []string")
+				fmt.Fprintln(buf, "\t}")
+				fmt.Fprintln(buf, "}")
+			}
+
+			file := filepath.Join(pkgDir, fmt.Sprintf("file%d.go", f))
+			err := ioutil.WriteFile(file, buf.Bytes(), 0666)
+			if err != nil {
+				b.Fatal(err)
+			}
+			pkgFiles[pkg] = append(pkgFiles[pkg], file)
+		}
+	}
+	return pkgFiles
+}
+
+// BenchmarkPackageDocs parses packages the way Write does, comparing a single
+// thread with all of them.
+func BenchmarkPackageDocs(b *testing.B) {
+	dir, err := ioutil.TempDir("", "bpdoc_test")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pkgFiles := writeBenchmarkPackages(b, dir, 16, 8, 20)
+
+	for _, bench := range []struct {
+		name  string
+		procs int
+	}{
+		{"serial", 1},
+		{"parallel", runtime.NumCPU()},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(bench.procs))
+
+			for i := 0; i < b.N; i++ {
+				docSet := NewDocCollector(pkgFiles)
+
+				var wg sync.WaitGroup
+				for pkg := range pkgFiles {
+					wg.Add(1)
+					go func(pkg string) {
+						defer wg.Done()
+						if _, err := docSet.packageDocs(pkg); err != nil {
+							b.Error(err)
+						}
+					}(pkg)
+				}
+				wg.Wait()
+			}
+		})
+	}
+}
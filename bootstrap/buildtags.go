@@ -0,0 +1,72 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"go/build"
+	"path/filepath"
+
+	"github.com/google/blueprint"
+)
+
+// buildContext returns the go/build context that selects the Go sources that
+// are compiled for the target.  The bootstrap binaries run on the machine that
+// builds them, so the target is the one that the running binary was built for,
+// or the one set by the GOOS and GOARCH environment variables like the goOS
+// and goArch variables.
+func (c *Config) buildContext() *build.Context {
+	ctx := build.Default
+
+	// The bootstrap rules compile Go sources only.
+	ctx.CgoEnabled = false
+
+	if c.testRace {
+		ctx.BuildTags = append(ctx.BuildTags, "race")
+	}
+
+	return &ctx
+}
+
+// filterGoSrcs returns the Go sources in srcs, which are relative to the module
+// directory, that are built for the target.  Like the go tool, it excludes
+// files with a _GOOS or _GOARCH suffix for another target, and files with
+// //go:build or // +build constraints that the target doesn't satisfy.
+//
+// The sources are added as dependencies of the Ninja file, since editing the
+// constraints of a source may change whether it is built.
+func filterGoSrcs(ctx blueprint.ModuleContext, config *Config, property string,
+	srcs []string) []string {
+
+	buildCtx := config.buildContext()
+	moduleDir := filepath.Join(filepath.Dir(config.topLevelBlueprintsFile),
+		ctx.ModuleDir())
+
+	var filtered []string
+	for _, src := range srcs {
+		dir, file := filepath.Split(filepath.Join(moduleDir, src))
+		match, err := buildCtx.MatchFile(dir, file)
+		if err != nil {
+			ctx.PropertyErrorf(property, "%s", err)
+			continue
+		}
+
+		ctx.AddNinjaFileDeps(filepath.Join(dir, file))
+		if match {
+			filtered = append(filtered, src)
+		}
+	}
+
+	return filtered
+}
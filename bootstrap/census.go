@@ -0,0 +1,121 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/google/blueprint"
+)
+
+// writeCensus writes a report of how many modules use each registered module
+// type and each of its properties to filename.  Module types and properties
+// that are never used are marked as unused.
+func writeCensus(ctx *blueprint.Context, filename string) error {
+	buf := bytes.NewBuffer(nil)
+
+	for _, census := range ctx.ModuleTypeCensus() {
+		fmt.Fprintf(buf, "%s: %d modules%s\n", census.Name, census.Modules,
+			unusedSuffix(census.Modules))
+
+		var names []string
+		for name := range census.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			count := census.Properties[name]
+			fmt.Fprintf(buf, "    %s: %d%s\n", name, count, unusedSuffix(count))
+		}
+	}
+
+	return ioutil.WriteFile(filename, buf.Bytes(), 0666)
+}
+
+// writeNinjaUsage writes a report of the Ninja variables and rules declared by
+// each Go package that no build statement uses to filename.
+func writeNinjaUsage(ctx *blueprint.Context, filename string) error {
+	usages, err := ctx.NinjaUsage()
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(nil)
+
+	for _, usage := range usages {
+		fmt.Fprintf(buf, "%s: %d of %d variables and %d of %d rules unused\n",
+			usage.PkgPath, len(usage.UnusedVariables), usage.Variables,
+			len(usage.UnusedRules), usage.Rules)
+
+		for _, name := range usage.UnusedVariables {
+			fmt.Fprintf(buf, "    variable %s\n", name)
+		}
+		for _, name := range usage.UnusedRules {
+			fmt.Fprintf(buf, "    rule %s\n", name)
+		}
+	}
+
+	return ioutil.WriteFile(filename, buf.Bytes(), 0666)
+}
+
+// largeFanIn is the number of inputs of a build statement above which
+// writeBuildMetrics marks it, as it likely dominates the time ninja spends
+// loading the Ninja file.
+const largeFanIn = 10000
+
+// writeBuildMetrics writes a report of the number and size of the inputs and
+// outputs of the build statements of each rule and module type to filename,
+// largest first.
+func writeBuildMetrics(ctx *blueprint.Context, filename string) error {
+	metrics, err := ctx.BuildMetrics()
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(nil)
+
+	writeSizes := func(name string, sizes blueprint.BuildSizes) {
+		fmt.Fprintf(buf, "    %s: %d builds, %d inputs (%d bytes), %d outputs (%d bytes)\n",
+			name, sizes.Builds, sizes.Inputs, sizes.InputBytes, sizes.Outputs,
+			sizes.OutputBytes)
+		if sizes.MaxInputs >= largeFanIn {
+			fmt.Fprintf(buf, "        large fan-in: %d inputs to %s\n",
+				sizes.MaxInputs, sizes.LargestBuild)
+		}
+	}
+
+	fmt.Fprintf(buf, "rules:\n")
+	for _, rule := range metrics.Rules {
+		writeSizes(rule.Rule, rule.BuildSizes)
+	}
+
+	fmt.Fprintf(buf, "module types:\n")
+	for _, moduleType := range metrics.ModuleTypes {
+		writeSizes(moduleType.ModuleType, moduleType.BuildSizes)
+	}
+
+	return ioutil.WriteFile(filename, buf.Bytes(), 0666)
+}
+
+func unusedSuffix(count int) string {
+	if count == 0 {
+		return " (unused)"
+	}
+	return ""
+}
@@ -19,6 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/google/blueprint"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -32,9 +33,9 @@ const logFileName = ".ninja_log"
 func removeAbandonedFiles(ctx *blueprint.Context, config *Config,
 	srcDir, manifestFile string) error {
 
-	buildDir := "."
+	buildDir := config.buildDir
 	if config.generatingBootstrapper {
-		buildDir = bootstrapDir
+		buildDir = filepath.Join(buildDir, bootstrapSubDir)
 	}
 
 	targetRules, err := ctx.AllTargets()
@@ -44,10 +45,13 @@ func removeAbandonedFiles(ctx *blueprint.Context, config *Config,
 
 	replacer := strings.NewReplacer(
 		"@@SrcDir@@", srcDir,
+		"@@BuildDir@@", config.buildDir,
 		"@@BootstrapManifest@@", manifestFile)
 	targets := make(map[string]bool)
 	for target := range targetRules {
-		replacedTarget := replacer.Replace(target)
+		// Ninja logs the targets with their paths cleaned, e.g. without the
+		// "./" that a build directory of "." adds.
+		replacedTarget := filepath.Clean(replacer.Replace(target))
 		targets[replacedTarget] = true
 	}
 
@@ -69,6 +73,65 @@ func removeAbandonedFiles(ctx *blueprint.Context, config *Config,
 	return nil
 }
 
+// removeDeletedModuleOutputs removes the outputs of the modules that are listed
+// in the cleanup manifest written by the previous run but no longer exist, and
+// then writes the current cleanup manifest.  Unlike removeAbandonedFiles, it
+// doesn't depend on the outputs having been built since the Ninja log was last
+// cleaned.
+func removeDeletedModuleOutputs(ctx *blueprint.Context, cleanupFile,
+	srcDir, buildDir, manifestFile string) error {
+
+	current, err := ctx.CleanupManifest()
+	if err != nil {
+		return err
+	}
+
+	previous, err := readCleanupManifest(cleanupFile)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", cleanupFile, err)
+	}
+
+	if previous != nil {
+		targetRules, err := ctx.AllTargets()
+		if err != nil {
+			return fmt.Errorf("error determining target list: %s", err)
+		}
+
+		replacer := strings.NewReplacer(
+			"@@SrcDir@@", srcDir,
+			"@@BuildDir@@", buildDir,
+			"@@BootstrapManifest@@", manifestFile)
+		for _, output := range previous.DeletedOutputs(current) {
+			if _, isTarget := targetRules[output]; isTarget {
+				continue
+			}
+			err = removeFileAndEmptyDirs(replacer.Replace(output))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return writeFileAtomicFunc(cleanupFile, 0666, func(w io.Writer) error {
+		return ctx.WriteCleanupManifest(w)
+	})
+}
+
+// readCleanupManifest reads the cleanup manifest written by the previous run,
+// or returns nil if there isn't one.
+func readCleanupManifest(cleanupFile string) (*blueprint.CleanupManifest, error) {
+	f, err := os.Open(cleanupFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	return blueprint.ReadCleanupManifest(f)
+}
+
 func parseNinjaLog(buildDir string) ([]string, error) {
 	logFilePath := filepath.Join(buildDir, logFileName)
 	logFile, err := os.Open(logFilePath)
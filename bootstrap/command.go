@@ -16,36 +16,147 @@ package bootstrap
 
 import (
 	"bytes"
+	"compress/gzip"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strings"
+	"time"
 
 	"github.com/google/blueprint"
+	"github.com/google/blueprint/bootstrap/bpdoc"
 	"github.com/google/blueprint/deptools"
 )
 
 var (
 	outFile      string
+	buildOutDir  string
 	depFile      string
 	checkFile    string
 	manifestFile string
 	docFile      string
 	cpuprofile   string
 	runGoTests   bool
+	testShards   int
+	testParallel int
+	testRace     bool
+	testCover    bool
+	testBench    bool
+	debugBuild   bool
+	cacheDir     string
+	minGoVersion string
+	goRootDir    string
+	externalFile string
+	docsStamp    string
+	skipDocs     bool
+	docsFormats  string
+	docsOutDir   string
+	docsSplit    bool
+	docsAnchors  string
+	parallelism  int
+	compress     bool
+	actionsFile  string
+	showStatus   bool
+	censusFile   string
+	usageFile    string
+	metricsFile  string
+	traceActions string
+	dumpModule   string
+	moduleGraph  string
+	listModules  string
+
+	dotGraph             string
+	dotGraphModuleTypes  string
+	dotGraphCollapseDirs bool
+
+	moduleGraphHTML string
+	moduleGraphRoot string
+
+	baselineFile   string
+	updateBaseline bool
+	strictWarnings bool
+
+	deprecationBaselineFile   string
+	updateDeprecationBaseline bool
+
+	verifyBuildActions bool
+	validateManifest   bool
+	analysisCacheDir   string
+	traceFile          string
+	subninjaDir        string
+	cleanupFile        string
+	testManifestFile   string
 )
 
 func init() {
 	flag.StringVar(&outFile, "o", "build.ninja.in", "the Ninja file to output")
+	flag.StringVar(&buildOutDir, "b", ".", "the build output directory, relative to the directory Ninja is run in")
 	flag.StringVar(&depFile, "d", "", "the dependency file to output")
 	flag.StringVar(&checkFile, "c", "", "the existing file to check against")
 	flag.StringVar(&manifestFile, "m", "", "the bootstrap manifest file")
 	flag.StringVar(&docFile, "docs", "", "build documentation file to output")
 	flag.StringVar(&cpuprofile, "cpuprofile", "", "write cpu profile to file")
 	flag.BoolVar(&runGoTests, "t", false, "build and run go tests during bootstrap")
+	flag.IntVar(&testShards, "test-shards", 1, "number of processes to split each package's go tests across")
+	flag.IntVar(&testParallel, "test-parallel", 0, "value of -test.parallel for go tests (0 uses the default)")
+	flag.BoolVar(&testRace, "test-race", false, "run go tests with the race detector, building all of the bootstrap go code with it")
+	flag.BoolVar(&testCover, "test-cover", false, "write a coverage profile for each package's go tests and a merged report")
+	flag.BoolVar(&testBench, "test-bench", false, "run each benchmark of the go tests once along with the tests")
+	flag.BoolVar(&debugBuild, "debug", false, "build the bootstrap binaries without optimizations for debugging")
+	flag.StringVar(&cacheDir, "cache-dir", "", "directory in which to cache the bootstrap packages and binaries")
+	flag.StringVar(&minGoVersion, "min-go-version", "", "the oldest Go toolchain version to allow, e.g. go1.4")
+	flag.StringVar(&goRootDir, "goroot", "", "the root of the Go toolchain that builds the bootstrap binaries, whose version is checked against -min-go-version")
+	flag.StringVar(&externalFile, "external-ninja", "", "Ninja file written by an external primary builder to include in the output")
+	flag.StringVar(&docsStamp, "docs-stamp", "", "file to update when the build documentation needs to be regenerated")
+	flag.BoolVar(&skipDocs, "skip-docs", false, "don't generate build documentation")
+	flag.StringVar(&docsFormats, "docs-formats", defaultDocsFormats, "comma-separated formats of the build documentation: html, md, json")
+	flag.StringVar(&docsOutDir, "docs-dir", "", "directory to write the build documentation to")
+	flag.BoolVar(&docsSplit, "docs-split", false, "write the build documentation for each package to a separate file")
+	flag.StringVar(&docsAnchors, "docs-anchors", "", "the anchors of a previous version of the HTML build documentation, whose renamed anchors are redirected")
+	flag.IntVar(&parallelism, "j", 0, "maximum number of parallel jobs (0 uses GOMAXPROCS)")
+	flag.BoolVar(&compress, "z", false, "write the Ninja file gzip-compressed with a loader stub (Ninja still decompresses it to disk)")
+	flag.StringVar(&actionsFile, "actions", "", "the JSON action graph file to output")
+	flag.BoolVar(&showStatus, "status", false, "print progress to stderr while generating")
+	flag.StringVar(&censusFile, "census", "", "module type usage report file to output")
+	flag.StringVar(&usageFile, "ninja-usage", "", "unused Ninja variable and rule report file to output")
+	flag.StringVar(&metricsFile, "metrics", "", "report file of the inputs and outputs of each rule and module type to output")
+	flag.StringVar(&traceActions, "trace-actions", "", "directory to which actiontrace writes the spans of the build actions")
+	flag.StringVar(&moduleGraph, "module-graph", "", "the JSON module graph file to output")
+	flag.StringVar(&dotGraph, "dot-graph", "", "the DOT module graph file to output")
+	flag.StringVar(&dotGraphModuleTypes, "dot-graph-types", "", "comma-separated module types to limit the -dot-graph file to")
+	flag.BoolVar(&dotGraphCollapseDirs, "dot-graph-collapse-dirs", false, "merge the modules in each directory into one node in the -dot-graph file")
+	flag.StringVar(&moduleGraphHTML, "module-graph-html", "", "the HTML page for exploring the dependencies of the -module-graph-root module to output")
+	flag.StringVar(&moduleGraphRoot, "module-graph-root", "", "the module whose dependencies are shown by the -module-graph-html page")
+	flag.StringVar(&dumpModule, "dump-module", "", "print the properties, variants, deps and outputs of the named module as JSON instead of generating the Ninja file")
+	flag.StringVar(&listModules, "list-modules", "", "list the modules whose names match the glob instead of generating the Ninja file")
+	flag.StringVar(&baselineFile, "baseline", "", "the file listing known warnings")
+	flag.BoolVar(&updateBaseline, "update-baseline", false, "write the current warnings to the -baseline file")
+	flag.StringVar(&deprecationBaselineFile, "deprecations", "", "the file listing known uses of deprecated module types and properties")
+	flag.BoolVar(&updateDeprecationBaseline, "update-deprecations", false, "write the current uses of deprecated module types and properties to the -deprecations file and exit")
+	flag.BoolVar(&verifyBuildActions, "verify-build-actions", false, "check the build actions for conflicting and empty outputs before writing the Ninja file")
+	flag.BoolVar(&validateManifest, "validate-manifest", false, "check that the strings in the Ninja file are valid UTF-8 and within Ninja's limits before writing it")
+	flag.StringVar(&analysisCacheDir, "analysis-cache-dir", "", "directory in which to cache the parsed Blueprints files between runs")
+	flag.StringVar(&subninjaDir, "subninja-dir", "", "directory to write the build actions of each directory to as separate subninja files")
+	flag.StringVar(&cleanupFile, "cleanup-manifest", "", "the JSON file listing the outputs of each module, used to remove the outputs of deleted modules")
+	flag.StringVar(&testManifestFile, "test-manifest", "", "the JSON file listing the test modules to output")
+	flag.StringVar(&traceFile, "trace", "", "write a Chrome trace of the time spent in each phase to file")
+	flag.BoolVar(&strictWarnings, "strict-warnings", false, "treat warnings that are not in the -baseline file as errors")
+}
+
+// registerModuleTypes registers the bootstrap module types and singleton, which
+// all share config.
+func registerModuleTypes(ctx *blueprint.Context, config *Config) {
+	ctx.RegisterModuleType("bootstrap_go_package", newGoPackageModuleFactory(config))
+	ctx.RegisterModuleType("bootstrap_go_vendored_package", newGoVendoredPackageModuleFactory(config))
+	ctx.RegisterModuleType("bootstrap_go_binary", newGoBinaryModuleFactory(config))
+	ctx.RegisterModuleType("bootstrap_go_gen", newGoGenModuleFactory(config))
+	ctx.RegisterModuleType("bootstrap_external_primary_builder", newExternalPrimaryBuilderModuleFactory(config))
+	ctx.RegisterSingletonType("bootstrap", newSingletonFactory(config))
 }
 
 func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...string) {
@@ -55,6 +166,14 @@ func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...stri
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
+	handleInterrupts()
+
+	ctx.SetParallelism(parallelism)
+
+	if showStatus {
+		ctx.SetStatusLogger(blueprint.NewConsoleStatusLogger(os.Stderr, time.Second))
+	}
+
 	if cpuprofile != "" {
 		f, err := os.Create(cpuprofile)
 		if err != nil {
@@ -69,6 +188,63 @@ func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...stri
 		fatalf("no Blueprints file specified")
 	}
 
+	if compress && checkFile != "" {
+		fatalf("-z and -c cannot be used together")
+	}
+
+	if externalFile != "" {
+		// The Blueprints files contain modules for the external primary
+		// builder, which it handles itself.
+		ctx.SetIgnoreUnknownModuleTypes(true)
+	}
+
+	if cacheDir != "" {
+		var err error
+		cacheDir, err = filepath.Abs(cacheDir)
+		if err != nil {
+			fatalf("error finding cache directory: %s", err)
+		}
+	}
+
+	if minGoVersion != "" {
+		if goRootDir == "" {
+			fatalf("-min-go-version requires -goroot")
+		}
+
+		version, err := readGoVersion(goRootDir)
+		if err != nil {
+			fatalf("%s", err)
+		}
+
+		err = checkGoVersion(goRootDir, version, minGoVersion)
+		if err != nil {
+			fatalf("%s", err)
+		}
+	}
+
+	if testShards < 1 {
+		fatalf("-test-shards must be at least 1")
+	}
+
+	if (testRace || testCover || testBench) && !runGoTests {
+		fatalf("-test-race, -test-cover and -test-bench require -t")
+	}
+
+	formats := strings.Split(docsFormats, ",")
+	for _, format := range formats {
+		if _, err := bpdoc.ParseFormat(format); err != nil {
+			fatalf("invalid -docs-formats: %s", err)
+		}
+	}
+
+	// Two generators writing the same Ninja file would corrupt it, its
+	// depfile and the glob files.
+	err := acquireLock(outFile + ".lock")
+	if err != nil {
+		fatalf("%s", err)
+	}
+	defer releaseLock()
+
 	generatingBootstrapper := false
 	if c, ok := config.(ConfigInterface); ok {
 		generatingBootstrapper = c.GeneratingBootstrapper()
@@ -77,66 +253,319 @@ func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...stri
 	bootstrapConfig := &Config{
 		generatingBootstrapper: generatingBootstrapper,
 		topLevelBlueprintsFile: flag.Arg(0),
+		buildDir:               buildOutDir,
 		runGoTests:             runGoTests,
+		testShards:             testShards,
+		testParallel:           testParallel,
+		testRace:               testRace,
+		testCover:              testCover,
+		testBench:              testBench,
+		debugBuild:             debugBuild,
+		cacheDir:               cacheDir,
+		minGoVersion:           minGoVersion,
+		skipDocs:               skipDocs,
+		docsFormats:            formats,
+		docsDir:                docsOutDir,
+		docsSplit:              docsSplit,
+		docsAnchorsFile:        docsAnchors,
+		traceActionsDir:        traceActions,
+		subninjaDir:            subninjaDir,
+	}
+
+	workspace, err := readGoWorkspace(filepath.Dir(bootstrapConfig.topLevelBlueprintsFile))
+	if err != nil {
+		fatalf("error reading go.work: %s", err)
 	}
+	bootstrapConfig.goWorkspace = workspace
 
-	ctx.RegisterModuleType("bootstrap_go_package", newGoPackageModuleFactory(bootstrapConfig))
-	ctx.RegisterModuleType("bootstrap_go_binary", newGoBinaryModuleFactory(bootstrapConfig))
-	ctx.RegisterSingletonType("bootstrap", newSingletonFactory(bootstrapConfig))
+	registerModuleTypes(ctx, bootstrapConfig)
 
-	deps, errs := ctx.ParseBlueprintsFiles(bootstrapConfig.topLevelBlueprintsFile)
-	if len(errs) > 0 {
-		fatalErrors(errs)
+	// The intermediate and generated files of the primary builder's modules
+	// and the volatile files are written to the build directory too.
+	ctx.SetOutDir(buildOutDir)
+
+	// The bootstrap Ninja file builds actiontrace, so only the commands in the
+	// main one can be traced.  The transformed commands are written to the
+	// Ninja file as they are, where $buildDir isn't defined, so the tracer is
+	// given the real path of the bin directory.
+	if traceActions != "" && !generatingBootstrapper {
+		ctx.RegisterCommandTransformer(&actionTracer{
+			dir:    traceActions,
+			binDir: strings.Replace(bootstrapConfig.binDir(), "$buildDir", buildOutDir, 1),
+		})
+	}
+
+	if updateBaseline && baselineFile == "" {
+		fatalf("-update-baseline requires -baseline")
+	}
+
+	if updateDeprecationBaseline && deprecationBaselineFile == "" {
+		fatalf("-update-deprecations requires -deprecations")
+	}
+
+	ctx.SetStrictWarnings(strictWarnings)
+	ctx.SetVerifyBuildActions(verifyBuildActions)
+	ctx.SetValidateManifest(validateManifest)
+	ctx.SetAnalysisCacheDir(analysisCacheDir)
+	ctx.SetTraceFile(traceFile)
+
+	// The uses of deprecated module types and properties are warnings whose
+	// keys are in the format of the -deprecations file, so both files are
+	// part of the baseline.
+	var baseline []string
+	if baselineFile != "" {
+		keys, err := readBaselineFile(baselineFile, updateBaseline)
+		if err != nil {
+			fatalf("error reading %s: %s", baselineFile, err)
+		}
+		baseline = append(baseline, keys...)
+	}
+	if deprecationBaselineFile != "" && !updateDeprecationBaseline {
+		keys, err := readBaselineFile(deprecationBaselineFile, false)
+		if err != nil {
+			fatalf("error reading %s: %s", deprecationBaselineFile, err)
+		}
+		baseline = append(baseline, keys...)
+	}
+	if baselineFile != "" || deprecationBaselineFile != "" {
+		ctx.SetBaseline(baseline)
 	}
 
+	deps, errs := ctx.ParseBlueprintsFiles(bootstrapConfig.topLevelBlueprintsFile)
+	checkErrors(ctx, errs)
+
 	// Add extra ninja file dependencies
 	deps = append(deps, extraNinjaFileDeps...)
+	if workspace != nil {
+		deps = append(deps, workspace.files...)
+	}
+
+	// Regenerate the Ninja file when the Go toolchain is updated, so that the
+	// minimum version is checked again.
+	if goRootDir != "" {
+		if file := goVersionFile(goRootDir); file != "" {
+			deps = append(deps, file)
+		}
+	}
 
 	errs = ctx.ResolveDependencies(config)
-	if len(errs) > 0 {
-		fatalErrors(errs)
+
+	if updateDeprecationBaseline {
+		// The deprecations are checked before anything else, so the
+		// baseline is complete even if resolving dependencies failed.
+		err := writeDeprecationBaselineFile(ctx)
+		if err != nil {
+			fatalf("error writing %s: %s", deprecationBaselineFile, err)
+		}
+		return
+	}
+
+	checkErrors(ctx, errs)
+
+	if censusFile != "" {
+		err := writeCensus(ctx, censusFile)
+		if err != nil {
+			fatalErrors([]error{err})
+		}
+		return
 	}
 
 	if docFile != "" {
-		err := writeDocs(ctx, filepath.Dir(bootstrapConfig.topLevelBlueprintsFile), docFile)
+		err := writeDocs(ctx, filepath.Dir(bootstrapConfig.topLevelBlueprintsFile), docFile,
+			docsSplit, docsAnchors)
 		if err != nil {
 			fatalErrors([]error{err})
 		}
 		return
 	}
 
-	extraDeps, errs := ctx.PrepareBuildActions(config)
-	if len(errs) > 0 {
-		fatalErrors(errs)
+	if docsStamp != "" {
+		err := writeDocsStamp(ctx, filepath.Dir(bootstrapConfig.topLevelBlueprintsFile), docsStamp)
+		if err != nil {
+			fatalErrors([]error{err})
+		}
 	}
+
+	extraDeps, errs := ctx.PrepareBuildActions(config)
+	checkErrors(ctx, errs)
 	deps = append(deps, extraDeps...)
 
-	buf := bytes.NewBuffer(nil)
-	err := ctx.WriteBuildFile(buf)
+	if moduleGraph != "" {
+		buf := bytes.NewBuffer(nil)
+		err := ctx.WriteModuleGraphJSON(buf)
+		if err != nil {
+			fatalf("error generating module graph: %s", err)
+		}
+
+		err = ioutil.WriteFile(moduleGraph, buf.Bytes(), 0666)
+		if err != nil {
+			fatalf("error writing %s: %s", moduleGraph, err)
+		}
+	}
+
+	if dotGraph != "" {
+		opts := blueprint.DotGraphOptions{
+			CollapseDirs: dotGraphCollapseDirs,
+		}
+		if dotGraphModuleTypes != "" {
+			opts.ModuleTypes = strings.Split(dotGraphModuleTypes, ",")
+		}
+
+		buf := bytes.NewBuffer(nil)
+		err := ctx.WriteDotGraph(buf, opts)
+		if err != nil {
+			fatalf("error generating module graph: %s", err)
+		}
+
+		err = ioutil.WriteFile(dotGraph, buf.Bytes(), 0666)
+		if err != nil {
+			fatalf("error writing %s: %s", dotGraph, err)
+		}
+	}
+
+	if moduleGraphHTML != "" {
+		if moduleGraphRoot == "" {
+			fatalf("-module-graph-html requires -module-graph-root")
+		}
+
+		buf := bytes.NewBuffer(nil)
+		err := ctx.WriteModuleGraphHTML(buf, moduleGraphRoot)
+		if err != nil {
+			fatalf("error generating module graph: %s", err)
+		}
+
+		err = ioutil.WriteFile(moduleGraphHTML, buf.Bytes(), 0666)
+		if err != nil {
+			fatalf("error writing %s: %s", moduleGraphHTML, err)
+		}
+	}
+
+	if dumpModule != "" {
+		err := writeModuleDump(ctx, dumpModule, os.Stdout)
+		if err != nil {
+			fatalf("%s", err)
+		}
+		return
+	}
+
+	if listModules != "" {
+		err := writeModuleList(ctx, listModules, os.Stdout)
+		if err != nil {
+			fatalf("%s", err)
+		}
+		return
+	}
+
+	// The main Ninja file reruns the globs performed by modules and only
+	// updates their file lists when the results change, so it depends on the
+	// file lists rather than on the directories that were searched.  The
+	// bootstrap Ninja file doesn't rerun them.
+	globs := ctx.Globs()
+	if generatingBootstrapper {
+		for _, g := range globs {
+			deps = append(deps, g.Deps...)
+		}
+	} else {
+		err := writeGlobFiles(buildOutDir, globs)
+		if err != nil {
+			fatalf("error writing glob files: %s", err)
+		}
+		deps = append(deps, globFiles(buildOutDir, globs)...)
+	}
+
+	// The values of the volatile variables are written outside of the Ninja
+	// file, so that changing them doesn't change it.
+	err = writeVolatileFiles(ctx.VolatileFiles())
 	if err != nil {
-		fatalf("error generating Ninja file contents: %s", err)
+		fatalf("error writing volatile variables: %s", err)
 	}
 
+	if usageFile != "" {
+		err := writeNinjaUsage(ctx, usageFile)
+		if err != nil {
+			fatalErrors([]error{err})
+		}
+	}
+
+	if metricsFile != "" {
+		err := writeBuildMetrics(ctx, metricsFile)
+		if err != nil {
+			fatalErrors([]error{err})
+		}
+	}
+
+	if externalFile != "" {
+		externalDeps, err := deptools.ReadDepFile(externalFile + ".d")
+		if err != nil {
+			fatalf("error reading external primary builder depfile: %s", err)
+		}
+		deps = append(deps, externalDeps...)
+	}
+
+	for _, warning := range ctx.Warnings() {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+
+	if updateBaseline {
+		err := writeBaselineFile(ctx)
+		if err != nil {
+			fatalf("error writing %s: %s", baselineFile, err)
+		}
+	}
+
+	// The manifest is streamed to the output file rather than collected in
+	// memory, since it can be very large.
 	const outFilePermissions = 0666
-	err = ioutil.WriteFile(outFile, buf.Bytes(), outFilePermissions)
+	writeOutFile := func(w io.Writer) error {
+		err := ctx.WriteBuildFiles(w, createSubninjaFile)
+		if err != nil {
+			return err
+		}
+
+		if externalFile != "" {
+			_, err = fmt.Fprintf(w, "subninja %s\n", externalFile)
+		}
+		return err
+	}
+	if compress {
+		err = writeCompressedOutFile(writeOutFile, outFilePermissions)
+	} else {
+		err = writeFileAtomicFunc(outFile, outFilePermissions, writeOutFile)
+	}
 	if err != nil {
 		fatalf("error writing %s: %s", outFile, err)
 	}
 
-	if checkFile != "" {
-		checkData, err := ioutil.ReadFile(checkFile)
+	if actionsFile != "" {
+		buf := bytes.NewBuffer(nil)
+		err := ctx.WriteActionGraph(buf)
 		if err != nil {
-			fatalf("error reading %s: %s", checkFile, err)
+			fatalf("error generating action graph: %s", err)
 		}
 
-		matches := buf.Len() == len(checkData)
-		if matches {
-			for i, value := range buf.Bytes() {
-				if value != checkData[i] {
-					matches = false
-					break
-				}
-			}
+		err = ioutil.WriteFile(actionsFile, buf.Bytes(), outFilePermissions)
+		if err != nil {
+			fatalf("error writing %s: %s", actionsFile, err)
+		}
+	}
+
+	if testManifestFile != "" {
+		buf := bytes.NewBuffer(nil)
+		err := ctx.WriteTestManifest(buf)
+		if err != nil {
+			fatalf("error generating test manifest: %s", err)
+		}
+
+		err = ioutil.WriteFile(testManifestFile, buf.Bytes(), outFilePermissions)
+		if err != nil {
+			fatalf("error writing %s: %s", testManifestFile, err)
+		}
+	}
+
+	if checkFile != "" {
+		matches, err := outFileMatches(checkFile)
+		if err != nil {
+			fatalf("error comparing %s to %s: %s", outFile, checkFile, err)
 		}
 
 		if matches {
@@ -167,10 +596,121 @@ func Main(ctx *blueprint.Context, config interface{}, extraNinjaFileDeps ...stri
 	if err != nil {
 		fatalf("error removing abandoned files: %s", err)
 	}
+
+	if cleanupFile != "" {
+		err = removeDeletedModuleOutputs(ctx, cleanupFile, srcDir, buildOutDir,
+			manifestFile)
+		if err != nil {
+			fatalf("error removing outputs of deleted modules: %s", err)
+		}
+	}
+}
+
+// readBaselineFile returns the warning keys listed in filename.  A missing
+// file is treated as an empty baseline if missingOK is true, which it is when
+// the file is being updated.
+func readBaselineFile(filename string, missingOK bool) ([]string, error) {
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) && missingOK {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return blueprint.ReadBaseline(f)
+}
+
+// writeBaselineFile writes the keys of the warnings that have been found so far
+// to the -baseline file.
+func writeBaselineFile(ctx *blueprint.Context) error {
+	buf := bytes.NewBuffer(nil)
+	err := blueprint.WriteBaseline(buf, ctx.WarningKeys())
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(baselineFile, buf.Bytes(), 0666)
+}
+
+// writeDeprecationBaselineFile writes every use of a deprecated module type or
+// property to the -deprecations file.
+func writeDeprecationBaselineFile(ctx *blueprint.Context) error {
+	buf := bytes.NewBuffer(nil)
+	err := blueprint.WriteBaseline(buf, ctx.DeprecatedUses())
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(deprecationBaselineFile, buf.Bytes(), 0666)
+}
+
+// checkErrors exits if there are any errors.  When the baseline is being
+// updated it is written first, so that warnings that failed because they were
+// not in the baseline will be allowed by the next run.
+func checkErrors(ctx *blueprint.Context, errs []error) {
+	if len(errs) == 0 {
+		return
+	}
+
+	if updateBaseline {
+		err := writeBaselineFile(ctx)
+		if err != nil {
+			fatalf("error writing %s: %s", baselineFile, err)
+		}
+	}
+
+	fatalErrors(errs)
+}
+
+// outFileMatches returns whether the manifest text written to outFile is the
+// same as the contents of checkFile.
+func outFileMatches(checkFile string) (bool, error) {
+	checkData, err := ioutil.ReadFile(checkFile)
+	if err != nil {
+		return false, err
+	}
+
+	data, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(data, checkData), nil
+}
+
+// writeCompressedOutFile writes the Ninja file written by write, compressed
+// with gzip, to outFile with a ".gz" suffix, and a loader stub that
+// decompresses it to outFile.  The first Ninja run replaces the stub with the
+// full Ninja file, so this only shrinks the output until then.  The subninja
+// files are not compressed.
+func writeCompressedOutFile(write func(w io.Writer) error, perm os.FileMode) error {
+	compressedFile := outFile + ".gz"
+
+	err := writeFileAtomicFunc(compressedFile, perm, func(w io.Writer) error {
+		gw := gzip.NewWriter(w)
+		err := write(gw)
+		if err != nil {
+			return err
+		}
+		return gw.Close()
+	})
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err = blueprint.WriteBuildFileLoader(buf, outFile, compressedFile)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(outFile, buf.Bytes(), perm)
 }
 
 func fatalf(format string, args ...interface{}) {
 	fmt.Printf(format, args...)
+	releaseLock()
 	os.Exit(1)
 }
 
@@ -183,5 +723,6 @@ func fatalErrors(errs []error) {
 			_, _ = fmt.Printf("internal error: %s\n", err)
 		}
 	}
+	releaseLock()
 	os.Exit(1)
 }
@@ -0,0 +1,75 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteCompressedOutFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "command_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	savedOutFile := outFile
+	defer func() { outFile = savedOutFile }()
+	outFile = filepath.Join(dir, "build.ninja")
+
+	const manifest = "subninja external.ninja\n"
+	err = writeCompressedOutFile(func(w io.Writer) error {
+		_, err := io.WriteString(w, manifest)
+		return err
+	}, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(outFile + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != manifest {
+		t.Errorf("expected compressed manifest %q, got %q", manifest, string(data))
+	}
+
+	loader, err := ioutil.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The loader is newer than the compressed manifest, so it must always
+	// decompress it rather than relying on the timestamps.
+	expected := fmt.Sprintf("build %s.gz.always: phony\n", outFile)
+	if !strings.Contains(string(loader), expected) {
+		t.Errorf("expected loader to contain %q, got %q", expected, string(loader))
+	}
+}
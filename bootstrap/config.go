@@ -14,15 +14,23 @@
 
 package bootstrap
 
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint/bootstrap/bpdoc"
+)
+
 var (
 	// These variables are the only configuration needed by the boostrap
 	// modules.  They are always set to the variable name enclosed in "@@" so
 	// that their values can be easily replaced in the generated Ninja file.
 	srcDir            = pctx.StaticVariable("srcDir", "@@SrcDir@@")
+	buildDir          = pctx.StaticVariable("buildDir", "@@BuildDir@@")
 	goRoot            = pctx.StaticVariable("goRoot", "@@GoRoot@@")
 	goOS              = pctx.StaticVariable("goOS", "@@GoOS@@")
 	goArch            = pctx.StaticVariable("goArch", "@@GoArch@@")
-	goChar            = pctx.StaticVariable("goChar", "@@GoChar@@")
 	bootstrapCmd      = pctx.StaticVariable("bootstrapCmd", "@@Bootstrap@@")
 	bootstrapManifest = pctx.StaticVariable("bootstrapManifest",
 		"@@BootstrapManifest@@")
@@ -31,6 +39,10 @@ var (
 		"$goRoot/pkg/tool/${goOS}_$goArch")
 )
 
+// The comma-separated formats that the documentation is generated in if no
+// others are requested.
+const defaultDocsFormats = "html"
+
 type ConfigInterface interface {
 	// GeneratingBootstrapper should return true if this build invocation is
 	// creating a build.ninja.in file to be used in a build bootstrapping
@@ -46,5 +58,223 @@ type Config struct {
 
 	topLevelBlueprintsFile string
 
+	// buildDir is the directory that all of the outputs are written to,
+	// relative to the directory that Ninja is run in.  It is the value that
+	// the bootstrap script substitutes for @@BuildDir@@, which the Ninja files
+	// pass back to minibp and the primary builder with -b.
+	buildDir string
+
 	runGoTests bool
+
+	// testShards is the number of processes that each package's tests are
+	// split across, and testParallel is the value passed to -test.parallel,
+	// or 0 to use the default.
+	testShards   int
+	testParallel int
+
+	// testRace should be true if the go tests should be run with the race
+	// detector.  Every package linked into a test must be built with it, so
+	// all of the bootstrap packages and binaries are.
+	testRace bool
+
+	// testCover should be true if the go tests should write a coverage
+	// profile for each package, which are merged into coverProfileFile.
+	testCover bool
+
+	// testBench should be true if each benchmark of the go tests should be
+	// run once along with the tests.
+	testBench bool
+
+	// debugBuild should be true if the bootstrap modules should be built
+	// without optimizations for debugging.  Their intermediate files are kept
+	// separately from the ones for the normal build.
+	debugBuild bool
+
+	// cacheDir is the absolute path of a directory in which to share the
+	// compiled bootstrap packages and binaries between output directories,
+	// or "" if they shouldn't be cached.
+	cacheDir string
+
+	// minGoVersion is the oldest Go toolchain version that the bootstrap
+	// modules may be built with, or "" if any version is allowed.
+	minGoVersion string
+
+	// skipDocs should be true if the documentation for the primary builder's
+	// module types should not be generated.
+	skipDocs bool
+
+	// docsFormats lists the formats to generate the documentation in, as
+	// accepted by bpdoc.ParseFormat.
+	docsFormats []string
+
+	// docsDir is the directory to write the documentation to, or "" to
+	// write it to the default location in the bootstrap directory.
+	docsDir string
+
+	// docsSplit should be true if the documentation for each package should
+	// be written to a separate file, with an index of them.
+	docsSplit bool
+
+	// docsAnchorsFile is the file recording the anchors of a previous version
+	// of the HTML documentation, normally in the source tree, or "" if there
+	// isn't one.  See bpdoc.Write.
+	docsAnchorsFile string
+
+	// traceActionsDir is the directory that actiontrace writes the spans of
+	// the build actions in the main Ninja file to, or "" to not trace them.
+	traceActionsDir string
+
+	// subninjaDir is the directory to write the build actions of the modules
+	// in each directory of the main Ninja file to as separate subninja files,
+	// or "" to write them all to the main Ninja file.
+	subninjaDir string
+
+	// goWorkspace describes the go.work file next to the top-level Blueprints
+	// file, or is nil if there isn't one.
+	goWorkspace *goWorkspace
+}
+
+// bootstrapFlags returns the command line flags that pass the bootstrap
+// settings on to the next stage of the bootstrap process.
+func (c *Config) bootstrapFlags() string {
+	var flags []string
+
+	if c.runGoTests {
+		flags = append(flags, "-t")
+		if c.testShards > 1 {
+			flags = append(flags, fmt.Sprintf("-test-shards %d", c.testShards))
+		}
+		if c.testParallel > 0 {
+			flags = append(flags, fmt.Sprintf("-test-parallel %d", c.testParallel))
+		}
+		if c.testRace {
+			flags = append(flags, "-test-race")
+		}
+		if c.testCover {
+			flags = append(flags, "-test-cover")
+		}
+		if c.testBench {
+			flags = append(flags, "-test-bench")
+		}
+	}
+
+	if c.debugBuild {
+		flags = append(flags, "-debug")
+	}
+
+	if c.cacheDir != "" {
+		flags = append(flags, "-cache-dir "+c.cacheDir)
+	}
+
+	if c.minGoVersion != "" {
+		// The version is checked against the toolchain in $goRoot, which
+		// builds the bootstrap binaries, rather than the one that built the
+		// next stage.
+		flags = append(flags, "-min-go-version "+c.minGoVersion,
+			"-goroot $goRoot")
+	}
+
+	if c.skipDocs {
+		flags = append(flags, "-skip-docs")
+	}
+
+	if formats := strings.Join(c.docsFormats, ","); formats != defaultDocsFormats {
+		flags = append(flags, "-docs-formats "+formats)
+	}
+
+	if c.docsDir != "" {
+		flags = append(flags, "-docs-dir "+c.docsDir)
+	}
+
+	if c.docsSplit {
+		flags = append(flags, "-docs-split")
+	}
+
+	if c.docsAnchorsFile != "" {
+		flags = append(flags, "-docs-anchors "+c.docsAnchorsFile)
+	}
+
+	if c.traceActionsDir != "" {
+		flags = append(flags, "-trace-actions "+c.traceActionsDir)
+	}
+
+	if c.subninjaDir != "" {
+		flags = append(flags, "-subninja-dir "+c.subninjaDir)
+	}
+
+	return strings.Join(flags, " ")
+}
+
+// docsFiles returns the documentation files to generate for the primary builder
+// named name, one for each format.  If the documentation is split by package
+// they are the index files.
+func (c *Config) docsFiles(name string) []string {
+	dir := c.docsDir
+	if dir == "" {
+		dir = docsDir
+	}
+
+	var files []string
+	for _, format := range c.docsFormats {
+		if c.docsSplit {
+			files = append(files, filepath.Join(dir, name, "index."+format))
+		} else {
+			files = append(files, filepath.Join(dir, name+"."+format))
+		}
+	}
+
+	return files
+}
+
+// docsOutputs returns the files that are written when the primary builder
+// generates docsFile, which include the anchors of the HTML documentation.
+func docsOutputs(docsFile string) []string {
+	outputs := []string{docsFile}
+	if filepath.Ext(docsFile) == bpdoc.HTML.Ext() {
+		outputs = append(outputs, bpdoc.AnchorsFile(docsFile))
+	}
+	return outputs
+}
+
+// binDir returns the directory that the bootstrap binaries are copied to.
+func (c *Config) binDir() string {
+	if c.debugBuild {
+		return debugBinDir
+	}
+	return BinDir
+}
+
+// gcFlags returns the compiler flags for a module that sets the gcflags
+// property to moduleFlags.
+func (c *Config) gcFlags(moduleFlags []string) []string {
+	if c.debugBuild {
+		// Disable optimizations and inlining so that the binaries can be
+		// stepped through in a debugger.
+		moduleFlags = append([]string{"-N", "-l"}, moduleFlags...)
+	}
+
+	if c.testRace {
+		moduleFlags = append([]string{"-race"}, moduleFlags...)
+	}
+
+	return moduleFlags
+}
+
+// ldFlags returns the linker flags for a module that sets the ldflags property
+// to moduleFlags.
+func (c *Config) ldFlags(moduleFlags []string) []string {
+	if c.testRace {
+		return append([]string{"-race"}, moduleFlags...)
+	}
+
+	return moduleFlags
+}
+
+// testCoverMode returns the mode that the packages are instrumented with for
+// coverage, which must be atomic if the tests are run with the race detector.
+func (c *Config) testCoverMode() string {
+	if c.testRace {
+		return "atomic"
+	}
+	return "set"
 }
@@ -18,7 +18,7 @@
 //
 //   1. A Ninja binary
 //   2. A script interpreter (e.g. Bash or Python)
-//   3. A Go toolchain
+//   3. A Go toolchain, version 1.11 or later
 //
 // The Primary Builder
 //
@@ -33,6 +33,11 @@
 // module property set to true in its Blueprints file.  If more than one module
 // sets primaryBuilder to true the build will fail.
 //
+// Like the go tool, the bootstrap Go modules only compile the files in their
+// 'srcs' and 'testSrcs' properties whose _GOOS and _GOARCH suffixes and
+// //go:build constraints match the target, so OS-specific files can be listed
+// alongside the others.
+//
 // The primary builder main function should look something like:
 //
 //   package main
@@ -71,6 +76,23 @@
 //       bootstrap.Main(ctx, config)
 //   }
 //
+// Primary Builders in Other Languages
+//
+// A primary builder that isn't written in Go is described by a module of type
+// 'bootstrap_external_primary_builder' instead, whose 'cmd' property is the
+// path of the executable and whose 'srcs' property lists any other files it is
+// made of.  It is run from the build directory as:
+//
+//   <cmd> -o <ninja file> -d <depfile> <top-level Blueprints file>
+//
+// It must write the build actions for the source tree to the Ninja file, and
+// write a gcc-style depfile listing every file that it read to the depfile.  It
+// should print any errors to stderr and exit with a non-zero status.  minibp
+// then generates the main Ninja file, which contains the rules that rerun the
+// bootstrap process when anything changes and includes the primary builder's
+// Ninja file with a subninja statement.  Module types that minibp doesn't know
+// about are ignored, so the primary builder can define its own.
+//
 // Required Source Files
 //
 // There are three files that must be included in the source tree to facilitate
@@ -118,13 +140,28 @@
 //
 //   @@SrcDir@@            - The path to the root source directory (either
 //                           absolute or relative to the build dir)
+//   @@BuildDir@@          - The path to the build output directory (either
+//                           absolute or relative to the directory Ninja is
+//                           run in)
 //   @@GoRoot@@            - The path to the root directory of the Go toolchain
 //   @@GoOS@@              - The OS string for the Go toolchain
 //   @@GoArch@@            - The CPU architecture for the Go toolchain
-//   @@GoChar@@            - The CPU arch character for the Go toolchain
 //   @@Bootstrap@@         - The path to the bootstrap script
 //   @@BootstrapManifest@@ - The path to the source bootstrap Ninja file
 //
+// By default the build output directory is the directory that the script is
+// run in.  If the BUILDDIR environment variable is set the script writes
+// build.ninja to that directory instead, and every output of the build,
+// including the .bootstrap directory, the documentation and the Ninja logs, is
+// written under it, so the source directory can be on a read-only filesystem.
+// Ninja is then run with "ninja -f $BUILDDIR/build.ninja" from the directory
+// that the script was run in.
+//
+// If the build directory contains a .bootstrap directory whose layout_version
+// file doesn't match the layout version in the bootstrap Ninja file, the
+// script removes the .bootstrap directory first, so that files laid out by
+// another version of Blueprint don't break the build.
+//
 // Once the script completes the build directory is initialized in the bootstrap
 // build state.  In this state, running Ninja may perform the following build
 // actions.  Each one but the last can be skipped if its output is determined to
@@ -0,0 +1,88 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/google/blueprint"
+)
+
+// writeModuleDump writes every variant of the module called name, with its
+// effective properties, dependencies and outputs, to w as a JSON list, to help
+// debug Blueprints files and mutators.
+func writeModuleDump(ctx *blueprint.Context, name string, w io.Writer) error {
+	graph, err := ctx.ModuleGraph()
+	if err != nil {
+		return err
+	}
+
+	variants := []*blueprint.ModuleGraphModule{}
+	for _, module := range graph.Modules {
+		if module.Name == name {
+			variants = append(variants, module)
+		}
+	}
+
+	if len(variants) == 0 {
+		return fmt.Errorf("unknown module %q", name)
+	}
+
+	data, err := json.MarshalIndent(variants, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// writeModuleList writes the name, type and Blueprints file of every module
+// whose name matches pattern to w, one module per line.  The pattern uses the
+// syntax of filepath.Match.
+func writeModuleList(ctx *blueprint.Context, pattern string, w io.Writer) error {
+	graph, err := ctx.ModuleGraph()
+	if err != nil {
+		return err
+	}
+
+	prev := ""
+	for _, module := range graph.Modules {
+		// Variants of a module are adjacent in the graph, only list the
+		// module once.
+		if module.Name == prev {
+			continue
+		}
+		prev = module.Name
+
+		match, err := filepath.Match(pattern, module.Name)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+		if !match {
+			continue
+		}
+
+		_, err = fmt.Fprintf(w, "%s %s %s\n", module.Name, module.Type, module.Blueprints)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
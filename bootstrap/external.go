@@ -0,0 +1,63 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"path/filepath"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/pathtools"
+)
+
+// An externalPrimaryBuilder is a module for a primary builder that isn't
+// written in Go.  See the package documentation for the protocol that it must
+// follow.
+type externalPrimaryBuilder struct {
+	properties struct {
+		// The primary builder executable, relative to the module directory.
+		Cmd string
+
+		// Other files that the primary builder is made of, e.g. modules for
+		// a scripting language, relative to the module directory.  Changes
+		// to them cause the Ninja file to be regenerated.
+		Srcs []string
+	}
+
+	cmd  string
+	srcs []string
+}
+
+func newExternalPrimaryBuilderModuleFactory(config *Config) func() (blueprint.Module, []interface{}) {
+	return func() (blueprint.Module, []interface{}) {
+		module := &externalPrimaryBuilder{}
+		return module, []interface{}{&module.properties}
+	}
+}
+
+func isExternalPrimaryBuilder(module blueprint.Module) bool {
+	_, ok := module.(*externalPrimaryBuilder)
+	return ok
+}
+
+func (e *externalPrimaryBuilder) GenerateBuildActions(ctx blueprint.ModuleContext) {
+	if e.properties.Cmd == "" {
+		ctx.PropertyErrorf("cmd", "must be set")
+		return
+	}
+
+	srcDir := moduleSrcDir(ctx)
+	e.cmd = filepath.Join(srcDir, e.properties.Cmd)
+	e.srcs = pathtools.PrefixPaths(e.properties.Srcs, srcDir)
+}
@@ -0,0 +1,139 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/pathtools"
+)
+
+type goGeneratedSrcsProducer interface {
+	GeneratedGoSrcs() []string
+}
+
+func isGoGeneratedSrcsProducer(module blueprint.Module) bool {
+	_, ok := module.(goGeneratedSrcsProducer)
+	return ok
+}
+
+// A goGen is a module that runs a command to generate Go source files, e.g.
+// with protoc or stringer.  The generated .go files are compiled into every
+// bootstrap_go_package or bootstrap_go_binary module that lists it in deps.
+type goGen struct {
+	properties struct {
+		// The command that generates the outputs.  It may use $in for the
+		// srcs, $out for the outputs, $genDir for the directory that the
+		// outputs are written to, $depfile for the dependency file if
+		// depfile is set, and $binDir for the directory that contains the
+		// bootstrap_go_binary modules listed in deps.
+		Cmd string
+
+		// The input files, relative to the module directory.
+		Srcs []string
+
+		// The output files, relative to the generated source directory.
+		Out []string
+
+		// Whether the command writes a GCC-style dependency file listing
+		// additional inputs to $depfile.
+		Depfile bool
+	}
+
+	outputs []string
+
+	// The bootstrap Config
+	config *Config
+}
+
+var _ goGeneratedSrcsProducer = (*goGen)(nil)
+
+func newGoGenModuleFactory(config *Config) func() (blueprint.Module, []interface{}) {
+	return func() (blueprint.Module, []interface{}) {
+		module := &goGen{
+			config: config,
+		}
+		return module, []interface{}{&module.properties}
+	}
+}
+
+func (g *goGen) GeneratedGoSrcs() []string {
+	var srcs []string
+	for _, output := range g.outputs {
+		if strings.HasSuffix(output, ".go") {
+			srcs = append(srcs, output)
+		}
+	}
+	return srcs
+}
+
+func (g *goGen) GenerateBuildActions(ctx blueprint.ModuleContext) {
+	if g.properties.Cmd == "" {
+		ctx.PropertyErrorf("cmd", "must be set")
+		return
+	}
+
+	if len(g.properties.Out) == 0 {
+		ctx.PropertyErrorf("out", "must list at least one output")
+		return
+	}
+
+	genDir := filepath.Join(intermediatesDir(ctx, g.config), "gen")
+	g.outputs = pathtools.PrefixPaths(g.properties.Out, genDir)
+
+	// Like the other bootstrap modules, the generator is only run when
+	// generating the bootstrap Ninja file.
+	if !g.config.generatingBootstrapper {
+		return
+	}
+
+	var tools []string
+	ctx.VisitDirectDepsIf(isBootstrapBinaryModule,
+		func(module blueprint.Module) {
+			tools = append(tools,
+				filepath.Join(g.config.binDir(), ctx.OtherModuleName(module)))
+		})
+
+	params := blueprint.RuleParams{
+		Command:     g.properties.Cmd,
+		Description: "gen " + ctx.ModuleName(),
+	}
+
+	argNames := []string{"genDir"}
+	args := map[string]string{
+		"genDir": genDir,
+	}
+
+	// The dependency file is passed as an argument so that the command can
+	// refer to it as $depfile.
+	if g.properties.Depfile {
+		params.Depfile = "$depfile"
+		params.Deps = blueprint.DepsGCC
+		argNames = append(argNames, "depfile")
+		args["depfile"] = filepath.Join(genDir, ctx.ModuleName()+".d")
+	}
+
+	rule := ctx.Rule(pctx, "gen", params, argNames...)
+
+	ctx.Build(pctx, blueprint.BuildParams{
+		Rule:      rule,
+		Outputs:   g.outputs,
+		Inputs:    pathtools.PrefixPaths(g.properties.Srcs, moduleSrcDir(ctx)),
+		Implicits: tools,
+		Args:      args,
+	})
+}
@@ -0,0 +1,104 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"crypto/md5"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint"
+	"github.com/google/blueprint/pathtools"
+)
+
+// The globs performed by modules with ModuleContext.Glob are rechecked by the
+// main Ninja file.  For every glob the primary builder writes a file listing
+// the files that matched it and a depfile listing the directories that were
+// searched.  The main Ninja file reruns the glob with bpglob whenever one of
+// the directories changes, and bpglob only rewrites the file list if the
+// result of the glob changed.  The file lists are dependencies of the main
+// Ninja file, so it is regenerated when a file matching one of the globs is
+// added or removed, but not when an unrelated file in a searched directory is.
+
+var (
+	bpglobFile = filepath.Join("$binDir", "bpglob")
+
+	globRule = pctx.StaticRule("glob",
+		blueprint.RuleParams{
+			Command:     fmt.Sprintf("%s -o $out -d $out.d $excludes $pattern", bpglobFile),
+			Description: "glob $pattern",
+			Depfile:     "$out.d",
+			Restat:      true,
+		},
+		"pattern", "excludes")
+)
+
+// globFile returns the file in buildDir that lists the files that match g.
+// The Ninja files refer to it with a buildDir of "$buildDir", and the primary
+// builder writes it with the value of that variable.
+func globFile(buildDir string, g blueprint.GlobPath) string {
+	hash := md5.Sum([]byte(g.Pattern + "\x00" + strings.Join(g.Excludes, "\x00")))
+	return filepath.Join(buildDir, bootstrapSubDir, "globs", fmt.Sprintf("%x", hash))
+}
+
+// globFiles returns the files in buildDir that list the files that match globs.
+func globFiles(buildDir string, globs []blueprint.GlobPath) []string {
+	var files []string
+	for _, g := range globs {
+		files = append(files, globFile(buildDir, g))
+	}
+	return files
+}
+
+// writeGlobFiles writes the file lists and depfiles for globs to buildDir, so
+// that they exist before the main Ninja file that rechecks them runs.
+func writeGlobFiles(buildDir string, globs []blueprint.GlobPath) error {
+	for _, g := range globs {
+		fileListFile := globFile(buildDir, g)
+		_, err := pathtools.GlobWithDepFile(g.Pattern, fileListFile,
+			fileListFile+".d", g.Excludes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateGlobBuildActions adds the build statements that recheck globs.
+func generateGlobBuildActions(ctx blueprint.SingletonContext,
+	globs []blueprint.GlobPath) {
+
+	for _, g := range globs {
+		var excludes []string
+		for _, exclude := range g.Excludes {
+			excludes = append(excludes, "-e "+ninjaEscape(shellQuote(exclude)))
+		}
+
+		ctx.Build(pctx, blueprint.BuildParams{
+			Rule:      globRule,
+			Outputs:   []string{globFile("$buildDir", g)},
+			Implicits: []string{bpglobFile},
+			Args: map[string]string{
+				"pattern":  ninjaEscape(shellQuote(g.Pattern)),
+				"excludes": strings.Join(excludes, " "),
+			},
+		})
+	}
+}
+
+func ninjaEscape(s string) string {
+	return strings.Replace(s, "$", "$$", -1)
+}
@@ -0,0 +1,123 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// goVersionFile returns the path of the file that records the version of the
+// Go toolchain in goRoot, or "" if it doesn't have one.  Development
+// toolchains built from source don't have a VERSION file.
+func goVersionFile(goRoot string) string {
+	file := filepath.Join(goRoot, "VERSION")
+	if _, err := os.Stat(file); err != nil {
+		return ""
+	}
+	return file
+}
+
+// readGoVersion returns the version of the Go toolchain in goRoot, which is
+// the one that the bootstrap binaries are built with rather than the one that
+// built the running binary.  It is read from the first line of the VERSION
+// file, or from "go env GOVERSION" if there isn't one.
+func readGoVersion(goRoot string) (string, error) {
+	if file := goVersionFile(goRoot); file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[:i]
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	out, err := exec.Command(filepath.Join(goRoot, "bin", "go"), "env",
+		"GOVERSION").Output()
+	if err != nil {
+		return "", fmt.Errorf("error finding the version of the Go toolchain "+
+			"in %s: %s", goRoot, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// checkGoVersion returns an error if version, the version of the Go toolchain
+// in goRoot, is older than minVersion, e.g. "go1.4".  Development versions
+// are assumed to be new enough.
+func checkGoVersion(goRoot, version, minVersion string) error {
+	min, ok := parseGoVersion(minVersion)
+	if !ok {
+		return fmt.Errorf("invalid minimum Go version %q", minVersion)
+	}
+
+	v, ok := parseGoVersion(version)
+	if !ok {
+		return nil
+	}
+
+	for i := range min {
+		n := 0
+		if i < len(v) {
+			n = v[i]
+		}
+
+		if n > min[i] {
+			break
+		} else if n < min[i] {
+			return fmt.Errorf("Go toolchain %s in %s is older than the "+
+				"minimum supported version %s", version, goRoot, minVersion)
+		}
+	}
+
+	return nil
+}
+
+// parseGoVersion returns the numeric components of a release version like
+// "go1.4.2".  Any suffix like "beta1" or "rc2" on the last component, and
+// anything after the first space, is ignored.
+func parseGoVersion(version string) ([]int, bool) {
+	if fields := strings.Fields(version); len(fields) > 0 {
+		version = fields[0]
+	}
+
+	if !strings.HasPrefix(version, "go") {
+		return nil, false
+	}
+
+	var ret []int
+	for _, s := range strings.Split(version[2:], ".") {
+		if i := strings.IndexFunc(s, func(r rune) bool {
+			return r < '0' || r > '9'
+		}); i >= 0 {
+			s = s[:i]
+		}
+
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, false
+		}
+		ret = append(ret, n)
+	}
+
+	return ret, true
+}
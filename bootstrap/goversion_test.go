@@ -0,0 +1,104 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var parseGoVersionTestCases = []struct {
+	version string
+	ret     []int
+	ok      bool
+}{
+	{"go1.4", []int{1, 4}, true},
+	{"go1.4.2", []int{1, 4, 2}, true},
+	{"go1.21.0", []int{1, 21, 0}, true},
+	{"go1.5beta1", []int{1, 5}, true},
+	{"go1.10rc2", []int{1, 10}, true},
+	{"go1.22.1 X:boringcrypto", []int{1, 22, 1}, true},
+	{"devel +a1b2c3d Tue Jan 1 00:00:00 2019 +0000", nil, false},
+	{"1.4", nil, false},
+	{"go", nil, false},
+	{"go1..4", nil, false},
+	{"", nil, false},
+}
+
+func TestParseGoVersion(t *testing.T) {
+	for _, testCase := range parseGoVersionTestCases {
+		ret, ok := parseGoVersion(testCase.version)
+		if ok != testCase.ok || !reflect.DeepEqual(ret, testCase.ret) {
+			t.Errorf("parseGoVersion(%q): expected %v, %v, got %v, %v",
+				testCase.version, testCase.ret, testCase.ok, ret, ok)
+		}
+	}
+}
+
+var checkGoVersionTestCases = []struct {
+	version    string
+	minVersion string
+	err        string
+}{
+	{"go1.4", "go1.4", ""},
+	{"go1.4.2", "go1.4", ""},
+	{"go1.5", "go1.4.2", ""},
+	{"go1.10", "go1.9", ""},
+	{"go1.4", "go1.4.0", ""},
+	{"go1.5beta1", "go1.5", ""},
+	{"devel +a1b2c3d", "go1.4", ""},
+	{"go1.3", "go1.4", "Go toolchain go1.3 in /goroot is older than the minimum supported version go1.4"},
+	{"go1.4.1", "go1.4.2", "Go toolchain go1.4.1 in /goroot is older than the minimum supported version go1.4.2"},
+	{"go1.9", "go1.10", "Go toolchain go1.9 in /goroot is older than the minimum supported version go1.10"},
+	{"go1.4", "1.4", `invalid minimum Go version "1.4"`},
+}
+
+func TestCheckGoVersion(t *testing.T) {
+	for _, testCase := range checkGoVersionTestCases {
+		err := checkGoVersion("/goroot", testCase.version, testCase.minVersion)
+		if (err == nil && testCase.err != "") ||
+			(err != nil && err.Error() != testCase.err) {
+
+			t.Errorf("checkGoVersion(%q, %q): expected error %q, got %v",
+				testCase.version, testCase.minVersion, testCase.err, err)
+		}
+	}
+}
+
+func TestReadGoVersion(t *testing.T) {
+	goRoot, err := ioutil.TempDir("", "goversion_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(goRoot)
+
+	// Since Go 1.21 the VERSION file has more lines after the version.
+	err = ioutil.WriteFile(filepath.Join(goRoot, "VERSION"),
+		[]byte("go1.21.0\ntime 2023-08-08T15:00:00Z\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := readGoVersion(goRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "go1.21.0" {
+		t.Errorf("expected version %q, got %q", "go1.21.0", version)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A goWorkspace describes the Go modules listed by the use directives of a
+// go.work file.  It is used to find the import path of a bootstrap_go_package
+// that doesn't set pkgPath from the directory that contains its sources.
+type goWorkspace struct {
+	modules []goModule
+
+	// files lists the go.work file and the go.mod file of each module, so
+	// that the Ninja file is regenerated when any of them change.
+	files []string
+}
+
+type goModule struct {
+	dir  string // The module directory, relative to the source directory.
+	path string // The module path from its go.mod file.
+}
+
+// readGoWorkspace reads the go.work file in srcDir and the go.mod files of the
+// modules that it uses.  It returns nil if there is no go.work file.
+func readGoWorkspace(srcDir string) (*goWorkspace, error) {
+	workFile := filepath.Join(srcDir, "go.work")
+
+	dirs, err := readGoModFile(workFile, "use")
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	w := &goWorkspace{
+		files: []string{workFile},
+	}
+
+	for _, dir := range dirs {
+		dir = filepath.Clean(filepath.FromSlash(dir))
+		if filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("%s: module directory %q must be relative",
+				workFile, dir)
+		}
+
+		modFile := filepath.Join(srcDir, dir, "go.mod")
+		paths, err := readGoModFile(modFile, "module")
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) != 1 {
+			return nil, fmt.Errorf("%s: expected one module directive, found %d",
+				modFile, len(paths))
+		}
+
+		w.modules = append(w.modules, goModule{dir, paths[0]})
+		w.files = append(w.files, modFile)
+	}
+
+	return w, nil
+}
+
+// pkgPath returns the import path of the package in dir, which is relative to
+// the source directory, using the module that most closely contains it.
+func (w *goWorkspace) pkgPath(dir string) (string, bool) {
+	dir = filepath.Clean(dir)
+
+	var best *goModule
+	var bestRel string
+	for i := range w.modules {
+		module := &w.modules[i]
+		rel, err := filepath.Rel(module.dir, dir)
+		if err != nil || rel == ".." ||
+			strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if best == nil || len(module.dir) > len(best.dir) {
+			best, bestRel = module, rel
+		}
+	}
+
+	if best == nil {
+		return "", false
+	}
+
+	if bestRel == "." {
+		return best.path, true
+	}
+	return best.path + "/" + filepath.ToSlash(bestRel), true
+}
+
+// readGoModFile returns the arguments of every directive named directive in a
+// go.mod or go.work file, including the ones in a parenthesized block.
+func readGoModFile(file, directive string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var args []string
+	block := "" // The directive of the parenthesized block being read, if any.
+	lineNum := 0
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		lineNum++
+
+		fields := goModFields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if block != "" {
+			if fields[0] == ")" {
+				block = ""
+				continue
+			}
+			if block != directive {
+				continue
+			}
+		} else {
+			if len(fields) == 2 && fields[1] == "(" {
+				block = fields[0]
+				continue
+			}
+			if fields[0] != directive {
+				continue
+			}
+			fields = fields[1:]
+		}
+
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("%s:%d: malformed %s directive", file, lineNum,
+				directive)
+		}
+
+		arg := fields[0]
+		if strings.HasPrefix(arg, `"`) || strings.HasPrefix(arg, "`") {
+			arg, err = strconv.Unquote(arg)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", file, lineNum, err)
+			}
+		}
+		args = append(args, arg)
+	}
+
+	return args, s.Err()
+}
+
+// goModFields splits a line of a go.mod or go.work file into fields, keeping
+// each quoted string in one field and dropping any comment.
+func goModFields(line string) []string {
+	var fields []string
+	for {
+		line = strings.TrimLeft(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "//") {
+			return fields
+		}
+
+		end := strings.IndexAny(line, " \t\r")
+		switch line[0] {
+		case '"':
+			// Skip escaped quotes.  An unterminated string runs to the end of
+			// the line, and fails to unquote.
+			end = len(line)
+			for i := 1; i < len(line); i++ {
+				if line[i] == '\\' {
+					i++
+				} else if line[i] == '"' {
+					end = i + 1
+					break
+				}
+			}
+		case '`':
+			if i := strings.IndexByte(line[1:], '`'); i >= 0 {
+				end = i + 2
+			} else {
+				end = len(line)
+			}
+		}
+		if end < 0 {
+			end = len(line)
+		}
+
+		fields = append(fields, line[:end])
+		line = line[end:]
+	}
+}
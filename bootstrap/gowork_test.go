@@ -0,0 +1,255 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var readGoModFileTestCases = []struct {
+	name      string
+	contents  string
+	directive string
+	args      []string
+	err       string
+}{
+	{
+		name:      "module",
+		contents:  "module example.com/foo\n\ngo 1.21\n",
+		directive: "module",
+		args:      []string{"example.com/foo"},
+	},
+	{
+		name:      "quoted module",
+		contents:  "module \"example.com/foo\"\n",
+		directive: "module",
+		args:      []string{"example.com/foo"},
+	},
+	{
+		name: "comments",
+		contents: "// module example.com/bar\n" +
+			"module example.com/foo // Deprecated: use example.com/baz\n",
+		directive: "module",
+		args:      []string{"example.com/foo"},
+	},
+	{
+		name:      "use directives",
+		contents:  "go 1.21\n\nuse ./foo\nuse bar\n",
+		directive: "use",
+		args:      []string{"./foo", "bar"},
+	},
+	{
+		name: "use block",
+		contents: "go 1.21\n\nuse (\n" +
+			"\t./foo // The foo module.\n" +
+			"\t// ./old\n" +
+			"\n" +
+			"\t\"./bar baz\"\n" +
+			")\n",
+		directive: "use",
+		args:      []string{"./foo", "./bar baz"},
+	},
+	{
+		name: "replace directives",
+		contents: "module example.com/foo\n\n" +
+			"replace example.com/bar => ./bar\n" +
+			"replace example.com/baz v1.0.0 => example.com/qux v1.1.0\n",
+		directive: "module",
+		args:      []string{"example.com/foo"},
+	},
+	{
+		name: "replace block",
+		contents: "use ./foo\n\n" +
+			"replace (\n" +
+			"\tuse => ./use\n" +
+			"\texample.com/bar => ./bar\n" +
+			")\n\n" +
+			"use ./bar\n",
+		directive: "use",
+		args:      []string{"./foo", "./bar"},
+	},
+	{
+		name: "require block",
+		contents: "module example.com/foo\n\n" +
+			"require (\n" +
+			"\tmodule v1.0.0\n" +
+			")\n",
+		directive: "module",
+		args:      []string{"example.com/foo"},
+	},
+	{
+		name:      "no directive",
+		contents:  "go 1.21\n",
+		directive: "use",
+		args:      nil,
+	},
+	{
+		name:      "malformed directive",
+		contents:  "go 1.21\n\nuse ./foo ./bar\n",
+		directive: "use",
+		err:       "malformed.mod:3: malformed use directive",
+	},
+	{
+		name:      "malformed quoting",
+		contents:  "module \"example.com/foo\n",
+		directive: "module",
+		err:       "malformed.mod:1: invalid syntax",
+	},
+}
+
+func TestReadGoModFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gowork_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, testCase := range readGoModFileTestCases {
+		file := filepath.Join(dir, "malformed.mod")
+		err := ioutil.WriteFile(file, []byte(testCase.contents), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		args, err := readGoModFile(file, testCase.directive)
+		if testCase.err != "" {
+			expected := filepath.Join(dir, testCase.err)
+			if err == nil || err.Error() != expected {
+				t.Errorf("%s: expected error %q, got %v", testCase.name, expected, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", testCase.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, testCase.args) {
+			t.Errorf("%s: expected %q, got %q", testCase.name, testCase.args, args)
+		}
+	}
+}
+
+var readGoWorkspaceTestCases = []struct {
+	name    string
+	files   map[string]string
+	modules []goModule
+	err     string
+}{
+	{
+		name:  "no go.work",
+		files: map[string]string{"foo/go.mod": "module example.com/foo\n"},
+	},
+	{
+		name: "modules",
+		files: map[string]string{
+			"go.work": "go 1.21\n\nuse (\n\t.\n\t./foo\n\tbar/baz\n)\n\n" +
+				"replace example.com/qux => ./qux\n",
+			"go.mod":         "module example.com/root\n",
+			"foo/go.mod":     "// The foo module.\nmodule example.com/foo\n",
+			"bar/baz/go.mod": "module example.com/baz\n\nrequire example.com/foo v1.0.0\n",
+		},
+		modules: []goModule{
+			{".", "example.com/root"},
+			{"foo", "example.com/foo"},
+			{filepath.Join("bar", "baz"), "example.com/baz"},
+		},
+	},
+	{
+		name: "missing go.mod",
+		files: map[string]string{
+			"go.work": "use ./foo\n",
+		},
+		err: "foo/go.mod: no such file or directory",
+	},
+	{
+		name: "missing module directive",
+		files: map[string]string{
+			"go.work":    "use ./foo\n",
+			"foo/go.mod": "go 1.21\n",
+		},
+		err: "foo/go.mod: expected one module directive, found 0",
+	},
+	{
+		name: "absolute module directory",
+		files: map[string]string{
+			"go.work": "use /foo\n",
+		},
+		err: `go.work: module directory "/foo" must be relative`,
+	},
+}
+
+func TestReadGoWorkspace(t *testing.T) {
+	for _, testCase := range readGoWorkspaceTestCases {
+		func() {
+			srcDir, err := ioutil.TempDir("", "gowork_test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(srcDir)
+
+			for name, contents := range testCase.files {
+				file := filepath.Join(srcDir, name)
+				err := os.MkdirAll(filepath.Dir(file), 0777)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = ioutil.WriteFile(file, []byte(contents), 0666)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			w, err := readGoWorkspace(srcDir)
+			if testCase.err != "" {
+				// Errors name files by their paths in the source directory.
+				if err == nil || !strings.HasSuffix(err.Error(), testCase.err) {
+					t.Errorf("%s: expected error %q, got %v", testCase.name, testCase.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", testCase.name, err)
+				return
+			}
+
+			if testCase.modules == nil {
+				if w != nil {
+					t.Errorf("%s: expected no workspace, got %v", testCase.name, w.modules)
+				}
+				return
+			}
+
+			if !reflect.DeepEqual(w.modules, testCase.modules) {
+				t.Errorf("%s: expected modules %v, got %v", testCase.name,
+					testCase.modules, w.modules)
+			}
+
+			expectedFiles := []string{filepath.Join(srcDir, "go.work")}
+			for _, module := range testCase.modules {
+				expectedFiles = append(expectedFiles,
+					filepath.Join(srcDir, module.dir, "go.mod"))
+			}
+			if !reflect.DeepEqual(w.files, expectedFiles) {
+				t.Errorf("%s: expected files %q, got %q", testCase.name,
+					expectedFiles, w.files)
+			}
+		}()
+	}
+}
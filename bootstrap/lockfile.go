@@ -0,0 +1,105 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+var (
+	// lockFile is the lock file held by this process, or nil if it doesn't
+	// hold one.
+	lockFileLock sync.Mutex
+	lockFile     *os.File
+)
+
+// acquireLock takes an exclusive flock on filename, creating it if necessary,
+// so that a second generator writing the same Ninja file fails instead of
+// corrupting the files written by the first.  The kernel releases the lock
+// when the process holding it exits, however it exits, so a lock is never
+// left behind.  The lock is advisory; it only stops other processes that call
+// acquireLock.
+func acquireLock(filename string) error {
+	f, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		f.Close()
+
+		// The PID is only for the error message.  It may not have been
+		// written yet if the other generator has only just taken the lock.
+		msg := "another generator"
+		if pid := readLockFile(filename); pid > 0 {
+			msg += fmt.Sprintf(" (pid %d)", pid)
+		}
+		return fmt.Errorf("%s is writing to this output directory", msg)
+	} else if err != nil {
+		f.Close()
+		return fmt.Errorf("error locking %s: %s", filename, err)
+	}
+
+	// The file is never removed, as a generator that opened it before it was
+	// removed could then lock it at the same time as one that recreated it.
+	err = f.Truncate(0)
+	if err == nil {
+		_, err = f.WriteString(fmt.Sprintf("%d\n", os.Getpid()))
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	lockFileLock.Lock()
+	lockFile = f
+	lockFileLock.Unlock()
+
+	return nil
+}
+
+// releaseLock releases the lock taken by acquireLock, if there is one.
+func releaseLock() {
+	lockFileLock.Lock()
+	defer lockFileLock.Unlock()
+
+	if lockFile != nil {
+		// Closing the file releases the flock.
+		lockFile.Close()
+		lockFile = nil
+	}
+}
+
+// readLockFile returns the PID in a lock file, or 0 if the file can't be read
+// or is malformed.
+func readLockFile(filename string) int {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
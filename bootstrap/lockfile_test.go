@@ -0,0 +1,91 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockContention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lockfile_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "build.ninja.lock")
+
+	err = acquireLock(filename)
+	if err != nil {
+		t.Fatalf("unexpected error acquiring lock: %s", err)
+	}
+
+	// flock locks belong to open files, so a second acquireLock conflicts
+	// with the first even within a single process.
+	holder := lockFile
+	err = acquireLock(filename)
+	expected := fmt.Sprintf("another generator (pid %d) is writing to this output directory",
+		os.Getpid())
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+	if lockFile != holder {
+		t.Errorf("failed acquireLock replaced the held lock")
+	}
+
+	releaseLock()
+
+	err = acquireLock(filename)
+	if err != nil {
+		t.Errorf("unexpected error acquiring released lock: %s", err)
+	}
+	releaseLock()
+}
+
+func TestStaleLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lockfile_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "build.ninja.lock")
+
+	// A lock file left behind by a generator that has exited is not locked,
+	// whatever PID it contains.
+	for _, contents := range []string{"", "garbage\n", "1\n", fmt.Sprintf("%d\n", os.Getpid())} {
+		err := ioutil.WriteFile(filename, []byte(contents), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = acquireLock(filename)
+		if err != nil {
+			t.Errorf("lock file containing %q: unexpected error: %s", contents, err)
+			continue
+		}
+
+		if pid := readLockFile(filename); pid != os.Getpid() {
+			t.Errorf("lock file containing %q: expected pid %d, got %d",
+				contents, os.Getpid(), pid)
+		}
+
+		releaseLock()
+	}
+}
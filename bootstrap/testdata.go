@@ -0,0 +1,95 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// undeclaredTestData returns the files and directories in testDir that are
+// named by string literals in the test sources, relative to testDir, but
+// aren't listed in testData or contain a file that is.  The tests are run in
+// a directory that only contains the testData files, so they can't open them.
+// Not every such literal is a file that the tests open, so the names are only
+// reported when the tests fail.
+func undeclaredTestData(testDir string, testSrcs []string, testData []string) []string {
+	declared := make(map[string]bool)
+	for _, data := range testData {
+		for dir := filepath.Clean(data); dir != "." && dir != ".."; dir = filepath.Dir(dir) {
+			declared[dir] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	var undeclared []string
+
+	fset := token.NewFileSet()
+	for _, src := range testSrcs {
+		file, err := parser.ParseFile(fset, src, nil, 0)
+		if err != nil {
+			// The compiler reports the errors in the test sources.
+			continue
+		}
+
+		ast.Inspect(file, func(n ast.Node) bool {
+			lit, ok := n.(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+
+			name, err := strconv.Unquote(lit.Value)
+			if err != nil || name == "" || filepath.IsAbs(name) {
+				return true
+			}
+			name = filepath.Clean(name)
+			if name == "." || name == ".." ||
+				strings.HasPrefix(name, ".."+string(filepath.Separator)) ||
+				declared[name] || seen[name] {
+				return true
+			}
+
+			if _, err := os.Stat(filepath.Join(testDir, name)); err == nil {
+				seen[name] = true
+				undeclared = append(undeclared, name)
+			}
+			return true
+		})
+	}
+
+	sort.Strings(undeclared)
+	return undeclared
+}
+
+// testDataNote returns a command that prints a note naming the undeclared
+// files, or an empty string if there aren't any.  testDir may refer to Ninja
+// variables.
+func testDataNote(testDir string, undeclared []string) string {
+	if len(undeclared) == 0 {
+		return ""
+	}
+
+	note := fmt.Sprintf("note: the tests run in %s, which only contains the files "+
+		"listed in testdata.  The tests refer to these files, which aren't listed: %s",
+		testDir, ninjaEscape(strings.Join(undeclared, " ")))
+	return "echo " + shellQuote(note) + " >&2; "
+}
@@ -0,0 +1,97 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const undeclaredTestDataTestSrc = `package lib
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const golden = "testdata/golden.txt"
+
+func TestLib(t *testing.T) {
+	ioutil.ReadFile("testdata/input.txt")
+	ioutil.ReadFile(golden)
+	ioutil.ReadFile(filepath.Join("fixtures", "a.txt"))
+	ioutil.ReadFile("./config.json")
+	ioutil.ReadFile("missing.txt")
+	ioutil.ReadFile("../other/file.txt")
+	ioutil.ReadFile("/etc/passwd")
+	t.Log("config.json", ".", "")
+}
+`
+
+var undeclaredTestDataTestCases = []struct {
+	name       string
+	testData   []string
+	undeclared []string
+}{
+	{
+		name:       "no testdata",
+		undeclared: []string{"config.json", "fixtures", "testdata/golden.txt", "testdata/input.txt"},
+	},
+	{
+		name:       "some testdata",
+		testData:   []string{"testdata/input.txt", "fixtures/a.txt"},
+		undeclared: []string{"config.json", "testdata/golden.txt"},
+	},
+	{
+		name: "all testdata",
+		testData: []string{"config.json", "fixtures/a.txt", "testdata/golden.txt",
+			"testdata/input.txt"},
+		undeclared: nil,
+	},
+}
+
+func TestUndeclaredTestData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testdata_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestSources(t, dir, map[string]string{
+		"lib/lib_test.go":            undeclaredTestDataTestSrc,
+		"lib/config.json":            "{}",
+		"lib/fixtures/a.txt":         "a",
+		"lib/testdata/input.txt":     "input",
+		"lib/testdata/golden.txt":    "golden",
+		"other/file.txt":             "file",
+		"lib/testdata/unused.txt":    "unused",
+		"lib/testdata/sub/other.txt": "other",
+	})
+
+	testDir := filepath.Join(dir, "lib")
+	testSrcs := []string{filepath.Join(testDir, "lib_test.go")}
+
+	for _, testCase := range undeclaredTestDataTestCases {
+		undeclared := undeclaredTestData(testDir, testSrcs, testCase.testData)
+		if !reflect.DeepEqual(undeclared, testCase.undeclared) {
+			t.Errorf("%s: expected %q, got %q", testCase.name, testCase.undeclared,
+				undeclared)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/blueprint"
+)
+
+// writeVolatileFiles writes the files that hold the values of the volatile
+// variables.  A file whose value hasn't changed is left alone, so that its
+// modification time doesn't make Ninja rerun the build statements that stamp
+// the value into their outputs.
+func writeVolatileFiles(files []blueprint.VolatileFile) error {
+	for _, file := range files {
+		data := []byte(file.Value)
+
+		old, err := ioutil.ReadFile(file.Path)
+		if err == nil && bytes.Equal(old, data) {
+			continue
+		}
+
+		err = os.MkdirAll(filepath.Dir(file.Path), 0777)
+		if err != nil {
+			return err
+		}
+
+		err = writeFileAtomic(file.Path, data, 0666)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
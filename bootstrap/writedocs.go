@@ -1,15 +1,135 @@
 package bootstrap
 
 import (
+	"bytes"
+	"crypto/sha1"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/google/blueprint"
 	"github.com/google/blueprint/bootstrap/bpdoc"
 	"github.com/google/blueprint/pathtools"
 )
 
-func writeDocs(ctx *blueprint.Context, srcDir, filename string) error {
+// writeDocs writes the documentation for the module types to filename, in the
+// format given by its extension.  If split is true the documentation for each
+// package is written to a separate file next to filename, and filename is an
+// index of them.  The HTML documentation redirects the anchors in anchorsFile
+// that are no longer present.
+func writeDocs(ctx *blueprint.Context, srcDir, filename string, split bool,
+	anchorsFile string) error {
+
+	format, err := bpdoc.ParseFormat(strings.TrimPrefix(filepath.Ext(filename), "."))
+	if err != nil {
+		return err
+	}
+
+	pkgFiles, err := docsPkgFiles(ctx, srcDir)
+	if err != nil {
+		return err
+	}
+
+	return bpdoc.Write(filename, format, split, anchorsFile, pkgFiles,
+		ctx.ModuleTypePropertyStructs(), ctx.ModuleTypeBases())
+}
+
+// writeDocsStamp writes a hash of everything that the docs written by
+// writeDocs are generated from to filename.  The file is left untouched if
+// the hash hasn't changed, so that the docs are only regenerated when the
+// module types or the sources of the packages that define them change.
+func writeDocsStamp(ctx *blueprint.Context, srcDir, filename string) error {
+	pkgFiles, err := docsPkgFiles(ctx, srcDir)
+	if err != nil {
+		return err
+	}
+
+	h := sha1.New()
+
+	propertyStructs := ctx.ModuleTypePropertyStructs()
+	var typeNames []string
+	for typeName := range propertyStructs {
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+
+	moduleTypeBases := ctx.ModuleTypeBases()
+	for _, typeName := range typeNames {
+		fmt.Fprintf(h, "module type %s\n", typeName)
+		if baseName, ok := moduleTypeBases[typeName]; ok {
+			fmt.Fprintf(h, "extends %s\n", baseName)
+		}
+		for _, p := range propertyStructs[typeName] {
+			writeTypeSignature(h, reflect.TypeOf(p))
+			fmt.Fprintln(h)
+		}
+	}
+
+	var pkgPaths []string
+	for pkgPath := range pkgFiles {
+		pkgPaths = append(pkgPaths, pkgPath)
+	}
+	sort.Strings(pkgPaths)
+
+	for _, pkgPath := range pkgPaths {
+		fmt.Fprintf(h, "package %s\n", pkgPath)
+		for _, file := range pkgFiles[pkgPath] {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "file %s %d\n", file, len(data))
+			h.Write(data)
+		}
+	}
+
+	stamp := []byte(fmt.Sprintf("%x\n", h.Sum(nil)))
+
+	existing, err := ioutil.ReadFile(filename)
+	if err == nil && bytes.Equal(existing, stamp) {
+		return nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(filename), 0777)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, stamp, 0666)
+}
+
+// writeTypeSignature writes a description of t to w that includes the names,
+// types, and tags of the fields of any structs it contains.
+func writeTypeSignature(w io.Writer, t reflect.Type) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		fmt.Fprint(w, "*")
+		writeTypeSignature(w, t.Elem())
+	case reflect.Slice:
+		fmt.Fprint(w, "[]")
+		writeTypeSignature(w, t.Elem())
+	case reflect.Struct:
+		fmt.Fprintf(w, "struct %s {", t)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			fmt.Fprintf(w, "%s %q ", field.Name, field.Tag)
+			writeTypeSignature(w, field.Type)
+			fmt.Fprint(w, "; ")
+		}
+		fmt.Fprint(w, "}")
+	default:
+		fmt.Fprint(w, t)
+	}
+}
+
+// docsPkgFiles returns the source files of each package that the primary
+// builder is built from, keyed by package path.
+func docsPkgFiles(ctx *blueprint.Context, srcDir string) (map[string][]string, error) {
 	// Find the module that's marked as the "primary builder", which means it's
 	// creating the binary that we'll use to generate the non-bootstrap
 	// build.ninja file.
@@ -41,7 +161,7 @@ func writeDocs(ctx *blueprint.Context, srcDir, filename string) error {
 		primaryBuilder = primaryBuilders[0]
 
 	default:
-		return fmt.Errorf("multiple primary builder modules present")
+		return nil, fmt.Errorf("multiple primary builder modules present")
 	}
 
 	pkgFiles := make(map[string][]string)
@@ -50,10 +170,12 @@ func writeDocs(ctx *blueprint.Context, srcDir, filename string) error {
 		case (*goPackage):
 			pkgFiles[m.properties.PkgPath] = pathtools.PrefixPaths(m.properties.Srcs,
 				filepath.Join(srcDir, ctx.ModuleDir(m)))
+		case *goGen, *goBinary:
+			// Generated sources and tools don't document module types.
 		default:
 			panic(fmt.Errorf("unknown dependency type %T", module))
 		}
 	})
 
-	return bpdoc.Write(filename, pkgFiles, ctx.ModuleTypePropertyStructs())
+	return pkgFiles, nil
 }
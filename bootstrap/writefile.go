@@ -0,0 +1,121 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bootstrap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+var (
+	// tempFiles are the temporary files that are being written by
+	// writeFileAtomic, which are removed if the process is interrupted.
+	tempFilesLock sync.Mutex
+	tempFiles     = make(map[string]bool)
+)
+
+// handleInterrupts makes the process remove the temporary files that it is
+// writing and exit when it receives SIGINT or SIGTERM.  Files are only renamed
+// into place while holding tempFilesLock, so an interrupted process never
+// leaves a truncated Ninja file behind for Ninja to misinterpret.
+func handleInterrupts() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-sigCh
+
+		tempFilesLock.Lock()
+		for file := range tempFiles {
+			os.Remove(file)
+		}
+
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[0], sig)
+		os.Exit(1)
+	}()
+}
+
+// writeFileAtomic writes data to a temporary file next to filename and then
+// renames it to filename, so that filename either has its old contents or
+// all of data.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	return writeFileAtomicFunc(filename, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeFileAtomicFunc is like writeFileAtomic, but the data is written by
+// write, so that it can be streamed to the file rather than collected in
+// memory first.
+func writeFileAtomicFunc(filename string, perm os.FileMode,
+	write func(w io.Writer) error) error {
+
+	tempFile := filename + ".tmp"
+
+	tempFilesLock.Lock()
+	tempFiles[tempFile] = true
+	tempFilesLock.Unlock()
+
+	f, err := os.OpenFile(tempFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err == nil {
+		err = write(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	tempFilesLock.Lock()
+	defer tempFilesLock.Unlock()
+
+	delete(tempFiles, tempFile)
+
+	if err == nil {
+		err = os.Rename(tempFile, filename)
+	}
+	if err != nil {
+		os.Remove(tempFile)
+	}
+
+	return err
+}
+
+// atomicFile is an io.WriteCloser that buffers the data written to it and
+// writes it to filename with writeFileAtomic when it is closed.
+type atomicFile struct {
+	bytes.Buffer
+	filename string
+	perm     os.FileMode
+}
+
+func (f *atomicFile) Close() error {
+	return writeFileAtomic(f.filename, f.Bytes(), f.perm)
+}
+
+// createSubninjaFile returns a writer for the subninja file at path, creating
+// its directory, for Context.WriteBuildFiles.
+func createSubninjaFile(path string) (io.WriteCloser, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0777)
+	if err != nil {
+		return nil, err
+	}
+	return &atomicFile{filename: path, perm: 0666}, nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// bpcopy is the command line tool that the bootstrap build actions use to copy
+// files.  It copies to a temporary file next to the destination and renames it
+// into place, so that an interrupted copy never leaves a truncated file that
+// Ninja considers up to date.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	mode          = flag.String("m", "", "octal permissions of the copy (defaults to the permissions of the source)")
+	preserveMtime = flag.Bool("p", false, "set the modification time of the copy to that of the source")
+	hash          = flag.String("sha256", "", "fail unless the source has the given hex-encoded SHA-256 hash")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: bpcopy [-m mode] [-p] [-sha256 hash] src dst\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		usage()
+	}
+
+	var perm os.FileMode
+	if *mode != "" {
+		m, err := strconv.ParseUint(*mode, 8, 32)
+		if err != nil || m&^uint64(os.ModePerm) != 0 {
+			fmt.Fprintf(os.Stderr, "bpcopy: invalid mode %q\n", *mode)
+			os.Exit(2)
+		}
+		perm = os.FileMode(m)
+	}
+
+	err := copyFile(flag.Arg(0), flag.Arg(1), perm, *preserveMtime,
+		strings.ToLower(*hash))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bpcopy: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// copyFile copies src to dst.  If perm is zero the permissions of src are
+// used.  If hash is not empty the copy fails unless it is the hex-encoded
+// SHA-256 hash of src, and dst is left unchanged.
+func copyFile(src, dst string, perm os.FileMode, preserveMtime bool,
+	hash string) (err error) {
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", src)
+	}
+	if perm == 0 {
+		perm = info.Mode().Perm()
+	}
+
+	out, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(out.Name())
+		}
+	}()
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, h), in)
+	if err != nil {
+		return err
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); hash != "" && sum != hash {
+		return fmt.Errorf("%s has SHA-256 hash %s, expected %s", src, sum, hash)
+	}
+
+	// Make sure the data is on disk before the rename makes it visible, so
+	// that a crash can't leave dst empty.
+	err = out.Sync()
+	if err != nil {
+		return err
+	}
+
+	err = out.Chmod(perm)
+	if err != nil {
+		return err
+	}
+
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+
+	if preserveMtime {
+		err = os.Chtimes(out.Name(), info.ModTime(), info.ModTime())
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(out.Name(), dst)
+}
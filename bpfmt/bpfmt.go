@@ -6,15 +6,11 @@
 package main
 
 import (
-	"bytes"
 	"flag"
 	"fmt"
-	"github.com/google/blueprint/parser"
-	"io"
+	"github.com/google/blueprint/format"
 	"io/ioutil"
 	"os"
-	"os/exec"
-	"path/filepath"
 )
 
 var (
@@ -22,6 +18,7 @@ var (
 	list      = flag.Bool("l", false, "list files whose formatting differs from bpfmt's")
 	write     = flag.Bool("w", false, "write result to (source) file instead of stdout")
 	doDiff    = flag.Bool("d", false, "display diffs instead of rewriting files")
+	check     = flag.Bool("c", false, "exit with status 1 if the formatting of any file differs from bpfmt's")
 	sortLists = flag.Bool("s", false, "sort arrays")
 )
 
@@ -40,137 +37,102 @@ func usage() {
 	os.Exit(2)
 }
 
-// If in == nil, the source is the contents of the file with the given filename.
-func processFile(filename string, in io.Reader, out io.Writer) error {
-	if in == nil {
-		f, err := os.Open(filename)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
-		in = f
-	}
-
-	src, err := ioutil.ReadAll(in)
-	if err != nil {
-		return err
-	}
+func main() {
+	flag.Usage = usage
+	flag.Parse()
 
-	r := bytes.NewBuffer(src)
+	opts := format.Options{SortLists: *sortLists}
 
-	file, errs := parser.Parse(filename, r, parser.NewScope(nil))
-	if len(errs) > 0 {
-		for _, err := range errs {
-			fmt.Fprintln(os.Stderr, err)
+	if flag.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "error: cannot use -w with standard input")
+			os.Exit(2)
 		}
-		return fmt.Errorf("%d parsing errors", len(errs))
-	}
-
-	if *sortLists {
-		parser.SortLists(file)
-	}
-
-	res, err := parser.Print(file)
-	if err != nil {
-		return err
+		if err := processStdin(opts); err != nil {
+			report(err)
+		}
+		os.Exit(exitCode)
 	}
 
-	if !bytes.Equal(src, res) {
-		// formatting has changed
-		if *list {
-			fmt.Fprintln(out, filename)
+	if !*list && !*write && !*doDiff && !*check {
+		// Print the formatted files, like gofmt.
+		files, errs := format.Files(flag.Args(), "")
+		for _, err := range errs {
+			report(err)
 		}
-		if *write {
-			err = ioutil.WriteFile(filename, res, 0644)
-			if err != nil {
-				return err
+		for _, path := range files {
+			src, err := ioutil.ReadFile(path)
+			if err == nil {
+				var res []byte
+				res, err = format.Source(path, src, opts)
+				if err == nil {
+					os.Stdout.Write(res)
+				}
 			}
-		}
-		if *doDiff {
-			data, err := diff(src, res)
 			if err != nil {
-				return fmt.Errorf("computing diff: %s", err)
+				report(err)
 			}
-			fmt.Printf("diff %s bpfmt/%s\n", filename, filename)
-			out.Write(data)
 		}
+		os.Exit(exitCode)
 	}
 
-	if !*list && !*write && !*doDiff {
-		_, err = out.Write(res)
+	mode := format.Check
+	if *write {
+		mode = format.Overwrite
+	} else if *doDiff {
+		mode = format.Diff
 	}
 
-	return err
-}
-
-func visitFile(path string, f os.FileInfo, err error) error {
-	if err == nil && f.Name() == "Blueprints" {
-		err = processFile(path, nil, os.Stdout)
-	}
-	if err != nil {
+	results, errs := format.Walk(flag.Args(), mode, opts)
+	for _, err := range errs {
 		report(err)
 	}
-	return nil
-}
-
-func walkDir(path string) {
-	filepath.Walk(path, visitFile)
-}
 
-func main() {
-	flag.Parse()
-
-	if flag.NArg() == 0 {
-		if *write {
-			fmt.Fprintln(os.Stderr, "error: cannot use -w with standard input")
-			exitCode = 2
-			return
+	for _, result := range results {
+		if *list {
+			fmt.Println(result.Filename)
 		}
-		if err := processFile("<standard input>", os.Stdin, os.Stdout); err != nil {
-			report(err)
+		if result.Diff != nil {
+			fmt.Printf("diff %s bpfmt/%s\n", result.Filename, result.Filename)
+			os.Stdout.Write(result.Diff)
 		}
-		return
 	}
 
-	for i := 0; i < flag.NArg(); i++ {
-		path := flag.Arg(i)
-		switch dir, err := os.Stat(path); {
-		case err != nil:
-			report(err)
-		case dir.IsDir():
-			walkDir(path)
-		default:
-			if err := processFile(path, nil, os.Stdout); err != nil {
-				report(err)
-			}
-		}
+	if *check && len(results) > 0 && exitCode == 0 {
+		exitCode = 1
 	}
+
+	os.Exit(exitCode)
 }
 
-func diff(b1, b2 []byte) (data []byte, err error) {
-	f1, err := ioutil.TempFile("", "bpfmt")
+func processStdin(opts format.Options) error {
+	const filename = "<standard input>"
+
+	src, err := ioutil.ReadAll(os.Stdin)
 	if err != nil {
-		return
+		return err
 	}
-	defer os.Remove(f1.Name())
-	defer f1.Close()
 
-	f2, err := ioutil.TempFile("", "bpfmt")
+	res, err := format.Source(filename, src, opts)
 	if err != nil {
-		return
+		return err
 	}
-	defer os.Remove(f2.Name())
-	defer f2.Close()
 
-	f1.Write(b1)
-	f2.Write(b2)
-
-	data, err = exec.Command("diff", "-u", f1.Name(), f2.Name()).CombinedOutput()
-	if len(data) > 0 {
-		// diff exits with a non-zero status when the files don't match.
-		// Ignore that failure as long as we get output.
-		err = nil
+	switch {
+	case *doDiff:
+		os.Stdout.Write(format.UnifiedDiff(filename, "bpfmt/"+filename, src, res))
+	case *list || *check:
+		if string(src) != string(res) {
+			if *list {
+				fmt.Println(filename)
+			}
+			if *check {
+				exitCode = 1
+			}
+		}
+	default:
+		os.Stdout.Write(res)
 	}
-	return
 
+	return nil
 }
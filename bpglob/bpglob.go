@@ -0,0 +1,70 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// bpglob is the command line tool that checks if the list of files matched by
+// a glob has changed, and only updates the file list if it has.  The main
+// Ninja file runs it for every glob performed by a module with
+// ModuleContext.Glob, and regenerates itself when one of the lists changes.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/google/blueprint/pathtools"
+)
+
+var (
+	out = flag.String("o", "", "file to write the list of files that match the glob to")
+	dep = flag.String("d", "", "file to write the depfile listing the directories searched to")
+
+	excludes multiArg
+)
+
+func init() {
+	flag.Var(&excludes, "e", "pattern to exclude from results")
+}
+
+type multiArg []string
+
+func (m *multiArg) String() string {
+	return `""`
+}
+
+func (m *multiArg) Set(s string) error {
+	*m = append(*m, s)
+	return nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: bpglob -o out -d depfile [-e exclude]... glob\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if *out == "" || *dep == "" || flag.NArg() != 1 {
+		usage()
+	}
+
+	_, err := pathtools.GlobWithDepFile(flag.Arg(0), *out, *dep, excludes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bpglob: %s\n", err)
+		os.Exit(1)
+	}
+}
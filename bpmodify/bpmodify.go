@@ -26,15 +26,18 @@ var (
 	doDiff          = flag.Bool("d", false, "display diffs instead of rewriting files")
 	sortLists       = flag.Bool("s", false, "sort touched lists, even if they were unsorted")
 	parameter       = flag.String("parameter", "deps", "name of parameter to modify on each module")
+	addModule       = flag.String("add-module", "", "Blueprints definition of a module to add to the end of each file")
 	targetedModules = new(identSet)
 	addIdents       = new(identSet)
 	removeIdents    = new(identSet)
+	removeModules   = new(identSet)
 )
 
 func init() {
 	flag.Var(targetedModules, "m", "comma or whitespace separated list of modules on which to operate")
 	flag.Var(addIdents, "a", "comma or whitespace separated list of identifiers to add")
 	flag.Var(removeIdents, "r", "comma or whitespace separated list of identifiers to remove")
+	flag.Var(removeModules, "remove-module", "comma or whitespace separated list of modules to remove")
 }
 
 var (
@@ -86,6 +89,19 @@ func processFile(filename string, in io.Reader, out io.Writer) error {
 		fmt.Fprintln(os.Stderr, "continuing...")
 	}
 
+	for _, name := range removeModules.idents {
+		m := parser.RemoveModule(file, name)
+		modified = modified || m
+	}
+
+	if *addModule != "" {
+		m, err := addModules(filename, file)
+		if err != nil {
+			return err
+		}
+		modified = modified || m
+	}
+
 	if modified {
 		res, err := parser.Print(file)
 		if err != nil {
@@ -186,6 +202,45 @@ func processParameter(value *parser.Value, paramName, moduleName string,
 	return modified, nil
 }
 
+// addModules adds the modules defined by the -add-module flag to file, unless
+// file already has modules with the same names.
+func addModules(filename string, file *parser.File) (modified bool, err error) {
+	// Parse the modules for each file, as adding them moves them.
+	newFile, errs := parser.Parse("<-add-module>", bytes.NewBufferString(*addModule),
+		parser.NewScope(nil))
+	if len(errs) > 0 {
+		return false, errs[0]
+	}
+
+	for _, def := range newFile.Defs {
+		module, ok := def.(*parser.Module)
+		if !ok {
+			return false, fmt.Errorf("-add-module must only contain module definitions")
+		}
+
+		name := ""
+		for _, prop := range module.Properties {
+			if prop.Name.Name == "name" && prop.Value.Type == parser.String {
+				name = prop.Value.StringValue
+			}
+		}
+		if name == "" {
+			return false, fmt.Errorf("-add-module modules must have names")
+		}
+
+		if parser.FindModule(file, name) != nil {
+			fmt.Fprintf(os.Stderr, "%s: module %q already exists, not adding it\n",
+				filename, name)
+			continue
+		}
+
+		parser.AddModule(file, module)
+		modified = true
+	}
+
+	return modified, nil
+}
+
 func targetedModule(name string) bool {
 	if targetedModules.all {
 		return true
@@ -228,12 +283,15 @@ func main() {
 		return
 	}
 
-	if len(targetedModules.idents) == 0 {
-		report(fmt.Errorf("-m parameter is required"))
+	editModules := *addModule != "" || len(removeModules.idents) > 0
+
+	if len(targetedModules.idents) == 0 && !editModules {
+		report(fmt.Errorf("-m, -add-module or -remove-module parameter is required"))
 		return
 	}
 
-	if len(addIdents.idents) == 0 && len(removeIdents.idents) == 0 {
+	if len(targetedModules.idents) > 0 &&
+		len(addIdents.idents) == 0 && len(removeIdents.idents) == 0 {
 		report(fmt.Errorf("-a or -r parameter is required"))
 		return
 	}
@@ -0,0 +1,274 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/scanner"
+)
+
+// A CallLog is the sequence of ErrorReporter and ActionEmitter calls, and the
+// globs, that a module made while generating its build actions.  It can be
+// serialized with encoding/json and compared with Diff, so that the build
+// actions of a module type can be checked against a golden log.  Logs are
+// recorded by a Context for each module after SetRecordModuleCalls, or by a
+// CallRecorder for module logic that is run without a Context.
+type CallLog struct {
+	Calls []RecordedCall `json:"calls"`
+}
+
+// A RecordedCall is a call to the method Method, with its arguments
+// formatted as strings.  The rules and pools defined by the module are named
+// without the prefix that makes them unique in the Ninja file, so that the
+// logs don't depend on where the module is defined.
+type RecordedCall struct {
+	Method string   `json:"method"`
+	Args   []string `json:"args,omitempty"`
+}
+
+func (c RecordedCall) String() string {
+	return c.Method + "(" + strings.Join(c.Args, ", ") + ")"
+}
+
+// String returns the calls of the log, one per line.
+func (l *CallLog) String() string {
+	var lines []string
+	for _, call := range l.Calls {
+		lines = append(lines, call.String()+"\n")
+	}
+	return strings.Join(lines, "")
+}
+
+// Diff returns a description of each call that differs between the expected
+// log l and got, or nil if they are the same.
+func (l *CallLog) Diff(got *CallLog) []string {
+	var diffs []string
+	for i := 0; i < len(l.Calls) || i < len(got.Calls); i++ {
+		switch {
+		case i >= len(got.Calls):
+			diffs = append(diffs, fmt.Sprintf("call %d: missing %s", i, l.Calls[i]))
+		case i >= len(l.Calls):
+			diffs = append(diffs, fmt.Sprintf("call %d: unexpected %s", i, got.Calls[i]))
+		case l.Calls[i].String() != got.Calls[i].String():
+			diffs = append(diffs, fmt.Sprintf("call %d: expected %s, got %s", i,
+				l.Calls[i], got.Calls[i]))
+		}
+	}
+	return diffs
+}
+
+// add records a call.  Calls to a nil log, which a Context uses when it isn't
+// recording, are ignored.
+func (l *CallLog) add(method string, args ...string) {
+	if l == nil {
+		return
+	}
+	l.Calls = append(l.Calls, RecordedCall{method, args})
+}
+
+func (l *CallLog) addVariable(name, value string) {
+	l.add("Variable", name, value)
+}
+
+func (l *CallLog) addPool(name string, params PoolParams) {
+	args := []string{name}
+	args = appendNonZero(args, "comment", params.Comment)
+	if params.Depth != 0 {
+		args = append(args, fmt.Sprintf("depth=%d", params.Depth))
+	}
+	l.add("Pool", args...)
+}
+
+func (l *CallLog) addRule(name string, params RuleParams, argNames []string) {
+	args := []string{name}
+	args = appendNonZero(args, "comment", params.Comment)
+	args = appendNonZero(args, "command", params.Command)
+	args = appendNonZero(args, "depfile", params.Depfile)
+	if params.Deps != DepsNone {
+		args = append(args, fmt.Sprintf("deps=%s", params.Deps))
+	}
+	args = appendNonZero(args, "description", params.Description)
+	if params.Generator {
+		args = append(args, "generator")
+	}
+	if params.Pool != nil {
+		args = append(args, "pool="+recordedName(params.Pool))
+	}
+	if params.Restat {
+		args = append(args, "restat")
+	}
+	args = appendNonZero(args, "rspfile", params.Rspfile)
+	args = appendNonZero(args, "rspfile_content", params.RspfileContent)
+	args = appendMap(args, "env", params.Env)
+	if len(argNames) > 0 {
+		args = append(args, fmt.Sprintf("args=%q", argNames))
+	}
+	l.add("Rule", args...)
+}
+
+func (l *CallLog) addBuild(params BuildParams) {
+	var args []string
+	if params.Rule != nil {
+		args = append(args, "rule="+recordedName(params.Rule))
+	}
+	args = appendList(args, "outputs", params.Outputs)
+	args = appendList(args, "symlink_outputs", params.SymlinkOutputs)
+	args = appendList(args, "inputs", params.Inputs)
+	args = appendList(args, "implicits", params.Implicits)
+	args = appendList(args, "order_only", params.OrderOnly)
+	args = appendList(args, "validations", params.Validations)
+	args = appendMap(args, "args", params.Args)
+	if params.Optional {
+		args = append(args, "optional")
+	}
+	l.add("Build", args...)
+}
+
+func (l *CallLog) addGlob(pattern string, excludes []string, matches []string, err error) {
+	args := []string{fmt.Sprintf("%q", pattern)}
+	args = appendList(args, "excludes", excludes)
+	if err != nil {
+		args = append(args, "error="+err.Error())
+	} else {
+		args = append(args, fmt.Sprintf("matches=%q", matches))
+	}
+	l.add("Glob", args...)
+}
+
+// recordedName returns the name of a rule or pool in a CallLog.
+func recordedName(def interface {
+	name() string
+	String() string
+}) string {
+	switch def.(type) {
+	case *localRule:
+		return "<local rule>:" + def.name()
+	case *localPool:
+		return "<local pool>:" + def.name()
+	}
+	return def.String()
+}
+
+func appendNonZero(args []string, key, value string) []string {
+	if value == "" {
+		return args
+	}
+	return append(args, fmt.Sprintf("%s=%q", key, value))
+}
+
+func appendList(args []string, key string, list []string) []string {
+	if len(list) == 0 {
+		return args
+	}
+	return append(args, fmt.Sprintf("%s=%q", key, list))
+}
+
+func appendMap(args []string, key string, m map[string]string) []string {
+	if len(m) == 0 {
+		return args
+	}
+	var entries []string
+	for k, v := range m {
+		entries = append(entries, fmt.Sprintf("%s=%q", k, v))
+	}
+	sort.Strings(entries)
+	return append(args, key+"={"+strings.Join(entries, " ")+"}")
+}
+
+// SetRecordModuleCalls makes PrepareBuildActions record a CallLog for each
+// module, which is returned by ModuleCallLog.
+func (c *Context) SetRecordModuleCalls(record bool) {
+	c.recordModuleCalls = record
+}
+
+// ModuleCallLog returns the CallLog recorded while logicModule generated its
+// build actions, or nil if SetRecordModuleCalls wasn't enabled.
+func (c *Context) ModuleCallLog(logicModule Module) *CallLog {
+	return c.moduleInfo[logicModule].callLog
+}
+
+// A CallRecorder is an ErrorReporter and ActionEmitter that records the calls
+// made to it in a CallLog, so that module logic written against those
+// interfaces can be checked against a golden log without a Context.  The
+// rules, pools and build statements are checked like those of a module.
+type CallRecorder struct {
+	localActions
+
+	log    CallLog
+	failed bool
+}
+
+var _ ErrorReporter = (*CallRecorder)(nil)
+var _ ActionEmitter = (*CallRecorder)(nil)
+
+func NewCallRecorder() *CallRecorder {
+	return &CallRecorder{
+		localActions: localActions{scope: newLocalScope(nil, "recorder.")},
+	}
+}
+
+// Log returns the calls recorded so far.
+func (r *CallRecorder) Log() *CallLog {
+	return &r.log
+}
+
+func (r *CallRecorder) Errorf(pos scanner.Position, format string, args ...interface{}) {
+	r.failed = true
+	r.log.add("Errorf", pos.String(), fmt.Sprintf(format, args...))
+}
+
+func (r *CallRecorder) ModuleErrorf(format string, args ...interface{}) {
+	r.failed = true
+	r.log.add("ModuleErrorf", fmt.Sprintf(format, args...))
+}
+
+func (r *CallRecorder) PropertyErrorf(property, format string, args ...interface{}) {
+	r.failed = true
+	r.log.add("PropertyErrorf", property, fmt.Sprintf(format, args...))
+}
+
+func (r *CallRecorder) Failed() bool {
+	return r.failed
+}
+
+func (r *CallRecorder) Variable(pctx *PackageContext, name, value string) {
+	r.localActions.Variable(pctx, name, value)
+	r.log.addVariable(name, value)
+}
+
+func (r *CallRecorder) Pool(pctx *PackageContext, name string, params PoolParams) Pool {
+	p := r.localActions.Pool(pctx, name, params)
+	r.log.addPool(name, params)
+	return p
+}
+
+func (r *CallRecorder) Rule(pctx *PackageContext, name string, params RuleParams,
+	argNames ...string) Rule {
+
+	rule := r.localActions.Rule(pctx, name, params, argNames...)
+	r.log.addRule(name, params, argNames)
+	return rule
+}
+
+func (r *CallRecorder) Build(pctx *PackageContext, params BuildParams) {
+	r.localActions.Build(pctx, params)
+	r.log.addBuild(params)
+}
+
+func (r *CallRecorder) AddNinjaFileDeps(deps ...string) {
+	r.log.add("AddNinjaFileDeps", deps...)
+}
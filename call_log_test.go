@@ -0,0 +1,138 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type recordedModule struct {
+	properties struct {
+		Srcs []string
+	}
+}
+
+func newRecordedModule() (Module, []interface{}) {
+	m := &recordedModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (m *recordedModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Variable(fakeTestPctx, "flags", "-v")
+	link := ctx.Rule(fakeTestPctx, "link", RuleParams{
+		Command: "ld $flags $in -o $out",
+	})
+
+	copyFiles(ctx, ctx, m.properties.Srcs, "out")
+
+	ctx.Build(fakeTestPctx, BuildParams{
+		Rule:    link,
+		Outputs: []string{ctx.ModuleName()},
+		Inputs:  []string{"out/a"},
+	})
+}
+
+func TestModuleCallLog(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("recorded_module", newRecordedModule)
+	ctx.SetRecordModuleCalls(true)
+
+	r := bytes.NewBufferString(`
+		recorded_module {
+			name: "a",
+			srcs: ["a", ""],
+		}
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) != 1 {
+		t.Errorf("expected 1 error, got %d:", len(errs))
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+	}
+
+	expected := &CallLog{
+		Calls: []RecordedCall{
+			{"Variable", []string{"flags", "-v"}},
+			{"Rule", []string{"link", `command="ld $flags $in -o $out"`}},
+			{"Build", []string{"rule=github.com/google/blueprint/faketest.cp",
+				`outputs=["out/a"]`, `inputs=["a"]`}},
+			{"PropertyErrorf", []string{"srcs", "empty source file"}},
+			{"Build", []string{"rule=<local rule>:link", `outputs=["a"]`, `inputs=["out/a"]`}},
+		},
+	}
+
+	got := ctx.ModuleCallLog(modules[0].logicModule)
+	if diffs := expected.Diff(got); diffs != nil {
+		t.Errorf("unexpected call log:\n%s", got)
+		for _, diff := range diffs {
+			t.Errorf("  %s", diff)
+		}
+	}
+
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	replayed := &CallLog{}
+	if err := json.Unmarshal(data, replayed); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(replayed, got) {
+		t.Errorf("expected the JSON log to round trip, got:\n%s", replayed)
+	}
+}
+
+func TestCallRecorder(t *testing.T) {
+	recorder := NewCallRecorder()
+
+	copyFiles(recorder, recorder, []string{"a", "", "b"}, "out")
+
+	if !recorder.Failed() {
+		t.Errorf("expected the recorder to have failed")
+	}
+
+	expected := &CallLog{
+		Calls: []RecordedCall{
+			{"Build", []string{"rule=github.com/google/blueprint/faketest.cp",
+				`outputs=["out/a"]`, `inputs=["a"]`}},
+			{"PropertyErrorf", []string{"srcs", "empty source file"}},
+			{"Build", []string{"rule=github.com/google/blueprint/faketest.cp",
+				`outputs=["out/b"]`, `inputs=["b"]`}},
+		},
+	}
+
+	if diffs := expected.Diff(recorder.Log()); diffs != nil {
+		t.Errorf("unexpected call log:\n%s", recorder.Log())
+		for _, diff := range diffs {
+			t.Errorf("  %s", diff)
+		}
+	}
+
+	missing := &CallLog{Calls: expected.Calls[:2]}
+	if diffs := missing.Diff(recorder.Log()); len(diffs) != 1 {
+		t.Errorf("expected 1 diff, got %q", diffs)
+	}
+}
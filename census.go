@@ -0,0 +1,129 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// A ModuleTypeCensus reports how often a registered module type and each of
+// its properties are used in the parsed Blueprints files.
+type ModuleTypeCensus struct {
+	Name    string // The module type name.
+	Modules int    // The number of modules of this type.
+
+	// Properties maps the name of every property that the module type
+	// accepts to the number of modules that set it.  Properties of nested
+	// structs are named with dots, e.g. "arch.arm.srcs".  Properties that are
+	// never set map to 0.
+	Properties map[string]int
+}
+
+// ModuleTypeCensus returns a ModuleTypeCensus for each registered module type,
+// sorted by module type name, including module types that are never used.  It
+// must be called after ParseBlueprintsFiles.  Each module is counted once
+// regardless of how many variants it is split into.
+func (c *Context) ModuleTypeCensus() []ModuleTypeCensus {
+	censusMap := make(map[string]*ModuleTypeCensus)
+
+	for typeName, factory := range c.moduleFactories {
+		census := &ModuleTypeCensus{
+			Name:       typeName,
+			Properties: make(map[string]int),
+		}
+
+		// Include the properties that every module has, like "name".
+		var info moduleInfo
+		_, properties := factory()
+		properties = append([]interface{}{&info.properties}, properties...)
+		for _, p := range properties {
+			addCensusProperties("", reflect.ValueOf(p).Elem(), census.Properties)
+		}
+
+		censusMap[typeName] = census
+	}
+
+	for _, group := range c.moduleGroups {
+		module := group.modules[0]
+		census, ok := censusMap[module.typeName]
+		if !ok {
+			continue
+		}
+
+		census.Modules++
+		for name := range module.propertyPos {
+			if _, ok := census.Properties[name]; ok {
+				census.Properties[name]++
+			}
+		}
+	}
+
+	typeNames := make([]string, 0, len(censusMap))
+	for typeName := range censusMap {
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+
+	ret := make([]ModuleTypeCensus, len(typeNames))
+	for i, typeName := range typeNames {
+		ret[i] = *censusMap[typeName]
+	}
+
+	return ret
+}
+
+// addCensusProperties adds the names of the properties that can be set in the
+// property struct structValue to properties.
+func addCensusProperties(namePrefix string, structValue reflect.Value,
+	properties map[string]int) {
+
+	structType := structValue.Type()
+
+	for i := 0; i < structValue.NumField(); i++ {
+		field := structType.Field(i)
+		fieldValue := structValue.Field(i)
+
+		if field.PkgPath != "" || hasTag(field, "blueprint", "mutated") {
+			// This field can't be set from a Blueprints file.
+			continue
+		}
+
+		propertyName := namePrefix + proptools.PropertyNameForField(field.Name)
+		properties[propertyName] = 0
+
+		switch fieldValue.Kind() {
+		case reflect.Interface:
+			if fieldValue.IsNil() {
+				continue
+			}
+			fieldValue = fieldValue.Elem()
+			fallthrough
+		case reflect.Ptr:
+			if fieldValue.IsNil() {
+				continue
+			}
+			fieldValue = fieldValue.Elem()
+			if fieldValue.Kind() != reflect.Struct {
+				continue
+			}
+			fallthrough
+		case reflect.Struct:
+			addCensusProperties(propertyName+".", fieldValue, properties)
+		}
+	}
+}
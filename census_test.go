@@ -0,0 +1,174 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type censusModule struct {
+	properties struct {
+		Srcs []string
+		Arch struct {
+			Arm struct {
+				Srcs []string
+			}
+		}
+		Count int `blueprint:"mutated"`
+	}
+}
+
+func newCensusModule() (Module, []interface{}) {
+	m := &censusModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (c *censusModule) GenerateBuildActions(ModuleContext) {
+}
+
+func TestModuleTypeCensus(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("census_module", newCensusModule)
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	r := bytes.NewBufferString(`
+		census_module {
+			name: "a",
+			srcs: ["a.c"],
+		}
+
+		census_module {
+			name: "b",
+			deps: ["a"],
+			arch: {
+				arm: {
+					srcs: ["b.c"],
+				},
+			},
+		}
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	expected := []ModuleTypeCensus{
+		{
+			Name:    AliasModuleType,
+			Modules: 0,
+			Properties: map[string]int{
+				"name":        0,
+				"deps":        0,
+				"enabled":     0,
+				"visibility":  0,
+				"overrides":   0,
+				"test_for":    0,
+				"test_suites": 0,
+				"actual":      0,
+				"warn":        0,
+			},
+		},
+		{
+			Name:    ConfigVariablesModuleType,
+			Modules: 0,
+			Properties: map[string]int{
+				"name":        0,
+				"deps":        0,
+				"enabled":     0,
+				"visibility":  0,
+				"overrides":   0,
+				"test_for":    0,
+				"test_suites": 0,
+				"variables":   0,
+			},
+		},
+		{
+			Name:    GroupModuleType,
+			Modules: 0,
+			Properties: map[string]int{
+				"name":        0,
+				"deps":        0,
+				"enabled":     0,
+				"visibility":  0,
+				"overrides":   0,
+				"test_for":    0,
+				"test_suites": 0,
+			},
+		},
+		{
+			Name:    NamespaceModuleType,
+			Modules: 0,
+			Properties: map[string]int{
+				"name":        0,
+				"deps":        0,
+				"enabled":     0,
+				"visibility":  0,
+				"overrides":   0,
+				"test_for":    0,
+				"test_suites": 0,
+				"imports":     0,
+				"exports":     0,
+			},
+		},
+		{
+			Name:    "census_module",
+			Modules: 2,
+			Properties: map[string]int{
+				"name":          2,
+				"deps":          1,
+				"enabled":       0,
+				"visibility":    0,
+				"overrides":     0,
+				"test_for":      0,
+				"test_suites":   0,
+				"srcs":          1,
+				"arch":          1,
+				"arch.arm":      1,
+				"arch.arm.srcs": 1,
+			},
+		},
+		{
+			Name:    "foo_module",
+			Modules: 0,
+			Properties: map[string]int{
+				"name":        0,
+				"deps":        0,
+				"enabled":     0,
+				"visibility":  0,
+				"overrides":   0,
+				"test_for":    0,
+				"test_suites": 0,
+				"foo":         0,
+			},
+		},
+	}
+
+	census := ctx.ModuleTypeCensus()
+	if !reflect.DeepEqual(census, expected) {
+		t.Errorf("incorrect census")
+		t.Errorf("  expected: %#v", expected)
+		t.Errorf("       got: %#v", census)
+	}
+}
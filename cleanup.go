@@ -0,0 +1,153 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// A CleanupManifest lists the outputs of the build actions of every module, as
+// written by Context.WriteCleanupManifest.  Comparing the manifest of a previous
+// run with the current one finds the outputs that belong to modules that have
+// since been deleted, so that they can be removed without cleaning the whole
+// output directory.
+type CleanupManifest struct {
+	Modules []*ModuleOutputs `json:"modules"`
+}
+
+// A ModuleOutputs is the entry for a single module variant in a
+// CleanupManifest.  Paths are not Ninja-escaped.
+type ModuleOutputs struct {
+	Name       string   `json:"name"`
+	Variant    string   `json:"variant,omitempty"`
+	Blueprints string   `json:"blueprints"` // The Blueprints file that defines the module.
+	Outputs    []string `json:"outputs"`
+}
+
+func (m *ModuleOutputs) key() string {
+	return m.Name + "\x00" + m.Variant
+}
+
+// CleanupManifest returns the outputs of the build actions of every module,
+// in name order.  If this is called before PrepareBuildActions successfully
+// completes then ErrBuildActionsNotReady is returned.
+func (c *Context) CleanupManifest() (*CleanupManifest, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
+	}
+
+	manifest := &CleanupManifest{
+		Modules: []*ModuleOutputs{},
+	}
+
+	for _, name := range c.sortedModuleNames() {
+		for _, module := range c.moduleGroups[name].modules {
+			outputs, err := c.evalModuleOutputs(module)
+			if err != nil {
+				return nil, err
+			}
+
+			manifest.Modules = append(manifest.Modules, &ModuleOutputs{
+				Name:       module.properties.Name,
+				Variant:    module.variantName,
+				Blueprints: module.relBlueprintsFile,
+				Outputs:    outputs,
+			})
+		}
+	}
+
+	return manifest, nil
+}
+
+// evalModuleOutputs returns the sorted values of the outputs of the build
+// statements of module.
+func (c *Context) evalModuleOutputs(module *moduleInfo) ([]string, error) {
+	eval := c.evalFunc(actionsOwner{actionDefs: &module.actionDefs})
+
+	outputs := []string{}
+	for _, def := range module.actionDefs.buildDefs {
+		values, err := eval(def.Outputs)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, values...)
+	}
+	sort.Strings(outputs)
+
+	return outputs, nil
+}
+
+// WriteCleanupManifest writes the outputs of the build actions of every module
+// to w as a JSON encoded CleanupManifest.  If this is called before
+// PrepareBuildActions successfully completes then ErrBuildActionsNotReady is
+// returned.
+func (c *Context) WriteCleanupManifest(w io.Writer) error {
+	manifest, err := c.CleanupManifest()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// ReadCleanupManifest reads a CleanupManifest written by WriteCleanupManifest.
+func ReadCleanupManifest(r io.Reader) (*CleanupManifest, error) {
+	manifest := &CleanupManifest{}
+	err := json.NewDecoder(r).Decode(manifest)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// DeletedOutputs returns the sorted outputs of the modules in m that are not
+// in current, because they or their variants have been deleted.  Outputs that
+// are still built by a module in current, for example because they moved to a
+// different module, are not included.
+func (m *CleanupManifest) DeletedOutputs(current *CleanupManifest) []string {
+	modules := make(map[string]bool)
+	outputs := make(map[string]bool)
+	for _, module := range current.Modules {
+		modules[module.key()] = true
+		for _, output := range module.Outputs {
+			outputs[output] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	var deleted []string
+	for _, module := range m.Modules {
+		if modules[module.key()] {
+			continue
+		}
+		for _, output := range module.Outputs {
+			if !outputs[output] && !seen[output] {
+				seen[output] = true
+				deleted = append(deleted, output)
+			}
+		}
+	}
+	sort.Strings(deleted)
+
+	return deleted
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func cleanupManifestForTest(t *testing.T, bp string) *CleanupManifest {
+	ctx := NewContext()
+	ctx.RegisterModuleType("verify_module", newVerifyModule)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", bytes.NewBufferString(bp), nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	ck(ctx.WriteCleanupManifest(buf))
+
+	manifest, err := ReadCleanupManifest(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return manifest
+}
+
+func TestCleanupManifest(t *testing.T) {
+	manifest := cleanupManifestForTest(t, `
+		verify_module { name: "b", outs: ["$outDir/b.o", "$outDir/b.d"], ins: ["b.c"] }
+		verify_module { name: "a", outs: ["$outDir/a.o"], ins: ["a.c"] }
+	`)
+
+	expected := &CleanupManifest{
+		Modules: []*ModuleOutputs{
+			{Name: "a", Blueprints: "Blueprint", Outputs: []string{"out/a.o"}},
+			{Name: "b", Blueprints: "Blueprint", Outputs: []string{"out/b.d", "out/b.o"}},
+		},
+	}
+	if !reflect.DeepEqual(manifest, expected) {
+		t.Errorf("expected manifest %+v, got %+v", expected.Modules, manifest.Modules)
+	}
+}
+
+func TestCleanupManifestNotReady(t *testing.T) {
+	ctx := NewContext()
+	if err := ctx.WriteCleanupManifest(bytes.NewBuffer(nil)); err != ErrBuildActionsNotReady {
+		t.Errorf("expected %v, got %v", ErrBuildActionsNotReady, err)
+	}
+}
+
+func TestDeletedOutputs(t *testing.T) {
+	previous := cleanupManifestForTest(t, `
+		verify_module { name: "a", outs: ["$outDir/a.o"], ins: ["a.c"] }
+		verify_module { name: "b", outs: ["$outDir/b.o", "$outDir/shared.h"], ins: ["b.c"] }
+		verify_module { name: "c", outs: ["$outDir/c.o", "$outDir/c.d"], ins: ["c.c"] }
+	`)
+
+	// Module c is deleted, and shared.h moves from b to a.  Module a no
+	// longer builds a.o, but it still exists, so a.o is left for Ninja to
+	// clean up.
+	current := cleanupManifestForTest(t, `
+		verify_module { name: "a", outs: ["$outDir/a2.o", "$outDir/shared.h"], ins: ["a.c"] }
+		verify_module { name: "b", outs: ["$outDir/b.o"], ins: ["b.c"] }
+	`)
+
+	expected := []string{"out/c.d", "out/c.o"}
+	if got := previous.DeletedOutputs(current); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected deleted outputs %q, got %q", expected, got)
+	}
+
+	if got := current.DeletedOutputs(current); len(got) != 0 {
+		t.Errorf("expected no deleted outputs, got %q", got)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+)
+
+// gzipMagic is the two byte header that starts every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// WriteCompressedBuildFile writes the gzip-compressed Ninja manifest text for
+// the generated build actions to w.  Ninja cannot read the compressed manifest
+// directly; it can be read back with NewBuildFileReader, or a loader manifest
+// that decompresses it can be written with WriteBuildFileLoader.  If this is
+// called before PrepareBuildActions successfully completes then
+// ErrBuildActionsNotReady is returned.
+func (c *Context) WriteCompressedBuildFile(w io.Writer) error {
+	if !c.buildActionsReady {
+		return ErrBuildActionsNotReady
+	}
+
+	gw := gzip.NewWriter(w)
+
+	err := c.WriteBuildFile(gw)
+	if err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
+
+// WriteBuildFileLoader writes a small Ninja manifest to w that regenerates
+// manifestFile by decompressing compressedFile.  When Ninja is pointed at the
+// loader it rebuilds the manifest, then restarts itself using the full
+// decompressed manifest.  The manifestFile path must be the path that Ninja
+// will load the loader manifest from.
+//
+// The loader is usually written after compressedFile, so the decompression
+// depends on a phony target that is never created to make Ninja run it however
+// the timestamps of the two files compare.
+//
+// Ninja can only read a manifest from disk, so the decompressed manifest
+// replaces the loader at manifestFile and stays there alongside
+// compressedFile.  Compression therefore only reduces the size of the
+// manifest while it is stored or transferred, for example as a build artifact
+// or in a cache, and not the disk space used once Ninja has run.
+func WriteBuildFileLoader(w io.Writer, manifestFile, compressedFile string) error {
+	nw := newNinjaWriter(w)
+
+	err := nw.Comment("Loader for the compressed Ninja manifest " + compressedFile)
+	if err != nil {
+		return err
+	}
+
+	err = nw.Assign("ninja_required_version", "1.1.0")
+	if err != nil {
+		return err
+	}
+
+	err = nw.BlankLine()
+	if err != nil {
+		return err
+	}
+
+	err = nw.Rule("gunzip_manifest")
+	if err != nil {
+		return err
+	}
+
+	err = nw.ScopedAssign("command", "gzip -dc $in > $out")
+	if err != nil {
+		return err
+	}
+
+	err = nw.ScopedAssign("description", "gunzip $out")
+	if err != nil {
+		return err
+	}
+
+	err = nw.ScopedAssign("generator", "true")
+	if err != nil {
+		return err
+	}
+
+	err = nw.BlankLine()
+	if err != nil {
+		return err
+	}
+
+	alwaysDirty := inputEscaper.Replace(compressedFile + ".always")
+
+	err = nw.Build("phony", []string{alwaysDirty}, nil, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	err = nw.BlankLine()
+	if err != nil {
+		return err
+	}
+
+	err = nw.Build("gunzip_manifest",
+		[]string{outputEscaper.Replace(manifestFile)},
+		[]string{inputEscaper.Replace(compressedFile)},
+		[]string{alwaysDirty}, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return nw.Flush()
+}
+
+// NewBuildFileReader returns a Reader that produces the Ninja manifest text
+// read from r.  The manifest may be either plain text or compressed with
+// WriteCompressedBuildFile; compressed manifests are detected automatically.
+func NewBuildFileReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	header, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if len(header) == len(gzipMagic) && header[0] == gzipMagic[0] &&
+		header[1] == gzipMagic[1] {
+
+		return gzip.NewReader(br)
+	}
+
+	return br, nil
+}
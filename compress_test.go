@@ -0,0 +1,82 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCompressedBuildFile(t *testing.T) {
+	ctx := NewContext()
+
+	_, errs := ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors preparing build actions:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	plain := bytes.NewBuffer(nil)
+	ck(ctx.WriteBuildFile(plain))
+
+	compressed := bytes.NewBuffer(nil)
+	ck(ctx.WriteCompressedBuildFile(compressed))
+
+	for _, input := range [][]byte{plain.Bytes(), compressed.Bytes()} {
+		r, err := NewBuildFileReader(bytes.NewReader(input))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		output, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if !bytes.Equal(output, plain.Bytes()) {
+			t.Errorf("incorrect manifest read back")
+			t.Errorf("  expected: %q", plain.String())
+			t.Errorf("       got: %q", string(output))
+		}
+	}
+}
+
+func TestBuildFileLoader(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	ck(WriteBuildFileLoader(buf, "build.ninja", "build.ninja.gz"))
+
+	expected := `# Loader for the compressed Ninja manifest build.ninja.gz
+ninja_required_version = 1.1.0
+
+rule gunzip_manifest
+    command = gzip -dc $in > $out
+    description = gunzip $out
+    generator = true
+
+build build.ninja.gz.always: phony
+
+build build.ninja: gunzip_manifest build.ninja.gz | build.ninja.gz.always
+`
+
+	if buf.String() != expected {
+		t.Errorf("incorrect loader output")
+		t.Errorf("  expected: %q", expected)
+		t.Errorf("       got: %q", buf.String())
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/blueprint/parser"
+)
+
+// A ConfigType is the type of the value of a config key.
+type ConfigType int
+
+const (
+	ConfigString ConfigType = iota
+	ConfigBool
+	ConfigInt
+)
+
+func (t ConfigType) String() string {
+	switch t {
+	case ConfigString:
+		return "string"
+	case ConfigBool:
+		return "bool"
+	case ConfigInt:
+		return "int"
+	default:
+		panic(fmt.Errorf("unknown config type %d", t))
+	}
+}
+
+// A ConfigKey describes a key of the product configuration, such as the target
+// architecture, that is registered with RegisterConfigKey.
+type ConfigKey struct {
+	Name string
+	Type ConfigType
+
+	// Allowed lists the values that the key may have, or is nil to allow
+	// any value of its type.
+	Allowed []string
+
+	// Default is the value of the key if the configuration doesn't set it.
+	// It must be a valid value unless Required is true.
+	Default string
+
+	// Required makes it an error for the configuration not to set the key.
+	Required bool
+}
+
+// check returns an error if value isn't a valid value for the key.
+func (k *ConfigKey) check(value string) error {
+	if expected := k.expected(value); expected != "" {
+		return fmt.Errorf("config key %q has value %q, expected %s", k.Name, value, expected)
+	}
+	return nil
+}
+
+// expected returns a description of the values that the key may have if value
+// isn't one of them, or "" if it is.
+func (k *ConfigKey) expected(value string) string {
+	switch k.Type {
+	case ConfigBool:
+		if value != "true" && value != "false" {
+			return "true or false"
+		}
+	case ConfigInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return "an integer"
+		}
+	}
+
+	if k.Allowed != nil && !k.allows(value) {
+		return "one of " + quotedList(k.Allowed)
+	}
+
+	return ""
+}
+
+func (k *ConfigKey) allows(value string) bool {
+	for _, allowed := range k.Allowed {
+		if value == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func quotedList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// RegisterConfigKey adds a key to the schema of the product configuration that
+// SetConfigValues validates.  Each key is also a select axis, so the
+// Blueprints files may choose values on it with select expressions, and a case
+// of a select that isn't a value the key may have is reported as an error.
+func (c *Context) RegisterConfigKey(key ConfigKey) {
+	if _, present := c.configKeys[key.Name]; present {
+		panic(fmt.Errorf("config key %s is already registered", key.Name))
+	}
+	if key.Type == ConfigBool && key.Allowed == nil {
+		key.Allowed = []string{"false", "true"}
+	}
+	for _, value := range key.Allowed {
+		if err := key.check(value); err != nil {
+			panic(fmt.Errorf("invalid allowed value: %s", err))
+		}
+	}
+	if !key.Required {
+		if err := key.check(key.Default); err != nil {
+			panic(fmt.Errorf("invalid default value: %s", err))
+		}
+	}
+
+	if c.configKeys == nil {
+		c.configKeys = make(map[string]*ConfigKey)
+	}
+	c.configKeys[key.Name] = &key
+}
+
+// SetConfigValues validates the values of the product configuration against
+// the keys registered with RegisterConfigKey, and sets the select axes of the
+// keys to them.  It must be called before ParseBlueprintsFiles.  All of the
+// problems with values are returned together, sorted by key, so that a bad
+// configuration can be fixed at once; the values are only set if there are
+// none.  The returned ConfigValues are also returned by Context.ConfigValues,
+// and may be stored in the config object so that VariableFunc functions can
+// read them.
+func (c *Context) SetConfigValues(values map[string]string) (*ConfigValues, []error) {
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	for name := range c.configKeys {
+		if _, ok := values[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var errs []error
+	configValues := &ConfigValues{keys: c.configKeys, values: make(map[string]string)}
+	for _, name := range names {
+		key, ok := c.configKeys[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown config key %q", name))
+			continue
+		}
+
+		value, ok := values[name]
+		if !ok {
+			if key.Required {
+				errs = append(errs, fmt.Errorf("required config key %q is not set", name))
+				continue
+			}
+			value = key.Default
+		}
+
+		if err := key.check(value); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		configValues.values[name] = value
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	for name, value := range configValues.values {
+		c.SetSelectAxis(name, value)
+	}
+	c.configValues = configValues
+
+	return configValues, nil
+}
+
+// ConfigValues returns the values set by SetConfigValues, or nil if it hasn't
+// been called.
+func (c *Context) ConfigValues() *ConfigValues {
+	return c.configValues
+}
+
+// checkSelectCases returns an error for each case of a select expression on a
+// config key in properties that isn't a value the key may have.
+func (c *Context) checkSelectCases(properties []*parser.Property) []error {
+	if len(c.configKeys) == 0 {
+		return nil
+	}
+
+	var errs []error
+	var check func(value parser.Value)
+	check = func(value parser.Value) {
+		switch {
+		case value.Select != nil:
+			s := value.Select
+			key, ok := c.configKeys[s.Axis.Name]
+			for _, selectCase := range s.Cases.MapValue {
+				name := selectCase.Name.Name
+				if ok && name != parser.SelectDefault {
+					if expected := key.expected(name); expected != "" {
+						errs = append(errs, &Error{
+							Err: fmt.Errorf("select on config key %q has case %q, expected %s",
+								key.Name, name, expected),
+							Pos: selectCase.Name.Pos,
+						})
+					}
+				}
+				check(selectCase.Value)
+			}
+		case value.Expression != nil:
+			check(value.Expression.Args[0])
+			check(value.Expression.Args[1])
+		case value.Type == parser.List:
+			for _, element := range value.ListValue {
+				check(element)
+			}
+		case value.Type == parser.Map:
+			for _, property := range value.MapValue {
+				check(property.Value)
+			}
+		}
+	}
+
+	for _, property := range properties {
+		check(property.Value)
+	}
+
+	return errs
+}
+
+// ConfigValues are the validated values of the product configuration, returned
+// by Context.SetConfigValues.  Reading a key that isn't registered, or as a
+// different type than it is registered with, is a programming error and
+// panics.
+type ConfigValues struct {
+	keys   map[string]*ConfigKey
+	values map[string]string
+}
+
+// A ConfigValuesProvider is a config object that carries the ConfigValues
+// returned by Context.SetConfigValues, for the VariableFunc functions and
+// modules that are passed the config object.
+type ConfigValuesProvider interface {
+	ConfigValues() *ConfigValues
+}
+
+func (v *ConfigValues) value(name string, typ ConfigType) string {
+	key, ok := v.keys[name]
+	if !ok {
+		panic(fmt.Errorf("config key %s is not registered", name))
+	}
+	if key.Type != typ {
+		panic(fmt.Errorf("config key %s has type %s, not %s", name, key.Type, typ))
+	}
+	return v.values[name]
+}
+
+// String returns the value of the string config key name.
+func (v *ConfigValues) String(name string) string {
+	return v.value(name, ConfigString)
+}
+
+// Bool returns the value of the bool config key name.
+func (v *ConfigValues) Bool(name string) bool {
+	return v.value(name, ConfigBool) == "true"
+}
+
+// Int returns the value of the int config key name.
+func (v *ConfigValues) Int(name string) int {
+	value, _ := strconv.Atoi(v.value(name, ConfigInt))
+	return value
+}
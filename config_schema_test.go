@@ -0,0 +1,189 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var (
+	schemaTestPctx = NewPackageContext("github.com/google/blueprint/schematest")
+
+	schemaTestArch = schemaTestPctx.VariableFunc("arch", func(config interface{}) (string, error) {
+		return config.(ConfigValuesProvider).ConfigValues().String("arch"), nil
+	})
+)
+
+type schemaTestConfig struct {
+	values *ConfigValues
+}
+
+func (c schemaTestConfig) ConfigValues() *ConfigValues {
+	return c.values
+}
+
+func newSchemaTestContext() *Context {
+	ctx := NewContext()
+	ctx.RegisterModuleType("config_module", newConfigVariableModule)
+	ctx.RegisterConfigKey(ConfigKey{
+		Name:     "arch",
+		Type:     ConfigString,
+		Allowed:  []string{"arm", "x86"},
+		Required: true,
+	})
+	ctx.RegisterConfigKey(ConfigKey{
+		Name:    "debug",
+		Type:    ConfigBool,
+		Default: "false",
+	})
+	ctx.RegisterConfigKey(ConfigKey{
+		Name:    "jobs",
+		Type:    ConfigInt,
+		Default: "4",
+	})
+	return ctx
+}
+
+func TestConfigValues(t *testing.T) {
+	ctx := newSchemaTestContext()
+
+	values, errs := ctx.SetConfigValues(map[string]string{
+		"arch":  "arm",
+		"debug": "true",
+	})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if ctx.ConfigValues() != values {
+		t.Errorf("expected ConfigValues to return the set values")
+	}
+
+	if got := values.String("arch"); got != "arm" {
+		t.Errorf("expected arch %q, got %q", "arm", got)
+	}
+	if got := values.Bool("debug"); got != true {
+		t.Errorf("expected debug %v, got %v", true, got)
+	}
+	if got := values.Int("jobs"); got != 4 {
+		t.Errorf("expected default jobs %d, got %d", 4, got)
+	}
+
+	value, err := schemaTestArch.value(schemaTestConfig{values})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := value.Value(nil); got != "arm" {
+		t.Errorf("expected variable value %q, got %q", "arm", got)
+	}
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", bytes.NewBufferString(`
+		config_module {
+			name: "a",
+			cflags: select(arch, {
+				"arm": ["-marm"],
+				default: [],
+			}) + select(debug, {
+				"true": ["-g"],
+				default: [],
+			}),
+		}
+	`), nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	module := ctx.moduleGroups["a"].modules[0].logicModule.(*configVariableModule)
+	expected := []string{"-marm", "-g"}
+	if !reflect.DeepEqual(module.properties.Cflags, expected) {
+		t.Errorf("expected cflags %q, got %q", expected, module.properties.Cflags)
+	}
+}
+
+func TestConfigValuesErrors(t *testing.T) {
+	ctx := newSchemaTestContext()
+
+	_, errs := ctx.SetConfigValues(map[string]string{
+		"debug":   "yes",
+		"jobs":    "many",
+		"product": "foo",
+	})
+
+	expected := []string{
+		`required config key "arch" is not set`,
+		`config key "debug" has value "yes", expected true or false`,
+		`config key "jobs" has value "many", expected an integer`,
+		`unknown config key "product"`,
+	}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected errors %q, got %q", expected, got)
+	}
+
+	_, errs = ctx.SetConfigValues(map[string]string{"arch": "mips"})
+	expected = []string{
+		`config key "arch" has value "mips", expected one of "arm", "x86"`,
+	}
+	got = nil
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected errors %q, got %q", expected, got)
+	}
+	if ctx.ConfigValues() != nil {
+		t.Errorf("expected invalid config values not to be set")
+	}
+}
+
+func TestConfigSelectCases(t *testing.T) {
+	ctx := newSchemaTestContext()
+	if _, errs := ctx.SetConfigValues(map[string]string{"arch": "x86"}); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	_, _, _, errs := ctx.parse(".", "Blueprint", bytes.NewBufferString(`
+		config_module {
+			name: "a",
+			cflags: select(arch, {
+				"arm": ["-marm"],
+				"mips": ["-mips"],
+				default: select(jobs, {
+					"lots": ["-j"],
+					default: [],
+				}),
+			}),
+		}
+	`), nil)
+
+	expected := []string{
+		`Blueprint:6:5: select on config key "arch" has case "mips", expected one of "arm", "x86"`,
+		`Blueprint:8:6: select on config key "jobs" has case "lots", expected an integer`,
+	}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected errors %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// ConfigVariablesModuleType is the name of the module type that every Context
+// supports for setting the configuration variables registered with
+// RegisterConfigVariable for a directory subtree.  A config variables module
+// sets the variables for the modules in the directory of its Blueprints file
+// and its subdirectories, unless a subdirectory sets them again, for example:
+//
+//	blueprint_config_variables {
+//	    variables: {
+//	        opt_level: "s",
+//	    },
+//	}
+//
+// lets a vendor directory build its modules with different flags than the
+// rest of the tree without changing the global configuration.
+const ConfigVariablesModuleType = "blueprint_config_variables"
+
+type configVariablesModule struct {
+	properties struct {
+		// Variables maps the names of configuration variables to their
+		// values in the directory subtree.
+		Variables map[string]string
+	}
+}
+
+func newConfigVariablesModule() (Module, []interface{}) {
+	m := &configVariablesModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (m *configVariablesModule) GenerateBuildActions(ModuleContext) {
+}
+
+// RegisterConfigVariable registers a configuration variable that may be set
+// for a directory subtree by a config variables module, and its value in the
+// directories where it isn't set.  The value for a module is returned by
+// BaseModuleContext.ConfigVariable, and for any directory by
+// Context.ConfigVariable.
+//
+// A module may also set properties for the values of the variable with the
+// built-in select property, like those of a platform axis:
+//
+//	select: {
+//	    opt_level: {
+//	        s: { cflags: ["-Os"] },
+//	        default: { cflags: ["-O2"] },
+//	    },
+//	}
+//
+// The Ninja variables of a Go package have a single value, so a value that
+// depends on the directory must be passed to rules as an argument.
+func (c *Context) RegisterConfigVariable(name, defaultValue string) {
+	if c.platformAxis(name) != nil {
+		panic(fmt.Errorf("config variable %s has the name of a platform axis", name))
+	}
+	if _, present := c.configVariables[name]; present {
+		panic(fmt.Errorf("config variable %s is already registered", name))
+	}
+
+	if c.configVariables == nil {
+		c.configVariables = make(map[string]string)
+	}
+
+	c.configVariables[name] = defaultValue
+}
+
+// ConfigVariable returns the value of the registered configuration variable
+// for the modules in dir, a directory relative to the root Blueprints file.
+func (c *Context) ConfigVariable(dir, name string) string {
+	defaultValue, present := c.configVariables[name]
+	if !present {
+		panic(fmt.Errorf("config variable %s is not registered", name))
+	}
+
+	dir = filepath.Clean(dir)
+	for {
+		if module, ok := c.configVariableModules[dir]; ok {
+			variables := module.logicModule.(*configVariablesModule).properties.Variables
+			if value, ok := variables[name]; ok {
+				return value
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return defaultValue
+		}
+		dir = parent
+	}
+}
+
+// addConfigVariables adds the configuration variables set by the config
+// variables module.
+func (c *Context) addConfigVariables(module *moduleInfo) []error {
+	dir := filepath.Dir(module.relBlueprintsFile)
+
+	if c.configVariableModules == nil {
+		c.configVariableModules = make(map[string]*moduleInfo)
+	}
+
+	if first, ok := c.configVariableModules[dir]; ok {
+		return []error{
+			&Error{
+				Err: fmt.Errorf("config variables already set for %q", dir),
+				Pos: module.pos,
+			},
+			&Error{
+				Err: fmt.Errorf("<-- previous definition here"),
+				Pos: first.pos,
+			},
+		}
+	}
+
+	c.configVariableModules[dir] = module
+
+	return nil
+}
+
+// applyConfigVariables checks that the config variables modules only set
+// registered configuration variables, and sets the properties of the select
+// properties of the modules for their values of the variables.
+func (c *Context) applyConfigVariables() (errs []error) {
+	var dirs []string
+	for dir := range c.configVariableModules {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		module := c.configVariableModules[dir]
+		variables := module.logicModule.(*configVariablesModule).properties.Variables
+
+		var names []string
+		for name := range variables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if _, present := c.configVariables[name]; !present {
+				errs = append(errs, &Error{
+					Err: fmt.Errorf("unknown config variable %q", name),
+					Pos: module.propertyPos["variables"],
+				})
+			}
+		}
+	}
+
+	if len(errs) > 0 || len(c.configVariables) == 0 {
+		return errs
+	}
+
+	var names []string
+	for name := range c.configVariables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, moduleName := range c.sortedModuleNames() {
+		for _, module := range c.moduleGroups[moduleName].modules {
+			dir := filepath.Dir(module.relBlueprintsFile)
+			for _, name := range names {
+				if _, ok := module.selects[name]; !ok {
+					continue
+				}
+				errs = append(errs, module.applySelect(name, c.ConfigVariable(dir, name))...)
+
+				// The select is only applied once, even if the dependencies
+				// are resolved again.
+				delete(module.selects, name)
+			}
+		}
+	}
+
+	return errs
+}
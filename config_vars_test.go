@@ -0,0 +1,126 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+type configVariableModule struct {
+	properties struct {
+		Cflags []string
+	}
+	optLevel string
+}
+
+func newConfigVariableModule() (Module, []interface{}) {
+	m := &configVariableModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (m *configVariableModule) GenerateBuildActions(ctx ModuleContext) {
+	m.optLevel = ctx.ConfigVariable("opt_level")
+}
+
+func prepareConfigVariablesTest(files []blueprintsTestFile) (*Context, []error) {
+	return prepareTestFiles(func(ctx *Context) {
+		ctx.RegisterModuleType("config_module", newConfigVariableModule)
+		ctx.RegisterConfigVariable("opt_level", "2")
+	}, files)
+}
+
+func TestConfigVariables(t *testing.T) {
+	selectCflags := `
+		select: {
+			opt_level: {
+				s: { cflags: ["-Os"] },
+				default: { cflags: ["-O2"] },
+			},
+		},`
+
+	ctx, errs := prepareConfigVariablesTest([]blueprintsTestFile{
+		{"Blueprints", `config_module { name: "app",` + selectCflags + ` }`},
+		{"vendor/Blueprints", `
+			blueprint_config_variables { variables: { opt_level: "s" } }
+			config_module { name: "lib",` + selectCflags + ` }
+		`},
+		{"vendor/sub/Blueprints", `config_module { name: "sub",` + selectCflags + ` }`},
+		{"vendor/fast/Blueprints", `
+			blueprint_config_variables { variables: { opt_level: "3" } }
+			config_module { name: "fast",` + selectCflags + ` }
+		`},
+	})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	expected := map[string]struct {
+		optLevel string
+		cflags   []string
+	}{
+		"app":  {"2", []string{"-O2"}},
+		"lib":  {"s", []string{"-Os"}},
+		"sub":  {"s", []string{"-Os"}},
+		"fast": {"3", []string{"-O2"}},
+	}
+
+	ctx.VisitAllModules(func(module Module) {
+		m := module.(*configVariableModule)
+		e := expected[ctx.ModuleName(module)]
+		if m.optLevel != e.optLevel {
+			t.Errorf("%s: expected opt_level %q, got %q", ctx.ModuleName(module),
+				e.optLevel, m.optLevel)
+		}
+		if !reflect.DeepEqual(m.properties.Cflags, e.cflags) {
+			t.Errorf("%s: expected cflags %q, got %q", ctx.ModuleName(module),
+				e.cflags, m.properties.Cflags)
+		}
+	})
+
+	if value := ctx.ConfigVariable("vendor/sub/tool", "opt_level"); value != "s" {
+		t.Errorf("expected opt_level \"s\" in vendor/sub/tool, got %q", value)
+	}
+}
+
+func TestConfigVariablesErrors(t *testing.T) {
+	testCases := []struct {
+		files []blueprintsTestFile
+		err   string
+	}{
+		{
+			files: []blueprintsTestFile{
+				{"a/Blueprints", `blueprint_config_variables { variables: { opt: "s" } }`},
+			},
+			err: `a/Blueprints:1:39: unknown config variable "opt"`,
+		},
+		{
+			files: []blueprintsTestFile{
+				{"a/Blueprints", `
+					blueprint_config_variables { variables: { opt_level: "s" } }
+					blueprint_config_variables { variables: { opt_level: "z" } }
+				`},
+			},
+			err: `a/Blueprints:3:6: config variables already set for "a"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, errs := prepareConfigVariablesTest(testCase.files)
+		if len(errs) == 0 || errs[0].Error() != testCase.err {
+			t.Errorf("expected error %q, got %v", testCase.err, errs)
+		}
+	}
+}
@@ -19,13 +19,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/scanner"
 	"text/template"
 
@@ -72,6 +75,7 @@ type Context struct {
 	mutatorInfo         []*mutatorInfo
 	earlyMutatorInfo    []*earlyMutatorInfo
 	variantMutatorNames []string
+	platformAxes        []*platformAxis
 	moduleNinjaNames    map[string]*moduleGroup
 
 	dependenciesReady bool // set to true on a successful ResolveDependencies
@@ -80,17 +84,139 @@ type Context struct {
 	// set by SetIgnoreUnknownModuleTypes
 	ignoreUnknownModuleTypes bool
 
+	// set by SetAllowMissingDependencies
+	allowMissingDependencies bool
+
+	// set by SetParallelism and SetJobServer
+	parallelism int
+	jobServer   JobServer
+
+	// set by SetStatusLogger
+	statusLogger StatusLogger
+
+	// set by SetOutDir
+	outDir string
+
+	// set by SetNinjaSymlinkOutputs
+	ninjaSymlinkOutputs bool
+
+	// set by SetPackageNaming
+	packageNaming PackageNaming
+
+	// set by RegisterCommandTransformer
+	commandTransformers []CommandTransformer
+
+	// set by DeprecateModuleType and DeprecateProperty
+	deprecatedModuleTypes map[string]deprecation
+	deprecatedProperties  map[string]map[string]deprecation
+
+	// set by SetDeprecatedTagLevel
+	deprecatedTagLevel DeprecationLevel
+
+	// set during ResolveDependencies
+	deprecatedUses []string
+
+	// set by RegisterConfigVariable
+	configVariables map[string]string
+
+	// set by SetSelectAxis and SetConfigValues
+	selectAxes map[string]string
+
+	// set by RegisterConfigKey
+	configKeys map[string]*ConfigKey
+
+	// set by SetConfigValues
+	configValues *ConfigValues
+
+	// set by RegisterExtendedModuleType
+	moduleTypeBases map[string]string
+
+	// set by RestrictModuleTypes
+	moduleTypeRestrictions map[string][]string
+
+	// set by SetDependencyPolicy
+	dependencyPolicy DependencyPolicy
+
+	// set by SetDuplicateModulePolicy
+	duplicateModulePolicy DuplicateModulePolicy
+
+	// set by SetVerifyBuildActions
+	verifyBuildActions bool
+
+	// set by SetValidateManifest
+	validateManifest bool
+
+	// set by SetDedupBuildActions
+	dedupDirs []string
+
+	// set by PrepareBuildActions
+	dedupedBuildActions int
+
+	// set by SetAnalysisCacheDir
+	analysisCacheDir string
+
+	// set during ParseBlueprintsFiles when the analysis cache is enabled
+	analysisCacheUsed map[string]bool
+	analysisCacheHits int
+	analysisCacheLock sync.Mutex
+
+	// set by SetTraceFile
+	tracer *tracer
+
+	// set by SetRecordModuleCalls
+	recordModuleCalls bool
+
+	// set by SetBaseline and SetStrictWarnings
+	baseline       map[string]bool
+	strictWarnings bool
+
+	// set by warn
+	warnings     []*Warning
+	warningKeys  map[string]bool
+	warningsLock sync.Mutex
+
+	// set during ParseBlueprintsFiles
+	assignments     []*parser.Assignment
+	assignmentsLock sync.Mutex
+
+	// set during ParseBlueprintsFiles by the Blueprints files that set
+	// export_scope
+	exportedScopes     map[string]*exportedScope
+	exportedScopesLock sync.Mutex
+
+	// set during addModules by the namespace modules, keyed by their
+	// directories
+	namespaces map[string]*namespace
+
+	// set during addModules by the config variables modules, keyed by their
+	// directories
+	configVariableModules map[string]*moduleInfo
+
+	// set during addModules by the alias modules, keyed like moduleGroups
+	moduleAliases map[string]*moduleInfo
+
+	// set during PrepareBuildActions by ModuleContext.IntermediatesDir and
+	// ModuleContext.GenDir
+	moduleDirOwners     map[string]*moduleInfo
+	moduleDirOwnersLock sync.Mutex
+
+	// set during PrepareBuildActions by ModuleContext.Glob
+	globs     map[string]GlobPath
+	globsLock sync.Mutex
+
 	// set during PrepareBuildActions
 	pkgNames        map[*PackageContext]string
 	globalVariables map[Variable]*ninjaString
 	globalPools     map[Pool]*poolDef
 	globalRules     map[Rule]*ruleDef
+	volatileValues  map[*volatileVariable]string
 
 	// set during PrepareBuildActions
 	buildDir           *ninjaString // The builddir special Ninja variable
 	requiredNinjaMajor int          // For the ninja_required_version variable
 	requiredNinjaMinor int          // For the ninja_required_version variable
 	requiredNinjaMicro int          // For the ninja_required_version variable
+	subninjaDir        string       // The directory of the per-directory Ninja files
 
 	// set lazily by sortedModuleNames
 	cachedSortedModuleNames []string
@@ -105,10 +231,74 @@ type Error struct {
 
 type localBuildActions struct {
 	variables []*localVariable
+	pools     []*localPool
 	rules     []*localRule
 	buildDefs []*buildDef
 }
 
+// localActions implements the ActionEmitter methods that define the local
+// variables, pools, rules and build statements of a module or singleton, so
+// that their parameters are checked the same way and every BuildParams and
+// RuleParams feature works the same for both.  Invalid parameters are a
+// programming error, so they cause a panic, which is reported as an error for
+// the module or singleton.
+type localActions struct {
+	scope      *localScope
+	actionDefs localBuildActions
+}
+
+func (l *localActions) Variable(pctx *PackageContext, name, value string) {
+	l.scope.ReparentTo(pctx)
+
+	v, err := l.scope.AddLocalVariable(name, value)
+	if err != nil {
+		panic(err)
+	}
+
+	l.actionDefs.variables = append(l.actionDefs.variables, v)
+}
+
+func (l *localActions) Pool(pctx *PackageContext, name string,
+	params PoolParams) Pool {
+
+	l.scope.ReparentTo(pctx)
+
+	p, err := l.scope.AddLocalPool(name, &params)
+	if err != nil {
+		panic(err)
+	}
+
+	l.actionDefs.pools = append(l.actionDefs.pools, p)
+
+	return p
+}
+
+func (l *localActions) Rule(pctx *PackageContext, name string,
+	params RuleParams, argNames ...string) Rule {
+
+	l.scope.ReparentTo(pctx)
+
+	r, err := l.scope.AddLocalRule(name, &params, argNames...)
+	if err != nil {
+		panic(err)
+	}
+
+	l.actionDefs.rules = append(l.actionDefs.rules, r)
+
+	return r
+}
+
+func (l *localActions) Build(pctx *PackageContext, params BuildParams) {
+	l.scope.ReparentTo(pctx)
+
+	def, err := parseBuildParams(l.scope, &params)
+	if err != nil {
+		panic(err)
+	}
+
+	l.actionDefs.buildDefs = append(l.actionDefs.buildDefs, def)
+}
+
 type moduleGroup struct {
 	name      string
 	ninjaName string
@@ -118,30 +308,51 @@ type moduleGroup struct {
 
 type moduleInfo struct {
 	// set during Parse
+	def               *parser.Module
 	typeName          string
 	relBlueprintsFile string
 	pos               scanner.Position
 	propertyPos       map[string]scanner.Position
+	deprecatedTags    map[string]string
+	selects           map[string]map[string]*parser.Property
 	properties        struct {
-		Name string
-		Deps []string
+		Name        string
+		Deps        []string
+		Enabled     bool `default:"true"`
+		Visibility  []string
+		Overrides   []string
+		Test_for    string
+		Test_suites []string
 	}
 
+	// set during Parse from the visibility property, nil if the module is
+	// visible to all modules
+	visibility []visibilityRule
+
+	// set during ResolveDependencies from the test_for property, nil if the
+	// module doesn't test another module
+	testFor *moduleGroup
+
 	variantName       string
 	variant           variationMap
 	dependencyVariant variationMap
 
 	logicModule      Module
 	group            *moduleGroup
+	namespace        *namespace
 	moduleProperties []interface{}
 
 	// set during ResolveDependencies
-	directDeps []*moduleInfo
+	directDeps  []*moduleInfo
+	missingDeps []string
 
 	// set during updateDependencies
 	reverseDeps []*moduleInfo
 	depsCount   int
 
+	// set by updateDirectReverseDeps, after the mutators have run
+	directReverseDeps []*moduleInfo
+
 	// used by parallelVisitAllBottomUp
 	waitingCount int
 
@@ -149,7 +360,16 @@ type moduleInfo struct {
 	splitModules []*moduleInfo
 
 	// set during PrepareBuildActions
-	actionDefs localBuildActions
+	actionDefs                   localBuildActions
+	startedGenerateBuildActions  bool
+	finishedGenerateBuildActions bool
+
+	// set during PrepareBuildActions by ModuleContext.SetProvider, indexed
+	// by the id of the provider
+	providers []interface{}
+
+	// set during PrepareBuildActions if SetRecordModuleCalls was enabled
+	callLog *CallLog
 }
 
 // A Variation is a way that a variant of a module differs from other variants of the same module.
@@ -191,9 +411,10 @@ func (vm variationMap) equal(other variationMap) bool {
 }
 
 type singletonInfo struct {
-	// set during RegisterSingletonType
-	factory   SingletonFactory
+	// set during RegisterSingletonType or RegisterParallelSingletonType
+	factory   interface{}
 	singleton Singleton
+	parallel  bool
 
 	// set during PrepareBuildActions
 	actionDefs localBuildActions
@@ -217,18 +438,211 @@ func (e *Error) Error() string {
 	return fmt.Sprintf("%s: %s", e.Pos, e.Err)
 }
 
+// A PanicError describes a panic that was recovered while a module or
+// singleton was generating its build actions.
+type PanicError struct {
+	In    string      // what was running, e.g. `module "foo"`
+	Value interface{} // the value passed to panic
+	Stack []byte      // the stack trace of the panicking goroutine
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic in %s: %v\n\n%s", e.In, e.Value, e.Stack)
+}
+
+// recoverPanic calls f, and returns a PanicError describing the panic if f
+// panics.
+func recoverPanic(in string, f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{
+				In:    in,
+				Value: r,
+				Stack: debug.Stack(),
+			}
+		}
+	}()
+
+	f()
+
+	return nil
+}
+
 // NewContext creates a new Context object.  The created context initially has
 // no module or singleton factories registered, so the RegisterModuleFactory and
 // RegisterSingletonFactory methods must be called before it can do anything
 // useful.
 func NewContext() *Context {
-	return &Context{
+	ctx := &Context{
 		moduleFactories:  make(map[string]ModuleFactory),
 		moduleGroups:     make(map[string]*moduleGroup),
 		moduleInfo:       make(map[Module]*moduleInfo),
 		singletonInfo:    make(map[string]*singletonInfo),
 		moduleNinjaNames: make(map[string]*moduleGroup),
 	}
+
+	ctx.RegisterModuleType(GroupModuleType, newGroupModule)
+	ctx.RegisterModuleType(NamespaceModuleType, newNamespaceModule)
+	ctx.RegisterModuleType(ConfigVariablesModuleType, newConfigVariablesModule)
+	ctx.RegisterModuleType(AliasModuleType, newAliasModule)
+
+	return ctx
+}
+
+// A JobServer allows a Context to cooperate with an external build system
+// (such as a make jobserver) on the number of concurrently running jobs.
+// Acquire is called before the Context starts a unit of parallel work and
+// should block until a job slot is available.  Release is called once for
+// each call to Acquire after that unit of work has finished.
+//
+// Acquire and Release may be called from multiple goroutines.
+type JobServer interface {
+	Acquire()
+	Release()
+}
+
+// SetParallelism sets the maximum number of goroutines that the Context will
+// use to concurrently parse Blueprints files and generate build actions.  If
+// parallelism is less than or equal to zero the value of runtime.GOMAXPROCS is
+// used, which is also the default.  Mutators are always run serially.
+func (c *Context) SetParallelism(parallelism int) {
+	c.parallelism = parallelism
+}
+
+// SetJobServer sets a JobServer that will be consulted before each parallel
+// unit of work is started, in addition to the limit set by SetParallelism.
+// Passing nil removes any previously set JobServer.
+func (c *Context) SetJobServer(jobServer JobServer) {
+	c.jobServer = jobServer
+}
+
+// SetOutDir sets the directory under which ModuleContext.IntermediatesDir and
+// ModuleContext.GenDir lay out their per-module directories.  It is relative to
+// the directory that Ninja is run from, and defaults to that directory.
+func (c *Context) SetOutDir(outDir string) {
+	c.outDir = outDir
+}
+
+// SetNinjaSymlinkOutputs sets whether the BuildParams.SymlinkOutputs of build
+// statements are written to the Ninja manifest as symlink_outputs variables.
+// The variable is only supported by Android's fork of Ninja, so this must only
+// be set to true when the manifest will be run by that fork.  By default the
+// variable is not written, and upstream Ninja treats the symlinks like any
+// other outputs.
+func (c *Context) SetNinjaSymlinkOutputs(ninjaSymlinkOutputs bool) {
+	c.ninjaSymlinkOutputs = ninjaSymlinkOutputs
+}
+
+// A CommandTransformer rewrites the commands of the rules in the Ninja file,
+// for example to run every command under a wrapper like ccache, a tracer or a
+// sandbox, without changing the RuleParams of every module type.
+type CommandTransformer interface {
+	// TransformCommand returns the command to use in place of command, the
+	// value of the "command" variable of rule.  The name, pool and other
+	// variables of rule, like "description" and "generator", may be used to
+	// decide how to transform it.  Both commands use Ninja syntax, so literal
+	// "$" characters must be escaped as "$$".
+	TransformCommand(rule *ManifestRule, command string) string
+}
+
+// RegisterCommandTransformer registers a CommandTransformer that is applied to
+// the command of every rule that is written by WriteBuildFile or
+// WriteManifest.  CommandTransformers are applied in the order in which they
+// were registered.
+func (c *Context) RegisterCommandTransformer(transformer CommandTransformer) {
+	c.commandTransformers = append(c.commandTransformers, transformer)
+}
+
+// manifestRule returns the ManifestRule for def named name, with its command
+// transformed by the registered CommandTransformers.
+func (c *Context) manifestRule(def *ruleDef, name string) *ManifestRule {
+	rule := def.manifestRule(name, c.pkgNames)
+
+	if command, ok := rule.Variables["command"]; ok {
+		for _, transformer := range c.commandTransformers {
+			command = transformer.TransformCommand(rule, command)
+		}
+		rule.Variables["command"] = command
+	}
+
+	return rule
+}
+
+// SetSelectAxis sets the value of a configuration axis, such as the target
+// architecture, that select expressions in the Blueprints files may choose
+// their values on:
+//
+//	cflags: select(arch, {
+//	    "arm": ["-marm"],
+//	    "x86": ["-m32"],
+//	    default: [],
+//	}),
+//
+// Unlike the platform axes registered with RegisterPlatformAxis, the axes
+// have a single value for the whole build, so the selects are resolved when
+// the Blueprints files are parsed.  It must be called before
+// ParseBlueprintsFiles.  A select on an axis that isn't set, or that has no
+// case for the value of the axis and no default case, is an error.
+func (c *Context) SetSelectAxis(name, value string) {
+	if c.selectAxes == nil {
+		c.selectAxes = make(map[string]string)
+	}
+	c.selectAxes[name] = value
+}
+
+// SetPackageNaming sets how the Go packages that define the Ninja variables,
+// rules and pools used by the build are named in the Ninja file.  The default
+// is ShortPackageNames.
+func (c *Context) SetPackageNaming(naming PackageNaming) {
+	c.packageNaming = naming
+}
+
+// claimModuleDir records that dir is used by module, and returns the module
+// that first claimed dir.
+func (c *Context) claimModuleDir(dir string, module *moduleInfo) *moduleInfo {
+	c.moduleDirOwnersLock.Lock()
+	defer c.moduleDirOwnersLock.Unlock()
+
+	if owner, ok := c.moduleDirOwners[dir]; ok {
+		return owner
+	}
+
+	c.moduleDirOwners[dir] = module
+	return module
+}
+
+// A jobLimiter limits the number of concurrently running jobs to the
+// parallelism of the Context, and acquires job slots from the Context's
+// JobServer if one was set.
+type jobLimiter struct {
+	slots     chan struct{}
+	jobServer JobServer
+}
+
+func (c *Context) newJobLimiter() *jobLimiter {
+	parallelism := c.parallelism
+	if parallelism <= 0 {
+		parallelism = runtime.GOMAXPROCS(0)
+	}
+
+	return &jobLimiter{
+		slots:     make(chan struct{}, parallelism),
+		jobServer: c.jobServer,
+	}
+}
+
+func (l *jobLimiter) acquire() {
+	l.slots <- struct{}{}
+	if l.jobServer != nil {
+		l.jobServer.Acquire()
+	}
+}
+
+func (l *jobLimiter) release() {
+	if l.jobServer != nil {
+		l.jobServer.Release()
+	}
+	<-l.slots
 }
 
 // A ModuleFactory function creates a new Module object.  See the
@@ -262,7 +676,7 @@ type ModuleFactory func() (m Module, propertyStructs []interface{})
 // struct containing a field that is not one these supported types.
 //
 // Any properties that appear in the Blueprints files that are not built-in
-// module properties (such as "name" and "deps") and do not have a corresponding
+// module properties (such as "name", "deps" and "enabled") and do not have a corresponding
 // field in the returned module properties struct result in an error during the
 // Context's parse phase.
 //
@@ -327,6 +741,27 @@ func (c *Context) RegisterSingletonType(name string, factory SingletonFactory) {
 	}
 }
 
+// A ParallelSingletonFactory function creates a new ParallelSingleton object.
+// See the Context.RegisterParallelSingletonType method for details about how a
+// registered ParallelSingletonFactory is used by a Context.
+type ParallelSingletonFactory func() ParallelSingleton
+
+// RegisterParallelSingletonType registers a singleton type like
+// RegisterSingletonType, except that before its GenerateBuildActions method is
+// called its VisitModule method is called for every module concurrently, and
+// the results are passed to its MergeModuleData method.
+func (c *Context) RegisterParallelSingletonType(name string, factory ParallelSingletonFactory) {
+	if _, present := c.singletonInfo[name]; present {
+		panic(errors.New("singleton name is already registered"))
+	}
+
+	c.singletonInfo[name] = &singletonInfo{
+		factory:   factory,
+		singleton: factory(),
+		parallel:  true,
+	}
+}
+
 func singletonPkgPath(singleton Singleton) string {
 	typ := reflect.TypeOf(singleton)
 	for typ.Kind() == reflect.Ptr {
@@ -424,6 +859,19 @@ func (c *Context) SetIgnoreUnknownModuleTypes(ignoreUnknownModuleTypes bool) {
 	c.ignoreUnknownModuleTypes = ignoreUnknownModuleTypes
 }
 
+// SetAllowMissingDependencies sets the behavior of the context in the case
+// where a module depends on a module that isn't defined.  By default this is
+// reported as an error by ResolveDependencies.  If this method is called with
+// allowMissingDependencies set to true then the dependency is skipped, and
+// the module can find the names of the modules that it couldn't depend on
+// with ModuleContext.GetMissingDependencies.  This allows a subset of a source
+// tree to be built when some of the modules that it refers to are absent, as
+// long as the modules that depend on them only fail when they are built, for
+// example by writing build statements that print an error.
+func (c *Context) SetAllowMissingDependencies(allowMissingDependencies bool) {
+	c.allowMissingDependencies = allowMissingDependencies
+}
+
 // Parse parses a single Blueprints file from r, creating Module objects for
 // each of the module definitions encountered.  If the Blueprints file contains
 // an assignment to the "subdirs" variable, then the subdirectories listed are
@@ -446,7 +894,10 @@ func (c *Context) parse(rootDir, filename string, r io.Reader,
 	scope = parser.NewScope(scope)
 	scope.Remove("subdirs")
 	scope.Remove("build")
-	file, errs := parser.ParseAndEval(filename, r, scope)
+	scope.Remove("imports")
+	scope.Remove("export_scope")
+	scope.Remove("exports")
+	file, errs := c.parseAndEval(filename, r, scope)
 	if len(errs) > 0 {
 		for i, err := range errs {
 			if parseErr, ok := err.(*parser.ParseError); ok {
@@ -471,7 +922,13 @@ func (c *Context) parse(rootDir, filename string, r io.Reader,
 			newModule, newErrs = c.processModuleDef(def, relBlueprintsFile)
 
 		case *parser.Assignment:
-			// Already handled via Scope object
+			// Already handled via Scope object, but remember the variables
+			// that were added to the scope to check that they are used.
+			if a, err := scope.Get(def.Name.Name); err == nil && a == def {
+				c.assignmentsLock.Lock()
+				c.assignments = append(c.assignments, def)
+				c.assignmentsLock.Unlock()
+			}
 		default:
 			panic("unknown definition type")
 		}
@@ -486,6 +943,8 @@ func (c *Context) parse(rootDir, filename string, r io.Reader,
 		}
 	}
 
+	errs = append(errs, c.exportScope(filename, scope)...)
+
 	subdirs, subdirsPos, err := getStringListFromScope(scope, "subdirs")
 	if err != nil {
 		errs = append(errs, err)
@@ -517,6 +976,24 @@ type stringAndScope struct {
 	*parser.Scope
 }
 
+// An exportedScope holds the variables that a Blueprints file exports to a
+// named scope, which other Blueprints files can import.
+type exportedScope struct {
+	filename string
+	pos      scanner.Position
+	vars     []*parser.Assignment
+}
+
+// A pendingBlueprints is a Blueprints file that imports scopes, and so can't
+// be evaluated until the files that export them have been.
+type pendingBlueprints struct {
+	stringAndScope
+	data       []byte
+	imports    []string
+	importsPos scanner.Position
+	export     string
+}
+
 // ParseBlueprintsFiles parses a set of Blueprints files starting with the file
 // at rootFile.  When it encounters a Blueprints file with a set of subdirs
 // listed it recursively parses any Blueprints files found in those
@@ -526,9 +1003,26 @@ type stringAndScope struct {
 // which the future output will depend is returned.  This list will include both
 // Blueprints file paths as well as directory paths for cases where wildcard
 // subdirs are found.
+//
+// Besides inheriting the variables of the Blueprints file that lists it in
+// subdirs, a Blueprints file can import the variables that other files export
+// to a named scope:
+//
+//	export_scope = "java"
+//	exports = ["javacflags"]
+//
+// makes javacflags available to every Blueprints file that sets
+//
+//	imports = ["java"]
+//
+// The values of imports and export_scope must be literals, because they are
+// needed before the file is evaluated.  A file that imports a scope is
+// evaluated after the file that exports it, and depends on it.
 func (c *Context) ParseBlueprintsFiles(rootFile string) (deps []string,
 	errs []error) {
 
+	defer c.traceTopLevel("parse", &errs)()
+
 	c.dependenciesReady = false
 
 	rootDir := filepath.Dir(rootFile)
@@ -541,21 +1035,69 @@ func (c *Context) ParseBlueprintsFiles(rootFile string) (deps []string,
 	modulesCh := make(chan []*moduleInfo)
 	depsCh := make(chan string)
 
-	// Channel to notify main loop that a parseBlueprintsFile goroutine has finished
-	doneCh := make(chan struct{})
+	// Channel to notify main loop that a parseBlueprintsFile goroutine has
+	// finished, with the Blueprints file if it is waiting for imports
+	doneCh := make(chan *pendingBlueprints)
 
 	// Number of outstanding goroutines to wait for
 	count := 0
 
+	// Blueprints files waiting for the scopes they import to be exported
+	var pending []*pendingBlueprints
+
+	// Modules are added once all of the files are parsed, when the
+	// namespaces that they belong to are known
+	var modules []*moduleInfo
+
+	jobs := c.newJobLimiter()
+
+	status := c.startStatus("parse", "files", 0)
+	defer status.finish()
+
 	startParseBlueprintsFile := func(filename string, scope *parser.Scope) {
 		count++
+		status.add(0, 1)
 		go func() {
-			c.parseBlueprintsFile(filename, scope, rootDir,
+			jobs.acquire()
+			p := c.parseBlueprintsFile(filename, scope, rootDir,
 				errsCh, modulesCh, blueprintsCh, depsCh)
-			doneCh <- struct{}{}
+			jobs.release()
+			doneCh <- p
 		}()
 	}
 
+	// startPendingBlueprintsFiles evaluates the pending Blueprints files
+	// whose imports have all been exported.
+	startPendingBlueprintsFiles := func() {
+		var stillPending []*pendingBlueprints
+		for _, p := range pending {
+			scope, exporters, ready, newErrs := c.importScopes(p)
+			if !ready {
+				stillPending = append(stillPending, p)
+				continue
+			}
+
+			// The file depends on the files that it imports from.
+			deps = append(deps, exporters...)
+
+			if len(newErrs) > 0 {
+				errs = append(errs, newErrs...)
+				status.add(1, 0)
+				continue
+			}
+
+			count++
+			go func(p *pendingBlueprints) {
+				jobs.acquire()
+				c.evalBlueprintsFile(p.string, p.data, scope, rootDir,
+					errsCh, modulesCh, blueprintsCh, depsCh)
+				jobs.release()
+				doneCh <- nil
+			}(p)
+		}
+		pending = stillPending
+	}
+
 	tooManyErrors := false
 
 	startParseBlueprintsFile(rootFile, nil)
@@ -571,9 +1113,8 @@ loop:
 			errs = append(errs, newErrs...)
 		case dep := <-depsCh:
 			deps = append(deps, dep)
-		case modules := <-modulesCh:
-			newErrs := c.addModules(modules)
-			errs = append(errs, newErrs...)
+		case newModules := <-modulesCh:
+			modules = append(modules, newModules...)
 		case blueprint := <-blueprintsCh:
 			if tooManyErrors {
 				continue
@@ -584,32 +1125,147 @@ loop:
 
 			blueprintsSet[blueprint.string] = true
 			startParseBlueprintsFile(blueprint.string, blueprint.Scope)
-		case <-doneCh:
+		case p := <-doneCh:
 			count--
+			if p != nil {
+				pending = append(pending, p)
+			} else {
+				status.add(1, 0)
+			}
+			if len(pending) > 0 {
+				startPendingBlueprintsFiles()
+			}
 			if count == 0 {
 				break loop
 			}
 		}
 	}
 
+	if len(pending) > 0 {
+		errs = append(errs, c.importErrors(pending)...)
+		status.add(len(pending), 0)
+	}
+
+	c.exportedScopes = nil
+
+	// The files are parsed in parallel, so the modules are sorted to add them
+	// in the same order in every run, which makes the names that are made
+	// unique while adding them the same.
+	sort.Sort(moduleDefSorter(modules))
+
+	errs = append(errs, c.addModules(modules)...)
+
+	errs = append(errs, c.checkUnusedVariables(rootDir)...)
+
+	if c.analysisCacheDir != "" && len(errs) == 0 {
+		// Failing to prune the cache only leaves unused entries behind.
+		c.pruneAnalysisCache()
+	}
+
 	return
 }
 
+type assignmentSorter []*parser.Assignment
+
+func (s assignmentSorter) Len() int {
+	return len(s)
+}
+
+func (s assignmentSorter) Less(i, j int) bool {
+	iPos, jPos := s[i].Name.Pos, s[j].Name.Pos
+	if iPos.Filename != jPos.Filename {
+		return iPos.Filename < jPos.Filename
+	}
+	return iPos.Offset < jPos.Offset
+}
+
+func (s assignmentSorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// checkUnusedVariables reports the variables assigned in the parsed Blueprints
+// files that were never referenced, either by the file itself or by the
+// Blueprints files in its subdirectories.
+func (c *Context) checkUnusedVariables(rootDir string) (errs []error) {
+	// The files were parsed concurrently, so sort the variables to report
+	// them in a consistent order.
+	sort.Sort(assignmentSorter(c.assignments))
+
+	for _, a := range c.assignments {
+		switch a.Name.Name {
+		case "subdirs", "build", "subname", "imports", "export_scope", "exports":
+			// These are used by the Context itself.
+			continue
+		}
+
+		if a.Referenced {
+			continue
+		}
+
+		file, err := filepath.Rel(rootDir, a.Name.Pos.Filename)
+		if err != nil {
+			file = a.Name.Pos.Filename
+		}
+
+		err = c.warn(fmt.Sprintf("%s: unused variable %s", file, a.Name.Name),
+			a.Name.Pos, fmt.Errorf("variable %q is never used", a.Name.Name),
+			false)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	c.assignments = nil
+
+	return errs
+}
+
 // parseBlueprintFile parses a single Blueprints file, returning any errors through
 // errsCh, any defined modules through modulesCh, any sub-Blueprints files through
 // blueprintsCh, and any dependencies on Blueprints files or directories through
-// depsCh.
+// depsCh.  If the file imports scopes it is returned instead, to be evaluated
+// once they have been exported.
 func (c *Context) parseBlueprintsFile(filename string, scope *parser.Scope, rootDir string,
 	errsCh chan<- []error, modulesCh chan<- []*moduleInfo, blueprintsCh chan<- stringAndScope,
-	depsCh chan<- string) {
+	depsCh chan<- string) *pendingBlueprints {
 
-	file, err := os.Open(filename)
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		errsCh <- []error{err}
-		return
+		return nil
+	}
+
+	imports, importsPos, export, errs := scanImports(filename, data)
+	if len(errs) > 0 {
+		errsCh <- errs
+		return nil
 	}
 
-	modules, subBlueprints, deps, errs := c.parse(rootDir, filename, file, scope)
+	if len(imports) > 0 {
+		return &pendingBlueprints{
+			stringAndScope: stringAndScope{filename, scope},
+			data:           data,
+			imports:        imports,
+			importsPos:     importsPos,
+			export:         export,
+		}
+	}
+
+	c.evalBlueprintsFile(filename, data, scope, rootDir,
+		errsCh, modulesCh, blueprintsCh, depsCh)
+	return nil
+}
+
+// evalBlueprintsFile evaluates the contents of a single Blueprints file in
+// scope, returning the results through the channels like parseBlueprintsFile.
+func (c *Context) evalBlueprintsFile(filename string, data []byte, scope *parser.Scope,
+	rootDir string, errsCh chan<- []error, modulesCh chan<- []*moduleInfo,
+	blueprintsCh chan<- stringAndScope, depsCh chan<- string) {
+
+	end := c.traceItem(filename, "parse")
+	modules, subBlueprints, deps, errs := c.parse(rootDir, filename,
+		bytes.NewReader(data), scope)
+	end()
 	if len(errs) > 0 {
 		errsCh <- errs
 	}
@@ -622,12 +1278,277 @@ func (c *Context) parseBlueprintsFile(filename string, scope *parser.Scope, root
 		depsCh <- d
 	}
 
-	err = file.Close()
+	modulesCh <- modules
+}
+
+// scanImports returns the scopes imported by the Blueprints file with the
+// contents data, and the scope that it exports, without evaluating it.
+func scanImports(filename string, data []byte) (imports []string,
+	importsPos scanner.Position, export string, errs []error) {
+
+	// Most files don't import anything, so avoid parsing them twice.
+	if !bytes.Contains(data, []byte("imports")) {
+		return nil, scanner.Position{}, "", nil
+	}
+
+	file, errs := parser.Parse(filename, bytes.NewReader(data), nil)
+	if len(errs) > 0 {
+		// Evaluating the file will report the errors.
+		return nil, scanner.Position{}, "", nil
+	}
+
+	for _, def := range file.Defs {
+		a, ok := def.(*parser.Assignment)
+		if !ok {
+			continue
+		}
+
+		switch a.Name.Name {
+		case "imports":
+			if !isStringLiteralList(a.Value) {
+				return nil, scanner.Position{}, "", []error{&Error{
+					Err: fmt.Errorf(`"imports" must be a list of string literals`),
+					Pos: a.Pos,
+				}}
+			}
+			for _, v := range a.Value.ListValue {
+				imports = append(imports, v.StringValue)
+			}
+			importsPos = a.Pos
+		case "export_scope":
+			if isStringLiteral(a.Value) {
+				export = a.Value.StringValue
+			}
+		}
+	}
+
+	return imports, importsPos, export, nil
+}
+
+func isStringLiteral(v parser.Value) bool {
+	return v.Type == parser.String && v.Variable == "" && v.Expression == nil
+}
+
+func isStringLiteralList(v parser.Value) bool {
+	if v.Type != parser.List || v.Variable != "" || v.Expression != nil {
+		return false
+	}
+	for _, elem := range v.ListValue {
+		if !isStringLiteral(elem) {
+			return false
+		}
+	}
+	return true
+}
+
+// exportScope records the variables listed in the exports variable of a
+// Blueprints file in the scope named by its export_scope variable.
+func (c *Context) exportScope(filename string, scope *parser.Scope) []error {
+	name, namePos, err := getStringFromScope(scope, "export_scope")
 	if err != nil {
-		errsCh <- []error{err}
+		return []error{err}
 	}
 
-	modulesCh <- modules
+	exports, exportsPos, err := getStringListFromScope(scope, "exports")
+	if err != nil {
+		return []error{err}
+	}
+
+	if name == "" {
+		if exports != nil {
+			return []error{&Error{
+				Err: fmt.Errorf(`"exports" requires "export_scope"`),
+				Pos: exportsPos,
+			}}
+		}
+		return nil
+	}
+
+	var errs []error
+
+	export := &exportedScope{filename: filename, pos: namePos}
+	for _, v := range exports {
+		a, err := scope.Get(v)
+		if err != nil {
+			errs = append(errs, &Error{
+				Err: fmt.Errorf("exported variable %q is not set", v),
+				Pos: exportsPos,
+			})
+			continue
+		}
+
+		// Exporting a variable counts as using it.
+		a.Referenced = true
+		export.vars = append(export.vars, a)
+	}
+
+	c.exportedScopesLock.Lock()
+	defer c.exportedScopesLock.Unlock()
+
+	if old, exists := c.exportedScopes[name]; exists {
+		// The files are parsed concurrently, so always report the error on
+		// the file that sorts last.
+		if filename < old.filename {
+			c.exportedScopes[name] = export
+			old, export = export, old
+		}
+		return append(errs, &Error{
+			Err: fmt.Errorf("scope %q is already exported by %s", name, old.filename),
+			Pos: export.pos,
+		})
+	}
+
+	if c.exportedScopes == nil {
+		c.exportedScopes = make(map[string]*exportedScope)
+	}
+	c.exportedScopes[name] = export
+
+	return errs
+}
+
+// importScopes returns the scope to evaluate a pending Blueprints file in,
+// containing the variables of the scopes it imports, and the files that
+// export them.  ready is false if some of the scopes haven't been exported yet.
+func (c *Context) importScopes(p *pendingBlueprints) (scope *parser.Scope,
+	exporters []string, ready bool, errs []error) {
+
+	c.exportedScopesLock.Lock()
+	defer c.exportedScopesLock.Unlock()
+
+	for _, name := range p.imports {
+		if c.exportedScopes[name] == nil {
+			return nil, nil, false, nil
+		}
+	}
+
+	scope = parser.NewScope(p.Scope)
+	for _, name := range p.imports {
+		export := c.exportedScopes[name]
+		exporters = append(exporters, export.filename)
+
+		for _, a := range export.vars {
+			if old, err := scope.Get(a.Name.Name); err == nil {
+				// The file may have inherited the variable from a file that
+				// imported the same scope.
+				if old != a {
+					errs = append(errs, &Error{
+						Err: fmt.Errorf("variable %q imported from scope %q is already set, "+
+							"previous assignment: %s", a.Name.Name, name, old),
+						Pos: p.importsPos,
+					})
+				}
+				continue
+			}
+			scope.Add(a)
+		}
+	}
+
+	return scope, exporters, true, errs
+}
+
+type pendingBlueprintsSorter []*pendingBlueprints
+
+func (s pendingBlueprintsSorter) Len() int           { return len(s) }
+func (s pendingBlueprintsSorter) Less(i, j int) bool { return s[i].string < s[j].string }
+func (s pendingBlueprintsSorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// importErrors returns the errors for the Blueprints files that were never
+// evaluated, because they import scopes that no file exports or that are
+// exported by files that import each other's scopes.
+func (c *Context) importErrors(pending []*pendingBlueprints) (errs []error) {
+	// The files were parsed concurrently, so sort them to report the errors
+	// in a consistent order.
+	sort.Sort(pendingBlueprintsSorter(pending))
+
+	exporters := make(map[string]*pendingBlueprints)
+	for _, p := range pending {
+		if p.export != "" {
+			exporters[p.export] = p
+		}
+	}
+
+	missing := func(p *pendingBlueprints) (names []string) {
+		for _, name := range p.imports {
+			if c.exportedScopes[name] == nil {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+
+	for _, p := range pending {
+		for _, name := range missing(p) {
+			if exporters[name] == nil {
+				errs = append(errs, &Error{
+					Err: fmt.Errorf("scope %q is not exported by any Blueprints file", name),
+					Pos: p.importsPos,
+				})
+			}
+		}
+	}
+
+	// Files that import each other's scopes never get evaluated.  Report each
+	// cycle once, starting from the first file in it that was found.
+	var stack []*pendingBlueprints
+	var stackImports []string
+	visited := make(map[*pendingBlueprints]bool)
+	checking := make(map[*pendingBlueprints]bool)
+
+	cycleError := func(start int) {
+		errs = append(errs, &Error{
+			Err: fmt.Errorf("encountered import cycle:"),
+			Pos: stack[start].importsPos,
+		})
+
+		for i := start; i < len(stack); i++ {
+			next := stack[start]
+			if i+1 < len(stack) {
+				next = stack[i+1]
+			}
+			errs = append(errs, &Error{
+				Err: fmt.Errorf("    %s imports %q from %s", stack[i].string,
+					stackImports[i], next.string),
+				Pos: stack[i].importsPos,
+			})
+		}
+	}
+
+	var check func(p *pendingBlueprints)
+	check = func(p *pendingBlueprints) {
+		visited[p] = true
+		checking[p] = true
+		stack = append(stack, p)
+
+		for _, name := range missing(p) {
+			next := exporters[name]
+			if next == nil {
+				continue
+			}
+
+			stackImports = append(stackImports, name)
+			if checking[next] {
+				for start := range stack {
+					if stack[start] == next {
+						cycleError(start)
+					}
+				}
+			} else if !visited[next] {
+				check(next)
+			}
+			stackImports = stackImports[:len(stackImports)-1]
+		}
+
+		stack = stack[:len(stack)-1]
+		delete(checking, p)
+	}
+
+	for _, p := range pending {
+		if !visited[p] {
+			check(p)
+		}
+	}
+
+	return errs
 }
 
 func (c *Context) findSubdirBlueprints(dir string, subdirs, build []string, subBlueprintsName string,
@@ -835,10 +1756,14 @@ func (c *Context) createVariations(origModule *moduleInfo, mutatorName string,
 		m := *origModule
 		newModule := &m
 		newModule.directDeps = append([]*moduleInfo(nil), origModule.directDeps...)
+		newModule.missingDeps = append([]string(nil), origModule.missingDeps...)
 		newModule.logicModule = newLogicModule
 		newModule.variant = newVariant
 		newModule.dependencyVariant = origModule.dependencyVariant.clone()
-		newModule.moduleProperties = newProperties
+		// The built-in properties belong to the copied moduleInfo, so that
+		// they can differ between the variants.
+		newModule.moduleProperties = append([]interface{}{&newModule.properties},
+			newProperties[1:]...)
 
 		if newModule.variantName == "" {
 			newModule.variantName = variationName
@@ -923,9 +1848,19 @@ func (c *Context) processModuleDef(moduleDef *parser.Module,
 		}
 	}
 
+	if err := c.checkModuleTypeAllowed(typeName, relBlueprintsFile); err != nil {
+		return nil, []error{
+			&Error{
+				Err: err,
+				Pos: moduleDef.Type.Pos,
+			},
+		}
+	}
+
 	logicModule, properties := factory()
 
 	module := &moduleInfo{
+		def:               moduleDef,
 		logicModule:       logicModule,
 		typeName:          typeName,
 		relBlueprintsFile: relBlueprintsFile,
@@ -937,7 +1872,22 @@ func (c *Context) processModuleDef(moduleDef *parser.Module,
 	properties = append(props, properties...)
 	module.moduleProperties = properties
 
-	propertyMap, errs := unpackProperties(moduleDef.Properties, properties...)
+	errs := c.checkSelectCases(moduleDef.Properties)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	propertyDefs, errs := parser.ResolveSelects(moduleDef.Properties, c.selectAxes)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	propertyDefs, selectDef, selects, errs := c.splitSelect(propertyDefs, properties)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	propertyMap, deprecated, errs := unpackProperties(propertyDefs, properties...)
 	if len(errs) > 0 {
 		return nil, errs
 	}
@@ -947,45 +1897,110 @@ func (c *Context) processModuleDef(moduleDef *parser.Module,
 	for name, propertyDef := range propertyMap {
 		module.propertyPos[name] = propertyDef.Pos
 	}
+	if selectDef != nil {
+		module.propertyPos[selectDef.Name.Name] = selectDef.Pos
+	}
+	module.selects = selects
+	module.deprecatedTags = deprecated
+
+	module.visibility, errs = parseVisibility(module)
+	if len(errs) > 0 {
+		return nil, errs
+	}
 
 	return module, nil
 }
 
 func (c *Context) addModules(modules []*moduleInfo) (errs []error) {
+	// The namespaces are added first so that the modules in the same files
+	// are added to them.
 	for _, module := range modules {
-		name := module.properties.Name
-		c.moduleInfo[module.logicModule] = module
+		switch module.typeName {
+		case NamespaceModuleType:
+			errs = append(errs, c.addNamespace(module)...)
+		case ConfigVariablesModuleType:
+			errs = append(errs, c.addConfigVariables(module)...)
+		}
+	}
 
-		if group, present := c.moduleGroups[name]; present {
-			errs = append(errs, []error{
-				&Error{
-					Err: fmt.Errorf("module %q already defined", name),
-					Pos: module.pos,
-				},
-				&Error{
-					Err: fmt.Errorf("<-- previous definition here"),
-					Pos: group.modules[0].pos,
-				},
-			}...)
+	// The definitions of each module are collected first, so that a module
+	// that is defined more than once can be resolved with the policy set
+	// by SetDuplicateModulePolicy.
+	var names []string
+	var aliases []*moduleInfo
+	defs := make(map[string][]*moduleInfo)
+	for _, module := range modules {
+		switch module.typeName {
+		case NamespaceModuleType, ConfigVariablesModuleType:
 			continue
-		} else {
-			ninjaName := toNinjaName(module.properties.Name)
+		case AliasModuleType:
+			aliases = append(aliases, module)
+			continue
+		}
+
+		module.namespace = c.moduleNamespace(module.relBlueprintsFile)
+		name := module.namespace.key(module.properties.Name)
+		if defs[name] == nil {
+			names = append(names, name)
+		}
+		defs[name] = append(defs[name], module)
+	}
+
+	for _, name := range names {
+		module := defs[name][0]
+		group, present := c.moduleGroups[name]
+
+		if alias, ok := c.moduleAliases[name]; ok {
+			errs = append(errs, duplicateModuleErrors(name, module, alias)...)
+			continue
+		}
 
-			// The sanitizing in toNinjaName can result in collisions, uniquify the name if it
-			// already exists
-			for i := 0; c.moduleNinjaNames[ninjaName] != nil; i++ {
-				ninjaName = toNinjaName(module.properties.Name) + strconv.Itoa(i)
+		if present || len(defs[name]) > 1 {
+			var all []*moduleInfo
+			if present {
+				all = append(all, group.modules[0])
 			}
+			all = append(all, defs[name]...)
 
-			group := &moduleGroup{
-				name:      module.properties.Name,
-				ninjaName: ninjaName,
-				modules:   []*moduleInfo{module},
+			var newErrs []error
+			module, newErrs = c.resolveDuplicateModules(name, all)
+			errs = append(errs, newErrs...)
+		}
+
+		if present {
+			if module != group.modules[0] {
+				delete(c.moduleInfo, group.modules[0].logicModule)
+				c.moduleInfo[module.logicModule] = module
+				module.group = group
+				group.modules[0] = module
 			}
-			module.group = group
-			c.moduleGroups[name] = group
-			c.moduleNinjaNames[ninjaName] = group
+			continue
+		}
+
+		c.moduleInfo[module.logicModule] = module
+
+		ninjaName := toNinjaName(module.properties.Name)
+
+		// The sanitizing in toNinjaName can result in collisions, uniquify the name if it
+		// already exists
+		for i := 0; c.moduleNinjaNames[ninjaName] != nil; i++ {
+			ninjaName = toNinjaName(module.properties.Name) + strconv.Itoa(i)
+		}
+
+		group = &moduleGroup{
+			name:      module.properties.Name,
+			ninjaName: ninjaName,
+			modules:   []*moduleInfo{module},
 		}
+		module.group = group
+		c.moduleGroups[name] = group
+		c.moduleNinjaNames[ninjaName] = group
+	}
+
+	// The aliases are added last, so that an alias with the name of a
+	// module is reported as its duplicate.
+	for _, alias := range aliases {
+		errs = append(errs, c.addAlias(alias)...)
 	}
 
 	return errs
@@ -999,18 +2014,53 @@ func (c *Context) addModules(modules []*moduleInfo) (errs []error) {
 // The config argument is made available to all of the DynamicDependerModule
 // objects via the Config method on the DynamicDependerModuleContext objects
 // passed to their DynamicDependencies method.
-func (c *Context) ResolveDependencies(config interface{}) []error {
-	errs := c.runEarlyMutators(config)
+func (c *Context) ResolveDependencies(config interface{}) (errs []error) {
+	defer c.traceTopLevel("resolve dependencies", &errs)()
+
+	errs = c.checkDeprecations()
+	if len(errs) > 0 {
+		return errs
+	}
+
+	errs = c.checkNamespaces()
+	if len(errs) > 0 {
+		return errs
+	}
+
+	errs = c.applyConfigVariables()
+	if len(errs) > 0 {
+		return errs
+	}
+
+	errs = c.runEarlyMutators(config)
 	if len(errs) > 0 {
 		return errs
 	}
 
+	end := c.tracePhase("dependencies")
 	errs = c.resolveDependencies(config)
+	if len(errs) == 0 {
+		errs = c.overrideModules()
+	}
+	if len(errs) == 0 {
+		errs = c.updateDependencies()
+	}
+	end()
 	if len(errs) > 0 {
 		return errs
 	}
 
-	errs = c.updateDependencies()
+	errs = c.checkVisibility()
+	if len(errs) > 0 {
+		return errs
+	}
+
+	errs = c.checkDependencyPolicy()
+	if len(errs) > 0 {
+		return errs
+	}
+
+	errs = c.resolveTests()
 	if len(errs) > 0 {
 		return errs
 	}
@@ -1069,6 +2119,9 @@ func (c *Context) moduleDeps(module *moduleInfo,
 			errs = append(errs, newErrs...)
 		}
 	}
+
+	errs = append(errs, c.addModuleReferenceDependencies(module)...)
+
 	return errs
 }
 
@@ -1078,6 +2131,7 @@ func (c *Context) resolveDependencies(config interface{}) (errs []error) {
 	for _, group := range c.moduleGroups {
 		for _, module := range group.modules {
 			module.directDeps = make([]*moduleInfo, 0, len(module.properties.Deps))
+			module.missingDeps = nil
 
 			newErrs := c.moduleDeps(module, config)
 			if len(newErrs) > 0 {
@@ -1099,13 +2153,12 @@ func (c *Context) addDependency(module *moduleInfo, depName string) []error {
 		}}
 	}
 
-	depInfo, ok := c.moduleGroups[depName]
-	if !ok {
-		return []error{&Error{
-			Err: fmt.Errorf("%q depends on undefined module %q",
-				module.properties.Name, depName),
-			Pos: depsPos,
-		}}
+	depInfo, err := c.lookupModuleGroup(module, depName)
+	if err != nil {
+		return []error{&Error{Err: err, Pos: depsPos}}
+	}
+	if depInfo == nil {
+		return c.missingDependency(module, depName)
 	}
 
 	for _, m := range module.directDeps {
@@ -1134,18 +2187,68 @@ func (c *Context) addDependency(module *moduleInfo, depName string) []error {
 	}}
 }
 
+// missingDependency records that module depends on the undefined module
+// depName if missing dependencies are allowed or the module is disabled, or
+// returns an error otherwise.
+func (c *Context) missingDependency(module *moduleInfo, depName string) []error {
+	if c.allowMissingDependencies || !module.properties.Enabled {
+		for _, name := range module.missingDeps {
+			if name == depName {
+				return nil
+			}
+		}
+		module.missingDeps = append(module.missingDeps, depName)
+		return nil
+	}
+
+	return []error{&Error{
+		Err: fmt.Errorf("%q depends on undefined module %q",
+			module.properties.Name, depName),
+		Pos: module.propertyPos["deps"],
+	}}
+}
+
+// checkDisabledDependencies returns an error for each dependency of an
+// enabled module on a disabled one, which doesn't generate the build actions
+// that the enabled module needs.
+func (c *Context) checkDisabledDependencies() (errs []error) {
+	for _, name := range c.sortedModuleNames() {
+		for _, module := range c.moduleGroups[name].modules {
+			if !module.properties.Enabled {
+				continue
+			}
+
+			for _, dep := range module.directDeps {
+				if dep.properties.Enabled {
+					continue
+				}
+
+				errs = append(errs, &Error{
+					Err: fmt.Errorf("dependency %q of %q is disabled",
+						dep.properties.Name, module.properties.Name),
+					Pos: module.propertyPos["deps"],
+				})
+				if len(errs) > maxErrors {
+					return errs
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
 func (c *Context) addVariationDependency(module *moduleInfo, variations []Variation,
 	depName string, far bool) []error {
 
 	depsPos := module.propertyPos["deps"]
 
-	depInfo, ok := c.moduleGroups[depName]
-	if !ok {
-		return []error{&Error{
-			Err: fmt.Errorf("%q depends on undefined module %q",
-				module.properties.Name, depName),
-			Pos: depsPos,
-		}}
+	depInfo, err := c.lookupModuleGroup(module, depName)
+	if err != nil {
+		return []error{&Error{Err: err, Pos: depsPos}}
+	}
+	if depInfo == nil {
+		return c.missingDependency(module, depName)
 	}
 
 	// We can't just append variant.Variant to module.dependencyVariants.variantName and
@@ -1192,7 +2295,14 @@ func (c *Context) addVariationDependency(module *moduleInfo, variations []Variat
 }
 
 func (c *Context) parallelVisitAllBottomUp(visit func(group *moduleInfo) bool) {
-	doneCh := make(chan *moduleInfo)
+	// The result of each visit is passed back with the module, so that only
+	// this goroutine reads and writes cancel.
+	type visitResult struct {
+		module *moduleInfo
+		cancel bool
+	}
+
+	doneCh := make(chan visitResult)
 	count := 0
 	cancel := false
 
@@ -1200,14 +2310,15 @@ func (c *Context) parallelVisitAllBottomUp(visit func(group *moduleInfo) bool) {
 		module.waitingCount = module.depsCount
 	}
 
+	jobs := c.newJobLimiter()
+
 	visitOne := func(module *moduleInfo) {
 		count++
 		go func() {
+			jobs.acquire()
 			ret := visit(module)
-			if ret {
-				cancel = true
-			}
-			doneCh <- module
+			jobs.release()
+			doneCh <- visitResult{module, ret}
 		}()
 	}
 
@@ -1219,9 +2330,12 @@ func (c *Context) parallelVisitAllBottomUp(visit func(group *moduleInfo) bool) {
 
 	for count > 0 {
 		select {
-		case doneModule := <-doneCh:
+		case result := <-doneCh:
+			if result.cancel {
+				cancel = true
+			}
 			if !cancel {
-				for _, parent := range doneModule.reverseDeps {
+				for _, parent := range result.module.reverseDeps {
 					parent.waitingCount--
 					if parent.waitingCount == 0 {
 						visitOne(parent)
@@ -1362,8 +2476,11 @@ func (c *Context) updateDependencies() (errs []error) {
 //
 // The returned deps is a list of the ninja files dependencies that were added
 // by the modules and singletons via the ModuleContext.AddNinjaFileDeps() and
-// SingletonContext.AddNinjaFileDeps() methods.
+// SingletonContext.AddNinjaFileDeps() methods.  The directories searched by
+// ModuleContext.Glob are not included, the globs are returned by Globs instead.
 func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs []error) {
+	defer c.traceTopLevel("prepare build actions", &errs)()
+
 	c.buildActionsReady = false
 
 	if !c.dependenciesReady {
@@ -1378,7 +2495,21 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 		return nil, errs
 	}
 
+	c.updateDirectReverseDeps()
+
+	errs = c.checkDisabledDependencies()
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	// The mutators may have added dependencies.
+	errs = c.checkVisibility()
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
 	liveGlobals := newLiveTracker(config)
+	liveGlobals.outDir = c.outDir
 
 	c.initSpecialVariables()
 
@@ -1394,11 +2525,18 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 
 	deps = append(depsModules, depsSingletons...)
 
+	if liveGlobals.validations {
+		c.requireNinjaVersion(1, 11, 0)
+	}
+
 	if c.buildDir != nil {
 		liveGlobals.addNinjaStringDeps(c.buildDir)
 	}
 
-	pkgNames := c.makeUniquePackageNames(liveGlobals)
+	pkgNames, errs := c.makeUniquePackageNames(liveGlobals)
+	if len(errs) > 0 {
+		return nil, errs
+	}
 
 	// This will panic if it finds a problem since it's a programming error.
 	c.checkForVariableReferenceCycles(liveGlobals.variables, pkgNames)
@@ -1407,6 +2545,25 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 	c.globalVariables = liveGlobals.variables
 	c.globalPools = liveGlobals.pools
 	c.globalRules = liveGlobals.rules
+	c.volatileValues = liveGlobals.volatileValues
+
+	if len(c.dedupDirs) > 0 {
+		c.dedupBuildActions()
+	}
+
+	if c.verifyBuildActions {
+		errs = c.checkBuildActions()
+		if len(errs) > 0 {
+			return nil, errs
+		}
+	}
+
+	if c.validateManifest {
+		errs = c.checkManifest()
+		if len(errs) > 0 {
+			return nil, errs
+		}
+	}
 
 	c.buildActionsReady = true
 
@@ -1415,33 +2572,46 @@ func (c *Context) PrepareBuildActions(config interface{}) (deps []string, errs [
 
 func (c *Context) runEarlyMutators(config interface{}) (errs []error) {
 	for _, mutator := range c.earlyMutatorInfo {
-		for _, group := range c.moduleGroups {
-			newModules := make([]*moduleInfo, 0, len(group.modules))
-
-			for _, module := range group.modules {
-				mctx := &mutatorContext{
-					baseModuleContext: baseModuleContext{
-						context: c,
-						config:  config,
-						module:  module,
-					},
-					name: mutator.name,
-				}
-				mutator.mutator(mctx)
-				if len(mctx.errs) > 0 {
-					errs = append(errs, mctx.errs...)
-					return errs
-				}
+		errs = c.runEarlyMutator(config, mutator)
+		if len(errs) > 0 {
+			return errs
+		}
+	}
 
-				if module.splitModules != nil {
-					newModules = append(newModules, module.splitModules...)
-				} else {
-					newModules = append(newModules, module)
-				}
+	return nil
+}
+
+func (c *Context) runEarlyMutator(config interface{},
+	mutator *earlyMutatorInfo) (errs []error) {
+
+	defer c.tracePhase("mutator " + mutator.name)()
+
+	for _, group := range c.moduleGroups {
+		newModules := make([]*moduleInfo, 0, len(group.modules))
+
+		for _, module := range group.modules {
+			mctx := &mutatorContext{
+				baseModuleContext: baseModuleContext{
+					context: c,
+					config:  config,
+					module:  module,
+				},
+				name: mutator.name,
+			}
+			mutator.mutator(mctx)
+			if len(mctx.errs) > 0 {
+				errs = append(errs, mctx.errs...)
+				return errs
 			}
 
-			group.modules = newModules
+			if module.splitModules != nil {
+				newModules = append(newModules, module.splitModules...)
+			} else {
+				newModules = append(newModules, module)
+			}
 		}
+
+		group.modules = newModules
 	}
 
 	return nil
@@ -1467,6 +2637,11 @@ func (c *Context) runMutators(config interface{}) (errs []error) {
 func (c *Context) runTopDownMutator(config interface{},
 	name string, mutator TopDownMutator) (errs []error) {
 
+	defer c.tracePhase("mutator " + name)()
+
+	status := c.startStatus("mutate "+name, "modules", len(c.modulesSorted))
+	defer status.finish()
+
 	for i := 0; i < len(c.modulesSorted); i++ {
 		module := c.modulesSorted[len(c.modulesSorted)-1-i]
 		mctx := &mutatorContext{
@@ -1479,6 +2654,7 @@ func (c *Context) runTopDownMutator(config interface{},
 		}
 
 		mutator(mctx)
+		status.add(1, 0)
 		if len(mctx.errs) > 0 {
 			errs = append(errs, mctx.errs...)
 			return errs
@@ -1491,6 +2667,13 @@ func (c *Context) runTopDownMutator(config interface{},
 func (c *Context) runBottomUpMutator(config interface{},
 	name string, mutator BottomUpMutator) (errs []error) {
 
+	defer c.tracePhase("mutator " + name)()
+
+	status := c.startStatus("mutate "+name, "modules", len(c.modulesSorted))
+	defer status.finish()
+
+	var replacements []replacement
+
 	for _, module := range c.modulesSorted {
 		newModules := make([]*moduleInfo, 0, 1)
 
@@ -1508,11 +2691,14 @@ func (c *Context) runBottomUpMutator(config interface{},
 		}
 
 		mutator(mctx)
+		status.add(1, 0)
 		if len(mctx.errs) > 0 {
 			errs = append(errs, mctx.errs...)
 			return errs
 		}
 
+		replacements = append(replacements, mctx.replacements...)
+
 		// Fix up any remaining dependencies on modules that were split into variants
 		// by replacing them with the first variant
 		for i, dep := range module.directDeps {
@@ -1530,6 +2716,8 @@ func (c *Context) runBottomUpMutator(config interface{},
 		module.group.modules = spliceModules(module.group.modules, module, newModules)
 	}
 
+	c.replaceDependencies(replacements)
+
 	errs = c.updateDependencies()
 	if len(errs) > 0 {
 		return errs
@@ -1575,6 +2763,7 @@ func (c *Context) initSpecialVariables() {
 	c.requiredNinjaMajor = 1
 	c.requiredNinjaMinor = 1
 	c.requiredNinjaMicro = 0
+	c.subninjaDir = ""
 }
 
 func (c *Context) generateModuleBuildActions(config interface{},
@@ -1587,6 +2776,11 @@ func (c *Context) generateModuleBuildActions(config interface{},
 	errsCh := make(chan []error)
 	depsCh := make(chan []string)
 
+	// Modules that panicked, or that depend on a module that panicked.
+	// Their build actions are not generated, but other modules continue.
+	var failedLock sync.Mutex
+	failed := make(map[*moduleInfo]bool)
+
 	go func() {
 		for {
 			select {
@@ -1602,23 +2796,85 @@ func (c *Context) generateModuleBuildActions(config interface{},
 		}
 	}()
 
+	c.moduleDirOwners = make(map[string]*moduleInfo)
+
+	defer c.tracePhase("generate")()
+
+	status := c.startStatus("generate", "modules", len(c.moduleInfo))
+	defer status.finish()
+
 	c.parallelVisitAllBottomUp(func(module *moduleInfo) bool {
+		failedLock.Lock()
+		for _, dep := range module.directDeps {
+			if failed[dep] {
+				failed[module] = true
+				break
+			}
+		}
+		skip := failed[module] || !module.properties.Enabled
+		failedLock.Unlock()
+
+		if skip {
+			status.add(1, 0)
+			return false
+		}
+
+		// The dependencies have generated their build actions, so the
+		// module references in the properties can be resolved to their
+		// outputs.
+		if newErrs := c.resolveModuleReferences(module); len(newErrs) > 0 {
+			status.add(1, 0)
+			errsCh <- newErrs
+			return true
+		}
+
 		// The parent scope of the moduleContext's local scope gets overridden to be that of the
 		// calling Go package on a per-call basis.  Since the initial parent scope doesn't matter we
 		// just set it to nil.
 		prefix := moduleNamespacePrefix(module.group.ninjaName + "_" + module.variantName)
 		scope := newLocalScope(nil, prefix)
 
+		if c.recordModuleCalls {
+			module.callLog = &CallLog{}
+		}
+
 		mctx := &moduleContext{
 			baseModuleContext: baseModuleContext{
 				context: c,
 				config:  config,
 				module:  module,
+				callLog: module.callLog,
 			},
-			scope: scope,
+			localActions: localActions{scope: scope},
+		}
+
+		traceName := module.properties.Name
+		if module.variantName != "" {
+			traceName += " " + module.variantName
 		}
+		end := c.traceItem(traceName, "module")
+		module.providers = nil
+		module.startedGenerateBuildActions = true
+		module.finishedGenerateBuildActions = false
+		err := recoverPanic(fmt.Sprintf("GenerateBuildActions for module %q variant %q",
+			module.properties.Name, module.variantName), func() {
+			mctx.module.logicModule.GenerateBuildActions(mctx)
+		})
+		module.finishedGenerateBuildActions = true
+		end()
+		status.add(1, 0)
 
-		mctx.module.logicModule.GenerateBuildActions(mctx)
+		if err != nil {
+			failedLock.Lock()
+			failed[module] = true
+			failedLock.Unlock()
+
+			errsCh <- []error{&Error{
+				Err: err,
+				Pos: module.pos,
+			}}
+			return false
+		}
 
 		if len(mctx.errs) > 0 {
 			errsCh <- mctx.errs
@@ -1648,6 +2904,11 @@ func (c *Context) generateSingletonBuildActions(config interface{},
 	var deps []string
 	var errs []error
 
+	defer c.tracePhase("singletons")()
+
+	status := c.startStatus("singletons", "singletons", len(c.singletonInfo))
+	defer status.finish()
+
 	for name, info := range c.singletonInfo {
 		// The parent scope of the singletonContext's local scope gets overridden to be that of the
 		// calling Go package on a per-call basis.  Since the initial parent scope doesn't matter we
@@ -1655,12 +2916,35 @@ func (c *Context) generateSingletonBuildActions(config interface{},
 		scope := newLocalScope(nil, singletonNamespacePrefix(name))
 
 		sctx := &singletonContext{
-			context: c,
-			config:  config,
-			scope:   scope,
+			context:      c,
+			config:       config,
+			localActions: localActions{scope: scope},
+		}
+
+		if info.parallel {
+			newErrs := c.collectModuleData(name, info.singleton.(ParallelSingleton), config)
+			if len(newErrs) > 0 {
+				status.add(1, 0)
+				errs = append(errs, newErrs...)
+				if len(errs) > maxErrors {
+					break
+				}
+				continue
+			}
 		}
 
-		info.singleton.GenerateBuildActions(sctx)
+		end := c.traceItem(name, "singleton")
+		err := recoverPanic(fmt.Sprintf("GenerateBuildActions for singleton %q", name),
+			func() {
+				info.singleton.GenerateBuildActions(sctx)
+			})
+		end()
+		status.add(1, 0)
+
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
 
 		if len(sctx.errs) > 0 {
 			errs = append(errs, sctx.errs...)
@@ -1683,6 +2967,74 @@ func (c *Context) generateSingletonBuildActions(config interface{},
 	return deps, errs
 }
 
+// collectModuleData calls the VisitModule method of the ParallelSingleton
+// registered as name for every module concurrently, and then passes the
+// results to its MergeModuleData method.
+func (c *Context) collectModuleData(name string, singleton ParallelSingleton,
+	config interface{}) []error {
+
+	var modules []Module
+	c.visitAllModules(func(module Module) {
+		modules = append(modules, module)
+	})
+
+	vctx := &singletonVisitContext{
+		context: c,
+		config:  config,
+	}
+
+	data := make([]interface{}, len(modules))
+	jobs := c.newJobLimiter()
+
+	// A job is acquired before each goroutine is started, so that there are
+	// never more goroutines than jobs however many modules there are.
+	var wg sync.WaitGroup
+	for i, module := range modules {
+		wg.Add(1)
+		jobs.acquire()
+		go func(i int, module Module) {
+			defer wg.Done()
+			defer jobs.release()
+
+			err := recoverPanic(fmt.Sprintf("VisitModule for singleton %q module %q",
+				name, c.ModuleName(module)),
+				func() {
+					data[i] = singleton.VisitModule(vctx, module)
+				})
+			if err != nil {
+				vctx.addError(err)
+			}
+		}(i, module)
+	}
+	wg.Wait()
+
+	if len(vctx.errs) > 0 {
+		// The modules were visited concurrently, so sort the errors to
+		// report them in a consistent order.
+		sort.SliceStable(vctx.errs, func(i, j int) bool {
+			return vctx.errs[i].Error() < vctx.errs[j].Error()
+		})
+		return vctx.errs
+	}
+
+	var merged []interface{}
+	for _, d := range data {
+		if d != nil {
+			merged = append(merged, d)
+		}
+	}
+
+	err := recoverPanic(fmt.Sprintf("MergeModuleData for singleton %q", name),
+		func() {
+			singleton.MergeModuleData(merged)
+		})
+	if err != nil {
+		return []error{err}
+	}
+
+	return nil
+}
+
 func (c *Context) processLocalBuildActions(out, in *localBuildActions,
 	liveGlobals *liveTracker) []error {
 
@@ -1714,6 +3066,13 @@ func (c *Context) processLocalBuildActions(out, in *localBuildActions,
 		}
 	}
 
+	for _, p := range in.pools {
+		isLive := liveGlobals.RemovePoolIfLive(p)
+		if isLive {
+			out.pools = append(out.pools, p)
+		}
+	}
+
 	for _, r := range in.rules {
 		isLive := liveGlobals.RemoveRuleIfLive(r)
 		if isLive {
@@ -1784,6 +3143,47 @@ func (c *Context) visitDirectDepsIf(module *moduleInfo, pred func(Module) bool,
 	}
 }
 
+// updateDirectReverseDeps sets the directReverseDeps list of every module to
+// the modules that have it in their directDeps list, in the order in which
+// visitAllModules visits them.  Unlike reverseDeps, it doesn't include the
+// later variants of the same module.
+func (c *Context) updateDirectReverseDeps() {
+	for _, group := range c.moduleGroups {
+		for _, module := range group.modules {
+			module.directReverseDeps = nil
+		}
+	}
+
+	for _, moduleName := range c.sortedModuleNames() {
+		for _, module := range c.moduleGroups[moduleName].modules {
+			for _, dep := range module.directDeps {
+				rdeps := dep.directReverseDeps
+				if len(rdeps) > 0 && rdeps[len(rdeps)-1] == module {
+					// The module depends on dep more than once.
+					continue
+				}
+				dep.directReverseDeps = append(rdeps, module)
+			}
+		}
+	}
+}
+
+func (c *Context) visitReverseDeps(module *moduleInfo, visit func(Module)) {
+	for _, rdep := range module.directReverseDeps {
+		visit(rdep.logicModule)
+	}
+}
+
+func (c *Context) visitReverseDepsIf(module *moduleInfo, pred func(Module) bool,
+	visit func(Module)) {
+
+	for _, rdep := range module.directReverseDeps {
+		if pred(rdep.logicModule) {
+			visit(rdep.logicModule)
+		}
+	}
+}
+
 func (c *Context) sortedModuleNames() []string {
 	if c.cachedSortedModuleNames == nil {
 		c.cachedSortedModuleNames = make([]string, 0, len(c.moduleGroups))
@@ -1797,11 +3197,15 @@ func (c *Context) sortedModuleNames() []string {
 	return c.cachedSortedModuleNames
 }
 
+// visitAllModules visits the modules in name order, skipping the disabled
+// ones, which don't generate any build actions.
 func (c *Context) visitAllModules(visit func(Module)) {
 	for _, moduleName := range c.sortedModuleNames() {
 		group := c.moduleGroups[moduleName]
 		for _, module := range group.modules {
-			visit(module.logicModule)
+			if module.properties.Enabled {
+				visit(module.logicModule)
+			}
 		}
 	}
 }
@@ -1812,7 +3216,7 @@ func (c *Context) visitAllModulesIf(pred func(Module) bool,
 	for _, moduleName := range c.sortedModuleNames() {
 		group := c.moduleGroups[moduleName]
 		for _, module := range group.modules {
-			if pred(module.logicModule) {
+			if module.properties.Enabled && pred(module.logicModule) {
 				visit(module.logicModule)
 			}
 		}
@@ -1840,7 +3244,37 @@ func (c *Context) setBuildDir(value *ninjaString) {
 }
 
 func (c *Context) makeUniquePackageNames(
-	liveGlobals *liveTracker) map[*PackageContext]string {
+	liveGlobals *liveTracker) (map[*PackageContext]string, []error) {
+
+	if c.packageNaming != ShortPackageNames {
+		livePkgs := make(map[*PackageContext]bool)
+		for v := range liveGlobals.variables {
+			livePkgs[v.packageContext()] = true
+		}
+		for p := range liveGlobals.pools {
+			livePkgs[p.packageContext()] = true
+		}
+		for r := range liveGlobals.rules {
+			livePkgs[r.packageContext()] = true
+		}
+		// Built-in rules and pools have no package.
+		delete(livePkgs, nil)
+
+		var pctxs []*PackageContext
+		for pctx := range livePkgs {
+			pctxs = append(pctxs, pctx)
+		}
+
+		if c.packageNaming == HashedPackageNames {
+			return hashedPackageNames(pctxs, minPackageHashLen), nil
+		}
+
+		pkgNames := make(map[*PackageContext]string)
+		for _, pctx := range pctxs {
+			pkgNames[pctx] = pctx.fullName
+		}
+		return pkgNames, duplicatePackageNames(pkgNames)
+	}
 
 	pkgs := make(map[string]*PackageContext)
 	pkgNames := make(map[*PackageContext]string)
@@ -1889,7 +3323,7 @@ func (c *Context) makeUniquePackageNames(
 		pkgNames[pctx] = pctx.fullName
 	}
 
-	return pkgNames
+	return pkgNames, duplicatePackageNames(pkgNames)
 }
 
 func (c *Context) checkForVariableReferenceCycles(
@@ -2064,54 +3498,99 @@ func (c *Context) VisitDepsDepthFirstIf(module Module,
 	c.visitDepsDepthFirstIf(c.moduleInfo[module], pred, visit)
 }
 
+// VisitReverseDeps calls visit for each module that directly depends on
+// module, in the order in which VisitAllModules visits them.  The reverse
+// dependencies are indexed by PrepareBuildActions after the mutators have run,
+// so this must not be called before then.
+func (c *Context) VisitReverseDeps(module Module, visit func(Module)) {
+	c.visitReverseDeps(c.moduleInfo[module], visit)
+}
+
+// VisitReverseDepsIf is like VisitReverseDeps, but only visits the modules
+// for which pred returns true.
+func (c *Context) VisitReverseDepsIf(module Module, pred func(Module) bool,
+	visit func(Module)) {
+
+	c.visitReverseDepsIf(c.moduleInfo[module], pred, visit)
+}
+
 // WriteBuildFile writes the Ninja manifeset text for the generated build
 // actions to w.  If this is called before PrepareBuildActions successfully
 // completes then ErrBuildActionsNotReady is returned.
 func (c *Context) WriteBuildFile(w io.Writer) error {
+	mw := newNinjaManifestWriter(w, c.ninjaSymlinkOutputs)
+	err := c.WriteManifest(mw)
+	if err != nil {
+		return err
+	}
+	return mw.Flush()
+}
+
+// WriteManifest passes the generated build actions to mw in the order in which
+// they appear in the Ninja manifest.  WriteBuildFile uses it with a writer that
+// produces Ninja manifest text, but other ManifestWriter implementations may be
+// used to serialize or execute the build actions some other way.  If this is
+// called before PrepareBuildActions successfully completes then
+// ErrBuildActionsNotReady is returned.
+func (c *Context) WriteManifest(mw ManifestWriter) (err error) {
 	if !c.buildActionsReady {
 		return ErrBuildActionsNotReady
 	}
 
-	nw := newNinjaWriter(w)
+	var traceErrs []error
+	defer func() {
+		if err == nil && len(traceErrs) > 0 {
+			err = traceErrs[0]
+		}
+	}()
+	defer c.traceTopLevel("write", &traceErrs)()
+
+	return c.writeManifest(mw, c.writeAllModuleActions)
+}
+
+// writeManifest passes the build actions to mw, using writeModules to pass the
+// build actions of the modules.
+func (c *Context) writeManifest(mw ManifestWriter,
+	writeModules func(mw ManifestWriter) error) error {
 
-	err := c.writeBuildFileHeader(nw)
+	err := c.writeBuildFileHeader(mw)
 	if err != nil {
 		return err
 	}
 
-	err = c.writeNinjaRequiredVersion(nw)
+	err = c.writeNinjaRequiredVersion(mw)
 	if err != nil {
 		return err
 	}
 
 	// TODO: Group the globals by package.
 
-	err = c.writeGlobalVariables(nw)
+	err = c.writeGlobalVariables(mw)
 	if err != nil {
 		return err
 	}
 
-	err = c.writeGlobalPools(nw)
+	err = c.writeGlobalPools(mw)
 	if err != nil {
 		return err
 	}
 
-	err = c.writeBuildDir(nw)
+	err = c.writeBuildDir(mw)
 	if err != nil {
 		return err
 	}
 
-	err = c.writeGlobalRules(nw)
+	err = c.writeGlobalRules(mw)
 	if err != nil {
 		return err
 	}
 
-	err = c.writeAllModuleActions(nw)
+	err = writeModules(mw)
 	if err != nil {
 		return err
 	}
 
-	err = c.writeAllSingletonActions(nw)
+	err = c.writeAllSingletonActions(mw)
 	if err != nil {
 		return err
 	}
@@ -2142,7 +3621,7 @@ func (s *pkgAssociationSorter) Swap(i, j int) {
 	s.pkgs[i], s.pkgs[j] = s.pkgs[j], s.pkgs[i]
 }
 
-func (c *Context) writeBuildFileHeader(nw *ninjaWriter) error {
+func (c *Context) writeBuildFileHeader(mw ManifestWriter) error {
 	headerTemplate := template.New("fileHeader")
 	_, err := headerTemplate.Parse(fileHeaderTemplate)
 	if err != nil {
@@ -2178,29 +3657,29 @@ func (c *Context) writeBuildFileHeader(nw *ninjaWriter) error {
 		return err
 	}
 
-	return nw.Comment(buf.String())
+	return mw.Comment(buf.String())
 }
 
-func (c *Context) writeNinjaRequiredVersion(nw *ninjaWriter) error {
+func (c *Context) writeNinjaRequiredVersion(mw ManifestWriter) error {
 	value := fmt.Sprintf("%d.%d.%d", c.requiredNinjaMajor, c.requiredNinjaMinor,
 		c.requiredNinjaMicro)
 
-	err := nw.Assign("ninja_required_version", value)
+	err := mw.Variable("ninja_required_version", value)
 	if err != nil {
 		return err
 	}
 
-	return nw.BlankLine()
+	return mw.BlankLine()
 }
 
-func (c *Context) writeBuildDir(nw *ninjaWriter) error {
+func (c *Context) writeBuildDir(mw ManifestWriter) error {
 	if c.buildDir != nil {
-		err := nw.Assign("builddir", c.buildDir.Value(c.pkgNames))
+		err := mw.Variable("builddir", c.buildDir.Value(c.pkgNames))
 		if err != nil {
 			return err
 		}
 
-		err = nw.BlankLine()
+		err = mw.BlankLine()
 		if err != nil {
 			return err
 		}
@@ -2231,7 +3710,7 @@ func (s *globalEntitySorter) Swap(i, j int) {
 	s.entities[i], s.entities[j] = s.entities[j], s.entities[i]
 }
 
-func (c *Context) writeGlobalVariables(nw *ninjaWriter) error {
+func (c *Context) writeGlobalVariables(mw ManifestWriter) error {
 	visited := make(map[Variable]bool)
 
 	var walk func(v Variable) error
@@ -2249,12 +3728,12 @@ func (c *Context) writeGlobalVariables(nw *ninjaWriter) error {
 			}
 		}
 
-		err := nw.Assign(v.fullName(c.pkgNames), value.Value(c.pkgNames))
+		err := mw.Variable(v.fullName(c.pkgNames), value.Value(c.pkgNames))
 		if err != nil {
 			return err
 		}
 
-		err = nw.BlankLine()
+		err = mw.BlankLine()
 		if err != nil {
 			return err
 		}
@@ -2282,7 +3761,7 @@ func (c *Context) writeGlobalVariables(nw *ninjaWriter) error {
 	return nil
 }
 
-func (c *Context) writeGlobalPools(nw *ninjaWriter) error {
+func (c *Context) writeGlobalPools(mw ManifestWriter) error {
 	globalPools := make([]globalEntity, 0, len(c.globalPools))
 	for pool := range c.globalPools {
 		globalPools = append(globalPools, pool)
@@ -2294,12 +3773,12 @@ func (c *Context) writeGlobalPools(nw *ninjaWriter) error {
 		pool := entity.(Pool)
 		name := pool.fullName(c.pkgNames)
 		def := c.globalPools[pool]
-		err := def.WriteTo(nw, name)
+		err := mw.Pool(def.manifestPool(name))
 		if err != nil {
 			return err
 		}
 
-		err = nw.BlankLine()
+		err = mw.BlankLine()
 		if err != nil {
 			return err
 		}
@@ -2308,7 +3787,7 @@ func (c *Context) writeGlobalPools(nw *ninjaWriter) error {
 	return nil
 }
 
-func (c *Context) writeGlobalRules(nw *ninjaWriter) error {
+func (c *Context) writeGlobalRules(mw ManifestWriter) error {
 	globalRules := make([]globalEntity, 0, len(c.globalRules))
 	for rule := range c.globalRules {
 		globalRules = append(globalRules, rule)
@@ -2320,12 +3799,12 @@ func (c *Context) writeGlobalRules(nw *ninjaWriter) error {
 		rule := entity.(Rule)
 		name := rule.fullName(c.pkgNames)
 		def := c.globalRules[rule]
-		err := def.WriteTo(nw, name, c.pkgNames)
+		err := mw.Rule(c.manifestRule(def, name))
 		if err != nil {
 			return err
 		}
 
-		err = nw.BlankLine()
+		err = mw.BlankLine()
 		if err != nil {
 			return err
 		}
@@ -2334,6 +3813,18 @@ func (c *Context) writeGlobalRules(nw *ninjaWriter) error {
 	return nil
 }
 
+// moduleDefSorter sorts modules by the positions of their definitions.
+type moduleDefSorter []*moduleInfo
+
+func (s moduleDefSorter) Len() int      { return len(s) }
+func (s moduleDefSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s moduleDefSorter) Less(i, j int) bool {
+	if s[i].relBlueprintsFile != s[j].relBlueprintsFile {
+		return s[i].relBlueprintsFile < s[j].relBlueprintsFile
+	}
+	return s[i].pos.Offset < s[j].pos.Offset
+}
+
 type moduleSorter []*moduleInfo
 
 func (s moduleSorter) Len() int {
@@ -2347,6 +3838,11 @@ func (s moduleSorter) Less(i, j int) bool {
 		iName = s[i].variantName
 		jName = s[j].variantName
 	}
+	if iName == jName {
+		// Modules in different namespaces may have the same name.
+		iName = s[i].group.ninjaName
+		jName = s[j].group.ninjaName
+	}
 	return iName < jName
 }
 
@@ -2354,7 +3850,17 @@ func (s moduleSorter) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
 
-func (c *Context) writeAllModuleActions(nw *ninjaWriter) error {
+func (c *Context) writeAllModuleActions(mw ManifestWriter) error {
+	modules := make([]*moduleInfo, 0, len(c.moduleInfo))
+	for _, module := range c.moduleInfo {
+		modules = append(modules, module)
+	}
+	sort.Sort(moduleSorter(modules))
+
+	return c.writeModuleActions(mw, modules)
+}
+
+func (c *Context) writeModuleActions(mw ManifestWriter, modules []*moduleInfo) error {
 	headerTemplate := template.New("moduleHeader")
 	_, err := headerTemplate.Parse(moduleHeaderTemplate)
 	if err != nil {
@@ -2362,12 +3868,6 @@ func (c *Context) writeAllModuleActions(nw *ninjaWriter) error {
 		panic(err)
 	}
 
-	modules := make([]*moduleInfo, 0, len(c.moduleInfo))
-	for _, module := range c.moduleInfo {
-		modules = append(modules, module)
-	}
-	sort.Sort(moduleSorter(modules))
-
 	buf := bytes.NewBuffer(nil)
 
 	for _, module := range modules {
@@ -2396,22 +3896,22 @@ func (c *Context) writeAllModuleActions(nw *ninjaWriter) error {
 			return err
 		}
 
-		err = nw.Comment(buf.String())
+		err = mw.Comment(buf.String())
 		if err != nil {
 			return err
 		}
 
-		err = nw.BlankLine()
+		err = mw.BlankLine()
 		if err != nil {
 			return err
 		}
 
-		err = c.writeLocalBuildActions(nw, &module.actionDefs)
+		err = c.writeLocalBuildActions(mw, &module.actionDefs)
 		if err != nil {
 			return err
 		}
 
-		err = nw.BlankLine()
+		err = mw.BlankLine()
 		if err != nil {
 			return err
 		}
@@ -2420,7 +3920,7 @@ func (c *Context) writeAllModuleActions(nw *ninjaWriter) error {
 	return nil
 }
 
-func (c *Context) writeAllSingletonActions(nw *ninjaWriter) error {
+func (c *Context) writeAllSingletonActions(mw ManifestWriter) error {
 	headerTemplate := template.New("singletonHeader")
 	_, err := headerTemplate.Parse(singletonHeaderTemplate)
 	if err != nil {
@@ -2454,22 +3954,22 @@ func (c *Context) writeAllSingletonActions(nw *ninjaWriter) error {
 			return err
 		}
 
-		err = nw.Comment(buf.String())
+		err = mw.Comment(buf.String())
 		if err != nil {
 			return err
 		}
 
-		err = nw.BlankLine()
+		err = mw.BlankLine()
 		if err != nil {
 			return err
 		}
 
-		err = c.writeLocalBuildActions(nw, &info.actionDefs)
+		err = c.writeLocalBuildActions(mw, &info.actionDefs)
 		if err != nil {
 			return err
 		}
 
-		err = nw.BlankLine()
+		err = mw.BlankLine()
 		if err != nil {
 			return err
 		}
@@ -2478,11 +3978,15 @@ func (c *Context) writeAllSingletonActions(nw *ninjaWriter) error {
 	return nil
 }
 
-func (c *Context) writeLocalBuildActions(nw *ninjaWriter,
+// writeLocalBuildActions writes the build actions of a module or singleton.
+// The definitions are sorted by name, and the build statements by their
+// outputs, so that the manifest doesn't depend on the order in which they were
+// defined.
+func (c *Context) writeLocalBuildActions(mw ManifestWriter,
 	defs *localBuildActions) error {
 
 	// Write the local variable assignments.
-	for _, v := range defs.variables {
+	for _, v := range sortedLocalVariables(defs.variables) {
 		// A localVariable doesn't need the package names or config to
 		// determine its name or value.
 		name := v.fullName(nil)
@@ -2490,21 +3994,47 @@ func (c *Context) writeLocalBuildActions(nw *ninjaWriter,
 		if err != nil {
 			panic(err)
 		}
-		err = nw.Assign(name, value.Value(c.pkgNames))
+		err = mw.Variable(name, value.Value(c.pkgNames))
 		if err != nil {
 			return err
 		}
 	}
 
 	if len(defs.variables) > 0 {
-		err := nw.BlankLine()
+		err := mw.BlankLine()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Write the local pools.  Ninja looks up the pool of a rule when it reads
+	// a build statement that uses the rule, so they must come first.
+	pools := append([]*localPool(nil), defs.pools...)
+	sort.Sort(localPoolSorter(pools))
+	for _, p := range pools {
+		// A localPool doesn't need the package names or config to determine
+		// its name or definition.
+		name := p.fullName(nil)
+		def, err := p.def(nil)
+		if err != nil {
+			panic(err)
+		}
+
+		err = mw.Pool(def.manifestPool(name))
+		if err != nil {
+			return err
+		}
+
+		err = mw.BlankLine()
 		if err != nil {
 			return err
 		}
 	}
 
 	// Write the local rules.
-	for _, r := range defs.rules {
+	rules := append([]*localRule(nil), defs.rules...)
+	sort.Sort(localRuleSorter(rules))
+	for _, r := range rules {
 		// A localRule doesn't need the package names or config to determine
 		// its name or definition.
 		name := r.fullName(nil)
@@ -2513,26 +4043,32 @@ func (c *Context) writeLocalBuildActions(nw *ninjaWriter,
 			panic(err)
 		}
 
-		err = def.WriteTo(nw, name, c.pkgNames)
+		err = mw.Rule(c.manifestRule(def, name))
 		if err != nil {
 			return err
 		}
 
-		err = nw.BlankLine()
+		err = mw.BlankLine()
 		if err != nil {
 			return err
 		}
 	}
 
 	// Write the build definitions.
-	for _, buildDef := range defs.buildDefs {
-		err := buildDef.WriteTo(nw, c.pkgNames)
+	builds := make([]*ManifestBuild, len(defs.buildDefs))
+	for i, buildDef := range defs.buildDefs {
+		builds[i] = buildDef.manifestBuild(c.pkgNames)
+	}
+	sort.Stable(manifestBuildSorter(builds))
+
+	for _, build := range builds {
+		err := mw.Build(build)
 		if err != nil {
 			return err
 		}
 
-		if len(buildDef.Args) > 0 {
-			err = nw.BlankLine()
+		if len(build.Args) > 0 {
+			err = mw.BlankLine()
 			if err != nil {
 				return err
 			}
@@ -2542,6 +4078,74 @@ func (c *Context) writeLocalBuildActions(nw *ninjaWriter,
 	return nil
 }
 
+// sortedLocalVariables returns variables sorted by name, except that a
+// variable always follows the variables that its value references, which Ninja
+// requires to be defined first.
+func sortedLocalVariables(variables []*localVariable) []*localVariable {
+	sorted := append([]*localVariable(nil), variables...)
+	sort.Sort(localVariableSorter(sorted))
+
+	local := make(map[Variable]bool)
+	for _, v := range variables {
+		local[v] = true
+	}
+
+	result := make([]*localVariable, 0, len(sorted))
+	visited := make(map[Variable]bool)
+
+	var walk func(v *localVariable)
+	walk = func(v *localVariable) {
+		visited[v] = true
+		for _, dep := range v.value_.variables {
+			if local[dep] && !visited[dep] {
+				walk(dep.(*localVariable))
+			}
+		}
+		result = append(result, v)
+	}
+
+	for _, v := range sorted {
+		if !visited[v] {
+			walk(v)
+		}
+	}
+
+	return result
+}
+
+type localVariableSorter []*localVariable
+
+func (s localVariableSorter) Len() int           { return len(s) }
+func (s localVariableSorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s localVariableSorter) Less(i, j int) bool { return s[i].fullName(nil) < s[j].fullName(nil) }
+
+type localPoolSorter []*localPool
+
+func (s localPoolSorter) Len() int           { return len(s) }
+func (s localPoolSorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s localPoolSorter) Less(i, j int) bool { return s[i].fullName(nil) < s[j].fullName(nil) }
+
+type localRuleSorter []*localRule
+
+func (s localRuleSorter) Len() int           { return len(s) }
+func (s localRuleSorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s localRuleSorter) Less(i, j int) bool { return s[i].fullName(nil) < s[j].fullName(nil) }
+
+// manifestBuildSorter sorts build statements by their outputs.
+type manifestBuildSorter []*ManifestBuild
+
+func (s manifestBuildSorter) Len() int      { return len(s) }
+func (s manifestBuildSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s manifestBuildSorter) Less(i, j int) bool {
+	iOutputs, jOutputs := s[i].Outputs, s[j].Outputs
+	for k := 0; k < len(iOutputs) && k < len(jOutputs); k++ {
+		if iOutputs[k] != jOutputs[k] {
+			return iOutputs[k] < jOutputs[k]
+		}
+	}
+	return len(iOutputs) < len(jOutputs)
+}
+
 func beforeInModuleList(a, b *moduleInfo, list []*moduleInfo) bool {
 	found := false
 	for _, l := range list {
@@ -16,7 +16,17 @@ package blueprint
 
 import (
 	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 type fooModule struct {
@@ -55,6 +65,56 @@ func (b *barModule) Bar() bool {
 	return b.properties.Bar
 }
 
+// A blueprintsTestFile is a Blueprints file parsed by a test.
+type blueprintsTestFile struct {
+	name, contents string
+}
+
+// parseTestFiles returns a new Context, with the module types and anything
+// else that a test needs registered by register, and the modules in files
+// added to it.  It stops at the first file with errors.
+func parseTestFiles(register func(ctx *Context),
+	files []blueprintsTestFile) (*Context, []error) {
+
+	ctx := NewContext()
+	register(ctx)
+
+	for _, file := range files {
+		modules, _, _, errs := ctx.parse(".", file.name,
+			bytes.NewBufferString(file.contents), nil)
+		if len(errs) == 0 {
+			errs = ctx.addModules(modules)
+		}
+		if len(errs) > 0 {
+			return ctx, errs
+		}
+	}
+
+	return ctx, nil
+}
+
+// resolveTestFiles calls parseTestFiles, then resolves the dependencies.
+func resolveTestFiles(register func(ctx *Context),
+	files []blueprintsTestFile) (*Context, []error) {
+
+	ctx, errs := parseTestFiles(register, files)
+	if len(errs) == 0 {
+		errs = ctx.ResolveDependencies(nil)
+	}
+	return ctx, errs
+}
+
+// prepareTestFiles calls parseTestFiles, then prepares the build actions.
+func prepareTestFiles(register func(ctx *Context),
+	files []blueprintsTestFile) (*Context, []error) {
+
+	ctx, errs := parseTestFiles(register, files)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	return ctx, errs
+}
+
 func TestContextParse(t *testing.T) {
 	ctx := NewContext()
 	ctx.RegisterModuleType("foo_module", newFooModule)
@@ -99,3 +159,1122 @@ func TestContextParse(t *testing.T) {
 	}
 
 }
+
+func TestSelectAxis(t *testing.T) {
+	for _, arch := range []string{"arm", "x86"} {
+		ctx := NewContext()
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.SetSelectAxis("arch", arch)
+
+		r := bytes.NewBufferString(`
+			foo_module {
+				name: "MyFooModule",
+				foo: "foo_" + select(arch, {
+					"arm": "arm",
+					default: "other",
+				}),
+			}
+		`)
+
+		modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+
+		expected := "foo_other"
+		if arch == "arm" {
+			expected = "foo_arm"
+		}
+		if foo := modules[0].logicModule.(*fooModule).Foo(); foo != expected {
+			t.Errorf("arch %s: expected foo %q, got %q", arch, expected, foo)
+		}
+	}
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	r := bytes.NewBufferString(`foo_module { name: "a", foo: select(arch, { default: "" }) }`)
+
+	_, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	expected := `Blueprint:1:37: unknown select axis "arch"`
+	if len(errs) != 1 || errs[0].Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, errs)
+	}
+}
+
+type countingModule struct {
+	properties struct {
+		Count string
+	}
+}
+
+var countingModuleActive, countingModuleMaxActive int32
+
+func newCountingModule() (Module, []interface{}) {
+	m := &countingModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (c *countingModule) GenerateBuildActions(ModuleContext) {
+	active := atomic.AddInt32(&countingModuleActive, 1)
+	for {
+		max := atomic.LoadInt32(&countingModuleMaxActive)
+		if active <= max || atomic.CompareAndSwapInt32(&countingModuleMaxActive, max, active) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+	atomic.AddInt32(&countingModuleActive, -1)
+}
+
+type testJobServer struct {
+	acquired, released int32
+}
+
+func (j *testJobServer) Acquire() {
+	atomic.AddInt32(&j.acquired, 1)
+}
+
+func (j *testJobServer) Release() {
+	atomic.AddInt32(&j.released, 1)
+}
+
+func TestContextParallelism(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("counting_module", newCountingModule)
+	ctx.SetParallelism(2)
+
+	jobServer := &testJobServer{}
+	ctx.SetJobServer(jobServer)
+
+	r := bytes.NewBufferString(`
+		counting_module { name: "a" }
+		counting_module { name: "b" }
+		counting_module { name: "c" }
+		counting_module { name: "d" }
+		counting_module { name: "e" }
+		counting_module { name: "f" }
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors preparing build actions:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	if max := atomic.LoadInt32(&countingModuleMaxActive); max > 2 {
+		t.Errorf("expected at most 2 concurrent jobs, got %d", max)
+	}
+
+	if jobServer.acquired != 6 || jobServer.released != 6 {
+		t.Errorf("expected 6 job server acquires and releases, got %d and %d",
+			jobServer.acquired, jobServer.released)
+	}
+}
+
+type namesSingleton struct {
+	names []string
+	count int
+}
+
+func newNamesSingleton() ParallelSingleton {
+	return &namesSingleton{}
+}
+
+func (s *namesSingleton) VisitModule(ctx SingletonVisitContext, module Module) interface{} {
+	if foo, ok := module.(*fooModule); ok {
+		if foo.properties.Foo == "error" {
+			ctx.ModuleErrorf(module, "bad foo")
+		}
+		return ctx.ModuleName(module)
+	}
+	return nil
+}
+
+func (s *namesSingleton) MergeModuleData(data []interface{}) {
+	for _, d := range data {
+		s.names = append(s.names, d.(string))
+	}
+}
+
+func (s *namesSingleton) GenerateBuildActions(ctx SingletonContext) {
+	s.count++
+}
+
+func TestParallelSingleton(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	ctx.RegisterParallelSingletonType("names", newNamesSingleton)
+
+	r := bytes.NewBufferString(`
+		foo_module { name: "c" }
+		bar_module { name: "b" }
+		foo_module { name: "a" }
+		foo_module { name: "d" }
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors preparing build actions:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	s := ctx.singletonInfo["names"].singleton.(*namesSingleton)
+	if !reflect.DeepEqual(s.names, []string{"a", "c", "d"}) {
+		t.Errorf("expected merged names [a c d], got %v", s.names)
+	}
+	if s.count != 1 {
+		t.Errorf("expected GenerateBuildActions to be called once, got %d", s.count)
+	}
+
+	ctx = NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterParallelSingletonType("names", newNamesSingleton)
+
+	r = bytes.NewBufferString(`
+		foo_module { name: "b", foo: "error" }
+		foo_module { name: "a", foo: "error" }
+		foo_module { name: "c", foo: "error" }
+	`)
+
+	modules, _, _, errs = ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected parse errors: %v", errs)
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	var lines []int
+	for _, err := range errs {
+		if err.(*Error).Err.Error() != "bad foo" {
+			t.Errorf("expected a bad foo error, got %v", err)
+		}
+		lines = append(lines, err.(*Error).Pos.Line)
+	}
+	if !reflect.DeepEqual(lines, []int{2, 3, 4}) {
+		t.Errorf("expected bad foo errors on lines [2 3 4], got %v", errs)
+	}
+
+	s = ctx.singletonInfo["names"].singleton.(*namesSingleton)
+	if s.names != nil || s.count != 0 {
+		t.Errorf("expected no merge or build actions after an error, got %v and %d",
+			s.names, s.count)
+	}
+}
+
+type panickingModule struct {
+	properties struct {
+		Panic bool
+	}
+	generated bool
+}
+
+func newPanickingModule() (Module, []interface{}) {
+	m := &panickingModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (p *panickingModule) GenerateBuildActions(ModuleContext) {
+	if p.properties.Panic {
+		panic("oops")
+	}
+	p.generated = true
+}
+
+func TestContextRecoversPanics(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("panicking_module", newPanickingModule)
+
+	r := bytes.NewBufferString(`
+		panicking_module { name: "a", panic: true }
+		panicking_module { name: "b", deps: ["a"] }
+		panicking_module { name: "c", panic: true }
+		panicking_module { name: "d" }
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+
+	panicked := make(map[string]bool)
+	for _, err := range errs {
+		if blueprintErr, ok := err.(*Error); ok {
+			if panicErr, ok := blueprintErr.Err.(*PanicError); ok {
+				if panicErr.Value != "oops" || len(panicErr.Stack) == 0 {
+					t.Errorf("incorrect panic error: %s", err)
+				}
+				panicked[blueprintErr.Pos.String()] = true
+				continue
+			}
+		}
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if len(panicked) != 2 {
+		t.Errorf("expected 2 panics, got %d", len(panicked))
+	}
+
+	for _, module := range modules {
+		p := module.logicModule.(*panickingModule)
+		expected := module.properties.Name == "d"
+		if p.generated != expected {
+			t.Errorf("module %q: expected generated %v, got %v",
+				module.properties.Name, expected, p.generated)
+		}
+	}
+}
+
+type dirModule struct {
+	properties struct {
+		Dir string
+	}
+	intermediatesDir string
+	genDir           string
+}
+
+func newDirModule() (Module, []interface{}) {
+	m := &dirModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (d *dirModule) GenerateBuildActions(ctx ModuleContext) {
+	d.intermediatesDir = ctx.IntermediatesDir()
+	d.genDir = ctx.GenDir()
+}
+
+func TestModuleDirs(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("dir_module", newDirModule)
+	ctx.SetOutDir("out")
+
+	var modules []*moduleInfo
+	for _, file := range []struct{ name, contents string }{
+		{"a/Blueprints", `dir_module { name: "b/c" }`},
+		{"a/b/Blueprints", `dir_module { name: "c" }`},
+		{"d/Blueprints", `dir_module { name: "e" }`},
+	} {
+		newModules, _, _, errs := ctx.parse(".", file.name,
+			bytes.NewBufferString(file.contents), nil)
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+		modules = append(modules, newModules...)
+	}
+
+	errs := ctx.addModules(modules)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) != 2 {
+		t.Errorf("expected 2 collision errors, got %d:", len(errs))
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+	}
+
+	e := modules[2].logicModule.(*dirModule)
+	if e.intermediatesDir != "out/.intermediates/d/e" {
+		t.Errorf("incorrect intermediates dir %q", e.intermediatesDir)
+	}
+	if e.genDir != "out/gen/d/e" {
+		t.Errorf("incorrect gen dir %q", e.genDir)
+	}
+}
+
+type variationModule struct {
+	properties struct {
+		Split bool
+	}
+	arch      string
+	subDir    string
+	depArches []string
+}
+
+func newVariationModule() (Module, []interface{}) {
+	m := &variationModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (v *variationModule) GenerateBuildActions(ctx ModuleContext) {
+	v.arch = ctx.Variation("arch")
+	v.subDir = ctx.ModuleSubDir()
+	ctx.VisitDirectDeps(func(dep Module) {
+		v.depArches = append(v.depArches, dep.(*variationModule).arch)
+	})
+}
+
+func archMutator(mctx BottomUpMutatorContext) {
+	if m, ok := mctx.Module().(*variationModule); ok && m.properties.Split {
+		mctx.CreateVariations("arm", "x86")
+	}
+}
+
+func TestModuleVariation(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("variation_module", newVariationModule)
+	ctx.RegisterBottomUpMutator("arch", archMutator)
+
+	r := bytes.NewBufferString(`
+		variation_module { name: "a", split: true }
+		variation_module { name: "b", split: true, deps: ["a"] }
+		variation_module { name: "c" }
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	var got []string
+	ctx.VisitAllModules(func(module Module) {
+		v := module.(*variationModule)
+		got = append(got, fmt.Sprintf("%s %q %q %v", ctx.ModuleName(module),
+			v.arch, v.subDir, v.depArches))
+	})
+
+	expected := []string{
+		`a "arm" "arm" []`,
+		`a "x86" "x86" []`,
+		`b "arm" "arm" [arm]`,
+		`b "x86" "x86" [x86]`,
+		`c "" "" []`,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected:\n  %s\ngot:\n  %s", strings.Join(expected, "\n  "),
+			strings.Join(got, "\n  "))
+	}
+}
+
+func TestMutatorVariationDependencies(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("variation_module", newVariationModule)
+	ctx.RegisterBottomUpMutator("arch", archMutator)
+	ctx.RegisterBottomUpMutator("deps", func(mctx BottomUpMutatorContext) {
+		switch mctx.ModuleName() {
+		case "b":
+			mctx.AddVariationDependencies([]Variation{{"arch", "x86"}}, "a")
+		case "c":
+			mctx.AddFarVariationDependencies([]Variation{{"arch", "arm"}}, "a")
+		}
+	})
+
+	r := bytes.NewBufferString(`
+		variation_module { name: "a", split: true }
+		variation_module { name: "b", split: true }
+		variation_module { name: "c" }
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	var got []string
+	ctx.VisitAllModules(func(module Module) {
+		v := module.(*variationModule)
+		got = append(got, fmt.Sprintf("%s %q %v", ctx.ModuleName(module),
+			v.arch, v.depArches))
+	})
+
+	expected := []string{
+		`a "arm" []`,
+		`a "x86" []`,
+		`b "arm" [x86]`,
+		`b "x86" [x86]`,
+		`c "" [arm]`,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected:\n  %s\ngot:\n  %s", strings.Join(expected, "\n  "),
+			strings.Join(got, "\n  "))
+	}
+}
+
+func TestMutatorVariationDependenciesMissingVariant(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("variation_module", newVariationModule)
+	ctx.RegisterBottomUpMutator("arch", archMutator)
+	ctx.RegisterBottomUpMutator("deps", func(mctx BottomUpMutatorContext) {
+		if mctx.ModuleName() == "c" {
+			mctx.AddFarVariationDependencies([]Variation{{"arch", "mips"}}, "a")
+		}
+	})
+
+	r := bytes.NewBufferString(`
+		variation_module { name: "a", split: true }
+		variation_module { name: "c" }
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+
+	expected := `dependency "a" of "c" missing variant "arch:mips"`
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), expected) {
+		t.Errorf("expected error containing %q, got %v", expected, errs)
+	}
+}
+
+type prefixTransformer string
+
+func (p prefixTransformer) TransformCommand(rule *ManifestRule, command string) string {
+	if strings.HasSuffix(rule.Name, ".usedRule") {
+		return string(p) + " " + command
+	}
+	return command
+}
+
+func TestCommandTransformer(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("usage_module", newUsageModule)
+	ctx.RegisterCommandTransformer(prefixTransformer("tracer"))
+	ctx.RegisterCommandTransformer(prefixTransformer("ccache"))
+
+	r := bytes.NewBufferString(`
+		usage_module {
+			name: "a",
+			out: "a.out",
+		}
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error writing build file: %s", err)
+	}
+
+	expected := "command = ccache tracer ${g.usagetest.tool} ${in} > ${out}\n"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected %q in:\n%s", expected, buf.String())
+	}
+}
+
+type rspfileModule struct {
+	usageModule
+}
+
+func newRspfileModule() (Module, []interface{}) {
+	m := &rspfileModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (r *rspfileModule) GenerateBuildActions(ctx ModuleContext) {
+	archive := ctx.Rule(usageTestPctx, "archive", RuleParams{
+		Command:        "ar crs $out @$out.rsp",
+		Rspfile:        "$out.rsp",
+		RspfileContent: "$in",
+	})
+	ctx.Build(usageTestPctx, BuildParams{
+		Rule:    archive,
+		Outputs: []string{r.properties.Out},
+		Inputs:  []string{"a.o", "b.o"},
+	})
+}
+
+func TestRspfile(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("rspfile_module", newRspfileModule)
+
+	r := bytes.NewBufferString(`
+		rspfile_module {
+			name: "a",
+			out: "a.a",
+		}
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error writing build file: %s", err)
+	}
+
+	for _, expected := range []string{
+		"    rspfile = ${out}.rsp\n",
+		"    rspfile_content = ${in}\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %q in:\n%s", expected, buf.String())
+		}
+	}
+
+	_, err := parseRuleParams(newScope(nil), &RuleParams{
+		Command: "ar crs $out @$out.rsp",
+		Rspfile: "$out.rsp",
+	})
+	if err == nil {
+		t.Errorf("expected an error for Rspfile without RspfileContent")
+	}
+}
+
+type poolModule struct {
+	usageModule
+}
+
+func newPoolModule() (Module, []interface{}) {
+	m := &poolModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (p *poolModule) GenerateBuildActions(ctx ModuleContext) {
+	heavy := ctx.Pool(usageTestPctx, "heavy", PoolParams{Depth: 2})
+	link := ctx.Rule(usageTestPctx, "link", RuleParams{
+		Command: "link -o $out $in",
+		Pool:    heavy,
+	})
+	ctx.Build(usageTestPctx, BuildParams{
+		Rule:    link,
+		Outputs: []string{p.properties.Out},
+	})
+}
+
+func TestLocalPool(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("pool_module", newPoolModule)
+
+	r := bytes.NewBufferString(`
+		pool_module {
+			name: "a",
+			out: "a.out",
+		}
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error writing build file: %s", err)
+	}
+
+	pool := strings.Index(buf.String(), "pool m.a_.heavy\n    depth = 2\n")
+	rule := strings.Index(buf.String(), "    pool = m.a_.heavy\n")
+	if pool == -1 || rule == -1 || rule < pool {
+		t.Errorf("expected local pool to be defined before the rule using it:\n%s",
+			buf.String())
+	}
+}
+
+func TestImportScopes(t *testing.T) {
+	testCases := []struct {
+		name  string
+		files map[string]string
+		foo   string   // The value of the foo property of module "a".
+		deps  []string // Dependencies that must be returned.
+		errs  []string
+	}{
+		{
+			name: "import",
+			files: map[string]string{
+				"a/Blueprints": `
+					imports = ["flags"]
+					foo_module { name: "a", foo: cflags }
+				`,
+				"b/Blueprints": `
+					export_scope = "flags"
+					exports = ["cflags"]
+					cflags = "-O2"
+				`,
+			},
+			foo:  "-O2",
+			deps: []string{"b/Blueprints"},
+		},
+		{
+			name: "inherited",
+			files: map[string]string{
+				"a/Blueprints": `
+					imports = ["flags"]
+					subdirs = ["c"]
+				`,
+				"a/c/Blueprints": `
+					imports = ["flags"]
+					foo_module { name: "a", foo: cflags }
+				`,
+				"b/Blueprints": `
+					export_scope = "flags"
+					exports = ["cflags"]
+					cflags = "-O2"
+				`,
+			},
+			foo: "-O2",
+		},
+		{
+			name: "missing",
+			files: map[string]string{
+				"a/Blueprints": `imports = ["flags"]`,
+			},
+			errs: []string{
+				`a/Blueprints:1:9: scope "flags" is not exported by any Blueprints file`,
+			},
+		},
+		{
+			name: "literal",
+			files: map[string]string{
+				"a/Blueprints": `
+					scopes = ["flags"]
+					imports = scopes
+				`,
+			},
+			errs: []string{
+				`a/Blueprints:3:14: "imports" must be a list of string literals`,
+			},
+		},
+		{
+			name: "duplicate",
+			files: map[string]string{
+				"a/Blueprints": `export_scope = "flags"`,
+				"b/Blueprints": `export_scope = "flags"`,
+			},
+			errs: []string{
+				`b/Blueprints:1:14: scope "flags" is already exported by a/Blueprints`,
+			},
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"a/Blueprints": `
+					imports = ["b"]
+					export_scope = "a"
+				`,
+				"b/Blueprints": `
+					imports = ["a"]
+					export_scope = "b"
+				`,
+			},
+			errs: []string{
+				`a/Blueprints:2:14: encountered import cycle:`,
+				`a/Blueprints:2:14:     a/Blueprints imports "b" from b/Blueprints`,
+				`b/Blueprints:2:14:     b/Blueprints imports "a" from a/Blueprints`,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		dir, err := ioutil.TempDir("", "imports")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		testCase.files["Blueprints"] = `subdirs = ["*"]`
+		for name, contents := range testCase.files {
+			file := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(file, []byte(contents), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		ctx := NewContext()
+		ctx.RegisterModuleType("foo_module", newFooModule)
+
+		deps, errs := ctx.ParseBlueprintsFiles(filepath.Join(dir, "Blueprints"))
+
+		var errStrings []string
+		for _, err := range errs {
+			errStrings = append(errStrings, strings.Replace(err.Error(), dir+"/", "", -1))
+		}
+
+		if !reflect.DeepEqual(errStrings, testCase.errs) {
+			t.Errorf("%s: incorrect errors:", testCase.name)
+			t.Errorf("     got: %q", errStrings)
+			t.Errorf("expected: %q", testCase.errs)
+			continue
+		}
+
+		if testCase.foo != "" {
+			a := ctx.moduleGroups["a"].modules[0].logicModule.(*fooModule)
+			if a.properties.Foo != testCase.foo {
+				t.Errorf("%s: expected foo %q, got %q", testCase.name, testCase.foo,
+					a.properties.Foo)
+			}
+		}
+
+		for _, dep := range testCase.deps {
+			found := false
+			for _, d := range deps {
+				found = found || d == filepath.Join(dir, dep)
+			}
+			if !found {
+				t.Errorf("%s: missing dependency %q in %q", testCase.name, dep, deps)
+			}
+		}
+	}
+}
+
+type missingDepsModule struct {
+	variationModule
+	missingDeps []string
+}
+
+func newMissingDepsModule() (Module, []interface{}) {
+	m := &missingDepsModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (m *missingDepsModule) GenerateBuildActions(ctx ModuleContext) {
+	m.missingDeps = ctx.GetMissingDependencies()
+}
+
+func TestAllowMissingDependencies(t *testing.T) {
+	bp := `
+		missing_deps_module { name: "a", split: true, deps: ["b", "x", "y", "x"] }
+		missing_deps_module { name: "b", split: true }
+	`
+
+	for _, allow := range []bool{false, true} {
+		ctx := NewContext()
+		ctx.RegisterModuleType("missing_deps_module", newMissingDepsModule)
+		ctx.RegisterBottomUpMutator("arch", func(mctx BottomUpMutatorContext) {
+			if m, ok := mctx.Module().(*missingDepsModule); ok && m.properties.Split {
+				mctx.CreateVariations("arm", "x86")
+			}
+		})
+		ctx.SetAllowMissingDependencies(allow)
+
+		modules, _, _, errs := ctx.parse(".", "Blueprint", bytes.NewBufferString(bp), nil)
+		if len(errs) == 0 {
+			errs = ctx.addModules(modules)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+
+		errs = ctx.ResolveDependencies(nil)
+		if !allow {
+			expected := []string{
+				`Blueprint:2:53: "a" depends on undefined module "x"`,
+				`Blueprint:2:53: "a" depends on undefined module "y"`,
+			}
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Error())
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected errors %q, got %q", expected, got)
+			}
+			continue
+		}
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		for _, module := range ctx.moduleGroups["a"].modules {
+			m := module.logicModule.(*missingDepsModule)
+			if expected := []string{"x", "y"}; !reflect.DeepEqual(m.missingDeps, expected) {
+				t.Errorf("variant %s: expected missing dependencies %q, got %q",
+					module.variantName, expected, m.missingDeps)
+			}
+			if len(module.directDeps) != 1 || module.directDeps[0].variantName != module.variantName {
+				t.Errorf("variant %s: expected a dependency on b", module.variantName)
+			}
+		}
+
+		b := ctx.moduleGroups["b"].modules[0].logicModule.(*missingDepsModule)
+		if b.missingDeps != nil {
+			t.Errorf("expected no missing dependencies for b, got %q", b.missingDeps)
+		}
+	}
+}
+
+type reverseDepsModule struct {
+	fooModule
+	reverseDeps []string
+}
+
+func newReverseDepsModule() (Module, []interface{}) {
+	m := &reverseDepsModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (m *reverseDepsModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.VisitReverseDeps(func(rdep Module) {
+		m.reverseDeps = append(m.reverseDeps, ctx.OtherModuleName(rdep))
+	})
+}
+
+func TestVisitReverseDeps(t *testing.T) {
+	bp := `
+		reverse_deps_module { name: "c", deps: ["a"] }
+		reverse_deps_module { name: "b", deps: ["a", "c"] }
+		reverse_deps_module { name: "a" }
+		reverse_deps_module { name: "d", deps: ["a", "a"], foo: "d" }
+	`
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("reverse_deps_module", newReverseDepsModule)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", bytes.NewBufferString(bp), nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	expected := map[string][]string{
+		"a": {"b", "c", "d"},
+		"c": {"b"},
+	}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		m := ctx.moduleGroups[name].modules[0].logicModule.(*reverseDepsModule)
+		if !reflect.DeepEqual(m.reverseDeps, expected[name]) {
+			t.Errorf("module %s: expected reverse dependencies %q, got %q",
+				name, expected[name], m.reverseDeps)
+		}
+	}
+
+	a := ctx.moduleGroups["a"].modules[0].logicModule
+	var got []string
+	ctx.VisitReverseDepsIf(a,
+		func(m Module) bool { return m.(*reverseDepsModule).properties.Foo != "" },
+		func(m Module) { got = append(got, ctx.ModuleName(m)) })
+	if expected := []string{"d"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected filtered reverse dependencies %q, got %q", expected, got)
+	}
+}
+
+func TestDisabledModules(t *testing.T) {
+	testCases := []struct {
+		bp        string
+		generated []string
+		errs      []string
+	}{
+		{
+			// A disabled module may depend on undefined modules.
+			bp: `
+				foo_module { name: "a", deps: ["b"] }
+				foo_module { name: "b" }
+				foo_module { name: "c", enabled: false, deps: ["b", "x"] }
+			`,
+			generated: []string{"a", "b"},
+		},
+		{
+			bp: `
+				foo_module { name: "a", deps: ["b"] }
+				foo_module { name: "b", enabled: false }
+				foo_module { name: "c", enabled: false, deps: ["b"] }
+			`,
+			errs: []string{`Blueprint:2:33: dependency "b" of "a" is disabled`},
+		},
+	}
+
+	for i, testCase := range testCases {
+		ctx := NewContext()
+
+		var lock sync.Mutex
+		var generated []string
+		ctx.RegisterModuleType("foo_module", func() (Module, []interface{}) {
+			m := &generateCountingModule{generated: func(name string) {
+				lock.Lock()
+				generated = append(generated, name)
+				lock.Unlock()
+			}}
+			return m, []interface{}{&m.properties}
+		})
+
+		modules, _, _, errs := ctx.parse(".", "Blueprint", bytes.NewBufferString(testCase.bp), nil)
+		if len(errs) == 0 {
+			errs = ctx.addModules(modules)
+		}
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+
+		var gotErrs []string
+		for _, err := range errs {
+			gotErrs = append(gotErrs, err.Error())
+		}
+		if !reflect.DeepEqual(gotErrs, testCase.errs) {
+			t.Errorf("test case %d: expected errors %q, got %q", i, testCase.errs, gotErrs)
+		}
+
+		sort.Strings(generated)
+		if len(errs) == 0 && !reflect.DeepEqual(generated, testCase.generated) {
+			t.Errorf("test case %d: expected generated modules %q, got %q", i,
+				testCase.generated, generated)
+		}
+
+		var visited []string
+		ctx.VisitAllModules(func(m Module) {
+			visited = append(visited, ctx.ModuleName(m))
+		})
+		if len(errs) == 0 && !reflect.DeepEqual(visited, testCase.generated) {
+			t.Errorf("test case %d: expected visited modules %q, got %q", i,
+				testCase.generated, visited)
+		}
+	}
+}
+
+type generateCountingModule struct {
+	fooModule
+	generated func(name string)
+}
+
+func (m *generateCountingModule) GenerateBuildActions(ctx ModuleContext) {
+	m.generated(ctx.ModuleName())
+}
+
+var sortTestPctx = NewPackageContext("github.com/google/blueprint/sorttest")
+
+type unsortedActionsModule struct {
+	fooModule
+}
+
+func (m *unsortedActionsModule) GenerateBuildActions(ctx ModuleContext) {
+	// The variables, rules and builds are defined out of order, and b
+	// references c, which must still be written first.
+	ctx.Variable(sortTestPctx, "c", "-c")
+	ctx.Variable(sortTestPctx, "b", "$c -b")
+	ctx.Variable(sortTestPctx, "a", "-a")
+	z := ctx.Rule(sortTestPctx, "z", RuleParams{Command: "z $a $b"})
+	y := ctx.Rule(sortTestPctx, "y", RuleParams{Command: "y"})
+	ctx.Build(sortTestPctx, BuildParams{Rule: z, Outputs: []string{"out/2"}})
+	ctx.Build(sortTestPctx, BuildParams{Rule: y, Outputs: []string{"out/1"}})
+	ctx.Build(sortTestPctx, BuildParams{Rule: z, Outputs: []string{"out/10"}})
+}
+
+func TestWriteBuildFileSorted(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("unsorted", func() (Module, []interface{}) {
+		m := &unsortedActionsModule{}
+		return m, []interface{}{&m.properties}
+	})
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", bytes.NewBufferString(`
+		unsorted { name: "m" }
+	`), nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	ck(ctx.WriteBuildFile(buf))
+
+	var order []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case strings.HasPrefix(line, "m."):
+			order = append(order, fields[0])
+		case strings.HasPrefix(line, "rule "), strings.HasPrefix(line, "build "):
+			order = append(order, fields[1])
+		}
+	}
+
+	expected := []string{
+		"m.m_.a", "m.m_.c", "m.m_.b",
+		"m.m_.y", "m.m_.z",
+		"out/1:", "out/10:", "out/2:",
+	}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected order %q, got %q", expected, order)
+	}
+}
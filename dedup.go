@@ -0,0 +1,126 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SetDedupBuildActions sets the directories, such as a shared directory of
+// generated headers, whose build actions PrepareBuildActions deduplicates.  If
+// more than one module or singleton adds an identical build statement, with
+// the same rule, outputs, inputs and arguments, and all of its outputs are in
+// one of dirs, only the first one in the order of the Ninja file is kept, and
+// the others depend on its outputs like any other dependent.  The kept build
+// statement is a default target unless all of them are optional.  Build
+// statements that build the same outputs in different ways are left alone, so
+// that SetVerifyBuildActions can report them.
+func (c *Context) SetDedupBuildActions(dirs []string) {
+	c.dedupDirs = nil
+	for _, dir := range dirs {
+		c.dedupDirs = append(c.dedupDirs, filepath.Clean(dir))
+	}
+}
+
+// DedupedBuildActions returns the number of build statements that were
+// removed by the last PrepareBuildActions because they were identical to
+// another build statement.
+func (c *Context) DedupedBuildActions() int {
+	return c.dedupedBuildActions
+}
+
+// inDedupDirs returns true if path is in one of the directories set by
+// SetDedupBuildActions.
+func (c *Context) inDedupDirs(path string) bool {
+	for _, dir := range c.dedupDirs {
+		if strings.HasPrefix(filepath.Clean(path), dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupBuildActions removes the build statements described by
+// SetDedupBuildActions.  It must be called after the global variables have been
+// collected by PrepareBuildActions.
+func (c *Context) dedupBuildActions() {
+	c.dedupedBuildActions = 0
+
+	first := make(map[string]*buildDef)
+	for _, owner := range c.actionsOwners() {
+		eval := c.evalFunc(owner)
+
+		var kept []*buildDef
+		for _, def := range owner.actionDefs.buildDefs {
+			key, ok := c.buildActionKey(def, eval)
+			if !ok {
+				kept = append(kept, def)
+				continue
+			}
+
+			if firstDef, ok := first[key]; ok {
+				firstDef.Optional = firstDef.Optional && def.Optional
+				c.dedupedBuildActions++
+				continue
+			}
+			first[key] = def
+			kept = append(kept, def)
+		}
+		owner.actionDefs.buildDefs = kept
+	}
+}
+
+// buildActionKey returns a string that identifies the build statement by the
+// evaluated values of everything that Ninja uses to build it, and whether it
+// may be deduplicated.
+func (c *Context) buildActionKey(def *buildDef,
+	eval func(strs []*ninjaString) ([]string, error)) (string, bool) {
+
+	outputs, err := eval(def.Outputs)
+	if err != nil || len(outputs) == 0 {
+		return "", false
+	}
+	for _, output := range outputs {
+		if !c.inDedupDirs(output) {
+			return "", false
+		}
+	}
+
+	key := []string{def.Rule.fullName(c.pkgNames)}
+	for _, strs := range [][]*ninjaString{def.Outputs, def.SymlinkOutputs, def.Inputs,
+		def.Implicits, def.OrderOnly, def.Validations} {
+
+		values, err := eval(strs)
+		if err != nil {
+			return "", false
+		}
+		key = append(key, strings.Join(values, "\x00"))
+	}
+
+	var args []string
+	for v, value := range def.Args {
+		values, err := eval([]*ninjaString{value})
+		if err != nil {
+			return "", false
+		}
+		args = append(args, v.fullName(c.pkgNames)+"="+values[0])
+	}
+	sort.Strings(args)
+	key = append(key, strings.Join(args, "\x00"))
+
+	return strings.Join(key, "\n"), true
+}
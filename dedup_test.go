@@ -0,0 +1,101 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDedupBuildActions(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("verify_module", newVerifyModule)
+	ctx.SetDedupBuildActions([]string{"out/gen"})
+	ctx.SetVerifyBuildActions(true)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", bytes.NewBufferString(`
+		verify_module { name: "a", outs: ["$outDir/gen/common.h"], ins: ["common.in"] }
+		verify_module { name: "b", outs: ["out/gen/common.h"], ins: ["common.in"] }
+		verify_module { name: "c", outs: ["$outDir/gen/common.h"], ins: ["common.in"] }
+		verify_module { name: "d", outs: ["$outDir/a.o"], ins: ["a.c"] }
+	`), nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	if got := ctx.DedupedBuildActions(); got != 2 {
+		t.Errorf("expected 2 deduplicated build actions, got %d", got)
+	}
+
+	var builders []string
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if len(ctx.moduleGroups[name].modules[0].actionDefs.buildDefs) > 0 {
+			builders = append(builders, name)
+		}
+	}
+	expected := []string{"a", "d"}
+	if !reflect.DeepEqual(builders, expected) {
+		t.Errorf("expected build actions in modules %q, got %q", expected, builders)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	ck(ctx.WriteBuildFile(buf))
+	if n := strings.Count(buf.String(), "common.h: "); n != 1 {
+		t.Errorf("expected 1 build statement for common.h, got %d:\n%s", n, buf.String())
+	}
+}
+
+func TestDedupBuildActionsConflict(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("verify_module", newVerifyModule)
+	ctx.SetDedupBuildActions([]string{"out/gen"})
+	ctx.SetVerifyBuildActions(true)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", bytes.NewBufferString(`
+		verify_module { name: "a", outs: ["out/gen/common.h"], ins: ["a.in"] }
+		verify_module { name: "b", outs: ["out/gen/common.h"], ins: ["b.in"] }
+	`), nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	expected := []string{
+		`Blueprint:3:3: module b: output "out/gen/common.h" of rule usedRule is also built by module a`,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected errors %q, got %q", expected, got)
+	}
+}
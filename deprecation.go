@@ -0,0 +1,174 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"sort"
+	"text/scanner"
+)
+
+// A DeprecationLevel controls how uses of a deprecated module type or property
+// are reported.
+type DeprecationLevel int
+
+const (
+	// DeprecationWarn reports every use as a warning.
+	DeprecationWarn DeprecationLevel = iota
+
+	// DeprecationErrorNew reports uses that are listed in the baseline set
+	// with SetBaseline as warnings, and any other use as an error.  This
+	// allows existing uses to be migrated gradually while preventing new
+	// ones.
+	DeprecationErrorNew
+
+	// DeprecationError reports every use as an error.
+	DeprecationError
+)
+
+type deprecation struct {
+	level   DeprecationLevel
+	message string
+}
+
+// DeprecateModuleType marks a registered module type as deprecated.  Each
+// module of that type is reported according to level when dependencies are
+// resolved, along with message, which should describe what to use instead.
+func (c *Context) DeprecateModuleType(typeName string, level DeprecationLevel,
+	message string) {
+
+	if _, present := c.moduleFactories[typeName]; !present {
+		panic(fmt.Errorf("module type %q is not registered", typeName))
+	}
+
+	if c.deprecatedModuleTypes == nil {
+		c.deprecatedModuleTypes = make(map[string]deprecation)
+	}
+
+	c.deprecatedModuleTypes[typeName] = deprecation{level, message}
+}
+
+// DeprecateProperty marks a property of a registered module type as
+// deprecated.  Each module of that type that sets the property is reported
+// according to level when dependencies are resolved, along with message.
+// Properties of nested structs are named with dots, e.g. "arch.arm.srcs".
+func (c *Context) DeprecateProperty(typeName, property string,
+	level DeprecationLevel, message string) {
+
+	if _, present := c.moduleFactories[typeName]; !present {
+		panic(fmt.Errorf("module type %q is not registered", typeName))
+	}
+
+	if c.deprecatedProperties == nil {
+		c.deprecatedProperties = make(map[string]map[string]deprecation)
+	}
+
+	if c.deprecatedProperties[typeName] == nil {
+		c.deprecatedProperties[typeName] = make(map[string]deprecation)
+	}
+
+	c.deprecatedProperties[typeName][property] = deprecation{level, message}
+}
+
+// SetDeprecatedTagLevel sets how properties that are set in Blueprints files
+// and whose fields are tagged blueprint:"deprecated:<message>" are reported.
+// The default is DeprecationWarn.  Properties that are also deprecated with
+// DeprecateProperty are reported according to the level passed to it instead.
+func (c *Context) SetDeprecatedTagLevel(level DeprecationLevel) {
+	c.deprecatedTagLevel = level
+}
+
+// SetDeprecationBaseline sets the list of known uses of deprecated module types
+// and properties that are only warned about at the DeprecationErrorNew level.
+// The entries are in the format returned by DeprecatedUses, which is also the
+// format of their warning keys, so this is the same as SetBaseline.
+func (c *Context) SetDeprecationBaseline(uses []string) {
+	c.SetBaseline(uses)
+}
+
+// DeprecatedUses returns a sorted list describing every use of a deprecated
+// module type or property found when dependencies were last resolved,
+// regardless of its DeprecationLevel.  It can be saved and passed to
+// SetDeprecationBaseline to allow the current uses while preventing new ones.
+func (c *Context) DeprecatedUses() []string {
+	return c.deprecatedUses
+}
+
+// checkDeprecations reports uses of deprecated module types and properties,
+// returning the ones that are errors and recording the ones that are warnings.
+func (c *Context) checkDeprecations() (errs []error) {
+	c.deprecatedUses = nil
+
+	report := func(module *moduleInfo, what string, d deprecation,
+		pos scanner.Position) {
+
+		key := fmt.Sprintf("%s: %s", module.properties.Name, what)
+		c.deprecatedUses = append(c.deprecatedUses, key)
+
+		err := fmt.Errorf("%s is deprecated: %s", what, d.message)
+
+		switch d.level {
+		case DeprecationWarn, DeprecationErrorNew:
+			err = c.warn(key, pos, err, d.level == DeprecationErrorNew)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		case DeprecationError:
+			errs = append(errs, &Error{
+				Err: err,
+				Pos: pos,
+			})
+		default:
+			panic(fmt.Errorf("unknown deprecation level %d", d.level))
+		}
+	}
+
+	for _, name := range c.sortedModuleNames() {
+		module := c.moduleGroups[name].modules[0]
+
+		if d, ok := c.deprecatedModuleTypes[module.typeName]; ok {
+			report(module, fmt.Sprintf("module type %s", module.typeName), d,
+				module.pos)
+		}
+
+		properties := c.deprecatedProperties[module.typeName]
+		if len(properties) == 0 && len(module.deprecatedTags) == 0 {
+			continue
+		}
+
+		var propertyNames []string
+		for property := range module.propertyPos {
+			_, registered := properties[property]
+			_, tagged := module.deprecatedTags[property]
+			if registered || tagged {
+				propertyNames = append(propertyNames, property)
+			}
+		}
+		sort.Strings(propertyNames)
+
+		for _, property := range propertyNames {
+			d, ok := properties[property]
+			if !ok {
+				d = deprecation{c.deprecatedTagLevel, module.deprecatedTags[property]}
+			}
+			report(module, fmt.Sprintf("property %s.%s", module.typeName, property),
+				d, module.propertyPos[property])
+		}
+	}
+
+	sort.Strings(c.deprecatedUses)
+
+	return errs
+}
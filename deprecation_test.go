@@ -0,0 +1,191 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var deprecationTestCases = []struct {
+	level    DeprecationLevel
+	baseline []string
+	warnings int
+	errors   int
+}{
+	{
+		level:    DeprecationWarn,
+		warnings: 2,
+	},
+	{
+		level:  DeprecationErrorNew,
+		errors: 2,
+	},
+	{
+		level:    DeprecationErrorNew,
+		baseline: []string{"MyFooModule: property foo_module.foo"},
+		warnings: 1,
+		errors:   1,
+	},
+	{
+		level:    DeprecationError,
+		baseline: []string{"MyFooModule: property foo_module.foo"},
+		errors:   2,
+	},
+}
+
+func TestDeprecations(t *testing.T) {
+	for i, testCase := range deprecationTestCases {
+		ctx := NewContext()
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterModuleType("bar_module", newBarModule)
+		ctx.DeprecateProperty("foo_module", "foo", testCase.level, "use bar")
+		ctx.DeprecateModuleType("bar_module", testCase.level, "use foo_module")
+		ctx.SetBaseline(testCase.baseline)
+
+		r := bytes.NewBufferString(`
+			foo_module {
+				name: "MyFooModule",
+				foo: "abc",
+			}
+
+			foo_module {
+				name: "MyOtherFooModule",
+			}
+
+			bar_module {
+				name: "MyBarModule",
+			}
+		`)
+
+		modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+		if len(errs) == 0 {
+			errs = ctx.addModules(modules)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+
+		errs = ctx.ResolveDependencies(nil)
+		if len(errs) != testCase.errors || len(ctx.Warnings()) != testCase.warnings {
+			t.Errorf("test case %d: expected %d errors and %d warnings", i,
+				testCase.errors, testCase.warnings)
+			t.Errorf("       got: %v and %v", errs, ctx.Warnings())
+		}
+
+		var expectedKeys []string
+		if testCase.level != DeprecationError {
+			expectedKeys = []string{
+				"MyBarModule: module type bar_module",
+				"MyFooModule: property foo_module.foo",
+			}
+		}
+		keys := ctx.WarningKeys()
+		if len(keys) == 0 {
+			keys = nil
+		}
+		if !reflect.DeepEqual(keys, expectedKeys) {
+			t.Errorf("test case %d: incorrect warning keys", i)
+			t.Errorf("  expected: %q", expectedKeys)
+			t.Errorf("       got: %q", keys)
+		}
+
+		expectedUses := []string{
+			"MyBarModule: module type bar_module",
+			"MyFooModule: property foo_module.foo",
+		}
+		if !reflect.DeepEqual(ctx.DeprecatedUses(), expectedUses) {
+			t.Errorf("test case %d: incorrect deprecated uses", i)
+			t.Errorf("  expected: %q", expectedUses)
+			t.Errorf("       got: %q", ctx.DeprecatedUses())
+		}
+	}
+}
+
+type deprecatedTagModule struct {
+	properties struct {
+		Old_srcs []string `blueprint:"deprecated:use srcs, which is sorted"`
+		Srcs     []string
+	}
+}
+
+func newDeprecatedTagModule() (Module, []interface{}) {
+	m := &deprecatedTagModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (m *deprecatedTagModule) GenerateBuildActions(ModuleContext) {
+}
+
+func TestDeprecatedTag(t *testing.T) {
+	for _, level := range []DeprecationLevel{DeprecationWarn, DeprecationError} {
+		ctx := NewContext()
+		ctx.RegisterModuleType("tag_module", newDeprecatedTagModule)
+		ctx.SetDeprecatedTagLevel(level)
+
+		r := bytes.NewBufferString(`
+			tag_module {
+				name: "MyTagModule",
+				old_srcs: ["a.c"],
+			}
+
+			tag_module {
+				name: "MyOtherTagModule",
+				srcs: ["b.c"],
+			}
+		`)
+
+		modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+		if len(errs) == 0 {
+			errs = ctx.addModules(modules)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected parse errors: %v", errs)
+		}
+
+		// The deprecated property is still unpacked.
+		module := ctx.moduleGroups["MyTagModule"].modules[0]
+		srcs := module.logicModule.(*deprecatedTagModule).properties.Old_srcs
+		if !reflect.DeepEqual(srcs, []string{"a.c"}) {
+			t.Errorf("incorrect old_srcs: %q", srcs)
+		}
+
+		errs = ctx.ResolveDependencies(nil)
+
+		expected := `Blueprint:4:13: property tag_module.old_srcs is deprecated: ` +
+			`use srcs, which is sorted`
+
+		var problems []error
+		if level == DeprecationWarn {
+			if len(errs) > 0 {
+				t.Errorf("unexpected errors: %v", errs)
+			}
+			for _, w := range ctx.Warnings() {
+				problems = append(problems, w)
+			}
+		} else {
+			if len(ctx.Warnings()) > 0 {
+				t.Errorf("unexpected warnings: %v", ctx.Warnings())
+			}
+			problems = errs
+		}
+
+		if len(problems) != 1 || problems[0].Error() != expected {
+			t.Errorf("level %d: expected %q", level, expected)
+			t.Errorf("      got: %v", problems)
+		}
+	}
+}
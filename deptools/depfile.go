@@ -16,6 +16,7 @@ package deptools
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strings"
 )
@@ -31,25 +32,82 @@ var (
 )
 
 // WriteDepFile creates a new gcc-style depfile and populates it with content
-// indicating that target depends on deps.
+// indicating that target depends on deps.  The depfile is written to a
+// temporary file that is renamed to filename, so that an interrupted process
+// never leaves a truncated depfile behind.
 func WriteDepFile(filename, target string, deps []string) error {
-	f, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
 	var escapedDeps []string
 
 	for _, dep := range deps {
 		escapedDeps = append(escapedDeps, pathEscaper.Replace(dep))
 	}
 
-	_, err = fmt.Fprintf(f, "%s: \\\n %s\n", target,
+	data := fmt.Sprintf("%s: \\\n %s\n", target,
 		strings.Join(escapedDeps, " \\\n "))
+
+	tempFile := filename + ".tmp"
+	err := ioutil.WriteFile(tempFile, []byte(data), 0666)
+	if err == nil {
+		err = os.Rename(tempFile, filename)
+	}
 	if err != nil {
-		return err
+		os.Remove(tempFile)
+	}
+
+	return err
+}
+
+// ReadDepFile reads a gcc-style depfile and returns the dependencies of all of
+// the targets that it lists.
+func ReadDepFile(filename string) ([]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var deps []string
+	var dep []rune
+	inDeps := false
+	escaped := false
+
+	// flush adds the current path to deps if it follows a target's colon, and
+	// discards it if it is a target.
+	flush := func() {
+		if inDeps && len(dep) > 0 {
+			deps = append(deps, string(dep))
+		}
+		dep = dep[:0]
+	}
+
+	for _, r := range string(data) {
+		switch {
+		case escaped:
+			escaped = false
+			if r == '\n' {
+				// A line continuation separates paths like a space.
+				if inDeps {
+					flush()
+				}
+			} else {
+				dep = append(dep, r)
+			}
+		case r == '\\':
+			escaped = true
+		case r == '\n':
+			flush()
+			inDeps = false
+		case !inDeps && r == ':':
+			dep = dep[:0]
+			inDeps = true
+		case r == ' ' || r == '\t' || r == '\r':
+			if inDeps {
+				flush()
+			}
+		default:
+			dep = append(dep, r)
+		}
 	}
+	flush()
 
-	return nil
+	return deps, nil
 }
@@ -0,0 +1,146 @@
+// Copyright 2014 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package deptools
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var readDepFileTestCases = []struct {
+	name     string
+	contents string
+	deps     []string
+}{
+	{
+		name:     "single line",
+		contents: "out: a b c\n",
+		deps:     []string{"a", "b", "c"},
+	},
+	{
+		name:     "no trailing newline",
+		contents: "out: a b",
+		deps:     []string{"a", "b"},
+	},
+	{
+		name:     "no deps",
+		contents: "out:\n",
+		deps:     nil,
+	},
+	{
+		name:     "line continuations",
+		contents: "out: \\\n a \\\n b\n",
+		deps:     []string{"a", "b"},
+	},
+	{
+		name:     "line continuation without spaces",
+		contents: "out: a\\\nb\n",
+		deps:     []string{"a", "b"},
+	},
+	{
+		name:     "escaped spaces",
+		contents: "out: dir\\ with\\ spaces/a b\n",
+		deps:     []string{"dir with spaces/a", "b"},
+	},
+	{
+		name:     "escaped characters",
+		contents: "out: a\\#b a\\*b a\\[b a\\|b a\\\\b\n",
+		deps:     []string{"a#b", "a*b", "a[b", "a|b", `a\b`},
+	},
+	{
+		name:     "tabs and carriage returns",
+		contents: "out:\ta\tb\r\n",
+		deps:     []string{"a", "b"},
+	},
+	{
+		name:     "multiple targets",
+		contents: "out1 out2: a b\n",
+		deps:     []string{"a", "b"},
+	},
+	{
+		name:     "multiple rules",
+		contents: "out1: a \\\n b\nout2: c\n",
+		deps:     []string{"a", "b", "c"},
+	},
+	{
+		name:     "colon in a dep",
+		contents: "out: a:b\n",
+		deps:     []string{"a:b"},
+	},
+}
+
+func TestReadDepFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "depfile_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "out.d")
+
+	for _, testCase := range readDepFileTestCases {
+		err := ioutil.WriteFile(filename, []byte(testCase.contents), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		deps, err := ReadDepFile(filename)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", testCase.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(deps, testCase.deps) {
+			t.Errorf("%s: expected deps %q, got %q", testCase.name, testCase.deps, deps)
+		}
+	}
+}
+
+func TestWriteDepFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "depfile_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "out.d")
+	deps := []string{"a", "dir with spaces/b", "c#d", `e\f`, "g*[h]|"}
+
+	err = WriteDepFile(filename, "out", deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filename + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected %s.tmp to be removed", filename)
+	}
+
+	got, err := ReadDepFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, deps) {
+		t.Errorf("expected deps %q, got %q", deps, got)
+	}
+}
+
+func TestReadMissingDepFile(t *testing.T) {
+	_, err := ReadDepFile(filepath.Join(os.TempDir(), "depfile_test_missing.d"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+}
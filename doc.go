@@ -60,6 +60,11 @@
 // asked to generate build rules based on property values, and then singletons
 // can generate any build rules from the output of all modules.
 //
+// Every module has an "enabled" property, which is true by default.  A module
+// with "enabled: false" doesn't generate any build rules, isn't visited by
+// singletons, and may depend on modules that don't exist, but it is an error
+// for an enabled module to depend on it.
+//
 // The per-project build logic defines a top level command, referred to in the
 // documentation as the "primary builder".  This command is responsible for
 // registering the module types needed for the project, as well as any
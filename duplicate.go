@@ -0,0 +1,206 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/blueprint/parser"
+)
+
+// A DuplicateModuleResolution is the way that the definitions of a module
+// that is defined more than once with the same name are resolved.
+type DuplicateModuleResolution int
+
+const (
+	// DuplicateModuleError reports an error for each definition of the
+	// module after the first.
+	DuplicateModuleError DuplicateModuleResolution = iota
+
+	// DuplicateModuleOverride uses the definition in the overlay with the
+	// highest precedence, and ignores the others.
+	DuplicateModuleOverride
+
+	// DuplicateModuleMerge merges the properties of the definitions with
+	// parser.MergeProperties, from the overlay with the lowest precedence to
+	// the one with the highest, so the qualifiers of the properties select
+	// whether their values replace or are appended to the values of the
+	// definitions with lower precedence.  All of the definitions must have
+	// the same module type.
+	DuplicateModuleMerge
+)
+
+// A DuplicateModulePolicy decides how to resolve the definitions of a module
+// that is defined more than once with the same name, which happens when an
+// overlay tree redefines modules of the tree that it overlays.
+type DuplicateModulePolicy struct {
+	// Overlays lists the directories of the overlay trees, relative to the
+	// directory of the root Blueprints file, from the lowest precedence to
+	// the highest.  The Blueprints files in an overlay directory or its
+	// subdirectories have the precedence of the overlay, and the others
+	// have a lower precedence than all of the overlays.  If more than one
+	// overlay contains a file, the one with the highest precedence is used.
+	Overlays []string
+
+	// Resolve returns how to resolve the definitions of the module named
+	// name in blueprintsFiles, which are sorted from the lowest precedence
+	// to the highest.  Duplicate definitions are errors if it is nil.  Two
+	// definitions with the same precedence are always errors.
+	Resolve func(name string, blueprintsFiles []string) DuplicateModuleResolution
+}
+
+// SetDuplicateModulePolicy sets how the definitions of a module that is
+// defined more than once with the same name are resolved when the Blueprints
+// files are parsed.
+func (c *Context) SetDuplicateModulePolicy(policy DuplicateModulePolicy) {
+	c.duplicateModulePolicy = policy
+}
+
+// overlayPrecedence returns the precedence of the overlay that contains
+// module, or 0 if it isn't in an overlay.
+func (c *Context) overlayPrecedence(module *moduleInfo) int {
+	precedence := 0
+	for i, dir := range c.duplicateModulePolicy.Overlays {
+		if moduleInDirs(module, []string{dir}) {
+			precedence = i + 1
+		}
+	}
+	return precedence
+}
+
+// resolveDuplicateModules returns the module that is used for the module
+// named name, which has the definitions defs in the order they were added.
+// If the definitions can't be resolved the first one is returned, along with
+// the errors.
+func (c *Context) resolveDuplicateModules(name string,
+	defs []*moduleInfo) (*moduleInfo, []error) {
+
+	sorted := make(overlaySorter, len(defs))
+	for i, module := range defs {
+		sorted[i] = overlayModule{module, c.overlayPrecedence(module)}
+	}
+	sort.Stable(sorted)
+
+	resolution := DuplicateModuleError
+	if c.duplicateModulePolicy.Resolve != nil {
+		files := make([]string, len(sorted))
+		for i, def := range sorted {
+			files[i] = def.module.relBlueprintsFile
+		}
+		resolution = c.duplicateModulePolicy.Resolve(name, files)
+	}
+
+	if resolution == DuplicateModuleError {
+		var errs []error
+		for _, module := range defs[1:] {
+			errs = append(errs, duplicateModuleErrors(name, module, defs[0])...)
+		}
+		return defs[0], errs
+	}
+
+	var errs []error
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].precedence == sorted[i-1].precedence {
+			errs = append(errs, duplicateModuleErrors(name, sorted[i].module,
+				sorted[i-1].module)...)
+		}
+	}
+	if len(errs) > 0 {
+		return defs[0], errs
+	}
+
+	switch resolution {
+	case DuplicateModuleOverride:
+		return sorted[len(sorted)-1].module, nil
+	case DuplicateModuleMerge:
+		modules := make([]*moduleInfo, len(sorted))
+		for i, def := range sorted {
+			modules[i] = def.module
+		}
+		merged, errs := c.mergeModuleDefs(name, modules)
+		if len(errs) > 0 {
+			return defs[0], errs
+		}
+		return merged, nil
+	default:
+		panic(fmt.Errorf("invalid resolution %d of duplicate module %q",
+			resolution, name))
+	}
+}
+
+func duplicateModuleErrors(name string, module, previous *moduleInfo) []error {
+	return []error{
+		&Error{
+			Err: fmt.Errorf("module %q already defined", name),
+			Pos: module.pos,
+		},
+		&Error{
+			Err: fmt.Errorf("<-- previous definition here"),
+			Pos: previous.pos,
+		},
+	}
+}
+
+// mergeModuleDefs returns a new module from the merged properties of
+// modules, which are sorted from the lowest precedence to the highest.
+func (c *Context) mergeModuleDefs(name string, modules []*moduleInfo) (*moduleInfo, []error) {
+	last := modules[len(modules)-1]
+
+	merged := &parser.Module{
+		Type: last.def.Type,
+	}
+	for _, module := range modules {
+		if module.typeName != last.typeName {
+			return nil, []error{&Error{
+				Err: fmt.Errorf("module %q is defined as a %q module and as a %q "+
+					"module in %s", name, module.typeName, last.typeName,
+					last.relBlueprintsFile),
+				Pos: module.pos,
+			}}
+		}
+		properties, errs := parser.MergeProperties(merged.Properties, module.def.Properties)
+		if len(errs) > 0 {
+			return nil, errs
+		}
+		merged.Properties = properties
+	}
+
+	module, errs := c.processModuleDef(merged, last.relBlueprintsFile)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	module.namespace = last.namespace
+
+	return module, nil
+}
+
+type overlayModule struct {
+	module     *moduleInfo
+	precedence int
+}
+
+// overlaySorter sorts modules by the precedence of their overlays, and then
+// by the Blueprints files that define them.
+type overlaySorter []overlayModule
+
+func (s overlaySorter) Len() int      { return len(s) }
+func (s overlaySorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s overlaySorter) Less(i, j int) bool {
+	if s[i].precedence != s[j].precedence {
+		return s[i].precedence < s[j].precedence
+	}
+	return s[i].module.relBlueprintsFile < s[j].module.relBlueprintsFile
+}
@@ -0,0 +1,212 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type overlayTestModule struct {
+	properties struct {
+		Foo    string
+		Nested struct {
+			A string
+			B string
+		}
+	}
+}
+
+func newOverlayTestModule() (Module, []interface{}) {
+	m := &overlayTestModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (m *overlayTestModule) GenerateBuildActions(ModuleContext) {
+}
+
+func runDuplicateModuleTest(policy *DuplicateModulePolicy,
+	files []blueprintsTestFile) (*Context, []error) {
+
+	return resolveTestFiles(func(ctx *Context) {
+		ctx.RegisterModuleType("overlay_module", newOverlayTestModule)
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		if policy != nil {
+			ctx.SetDuplicateModulePolicy(*policy)
+		}
+	}, files)
+}
+
+func resolveAll(resolution DuplicateModuleResolution) func(string, []string) DuplicateModuleResolution {
+	return func(string, []string) DuplicateModuleResolution {
+		return resolution
+	}
+}
+
+var overlayTestFiles = []blueprintsTestFile{
+	{"vendor/a/Blueprints", `
+		overlay_module {
+			name: "a",
+			foo: "vendor",
+			nested: { b: "vendor" },
+		}
+	`},
+	{"a/Blueprints", `
+		overlay_module {
+			name: "a",
+			foo: "base",
+			deps: ["b"],
+			nested: { a: "base", b: "base" },
+		}
+		overlay_module { name: "b" }
+	`},
+}
+
+func TestDuplicateModuleError(t *testing.T) {
+	_, errs := runDuplicateModuleTest(nil, overlayTestFiles)
+
+	expected := []string{
+		`a/Blueprints:2:3: module "a" already defined`,
+		`vendor/a/Blueprints:2:3: <-- previous definition here`,
+	}
+	if len(errs) != 2 || errs[0].Error() != expected[0] || errs[1].Error() != expected[1] {
+		t.Errorf("expected errors %q, got %v", expected, errs)
+	}
+}
+
+func TestDuplicateModuleOverride(t *testing.T) {
+	var resolved []string
+	ctx, errs := runDuplicateModuleTest(&DuplicateModulePolicy{
+		Overlays: []string{"vendor"},
+		Resolve: func(name string, files []string) DuplicateModuleResolution {
+			resolved = append(resolved, name+": "+strings.Join(files, " "))
+			return DuplicateModuleOverride
+		},
+	}, overlayTestFiles)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	if expected := []string{"a: a/Blueprints vendor/a/Blueprints"}; !reflect.DeepEqual(resolved, expected) {
+		t.Errorf("expected Resolve calls %q, got %q", expected, resolved)
+	}
+
+	module := ctx.moduleGroups["a"].modules[0]
+	m := module.logicModule.(*overlayTestModule)
+	if m.properties.Foo != "vendor" || m.properties.Nested.A != "" {
+		t.Errorf("expected the vendor definition, got %+v", m.properties)
+	}
+	if len(module.directDeps) != 0 {
+		t.Errorf("expected no dependencies, got %d", len(module.directDeps))
+	}
+	if len(ctx.moduleInfo) != 2 {
+		t.Errorf("expected 2 modules, got %d", len(ctx.moduleInfo))
+	}
+}
+
+func TestDuplicateModuleMerge(t *testing.T) {
+	ctx, errs := runDuplicateModuleTest(&DuplicateModulePolicy{
+		Overlays: []string{"vendor"},
+		Resolve:  resolveAll(DuplicateModuleMerge),
+	}, overlayTestFiles)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	module := ctx.moduleGroups["a"].modules[0]
+	m := module.logicModule.(*overlayTestModule)
+	if m.properties.Foo != "vendor" || m.properties.Nested.A != "base" ||
+		m.properties.Nested.B != "vendor" {
+		t.Errorf("expected merged properties, got %+v", m.properties)
+	}
+	if len(module.directDeps) != 1 || module.directDeps[0].properties.Name != "b" {
+		t.Errorf("expected a dependency on b, got %d dependencies", len(module.directDeps))
+	}
+	if module.relBlueprintsFile != "vendor/a/Blueprints" {
+		t.Errorf("expected the module to be defined in vendor/a/Blueprints, got %s",
+			module.relBlueprintsFile)
+	}
+}
+
+func TestDuplicateModuleResolutionErrors(t *testing.T) {
+	testCases := []struct {
+		resolution DuplicateModuleResolution
+		files      []blueprintsTestFile
+		err        string
+	}{
+		{
+			// Definitions in the same overlay have the same precedence.
+			resolution: DuplicateModuleOverride,
+			files: []blueprintsTestFile{
+				{"vendor/a/Blueprints", `overlay_module { name: "a" }`},
+				{"vendor/b/Blueprints", `overlay_module { name: "a" }`},
+			},
+			err: `vendor/b/Blueprints:1:1: module "a" already defined`,
+		},
+		{
+			resolution: DuplicateModuleMerge,
+			files: []blueprintsTestFile{
+				{"a/Blueprints", `foo_module { name: "a" }`},
+				{"vendor/a/Blueprints", `overlay_module { name: "a" }`},
+			},
+			err: `a/Blueprints:1:1: module "a" is defined as a "foo_module" module ` +
+				`and as a "overlay_module" module in vendor/a/Blueprints`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, errs := runDuplicateModuleTest(&DuplicateModulePolicy{
+			Overlays: []string{"vendor"},
+			Resolve:  resolveAll(testCase.resolution),
+		}, testCase.files)
+		if len(errs) == 0 || errs[0].Error() != testCase.err {
+			t.Errorf("expected error %q, got %v", testCase.err, errs)
+		}
+	}
+}
+
+func TestDuplicateModuleMergeQualifiers(t *testing.T) {
+	ctx, errs := runDuplicateModuleTest(&DuplicateModulePolicy{
+		Overlays: []string{"vendor"},
+		Resolve:  resolveAll(DuplicateModuleMerge),
+	}, []blueprintsTestFile{
+		{"a/Blueprints", `
+			overlay_module { name: "a", deps: ["b"], nested: { a: "base" } }
+			overlay_module { name: "b" }
+			overlay_module { name: "c" }
+		`},
+		{"vendor/a/Blueprints", `
+			overlay_module { name: "a", deps +: ["c"], nested =: { b: "vendor" } }
+		`},
+	})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	module := ctx.moduleGroups["a"].modules[0]
+	var deps []string
+	for _, dep := range module.directDeps {
+		deps = append(deps, dep.properties.Name)
+	}
+	if expected := []string{"b", "c"}; !reflect.DeepEqual(deps, expected) {
+		t.Errorf("expected dependencies %q, got %q", expected, deps)
+	}
+
+	m := module.logicModule.(*overlayTestModule)
+	if m.properties.Nested.A != "" || m.properties.Nested.B != "vendor" {
+		t.Errorf("expected the vendor nested properties, got %+v", m.properties.Nested)
+	}
+}
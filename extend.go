@@ -0,0 +1,109 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A ModuleExtender creates a module that extends base, a new module of the
+// module type being extended.  It returns the new module and the property
+// structs that it adds to those of base.
+//
+// The new module usually embeds base, so that it inherits its methods,
+// including the interfaces that mutators look for, and overrides the methods
+// whose behavior it changes.  For example:
+//
+//	type strippedBinary struct {
+//	    *binary
+//	    properties struct {
+//	        Strip_flags []string
+//	    }
+//	}
+//
+//	func extendBinary(base blueprint.Module) (blueprint.Module, []interface{}) {
+//	    m := &strippedBinary{binary: base.(*binary)}
+//	    return m, []interface{}{&m.properties}
+//	}
+//
+//	func (m *strippedBinary) GenerateBuildActions(ctx blueprint.ModuleContext) {
+//	    m.binary.GenerateBuildActions(ctx)
+//	    // ...
+//	}
+type ModuleExtender func(base Module) (Module, []interface{})
+
+// ExtendModuleType returns a ModuleFactory for a module type that extends the
+// module type created by base.  Each module is created by passing a new
+// module from base to extend, and has the property structs of the base module
+// followed by those added by extend, so a module of the new type may set any
+// of the properties of the base type.
+func ExtendModuleType(base ModuleFactory, extend ModuleExtender) ModuleFactory {
+	return func() (Module, []interface{}) {
+		baseModule, baseProperties := base()
+		module, properties := extend(baseModule)
+		if module == nil {
+			panic(errors.New("module extender returned a nil module"))
+		}
+
+		for _, p := range properties {
+			for _, baseP := range baseProperties {
+				if p == baseP {
+					panic(fmt.Errorf("module extender returned property struct %T "+
+						"of the base module", p))
+				}
+			}
+		}
+
+		allProperties := make([]interface{}, 0, len(baseProperties)+len(properties))
+		allProperties = append(allProperties, baseProperties...)
+		allProperties = append(allProperties, properties...)
+
+		return module, allProperties
+	}
+}
+
+// RegisterExtendedModuleType registers a module type that extends the
+// registered module type baseName, as described by ExtendModuleType.  The
+// relationship between the module types is returned by ModuleTypeBases, so
+// that the documentation of the new module type can refer to the base type.
+func (c *Context) RegisterExtendedModuleType(name, baseName string,
+	extend ModuleExtender) {
+
+	base, present := c.moduleFactories[baseName]
+	if !present {
+		panic(fmt.Errorf("module type %q is not registered", baseName))
+	}
+
+	c.RegisterModuleType(name, ExtendModuleType(base, extend))
+
+	if c.moduleTypeBases == nil {
+		c.moduleTypeBases = make(map[string]string)
+	}
+
+	c.moduleTypeBases[name] = baseName
+}
+
+// ModuleTypeBases returns a mapping from the name of each module type
+// registered with RegisterExtendedModuleType to the name of the module type
+// that it extends.
+func (c *Context) ModuleTypeBases() map[string]string {
+	ret := make(map[string]string)
+	for name, baseName := range c.moduleTypeBases {
+		ret[name] = baseName
+	}
+
+	return ret
+}
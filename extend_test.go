@@ -0,0 +1,99 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type extendedFooModule struct {
+	*fooModule
+	properties struct {
+		Suffix string
+	}
+	generated string
+}
+
+func extendFooModule(base Module) (Module, []interface{}) {
+	m := &extendedFooModule{fooModule: base.(*fooModule)}
+	return m, []interface{}{&m.properties}
+}
+
+func (e *extendedFooModule) GenerateBuildActions(ctx ModuleContext) {
+	e.fooModule.GenerateBuildActions(ctx)
+	e.generated = e.Foo() + e.properties.Suffix
+}
+
+func TestExtendedModuleType(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterExtendedModuleType("extended_foo_module", "foo_module", extendFooModule)
+
+	r := bytes.NewBufferString(`
+		extended_foo_module {
+			name: "a",
+			foo: "abc",
+			suffix: "def",
+		}
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	m := modules[0].logicModule.(*extendedFooModule)
+	if m.generated != "abcdef" {
+		t.Errorf("expected the base and extended properties to be set, got %q", m.generated)
+	}
+
+	// Interfaces implemented by the base module are implemented by the
+	// extended module, so mutators treat them the same.
+	if _, ok := modules[0].logicModule.(interface{ Foo() string }); !ok {
+		t.Errorf("expected the extended module to implement the methods of the base module")
+	}
+
+	expected := map[string]string{"extended_foo_module": "foo_module"}
+	if bases := ctx.ModuleTypeBases(); !reflect.DeepEqual(bases, expected) {
+		t.Errorf("expected module type bases %q, got %q", expected, bases)
+	}
+
+	if n := len(ctx.ModuleTypePropertyStructs()["extended_foo_module"]); n != 2 {
+		t.Errorf("expected 2 property structs, got %d", n)
+	}
+}
+
+func TestExtendUnregisteredModuleType(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a panic extending an unregistered module type")
+		}
+	}()
+
+	ctx := NewContext()
+	ctx.RegisterExtendedModuleType("extended_foo_module", "foo_module", extendFooModule)
+}
@@ -0,0 +1,189 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package format formats Blueprints files the way bpfmt does, so that tools
+// and tests can check or fix the formatting of a tree without running bpfmt.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/blueprint/parser"
+)
+
+// A Mode selects what File and Walk do with a file whose formatting differs.
+type Mode int
+
+const (
+	// Check only reports the file.
+	Check Mode = iota
+
+	// Diff reports the file with a unified diff of the changes that
+	// formatting it would make.
+	Diff
+
+	// Overwrite writes the formatted file in place.
+	Overwrite
+)
+
+// Options changes how the files are formatted.
+type Options struct {
+	// SortLists sorts the lists of strings, as bpfmt -s does.
+	SortLists bool
+
+	// FileName is the name of the files that Walk formats in directories,
+	// as passed to Files.
+	FileName string
+}
+
+// A Result describes a file whose formatting differs.
+type Result struct {
+	Filename string
+
+	// Diff is a unified diff from the file to the formatted file, only set
+	// in Diff mode.
+	Diff []byte
+}
+
+// A ParseError is returned for a file that can't be formatted because it
+// can't be parsed.
+type ParseError struct {
+	Filename string
+	Errs     []error
+}
+
+func (e *ParseError) Error() string {
+	var msgs []string
+	for _, err := range e.Errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Source returns src, the contents of filename, formatted.
+func Source(filename string, src []byte, opts Options) ([]byte, error) {
+	file, errs := parser.Parse(filename, bytes.NewReader(src), parser.NewScope(nil))
+	if len(errs) > 0 {
+		return nil, &ParseError{filename, errs}
+	}
+
+	if opts.SortLists {
+		parser.SortLists(file)
+	}
+
+	return parser.Print(file)
+}
+
+// File formats filename according to mode.  It returns nil if the file is
+// already formatted.
+func File(filename string, mode Mode, opts Options) (*Result, error) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := Source(filename, src, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(src, res) {
+		return nil, nil
+	}
+
+	result := &Result{Filename: filename}
+
+	switch mode {
+	case Check:
+	case Diff:
+		result.Diff = UnifiedDiff(filename+".orig", filename, src, res)
+	case Overwrite:
+		info, err := os.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		err = ioutil.WriteFile(filename, res, info.Mode().Perm())
+		if err != nil {
+			return nil, err
+		}
+	default:
+		panic(fmt.Errorf("unknown format mode %d", mode))
+	}
+
+	return result, nil
+}
+
+// Files returns each of paths that is a file, and the files named fileName
+// found by walking the paths that are directories recursively.  If fileName
+// is empty it is "Blueprints".
+func Files(paths []string, fileName string) ([]string, []error) {
+	if fileName == "" {
+		fileName = "Blueprints"
+	}
+
+	var files []string
+	var errs []error
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.Walk(path, func(filename string, info os.FileInfo, err error) error {
+			if err != nil {
+				errs = append(errs, err)
+			} else if !info.IsDir() && info.Name() == fileName {
+				files = append(files, filename)
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return files, errs
+}
+
+// Walk formats the files returned by Files for paths and opts.FileName
+// according to mode.  It returns the files whose formatting differs, and the
+// errors for the files that couldn't be formatted, so that one bad file
+// doesn't hide the rest.
+func Walk(paths []string, mode Mode, opts Options) ([]Result, []error) {
+	files, errs := Files(paths, opts.FileName)
+
+	var results []Result
+	for _, filename := range files {
+		result, err := File(filename, mode, opts)
+		if err != nil {
+			errs = append(errs, err)
+		} else if result != nil {
+			results = append(results, *result)
+		}
+	}
+
+	return results, errs
+}
@@ -0,0 +1,141 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package format
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const (
+	unformatted = `foo{name= "abc",}
+`
+	formatted = `foo {
+    name: "abc",
+}
+`
+)
+
+var unifiedDiffTestCases = []struct {
+	old, new string
+	diff     string
+}{
+	{
+		old: "a\nb\nc\n",
+		new: "a\nb\nc\n",
+	},
+	{
+		old:  unformatted,
+		new:  formatted,
+		diff: "--- a\n+++ b\n@@ -1 +1,3 @@\n-foo{name= \"abc\",}\n+foo {\n+    name: \"abc\",\n+}\n",
+	},
+	{
+		old: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n16\n",
+		new: "1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\nfifteen\n16\n",
+		diff: "--- a\n+++ b\n" +
+			"@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n" +
+			"@@ -12,5 +12,5 @@\n 12\n 13\n 14\n-15\n+fifteen\n 16\n",
+	},
+	{
+		old:  "a\nb\nc",
+		new:  "a\nb\n",
+		diff: "--- a\n+++ b\n@@ -1,3 +1,2 @@\n a\n b\n-c\n\\ No newline at end of file\n",
+	},
+	{
+		old:  "",
+		new:  "a\n",
+		diff: "--- a\n+++ b\n@@ -0,0 +1 @@\n+a\n",
+	},
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	for _, testCase := range unifiedDiffTestCases {
+		diff := string(UnifiedDiff("a", "b", []byte(testCase.old), []byte(testCase.new)))
+		if diff != testCase.diff {
+			t.Errorf("incorrect diff from %q to %q", testCase.old, testCase.new)
+			t.Errorf("  expected: %q", testCase.diff)
+			t.Errorf("       got: %q", diff)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "format_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"Blueprints":           formatted,
+		"a/Blueprints":         unformatted,
+		"a/b/Blueprints":       unformatted,
+		"a/b/Blueprints.extra": unformatted,
+		"c/Blueprints":         "foo {",
+	}
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []string{
+		filepath.Join(dir, "a/Blueprints"),
+		filepath.Join(dir, "a/b/Blueprints"),
+	}
+
+	results, errs := Walk([]string{dir}, Diff, Options{})
+	if len(errs) != 1 {
+		t.Errorf("expected 1 error for c/Blueprints, got %q", errs)
+	} else if _, ok := errs[0].(*ParseError); !ok {
+		t.Errorf("expected a ParseError, got %T %q", errs[0], errs[0])
+	}
+
+	var got []string
+	for _, result := range results {
+		got = append(got, result.Filename)
+		if result.Diff == nil {
+			t.Errorf("expected a diff for %s", result.Filename)
+		}
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected unformatted files %q, got %q", expected, got)
+	}
+
+	results, _ = Walk([]string{dir}, Overwrite, Options{})
+	if len(results) != 2 {
+		t.Errorf("expected 2 files to be overwritten, got %d", len(results))
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "a/b/Blueprints"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != formatted {
+		t.Errorf("expected the file to be overwritten with %q, got %q", formatted, data)
+	}
+
+	results, _ = Walk([]string{dir}, Check, Options{})
+	if len(results) != 0 {
+		t.Errorf("expected all files to be formatted, got %v", results)
+	}
+}
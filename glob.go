@@ -0,0 +1,89 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/google/blueprint/pathtools"
+)
+
+// A GlobPath describes a glob performed with ModuleContext.Glob.
+type GlobPath struct {
+	Pattern  string
+	Excludes []string
+	Files    []string // The files that matched the glob.
+	Deps     []string // The directories that were searched.
+}
+
+func globKey(pattern string, excludes []string) string {
+	return pattern + "\x00" + strings.Join(excludes, "\x00")
+}
+
+// glob returns the files that match pattern but not excludes, and records the
+// glob so that it is returned by Globs.  Modules that perform the same glob
+// share the result.
+func (c *Context) glob(pattern string, excludes []string) ([]string, error) {
+	key := globKey(pattern, excludes)
+
+	c.globsLock.Lock()
+	g, exists := c.globs[key]
+	c.globsLock.Unlock()
+
+	if !exists {
+		files, deps, err := pathtools.GlobWithExcludes(pattern, excludes)
+		if err != nil {
+			return nil, err
+		}
+
+		g = GlobPath{
+			Pattern:  pattern,
+			Excludes: append([]string(nil), excludes...),
+			Files:    files,
+			Deps:     deps,
+		}
+
+		c.globsLock.Lock()
+		if c.globs == nil {
+			c.globs = make(map[string]GlobPath)
+		}
+		c.globs[key] = g
+		c.globsLock.Unlock()
+	}
+
+	// Return a copy so that modules can't change the recorded result.
+	return append([]string(nil), g.Files...), nil
+}
+
+// Globs returns the globs performed by modules with ModuleContext.Glob during
+// PrepareBuildActions, sorted by pattern.  The primary builder uses them to
+// regenerate the Ninja file when the result of any of the globs changes.
+func (c *Context) Globs() []GlobPath {
+	c.globsLock.Lock()
+	defer c.globsLock.Unlock()
+
+	keys := make([]string, 0, len(c.globs))
+	for key := range c.globs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	globs := make([]GlobPath, len(keys))
+	for i, key := range keys {
+		globs[i] = c.globs[key]
+	}
+	return globs
+}
@@ -0,0 +1,159 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var globTestDir string
+
+type globModule struct {
+	properties struct {
+		Srcs     string
+		Excludes []string
+	}
+	files []string
+}
+
+func newGlobModule() (Module, []interface{}) {
+	m := &globModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (g *globModule) GenerateBuildActions(ctx ModuleContext) {
+	var excludes []string
+	for _, exclude := range g.properties.Excludes {
+		excludes = append(excludes, filepath.Join(globTestDir, exclude))
+	}
+
+	var err error
+	g.files, err = ctx.Glob(filepath.Join(globTestDir, g.properties.Srcs), excludes)
+	if err != nil {
+		ctx.ModuleErrorf("%s", err)
+	}
+}
+
+type globSingleton struct {
+	globs []GlobPath
+}
+
+func (s *globSingleton) GenerateBuildActions(ctx SingletonContext) {
+	s.globs = ctx.Globs()
+}
+
+func TestGlob(t *testing.T) {
+	var err error
+	globTestDir, err = ioutil.TempDir("", "glob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(globTestDir)
+
+	for _, file := range []string{"a.c", "b.c", "b.h", "sub/c.c"} {
+		path := filepath.Join(globTestDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	singleton := &globSingleton{}
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("glob_module", newGlobModule)
+	ctx.RegisterSingletonType("glob_singleton", func() Singleton {
+		return singleton
+	})
+
+	r := bytes.NewBufferString(`
+		glob_module {
+			name: "a",
+			srcs: "*.c",
+		}
+
+		glob_module {
+			name: "b",
+			srcs: "*.c",
+		}
+
+		glob_module {
+			name: "c",
+			srcs: "**/*.c",
+			excludes: ["a.c"],
+		}
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	abs := func(files ...string) []string {
+		for i := range files {
+			files[i] = filepath.Join(globTestDir, files[i])
+		}
+		return files
+	}
+
+	checkFiles := func(name string, expected []string) {
+		g := ctx.moduleGroups[name].modules[0].logicModule.(*globModule)
+		if !reflect.DeepEqual(g.files, expected) {
+			t.Errorf("incorrect files for %s:", name)
+			t.Errorf("     got: %q", g.files)
+			t.Errorf("expected: %q", expected)
+		}
+	}
+
+	checkFiles("a", abs("a.c", "b.c"))
+	checkFiles("b", abs("a.c", "b.c"))
+	checkFiles("c", abs("b.c", "sub/c.c"))
+
+	expected := []GlobPath{
+		{
+			Pattern:  filepath.Join(globTestDir, "**/*.c"),
+			Excludes: abs("a.c"),
+			Files:    abs("b.c", "sub/c.c"),
+			Deps:     abs(".", "sub"),
+		},
+		{
+			Pattern: filepath.Join(globTestDir, "*.c"),
+			Files:   abs("a.c", "b.c"),
+			Deps:    abs("."),
+		},
+	}
+
+	if !reflect.DeepEqual(singleton.globs, expected) {
+		t.Errorf("incorrect globs:")
+		t.Errorf("     got: %#v", singleton.globs)
+		t.Errorf("expected: %#v", expected)
+	}
+}
@@ -12,46 +12,115 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package gotestmain
+package main
 
 import (
 	"bytes"
 	"flag"
 	"fmt"
 	"go/ast"
+	"go/doc"
 	"go/parser"
 	"go/token"
 	"io/ioutil"
 	"os"
 	"strings"
 	"text/template"
+	"unicode"
+	"unicode/utf8"
 )
 
 var (
-	output   = flag.String("o", "", "output filename")
-	pkg      = flag.String("pkg", "", "test package")
-	exitCode = 0
+	output    = flag.String("o", "", "output filename")
+	pkg       = flag.String("pkg", "", "test package")
+	bench     = flag.Bool("bench", false, "register the Benchmark functions, which are run with -test.bench")
+	coverMode = flag.String("cover-mode", "", "coverage mode the package is instrumented with")
+	coverVars = flag.String("cover-vars", "", "comma-separated coverage variables of the package, as var=file")
+	exitCode  = 0
 )
 
 type data struct {
-	Package string
-	Tests   []string
+	Package    string
+	Tests      []string
+	Benchmarks []string
+	Examples   []*doc.Example
+	HasMain    bool
+	CoverMode  string
+	CoverVars  []coverVar
+}
+
+// A coverVar is a variable added to the package by the cover tool, which
+// holds the counters of the blocks in File.
+type coverVar struct {
+	Var  string
+	File string
+}
+
+func parseCoverVars(s string) (vars []coverVar) {
+	if s == "" {
+		return nil
+	}
+	for _, v := range strings.Split(s, ",") {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			fmt.Fprintf(os.Stderr, "error: invalid coverage variable %q\n", v)
+			os.Exit(1)
+		}
+		vars = append(vars, coverVar{parts[0], parts[1]})
+	}
+	return
+}
+
+// isTest returns whether name is the name of a test function with the given
+// prefix, using the same rule as go test: the prefix must not be followed by
+// a lower case letter, so that e.g. Testify is not a test.
+func isTest(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	if len(name) == len(prefix) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+	return !unicode.IsLower(r)
 }
 
-func findTests(srcs []string) (tests []string) {
+// findTests fills in the tests, benchmarks, examples and TestMain function
+// of d from the test sources srcs.
+func findTests(d *data, srcs []string) {
+	var files []*ast.File
 	for _, src := range srcs {
-		f, err := parser.ParseFile(token.NewFileSet(), src, nil, 0)
+		f, err := parser.ParseFile(token.NewFileSet(), src, nil, parser.ParseComments)
 		if err != nil {
 			panic(err)
 		}
-		for _, obj := range f.Scope.Objects {
-			if obj.Kind != ast.Fun || !strings.HasPrefix(obj.Name, "Test") {
+		files = append(files, f)
+
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil {
 				continue
 			}
-			tests = append(tests, obj.Name)
+
+			name := fn.Name.Name
+			switch {
+			case name == "TestMain":
+				d.HasMain = true
+			case isTest(name, "Test"):
+				d.Tests = append(d.Tests, name)
+			case isTest(name, "Benchmark") && *bench:
+				d.Benchmarks = append(d.Benchmarks, name)
+			}
+		}
+	}
+
+	// Like go test, the examples without an output comment are compiled but
+	// not run.
+	for _, example := range doc.Examples(files...) {
+		if example.Output != "" || example.EmptyOutput {
+			d.Examples = append(d.Examples, example)
 		}
 	}
-	return
 }
 
 func main() {
@@ -66,9 +135,11 @@ func main() {
 	buf := &bytes.Buffer{}
 
 	d := data{
-		Package: *pkg,
-		Tests:   findTests(flag.Args()),
+		Package:   *pkg,
+		CoverMode: *coverMode,
+		CoverVars: parseCoverVars(*coverVars),
 	}
+	findTests(&d, flag.Args())
 
 	err := testMainTmpl.Execute(buf, d)
 	if err != nil {
@@ -85,7 +156,15 @@ var testMainTmpl = template.Must(template.New("testMain").Parse(`
 package main
 
 import (
-	"testing"
+{{if .CoverVars}}	"bufio"
+{{end}}	"fmt"
+	"os"
+{{if .HasMain}}	"reflect"
+{{end}}	"strconv"
+{{if .CoverVars}}	"sync"
+	"sync/atomic"
+{{end}}	"testing"
+	"testing/internal/testdeps"
 
 	pkg "{{.Package}}"
 )
@@ -96,11 +175,143 @@ var t = []testing.InternalTest{
 {{end}}
 }
 
-func matchString(pat, str string) (bool, error) {
-	return true, nil
+var b = []testing.InternalBenchmark{
+{{range .Benchmarks}}
+	{"{{.}}", pkg.{{.}}},
+{{end}}
 }
 
-func main() {
-	testing.Main(matchString, t, nil, nil)
+var e = []testing.InternalExample{
+{{range .Examples}}
+	{"Example{{.Name}}", pkg.Example{{.Name}}, {{printf "%q" .Output}}, {{.Unordered}}},
+{{end}}
+}
+
+// shard returns a function that reports whether the i'th test, benchmark or
+// example belongs to the shard selected by the TEST_SHARD_INDEX and
+// TEST_TOTAL_SHARDS environment variables.  All of them do if the variables
+// are not set.
+func shard() func(i int) bool {
+	total, err := strconv.Atoi(os.Getenv("TEST_TOTAL_SHARDS"))
+	if err != nil || total <= 1 {
+		return func(int) bool { return true }
+	}
+
+	index, err := strconv.Atoi(os.Getenv("TEST_SHARD_INDEX"))
+	if err != nil || index < 0 || index >= total {
+		fmt.Fprintf(os.Stderr, "invalid TEST_SHARD_INDEX %q\n",
+			os.Getenv("TEST_SHARD_INDEX"))
+		os.Exit(2)
+	}
+
+	return func(i int) bool {
+		return i%total == index
+	}
 }
+{{if .CoverVars}}
+var coverFiles = []struct {
+	file    string
+	count   []uint32
+	pos     []uint32
+	numStmt []uint16
+}{
+{{range .CoverVars}}
+	{"{{.File}}", pkg.{{.Var}}.Count[:], pkg.{{.Var}}.Pos[:], pkg.{{.Var}}.NumStmt[:]},
+{{end}}
+}
+
+var coverLock sync.Mutex
+
+// writeCoverProfile writes the coverage counters of the package to the file
+// named by the TEST_COVERPROFILE environment variable, if it is set.
+func writeCoverProfile() error {
+	file := os.Getenv("TEST_COVERPROFILE")
+	if file == "" {
+		return nil
+	}
+
+	coverLock.Lock()
+	defer coverLock.Unlock()
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	fmt.Fprintf(w, "mode: {{.CoverMode}}\n")
+	for _, c := range coverFiles {
+		for i := range c.count {
+			// Each block has its start and end lines followed by its
+			// start and end columns packed into one value.
+			fmt.Fprintf(w, "%s:%d.%d,%d.%d %d %d\n", c.file,
+				c.pos[3*i], uint16(c.pos[3*i+2]),
+				c.pos[3*i+1], uint16(c.pos[3*i+2]>>16),
+				c.numStmt[i], atomic.LoadUint32(&c.count[i]))
+		}
+	}
+	return w.Flush()
+}
+
+// coverTest wraps a test to write the coverage profile after it and its
+// subtests finish, since the test binary may exit as soon as the last one
+// does.
+func coverTest(test testing.InternalTest) testing.InternalTest {
+	f := test.F
+	return testing.InternalTest{
+		Name: test.Name,
+		F: func(t *testing.T) {
+			t.Cleanup(func() {
+				if err := writeCoverProfile(); err != nil {
+					t.Errorf("error writing coverage profile: %s", err)
+				}
+			})
+			f(t)
+		},
+	}
+}
+{{end}}
+func main() {
+{{if .CoverVars}}	// The profile is written before the tests are run too, so that it
+	// exists even if the shard has none.
+	if err := writeCoverProfile(); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing coverage profile: %s\n", err)
+		os.Exit(2)
+	}
+
+{{end}}	inShard := shard()
+
+	var tests []testing.InternalTest
+	for i, test := range t {
+		if inShard(i) {
+{{if .CoverVars}}			test = coverTest(test)
+{{end}}			tests = append(tests, test)
+		}
+	}
+
+	var benchmarks []testing.InternalBenchmark
+	for i, benchmark := range b {
+		if inShard(i) {
+			benchmarks = append(benchmarks, benchmark)
+		}
+	}
+
+	var examples []testing.InternalExample
+	for i, example := range e {
+		if inShard(i) {
+			examples = append(examples, example)
+		}
+	}
+
+	m := testing.MainStart(testdeps.TestDeps{}, tests, benchmarks, nil, examples)
+{{if .HasMain}}
+	pkg.TestMain(m)
+
+	// A TestMain function that returns instead of calling os.Exit exits
+	// with the result of m.Run.
+	os.Exit(int(reflect.ValueOf(m).Elem().FieldByName("exitCode").Int()))
+{{else}}
+	os.Exit(m.Run())
+{{end}}}
 `))
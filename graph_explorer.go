@@ -0,0 +1,264 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+)
+
+// graphExplorerRoot is a variant of the root module of a graph explorer page.
+type graphExplorerRoot struct {
+	Name    string `json:"name"`
+	Variant string `json:"variant"`
+}
+
+type graphExplorerData struct {
+	Root  string
+	Roots []graphExplorerRoot
+	Graph *ModuleGraph
+}
+
+// WriteModuleGraphHTML writes an HTML page to w for exploring the
+// dependencies of the module named root, so that a build can be triaged with
+// only a web browser.  The page embeds the part of the graph returned by
+// ModuleGraph that root depends on, directly or indirectly, and doesn't load
+// anything else.  It shows the dependencies of each variant of root as a tree
+// whose nodes can be expanded and collapsed, the properties and outputs of the
+// selected module, and can search for a module by name to show the path to it
+// from root.
+func (c *Context) WriteModuleGraphHTML(w io.Writer, root string) error {
+	group := c.moduleGroups[root]
+	if group == nil {
+		return fmt.Errorf("module %q is not defined", root)
+	}
+
+	reachable := make(map[*moduleInfo]bool)
+	var visit func(module *moduleInfo)
+	visit = func(module *moduleInfo) {
+		if reachable[module] {
+			return
+		}
+		reachable[module] = true
+		for _, dep := range module.directDeps {
+			visit(dep)
+		}
+	}
+
+	data := graphExplorerData{Root: root}
+	for _, module := range group.modules {
+		visit(module)
+		data.Roots = append(data.Roots, graphExplorerRoot{
+			Name:    module.properties.Name,
+			Variant: module.variantName,
+		})
+	}
+
+	graph, err := c.moduleGraph(func(module *moduleInfo) bool {
+		return reachable[module]
+	})
+	if err != nil {
+		return err
+	}
+	data.Graph = graph
+
+	return graphExplorerTemplate.Execute(w, data)
+}
+
+var graphExplorerTemplate = template.Must(template.New("graph").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Dependencies of {{.Root}}</title>
+<style>
+  body { font-family: sans-serif; margin: 0; display: flex; height: 100vh; }
+  #tree { flex: 1; overflow: auto; padding: 8px; }
+  #side { width: 40%; overflow: auto; padding: 8px; border-left: 1px solid #ccc; }
+  ul { list-style: none; padding-left: 18px; margin: 0; }
+  .toggle { display: inline-block; width: 14px; cursor: pointer; font-family: monospace; }
+  .name { cursor: pointer; }
+  .info { color: #888; font-size: smaller; }
+  .selected > .name { background: #ffe680; }
+  #search { width: 100%; box-sizing: border-box; }
+  #results li { cursor: pointer; }
+  #results li:hover { text-decoration: underline; }
+  pre { font-size: smaller; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<div id="tree"><h3>Dependencies of {{.Root}}</h3><ul id="roots"></ul></div>
+<div id="side">
+  <input id="search" type="search" placeholder="Search modules">
+  <ul id="results"></ul>
+  <pre id="details"></pre>
+</div>
+<script>
+  var graph = {{.Graph}};
+  var roots = {{.Roots}};
+
+  function key(name, variant) {
+    return name + "\u0000" + (variant || "");
+  }
+
+  var modules = {};
+  graph.modules.forEach(function(m) {
+    modules[key(m.name, m.variant)] = m;
+  });
+
+  function label(m) {
+    return m.variant ? m.name + " (" + m.variant + ")" : m.name;
+  }
+
+  var selected = null;
+
+  function select(li) {
+    if (selected) {
+      selected.classList.remove("selected");
+    }
+    selected = li;
+    li.classList.add("selected");
+    document.getElementById("details").textContent =
+        JSON.stringify(modules[li.dataset.key], null, 2);
+  }
+
+  // node returns a tree node for the module with key k, whose dependencies
+  // are only added when it is first expanded.
+  function node(k) {
+    var m = modules[k];
+    var deps = m.deps || [];
+    var li = document.createElement("li");
+    li.dataset.key = k;
+
+    var toggle = document.createElement("span");
+    toggle.className = "toggle";
+    toggle.textContent = deps.length ? "+" : " ";
+    li.appendChild(toggle);
+
+    var name = document.createElement("span");
+    name.className = "name";
+    name.textContent = label(m);
+    li.appendChild(name);
+
+    var info = document.createElement("span");
+    info.className = "info";
+    info.textContent = " " + m.type + " in " + m.dir;
+    li.appendChild(info);
+
+    var children = null;
+    li.expand = function(open) {
+      if (!deps.length) {
+        return;
+      }
+      if (!children) {
+        children = document.createElement("ul");
+        deps.forEach(function(dep) {
+          children.appendChild(node(key(dep.name, dep.variant)));
+        });
+        li.appendChild(children);
+      }
+      children.hidden = !open;
+      toggle.textContent = open ? "-" : "+";
+    };
+    li.child = function(k) {
+      li.expand(true);
+      for (var i = 0; i < children.children.length; i++) {
+        if (children.children[i].dataset.key === k) {
+          return children.children[i];
+        }
+      }
+      return null;
+    };
+
+    toggle.onclick = function() {
+      li.expand(!children || children.hidden);
+    };
+    name.onclick = function() {
+      select(li);
+    };
+    return li;
+  }
+
+  var rootList = document.getElementById("roots");
+  roots.forEach(function(r) {
+    var li = node(key(r.name, r.variant));
+    rootList.appendChild(li);
+    li.expand(true);
+  });
+
+  // reveal expands the tree along the shortest path from a root to the
+  // module with key k, and selects it.
+  function reveal(k) {
+    var parents = {};
+    var queue = [];
+    roots.forEach(function(r) {
+      var rk = key(r.name, r.variant);
+      parents[rk] = null;
+      queue.push(rk);
+    });
+    while (queue.length && !(k in parents)) {
+      var cur = queue.shift();
+      (modules[cur].deps || []).forEach(function(dep) {
+        var dk = key(dep.name, dep.variant);
+        if (!(dk in parents)) {
+          parents[dk] = cur;
+          queue.push(dk);
+        }
+      });
+    }
+
+    var path = [];
+    for (var p = k; p !== null; p = parents[p]) {
+      path.unshift(p);
+    }
+
+    var li = null;
+    for (var i = 0; i < rootList.children.length; i++) {
+      if (rootList.children[i].dataset.key === path[0]) {
+        li = rootList.children[i];
+      }
+    }
+    for (var i = 1; i < path.length; i++) {
+      li = li.child(path[i]);
+    }
+    select(li);
+    li.scrollIntoView();
+  }
+
+  document.getElementById("search").oninput = function() {
+    var query = this.value.toLowerCase();
+    var results = document.getElementById("results");
+    results.textContent = "";
+    if (!query) {
+      return;
+    }
+    var count = 0;
+    graph.modules.forEach(function(m) {
+      if (count >= 100 || m.name.toLowerCase().indexOf(query) < 0) {
+        return;
+      }
+      count++;
+      var li = document.createElement("li");
+      li.textContent = label(m);
+      li.onclick = function() {
+        reveal(key(m.name, m.variant));
+      };
+      results.appendChild(li);
+    });
+  };
+</script>
+</body>
+</html>
+`))
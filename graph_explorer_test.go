@@ -0,0 +1,75 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteModuleGraphHTML(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+
+	r := bytes.NewBufferString(`
+		foo_module { name: "a", deps: ["b"] }
+		foo_module { name: "b", deps: ["c"] }
+		foo_module { name: "c", foo: "</script>" }
+		foo_module { name: "d", deps: ["a"] }
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteModuleGraphHTML(buf, "a"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	html := buf.String()
+
+	for _, expected := range []string{
+		"<title>Dependencies of a</title>",
+		`var roots = [{"name":"a","variant":""}];`,
+		`"name":"a"`,
+		`"name":"b"`,
+		`"name":"c"`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("expected %q in:\n%s", expected, html)
+		}
+	}
+
+	// Modules that the root doesn't depend on are left out, and property
+	// values can't end the script.
+	for _, unexpected := range []string{`"name":"d"`, "</script>\""} {
+		if strings.Contains(html, unexpected) {
+			t.Errorf("unexpected %q in:\n%s", unexpected, html)
+		}
+	}
+
+	err := ctx.WriteModuleGraphHTML(&bytes.Buffer{}, "x")
+	if expected := `module "x" is not defined`; err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+// GroupModuleType is the name of the module type that every Context supports
+// for grouping other modules.  A group module creates a phony Ninja target
+// with the name of the module that builds all of the outputs of the modules
+// in its "deps" property, for example:
+//
+//	blueprint_group {
+//	    name: "tools",
+//	    deps: ["bpfmt", "bpmodify"],
+//	}
+//
+// allows "ninja tools" to build both binaries.  Groups may contain other
+// groups.  The phony target is not built by default.
+const GroupModuleType = "blueprint_group"
+
+type groupModule struct {
+	// inputs are the outputs of the modules in the group.  Modules are
+	// identified by their pointers, so groupModule must not be zero-sized.
+	inputs []*ninjaString
+}
+
+func newGroupModule() (Module, []interface{}) {
+	return &groupModule{}, nil
+}
+
+func (g *groupModule) GenerateBuildActions(ctx ModuleContext) {
+	mctx := ctx.(*moduleContext)
+
+	// Variants of the group share the phony target, so the last variant
+	// creates it for all of them.  Each variant depends on the earlier
+	// variants of its group (see updateDependencies), so the last one is only
+	// generated after the deps of every variant have been.
+	group := mctx.module.group
+	if mctx.module != group.modules[len(group.modules)-1] {
+		return
+	}
+
+	seen := make(map[*moduleInfo]bool)
+	for _, variant := range group.modules {
+		for _, dep := range variant.directDeps {
+			if seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			g.inputs = append(g.inputs, moduleOutputs(dep)...)
+		}
+	}
+
+	mctx.actionDefs.buildDefs = append(mctx.actionDefs.buildDefs, &buildDef{
+		Rule:     Phony,
+		Outputs:  []*ninjaString{simpleNinjaString(group.name)},
+		Inputs:   g.inputs,
+		Optional: true,
+	})
+}
+
+// moduleOutputs returns the outputs of the build statements of module.  The
+// module's local variables are replaced with their values, so that the
+// outputs can be used outside of the module's section of the Ninja file.
+func moduleOutputs(module *moduleInfo) []*ninjaString {
+	locals := make(map[Variable]*ninjaString)
+	for _, v := range module.actionDefs.variables {
+		locals[v] = v.value_
+	}
+
+	var outputs []*ninjaString
+	for _, def := range module.actionDefs.buildDefs {
+		for _, output := range def.Outputs {
+			outputs = append(outputs, output.substitute(locals))
+		}
+	}
+	return outputs
+}
@@ -0,0 +1,168 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// localOutModule writes its output to a directory in a module local variable.
+type localOutModule struct {
+	usageModule
+}
+
+func newLocalOutModule() (Module, []interface{}) {
+	m := &localOutModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (l *localOutModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Variable(usageTestPctx, "outDir", "gen/"+ctx.ModuleName())
+	ctx.Build(usageTestPctx, BuildParams{
+		Rule:    usageTestRule,
+		Outputs: []string{"$outDir/" + l.properties.Out},
+	})
+}
+
+func TestGroupModule(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("usage_module", newUsageModule)
+	ctx.RegisterModuleType("local_out_module", newLocalOutModule)
+
+	r := bytes.NewBufferString(`
+		usage_module {
+			name: "a",
+			out: "a.out",
+		}
+
+		local_out_module {
+			name: "b",
+			out: "b.out",
+		}
+
+		blueprint_group {
+			name: "ab",
+			deps: ["a", "b"],
+		}
+
+		blueprint_group {
+			name: "all",
+			deps: ["ab"],
+		}
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error writing build file: %s", err)
+	}
+
+	for _, expected := range []string{
+		"build ab: phony a.out gen/b/b.out\n",
+		"build all: phony ab\n",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expected %q in:\n%s", expected, buf.String())
+		}
+	}
+
+	if strings.Contains(buf.String(), "default ab") {
+		t.Errorf("expected group to not be built by default:\n%s", buf.String())
+	}
+}
+
+// variantOutModule writes its output to a directory for its variant.
+type variantOutModule struct {
+	usageModule
+}
+
+func newVariantOutModule() (Module, []interface{}) {
+	m := &variantOutModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (v *variantOutModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Build(usageTestPctx, BuildParams{
+		Rule:    usageTestRule,
+		Outputs: []string{ctx.ModuleSubDir() + "/" + v.properties.Out},
+	})
+}
+
+func TestGroupModuleVariants(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("variant_out_module", newVariantOutModule)
+	ctx.RegisterBottomUpMutator("arch", func(mctx BottomUpMutatorContext) {
+		mctx.CreateVariations("arm", "x86")
+	})
+
+	r := bytes.NewBufferString(`
+		variant_out_module {
+			name: "a",
+			out: "a.out",
+		}
+
+		variant_out_module {
+			name: "b",
+			out: "b.out",
+		}
+
+		blueprint_group {
+			name: "ab",
+			deps: ["a", "b"],
+		}
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error writing build file: %s", err)
+	}
+
+	expected := "build ab: phony arm/a.out arm/b.out x86/a.out x86/b.out\n"
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected %q in:\n%s", expected, buf.String())
+	}
+	if n := strings.Count(buf.String(), "build ab: phony"); n != 1 {
+		t.Errorf("expected 1 phony target for the group, got %d", n)
+	}
+}
@@ -27,6 +27,14 @@ type liveTracker struct {
 	variables map[Variable]*ninjaString
 	pools     map[Pool]*poolDef
 	rules     map[Rule]*ruleDef
+
+	// set if any build definition has validations, which need Ninja 1.11
+	validations bool
+
+	// outDir is the directory set by Context.SetOutDir, under which the files
+	// with the values of the volatile variables are written.
+	outDir         string
+	volatileValues map[*volatileVariable]string
 }
 
 func newLiveTracker(config interface{}) *liveTracker {
@@ -67,6 +75,14 @@ func (l *liveTracker) AddBuildDefDeps(def *buildDef) error {
 		return err
 	}
 
+	err = l.addNinjaStringListDeps(def.Validations)
+	if err != nil {
+		return err
+	}
+	if len(def.Validations) > 0 {
+		l.validations = true
+	}
+
 	for _, value := range def.Args {
 		err = l.addNinjaStringDeps(value)
 		if err != nil {
@@ -74,6 +90,8 @@ func (l *liveTracker) AddBuildDefDeps(def *buildDef) error {
 		}
 	}
 
+	l.addVolatileImplicits(def)
+
 	return nil
 }
 
@@ -130,6 +148,10 @@ func (l *liveTracker) addPool(p Pool) error {
 func (l *liveTracker) addVariable(v Variable) error {
 	_, ok := l.variables[v]
 	if !ok {
+		if volatile, ok := v.(*volatileVariable); ok {
+			return l.addVolatileVariable(volatile)
+		}
+
 		value, err := v.value(l.config)
 		if err == errVariableIsArg {
 			// This variable is a placeholder for an argument that can be passed
@@ -183,6 +205,17 @@ func (l *liveTracker) RemoveVariableIfLive(v Variable) bool {
 	return isLive
 }
 
+func (l *liveTracker) RemovePoolIfLive(p Pool) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	_, isLive := l.pools[p]
+	if isLive {
+		delete(l.pools, p)
+	}
+	return isLive
+}
+
 func (l *liveTracker) RemoveRuleIfLive(r Rule) bool {
 	l.Lock()
 	defer l.Unlock()
@@ -14,6 +14,97 @@
 
 package blueprint
 
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// A PackageNaming selects how the Go packages that define Ninja variables,
+// rules and pools are named in the Ninja file.  The names prefix every
+// package-scoped variable, rule and pool, so they account for much of the
+// size of large Ninja files.
+type PackageNaming int
+
+const (
+	// ShortPackageNames names each package after the last element of its
+	// path, e.g. "bootstrap", unless another package has the same last
+	// element, in which case both use FullPackageNames.
+	ShortPackageNames PackageNaming = iota
+
+	// FullPackageNames names each package after its full path, with "/"
+	// replaced by ".".  Preparing the build actions fails if two packages
+	// get the same name this way, e.g. "a/b.c" and "a.b/c".
+	FullPackageNames
+
+	// HashedPackageNames names each package with "p" followed by a prefix of
+	// the hash of its path, e.g. "p3fa2c1d0", that is lengthened until the
+	// names of all the packages are unique.  The header of the Ninja file
+	// lists the path of each package.
+	HashedPackageNames
+)
+
+// minPackageHashLen is the number of hex digits of the hash of a package path
+// that HashedPackageNames starts with.
+const minPackageHashLen = 8
+
+// hashedPackageNames returns the HashedPackageNames names of pctxs, using the
+// shortest hash prefix of at least minLen hex digits that is unique.
+func hashedPackageNames(pctxs []*PackageContext, minLen int) map[*PackageContext]string {
+	hashes := make([]string, len(pctxs))
+	for i, pctx := range pctxs {
+		sum := sha1.Sum([]byte(pctx.pkgPath))
+		hashes[i] = hex.EncodeToString(sum[:])
+	}
+
+	length := minLen
+	for ; length < sha1.Size*2; length++ {
+		prefixes := make(map[string]bool)
+		for _, hash := range hashes {
+			prefixes[hash[:length]] = true
+		}
+		if len(prefixes) == len(hashes) {
+			break
+		}
+	}
+
+	pkgNames := make(map[*PackageContext]string)
+	for i, pctx := range pctxs {
+		pkgNames[pctx] = "p" + hashes[i][:length]
+	}
+
+	return pkgNames
+}
+
+// duplicatePackageNames returns an error for each name in pkgNames that is
+// used by more than one package.  Full package names can collide, because
+// both "/" and "." in a package path become "." in its name.
+func duplicatePackageNames(pkgNames map[*PackageContext]string) []error {
+	pkgPaths := make(map[string][]string)
+	for pctx, name := range pkgNames {
+		pkgPaths[name] = append(pkgPaths[name], pctx.pkgPath)
+	}
+
+	var names []string
+	for name, paths := range pkgPaths {
+		if len(paths) > 1 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		paths := pkgPaths[name]
+		sort.Strings(paths)
+		errs = append(errs, fmt.Errorf("packages %q and %q have the same name %q",
+			paths[0], paths[1], name))
+	}
+
+	return errs
+}
+
 func packageNamespacePrefix(packageName string) string {
 	return "g." + packageName + "."
 }
@@ -0,0 +1,125 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestHashedPackageNames(t *testing.T) {
+	var pctxs []*PackageContext
+	for i := 0; i < 100; i++ {
+		pctxs = append(pctxs, &PackageContext{
+			pkgPath: fmt.Sprintf("example.com/pkg%d", i),
+		})
+	}
+
+	// With one hex digit to start with the hashes of 100 packages must
+	// collide, so the prefix has to be lengthened.
+	pkgNames := hashedPackageNames(pctxs, 1)
+
+	seen := make(map[string]bool)
+	length := len(pkgNames[pctxs[0]])
+	for _, pctx := range pctxs {
+		name := pkgNames[pctx]
+		if seen[name] {
+			t.Errorf("duplicate package name %q", name)
+		}
+		seen[name] = true
+
+		if !strings.HasPrefix(name, "p") || len(name) != length {
+			t.Errorf("package %q: unexpected name %q", pctx.pkgPath, name)
+		}
+	}
+
+	if length <= 2 {
+		t.Errorf("expected the hash prefix to be lengthened, got %q",
+			pkgNames[pctxs[0]])
+	}
+}
+
+func TestDuplicatePackageNames(t *testing.T) {
+	pkgNames := make(map[*PackageContext]string)
+	for _, pkgPath := range []string{"example.com/a/b.c", "example.com/a.b/c",
+		"example.com/a/b/c", "example.com/d"} {
+
+		pctx := &PackageContext{pkgPath: pkgPath, fullName: pkgPathToName(pkgPath)}
+		pkgNames[pctx] = pctx.fullName
+	}
+
+	errs := duplicatePackageNames(pkgNames)
+	expected := `packages "example.com/a.b/c" and "example.com/a/b.c" have the same ` +
+		`name "example.com.a.b.c"`
+	if len(errs) != 1 || errs[0].Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, errs)
+	}
+}
+
+func TestPackageNaming(t *testing.T) {
+	testCases := []struct {
+		naming   PackageNaming
+		expected string
+	}{
+		{ShortPackageNames, "usagetest"},
+		{FullPackageNames, "github.com.google.blueprint.usagetest"},
+		{HashedPackageNames, "p"},
+	}
+
+	for _, testCase := range testCases {
+		ctx := NewContext()
+		ctx.RegisterModuleType("usage_module", newUsageModule)
+		ctx.SetPackageNaming(testCase.naming)
+
+		r := bytes.NewBufferString(`
+			usage_module {
+				name: "a",
+				out: "a.out",
+			}
+		`)
+
+		modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+		if len(errs) == 0 {
+			errs = ctx.addModules(modules)
+		}
+		if len(errs) == 0 {
+			_, errs = ctx.PrepareBuildActions(nil)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		name := ctx.pkgNames[usageTestPctx]
+		if testCase.naming == HashedPackageNames {
+			if !strings.HasPrefix(name, "p") || len(name) != 1+minPackageHashLen {
+				t.Errorf("expected a hashed package name, got %q", name)
+			}
+		} else if name != testCase.expected {
+			t.Errorf("naming %d: expected package name %q, got %q",
+				testCase.naming, testCase.expected, name)
+		}
+
+		buf := &bytes.Buffer{}
+		if err := ctx.WriteBuildFile(buf); err != nil {
+			t.Fatalf("unexpected error writing build file: %s", err)
+		}
+		if !strings.Contains(buf.String(), "rule g."+name+".usedRule") {
+			t.Errorf("naming %d: expected rule g.%s.usedRule in:\n%s",
+				testCase.naming, name, buf.String())
+		}
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A ManifestWriter receives the generated build actions from
+// Context.WriteManifest.  All names are the fully qualified names that would
+// appear in the Ninja manifest, and all values are in Ninja syntax: variable
+// references are left unexpanded and paths are escaped as Ninja requires them.
+type ManifestWriter interface {
+	// Comment is called with free-form text describing the actions that
+	// follow it.
+	Comment(comment string) error
+
+	// Variable is called for each top-level variable assignment, including
+	// ninja_required_version and builddir.  A variable is always passed to
+	// Variable before any value that references it.
+	Variable(name, value string) error
+
+	// Pool is called for each pool definition.
+	Pool(pool *ManifestPool) error
+
+	// Rule is called for each rule definition.  A rule is always passed to
+	// Rule before any build that uses it.
+	Rule(rule *ManifestRule) error
+
+	// Build is called for each build statement.
+	Build(build *ManifestBuild) error
+
+	// BlankLine is called between groups of related definitions.  It is only
+	// a formatting hint, and writers that do not produce text may ignore it.
+	BlankLine() error
+}
+
+// A ManifestPool describes a Ninja pool definition.
+type ManifestPool struct {
+	Name    string
+	Comment string
+	Depth   int
+}
+
+// A ManifestRule describes a Ninja rule definition.
+type ManifestRule struct {
+	Name      string
+	Comment   string
+	Pool      string            // The name of the pool, or "" for no pool.
+	Variables map[string]string // The rule variables, e.g. "command".
+	Env       map[string]string // The only environment variables for the command.
+}
+
+// A ManifestBuild describes a Ninja build statement.
+type ManifestBuild struct {
+	Rule           string
+	Outputs        []string
+	SymlinkOutputs []string // The outputs that are symlinks, a subset of Outputs.
+	Inputs         []string
+	Implicits      []string
+	OrderOnly      []string
+	Validations    []string
+	Args           map[string]string
+	Optional       bool // Whether the outputs are left out of the default targets.
+}
+
+// ninjaManifestWriter is the ManifestWriter that produces Ninja manifest text.
+type ninjaManifestWriter struct {
+	nw *ninjaWriter
+
+	// symlinkOutputs is whether to write the symlink_outputs variable, which
+	// only some forks of Ninja understand.
+	symlinkOutputs bool
+}
+
+func newNinjaManifestWriter(w io.Writer, symlinkOutputs bool) *ninjaManifestWriter {
+	return &ninjaManifestWriter{
+		nw:             newNinjaWriter(w),
+		symlinkOutputs: symlinkOutputs,
+	}
+}
+
+// Flush writes the buffered manifest text to the underlying writer.
+func (m *ninjaManifestWriter) Flush() error {
+	return m.nw.Flush()
+}
+
+func (m *ninjaManifestWriter) Comment(comment string) error {
+	return m.nw.Comment(comment)
+}
+
+func (m *ninjaManifestWriter) Variable(name, value string) error {
+	return m.nw.Assign(name, value)
+}
+
+func (m *ninjaManifestWriter) Pool(pool *ManifestPool) error {
+	if pool.Comment != "" {
+		err := m.nw.Comment(pool.Comment)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := m.nw.Pool(pool.Name)
+	if err != nil {
+		return err
+	}
+
+	return m.nw.ScopedAssign("depth", strconv.Itoa(pool.Depth))
+}
+
+func (m *ninjaManifestWriter) Rule(rule *ManifestRule) error {
+	if rule.Comment != "" {
+		err := m.nw.Comment(rule.Comment)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := m.nw.Rule(rule.Name)
+	if err != nil {
+		return err
+	}
+
+	if rule.Pool != "" {
+		err = m.nw.ScopedAssign("pool", rule.Pool)
+		if err != nil {
+			return err
+		}
+	}
+
+	variables := rule.Variables
+	if len(rule.Env) > 0 {
+		variables = make(map[string]string)
+		for name, value := range rule.Variables {
+			variables[name] = value
+		}
+		variables["command"] = envCommand(rule.Env, rule.Variables["command"])
+	}
+
+	return m.writeScopedAssigns(variables)
+}
+
+func (m *ninjaManifestWriter) Build(build *ManifestBuild) error {
+	err := m.nw.Build(build.Rule, build.Outputs, build.Inputs, build.Implicits,
+		build.OrderOnly, build.Validations)
+	if err != nil {
+		return err
+	}
+
+	err = m.writeScopedAssigns(build.Args)
+	if err != nil {
+		return err
+	}
+
+	// symlink_outputs is not understood by upstream Ninja, which treats
+	// every output as a regular file, so it is only written when the Ninja
+	// being used has been declared to support it.
+	if m.symlinkOutputs && len(build.SymlinkOutputs) > 0 {
+		err = m.nw.ScopedAssign("symlink_outputs",
+			strings.Join(build.SymlinkOutputs, " "))
+		if err != nil {
+			return err
+		}
+	}
+
+	if !build.Optional {
+		return m.nw.Default(build.Outputs...)
+	}
+
+	return nil
+}
+
+// envCommand prefixes command with env so that it is run with only the
+// environment variables in env set.  The command is not quoted, since Ninja
+// expands the variables in it, shell-escaping the paths in $in and $out, after
+// it is written.
+func envCommand(env map[string]string, command string) string {
+	var names []string
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := bytes.NewBufferString("env -i")
+	for _, name := range names {
+		fmt.Fprintf(buf, " %s=%s", name, env[name])
+	}
+	fmt.Fprintf(buf, " %s", command)
+
+	return buf.String()
+}
+
+func (m *ninjaManifestWriter) BlankLine() error {
+	return m.nw.BlankLine()
+}
+
+func (m *ninjaManifestWriter) writeScopedAssigns(vars map[string]string) error {
+	var keys []string
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, name := range keys {
+		err := m.nw.ScopedAssign(name, vars[name])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
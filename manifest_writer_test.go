@@ -0,0 +1,234 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var ninjaManifestWriterTestCases = []struct {
+	input          func(w ManifestWriter)
+	symlinkOutputs bool
+	output         string
+}{
+	{
+		input: func(w ManifestWriter) {
+			ck(w.Pool(&ManifestPool{
+				Name:    "link",
+				Comment: "Limit links",
+				Depth:   2,
+			}))
+		},
+		output: "# Limit links\npool link\n    depth = 2\n",
+	},
+	{
+		input: func(w ManifestWriter) {
+			ck(w.Rule(&ManifestRule{
+				Name: "cc",
+				Pool: "link",
+				Variables: map[string]string{
+					"description": "CC $out",
+					"command":     "cc -c $in -o $out",
+				},
+			}))
+		},
+		output: "rule cc\n    pool = link\n    command = cc -c $in -o $out\n" +
+			"    description = CC $out\n",
+	},
+	{
+		input: func(w ManifestWriter) {
+			ck(w.Rule(&ManifestRule{
+				Name: "gen",
+				Variables: map[string]string{
+					"command": "echo 'hi' > $out",
+				},
+				Env: map[string]string{
+					"PATH": "/bin",
+					"LANG": "C",
+				},
+			}))
+		},
+		output: "rule gen\n    command = env -i LANG=C PATH=/bin echo 'hi' > $out\n",
+	},
+	{
+		input: func(w ManifestWriter) {
+			ck(w.Build(&ManifestBuild{
+				Rule:      "cc",
+				Outputs:   []string{"foo.o"},
+				Inputs:    []string{"foo.c"},
+				Implicits: []string{"foo.h"},
+				Args:      map[string]string{"cflags": "-O2"},
+			}))
+		},
+		output: "build foo.o: cc foo.c | foo.h\n    cflags = -O2\ndefault foo.o\n",
+	},
+	{
+		input: func(w ManifestWriter) {
+			ck(w.Build(&ManifestBuild{
+				Rule:     "cc",
+				Outputs:  []string{"foo.o"},
+				Inputs:   []string{"foo.c"},
+				Optional: true,
+			}))
+		},
+		output: "build foo.o: cc foo.c\n",
+	},
+	{
+		input: func(w ManifestWriter) {
+			ck(w.Build(&ManifestBuild{
+				Rule:           "ln",
+				Outputs:        []string{"foo.so", "foo.so.1"},
+				SymlinkOutputs: []string{"foo.so"},
+				Inputs:         []string{"foo.so.1.0"},
+			}))
+		},
+		symlinkOutputs: true,
+		output: "build foo.so foo.so.1: ln foo.so.1.0\n    symlink_outputs = foo.so\n" +
+			"default foo.so foo.so.1\n",
+	},
+	{
+		input: func(w ManifestWriter) {
+			ck(w.Build(&ManifestBuild{
+				Rule:           "ln",
+				Outputs:        []string{"foo.so", "foo.so.1"},
+				SymlinkOutputs: []string{"foo.so"},
+				Inputs:         []string{"foo.so.1.0"},
+			}))
+		},
+		output: "build foo.so foo.so.1: ln foo.so.1.0\ndefault foo.so foo.so.1\n",
+	},
+	{
+		input: func(w ManifestWriter) {
+			ck(w.Build(&ManifestBuild{
+				Rule:        "cc",
+				Outputs:     []string{"foo.o"},
+				Inputs:      []string{"foo.c"},
+				Validations: []string{"foo.lint"},
+				Optional:    true,
+			}))
+		},
+		output: "build foo.o: cc foo.c |@ foo.lint\n",
+	},
+}
+
+func TestNinjaManifestWriter(t *testing.T) {
+	for i, testCase := range ninjaManifestWriterTestCases {
+		buf := bytes.NewBuffer(nil)
+		w := newNinjaManifestWriter(buf, testCase.symlinkOutputs)
+		testCase.input(w)
+		ck(w.Flush())
+		if buf.String() != testCase.output {
+			t.Errorf("incorrect output for test case %d", i)
+			t.Errorf("  expected: %q", testCase.output)
+			t.Errorf("       got: %q", buf.String())
+		}
+	}
+}
+
+func TestEnvCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest_writer_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "env 'output'.txt")
+	command := envCommand(map[string]string{"LANG": "C", "GREETING": "hi"}, "env > $out")
+
+	// Ninja shell-escapes the paths in $out when it expands the command.
+	command = strings.Replace(command, "$out", shellEscape(out), -1)
+	output, err := exec.Command("/bin/sh", "-c", command).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %s\n%s", command, err, output)
+	}
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "GREETING=hi\nLANG=C\n"
+	if string(data) != expected {
+		t.Errorf("expected environment %q, got %q", expected, string(data))
+	}
+}
+
+func TestSymlinkOutputsNotInOutputs(t *testing.T) {
+	scope := newLocalScope(nil, "test.")
+	scope.ReparentTo(fakeTestPctx)
+
+	_, err := parseBuildParams(scope, &BuildParams{
+		Rule:           fakeTestCp,
+		Outputs:        []string{"foo.so.1"},
+		SymlinkOutputs: []string{"foo.so"},
+		Inputs:         []string{"foo.so.1.0"},
+	})
+	expected := `SymlinkOutputs param "foo.so" is not in Outputs`
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, got %v", expected, err)
+	}
+}
+
+type validatedModule struct{}
+
+func newValidatedModule() (Module, []interface{}) {
+	return &validatedModule{}, nil
+}
+
+func (v *validatedModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Build(fakeTestPctx, BuildParams{
+		Rule:        fakeTestCp,
+		Outputs:     []string{"out/" + ctx.ModuleName()},
+		Inputs:      []string{ctx.ModuleName()},
+		Validations: []string{ctx.ModuleName() + ".lint"},
+	})
+}
+
+func TestValidationsRequireNinjaVersion(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("validated_module", newValidatedModule)
+
+	r := bytes.NewBufferString(`validated_module { name: "a" }`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, expected := range []string{
+		"ninja_required_version = 1.11.0\n",
+		"build out/a: g.faketest.cp a |@ a.lint\n",
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(expected)) {
+			t.Errorf("expected %q in:\n%s", expected, buf)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"sort"
+)
+
+// BuildSizes counts the inputs and outputs declared by a set of build
+// statements.  Ninja spends most of its time loading a large Ninja file on
+// the paths of the inputs and outputs, so a few build statements with
+// thousands of inputs each can dominate it.
+type BuildSizes struct {
+	Builds int // The number of build statements.
+
+	Inputs      int // The number of explicit, implicit and order-only inputs.
+	InputBytes  int // The length of the inputs as written in the Ninja file.
+	Outputs     int // The number of outputs.
+	OutputBytes int // The length of the outputs as written in the Ninja file.
+
+	MaxInputs    int    // The largest number of inputs of a build statement.
+	LargestBuild string // The first output of the build statement with MaxInputs inputs.
+}
+
+func (s *BuildSizes) add(def *buildDef, pkgNames map[*PackageContext]string) {
+	s.Builds++
+
+	inputs := 0
+	for _, list := range [][]*ninjaString{def.Inputs, def.Implicits, def.OrderOnly} {
+		for _, input := range list {
+			s.InputBytes += len(input.Value(pkgNames))
+		}
+		inputs += len(list)
+	}
+	s.Inputs += inputs
+
+	for _, output := range def.Outputs {
+		s.OutputBytes += len(output.Value(pkgNames))
+	}
+	s.Outputs += len(def.Outputs)
+
+	if inputs > s.MaxInputs || s.Builds == 1 {
+		s.MaxInputs = inputs
+		s.LargestBuild = ""
+		if len(def.Outputs) > 0 {
+			s.LargestBuild = def.Outputs[0].Value(pkgNames)
+		}
+	}
+}
+
+// RuleMetrics are the BuildSizes of the build statements that use a rule.
+type RuleMetrics struct {
+	Rule string // The name of the rule in the Ninja file.
+	BuildSizes
+}
+
+// ModuleTypeMetrics are the BuildSizes of the build statements of the
+// modules of a type.
+type ModuleTypeMetrics struct {
+	ModuleType string
+	BuildSizes
+}
+
+// BuildMetrics are the sizes of the inputs and outputs of the build
+// statements in the Ninja file, by rule and by module type.  Each list is
+// sorted by InputBytes, largest first.
+type BuildMetrics struct {
+	Rules       []RuleMetrics
+	ModuleTypes []ModuleTypeMetrics
+}
+
+// BuildMetrics returns the BuildMetrics of the build statements of the
+// modules and singletons.  If this is called before PrepareBuildActions
+// successfully completes then ErrBuildActionsNotReady is returned.
+func (c *Context) BuildMetrics() (*BuildMetrics, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
+	}
+
+	rules := make(map[string]*BuildSizes)
+	moduleTypes := make(map[string]*BuildSizes)
+
+	addBuild := func(def *buildDef) {
+		name := def.Rule.fullName(c.pkgNames)
+		sizes, ok := rules[name]
+		if !ok {
+			sizes = &BuildSizes{}
+			rules[name] = sizes
+		}
+		sizes.add(def, c.pkgNames)
+	}
+
+	for _, name := range c.sortedModuleNames() {
+		for _, module := range c.moduleGroups[name].modules {
+			sizes, ok := moduleTypes[module.typeName]
+			if !ok {
+				sizes = &BuildSizes{}
+				moduleTypes[module.typeName] = sizes
+			}
+
+			for _, def := range module.actionDefs.buildDefs {
+				addBuild(def)
+				sizes.add(def, c.pkgNames)
+			}
+		}
+	}
+
+	var singletonNames []string
+	for name := range c.singletonInfo {
+		singletonNames = append(singletonNames, name)
+	}
+	sort.Strings(singletonNames)
+
+	for _, name := range singletonNames {
+		for _, def := range c.singletonInfo[name].actionDefs.buildDefs {
+			addBuild(def)
+		}
+	}
+
+	metrics := &BuildMetrics{}
+
+	for name, sizes := range rules {
+		metrics.Rules = append(metrics.Rules, RuleMetrics{name, *sizes})
+	}
+	sort.Sort(ruleMetricsSorter(metrics.Rules))
+
+	for name, sizes := range moduleTypes {
+		if sizes.Builds > 0 {
+			metrics.ModuleTypes = append(metrics.ModuleTypes, ModuleTypeMetrics{name, *sizes})
+		}
+	}
+	sort.Sort(moduleTypeMetricsSorter(metrics.ModuleTypes))
+
+	return metrics, nil
+}
+
+type ruleMetricsSorter []RuleMetrics
+
+func (s ruleMetricsSorter) Len() int { return len(s) }
+func (s ruleMetricsSorter) Less(i, j int) bool {
+	if s[i].InputBytes != s[j].InputBytes {
+		return s[i].InputBytes > s[j].InputBytes
+	}
+	return s[i].Rule < s[j].Rule
+}
+func (s ruleMetricsSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+type moduleTypeMetricsSorter []ModuleTypeMetrics
+
+func (s moduleTypeMetricsSorter) Len() int { return len(s) }
+func (s moduleTypeMetricsSorter) Less(i, j int) bool {
+	if s[i].InputBytes != s[j].InputBytes {
+		return s[i].InputBytes > s[j].InputBytes
+	}
+	return s[i].ModuleType < s[j].ModuleType
+}
+func (s moduleTypeMetricsSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,155 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var (
+	metricsTestPctx = NewPackageContext("github.com/google/blueprint/metricstest")
+
+	metricsTestCc = metricsTestPctx.StaticRule("cc",
+		RuleParams{
+			Command: "cc -c $in -o $out",
+		})
+	metricsTestAr = metricsTestPctx.StaticRule("ar",
+		RuleParams{
+			Command: "ar $out $in",
+		})
+)
+
+type metricsModule struct {
+	properties struct {
+		Srcs []string
+	}
+}
+
+func newMetricsModule() (Module, []interface{}) {
+	m := &metricsModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (m *metricsModule) GenerateBuildActions(ctx ModuleContext) {
+	var objs []string
+	for _, src := range m.properties.Srcs {
+		obj := src + ".o"
+		ctx.Build(metricsTestPctx, BuildParams{
+			Rule:      metricsTestCc,
+			Outputs:   []string{obj},
+			Inputs:    []string{src},
+			Implicits: []string{"cc"},
+		})
+		objs = append(objs, obj)
+	}
+
+	ctx.Build(metricsTestPctx, BuildParams{
+		Rule:    metricsTestAr,
+		Outputs: []string{ctx.ModuleName() + ".a"},
+		Inputs:  objs,
+	})
+}
+
+func TestBuildMetrics(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("metrics_module", newMetricsModule)
+
+	if _, err := ctx.BuildMetrics(); err != ErrBuildActionsNotReady {
+		t.Errorf("expected ErrBuildActionsNotReady before PrepareBuildActions, got %v", err)
+	}
+
+	r := bytes.NewBufferString(`
+		metrics_module {
+			name: "a",
+			srcs: ["a1.c", "a2.c"],
+		}
+
+		metrics_module {
+			name: "b",
+			srcs: ["b.c"],
+		}
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	metrics, err := ctx.BuildMetrics()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := &BuildMetrics{
+		Rules: []RuleMetrics{
+			{
+				Rule: "g.metricstest.ar",
+				BuildSizes: BuildSizes{
+					Builds:       2,
+					Inputs:       3,
+					InputBytes:   17,
+					Outputs:      2,
+					OutputBytes:  6,
+					MaxInputs:    2,
+					LargestBuild: "a.a",
+				},
+			},
+			{
+				Rule: "g.metricstest.cc",
+				BuildSizes: BuildSizes{
+					Builds:       3,
+					Inputs:       6,
+					InputBytes:   17,
+					Outputs:      3,
+					OutputBytes:  17,
+					MaxInputs:    2,
+					LargestBuild: "a1.c.o",
+				},
+			},
+		},
+		ModuleTypes: []ModuleTypeMetrics{
+			{
+				ModuleType: "metrics_module",
+				BuildSizes: BuildSizes{
+					Builds:       5,
+					Inputs:       9,
+					InputBytes:   34,
+					Outputs:      5,
+					OutputBytes:  23,
+					MaxInputs:    2,
+					LargestBuild: "a1.c.o",
+				},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(metrics, expected) {
+		t.Errorf("incorrect metrics")
+		t.Errorf("  expected: %#v", expected)
+		t.Errorf("       got: %#v", metrics)
+	}
+}
@@ -76,6 +76,9 @@ import (
 // to build the list of library file names that should be included in its link
 // command.
 //
+// Data can also be passed to dependant modules without interfaces using
+// providers, see NewProvider.
+//
 // GenerateBuildActions may be called from multiple threads.  It is guaranteed to
 // be called after it has finished being called on all dependencies and on all
 // variants of that appear earlier in the ModuleContext.VisitAllModuleVariants list.
@@ -104,16 +107,57 @@ type DynamicDependerModule interface {
 	DynamicDependencies(DynamicDependerModuleContext) []string
 }
 
+// The ErrorReporter, ActionEmitter and DepVisitor interfaces are the parts of
+// the module and singleton contexts that module logic typically needs.  They
+// are composed into the larger context interfaces, so that code that only
+// needs one of them can take it as its argument and be tested with a small
+// fake instead of a whole ModuleContext.
+
+// An ErrorReporter reports errors in the definition of a module.
+type ErrorReporter interface {
+	Errorf(pos scanner.Position, fmt string, args ...interface{})
+	ModuleErrorf(fmt string, args ...interface{})
+	PropertyErrorf(property, fmt string, args ...interface{})
+	Failed() bool
+}
+
+// An ActionEmitter adds Ninja variables, pools, rules and build statements to
+// the build actions of a module or singleton.
+type ActionEmitter interface {
+	Variable(pctx *PackageContext, name, value string)
+	Pool(pctx *PackageContext, name string, params PoolParams) Pool
+	Rule(pctx *PackageContext, name string, params RuleParams, argNames ...string) Rule
+	Build(pctx *PackageContext, params BuildParams)
+
+	AddNinjaFileDeps(deps ...string)
+}
+
+// A DepVisitor visits the dependencies of a module.
+type DepVisitor interface {
+	VisitDirectDeps(visit func(Module))
+	VisitDirectDepsIf(pred func(Module) bool, visit func(Module))
+	VisitDepsDepthFirst(visit func(Module))
+	VisitDepsDepthFirstIf(pred func(Module) bool, visit func(Module))
+}
+
 type BaseModuleContext interface {
+	ErrorReporter
+
 	ModuleName() string
 	ModuleDir() string
 	Config() interface{}
 
+	// Variation returns the name of the variation that the current module
+	// variant was created as by the mutator registered as mutatorName, or ""
+	// if that mutator hasn't split the module.
+	Variation(mutatorName string) string
+
+	// ConfigVariable returns the value of the configuration variable
+	// registered with Context.RegisterConfigVariable for the directory of the
+	// module.
+	ConfigVariable(name string) string
+
 	ContainsProperty(name string) bool
-	Errorf(pos scanner.Position, fmt string, args ...interface{})
-	ModuleErrorf(fmt string, args ...interface{})
-	PropertyErrorf(property, fmt string, args ...interface{})
-	Failed() bool
 }
 
 type DynamicDependerModuleContext interface {
@@ -125,26 +169,53 @@ type DynamicDependerModuleContext interface {
 
 type ModuleContext interface {
 	BaseModuleContext
+	ActionEmitter
+	DepVisitor
 
 	OtherModuleName(m Module) string
 	OtherModuleErrorf(m Module, fmt string, args ...interface{})
 
-	VisitDirectDeps(visit func(Module))
-	VisitDirectDepsIf(pred func(Module) bool, visit func(Module))
-	VisitDepsDepthFirst(visit func(Module))
-	VisitDepsDepthFirstIf(pred func(Module) bool, visit func(Module))
+	// SetProvider sets the value of provider for this module variant, which
+	// must have the type passed to NewProvider.  It may only be called once
+	// for each provider, and only during GenerateBuildActions.
+	SetProvider(provider ProviderKey, value interface{})
 
-	ModuleSubDir() string
+	// OtherModuleProvider returns the value of provider set by m, which must
+	// be a dependency of this module variant, and whether it was set.
+	OtherModuleProvider(m Module, provider ProviderKey) (interface{}, bool)
 
-	Variable(pctx *PackageContext, name, value string)
-	Rule(pctx *PackageContext, name string, params RuleParams, argNames ...string) Rule
-	Build(pctx *PackageContext, params BuildParams)
+	// VisitReverseDeps calls visit for each module that directly depends on
+	// this module variant.  The reverse dependencies generate their build
+	// actions after this module, so visit must not use anything that they
+	// set in GenerateBuildActions.
+	VisitReverseDeps(visit func(Module))
+	VisitReverseDepsIf(pred func(Module) bool, visit func(Module))
 
-	AddNinjaFileDeps(deps ...string)
+	ModuleSubDir() string
+
+	// IntermediatesDir returns a directory for intermediate files that is
+	// unique to this module variant, and GenDir returns a directory for
+	// generated sources that is unique to this module variant.  Both are laid
+	// out by module directory, name and variant under the directory set with
+	// Context.SetOutDir.
+	IntermediatesDir() string
+	GenDir() string
+
+	// Glob returns the files that match pattern but not any of excludes, using
+	// pathtools.GlobWithExcludes.  Patterns are relative to the working
+	// directory, so they usually start with ModuleDir().  The glob is recorded
+	// in Context.Globs so that the primary builder can regenerate the Ninja
+	// file when files that match it are added or removed.
+	Glob(pattern string, excludes []string) ([]string, error)
 
 	PrimaryModule() Module
 	FinalModule() Module
 	VisitAllModuleVariants(visit func(Module))
+
+	// GetMissingDependencies returns the names of the modules that this
+	// module depends on but that are not defined, which is only possible if
+	// Context.SetAllowMissingDependencies was called.
+	GetMissingDependencies() []string
 }
 
 var _ BaseModuleContext = (*baseModuleContext)(nil)
@@ -154,6 +225,9 @@ type baseModuleContext struct {
 	config  interface{}
 	module  *moduleInfo
 	errs    []error
+
+	// nil unless the calls are being recorded
+	callLog *CallLog
 }
 
 func (d *baseModuleContext) ModuleName() string {
@@ -173,9 +247,18 @@ func (d *baseModuleContext) Config() interface{} {
 	return d.config
 }
 
+func (d *baseModuleContext) Variation(mutatorName string) string {
+	return d.module.variant[mutatorName]
+}
+
+func (d *baseModuleContext) ConfigVariable(name string) string {
+	return d.context.ConfigVariable(d.ModuleDir(), name)
+}
+
 func (d *baseModuleContext) Errorf(pos scanner.Position,
 	format string, args ...interface{}) {
 
+	d.callLog.add("Errorf", pos.String(), fmt.Sprintf(format, args...))
 	d.errs = append(d.errs, &Error{
 		Err: fmt.Errorf(format, args...),
 		Pos: pos,
@@ -185,6 +268,7 @@ func (d *baseModuleContext) Errorf(pos scanner.Position,
 func (d *baseModuleContext) ModuleErrorf(format string,
 	args ...interface{}) {
 
+	d.callLog.add("ModuleErrorf", fmt.Sprintf(format, args...))
 	d.errs = append(d.errs, &Error{
 		Err: fmt.Errorf(format, args...),
 		Pos: d.module.pos,
@@ -199,6 +283,7 @@ func (d *baseModuleContext) PropertyErrorf(property, format string,
 		panic(fmt.Errorf("property %q was not set for this module", property))
 	}
 
+	d.callLog.add("PropertyErrorf", property, fmt.Sprintf(format, args...))
 	d.errs = append(d.errs, &Error{
 		Err: fmt.Errorf(format, args...),
 		Pos: pos,
@@ -213,9 +298,8 @@ var _ ModuleContext = (*moduleContext)(nil)
 
 type moduleContext struct {
 	baseModuleContext
-	scope         *localScope
+	localActions
 	ninjaFileDeps []string
-	actionDefs    localBuildActions
 }
 
 func (m *moduleContext) OtherModuleName(logicModule Module) string {
@@ -227,6 +311,7 @@ func (m *moduleContext) OtherModuleErrorf(logicModule Module, format string,
 	args ...interface{}) {
 
 	module := m.context.moduleInfo[logicModule]
+	m.callLog.add("OtherModuleErrorf", module.properties.Name, fmt.Sprintf(format, args...))
 	m.errs = append(m.errs, &Error{
 		Err: fmt.Errorf(format, args...),
 		Pos: module.pos,
@@ -251,49 +336,82 @@ func (m *moduleContext) VisitDepsDepthFirstIf(pred func(Module) bool,
 	m.context.visitDepsDepthFirstIf(m.module, pred, visit)
 }
 
+func (m *moduleContext) VisitReverseDeps(visit func(Module)) {
+	m.context.visitReverseDeps(m.module, visit)
+}
+
+func (m *moduleContext) VisitReverseDepsIf(pred func(Module) bool,
+	visit func(Module)) {
+
+	m.context.visitReverseDepsIf(m.module, pred, visit)
+}
+
 func (m *moduleContext) ModuleSubDir() string {
 	return m.module.variantName
 }
 
-func (m *moduleContext) Variable(pctx *PackageContext, name, value string) {
-	m.scope.ReparentTo(pctx)
+func (m *moduleContext) GetMissingDependencies() []string {
+	return m.module.missingDeps
+}
+
+func (m *moduleContext) IntermediatesDir() string {
+	return m.claimDir(".intermediates")
+}
+
+func (m *moduleContext) GenDir() string {
+	return m.claimDir("gen")
+}
+
+func (m *moduleContext) claimDir(kind string) string {
+	dir := filepath.Join(m.context.outDir, kind, m.ModuleDir(), m.ModuleName(),
+		m.ModuleSubDir())
 
-	v, err := m.scope.AddLocalVariable(name, value)
-	if err != nil {
-		panic(err)
+	owner := m.context.claimModuleDir(dir, m.module)
+	if owner != m.module {
+		m.ModuleErrorf("%s directory %q is also used by module %q variant %q",
+			kind, dir, owner.properties.Name, owner.variantName)
 	}
 
-	m.actionDefs.variables = append(m.actionDefs.variables, v)
+	return dir
 }
 
-func (m *moduleContext) Rule(pctx *PackageContext, name string,
-	params RuleParams, argNames ...string) Rule {
+func (m *moduleContext) Variable(pctx *PackageContext, name, value string) {
+	m.localActions.Variable(pctx, name, value)
+	m.callLog.addVariable(name, value)
+}
 
-	m.scope.ReparentTo(pctx)
+func (m *moduleContext) Pool(pctx *PackageContext, name string,
+	params PoolParams) Pool {
 
-	r, err := m.scope.AddLocalRule(name, &params, argNames...)
-	if err != nil {
-		panic(err)
-	}
+	p := m.localActions.Pool(pctx, name, params)
+	m.callLog.addPool(name, params)
+
+	return p
+}
+
+func (m *moduleContext) Rule(pctx *PackageContext, name string,
+	params RuleParams, argNames ...string) Rule {
 
-	m.actionDefs.rules = append(m.actionDefs.rules, r)
+	r := m.localActions.Rule(pctx, name, params, argNames...)
+	m.callLog.addRule(name, params, argNames)
 
 	return r
 }
 
 func (m *moduleContext) Build(pctx *PackageContext, params BuildParams) {
-	m.scope.ReparentTo(pctx)
-
-	def, err := parseBuildParams(m.scope, &params)
-	if err != nil {
-		panic(err)
-	}
-
-	m.actionDefs.buildDefs = append(m.actionDefs.buildDefs, def)
+	m.localActions.Build(pctx, params)
+	m.callLog.addBuild(params)
 }
 
 func (m *moduleContext) AddNinjaFileDeps(deps ...string) {
 	m.ninjaFileDeps = append(m.ninjaFileDeps, deps...)
+	m.callLog.add("AddNinjaFileDeps", deps...)
+}
+
+func (m *moduleContext) Glob(pattern string, excludes []string) ([]string, error) {
+	matches, err := m.context.glob(pattern, excludes)
+	m.callLog.addGlob(pattern, excludes, matches, err)
+	return matches, err
 }
 
 func (m *moduleContext) PrimaryModule() Module {
@@ -361,6 +479,7 @@ type mutatorContext struct {
 	baseModuleContext
 	name                 string
 	dependenciesModified bool
+	replacements         []replacement
 }
 
 type baseMutatorContext interface {
@@ -378,20 +497,19 @@ type EarlyMutatorContext interface {
 
 type TopDownMutatorContext interface {
 	baseMutatorContext
-
-	VisitDirectDeps(visit func(Module))
-	VisitDirectDepsIf(pred func(Module) bool, visit func(Module))
-	VisitDepsDepthFirst(visit func(Module))
-	VisitDepsDepthFirstIf(pred func(Module) bool, visit func(Module))
+	DepVisitor
 }
 
 type BottomUpMutatorContext interface {
 	baseMutatorContext
 
 	AddDependency(module Module, name string)
+	AddVariationDependencies([]Variation, ...string)
+	AddFarVariationDependencies([]Variation, ...string)
 	CreateVariations(...string) []Module
 	CreateLocalVariations(...string) []Module
 	SetDependencyVariation(string)
+	ReplaceDependencies(string)
 }
 
 // A Mutator function is called for each Module, and can use
@@ -476,6 +594,40 @@ func (mctx *mutatorContext) AddDependency(module Module, depName string) {
 	mctx.dependenciesModified = true
 }
 
+// AddVariationDependencies adds deps as dependencies of the current module
+// variant, like AddDependency, but uses the variations argument to select
+// which variant of the dependency to use.  A variant of the dependency must
+// exist that matches all of the non-local variations of the current module,
+// plus the variations argument.
+func (mctx *mutatorContext) AddVariationDependencies(variations []Variation,
+	deps ...string) {
+
+	for _, dep := range deps {
+		errs := mctx.context.addVariationDependency(mctx.module, variations, dep, false)
+		if len(errs) > 0 {
+			mctx.errs = append(mctx.errs, errs...)
+		}
+	}
+	mctx.dependenciesModified = true
+}
+
+// AddFarVariationDependencies adds deps as dependencies of the current module
+// variant, like AddVariationDependencies, but ignores the variations of the
+// current module, so that for example a module can depend on the host variant
+// of a tool.  A variant of the dependency must exist that matches the
+// variations argument, but it may also have other variations.
+func (mctx *mutatorContext) AddFarVariationDependencies(variations []Variation,
+	deps ...string) {
+
+	for _, dep := range deps {
+		errs := mctx.context.addVariationDependency(mctx.module, variations, dep, true)
+		if len(errs) > 0 {
+			mctx.errs = append(mctx.errs, errs...)
+		}
+	}
+	mctx.dependenciesModified = true
+}
+
 func (mctx *mutatorContext) VisitDirectDeps(visit func(Module)) {
 	mctx.context.visitDirectDeps(mctx.module, visit)
 }
@@ -0,0 +1,117 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"text/scanner"
+)
+
+var (
+	_ ErrorReporter = ModuleContext(nil)
+	_ ActionEmitter = ModuleContext(nil)
+	_ DepVisitor    = ModuleContext(nil)
+	_ ActionEmitter = SingletonContext(nil)
+	_ DepVisitor    = TopDownMutatorContext(nil)
+)
+
+var fakeTestPctx = NewPackageContext("github.com/google/blueprint/faketest")
+
+var fakeTestCp = fakeTestPctx.StaticRule("cp",
+	RuleParams{
+		Command: "cp $in $out",
+	})
+
+// copyFiles is module logic that only needs an ErrorReporter and an
+// ActionEmitter, so it can be tested without a Context.
+func copyFiles(errs ErrorReporter, actions ActionEmitter, srcs []string, dir string) {
+	for _, src := range srcs {
+		if src == "" {
+			errs.PropertyErrorf("srcs", "empty source file")
+			continue
+		}
+		actions.Build(fakeTestPctx, BuildParams{
+			Rule:    fakeTestCp,
+			Outputs: []string{dir + "/" + src},
+			Inputs:  []string{src},
+		})
+	}
+}
+
+type fakeErrorReporter struct {
+	errs []string
+}
+
+func (f *fakeErrorReporter) Errorf(pos scanner.Position, format string, args ...interface{}) {
+	f.errs = append(f.errs, pos.String()+": "+fmt.Sprintf(format, args...))
+}
+
+func (f *fakeErrorReporter) ModuleErrorf(format string, args ...interface{}) {
+	f.errs = append(f.errs, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeErrorReporter) PropertyErrorf(property, format string, args ...interface{}) {
+	f.errs = append(f.errs, property+": "+fmt.Sprintf(format, args...))
+}
+
+func (f *fakeErrorReporter) Failed() bool {
+	return len(f.errs) > 0
+}
+
+type fakeActionEmitter struct {
+	builds []BuildParams
+}
+
+func (f *fakeActionEmitter) Variable(pctx *PackageContext, name, value string) {}
+
+func (f *fakeActionEmitter) Pool(pctx *PackageContext, name string, params PoolParams) Pool {
+	return nil
+}
+
+func (f *fakeActionEmitter) Rule(pctx *PackageContext, name string, params RuleParams,
+	argNames ...string) Rule {
+
+	return nil
+}
+
+func (f *fakeActionEmitter) Build(pctx *PackageContext, params BuildParams) {
+	f.builds = append(f.builds, params)
+}
+
+func (f *fakeActionEmitter) AddNinjaFileDeps(deps ...string) {}
+
+func TestNarrowContextFakes(t *testing.T) {
+	errs := &fakeErrorReporter{}
+	actions := &fakeActionEmitter{}
+
+	copyFiles(errs, actions, []string{"a", "", "b"}, "out")
+
+	if expected := []string{"srcs: empty source file"}; !reflect.DeepEqual(errs.errs, expected) {
+		t.Errorf("expected errors %q, got %q", expected, errs.errs)
+	}
+
+	var outputs []string
+	for _, build := range actions.builds {
+		if build.Rule != fakeTestCp {
+			t.Errorf("expected rule %s, got %s", fakeTestCp, build.Rule)
+		}
+		outputs = append(outputs, build.Outputs...)
+	}
+	if expected := []string{"out/a", "out/b"}; !reflect.DeepEqual(outputs, expected) {
+		t.Errorf("expected outputs %q, got %q", expected, outputs)
+	}
+}
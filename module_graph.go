@@ -0,0 +1,243 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// A ModuleGraph is the resolved module graph, as written by
+// Context.WriteModuleGraphJSON.  It lets tools such as IDE integrations
+// inspect the modules without parsing the Blueprints files themselves.
+type ModuleGraph struct {
+	Modules []*ModuleGraphModule `json:"modules"`
+}
+
+// A ModuleGraphModule is a single variant of a module in a ModuleGraph.
+type ModuleGraphModule struct {
+	Name       string                 `json:"name"`
+	Type       string                 `json:"type"`
+	Dir        string                 `json:"dir"`
+	Blueprints string                 `json:"blueprints"` // The file that defines the module.
+	Variant    string                 `json:"variant,omitempty"`
+	Variations map[string]string      `json:"variations,omitempty"` // Mutator names to variations.
+	Properties map[string]interface{} `json:"properties"`           // As returned by Context.ModuleProperties.
+	Deps       []ModuleGraphDep       `json:"deps,omitempty"`
+	Outputs    []string               `json:"outputs,omitempty"` // Only set after PrepareBuildActions.
+}
+
+// A ModuleGraphDep is a dependency of a module in a ModuleGraph on a variant
+// of another module.
+type ModuleGraphDep struct {
+	Name    string `json:"name"`
+	Variant string `json:"variant,omitempty"`
+}
+
+// ModuleGraph returns every variant of every module, with its properties and
+// dependencies.  The modules are sorted by name, and the variants of a module
+// are in the order the mutators created them.  If this is called after
+// PrepareBuildActions the graph includes the changes made by mutators and the
+// outputs of the build actions of each module.
+func (c *Context) ModuleGraph() (*ModuleGraph, error) {
+	return c.moduleGraph(nil)
+}
+
+// moduleGraph returns the module graph limited to the modules for which
+// include returns true, or every module if include is nil.
+func (c *Context) moduleGraph(include func(*moduleInfo) bool) (*ModuleGraph, error) {
+	graph := &ModuleGraph{
+		Modules: []*ModuleGraphModule{},
+	}
+
+	var names []string
+	for name := range c.moduleGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, module := range c.moduleGroups[name].modules {
+			if include != nil && !include(module) {
+				continue
+			}
+
+			m := &ModuleGraphModule{
+				Name:       module.properties.Name,
+				Type:       module.typeName,
+				Dir:        filepath.Dir(module.relBlueprintsFile),
+				Blueprints: module.relBlueprintsFile,
+				Variant:    module.variantName,
+				Properties: c.ModuleProperties(module.logicModule),
+			}
+
+			if len(module.variant) > 0 {
+				m.Variations = module.variant.clone()
+			}
+
+			for _, dep := range module.directDeps {
+				m.Deps = append(m.Deps, ModuleGraphDep{
+					Name:    dep.properties.Name,
+					Variant: dep.variantName,
+				})
+			}
+
+			if c.buildActionsReady {
+				for _, output := range moduleOutputs(module) {
+					value, err := output.Eval(c.globalVariables)
+					if err != nil {
+						return nil, err
+					}
+					m.Outputs = append(m.Outputs, value)
+				}
+			}
+
+			graph.Modules = append(graph.Modules, m)
+		}
+	}
+
+	return graph, nil
+}
+
+// WriteModuleGraphJSON writes the graph returned by ModuleGraph to w as JSON.
+func (c *Context) WriteModuleGraphJSON(w io.Writer) error {
+	graph, err := c.ModuleGraph()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(graph, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// DotGraphOptions selects the part of the module graph that is written by
+// WriteDotGraph.  The graphs of large trees are too big for visualization
+// tools to be useful, so they are usually limited to the modules and
+// dependencies of interest.
+type DotGraphOptions struct {
+	// ModuleTypes limits the graph to the modules of the listed types, and
+	// the dependencies between them.  All the modules are included if it is
+	// empty.
+	ModuleTypes []string
+
+	// Dep limits the graph to the dependencies for which it returns true.
+	// Blueprint doesn't label the dependencies between modules, so a primary
+	// builder that tags them, for example as static or shared library
+	// dependencies, uses it to keep only the edges with some tag.  All the
+	// dependencies are included if it is nil.
+	Dep func(module, dep Module) bool
+
+	// CollapseDirs replaces the modules in each directory with a single node
+	// for the directory, which depends on another directory if any of its
+	// modules depend on one of the other directory's modules.
+	CollapseDirs bool
+}
+
+// WriteDotGraph writes the module graph, limited by opts, to w in the DOT
+// format read by Graphviz.  Each variant of a module is a separate node, and
+// an edge points from a module to each of its dependencies.  The nodes and
+// edges are sorted, so the same graph is always written the same way.
+func (c *Context) WriteDotGraph(w io.Writer, opts DotGraphOptions) error {
+	var types map[string]bool
+	if len(opts.ModuleTypes) > 0 {
+		types = make(map[string]bool)
+		for _, typ := range opts.ModuleTypes {
+			types[typ] = true
+		}
+	}
+	included := func(module *moduleInfo) bool {
+		return types == nil || types[module.typeName]
+	}
+
+	node := func(module *moduleInfo) string {
+		if opts.CollapseDirs {
+			return filepath.Dir(module.relBlueprintsFile)
+		}
+		if module.variantName != "" {
+			return module.properties.Name + " (" + module.variantName + ")"
+		}
+		return module.properties.Name
+	}
+
+	nodes := make(map[string]bool)
+	edges := make(map[[2]string]bool)
+
+	for _, module := range c.modulesSorted {
+		if !included(module) {
+			continue
+		}
+		from := node(module)
+		nodes[from] = true
+
+		for _, dep := range module.directDeps {
+			if !included(dep) {
+				continue
+			}
+			if opts.Dep != nil && !opts.Dep(module.logicModule, dep.logicModule) {
+				continue
+			}
+			to := node(dep)
+			if from != to {
+				edges[[2]string{from, to}] = true
+			}
+		}
+	}
+
+	sortedNodes := make([]string, 0, len(nodes))
+	for n := range nodes {
+		sortedNodes = append(sortedNodes, n)
+	}
+	sort.Strings(sortedNodes)
+
+	sortedEdges := make([][2]string, 0, len(edges))
+	for e := range edges {
+		sortedEdges = append(sortedEdges, e)
+	}
+	sort.Sort(dotEdgeSorter(sortedEdges))
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintln(buf, "digraph modules {")
+	for _, n := range sortedNodes {
+		fmt.Fprintf(buf, "\t%s;\n", strconv.Quote(n))
+	}
+	for _, e := range sortedEdges {
+		fmt.Fprintf(buf, "\t%s -> %s;\n", strconv.Quote(e[0]), strconv.Quote(e[1]))
+	}
+	fmt.Fprintln(buf, "}")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+type dotEdgeSorter [][2]string
+
+func (s dotEdgeSorter) Len() int      { return len(s) }
+func (s dotEdgeSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s dotEdgeSorter) Less(i, j int) bool {
+	if s[i][0] != s[j][0] {
+		return s[i][0] < s[j][0]
+	}
+	return s[i][1] < s[j][1]
+}
@@ -0,0 +1,240 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestWriteModuleGraphJSON(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("variation_module", newVariationModule)
+	ctx.RegisterBottomUpMutator("arch", archMutator)
+
+	r := bytes.NewBufferString(`
+		variation_module { name: "b", split: true, deps: ["a"] }
+		variation_module { name: "a", split: true }
+		variation_module { name: "c" }
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "dir/Blueprints", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteModuleGraphJSON(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var graph ModuleGraph
+	if err := json.Unmarshal(buf.Bytes(), &graph); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []*ModuleGraphModule{
+		{
+			Name:       "a",
+			Type:       "variation_module",
+			Dir:        "dir",
+			Blueprints: "dir/Blueprints",
+			Variant:    "arm",
+			Variations: map[string]string{"arch": "arm"},
+			Properties: map[string]interface{}{"name": "a", "deps": []interface{}{}, "enabled": true, "visibility": []interface{}{}, "overrides": []interface{}{}, "test_for": "", "test_suites": []interface{}{}, "split": true},
+		},
+		{
+			Name:       "a",
+			Type:       "variation_module",
+			Dir:        "dir",
+			Blueprints: "dir/Blueprints",
+			Variant:    "x86",
+			Variations: map[string]string{"arch": "x86"},
+			Properties: map[string]interface{}{"name": "a", "deps": []interface{}{}, "enabled": true, "visibility": []interface{}{}, "overrides": []interface{}{}, "test_for": "", "test_suites": []interface{}{}, "split": true},
+		},
+		{
+			Name:       "b",
+			Type:       "variation_module",
+			Dir:        "dir",
+			Blueprints: "dir/Blueprints",
+			Variant:    "arm",
+			Variations: map[string]string{"arch": "arm"},
+			Properties: map[string]interface{}{"name": "b", "deps": []interface{}{"a"}, "enabled": true, "visibility": []interface{}{}, "overrides": []interface{}{}, "test_for": "", "test_suites": []interface{}{}, "split": true},
+			Deps:       []ModuleGraphDep{{Name: "a", Variant: "arm"}},
+		},
+		{
+			Name:       "b",
+			Type:       "variation_module",
+			Dir:        "dir",
+			Blueprints: "dir/Blueprints",
+			Variant:    "x86",
+			Variations: map[string]string{"arch": "x86"},
+			Properties: map[string]interface{}{"name": "b", "deps": []interface{}{"a"}, "enabled": true, "visibility": []interface{}{}, "overrides": []interface{}{}, "test_for": "", "test_suites": []interface{}{}, "split": true},
+			Deps:       []ModuleGraphDep{{Name: "a", Variant: "x86"}},
+		},
+		{
+			Name:       "c",
+			Type:       "variation_module",
+			Dir:        "dir",
+			Blueprints: "dir/Blueprints",
+			Properties: map[string]interface{}{"name": "c", "deps": []interface{}{}, "enabled": true, "visibility": []interface{}{}, "overrides": []interface{}{}, "test_for": "", "test_suites": []interface{}{}, "split": false},
+		},
+	}
+
+	if !reflect.DeepEqual(graph.Modules, expected) {
+		t.Errorf("incorrect module graph:\n%s", buf.String())
+	}
+}
+
+func TestModuleGraphOutputs(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("local_out_module", newLocalOutModule)
+
+	r := bytes.NewBufferString(`
+		local_out_module {
+			name: "a",
+			out: "a.out",
+		}
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	graph, err := ctx.ModuleGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outputs := graph.Modules[0].Outputs; outputs != nil {
+		t.Errorf("expected no outputs before PrepareBuildActions, got %q", outputs)
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	graph, err = ctx.ModuleGraph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"gen/a/a.out"}
+	if outputs := graph.Modules[0].Outputs; !reflect.DeepEqual(outputs, expected) {
+		t.Errorf("expected outputs %q, got %q", expected, outputs)
+	}
+}
+
+func TestWriteDotGraph(t *testing.T) {
+	ctx, errs := prepareTestFiles(func(ctx *Context) {
+		ctx.RegisterModuleType("variation_module", newVariationModule)
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterBottomUpMutator("arch", archMutator)
+	}, []blueprintsTestFile{
+		{"dir/Blueprints", `
+			variation_module { name: "b", split: true, deps: ["a", "c"] }
+			variation_module { name: "a", split: true, deps: ["d"] }
+		`},
+		{"other/Blueprints", `
+			variation_module { name: "c", deps: ["d"] }
+			variation_module { name: "d" }
+			foo_module { name: "e" }
+		`},
+	})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	testCases := []struct {
+		opts     DotGraphOptions
+		expected string
+	}{
+		{
+			opts: DotGraphOptions{},
+			expected: `digraph modules {
+	"a (arm)";
+	"a (x86)";
+	"b (arm)";
+	"b (x86)";
+	"c";
+	"d";
+	"e";
+	"a (arm)" -> "d";
+	"a (x86)" -> "d";
+	"b (arm)" -> "a (arm)";
+	"b (arm)" -> "c";
+	"b (x86)" -> "a (x86)";
+	"b (x86)" -> "c";
+	"c" -> "d";
+}
+`,
+		},
+		{
+			opts: DotGraphOptions{
+				ModuleTypes: []string{"variation_module"},
+				Dep: func(module, dep Module) bool {
+					return dep.(*variationModule).properties.Split
+				},
+			},
+			expected: `digraph modules {
+	"a (arm)";
+	"a (x86)";
+	"b (arm)";
+	"b (x86)";
+	"c";
+	"d";
+	"b (arm)" -> "a (arm)";
+	"b (x86)" -> "a (x86)";
+}
+`,
+		},
+		{
+			opts: DotGraphOptions{CollapseDirs: true},
+			expected: `digraph modules {
+	"dir";
+	"other";
+	"dir" -> "other";
+}
+`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		buf := &bytes.Buffer{}
+		if err := ctx.WriteDotGraph(buf, testCase.opts); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != testCase.expected {
+			t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, buf.String())
+		}
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// An OutputFileProducer is a module whose outputs other modules may refer to in
+// their properties instead of hardcoding their paths.  The string and []string
+// property fields that are tagged blueprint:"path" may contain module
+// references of the form ":<module name>", for the default outputs of the
+// module, or ":<module name>{<tag>}", for the outputs with the given tag, for
+// example:
+//
+//	my_binary {
+//	    name: "app",
+//	    srcs: ["main.c", ":gen_sources"],
+//	    map_file: ":linker{.map}",
+//	}
+//
+// The module may also be named as "//<namespace dir>:<module name>".  The
+// Context adds a dependency on each referenced module while resolving
+// dependencies, and replaces the references with the outputs just before the
+// referencing module's GenerateBuildActions method is called.  A reference in
+// a string field must resolve to exactly one output.
+type OutputFileProducer interface {
+	// OutputFiles returns the paths of the outputs with the given tag, for
+	// example ".map", or of the default outputs if tag is "".  It is called
+	// after the module's GenerateBuildActions method.
+	OutputFiles(tag string) ([]string, error)
+}
+
+// parseModuleReference returns the module name and the output tag of a module
+// reference, and whether s is one.  Strings that start with ':', or with "//"
+// and contain a ':', are module references.
+func parseModuleReference(s string) (name, tag string, ok bool, err error) {
+	switch {
+	case strings.HasPrefix(s, ":"):
+		name = s[1:]
+	case strings.HasPrefix(s, "//") && strings.Contains(s, ":"):
+		name = s
+	default:
+		return "", "", false, nil
+	}
+
+	if i := strings.IndexByte(name, '{'); i >= 0 {
+		if !strings.HasSuffix(name, "}") || i == len(name)-2 {
+			return "", "", true, fmt.Errorf("invalid output tag in module reference %q", s)
+		}
+		name, tag = name[:i], name[i+1:len(name)-1]
+	}
+
+	if name == "" || strings.HasSuffix(name, ":") || strings.ContainsAny(name, "{}") {
+		return "", "", true, fmt.Errorf("invalid module reference %q", s)
+	}
+
+	return name, tag, true, nil
+}
+
+// A pathProperty is a string or []string property field tagged
+// blueprint:"path", which may contain module references.
+type pathProperty struct {
+	name  string
+	value reflect.Value
+}
+
+// strings returns the values of the property.
+func (p pathProperty) strings() []string {
+	if p.value.Kind() == reflect.String {
+		return []string{p.value.String()}
+	}
+	values := make([]string, p.value.Len())
+	for i := range values {
+		values[i] = p.value.Index(i).String()
+	}
+	return values
+}
+
+// pathProperties returns the fields of the property structs of module that
+// are tagged blueprint:"path".
+func pathProperties(module *moduleInfo) []pathProperty {
+	var properties []pathProperty
+
+	var walk func(namePrefix string, structValue reflect.Value)
+	walk = func(namePrefix string, structValue reflect.Value) {
+		structType := structValue.Type()
+		for i := 0; i < structValue.NumField(); i++ {
+			field := structType.Field(i)
+			fieldValue := structValue.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+
+			name := namePrefix + proptools.PropertyNameForField(field.Name)
+
+			switch fieldValue.Kind() {
+			case reflect.String, reflect.Slice:
+				if hasTag(field, "blueprint", "path") {
+					properties = append(properties, pathProperty{name, fieldValue})
+				}
+			case reflect.Interface, reflect.Ptr:
+				if fieldValue.IsNil() {
+					continue
+				}
+				fieldValue = fieldValue.Elem()
+				if fieldValue.Kind() == reflect.Ptr {
+					fieldValue = fieldValue.Elem()
+				}
+				if fieldValue.Kind() == reflect.Struct {
+					walk(name+".", fieldValue)
+				}
+			case reflect.Struct:
+				walk(name+".", fieldValue)
+			}
+		}
+	}
+
+	for _, p := range module.moduleProperties {
+		walk("", reflect.ValueOf(p).Elem())
+	}
+
+	return properties
+}
+
+// addModuleReferenceDependencies adds a dependency on each module referenced by
+// the path properties of module.
+func (c *Context) addModuleReferenceDependencies(module *moduleInfo) (errs []error) {
+	for _, property := range pathProperties(module) {
+		pos := module.propertyPos[property.name]
+		for _, s := range property.strings() {
+			name, _, ok, err := parseModuleReference(s)
+			if err != nil {
+				errs = append(errs, &Error{Err: err, Pos: pos})
+				continue
+			}
+			if !ok {
+				continue
+			}
+
+			depInfo, err := c.lookupModuleGroup(module, name)
+			if err != nil {
+				errs = append(errs, &Error{Err: err, Pos: pos})
+				continue
+			}
+			if depInfo == nil {
+				if c.allowMissingDependencies || !module.properties.Enabled {
+					errs = append(errs, c.missingDependency(module, name)...)
+				} else {
+					errs = append(errs, &Error{
+						Err: fmt.Errorf("%q refers to undefined module %q in property %q",
+							module.properties.Name, name, property.name),
+						Pos: pos,
+					})
+				}
+				continue
+			}
+
+			errs = append(errs, c.addDependency(module, name)...)
+		}
+	}
+
+	return errs
+}
+
+// resolveModuleReferences replaces the module references in the path
+// properties of module with the outputs of the referenced modules.  References
+// to missing modules are left alone, so that the module can report them.
+func (c *Context) resolveModuleReferences(module *moduleInfo) (errs []error) {
+	for _, property := range pathProperties(module) {
+		pos := module.propertyPos[property.name]
+		values := property.strings()
+
+		var resolved []string
+		changed, failed := false, false
+		for _, s := range values {
+			name, tag, ok, err := parseModuleReference(s)
+			if err != nil || !ok {
+				resolved = append(resolved, s)
+				continue
+			}
+
+			outputs, err := c.moduleReferenceOutputs(module, name, tag)
+			if err != nil {
+				errs = append(errs, &Error{
+					Err: fmt.Errorf("property %q: %s", property.name, err),
+					Pos: pos,
+				})
+				failed = true
+				continue
+			}
+			if outputs == nil {
+				resolved = append(resolved, s)
+				continue
+			}
+			resolved = append(resolved, outputs...)
+			changed = true
+		}
+
+		if failed || !changed {
+			continue
+		}
+
+		if property.value.Kind() == reflect.String {
+			if len(resolved) != 1 {
+				errs = append(errs, &Error{
+					Err: fmt.Errorf("property %q: module reference %q must resolve to "+
+						"one output, got %d", property.name, values[0], len(resolved)),
+					Pos: pos,
+				})
+				continue
+			}
+			property.value.SetString(resolved[0])
+		} else {
+			slice := reflect.MakeSlice(property.value.Type(), len(resolved), len(resolved))
+			for i, s := range resolved {
+				slice.Index(i).SetString(s)
+			}
+			property.value.Set(slice)
+		}
+	}
+
+	return errs
+}
+
+// moduleReferenceOutputs returns the outputs of the dependency of module named
+// name with the given tag, or nil if the dependency is missing.
+func (c *Context) moduleReferenceOutputs(module *moduleInfo, name,
+	tag string) ([]string, error) {
+
+	depInfo, err := c.lookupModuleGroup(module, name)
+	if err != nil {
+		return nil, err
+	}
+	if depInfo == nil {
+		return nil, nil
+	}
+
+	for _, dep := range module.directDeps {
+		if dep.group != depInfo {
+			continue
+		}
+
+		producer, ok := dep.logicModule.(OutputFileProducer)
+		if !ok {
+			return nil, fmt.Errorf("module %q does not produce output files", name)
+		}
+
+		outputs, err := producer.OutputFiles(tag)
+		if err != nil {
+			return nil, fmt.Errorf("module %q: %s", name, err)
+		}
+		return append([]string{}, outputs...), nil
+	}
+
+	// This shouldn't happen, since addModuleReferenceDependencies added the
+	// dependency.
+	return nil, fmt.Errorf("module %q is not a dependency", name)
+}
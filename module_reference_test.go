@@ -0,0 +1,184 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type refProducerModule struct {
+	properties struct {
+		Outs []string
+		Map  string
+	}
+}
+
+func newRefProducerModule() (Module, []interface{}) {
+	m := &refProducerModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (m *refProducerModule) GenerateBuildActions(ctx ModuleContext) {
+}
+
+func (m *refProducerModule) OutputFiles(tag string) ([]string, error) {
+	switch tag {
+	case "":
+		return m.properties.Outs, nil
+	case ".map":
+		return []string{m.properties.Map}, nil
+	default:
+		return nil, fmt.Errorf("unsupported output tag %q", tag)
+	}
+}
+
+type refConsumerModule struct {
+	properties struct {
+		Srcs     []string `blueprint:"path"`
+		Map_file string   `blueprint:"path"`
+		Flags    []string
+		Target   struct {
+			Srcs []string `blueprint:"path"`
+		}
+	}
+}
+
+func newRefConsumerModule() (Module, []interface{}) {
+	m := &refConsumerModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (m *refConsumerModule) GenerateBuildActions(ctx ModuleContext) {
+}
+
+func runModuleReferenceTest(bp string) (*Context, []error) {
+	return prepareTestFiles(func(ctx *Context) {
+		ctx.RegisterModuleType("producer", newRefProducerModule)
+		ctx.RegisterModuleType("consumer", newRefConsumerModule)
+		ctx.RegisterModuleType("plain", newVerifyModule)
+	}, []blueprintsTestFile{{"Blueprint", bp}})
+}
+
+func TestModuleReferences(t *testing.T) {
+	ctx, errs := runModuleReferenceTest(`
+		producer { name: "gen", outs: ["gen/a.c", "gen/b.c"], map: "gen/gen.map" }
+		consumer {
+			name: "app",
+			srcs: ["main.c", ":gen"],
+			map_file: ":gen{.map}",
+			flags: [":gen"],
+			target: { srcs: [":gen{.map}"] },
+		}
+	`)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	app := ctx.moduleGroups["app"].modules[0]
+	m := app.logicModule.(*refConsumerModule)
+
+	if expected := []string{"main.c", "gen/a.c", "gen/b.c"}; !reflect.DeepEqual(m.properties.Srcs, expected) {
+		t.Errorf("expected srcs %q, got %q", expected, m.properties.Srcs)
+	}
+	if expected := "gen/gen.map"; m.properties.Map_file != expected {
+		t.Errorf("expected map_file %q, got %q", expected, m.properties.Map_file)
+	}
+	if expected := []string{":gen"}; !reflect.DeepEqual(m.properties.Flags, expected) {
+		t.Errorf("expected untagged flags %q, got %q", expected, m.properties.Flags)
+	}
+	if expected := []string{"gen/gen.map"}; !reflect.DeepEqual(m.properties.Target.Srcs, expected) {
+		t.Errorf("expected target.srcs %q, got %q", expected, m.properties.Target.Srcs)
+	}
+
+	if len(app.directDeps) != 1 || app.directDeps[0].properties.Name != "gen" {
+		t.Errorf("expected app to depend on gen, got %d dependencies", len(app.directDeps))
+	}
+}
+
+func TestModuleReferenceErrors(t *testing.T) {
+	testCases := []struct {
+		bp  string
+		err string
+	}{
+		{
+			bp:  `consumer { name: "app", srcs: [":missing"] }`,
+			err: `Blueprint:1:29: "app" refers to undefined module "missing" in property "srcs"`,
+		},
+		{
+			bp:  `consumer { name: "app", srcs: [":gen{.map"] }`,
+			err: `Blueprint:1:29: invalid output tag in module reference ":gen{.map"`,
+		},
+		{
+			bp: `
+				plain { name: "gen", outs: ["$outDir/gen"] }
+				consumer { name: "app", srcs: [":gen"] }
+			`,
+			err: `Blueprint:3:33: property "srcs": module "gen" does not produce output files`,
+		},
+		{
+			bp: `
+				producer { name: "gen", outs: ["a.c", "b.c"] }
+				consumer { name: "app", map_file: ":gen" }
+			`,
+			err: `Blueprint:3:37: property "map_file": module reference ":gen" must resolve to one output, got 2`,
+		},
+		{
+			bp: `
+				producer { name: "gen" }
+				consumer { name: "app", srcs: [":gen{.h}"] }
+			`,
+			err: `Blueprint:3:33: property "srcs": module "gen": unsupported output tag ".h"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, errs := runModuleReferenceTest(testCase.bp)
+		if len(errs) != 1 || errs[0].Error() != testCase.err {
+			t.Errorf("for %s\nexpected error %q, got %v", testCase.bp, testCase.err, errs)
+		}
+	}
+}
+
+func TestParseModuleReference(t *testing.T) {
+	testCases := []struct {
+		s         string
+		name, tag string
+		ok        bool
+		err       bool
+	}{
+		{s: "a.c"},
+		{s: "//not/a/reference"},
+		{s: ":gen", name: "gen", ok: true},
+		{s: ":gen{.map}", name: "gen", tag: ".map", ok: true},
+		{s: "//vendor/lib:gen{.h}", name: "//vendor/lib:gen", tag: ".h", ok: true},
+		{s: ":", ok: true, err: true},
+		{s: ":gen{}", ok: true, err: true},
+		{s: ":gen{.map}x", ok: true, err: true},
+		{s: "//vendor/lib:", ok: true, err: true},
+	}
+
+	for _, testCase := range testCases {
+		name, tag, ok, err := parseModuleReference(testCase.s)
+		if name != testCase.name || tag != testCase.tag || ok != testCase.ok ||
+			(err != nil) != testCase.err {
+
+			t.Errorf("parseModuleReference(%q): expected %q, %q, %v, error %v, got %q, %q, %v, %v",
+				testCase.s, testCase.name, testCase.tag, testCase.ok, testCase.err,
+				name, tag, ok, err)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// NamespaceModuleType is the name of the module type that every Context
+// supports for declaring namespaces.  A namespace module makes the directory
+// of its Blueprints file and its subdirectories, up to any nested namespaces,
+// a namespace with its own module names, for example:
+//
+//	blueprint_namespace {
+//	    imports: ["//vendor/common"],
+//	    exports: ["utils"],
+//	}
+//
+// The modules in a namespace may only be depended on by name from within the
+// namespace, or from the namespaces that import it.  A dependency is looked up
+// in the namespace of the depending module first, then in the namespaces that
+// it imports, and then among the modules that aren't in any namespace, which
+// may be depended on from everywhere.  A dependency may also name a module in
+// any namespace as "//<namespace dir>:<module name>".  If the exports property
+// is set, only the modules that it lists may be depended on from outside the
+// namespace.
+const NamespaceModuleType = "blueprint_namespace"
+
+type namespaceModule struct {
+	properties struct {
+		// Imports lists the namespaces, as "//<namespace dir>", whose
+		// modules may be depended on by name.
+		Imports []string
+
+		// Exports lists the modules that may be depended on from outside
+		// the namespace.  All of them may if it isn't set.
+		Exports []string
+	}
+}
+
+func newNamespaceModule() (Module, []interface{}) {
+	m := &namespaceModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (n *namespaceModule) GenerateBuildActions(ModuleContext) {
+}
+
+type namespace struct {
+	// dir is the directory of the namespace relative to the root Blueprints
+	// file, or "." for the root directory.
+	dir    string
+	module *moduleInfo
+
+	// set by checkNamespaces
+	imports []*namespace
+}
+
+// name returns the name of the namespace, which is used in the qualified
+// names of its modules.
+func (ns *namespace) name() string {
+	if ns.dir == "." {
+		return "//"
+	}
+	return "//" + filepath.ToSlash(ns.dir)
+}
+
+// key returns the key of the module group named name in ns in
+// Context.moduleGroups.  The modules that aren't in a namespace, for which ns
+// is nil, are keyed by their names.
+func (ns *namespace) key(name string) string {
+	if ns == nil {
+		return name
+	}
+	return ns.name() + ":" + name
+}
+
+func (ns *namespace) exported(name string) bool {
+	if _, ok := ns.module.propertyPos["exports"]; !ok {
+		return true
+	}
+	for _, export := range ns.module.logicModule.(*namespaceModule).properties.Exports {
+		if export == name {
+			return true
+		}
+	}
+	return false
+}
+
+// addNamespace adds the namespace declared by the namespace module.
+func (c *Context) addNamespace(module *moduleInfo) []error {
+	dir := filepath.Dir(module.relBlueprintsFile)
+
+	if c.namespaces == nil {
+		c.namespaces = make(map[string]*namespace)
+	}
+
+	if first, ok := c.namespaces[dir]; ok {
+		return []error{
+			&Error{
+				Err: fmt.Errorf("namespace %q already defined", first.name()),
+				Pos: module.pos,
+			},
+			&Error{
+				Err: fmt.Errorf("<-- previous definition here"),
+				Pos: first.module.pos,
+			},
+		}
+	}
+
+	c.namespaces[dir] = &namespace{
+		dir:    dir,
+		module: module,
+	}
+
+	return nil
+}
+
+// moduleNamespace returns the innermost namespace that contains
+// relBlueprintsFile, or nil if it isn't in a namespace.
+func (c *Context) moduleNamespace(relBlueprintsFile string) *namespace {
+	if len(c.namespaces) == 0 {
+		return nil
+	}
+
+	dir := filepath.Dir(relBlueprintsFile)
+	for {
+		if ns, ok := c.namespaces[dir]; ok {
+			return ns
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+// checkNamespaces resolves the imports of the namespaces, and checks that
+// they export modules that they contain.
+func (c *Context) checkNamespaces() (errs []error) {
+	var dirs []string
+	for dir := range c.namespaces {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		ns := c.namespaces[dir]
+		properties := ns.module.logicModule.(*namespaceModule).properties
+
+		ns.imports = nil
+		for _, name := range properties.Imports {
+			imported := c.namespaces[namespaceDir(name)]
+			if !strings.HasPrefix(name, "//") || imported == nil {
+				errs = append(errs, &Error{
+					Err: fmt.Errorf("namespace %q imports undefined namespace %q",
+						ns.name(), name),
+					Pos: ns.module.propertyPos["imports"],
+				})
+				continue
+			}
+			ns.imports = append(ns.imports, imported)
+		}
+
+		for _, name := range properties.Exports {
+			_, isModule := c.moduleGroups[ns.key(name)]
+			_, isAlias := c.moduleAliases[ns.key(name)]
+			if !isModule && !isAlias {
+				errs = append(errs, &Error{
+					Err: fmt.Errorf("namespace %q exports undefined module %q",
+						ns.name(), name),
+					Pos: ns.module.propertyPos["exports"],
+				})
+			}
+		}
+	}
+
+	return errs
+}
+
+// namespaceDir returns the directory of the namespace with the given name.
+func namespaceDir(name string) string {
+	dir := strings.TrimPrefix(name, "//")
+	if dir == "" {
+		return "."
+	}
+	return filepath.Clean(filepath.FromSlash(dir))
+}
+
+// lookupModuleGroup returns the group of the module that module refers to as
+// depName, or nil if there isn't one.  It returns an error if the module
+// exists but module may not depend on it.  If depName is an alias, it returns
+// the group of the module that the alias refers to.
+func (c *Context) lookupModuleGroup(module *moduleInfo, depName string) (*moduleGroup, error) {
+	group, alias, err := c.lookupModuleGroupOrAlias(module, depName)
+	if err != nil || alias == nil {
+		return group, err
+	}
+	return c.resolveAlias(module, depName, alias)
+}
+
+// lookupModuleGroupOrAlias returns either the group of the module or the
+// alias module that module refers to as depName, or neither if there isn't
+// one.
+func (c *Context) lookupModuleGroupOrAlias(module *moduleInfo,
+	depName string) (*moduleGroup, *moduleInfo, error) {
+
+	find := func(key string) (*moduleGroup, *moduleInfo, bool) {
+		if group, ok := c.moduleGroups[key]; ok {
+			return group, nil, true
+		}
+		if alias, ok := c.moduleAliases[key]; ok {
+			return nil, alias, true
+		}
+		return nil, nil, false
+	}
+
+	if strings.HasPrefix(depName, "//") {
+		i := strings.LastIndex(depName, ":")
+		if i == -1 {
+			return nil, nil, fmt.Errorf("%q depends on %q, which is not of the form "+
+				"//<namespace dir>:<module name>", module.properties.Name, depName)
+		}
+
+		ns := c.namespaces[namespaceDir(depName[:i])]
+		if ns == nil {
+			return nil, nil, fmt.Errorf("%q depends on %q in undefined namespace %q",
+				module.properties.Name, depName, depName[:i])
+		}
+
+		name := depName[i+1:]
+		group, alias, ok := find(ns.key(name))
+		if ok && ns != module.namespace && !ns.exported(name) {
+			return nil, nil, fmt.Errorf("%q depends on %q, which is not exported by namespace %q",
+				module.properties.Name, name, ns.name())
+		}
+		return group, alias, nil
+	}
+
+	ns := module.namespace
+	if group, alias, ok := find(ns.key(depName)); ok {
+		return group, alias, nil
+	}
+
+	if ns == nil {
+		return nil, nil, nil
+	}
+
+	for _, imported := range ns.imports {
+		if group, alias, ok := find(imported.key(depName)); ok && imported.exported(depName) {
+			return group, alias, nil
+		}
+	}
+
+	group, alias, _ := find(depName)
+	return group, alias, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func prepareNamespaceTest(files []blueprintsTestFile) (*Context, []error) {
+	return prepareTestFiles(func(ctx *Context) {
+		ctx.RegisterModuleType("foo_module", newFooModule)
+	}, files)
+}
+
+func TestNamespaces(t *testing.T) {
+	ctx, errs := prepareNamespaceTest([]blueprintsTestFile{
+		{"Blueprints", `
+			foo_module { name: "utils" }
+			foo_module { name: "app", deps: ["utils", "//vendor/a:lib"] }
+		`},
+		{"vendor/a/Blueprints", `
+			foo_module { name: "lib", deps: ["utils"] }
+			blueprint_namespace { exports: ["lib"] }
+			foo_module { name: "utils" }
+		`},
+		{"vendor/a/sub/Blueprints", `
+			foo_module { name: "tool", deps: ["utils", "app"] }
+		`},
+		{"vendor/b/Blueprints", `
+			blueprint_namespace { imports: ["//vendor/a"] }
+			foo_module { name: "bin", deps: ["lib", "utils"] }
+		`},
+	})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	var got []string
+	ctx.VisitAllModules(func(m Module) {
+		module := ctx.moduleInfo[m]
+		var deps []string
+		for _, dep := range module.directDeps {
+			deps = append(deps, filepath.Dir(dep.relBlueprintsFile)+":"+dep.properties.Name)
+		}
+		got = append(got, fmt.Sprintf("%s:%s %v", filepath.Dir(module.relBlueprintsFile),
+			module.properties.Name, deps))
+	})
+
+	expected := []string{
+		"vendor/a:lib [vendor/a:utils]",
+		"vendor/a/sub:tool [vendor/a:utils .:app]",
+		"vendor/a:utils []",
+		"vendor/b:bin [vendor/a:lib .:utils]",
+		".:app [.:utils vendor/a:lib]",
+		".:utils []",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected:\n  %s\ngot:\n  %s", strings.Join(expected, "\n  "),
+			strings.Join(got, "\n  "))
+	}
+}
+
+func TestNamespaceErrors(t *testing.T) {
+	testCases := []struct {
+		files []blueprintsTestFile
+		err   string
+	}{
+		{
+			files: []blueprintsTestFile{
+				{"a/Blueprints", `
+					blueprint_namespace {}
+					foo_module { name: "utils" }
+				`},
+				{"b/Blueprints", `foo_module { name: "bin", deps: ["utils"] }`},
+			},
+			err: `b/Blueprints:1:31: "bin" depends on undefined module "utils"`,
+		},
+		{
+			files: []blueprintsTestFile{
+				{"a/Blueprints", `
+					blueprint_namespace { exports: [] }
+					foo_module { name: "utils" }
+				`},
+				{"b/Blueprints", `foo_module { name: "bin", deps: ["//a:utils"] }`},
+			},
+			err: `b/Blueprints:1:31: "bin" depends on "utils", which is not exported by namespace "//a"`,
+		},
+		{
+			files: []blueprintsTestFile{
+				{"b/Blueprints", `foo_module { name: "bin", deps: ["//a:utils"] }`},
+			},
+			err: `b/Blueprints:1:31: "bin" depends on "//a:utils" in undefined namespace "//a"`,
+		},
+		{
+			files: []blueprintsTestFile{
+				{"a/Blueprints", `blueprint_namespace { imports: ["//c"] }`},
+			},
+			err: `a/Blueprints:1:30: namespace "//a" imports undefined namespace "//c"`,
+		},
+		{
+			files: []blueprintsTestFile{
+				{"a/Blueprints", `blueprint_namespace { exports: ["utils"] }`},
+			},
+			err: `a/Blueprints:1:30: namespace "//a" exports undefined module "utils"`,
+		},
+		{
+			files: []blueprintsTestFile{
+				{"a/Blueprints", `
+					blueprint_namespace {}
+					blueprint_namespace {}
+				`},
+			},
+			err: `a/Blueprints:3:6: namespace "//a" already defined`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, errs := prepareNamespaceTest(testCase.files)
+		if len(errs) == 0 {
+			t.Errorf("expected error %q, got none", testCase.err)
+			continue
+		}
+		if got := errs[0].Error(); got != testCase.err {
+			t.Errorf("expected error %q, got %q", testCase.err, got)
+		}
+	}
+}
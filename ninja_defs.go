@@ -17,8 +17,6 @@ package blueprint
 import (
 	"errors"
 	"fmt"
-	"sort"
-	"strconv"
 	"strings"
 )
 
@@ -65,7 +63,15 @@ type RuleParams struct {
 	Pool           Pool   // The Ninja pool to which the rule belongs.
 	Restat         bool   // Whether Ninja should re-stat the rule's outputs.
 	Rspfile        string // The response file.
-	RspfileContent string // The response file content.
+	RspfileContent string // The response file content.  Set with Rspfile.
+
+	// Env contains the environment variables that the command needs.  If it
+	// is non-empty the command is run with exactly these variables set,
+	// rather than with the environment that Ninja was run with.  The command
+	// is run by env rather than by a shell, so it must run a single program;
+	// use a script to run more.  Like the command, the values are passed to
+	// the shell as they are written.
+	Env map[string]string
 }
 
 // A BuildParams object contains the set of parameters that make up a Ninja
@@ -73,13 +79,15 @@ type RuleParams struct {
 // Ninja build statement.  The Args field contains variable names and values
 // that are set within the build statement's scope in the Ninja file.
 type BuildParams struct {
-	Rule      Rule              // The rule to invoke.
-	Outputs   []string          // The list of output targets.
-	Inputs    []string          // The list of explicit input dependencies.
-	Implicits []string          // The list of implicit dependencies.
-	OrderOnly []string          // The list of order-only dependencies.
-	Args      map[string]string // The variable/value pairs to set.
-	Optional  bool              // Skip outputting a default statement
+	Rule           Rule              // The rule to invoke.
+	Outputs        []string          // The list of output targets.
+	SymlinkOutputs []string          // The outputs that the rule creates as symlinks.  See Context.SetNinjaSymlinkOutputs.
+	Inputs         []string          // The list of explicit input dependencies.
+	Implicits      []string          // The list of implicit dependencies.
+	OrderOnly      []string          // The list of order-only dependencies.
+	Validations    []string          // The list of validations to run when the outputs are built.
+	Args           map[string]string // The variable/value pairs to set.
+	Optional       bool              // Skip outputting a default statement
 }
 
 // A poolDef describes a pool definition.  It does not include the name of the
@@ -92,6 +100,10 @@ type poolDef struct {
 func parsePoolParams(scope scope, params *PoolParams) (*poolDef,
 	error) {
 
+	if params.Depth < 0 {
+		return nil, fmt.Errorf("invalid pool depth %d", params.Depth)
+	}
+
 	def := &poolDef{
 		Comment: params.Comment,
 		Depth:   params.Depth,
@@ -100,20 +112,12 @@ func parsePoolParams(scope scope, params *PoolParams) (*poolDef,
 	return def, nil
 }
 
-func (p *poolDef) WriteTo(nw *ninjaWriter, name string) error {
-	if p.Comment != "" {
-		err := nw.Comment(p.Comment)
-		if err != nil {
-			return err
-		}
-	}
-
-	err := nw.Pool(name)
-	if err != nil {
-		return err
+func (p *poolDef) manifestPool(name string) *ManifestPool {
+	return &ManifestPool{
+		Name:    name,
+		Comment: p.Comment,
+		Depth:   p.Depth,
 	}
-
-	return nw.ScopedAssign("depth", strconv.Itoa(p.Depth))
 }
 
 // A ruleDef describes a rule definition.  It does not include the name of the
@@ -122,6 +126,7 @@ type ruleDef struct {
 	Comment   string
 	Pool      Pool
 	Variables map[string]*ninjaString
+	Env       map[string]*ninjaString
 }
 
 func parseRuleParams(scope scope, params *RuleParams) (*ruleDef,
@@ -176,6 +181,12 @@ func parseRuleParams(scope scope, params *RuleParams) (*ruleDef,
 		r.Variables["restat"] = simpleNinjaString("true")
 	}
 
+	// Ninja writes RspfileContent to Rspfile before running the command and
+	// rejects rules that only set one of them.
+	if (params.Rspfile == "") != (params.RspfileContent == "") {
+		return nil, fmt.Errorf("Rspfile and RspfileContent must be set together")
+	}
+
 	if params.Rspfile != "" {
 		value, err = parseNinjaString(scope, params.Rspfile)
 		if err != nil {
@@ -193,56 +204,64 @@ func parseRuleParams(scope scope, params *RuleParams) (*ruleDef,
 		r.Variables["rspfile_content"] = value
 	}
 
-	return r, nil
-}
-
-func (r *ruleDef) WriteTo(nw *ninjaWriter, name string,
-	pkgNames map[*PackageContext]string) error {
+	if len(params.Env) > 0 {
+		r.Env = make(map[string]*ninjaString)
+		for name, envValue := range params.Env {
+			if !isEnvVarName(name) {
+				return nil, fmt.Errorf("invalid environment variable name %q",
+					name)
+			}
 
-	if r.Comment != "" {
-		err := nw.Comment(r.Comment)
-		if err != nil {
-			return err
+			value, err = parseNinjaString(scope, envValue)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing Env param %s: %s", name,
+					err)
+			}
+			r.Env[name] = value
 		}
 	}
 
-	err := nw.Rule(name)
-	if err != nil {
-		return err
+	return r, nil
+}
+
+func (r *ruleDef) manifestRule(name string,
+	pkgNames map[*PackageContext]string) *ManifestRule {
+
+	rule := &ManifestRule{
+		Name:      name,
+		Comment:   r.Comment,
+		Variables: make(map[string]string),
 	}
 
 	if r.Pool != nil {
-		err = nw.ScopedAssign("pool", r.Pool.fullName(pkgNames))
-		if err != nil {
-			return err
-		}
+		rule.Pool = r.Pool.fullName(pkgNames)
 	}
 
-	var keys []string
-	for k := range r.Variables {
-		keys = append(keys, k)
+	if r.Env != nil {
+		rule.Env = make(map[string]string)
+		for name, value := range r.Env {
+			rule.Env[name] = value.Value(pkgNames)
+		}
 	}
-	sort.Strings(keys)
 
-	for _, name := range keys {
-		err = nw.ScopedAssign(name, r.Variables[name].Value(pkgNames))
-		if err != nil {
-			return err
-		}
+	for name, value := range r.Variables {
+		rule.Variables[name] = value.Value(pkgNames)
 	}
 
-	return nil
+	return rule
 }
 
 // A buildDef describes a build target definition.
 type buildDef struct {
-	Rule      Rule
-	Outputs   []*ninjaString
-	Inputs    []*ninjaString
-	Implicits []*ninjaString
-	OrderOnly []*ninjaString
-	Args      map[Variable]*ninjaString
-	Optional  bool
+	Rule           Rule
+	Outputs        []*ninjaString
+	SymlinkOutputs []*ninjaString
+	Inputs         []*ninjaString
+	Implicits      []*ninjaString
+	OrderOnly      []*ninjaString
+	Validations    []*ninjaString
+	Args           map[Variable]*ninjaString
+	Optional       bool
 }
 
 func parseBuildParams(scope scope, params *BuildParams) (*buildDef,
@@ -268,6 +287,23 @@ func parseBuildParams(scope scope, params *BuildParams) (*buildDef,
 		return nil, fmt.Errorf("error parsing Outputs param: %s", err)
 	}
 
+	// Ninja only treats an output as a symlink when deciding whether it is
+	// up to date or needs cleaning, so each one must also be an output.
+	isOutput := make(map[string]bool)
+	for _, output := range params.Outputs {
+		isOutput[output] = true
+	}
+	for _, symlink := range params.SymlinkOutputs {
+		if !isOutput[symlink] {
+			return nil, fmt.Errorf("SymlinkOutputs param %q is not in Outputs", symlink)
+		}
+	}
+
+	b.SymlinkOutputs, err = parseNinjaStrings(scope, params.SymlinkOutputs)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing SymlinkOutputs param: %s", err)
+	}
+
 	b.Inputs, err = parseNinjaStrings(scope, params.Inputs)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing Inputs param: %s", err)
@@ -283,6 +319,11 @@ func parseBuildParams(scope scope, params *BuildParams) (*buildDef,
 		return nil, fmt.Errorf("error parsing OrderOnly param: %s", err)
 	}
 
+	b.Validations, err = parseNinjaStrings(scope, params.Validations)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing Validations param: %s", err)
+	}
+
 	b.Optional = params.Optional
 
 	argNameScope := rule.scope()
@@ -313,43 +354,40 @@ func parseBuildParams(scope scope, params *BuildParams) (*buildDef,
 	return b, nil
 }
 
-func (b *buildDef) WriteTo(nw *ninjaWriter, pkgNames map[*PackageContext]string) error {
-	var (
-		rule          = b.Rule.fullName(pkgNames)
-		outputs       = valueList(b.Outputs, pkgNames, outputEscaper)
-		explicitDeps  = valueList(b.Inputs, pkgNames, inputEscaper)
-		implicitDeps  = valueList(b.Implicits, pkgNames, inputEscaper)
-		orderOnlyDeps = valueList(b.OrderOnly, pkgNames, inputEscaper)
-	)
-	err := nw.Build(rule, outputs, explicitDeps, implicitDeps, orderOnlyDeps)
-	if err != nil {
-		return err
+func (b *buildDef) manifestBuild(pkgNames map[*PackageContext]string) *ManifestBuild {
+	build := &ManifestBuild{
+		Rule:           b.Rule.fullName(pkgNames),
+		Outputs:        valueList(b.Outputs, pkgNames, outputEscaper),
+		SymlinkOutputs: valueList(b.SymlinkOutputs, pkgNames, outputEscaper),
+		Inputs:         valueList(b.Inputs, pkgNames, inputEscaper),
+		Implicits:      valueList(b.Implicits, pkgNames, inputEscaper),
+		OrderOnly:      valueList(b.OrderOnly, pkgNames, inputEscaper),
+		Validations:    valueList(b.Validations, pkgNames, inputEscaper),
+		Args:           make(map[string]string),
+		Optional:       b.Optional,
 	}
 
-	args := make(map[string]string)
-
 	for argVar, value := range b.Args {
-		args[argVar.fullName(pkgNames)] = value.Value(pkgNames)
+		build.Args[argVar.fullName(pkgNames)] = value.Value(pkgNames)
 	}
 
-	var keys []string
-	for k := range args {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
+	return build
+}
 
-	for _, name := range keys {
-		err = nw.ScopedAssign(name, args[name])
-		if err != nil {
-			return err
-		}
+func isEnvVarName(name string) bool {
+	if name == "" {
+		return false
 	}
 
-	if !b.Optional {
-		nw.Default(outputs...)
+	for i, c := range name {
+		valid := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' ||
+			i > 0 && c >= '0' && c <= '9'
+		if !valid {
+			return false
+		}
 	}
 
-	return nil
+	return true
 }
 
 func valueList(list []*ninjaString, pkgNames map[*PackageContext]string,
@@ -20,8 +20,6 @@ import (
 	"strings"
 )
 
-const eof = -1
-
 var (
 	defaultEscaper = strings.NewReplacer(
 		"\n", "$\n")
@@ -51,34 +49,20 @@ func simpleNinjaString(str string) *ninjaString {
 	}
 }
 
-type parseState struct {
-	scope       scope
-	str         string
-	stringStart int
-	varStart    int
-	result      *ninjaString
-}
-
-func (ps *parseState) pushVariable(v Variable) {
-	if len(ps.result.variables) == len(ps.result.strings) {
-		// Last push was a variable, we need a blank string separator
-		ps.result.strings = append(ps.result.strings, "")
-	}
-	ps.result.variables = append(ps.result.variables, v)
-}
-
-func (ps *parseState) pushString(s string) {
-	if len(ps.result.strings) != len(ps.result.variables) {
-		panic("oops, pushed string after string")
-	}
-	ps.result.strings = append(ps.result.strings, s)
+// isNinjaVariableChar returns true if c may be part of the name of a variable
+// referenced as $name.  Names in brackets may also contain '.'.
+func isNinjaVariableChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9') || c == '_' || c == '-'
 }
 
-type stateFunc func(*parseState, int, rune) (stateFunc, error)
-
 // parseNinjaString parses an unescaped ninja string (i.e. all $<something>
 // occurrences are expected to be variables or $$) and returns a list of the
 // variable names that the string references.
+//
+// It is called for every string in every build statement, so it scans the
+// string in a single pass, jumping from one '$' to the next, and only
+// allocates the result.
 func parseNinjaString(scope scope, str string) (*ninjaString, error) {
 	// naively pre-allocate slices by counting $ signs
 	n := strings.Count(str, "$")
@@ -86,159 +70,85 @@ func parseNinjaString(scope scope, str string) (*ninjaString, error) {
 		strings:   make([]string, 0, n+1),
 		variables: make([]Variable, 0, n),
 	}
-
-	parseState := &parseState{
-		scope:  scope,
-		str:    str,
-		result: result,
+	if n == 0 {
+		result.strings = append(result.strings, str)
+		return result, nil
 	}
 
-	state := parseStringState
-	var err error
-	for i := 0; i < len(str); i++ {
-		r := rune(str[i])
-		state, err = state(parseState, i, r)
-		if err != nil {
-			return nil, err
+	// The literal text since the end of the last variable reference, which
+	// includes any "$$" escapes, starts at stringStart.
+	stringStart := 0
+	i := 0
+	for {
+		dollar := strings.IndexByte(str[i:], '$')
+		if dollar < 0 {
+			break
 		}
-	}
-
-	_, err = state(parseState, len(parseState.str), eof)
-	if err != nil {
-		return nil, err
-	}
-
-	return result, nil
-}
-
-func parseStringState(state *parseState, i int, r rune) (stateFunc, error) {
-	switch {
-	case r == '$':
-		state.varStart = i + 1
-		return parseDollarStartState, nil
-
-	case r == eof:
-		state.pushString(state.str[state.stringStart:i])
-		return nil, nil
-
-	default:
-		return parseStringState, nil
-	}
-}
-
-func parseDollarStartState(state *parseState, i int, r rune) (stateFunc, error) {
-	switch {
-	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z',
-		r >= '0' && r <= '9', r == '_', r == '-':
-		// The beginning of a of the variable name.  Output the string and
-		// keep going.
-		state.pushString(state.str[state.stringStart : i-1])
-		return parseDollarState, nil
-
-	case r == '$':
-		// Just a "$$".  Go back to parseStringState without changing
-		// state.stringStart.
-		return parseStringState, nil
-
-	case r == '{':
-		// This is a bracketted variable name (e.g. "${blah.blah}").  Output
-		// the string and keep going.
-		state.pushString(state.str[state.stringStart : i-1])
-		state.varStart = i + 1
-		return parseBracketsState, nil
-
-	case r == eof:
-		return nil, fmt.Errorf("unexpected end of string after '$'")
-
-	default:
-		// This was some arbitrary character following a dollar sign,
-		// which is not allowed.
-		return nil, fmt.Errorf("invalid character after '$' at byte "+
-			"offset %d", i)
-	}
-}
+		i += dollar
 
-func parseDollarState(state *parseState, i int, r rune) (stateFunc, error) {
-	switch {
-	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z',
-		r >= '0' && r <= '9', r == '_', r == '-':
-		// A part of the variable name.  Keep going.
-		return parseDollarState, nil
-
-	case r == '$':
-		// A dollar after the variable name (e.g. "$blah$").  Output the
-		// variable we have and start a new one.
-		v, err := state.scope.LookupVariable(state.str[state.varStart:i])
-		if err != nil {
-			return nil, err
+		if i+1 == len(str) {
+			return nil, fmt.Errorf("unexpected end of string after '$'")
 		}
 
-		state.pushVariable(v)
-		state.varStart = i + 1
-		state.stringStart = i
-
-		return parseDollarStartState, nil
-
-	case r == eof:
-		// This is the end of the variable name.
-		v, err := state.scope.LookupVariable(state.str[state.varStart:i])
-		if err != nil {
-			return nil, err
+		var name string
+		switch c := str[i+1]; {
+		case c == '$':
+			// Just a "$$", which is kept in the literal text.
+			i += 2
+			continue
+
+		case c == '{':
+			// This is a bracketted variable name (e.g. "${blah.blah}").
+			start := i + 2
+			end := start
+			for end < len(str) && (isNinjaVariableChar(str[end]) || str[end] == '.') {
+				end++
+			}
+			switch {
+			case end == len(str):
+				return nil, fmt.Errorf("unexpected end of string in variable name")
+			case str[end] != '}':
+				// This character isn't allowed in a variable name.
+				return nil, fmt.Errorf("invalid character in variable name at "+
+					"byte offset %d", end)
+			case end == start:
+				// The brackets were immediately closed.  That's no good.
+				return nil, fmt.Errorf("empty variable name at byte offset %d",
+					end)
+			}
+			name = str[start:end]
+			result.strings = append(result.strings, str[stringStart:i])
+			i = end + 1
+
+		case isNinjaVariableChar(c):
+			start := i + 1
+			end := start + 1
+			for end < len(str) && isNinjaVariableChar(str[end]) {
+				end++
+			}
+			name = str[start:end]
+			result.strings = append(result.strings, str[stringStart:i])
+			i = end
+
+		default:
+			// This was some arbitrary character following a dollar sign,
+			// which is not allowed.
+			return nil, fmt.Errorf("invalid character after '$' at byte "+
+				"offset %d", i+1)
 		}
 
-		state.pushVariable(v)
-
-		// We always end with a string, even if it's an empty one.
-		state.pushString("")
-
-		return nil, nil
-
-	default:
-		// We've just gone past the end of the variable name, so record what
-		// we have.
-		v, err := state.scope.LookupVariable(state.str[state.varStart:i])
+		v, err := scope.LookupVariable(name)
 		if err != nil {
 			return nil, err
 		}
-
-		state.pushVariable(v)
-		state.stringStart = i
-		return parseStringState, nil
+		result.variables = append(result.variables, v)
+		stringStart = i
 	}
-}
 
-func parseBracketsState(state *parseState, i int, r rune) (stateFunc, error) {
-	switch {
-	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z',
-		r >= '0' && r <= '9', r == '_', r == '-', r == '.':
-		// A part of the variable name.  Keep going.
-		return parseBracketsState, nil
-
-	case r == '}':
-		if state.varStart == i {
-			// The brackets were immediately closed.  That's no good.
-			return nil, fmt.Errorf("empty variable name at byte offset %d",
-				i)
-		}
+	// We always end with a string, even if it's an empty one.
+	result.strings = append(result.strings, str[stringStart:])
 
-		// This is the end of the variable name.
-		v, err := state.scope.LookupVariable(state.str[state.varStart:i])
-		if err != nil {
-			return nil, err
-		}
-
-		state.pushVariable(v)
-		state.stringStart = i + 1
-		return parseStringState, nil
-
-	case r == eof:
-		return nil, fmt.Errorf("unexpected end of string in variable name")
-
-	default:
-		// This character isn't allowed in a variable name.
-		return nil, fmt.Errorf("invalid character in variable name at "+
-			"byte offset %d", i)
-	}
+	return result, nil
 }
 
 func parseNinjaStrings(scope scope, strs []string) ([]*ninjaString,
@@ -289,6 +199,27 @@ func (n *ninjaString) Eval(variables map[Variable]*ninjaString) (string, error)
 	return str, nil
 }
 
+// substitute returns n with the values of the variables in variables, which
+// may themselves refer to other variables in variables, in place of references
+// to them.  References to other variables are kept.
+func (n *ninjaString) substitute(variables map[Variable]*ninjaString) *ninjaString {
+	result := &ninjaString{strings: []string{n.strings[0]}}
+	for i, v := range n.variables {
+		if value, ok := variables[v]; ok {
+			value = value.substitute(variables)
+			last := len(result.strings) - 1
+			result.strings[last] += value.strings[0]
+			result.strings = append(result.strings, value.strings[1:]...)
+			result.variables = append(result.variables, value.variables...)
+			result.strings[len(result.strings)-1] += n.strings[i+1]
+		} else {
+			result.strings = append(result.strings, n.strings[i+1])
+			result.variables = append(result.variables, v)
+		}
+	}
+	return result
+}
+
 func validateNinjaName(name string) error {
 	for i, r := range name {
 		valid := (r >= 'a' && r <= 'z') ||
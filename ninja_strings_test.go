@@ -15,7 +15,9 @@
 package blueprint
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -90,6 +92,37 @@ var ninjaParseTestCases = []struct {
 		input: "foo ${abc",
 		err:   "unexpected end of string in variable name",
 	},
+	{
+		input: "$a.b",
+		vars:  []string{"a"},
+		strs:  []string{"", ".b"},
+	},
+	{
+		input: "$$$foo$$",
+		vars:  []string{"foo"},
+		strs:  []string{"$$", "$$"},
+	},
+	{
+		input: "${foo}${bar}baz",
+		vars:  []string{"foo", "bar"},
+		strs:  []string{"", "", "baz"},
+	},
+	{
+		input: "foo $\nbar",
+		err:   "invalid character after '$' at byte offset 5",
+	},
+	{
+		input: "foo $\xc3\xa9",
+		err:   "invalid character after '$' at byte offset 5",
+	},
+	{
+		input: "foo ${a b}",
+		err:   "invalid character in variable name at byte offset 7",
+	},
+	{
+		input: "foo ${",
+		err:   "unexpected end of string in variable name",
+	},
 }
 
 func TestParseNinjaString(t *testing.T) {
@@ -156,3 +189,283 @@ func TestParseNinjaStringWithImportedVar(t *testing.T) {
 		t.Errorf("       got: %#v", output)
 	}
 }
+
+const refEOF = -1
+
+type refParseState struct {
+	scope       scope
+	str         string
+	stringStart int
+	varStart    int
+	result      *ninjaString
+}
+
+func (ps *refParseState) pushVariable(v Variable) {
+	if len(ps.result.variables) == len(ps.result.strings) {
+		// Last push was a variable, we need a blank string separator
+		ps.result.strings = append(ps.result.strings, "")
+	}
+	ps.result.variables = append(ps.result.variables, v)
+}
+
+func (ps *refParseState) pushString(s string) {
+	if len(ps.result.strings) != len(ps.result.variables) {
+		panic("oops, pushed string after string")
+	}
+	ps.result.strings = append(ps.result.strings, s)
+}
+
+type refStateFunc func(*refParseState, int, rune) (refStateFunc, error)
+
+// referenceParseNinjaString is the original state machine implementation of
+// parseNinjaString, which calls a state function for every byte of the string.
+// The conformance tests check that parseNinjaString returns the same results
+// and errors for every input.
+func referenceParseNinjaString(scope scope, str string) (*ninjaString, error) {
+	// naively pre-allocate slices by counting $ signs
+	n := strings.Count(str, "$")
+	result := &ninjaString{
+		strings:   make([]string, 0, n+1),
+		variables: make([]Variable, 0, n),
+	}
+
+	ps := &refParseState{
+		scope:  scope,
+		str:    str,
+		result: result,
+	}
+
+	state := refStringState
+	var err error
+	for i := 0; i < len(str); i++ {
+		r := rune(str[i])
+		state, err = state(ps, i, r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = state(ps, len(ps.str), refEOF)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func refStringState(state *refParseState, i int, r rune) (refStateFunc, error) {
+	switch {
+	case r == '$':
+		state.varStart = i + 1
+		return refDollarStartState, nil
+
+	case r == refEOF:
+		state.pushString(state.str[state.stringStart:i])
+		return nil, nil
+
+	default:
+		return refStringState, nil
+	}
+}
+
+func refDollarStartState(state *refParseState, i int, r rune) (refStateFunc, error) {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z',
+		r >= '0' && r <= '9', r == '_', r == '-':
+		// The beginning of a of the variable name.  Output the string and
+		// keep going.
+		state.pushString(state.str[state.stringStart : i-1])
+		return refDollarState, nil
+
+	case r == '$':
+		// Just a "$$".  Go back to refStringState without changing
+		// state.stringStart.
+		return refStringState, nil
+
+	case r == '{':
+		// This is a bracketted variable name (e.g. "${blah.blah}").  Output
+		// the string and keep going.
+		state.pushString(state.str[state.stringStart : i-1])
+		state.varStart = i + 1
+		return refBracketsState, nil
+
+	case r == refEOF:
+		return nil, fmt.Errorf("unexpected end of string after '$'")
+
+	default:
+		// This was some arbitrary character following a dollar sign,
+		// which is not allowed.
+		return nil, fmt.Errorf("invalid character after '$' at byte "+
+			"offset %d", i)
+	}
+}
+
+func refDollarState(state *refParseState, i int, r rune) (refStateFunc, error) {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z',
+		r >= '0' && r <= '9', r == '_', r == '-':
+		// A part of the variable name.  Keep going.
+		return refDollarState, nil
+
+	case r == '$':
+		// A dollar after the variable name (e.g. "$blah$").  Output the
+		// variable we have and start a new one.
+		v, err := state.scope.LookupVariable(state.str[state.varStart:i])
+		if err != nil {
+			return nil, err
+		}
+
+		state.pushVariable(v)
+		state.varStart = i + 1
+		state.stringStart = i
+
+		return refDollarStartState, nil
+
+	case r == refEOF:
+		// This is the end of the variable name.
+		v, err := state.scope.LookupVariable(state.str[state.varStart:i])
+		if err != nil {
+			return nil, err
+		}
+
+		state.pushVariable(v)
+
+		// We always end with a string, even if it's an empty one.
+		state.pushString("")
+
+		return nil, nil
+
+	default:
+		// We've just gone past the end of the variable name, so record what
+		// we have.
+		v, err := state.scope.LookupVariable(state.str[state.varStart:i])
+		if err != nil {
+			return nil, err
+		}
+
+		state.pushVariable(v)
+		state.stringStart = i
+		return refStringState, nil
+	}
+}
+
+func refBracketsState(state *refParseState, i int, r rune) (refStateFunc, error) {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z',
+		r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+		// A part of the variable name.  Keep going.
+		return refBracketsState, nil
+
+	case r == '}':
+		if state.varStart == i {
+			// The brackets were immediately closed.  That's no good.
+			return nil, fmt.Errorf("empty variable name at byte offset %d",
+				i)
+		}
+
+		// This is the end of the variable name.
+		v, err := state.scope.LookupVariable(state.str[state.varStart:i])
+		if err != nil {
+			return nil, err
+		}
+
+		state.pushVariable(v)
+		state.stringStart = i + 1
+		return refStringState, nil
+
+	case r == refEOF:
+		return nil, fmt.Errorf("unexpected end of string in variable name")
+
+	default:
+		// This character isn't allowed in a variable name.
+		return nil, fmt.Errorf("invalid character in variable name at "+
+			"byte offset %d", i)
+	}
+}
+
+// conformanceScope is a scope in which every variable name that
+// basicScope.LookupVariable would accept is defined.
+type conformanceScope struct {
+	variables map[string]Variable
+}
+
+func (s *conformanceScope) LookupVariable(name string) (Variable, error) {
+	if strings.Contains(name, ".") {
+		return nil, fmt.Errorf("no package named %q", name)
+	}
+	v, ok := s.variables[name]
+	if !ok {
+		v = &staticVariable{name_: name}
+		s.variables[name] = v
+	}
+	return v, nil
+}
+
+func (s *conformanceScope) IsRuleVisible(rule Rule) bool { return true }
+func (s *conformanceScope) IsPoolVisible(pool Pool) bool { return true }
+
+func checkParseNinjaStringConformance(t *testing.T, scope scope, input string) {
+	expected, expectedErr := referenceParseNinjaString(scope, input)
+	output, err := parseNinjaString(scope, input)
+
+	if fmt.Sprint(err) != fmt.Sprint(expectedErr) {
+		t.Errorf("input %q: expected error %v, got %v", input, expectedErr, err)
+		return
+	}
+	if err == nil && !reflect.DeepEqual(output, expected) {
+		t.Errorf("input %q: expected %#v, got %#v", input, expected, output)
+	}
+}
+
+func TestParseNinjaStringConformance(t *testing.T) {
+	scope := &conformanceScope{variables: make(map[string]Variable)}
+
+	for _, testCase := range ninjaParseTestCases {
+		checkParseNinjaStringConformance(t, scope, testCase.input)
+	}
+
+	// Every string of up to 6 of the bytes that are significant to the
+	// parser, and a few that are not.
+	const alphabet = "${}._a- :\xff"
+	var generate func(prefix string)
+	generate = func(prefix string) {
+		checkParseNinjaStringConformance(t, scope, prefix)
+		if len(prefix) < 6 && !t.Failed() {
+			for i := 0; i < len(alphabet); i++ {
+				generate(prefix + alphabet[i:i+1])
+			}
+		}
+	}
+	generate("")
+}
+
+func BenchmarkParseNinjaString(b *testing.B) {
+	scope := newLocalScope(nil, "namespace")
+	for _, name := range []string{"in", "out", "cFlags", "outDir", "ccCmd"} {
+		if _, err := scope.AddLocalVariable(name, ""); err != nil {
+			b.Fatalf("error creating scope: %s", err)
+		}
+	}
+
+	benchmarks := []struct {
+		name  string
+		input string
+	}{
+		{"literal", "out/soong/.intermediates/frameworks/base/core/jni/libandroid_runtime.so"},
+		{"variables", "$ccCmd -c $cFlags -MD -MF ${out}.d -o $out $in"},
+		{"escapes", "for f in $in; do echo $$f $$(basename $$f); done > $out"},
+		{"long", strings.Repeat("${outDir}/obj/file.o ", 100)},
+	}
+
+	for _, benchmark := range benchmarks {
+		b.Run(benchmark.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := parseNinjaString(scope, benchmark.input)
+				if err != nil {
+					b.Fatalf("unexpected error: %s", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"sort"
+)
+
+// A NinjaUsage reports which of the Ninja variables and rules declared with a
+// Go package's PackageContext are not referenced, directly or through other
+// variables, by any build statement.  Unused variables and rules are left out
+// of the Ninja file, but they still have to be maintained.
+type NinjaUsage struct {
+	PkgPath string // The Go package path passed to NewPackageContext.
+
+	Variables int // The number of variables declared by the package.
+	Rules     int // The number of rules declared by the package.
+
+	UnusedVariables []string // The sorted names of the unused variables.
+	UnusedRules     []string // The sorted names of the unused rules.
+}
+
+// NinjaUsage returns a NinjaUsage for every Go package that has created a
+// PackageContext, sorted by package path.  If this is called before
+// PrepareBuildActions successfully completes then ErrBuildActionsNotReady is
+// returned.
+func (c *Context) NinjaUsage() ([]NinjaUsage, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
+	}
+
+	pkgPaths := make([]string, 0, len(packageContexts))
+	for pkgPath := range packageContexts {
+		pkgPaths = append(pkgPaths, pkgPath)
+	}
+	sort.Strings(pkgPaths)
+
+	ret := make([]NinjaUsage, len(pkgPaths))
+	for i, pkgPath := range pkgPaths {
+		scope := packageContexts[pkgPath].scope
+		usage := NinjaUsage{
+			PkgPath:   pkgPath,
+			Variables: len(scope.variables),
+			Rules:     len(scope.rules),
+		}
+
+		for name, v := range scope.variables {
+			if _, ok := c.globalVariables[v]; !ok {
+				usage.UnusedVariables = append(usage.UnusedVariables, name)
+			}
+		}
+		sort.Strings(usage.UnusedVariables)
+
+		for name, r := range scope.rules {
+			if _, ok := c.globalRules[r]; !ok {
+				usage.UnusedRules = append(usage.UnusedRules, name)
+			}
+		}
+		sort.Strings(usage.UnusedRules)
+
+		ret[i] = usage
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var (
+	usageTestPctx = NewPackageContext("github.com/google/blueprint/usagetest")
+
+	usageTestFlags  = usageTestPctx.StaticVariable("flags", "-x")
+	usageTestTool   = usageTestPctx.StaticVariable("tool", "tool $flags")
+	usageTestUnused = usageTestPctx.StaticVariable("unused", "-y")
+
+	usageTestRule = usageTestPctx.StaticRule("usedRule",
+		RuleParams{
+			Command: "$tool $in > $out",
+		})
+	usageTestUnusedRule = usageTestPctx.StaticRule("unusedRule",
+		RuleParams{
+			Command: "$unused",
+		})
+)
+
+type usageModule struct {
+	properties struct {
+		Out string
+	}
+}
+
+func newUsageModule() (Module, []interface{}) {
+	m := &usageModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (u *usageModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Build(usageTestPctx, BuildParams{
+		Rule:    usageTestRule,
+		Outputs: []string{u.properties.Out},
+	})
+}
+
+func TestNinjaUsage(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("usage_module", newUsageModule)
+
+	if _, err := ctx.NinjaUsage(); err != ErrBuildActionsNotReady {
+		t.Errorf("expected ErrBuildActionsNotReady before PrepareBuildActions, got %v", err)
+	}
+
+	r := bytes.NewBufferString(`
+		usage_module {
+			name: "a",
+			out: "a.out",
+		}
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) > 0 {
+		t.Errorf("unexpected parse errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	_, errs = ctx.PrepareBuildActions(nil)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors preparing build actions:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	usages, err := ctx.NinjaUsage()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var usage *NinjaUsage
+	for i := range usages {
+		if usages[i].PkgPath == "github.com/google/blueprint/usagetest" {
+			usage = &usages[i]
+		}
+	}
+	if usage == nil {
+		t.Fatalf("missing usage for test package in %v", usages)
+	}
+
+	expected := NinjaUsage{
+		PkgPath:         "github.com/google/blueprint/usagetest",
+		Variables:       3,
+		Rules:           2,
+		UnusedVariables: []string{"unused"},
+		UnusedRules:     []string{"unusedRule"},
+	}
+	if !reflect.DeepEqual(*usage, expected) {
+		t.Errorf("expected %#v, got %#v", expected, *usage)
+	}
+}
@@ -15,7 +15,7 @@
 package blueprint
 
 import (
-	"fmt"
+	"bufio"
 	"io"
 	"strings"
 	"unicode"
@@ -27,20 +27,44 @@ const (
 	lineWidth      = 80
 )
 
+// ninjaWriterBufferSize is the size of the buffer that a ninjaWriter collects
+// the many small writes of a manifest in before writing them to the
+// underlying writer.
+const ninjaWriterBufferSize = 256 * 1024
+
 var indentString = strings.Repeat(" ", indentWidth*maxIndentDepth)
 
+// A ninjaWriter writes the statements of a Ninja manifest.  The statements are
+// written straight to a buffer rather than being formatted into intermediate
+// strings, and the buffer is only written to the underlying writer when it is
+// full or Flush is called, so Flush must be called after the last statement.
 type ninjaWriter struct {
-	writer io.Writer
+	writer *bufio.Writer
 
 	justDidBlankLine bool // true if the last operation was a BlankLine
 }
 
 func newNinjaWriter(writer io.Writer) *ninjaWriter {
 	return &ninjaWriter{
-		writer: writer,
+		writer: bufio.NewWriterSize(writer, ninjaWriterBufferSize),
 	}
 }
 
+// Flush writes the buffered statements to the underlying writer.
+func (n *ninjaWriter) Flush() error {
+	return n.writer.Flush()
+}
+
+// writeStrings writes strs to the buffer.  The buffer keeps the first error,
+// so only the last write needs to be checked.
+func (n *ninjaWriter) writeStrings(strs ...string) error {
+	var err error
+	for _, s := range strs {
+		_, err = n.writer.WriteString(s)
+	}
+	return err
+}
+
 func (n *ninjaWriter) Comment(comment string) error {
 	n.justDidBlankLine = false
 
@@ -71,8 +95,12 @@ func (n *ninjaWriter) Comment(comment string) error {
 		}
 
 		if writeLine {
-			line = strings.TrimSpace("# "+line) + "\n"
-			_, err := io.WriteString(n.writer, line)
+			var err error
+			if line == "" {
+				err = n.writeStrings("#\n")
+			} else {
+				err = n.writeStrings("# ", line, "\n")
+			}
 			if err != nil {
 				return err
 			}
@@ -82,7 +110,7 @@ func (n *ninjaWriter) Comment(comment string) error {
 
 	if lineStart != len(comment) {
 		line := strings.TrimSpace(comment[lineStart:])
-		_, err := fmt.Fprintf(n.writer, "# %s\n", line)
+		err := n.writeStrings("# ", line, "\n")
 		if err != nil {
 			return err
 		}
@@ -93,18 +121,21 @@ func (n *ninjaWriter) Comment(comment string) error {
 
 func (n *ninjaWriter) Pool(name string) error {
 	n.justDidBlankLine = false
-	_, err := fmt.Fprintf(n.writer, "pool %s\n", name)
-	return err
+	return n.writeStrings("pool ", name, "\n")
 }
 
 func (n *ninjaWriter) Rule(name string) error {
 	n.justDidBlankLine = false
-	_, err := fmt.Fprintf(n.writer, "rule %s\n", name)
-	return err
+	return n.writeStrings("rule ", name, "\n")
+}
+
+func (n *ninjaWriter) Subninja(file string) error {
+	n.justDidBlankLine = false
+	return n.writeStrings("subninja ", file, "\n")
 }
 
 func (n *ninjaWriter) Build(rule string, outputs, explicitDeps, implicitDeps,
-	orderOnlyDeps []string) error {
+	orderOnlyDeps, validations []string) error {
 
 	n.justDidBlankLine = false
 
@@ -146,19 +177,25 @@ func (n *ninjaWriter) Build(rule string, outputs, explicitDeps, implicitDeps,
 		}
 	}
 
+	if len(validations) > 0 {
+		wrapper.WriteStringWithSpace("|@")
+
+		for _, validation := range validations {
+			wrapper.WriteStringWithSpace(validation)
+		}
+	}
+
 	return wrapper.Flush()
 }
 
 func (n *ninjaWriter) Assign(name, value string) error {
 	n.justDidBlankLine = false
-	_, err := fmt.Fprintf(n.writer, "%s = %s\n", name, value)
-	return err
+	return n.writeStrings(name, " = ", value, "\n")
 }
 
 func (n *ninjaWriter) ScopedAssign(name, value string) error {
 	n.justDidBlankLine = false
-	_, err := fmt.Fprintf(n.writer, "%s%s = %s\n", indentString[:indentWidth], name, value)
-	return err
+	return n.writeStrings(indentString[:indentWidth], name, " = ", value, "\n")
 }
 
 func (n *ninjaWriter) Default(targets ...string) error {
@@ -175,7 +212,7 @@ func (n *ninjaWriter) Default(targets ...string) error {
 	wrapper.WriteString("default")
 
 	for _, target := range targets {
-		wrapper.WriteString(" " + target)
+		wrapper.WriteStringWithSpace(target)
 	}
 
 	return wrapper.Flush()
@@ -185,7 +222,7 @@ func (n *ninjaWriter) BlankLine() (err error) {
 	// We don't output multiple blank lines in a row.
 	if !n.justDidBlankLine {
 		n.justDidBlankLine = true
-		_, err = io.WriteString(n.writer, "\n")
+		err = n.writeStrings("\n")
 	}
 	return err
 }
@@ -208,22 +245,15 @@ func (n *ninjaWriterWithWrap) writeString(s string, space bool) {
 	}
 
 	if n.writtenLen+len(s)+spaceLen > n.maxLineLen {
-		_, n.err = io.WriteString(n.writer, " $\n")
-		if n.err != nil {
-			return
-		}
-		_, n.err = io.WriteString(n.writer, indentString[:indentWidth*2])
-		if n.err != nil {
-			return
-		}
+		n.err = n.writeStrings(" $\n", indentString[:indentWidth*2])
 		n.writtenLen = indentWidth * 2
 		s = strings.TrimLeftFunc(s, unicode.IsSpace)
 	} else if space {
-		io.WriteString(n.writer, " ")
+		n.err = n.writeStrings(" ")
 		n.writtenLen++
 	}
 
-	_, n.err = io.WriteString(n.writer, s)
+	n.err = n.writeStrings(s)
 	n.writtenLen += len(s)
 }
 
@@ -239,6 +269,5 @@ func (n *ninjaWriterWithWrap) Flush() error {
 	if n.err != nil {
 		return n.err
 	}
-	_, err := io.WriteString(n.writer, "\n")
-	return err
+	return n.writeStrings("\n")
 }
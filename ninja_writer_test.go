@@ -50,10 +50,17 @@ var ninjaWriterTestCases = []struct {
 	{
 		input: func(w *ninjaWriter) {
 			ck(w.Build("foo", []string{"o1", "o2"}, []string{"e1", "e2"},
-				[]string{"i1", "i2"}, []string{"oo1", "oo2"}))
+				[]string{"i1", "i2"}, []string{"oo1", "oo2"}, nil))
 		},
 		output: "build o1 o2: foo e1 e2 | i1 i2 || oo1 oo2\n",
 	},
+	{
+		input: func(w *ninjaWriter) {
+			ck(w.Build("foo", []string{"o1"}, []string{"e1"}, nil, nil,
+				[]string{"v1", "v2"}))
+		},
+		output: "build o1: foo e1 |@ v1 v2\n",
+	},
 	{
 		input: func(w *ninjaWriter) {
 			ck(w.Default("foo"))
@@ -88,7 +95,7 @@ var ninjaWriterTestCases = []struct {
 			ck(w.ScopedAssign("command", "echo out: $out in: $in _arg: $_arg"))
 			ck(w.ScopedAssign("pool", "p"))
 			ck(w.BlankLine())
-			ck(w.Build("r", []string{"foo.o"}, []string{"foo.in"}, nil, nil))
+			ck(w.Build("r", []string{"foo.o"}, []string{"foo.in"}, nil, nil, nil))
 			ck(w.ScopedAssign("_arg", "arg value"))
 		},
 		output: `pool p
@@ -110,6 +117,7 @@ func TestNinjaWriter(t *testing.T) {
 		buf := bytes.NewBuffer(nil)
 		w := newNinjaWriter(buf)
 		testCase.input(w)
+		ck(w.Flush())
 		if buf.String() != testCase.output {
 			t.Errorf("incorrect output for test case %d", i)
 			t.Errorf("  expected: %q", testCase.output)
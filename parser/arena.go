@@ -0,0 +1,57 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+// arenaSlabSize is the number of nodes of each type allocated at once.
+const arenaSlabSize = 64
+
+// A nodeArena allocates the AST nodes for a single file from slabs, so that
+// parsing a file makes a few large heap allocations rather than one small
+// allocation per node.  This reduces the amount of work the garbage collector
+// has to do while large trees are being parsed.  A slab is freed once none of
+// the nodes allocated from it are referenced, which normally happens once the
+// module properties have been unpacked.
+type nodeArena struct {
+	assignments []Assignment
+	modules     []Module
+	properties  []Property
+}
+
+func (a *nodeArena) newAssignment() *Assignment {
+	if len(a.assignments) == 0 {
+		a.assignments = make([]Assignment, arenaSlabSize)
+	}
+	assignment := &a.assignments[0]
+	a.assignments = a.assignments[1:]
+	return assignment
+}
+
+func (a *nodeArena) newModule() *Module {
+	if len(a.modules) == 0 {
+		a.modules = make([]Module, arenaSlabSize)
+	}
+	module := &a.modules[0]
+	a.modules = a.modules[1:]
+	return module
+}
+
+func (a *nodeArena) newProperty() *Property {
+	if len(a.properties) == 0 {
+		a.properties = make([]Property, arenaSlabSize)
+	}
+	property := &a.properties[0]
+	a.properties = a.properties[1:]
+	return property
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+// MergeProperties returns the properties of a module definition, base, with
+// the properties of a definition that overrides it, overlay, merged into
+// them.  Both must have been evaluated.  A property of overlay is added to
+// base if base doesn't have a property with the same name.  Otherwise the
+// qualifier of the overlay property selects how the values are combined:
+//
+//	srcs: ["b.c"]   // replaces the value, but merges the properties of maps
+//	srcs +: ["b.c"] // appends the value, like the + operator
+//	srcs =: ["b.c"] // replaces the value, even if it is a map
+//
+// The merged properties have no qualifiers, so that they can in turn be
+// overridden.  Neither base nor overlay are modified.
+func MergeProperties(base, overlay []*Property) ([]*Property, []error) {
+	var errs []error
+	merged := append([]*Property(nil), base...)
+
+	for _, property := range overlay {
+		i := 0
+		for i < len(merged) && merged[i].Name.Name != property.Name.Name {
+			i++
+		}
+
+		if i == len(merged) {
+			merged = append(merged, property)
+			continue
+		}
+
+		mergedProperty := *property
+		mergedProperty.Qualifier = NoQualifier
+
+		switch property.Qualifier {
+		case AppendQualifier:
+			value, err := evaluateOperator(merged[i].Value, property.Value, '+',
+				property.Pos)
+			if err != nil {
+				errs = append(errs, &ParseError{Err: err, Pos: property.Pos})
+				continue
+			}
+			mergedProperty.Value = value
+		case NoQualifier:
+			if isMergeableMap(merged[i].Value) && isMergeableMap(property.Value) {
+				properties, newErrs := MergeProperties(merged[i].Value.MapValue,
+					property.Value.MapValue)
+				if len(newErrs) > 0 {
+					errs = append(errs, newErrs...)
+					continue
+				}
+				mergedProperty.Value.MapValue = properties
+				mergedProperty.Value.Variable = ""
+			}
+		}
+
+		merged[i] = &mergedProperty
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return merged, nil
+}
+
+// isMergeableMap returns true if value is a map whose properties can be
+// merged with those of another map.
+func isMergeableMap(value Value) bool {
+	return value.Type == Map && value.Expression == nil && value.Select == nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var mergePropertiesTestCases = []struct {
+	base     string
+	overlay  string
+	expected map[string]interface{}
+	err      string
+}{
+	{
+		base:    `foo { srcs: ["a.c"], cflags: ["-O2"], name: "foo" }`,
+		overlay: `foo { srcs +: ["b.c"], cflags: ["-O0"], stem: "bar" }`,
+		expected: map[string]interface{}{
+			"srcs":   []interface{}{"a.c", "b.c"},
+			"cflags": []interface{}{"-O0"},
+			"name":   "foo",
+			"stem":   "bar",
+		},
+	},
+	{
+		base: `foo {
+			target: {
+				host: { srcs: ["a.c"], cflags: ["-O2"] },
+				arm: { srcs: ["arm.c"] },
+			},
+		}`,
+		overlay: `foo {
+			target: {
+				host: { srcs +: ["b.c"] },
+				arm =: { cflags: ["-marm"] },
+			},
+		}`,
+		expected: map[string]interface{}{
+			"target": map[string]interface{}{
+				"host": map[string]interface{}{
+					"srcs":   []interface{}{"a.c", "b.c"},
+					"cflags": []interface{}{"-O2"},
+				},
+				"arm": map[string]interface{}{
+					"cflags": []interface{}{"-marm"},
+				},
+			},
+		},
+	},
+	{
+		base:     `foo { name: "foo" }`,
+		overlay:  `foo { name +: "_vendor" }`,
+		expected: map[string]interface{}{"name": "foo_vendor"},
+	},
+	{
+		base:    `foo { srcs: ["a.c"] }`,
+		overlay: `foo { srcs +: "b.c" }`,
+		err:     `<input>:1:12: mismatched type in operator +: list != string`,
+	},
+}
+
+func parseModuleProperties(t *testing.T, input string) []*Property {
+	file, errs := ParseAndEval("", bytes.NewBufferString(input), NewScope(nil))
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors parsing %s: %v", input, errs)
+	}
+	return file.Defs[0].(*Module).Properties
+}
+
+func TestMergeProperties(t *testing.T) {
+	for _, testCase := range mergePropertiesTestCases {
+		base := parseModuleProperties(t, testCase.base)
+		overlay := parseModuleProperties(t, testCase.overlay)
+
+		merged, errs := MergeProperties(base, overlay)
+
+		if testCase.err != "" {
+			if len(errs) != 1 || errs[0].Error() != testCase.err {
+				t.Errorf("test case: %s + %s", testCase.base, testCase.overlay)
+				t.Errorf("  expected error %q, got %v", testCase.err, errs)
+			}
+			continue
+		}
+		if len(errs) > 0 {
+			t.Errorf("test case: %s + %s", testCase.base, testCase.overlay)
+			t.Errorf("  unexpected errors: %v", errs)
+			continue
+		}
+
+		if got := plainProperties(merged); !reflect.DeepEqual(got, testCase.expected) {
+			t.Errorf("test case: %s + %s", testCase.base, testCase.overlay)
+			t.Errorf("  expected: %#v", testCase.expected)
+			t.Errorf("       got: %#v", got)
+		}
+		for _, property := range merged {
+			if property.Qualifier != NoQualifier {
+				t.Errorf("test case: %s + %s", testCase.base, testCase.overlay)
+				t.Errorf("  property %q has qualifier %q", property.Name.Name,
+					property.Qualifier)
+			}
+		}
+	}
+}
+
+func TestParseQualifierErrors(t *testing.T) {
+	testCases := []struct {
+		input string
+		err   string
+	}{
+		{`foo { srcs +: }`, `<input>:1:15: expected bool, list, or string value`},
+		{`foo { srcs += ["a"] }`, `<input>:1:13: expected ":", found "="`},
+		{`foo { a: { srcs = ["a"] } }`, `<input>:1:17: expected ":", found "="`},
+		{`foo ( srcs +: ["a"] )`, `<input>:1:12: expected "=", found "+"`},
+	}
+
+	for _, testCase := range testCases {
+		_, errs := Parse("", bytes.NewBufferString(testCase.input), NewScope(nil))
+		if len(errs) == 0 || !bytes.HasPrefix([]byte(errs[0].Error()), []byte(testCase.err)) {
+			t.Errorf("test case: %s", testCase.input)
+			t.Errorf("  expected error starting with %q, got %v", testCase.err, errs)
+		}
+	}
+}
@@ -14,6 +14,8 @@
 
 package parser
 
+import "text/scanner"
+
 func AddStringToList(value *Value, s string) (modified bool) {
 	if value.Type != List {
 		panic("expected list value, got " + value.Type.String())
@@ -59,3 +61,161 @@ func RemoveStringFromList(value *Value, s string) (modified bool) {
 
 	return false
 }
+
+// FindModule returns the module in file whose "name" property is name, or nil
+// if there is no such module.
+func FindModule(file *File, name string) *Module {
+	for _, def := range file.Defs {
+		if module, ok := def.(*Module); ok && moduleName(module) == name {
+			return module
+		}
+	}
+
+	return nil
+}
+
+// AddModule appends module to the definitions in file.  The positions of the
+// module and the comments attached to it are moved to after the end of the
+// file, so module may come from parsing a different file.
+func AddModule(file *File, module *Module) {
+	end := fileEnd(file)
+
+	// Leave a blank line between the end of the file and the module.
+	start := startPos(module)
+	lines := end.Line + 2 - start.Line
+	offset := end.Offset + 2 - start.Offset
+	walkPositions(module, func(pos *scanner.Position) {
+		pos.Line += lines
+		pos.Offset += offset
+	})
+
+	file.Defs = append(file.Defs, module)
+}
+
+// RemoveModule removes the module whose "name" property is name from file,
+// along with the comments attached to it and any comments inside of it.
+func RemoveModule(file *File, name string) (modified bool) {
+	for i, def := range file.Defs {
+		module, ok := def.(*Module)
+		if !ok || moduleName(module) != name {
+			continue
+		}
+
+		file.Defs = append(file.Defs[:i], file.Defs[i+1:]...)
+
+		start, end := startPos(module).Offset, module.RbracePos.Offset
+		var comments []Comment
+		for _, c := range file.Comments {
+			if c.Pos.Offset < start || c.Pos.Offset > end {
+				comments = append(comments, c)
+			}
+		}
+		file.Comments = comments
+
+		return true
+	}
+
+	return false
+}
+
+func moduleName(module *Module) string {
+	for _, prop := range module.Properties {
+		if prop.Name.Name == "name" && prop.Value.Type == String {
+			return prop.Value.StringValue
+		}
+	}
+
+	return ""
+}
+
+// startPos returns the position of the first comment attached to module, or
+// of its type if there are no comments.
+func startPos(module *Module) scanner.Position {
+	if len(module.Comments) > 0 {
+		return module.Comments[0].Pos
+	}
+	return module.Type.Pos
+}
+
+// fileEnd returns the position of the end of the last definition or comment
+// in file.
+func fileEnd(file *File) scanner.Position {
+	var end scanner.Position
+	update := func(pos *scanner.Position) {
+		if pos.Offset > end.Offset {
+			end.Offset = pos.Offset
+		}
+		if pos.Line > end.Line {
+			end.Line = pos.Line
+		}
+	}
+
+	for _, def := range file.Defs {
+		walkPositions(def, update)
+	}
+	for _, c := range file.Comments {
+		pos := c.Pos
+		pos.Line = c.endLine()
+		update(&pos)
+	}
+
+	return end
+}
+
+// walkPositions calls f with a pointer to every valid position in def,
+// including the positions of the comments attached to it.  Only the original
+// value of an assignment is walked, as it is the one that is printed.
+func walkPositions(def Definition, visit func(pos *scanner.Position)) {
+	f := func(pos *scanner.Position) {
+		if pos.IsValid() {
+			visit(pos)
+		}
+	}
+
+	walkComments := func(comments []Comment, lineComment *Comment) {
+		for i := range comments {
+			f(&comments[i].Pos)
+		}
+		if lineComment != nil {
+			f(&lineComment.Pos)
+		}
+	}
+
+	var walkValue func(value *Value)
+	walkProperties := func(properties []*Property) {
+		for _, prop := range properties {
+			walkComments(prop.Comments, prop.LineComment)
+			f(&prop.Name.Pos)
+			f(&prop.Pos)
+			walkValue(&prop.Value)
+		}
+	}
+	walkValue = func(value *Value) {
+		walkComments(value.Comments, value.LineComment)
+		f(&value.Pos)
+		f(&value.EndPos)
+		if value.Expression != nil {
+			f(&value.Expression.Pos)
+			walkValue(&value.Expression.Args[0])
+			walkValue(&value.Expression.Args[1])
+		}
+		for i := range value.ListValue {
+			walkValue(&value.ListValue[i])
+		}
+		walkProperties(value.MapValue)
+	}
+
+	switch def := def.(type) {
+	case *Module:
+		walkComments(def.Comments, def.LineComment)
+		f(&def.Type.Pos)
+		f(&def.LbracePos)
+		f(&def.RbracePos)
+		walkProperties(def.Properties)
+	case *Assignment:
+		walkComments(def.Comments, def.LineComment)
+		f(&def.Name.Pos)
+		f(&def.Pos)
+		walkValue(&def.OrigValue)
+	}
+}
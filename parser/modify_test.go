@@ -0,0 +1,117 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"bytes"
+	"testing"
+)
+
+const modifyTestInput = `
+// The foo library.
+foo {
+    name: "foo",
+    srcs: ["foo.c"], // main
+}
+
+// The bar library.
+bar {
+    name: "bar",
+    // nothing yet
+}
+
+// Trailing comment
+`
+
+func parseModifyTest(t *testing.T, input string) *File {
+	file, errs := Parse("", bytes.NewBufferString(input), NewScope(nil))
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	return file
+}
+
+func checkModifyTest(t *testing.T, file *File, expected string) {
+	got, err := Print(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if string(got) != expected {
+		t.Errorf("  expected: %s", expected)
+		t.Errorf("       got: %s", string(got))
+	}
+}
+
+func TestRemoveModule(t *testing.T) {
+	file := parseModifyTest(t, modifyTestInput[1:])
+
+	if RemoveModule(file, "baz") {
+		t.Errorf("expected removing missing module to do nothing")
+	}
+
+	if !RemoveModule(file, "bar") {
+		t.Fatalf("expected bar to be removed")
+	}
+	if FindModule(file, "bar") != nil {
+		t.Errorf("expected bar to be missing")
+	}
+
+	checkModifyTest(t, file, `// The foo library.
+foo {
+    name: "foo",
+    srcs: ["foo.c"], // main
+}
+
+// Trailing comment
+`)
+
+	if !RemoveModule(file, "foo") {
+		t.Fatalf("expected foo to be removed")
+	}
+
+	checkModifyTest(t, file, `// Trailing comment
+`)
+}
+
+func TestAddModule(t *testing.T) {
+	file := parseModifyTest(t, modifyTestInput[1:])
+	newFile := parseModifyTest(t, `
+// The baz library.
+baz {
+    name: "baz",
+    deps: [
+        // Needed for foo.h.
+        "foo",
+    ],
+}
+`)
+
+	AddModule(file, newFile.Defs[0].(*Module))
+	if FindModule(file, "baz") == nil {
+		t.Errorf("expected baz to be found")
+	}
+
+	checkModifyTest(t, file, modifyTestInput[1:]+`
+// The baz library.
+baz {
+    name: "baz",
+    deps: [
+        // Needed for foo.h.
+        "foo",
+    ],
+}
+`)
+}
@@ -38,7 +38,10 @@ func (e *ParseError) Error() string {
 }
 
 type File struct {
-	Defs     []Definition
+	Defs []Definition
+
+	// Comments are the comments that aren't attached to any definition,
+	// property or list element, in the order that they appear in the file.
 	Comments []Comment
 }
 
@@ -56,7 +59,13 @@ func parse(p *parser) (file *File, errs []error) {
 	defs := p.parseDefinitions()
 	p.accept(scanner.EOF)
 	errs = p.errors
-	comments := p.comments
+
+	var comments []Comment
+	for i, comment := range p.comments {
+		if !p.attached[i] {
+			comments = append(comments, comment)
+		}
+	}
 
 	return &File{
 		Defs:     defs,
@@ -87,11 +96,25 @@ type parser struct {
 	scope    *Scope
 	comments []Comment
 	eval     bool
+	arena    nodeArena
+
+	// attached[i] is true if p.comments[i] has been attached to a node.
+	attached []bool
+
+	// endLine is the line on which the last token or comment ended.
+	endLine int
+
+	// leading holds the indexes in p.comments of the comments on the lines
+	// directly above the current token, and trailing the index of the
+	// comment at the end of the line of the previous token, or -1.
+	leading  []int
+	trailing int
 }
 
 func newParser(r io.Reader, scope *Scope) *parser {
 	p := &parser{}
 	p.scope = scope
+	p.trailing = -1
 	p.scanner.Init(r)
 	p.scanner.Error = func(sc *scanner.Scanner, msg string) {
 		p.errorf(msg)
@@ -107,6 +130,11 @@ func (p *parser) errorf(format string, args ...interface{}) {
 	if !pos.IsValid() {
 		pos = p.scanner.Pos()
 	}
+	p.errorfAt(pos, format, args...)
+}
+
+// errorfAt reports an error at pos, rather than at the current token.
+func (p *parser) errorfAt(pos scanner.Position, format string, args ...interface{}) {
 	err := &ParseError{
 		Err: fmt.Errorf(format, args...),
 		Pos: pos,
@@ -131,33 +159,110 @@ func (p *parser) accept(toks ...rune) bool {
 
 func (p *parser) next() {
 	if p.tok != scanner.EOF {
+		p.leading = nil
+		p.trailing = -1
+		afterToken := true
+
 		p.tok = p.scanner.Scan()
 		for p.tok == scanner.Comment {
 			lines := strings.Split(p.scanner.TokenText(), "\n")
-			p.comments = append(p.comments, Comment{lines, p.scanner.Position})
+			comment := Comment{lines, p.scanner.Position}
+
+			// A comment that starts its own line may be the beginning or the
+			// continuation of a group of comments above the next token.  The
+			// only comment at the end of the line of the previous token may
+			// belong to that token.
+			i := len(p.comments)
+			if comment.Pos.Line > p.endLine {
+				if len(p.leading) == 0 || comment.Pos.Line > p.endLine+1 {
+					p.leading = nil
+				}
+				p.leading = append(p.leading, i)
+			} else {
+				p.leading = nil
+				if afterToken && len(lines) == 1 {
+					p.trailing = i
+				} else {
+					p.trailing = -1
+				}
+			}
+
+			p.comments = append(p.comments, comment)
+			p.attached = append(p.attached, false)
+			p.endLine = comment.endLine()
+			afterToken = false
+
 			p.tok = p.scanner.Scan()
 		}
+
+		line := p.scanner.Position.Line
+		if len(p.leading) > 0 && p.endLine != line-1 {
+			// The comments are separated from the token by a blank line,
+			// or are on the same line as it.
+			p.leading = nil
+		}
+		if p.trailing != -1 && p.comments[p.trailing].Pos.Line == line &&
+			p.tok != scanner.EOF {
+			// The comment is followed by another token on the same line.
+			p.trailing = -1
+		}
+		p.endLine = p.scanner.Pos().Line
 	}
 	return
 }
 
+// leadingComments attaches the group of comments on the lines directly above
+// the current token to the node that it starts, and returns them.
+func (p *parser) leadingComments() []Comment {
+	var comments []Comment
+	for _, i := range p.leading {
+		comments = append(comments, p.comments[i])
+		p.attached[i] = true
+	}
+	p.leading = nil
+	return comments
+}
+
+// lineComment attaches the comment at the end of the line of the last
+// token to the node that the token ends, and returns it, or returns nil if
+// there is no such comment.
+func (p *parser) lineComment() *Comment {
+	if p.trailing == -1 {
+		return nil
+	}
+	comment := p.comments[p.trailing]
+	p.attached[p.trailing] = true
+	p.trailing = -1
+	return &comment
+}
+
 func (p *parser) parseDefinitions() (defs []Definition) {
 	for {
 		switch p.tok {
 		case scanner.Ident:
 			ident := p.scanner.TokenText()
 			pos := p.scanner.Position
+			comments := p.leadingComments()
 
 			p.accept(scanner.Ident)
 
 			switch p.tok {
 			case '+':
 				p.accept('+')
-				defs = append(defs, p.parseAssignment(ident, pos, "+="))
+				assignment := p.parseAssignment(ident, pos, "+=")
+				assignment.Comments = comments
+				assignment.LineComment = p.lineComment()
+				defs = append(defs, assignment)
 			case '=':
-				defs = append(defs, p.parseAssignment(ident, pos, "="))
+				assignment := p.parseAssignment(ident, pos, "=")
+				assignment.Comments = comments
+				assignment.LineComment = p.lineComment()
+				defs = append(defs, assignment)
 			case '{', '(':
-				defs = append(defs, p.parseModule(ident, pos))
+				module := p.parseModule(ident, pos)
+				module.Comments = comments
+				module.LineComment = p.lineComment()
+				defs = append(defs, module)
 			default:
 				p.errorf("expected \"=\" or \"+=\" or \"{\" or \"(\", found %s",
 					scanner.TokenString(p.tok))
@@ -175,7 +280,7 @@ func (p *parser) parseDefinitions() (defs []Definition) {
 func (p *parser) parseAssignment(name string,
 	namePos scanner.Position, assigner string) (assignment *Assignment) {
 
-	assignment = new(Assignment)
+	assignment = p.arena.newAssignment()
 
 	pos := p.scanner.Position
 	if !p.accept('=') {
@@ -212,7 +317,7 @@ func (p *parser) parseAssignment(name string,
 func (p *parser) parseModule(typ string,
 	typPos scanner.Position) (module *Module) {
 
-	module = new(Module)
+	module = p.arena.newModule()
 	compat := false
 	lbracePos := p.scanner.Position
 	if p.tok == '{' {
@@ -238,38 +343,71 @@ func (p *parser) parseModule(typ string,
 }
 
 func (p *parser) parsePropertyList(isModule, compat bool) (properties []*Property) {
-	for p.tok == scanner.Ident {
+	// The names of the properties in a map value may be quoted, so that they
+	// can be arbitrary strings.
+	for p.tok == scanner.Ident || (!isModule && p.tok == scanner.String) {
+		comments := p.leadingComments()
 		property := p.parseProperty(isModule, compat)
+		property.Comments = comments
 		properties = append(properties, property)
 
 		if p.tok != ',' {
 			// There was no comma, so the list is done.
+			property.LineComment = p.lineComment()
 			break
 		}
 
 		p.accept(',')
+		property.LineComment = p.lineComment()
 	}
 
 	return
 }
 
 func (p *parser) parseProperty(isModule, compat bool) (property *Property) {
-	property = new(Property)
+	property = p.arena.newProperty()
 
 	name := p.scanner.TokenText()
 	namePos := p.scanner.Position
-	p.accept(scanner.Ident)
+	if p.tok == scanner.String {
+		var err error
+		name, err = strconv.Unquote(name)
+		if err != nil {
+			p.errorf("couldn't parse property name: %s", err)
+		}
+		p.accept(scanner.String)
+	} else {
+		p.accept(scanner.Ident)
+	}
 	pos := p.scanner.Position
 
-	if isModule {
-		if compat && p.tok == ':' {
+	// Properties that are separated from their values by a colon may have a
+	// qualifier before the colon.
+	colon := !isModule || compat
+	qualifier := NoQualifier
+
+	switch {
+	case colon && p.tok == '+':
+		p.accept('+')
+		qualifier = AppendQualifier
+		if !p.accept(':') {
+			return
+		}
+	case colon && p.tok == '=':
+		eqPos := p.scanner.Position
+		p.accept('=')
+		if p.tok == ':' {
 			p.accept(':')
-		} else {
-			if !p.accept('=') {
-				return
-			}
+			qualifier = ReplaceQualifier
+		} else if !isModule {
+			p.errorfAt(eqPos, "expected \":\", found \"=\"")
+			return
 		}
-	} else {
+	case isModule && !compat:
+		if !p.accept('=') {
+			return
+		}
+	default:
 		if !p.accept(':') {
 			return
 		}
@@ -278,6 +416,7 @@ func (p *parser) parseProperty(isModule, compat bool) (property *Property) {
 	value := p.parseExpression()
 
 	property.Name = Ident{name, namePos}
+	property.Qualifier = qualifier
 	property.Value = value
 	property.Pos = pos
 
@@ -297,17 +436,40 @@ func (p *parser) parseExpression() (value Value) {
 func (p *parser) evaluateOperator(value1, value2 Value, operator rune,
 	pos scanner.Position) (Value, error) {
 
-	value := Value{}
-
 	if p.eval {
-		if value1.Type != value2.Type {
-			return Value{}, fmt.Errorf("mismatched type in operator %c: %s != %s", operator,
-				value1.Type, value2.Type)
-		}
+		return evaluateOperator(value1, value2, operator, pos)
+	}
+
+	return Value{
+		Expression: &Expression{
+			Args:     [2]Value{value1, value2},
+			Operator: operator,
+			Pos:      pos,
+		},
+	}, nil
+}
+
+// evaluateOperator returns the value of the expression applying operator to
+// value1 and value2.  If either of them contains a select expression, the
+// result can't be computed until the selects are resolved, so only its type
+// is set.
+func evaluateOperator(value1, value2 Value, operator rune,
+	pos scanner.Position) (Value, error) {
 
-		value = value1
-		value.Variable = ""
+	if value1.Type != value2.Type {
+		return Value{}, fmt.Errorf("mismatched type in operator %c: %s != %s", operator,
+			value1.Type, value2.Type)
+	}
+
+	value := value1
+	value.Variable = ""
 
+	if value1.HasSelect() || value2.HasSelect() {
+		value = Value{
+			Type: value1.Type,
+			Pos:  value1.Pos,
+		}
+	} else {
 		switch operator {
 		case '+':
 			switch value1.Type {
@@ -318,7 +480,7 @@ func (p *parser) evaluateOperator(value1, value2 Value, operator rune,
 				value.ListValue = append(value.ListValue, value2.ListValue...)
 			case Map:
 				var err error
-				value.MapValue, err = p.addMaps(value.MapValue, value2.MapValue, pos)
+				value.MapValue, err = addMaps(value.MapValue, value2.MapValue, pos)
 				if err != nil {
 					return Value{}, err
 				}
@@ -340,7 +502,7 @@ func (p *parser) evaluateOperator(value1, value2 Value, operator rune,
 	return value, nil
 }
 
-func (p *parser) addMaps(map1, map2 []*Property, pos scanner.Position) ([]*Property, error) {
+func addMaps(map1, map2 []*Property, pos scanner.Position) ([]*Property, error) {
 	ret := make([]*Property, 0, len(map1))
 
 	inMap1 := make(map[string]*Property)
@@ -362,7 +524,7 @@ func (p *parser) addMaps(map1, map2 []*Property, pos scanner.Position) ([]*Prope
 		if prop2, ok := inBoth[prop1.Name.Name]; ok {
 			var err error
 			newProp := *prop1
-			newProp.Value, err = p.evaluateOperator(prop1.Value, prop2.Value, '+', pos)
+			newProp.Value, err = evaluateOperator(prop1.Value, prop2.Value, '+', pos)
 			if err != nil {
 				return nil, err
 			}
@@ -400,6 +562,9 @@ func (p *parser) parseOperator(value1 Value) Value {
 func (p *parser) parseValue() (value Value) {
 	switch p.tok {
 	case scanner.Ident:
+		if p.scanner.TokenText() == "select" {
+			return p.parseSelect()
+		}
 		return p.parseVariable()
 	case scanner.String:
 		return p.parseStringValue()
@@ -462,19 +627,24 @@ func (p *parser) parseListValue() (value Value) {
 
 	var elements []Value
 	for p.tok != ']' {
+		comments := p.leadingComments()
 		element := p.parseExpression()
 		if p.eval && element.Type != String {
 			p.errorf("Expected string in list, found %s", element.String())
 			return
 		}
-		elements = append(elements, element)
+		element.Comments = comments
 
 		if p.tok != ',' {
 			// There was no comma, so the list is done.
+			element.LineComment = p.lineComment()
+			elements = append(elements, element)
 			break
 		}
 
 		p.accept(',')
+		element.LineComment = p.lineComment()
+		elements = append(elements, element)
 	}
 
 	value.ListValue = elements
@@ -499,6 +669,62 @@ func (p *parser) parseMapValue() (value Value) {
 	return
 }
 
+// parseSelect parses a select expression:
+//
+//	select(axis, {
+//	    "value": <value for the value of the axis>,
+//	    default: <value for the other values>,
+//	})
+func (p *parser) parseSelect() (value Value) {
+	pos := p.scanner.Position
+	if !p.accept(scanner.Ident, '(') {
+		return
+	}
+
+	axis := Ident{p.scanner.TokenText(), p.scanner.Position}
+	if !p.accept(scanner.Ident, ',') {
+		return
+	}
+
+	cases := p.parseMapValue()
+	rparenPos := p.scanner.Position
+	if !p.accept(')') {
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range cases.MapValue {
+		if seen[c.Name.Name] {
+			p.errorfAt(c.Name.Pos, "duplicate case %q in select on %q", c.Name.Name, axis.Name)
+			return
+		}
+		seen[c.Name.Name] = true
+	}
+
+	if p.eval {
+		if len(cases.MapValue) == 0 {
+			p.errorfAt(pos, "select on %q has no cases", axis.Name)
+			return
+		}
+		value.Type = cases.MapValue[0].Value.Type
+		for _, c := range cases.MapValue[1:] {
+			if c.Value.Type != value.Type {
+				p.errorfAt(c.Value.Pos, "mismatched type in select on %q: %s != %s", axis.Name,
+					value.Type, c.Value.Type)
+				return
+			}
+		}
+	}
+
+	value.Pos = pos
+	value.Select = &Select{
+		Axis:      axis,
+		Cases:     cases,
+		RparenPos: rparenPos,
+	}
+	return
+}
+
 type Expression struct {
 	Args     [2]Value
 	Operator rune
@@ -510,6 +736,20 @@ func (e *Expression) String() string {
 		e.Pos.Offset, e.Pos)
 }
 
+// A Select is a select expression, whose value is the value of the case named
+// by the value of its axis, or of the "default" case if there is no such case.
+// The values of the axes are supplied by the primary builder, so the value of
+// a select expression is only known once it is resolved by ResolveSelects.
+type Select struct {
+	Axis      Ident
+	Cases     Value // A map value from the values of the axis to the values.
+	RparenPos scanner.Position
+}
+
+func (s *Select) String() string {
+	return fmt.Sprintf("select(%s, %s)", s.Axis, s.Cases)
+}
+
 type ValueType int
 
 const (
@@ -540,12 +780,14 @@ type Definition interface {
 }
 
 type Assignment struct {
-	Name       Ident
-	Value      Value
-	OrigValue  Value
-	Pos        scanner.Position
-	Assigner   string
-	Referenced bool
+	Name        Ident
+	Value       Value
+	OrigValue   Value
+	Pos         scanner.Position
+	Assigner    string
+	Referenced  bool
+	Comments    []Comment // The comments on the lines directly above the assignment.
+	LineComment *Comment  // The comment at the end of the assignment's last line.
 }
 
 func (a *Assignment) String() string {
@@ -555,10 +797,12 @@ func (a *Assignment) String() string {
 func (a *Assignment) definitionTag() {}
 
 type Module struct {
-	Type       Ident
-	Properties []*Property
-	LbracePos  scanner.Position
-	RbracePos  scanner.Position
+	Type        Ident
+	Properties  []*Property
+	LbracePos   scanner.Position
+	RbracePos   scanner.Position
+	Comments    []Comment // The comments on the lines directly above the module.
+	LineComment *Comment  // The comment at the end of the module's last line.
 }
 
 func (m *Module) String() string {
@@ -575,15 +819,51 @@ func (m *Module) String() string {
 func (m *Module) definitionTag() {}
 
 type Property struct {
-	Name  Ident
-	Value Value
-	Pos   scanner.Position
+	Name        Ident
+	Qualifier   Qualifier
+	Value       Value
+	Pos         scanner.Position
+	Comments    []Comment // The comments on the lines directly above the property.
+	LineComment *Comment  // The comment at the end of the property's last line.
 }
 
 func (p *Property) String() string {
 	return fmt.Sprintf("%s@%d:%s: %s", p.Name, p.Pos.Offset, p.Pos, p.Value)
 }
 
+// A Qualifier is written between the name of a property and the colon that
+// separates it from its value, and selects how the value is combined with the
+// value of the same property in the definition of a module that it overrides
+// by MergeProperties.
+type Qualifier int
+
+const (
+	// NoQualifier replaces the overridden value, except that the properties
+	// of map values are merged: "name: value".
+	NoQualifier Qualifier = iota
+
+	// AppendQualifier appends the value to the overridden value, like the +
+	// operator: "name +: value".
+	AppendQualifier
+
+	// ReplaceQualifier replaces the overridden value, even if it is a map:
+	// "name =: value".
+	ReplaceQualifier
+)
+
+func (q Qualifier) String() string {
+	switch q {
+	case NoQualifier:
+		return ""
+	case AppendQualifier:
+		return "+"
+	case ReplaceQualifier:
+		return "="
+	default:
+		panic(fmt.Errorf("unknown qualifier %d", int(q)))
+	}
+}
+
 type Ident struct {
 	Name string
 	Pos  scanner.Position
@@ -600,9 +880,16 @@ type Value struct {
 	ListValue   []Value
 	MapValue    []*Property
 	Expression  *Expression
+	Select      *Select
 	Variable    string
 	Pos         scanner.Position
 	EndPos      scanner.Position
+
+	// Comments and LineComment are the comments on the lines directly above a
+	// list element and at the end of its last line.  They are only set on the
+	// elements of lists.
+	Comments    []Comment
+	LineComment *Comment
 }
 
 func (p Value) String() string {
@@ -613,6 +900,9 @@ func (p Value) String() string {
 	if p.Expression != nil {
 		s += p.Expression.String()
 	}
+	if p.Select != nil {
+		return s + p.Select.String()
+	}
 	switch p.Type {
 	case Bool:
 		s += fmt.Sprintf("%t@%d:%s", p.BoolValue, p.Pos.Offset, p.Pos)
@@ -700,3 +990,8 @@ type Comment struct {
 	Comment []string
 	Pos     scanner.Position
 }
+
+// endLine returns the line on which the comment ends.
+func (c Comment) endLine() int {
+	return c.Pos.Line + len(c.Comment) - 1
+}
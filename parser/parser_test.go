@@ -16,6 +16,7 @@ package parser
 
 import (
 	"bytes"
+	"fmt"
 	"reflect"
 	"testing"
 	"text/scanner"
@@ -202,6 +203,55 @@ var validParseTestCases = []struct {
 		nil,
 	},
 
+	{`
+		foo {
+			stuff: {
+				"a-b": "c",
+				d: "e"
+			}
+		}
+		`,
+		[]Definition{
+			&Module{
+				Type:      Ident{"foo", mkpos(3, 2, 3)},
+				LbracePos: mkpos(7, 2, 7),
+				RbracePos: mkpos(55, 7, 3),
+				Properties: []*Property{
+					{
+						Name: Ident{"stuff", mkpos(12, 3, 4)},
+						Pos:  mkpos(17, 3, 9),
+						Value: Value{
+							Type:   Map,
+							Pos:    mkpos(19, 3, 11),
+							EndPos: mkpos(51, 6, 4),
+							MapValue: []*Property{
+								{
+									Name: Ident{"a-b", mkpos(25, 4, 5)},
+									Pos:  mkpos(30, 4, 10),
+									Value: Value{
+										Type:        String,
+										Pos:         mkpos(32, 4, 12),
+										StringValue: "c",
+									},
+								},
+								{
+									Name: Ident{"d", mkpos(41, 5, 5)},
+									Pos:  mkpos(42, 5, 6),
+									Value: Value{
+										Type:        String,
+										Pos:         mkpos(44, 5, 8),
+										StringValue: "e",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		nil,
+	},
+
 	{`
 		// comment1
 		foo {
@@ -223,8 +273,87 @@ var validParseTestCases = []struct {
 							Pos:       mkpos(49, 5, 12),
 							BoolValue: true,
 						},
+						Comments: []Comment{
+							Comment{
+								Comment: []string{"// comment2"},
+								Pos:     mkpos(26, 4, 4),
+							},
+						},
+						LineComment: &Comment{
+							Comment: []string{"// comment3"},
+							Pos:     mkpos(56, 5, 19),
+						},
+					},
+				},
+				Comments: []Comment{
+					Comment{
+						Comment: []string{"// comment1"},
+						Pos:     mkpos(3, 2, 3),
+					},
+				},
+			},
+		},
+		nil,
+	},
+
+	{`
+		// comment1
+
+		foo {
+			srcs: [
+				// comment2
+				"a", // comment3
+				"b" /* comment4 */, "c",
+			],
+		} // comment5
+		`,
+		[]Definition{
+			&Module{
+				Type:      Ident{"foo", mkpos(18, 4, 3)},
+				LbracePos: mkpos(22, 4, 7),
+				RbracePos: mkpos(109, 10, 3),
+				Properties: []*Property{
+					{
+						Name: Ident{"srcs", mkpos(27, 5, 4)},
+						Pos:  mkpos(31, 5, 8),
+						Value: Value{
+							Type: List,
+							Pos:  mkpos(33, 5, 10),
+							ListValue: []Value{
+								Value{
+									Type:        String,
+									Pos:         mkpos(55, 7, 5),
+									StringValue: "a",
+									Comments: []Comment{
+										Comment{
+											Comment: []string{"// comment2"},
+											Pos:     mkpos(39, 6, 5),
+										},
+									},
+									LineComment: &Comment{
+										Comment: []string{"// comment3"},
+										Pos:     mkpos(60, 7, 10),
+									},
+								},
+								Value{
+									Type:        String,
+									Pos:         mkpos(76, 8, 5),
+									StringValue: "b",
+								},
+								Value{
+									Type:        String,
+									Pos:         mkpos(96, 8, 25),
+									StringValue: "c",
+								},
+							},
+							EndPos: mkpos(104, 9, 4),
+						},
 					},
 				},
+				LineComment: &Comment{
+					Comment: []string{"// comment5"},
+					Pos:     mkpos(111, 10, 5),
+				},
 			},
 		},
 		[]Comment{
@@ -233,12 +362,8 @@ var validParseTestCases = []struct {
 				Pos:     mkpos(3, 2, 3),
 			},
 			Comment{
-				Comment: []string{"// comment2"},
-				Pos:     mkpos(26, 4, 4),
-			},
-			Comment{
-				Comment: []string{"// comment3"},
-				Pos:     mkpos(56, 5, 19),
+				Comment: []string{"/* comment4 */"},
+				Pos:     mkpos(80, 8, 9),
 			},
 		},
 	},
@@ -520,3 +645,32 @@ func TestParseValidInput(t *testing.T) {
 }
 
 // TODO: Test error strings
+
+func BenchmarkParse(b *testing.B) {
+	buf := bytes.NewBuffer(nil)
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(buf, `
+			cc_library {
+				name: "lib%d",
+				srcs: ["a.c", "b.c", "c.c"],
+				cflags: ["-O2", "-Wall"],
+				shared: true,
+				arch: {
+					arm: { srcs: ["arm.c"] },
+					x86: { srcs: ["x86.c"] },
+				},
+			}
+		`, i)
+	}
+	input := buf.Bytes()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_, errs := Parse("Blueprints", bytes.NewReader(input), NewScope(nil))
+		if len(errs) > 0 {
+			b.Fatalf("unexpected errors: %v", errs)
+		}
+	}
+}
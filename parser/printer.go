@@ -87,17 +87,21 @@ func (p *printer) printDef(def Definition) {
 }
 
 func (p *printer) printAssignment(assignment *Assignment) {
+	p.printComments(assignment.Comments)
 	p.printToken(assignment.Name.Name, assignment.Name.Pos)
 	p.requestSpace()
 	p.printToken(assignment.Assigner, assignment.Pos)
 	p.requestSpace()
 	p.printValue(assignment.OrigValue)
+	p.printLineComment(assignment.LineComment)
 	p.requestNewline()
 }
 
 func (p *printer) printModule(module *Module) {
+	p.printComments(module.Comments)
 	p.printToken(module.Type.Name, module.Type.Pos)
-	p.printMap(module.Properties, module.LbracePos, module.RbracePos)
+	p.printMap(module.Properties, module.LbracePos, module.RbracePos, p.printProperty)
+	p.printLineComment(module.LineComment)
 	p.requestDoubleNewline()
 }
 
@@ -106,6 +110,8 @@ func (p *printer) printValue(value Value) {
 		p.printToken(value.Variable, value.Pos)
 	} else if value.Expression != nil {
 		p.printExpression(*value.Expression)
+	} else if value.Select != nil {
+		p.printSelect(value.Select, value.Pos)
 	} else {
 		switch value.Type {
 		case Bool:
@@ -121,7 +127,7 @@ func (p *printer) printValue(value Value) {
 		case List:
 			p.printList(value.ListValue, value.Pos, value.EndPos)
 		case Map:
-			p.printMap(value.MapValue, value.Pos, value.EndPos)
+			p.printMap(value.MapValue, value.Pos, value.EndPos, p.printProperty)
 		default:
 			panic(fmt.Errorf("bad property type: %d", value.Type))
 		}
@@ -135,8 +141,10 @@ func (p *printer) printList(list []Value, pos, endPos scanner.Position) {
 		p.requestNewline()
 		p.indent(p.curIndent() + 4)
 		for _, value := range list {
+			p.printComments(value.Comments)
 			p.printValue(value)
 			p.printToken(",", noPos)
+			p.printLineComment(value.LineComment)
 			p.requestNewline()
 		}
 		p.unindent(endPos)
@@ -148,15 +156,18 @@ func (p *printer) printList(list []Value, pos, endPos scanner.Position) {
 	p.printToken("]", endPos)
 }
 
-func (p *printer) printMap(list []*Property, pos, endPos scanner.Position) {
+func (p *printer) printMap(list []*Property, pos, endPos scanner.Position,
+	printProperty func(*Property)) {
+
 	p.requestSpace()
 	p.printToken("{", pos)
 	if len(list) > 0 || pos.Line != endPos.Line {
 		p.requestNewline()
 		p.indent(p.curIndent() + 4)
 		for _, prop := range list {
-			p.printProperty(prop)
+			printProperty(prop)
 			p.printToken(",", noPos)
+			p.printLineComment(prop.LineComment)
 			p.requestNewline()
 		}
 		p.unindent(endPos)
@@ -176,9 +187,41 @@ func (p *printer) printExpression(expression Expression) {
 	p.printValue(expression.Args[1])
 }
 
+func (p *printer) printSelect(s *Select, pos scanner.Position) {
+	p.printToken("select", pos)
+	p.printToken("(", noPos)
+	p.printToken(s.Axis.Name, s.Axis.Pos)
+	p.printToken(",", noPos)
+	p.printMap(s.Cases.MapValue, s.Cases.Pos, s.Cases.EndPos, p.printSelectCase)
+	p.printToken(")", s.RparenPos)
+}
+
+// printSelectCase prints a case of a select expression, whose name is quoted
+// unless it is the default case.
+func (p *printer) printSelectCase(c *Property) {
+	name := c.Name.Name
+	if name != SelectDefault {
+		name = strconv.Quote(name)
+	}
+	p.printComments(c.Comments)
+	p.printToken(name, c.Name.Pos)
+	p.printToken(":", c.Pos)
+	p.requestSpace()
+	p.printValue(c.Value)
+}
+
 func (p *printer) printProperty(property *Property) {
-	p.printToken(property.Name.Name, property.Name.Pos)
-	p.printToken(":", property.Pos)
+	name := property.Name.Name
+	if !isIdent(name) {
+		// A map value property with a name that had to be quoted.
+		name = strconv.Quote(name)
+	}
+	p.printComments(property.Comments)
+	p.printToken(name, property.Name.Pos)
+	if property.Qualifier != NoQualifier {
+		p.requestSpace()
+	}
+	p.printToken(property.Qualifier.String()+":", property.Pos)
 	p.requestSpace()
 	p.printValue(property.Value)
 }
@@ -206,6 +249,28 @@ func (p *printer) printToken(s string, pos scanner.Position) {
 	p.pos = pos
 }
 
+// Print the comments attached above a node, each on its own line, along with any
+// unattached comments that appear before them
+func (p *printer) printComments(comments []Comment) {
+	for _, c := range comments {
+		if p.pendingNewline != 0 {
+			p.printEndOfLineCommentsBefore(c.Pos)
+			p.requestNewlinesForPos(c.Pos)
+		}
+		p.printInLineCommentsBefore(c.Pos)
+		p.printComment(c)
+		p._requestNewline()
+	}
+}
+
+// Print the comment attached to the end of the last line of a node, if any
+func (p *printer) printLineComment(comment *Comment) {
+	if comment != nil {
+		p.requestSpace()
+		p.printComment(*comment)
+	}
+}
+
 // Print any in-line (single line /* */) comments that appear _before_ pos
 func (p *printer) printInLineCommentsBefore(pos scanner.Position) {
 	for p.curComment < len(p.comments) && p.comments[p.curComment].Pos.Offset < pos.Offset {
@@ -248,7 +313,8 @@ func (p *printer) printEndOfLineCommentsBefore(pos scanner.Position) {
 func (p *printer) requestNewlinesForPos(pos scanner.Position) bool {
 	if pos.Line > p.pos.Line {
 		p._requestNewline()
-		if pos.Line > p.pos.Line+1 {
+		if pos.Line > p.pos.Line+1 && p.pendingNewline != -1 {
+			// Keep a blank line, unless nothing has been printed yet.
 			p.pendingNewline = 2
 		}
 		return true
@@ -371,3 +437,13 @@ func max(a, b int) int {
 		return b
 	}
 }
+
+// isIdent returns true if s can be scanned as an identifier.
+func isIdent(s string) bool {
+	for i, c := range s {
+		if !(c == '_' || unicode.IsLetter(c) || (i > 0 && unicode.IsDigit(c))) {
+			return false
+		}
+	}
+	return s != ""
+}
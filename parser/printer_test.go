@@ -39,6 +39,23 @@ foo{name= "abc",}
 foo {
     name: "abc",
 }
+`,
+	},
+	{
+		input: `
+foo{srcs+:["a.c"],cflags =: ["-O2"],
+target: {host +:{srcs: ["b.c"]}}}
+`,
+		output: `
+foo {
+    srcs +: ["a.c"],
+    cflags =: ["-O2"],
+    target: {
+        host +: {
+            srcs: ["b.c"],
+        },
+    },
+}
 `,
 	},
 	{
@@ -168,6 +185,32 @@ test2 {
 }
 
 //test3
+`,
+	},
+	{
+		input: `
+foo {
+    srcs: [
+        "d.c",
+
+        // Generated by bar.
+        "c.c", // c
+        "b.c",
+        "a.c", /* a */
+    ],
+}
+`,
+		output: `
+foo {
+    srcs: [
+        "d.c",
+
+        "a.c", /* a */
+        "b.c",
+        // Generated by bar.
+        "c.c", // c
+    ],
+}
 `,
 	},
 	{
@@ -197,6 +240,24 @@ module { // test
 }
 
 //test2
+`,
+	},
+	{
+		input: `
+foo {
+    stuff: {
+        "a-b": "c",
+        "d": "e",
+    },
+}
+`,
+		output: `
+foo {
+    stuff: {
+        "a-b": "c",
+        d: "e",
+    },
+}
 `,
 	},
 	{
@@ -251,6 +312,30 @@ test {}
 
 // Multiline
 // Comment
+`,
+	},
+	{
+		input: `
+foo {
+    cflags: ["-Wall"] + select(arch, {
+        "arm": ["-marm"], // arm
+        default: [],
+    }),
+    os: select(os,{linux:"a",
+    "darwin":"b"}),
+}
+`,
+		output: `
+foo {
+    cflags: ["-Wall"] + select(arch, {
+        "arm": ["-marm"], // arm
+        default: [],
+    }),
+    os: select(os, {
+        "linux": "a",
+        "darwin": "b",
+    }),
+}
 `,
 	},
 }
@@ -0,0 +1,183 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"fmt"
+)
+
+// SelectDefault is the name of the case of a select expression that is used
+// when there is no case for the value of its axis.
+const SelectDefault = "default"
+
+// HasSelect returns true if the value contains a select expression, either
+// itself or in one of its operands, list elements or map values.
+func (p Value) HasSelect() bool {
+	if p.Select != nil {
+		return true
+	}
+	if p.Expression != nil {
+		return p.Expression.Args[0].HasSelect() || p.Expression.Args[1].HasSelect()
+	}
+	switch p.Type {
+	case List:
+		for _, value := range p.ListValue {
+			if value.HasSelect() {
+				return true
+			}
+		}
+	case Map:
+		for _, property := range p.MapValue {
+			if property.Value.HasSelect() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ResolveSelects returns properties with each select expression in their
+// values, which must have been evaluated, replaced by the value of its case
+// for the value of its axis in axes, and the expressions that use them
+// evaluated.  The properties that don't contain select expressions are
+// returned as is.
+func ResolveSelects(properties []*Property, axes map[string]string) ([]*Property, []error) {
+	var errs []error
+	var resolved []*Property
+
+	for i, property := range properties {
+		if !property.Value.HasSelect() {
+			if resolved != nil {
+				resolved = append(resolved, property)
+			}
+			continue
+		}
+
+		if resolved == nil {
+			resolved = append([]*Property(nil), properties[:i]...)
+		}
+
+		value, err := resolveSelects(property.Value, axes)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		newProperty := *property
+		newProperty.Value = value
+		resolved = append(resolved, &newProperty)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if resolved == nil {
+		return properties, nil
+	}
+	return resolved, nil
+}
+
+func resolveSelects(value Value, axes map[string]string) (Value, error) {
+	if !value.HasSelect() {
+		return value, nil
+	}
+
+	var resolved Value
+
+	switch {
+	case value.Select != nil:
+		s := value.Select
+		axisValue, ok := axes[s.Axis.Name]
+		if !ok {
+			return Value{}, &ParseError{
+				Err: fmt.Errorf("unknown select axis %q", s.Axis.Name),
+				Pos: s.Axis.Pos,
+			}
+		}
+
+		var selected, defaultCase *Property
+		for _, c := range s.Cases.MapValue {
+			switch c.Name.Name {
+			case axisValue:
+				selected = c
+			case SelectDefault:
+				defaultCase = c
+			}
+		}
+		if selected == nil {
+			selected = defaultCase
+		}
+		if selected == nil {
+			return Value{}, &ParseError{
+				Err: fmt.Errorf("no case for value %q of select axis %q",
+					axisValue, s.Axis.Name),
+				Pos: value.Pos,
+			}
+		}
+
+		var err error
+		resolved, err = resolveSelects(selected.Value, axes)
+		if err != nil {
+			return Value{}, err
+		}
+
+	case value.Expression != nil:
+		e := value.Expression
+		arg1, err := resolveSelects(e.Args[0], axes)
+		if err != nil {
+			return Value{}, err
+		}
+		arg2, err := resolveSelects(e.Args[1], axes)
+		if err != nil {
+			return Value{}, err
+		}
+		resolved, err = evaluateOperator(arg1, arg2, e.Operator, e.Pos)
+		if err != nil {
+			return Value{}, &ParseError{Err: err, Pos: e.Pos}
+		}
+
+	case value.Type == List:
+		resolved = value
+		resolved.ListValue = make([]Value, len(value.ListValue))
+		for i, element := range value.ListValue {
+			element, err := resolveSelects(element, axes)
+			if err != nil {
+				return Value{}, err
+			}
+			if element.Type != String {
+				return Value{}, &ParseError{
+					Err: fmt.Errorf("Expected string in list, found %s", element.Type),
+					Pos: element.Pos,
+				}
+			}
+			resolved.ListValue[i] = element
+		}
+
+	case value.Type == Map:
+		properties, errs := ResolveSelects(value.MapValue, axes)
+		if len(errs) > 0 {
+			return Value{}, errs[0]
+		}
+		resolved = value
+		resolved.MapValue = properties
+	}
+
+	// The resolved value takes the place of the select expression, so it
+	// keeps the comments attached to it as a list element.
+	resolved.Comments = value.Comments
+	resolved.LineComment = value.LineComment
+
+	return resolved, nil
+}
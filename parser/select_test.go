@@ -0,0 +1,158 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// plainValue returns value as a bool, string, []interface{} or
+// map[string]interface{}.
+func plainValue(value Value) interface{} {
+	switch value.Type {
+	case Bool:
+		return value.BoolValue
+	case String:
+		return value.StringValue
+	case List:
+		list := []interface{}{}
+		for _, element := range value.ListValue {
+			list = append(list, plainValue(element))
+		}
+		return list
+	default:
+		return plainProperties(value.MapValue)
+	}
+}
+
+func plainProperties(properties []*Property) map[string]interface{} {
+	m := make(map[string]interface{})
+	for _, property := range properties {
+		m[property.Name.Name] = plainValue(property.Value)
+	}
+	return m
+}
+
+var resolveSelectsTestCases = []struct {
+	input    string
+	axes     map[string]string
+	expected map[string]interface{}
+	err      string
+}{
+	{
+		input: `
+			foo {
+				cflags: ["-Wall"] + select(arch, {
+					"arm": ["-marm"],
+					default: ["-m32"],
+				}),
+				name: "foo",
+			}`,
+		axes: map[string]string{"arch": "arm"},
+		expected: map[string]interface{}{
+			"cflags": []interface{}{"-Wall", "-marm"},
+			"name":   "foo",
+		},
+	},
+	{
+		input: `
+			foo {
+				cflags: ["-Wall"] + select(arch, {
+					"arm": ["-marm"],
+					default: ["-m32"],
+				}),
+			}`,
+		axes: map[string]string{"arch": "x86"},
+		expected: map[string]interface{}{
+			"cflags": []interface{}{"-Wall", "-m32"},
+		},
+	},
+	{
+		input: `
+			suffix = select(os, { "linux": "_linux", default: "" })
+			foo {
+				name: "foo" + suffix,
+				srcs: ["a.c", select(os, { "darwin": "mac.c", default: "posix.c" })],
+				target: {
+					host: { enabled: select(os, { "linux": true, default: false }) },
+				},
+			}`,
+		axes: map[string]string{"os": "linux"},
+		expected: map[string]interface{}{
+			"name": "foo_linux",
+			"srcs": []interface{}{"a.c", "posix.c"},
+			"target": map[string]interface{}{
+				"host": map[string]interface{}{"enabled": true},
+			},
+		},
+	},
+	{
+		input: `foo { cflags: select(arch, { "arm": ["-marm"] }) }`,
+		axes:  map[string]string{"os": "linux"},
+		err:   `<input>:1:22: unknown select axis "arch"`,
+	},
+	{
+		input: `foo { cflags: select(arch, { "arm": ["-marm"] }) }`,
+		axes:  map[string]string{"arch": "x86"},
+		err:   `<input>:1:15: no case for value "x86" of select axis "arch"`,
+	},
+	{
+		input: `foo { cflags: select(arch, { "arm": ["-marm"], default: "" }) }`,
+		err:   `<input>:1:57: mismatched type in select on "arch": list != string`,
+	},
+	{
+		input: `foo { cflags: select(arch, { "arm": [], arm: [] }) }`,
+		err:   `<input>:1:41: duplicate case "arm" in select on "arch"`,
+	},
+}
+
+func TestResolveSelects(t *testing.T) {
+	for _, testCase := range resolveSelectsTestCases {
+		r := bytes.NewBufferString(testCase.input)
+		file, errs := ParseAndEval("", r, NewScope(nil))
+
+		var module *Module
+		if len(errs) == 0 {
+			module = file.Defs[len(file.Defs)-1].(*Module)
+			var properties []*Property
+			properties, errs = ResolveSelects(module.Properties, testCase.axes)
+			if len(errs) == 0 {
+				got := plainProperties(properties)
+				if !reflect.DeepEqual(got, testCase.expected) {
+					t.Errorf("test case: %s", testCase.input)
+					t.Errorf("  expected: %#v", testCase.expected)
+					t.Errorf("       got: %#v", got)
+				}
+				if module.Properties[0].Value.Select == nil &&
+					module.Properties[0].Value.Expression == nil {
+					t.Errorf("test case: %s", testCase.input)
+					t.Errorf("  the parsed properties were modified")
+				}
+			}
+		}
+
+		if testCase.err != "" {
+			if len(errs) != 1 || errs[0].Error() != testCase.err {
+				t.Errorf("test case: %s", testCase.input)
+				t.Errorf("  expected error %q, got %v", testCase.err, errs)
+			}
+		} else if len(errs) > 0 {
+			t.Errorf("test case: %s", testCase.input)
+			t.Errorf("  unexpected errors: %v", errs)
+		}
+	}
+}
@@ -85,6 +85,11 @@ func sortListsInValue(value Value, file *File) {
 		return
 	}
 
+	if value.Select != nil {
+		sortListsInValue(value.Select.Cases, file)
+		return
+	}
+
 	if value.Type == Map {
 		for _, p := range value.MapValue {
 			sortListsInValue(p.Value, file)
@@ -107,7 +112,11 @@ func sortSubList(values []Value, nextPos scanner.Position, file *File) {
 		if i < len(values)-1 {
 			n = values[i+1].Pos
 		}
-		l[i] = elem{v.StringValue, i, v.Pos, n}
+		start := v.Pos
+		if len(v.Comments) > 0 {
+			start = v.Comments[0].Pos
+		}
+		l[i] = elem{v.StringValue, i, start, v.Pos, n}
 	}
 
 	sort.Sort(l)
@@ -115,19 +124,48 @@ func sortSubList(values []Value, nextPos scanner.Position, file *File) {
 	copyValues := append([]Value{}, values...)
 	copyComments := append([]Comment{}, file.Comments...)
 
-	curPos := values[0].Pos
+	// Each element moves along with the comments attached above it.
+	curPos := l.firstStart()
 	for i, e := range l {
+		lines := curPos.Line - e.start.Line
+		offset := curPos.Offset - e.start.Offset
+
 		values[i] = copyValues[e.i]
-		values[i].Pos = curPos
+		values[i].Pos.Line += lines
+		values[i].Pos.Offset += offset
+		moveComments(&values[i], lines, offset)
 		for j, c := range copyComments {
 			if c.Pos.Offset > e.pos.Offset && c.Pos.Offset < e.nextPos.Offset {
-				file.Comments[j].Pos.Line = curPos.Line
-				file.Comments[j].Pos.Offset += values[i].Pos.Offset - e.pos.Offset
+				file.Comments[j].Pos.Line = values[i].Pos.Line
+				file.Comments[j].Pos.Offset += offset
 			}
 		}
 
-		curPos.Offset += e.nextPos.Offset - e.pos.Offset
-		curPos.Line++
+		curPos.Offset += e.nextPos.Offset - e.start.Offset
+		curPos.Line += e.pos.Line - e.start.Line + 1
+	}
+}
+
+// moveComments moves the comments attached to a list element that has been
+// moved by the given number of lines and bytes along with it.
+func moveComments(value *Value, lines, offset int) {
+	move := func(c Comment) Comment {
+		c.Pos.Line += lines
+		c.Pos.Offset += offset
+		return c
+	}
+
+	if value.Comments != nil {
+		comments := make([]Comment, len(value.Comments))
+		for i, c := range value.Comments {
+			comments[i] = move(c)
+		}
+		value.Comments = comments
+	}
+
+	if value.LineComment != nil {
+		c := move(*value.LineComment)
+		value.LineComment = &c
 	}
 }
 
@@ -149,12 +187,24 @@ func subListIsSorted(values []Value) bool {
 type elem struct {
 	s       string
 	i       int
+	start   scanner.Position // The position of the first comment attached above the element.
 	pos     scanner.Position
 	nextPos scanner.Position
 }
 
 type elemList []elem
 
+// firstStart returns the start of the element that was first in the list
+// before it was sorted.
+func (l elemList) firstStart() scanner.Position {
+	for _, e := range l {
+		if e.i == 0 {
+			return e.start
+		}
+	}
+	return scanner.Position{}
+}
+
 func (l elemList) Len() int {
 	return len(l)
 }
@@ -15,10 +15,14 @@
 package pathtools
 
 import (
+	"bytes"
 	"errors"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/google/blueprint/deptools"
 )
 
 var GlobMultipleRecursiveErr = errors.New("pattern contains multiple **")
@@ -57,6 +61,53 @@ func GlobWithExcludes(pattern string, excludes []string) (matches, dirs []string
 	return matches, dirs, nil
 }
 
+// GlobWithDepFile finds the files that match glob but do not match excludes,
+// like GlobWithExcludes, and writes them to fileListFile, one per line.  It
+// also writes a depfile to depFile that makes fileListFile depend on the
+// directories that were searched, so that a Ninja rule running the glob again
+// runs whenever a file is added to or removed from them.  fileListFile is only
+// rewritten if the list of files changes, so with restat anything that depends
+// on it is only rebuilt when the result of the glob changes.
+func GlobWithDepFile(glob, fileListFile, depFile string, excludes []string) (files []string, err error) {
+	files, dirs, err := GlobWithExcludes(glob, excludes)
+	if err != nil {
+		return nil, err
+	}
+
+	fileList := strings.Join(files, "\n")
+	if len(files) > 0 {
+		fileList += "\n"
+	}
+
+	err = WriteFileIfChanged(fileListFile, []byte(fileList), 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	err = deptools.WriteDepFile(depFile, fileListFile, dirs)
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// WriteFileIfChanged writes data to filename, creating the directory that
+// contains it if necessary, unless filename already contains data.  Leaving
+// the file untouched keeps its modification time, so that Ninja rules with
+// restat don't rebuild the files that depend on it.
+func WriteFileIfChanged(filename string, data []byte, perm os.FileMode) error {
+	if old, err := ioutil.ReadFile(filename); err == nil && bytes.Equal(old, data) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0777); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, data, perm)
+}
+
 // glob is a recursive helper function to handle globbing each level of the pattern individually,
 // allowing searched directories to be tracked.  Also handles the recursive glob pattern, **.
 func glob(pattern string, hasRecursive bool) (matches, dirs []string, err error) {
@@ -15,10 +15,12 @@
 package pathtools
 
 import (
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 )
 
 var pwd, _ = os.Getwd()
@@ -453,3 +455,60 @@ func TestGlob(t *testing.T) {
 		}
 	}
 }
+
+func TestGlobWithDepFile(t *testing.T) {
+	outDir, err := ioutil.TempDir("", "glob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outDir)
+
+	os.Chdir("testdata")
+	defer os.Chdir("..")
+
+	fileListFile := filepath.Join(outDir, "globs", "ext")
+	depFile := fileListFile + ".d"
+
+	matches, err := GlobWithDepFile("*.ext", fileListFile, depFile, []string{"e.ext"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(matches, []string{"d.ext"}) {
+		t.Errorf("incorrect matches list: %#v", matches)
+	}
+
+	checkFile := func(filename, expected string) {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("incorrect contents of %s:", filename)
+			t.Errorf("     got: %q", string(data))
+			t.Errorf("expected: %q", expected)
+		}
+	}
+
+	checkFile(fileListFile, "d.ext\n")
+	checkFile(depFile, fileListFile+": \\\n .\n")
+
+	// Globbing again with the same result must not touch the file list.
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(fileListFile, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GlobWithDepFile("*.ext", fileListFile, depFile, []string{"e.ext"}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(fileListFile); err != nil {
+		t.Fatal(err)
+	} else if !info.ModTime().Equal(old) {
+		t.Errorf("expected unchanged file list to keep its modification time")
+	}
+
+	if _, err := GlobWithDepFile("*.ext", fileListFile, depFile, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkFile(fileListFile, "d.ext\ne.ext\n")
+}
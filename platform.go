@@ -0,0 +1,306 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/google/blueprint/parser"
+	"github.com/google/blueprint/proptools"
+)
+
+// selectDefault is the value of a platform axis in a select property that
+// applies to the variants whose value isn't listed.
+const selectDefault = "default"
+
+// A PlatformModule is a Module that is split into variants on the platform
+// axes registered with RegisterPlatformAxis.
+type PlatformModule interface {
+	Module
+
+	// PlatformAxisValues returns the values of the named axis for which the
+	// module has variants, chosen from the values registered for the axis.
+	// Returning nil leaves the module unsplit on the axis.
+	PlatformAxisValues(axis string, values []string) []string
+}
+
+type platformAxis struct {
+	name   string
+	values []string
+}
+
+func (a *platformAxis) hasValue(value string) bool {
+	for _, v := range a.values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterPlatformAxis registers a platform axis, such as the operating system
+// or the architecture, and the values it can take.  Each axis is a bottom up
+// mutator with the same name that runs in registration order with the other
+// mutators, and splits every PlatformModule into a variant for each of the
+// values returned by its PlatformAxisValues method.  Dependencies on the
+// variants are resolved like those of any other variant, and the value of a
+// variant is returned by BaseModuleContext.Variation(name).
+//
+// Once an axis is registered, a module may set properties for some of its
+// variants with the built-in select property, which maps axes to values to
+// the properties to set in the variants with that value:
+//
+//	select: {
+//	    os: {
+//	        linux: { srcs: ["linux.c"] },
+//	        default: { enabled: false },
+//	    },
+//	},
+//
+// The lists are appended to the module's own, and the other properties
+// replace its own.  The "default" properties are set in the variants whose
+// value isn't listed.  The axes on which a module isn't split are ignored.
+func (c *Context) RegisterPlatformAxis(name string, values ...string) {
+	if len(values) == 0 {
+		panic(fmt.Errorf("platform axis %s has no values", name))
+	}
+	for _, value := range values {
+		if value == selectDefault {
+			panic(fmt.Errorf("platform axis %s can't have the value %q", name, value))
+		}
+	}
+	if _, present := c.configVariables[name]; present {
+		panic(fmt.Errorf("platform axis %s has the name of a config variable", name))
+	}
+
+	axis := &platformAxis{
+		name:   name,
+		values: append([]string(nil), values...),
+	}
+
+	c.RegisterBottomUpMutator(name, axis.mutator)
+	c.platformAxes = append(c.platformAxes, axis)
+}
+
+func (a *platformAxis) mutator(ctx BottomUpMutatorContext) {
+	m, ok := ctx.Module().(PlatformModule)
+	if !ok {
+		return
+	}
+
+	values := m.PlatformAxisValues(a.name, a.values)
+	if len(values) == 0 {
+		return
+	}
+
+	for _, value := range values {
+		if !a.hasValue(value) {
+			ctx.ModuleErrorf("unknown value %q of platform axis %q", value, a.name)
+			return
+		}
+	}
+
+	mctx := ctx.(*mutatorContext)
+	for i, variant := range mctx.CreateVariations(values...) {
+		module := mctx.context.moduleInfo[variant]
+		errs := module.applySelect(a.name, values[i])
+		mctx.errs = append(mctx.errs, errs...)
+	}
+}
+
+// splitSelect removes the select property from propertyDefs if platform axes
+// or config variables are registered, and returns the properties of each of
+// its values.
+func (c *Context) splitSelect(propertyDefs []*parser.Property,
+	properties []interface{}) ([]*parser.Property, *parser.Property,
+	map[string]map[string]*parser.Property, []error) {
+
+	if len(c.platformAxes) == 0 && len(c.configVariables) == 0 {
+		return propertyDefs, nil, nil, nil
+	}
+
+	var selectDef *parser.Property
+	var rest []*parser.Property
+	for _, propertyDef := range propertyDefs {
+		if propertyDef.Name.Name == "select" && selectDef == nil {
+			selectDef = propertyDef
+		} else {
+			rest = append(rest, propertyDef)
+		}
+	}
+	if selectDef == nil {
+		return propertyDefs, nil, nil, nil
+	}
+
+	if selectDef.Value.Type != parser.Map {
+		return nil, nil, nil, []error{
+			fmt.Errorf("%s: can't assign %s value to %s property %q",
+				selectDef.Value.Pos, selectDef.Value.Type, parser.Map,
+				selectDef.Name),
+		}
+	}
+
+	var errs []error
+	selects := make(map[string]map[string]*parser.Property)
+	for _, axisDef := range selectDef.Value.MapValue {
+		name := axisDef.Name.Name
+		axis := c.platformAxis(name)
+		if _, isConfigVariable := c.configVariables[name]; axis == nil && !isConfigVariable {
+			errs = append(errs, &Error{
+				Err: fmt.Errorf("unknown platform axis or config variable %q", name),
+				Pos: axisDef.Pos,
+			})
+			continue
+		}
+		if axisDef.Value.Type != parser.Map {
+			errs = append(errs, fmt.Errorf("%s: can't assign %s value to %s property %q",
+				axisDef.Value.Pos, axisDef.Value.Type, parser.Map, "select."+name))
+			continue
+		}
+
+		values := make(map[string]*parser.Property)
+		for _, valueDef := range axisDef.Value.MapValue {
+			value := valueDef.Name.Name
+			// Any value of a config variable may be selected.
+			if axis != nil && value != selectDefault && !axis.hasValue(value) {
+				errs = append(errs, &Error{
+					Err: fmt.Errorf("unknown value %q of platform axis %q", value, axis.name),
+					Pos: valueDef.Pos,
+				})
+				continue
+			}
+			if valueDef.Value.Type != parser.Map {
+				errs = append(errs, fmt.Errorf("%s: can't assign %s value to %s property %q",
+					valueDef.Value.Pos, valueDef.Value.Type, parser.Map,
+					"select."+name+"."+value))
+				continue
+			}
+
+			// Check the properties now rather than when the variants are
+			// created, so that they are reported even if the module is never
+			// split on the axis.
+			_, _, newErrs := unpackProperties(valueDef.Value.MapValue,
+				cloneEmptyPropertyStructs(properties)...)
+			errs = append(errs, newErrs...)
+
+			values[value] = valueDef
+		}
+		selects[name] = values
+	}
+
+	if len(errs) > 0 {
+		return nil, nil, nil, errs
+	}
+
+	return rest, selectDef, selects, nil
+}
+
+func (c *Context) platformAxis(name string) *platformAxis {
+	for _, axis := range c.platformAxes {
+		if axis.name == name {
+			return axis
+		}
+	}
+	return nil
+}
+
+// applySelect sets the properties of the select property that apply to the
+// variant of the module with the given value of the axis.
+func (module *moduleInfo) applySelect(axis, value string) []error {
+	valueDef := module.selects[axis][value]
+	if valueDef == nil {
+		valueDef = module.selects[axis][selectDefault]
+	}
+	if valueDef == nil {
+		return nil
+	}
+
+	dsts := module.moduleProperties
+
+	srcs := cloneEmptyPropertyStructs(dsts)
+	propertyMap, _, errs := unpackProperties(valueDef.Value.MapValue, srcs...)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	for name := range propertyMap {
+		path := strings.Split(name, ".")
+		for i := range dsts {
+			extendProperty(reflect.ValueOf(dsts[i]).Elem(),
+				reflect.ValueOf(srcs[i]).Elem(), path)
+		}
+	}
+
+	return nil
+}
+
+func cloneEmptyPropertyStructs(properties []interface{}) []interface{} {
+	clones := make([]interface{}, len(properties))
+	for i, p := range properties {
+		clones[i] = proptools.CloneEmptyProperties(reflect.ValueOf(p).Elem()).Interface()
+	}
+	return clones
+}
+
+// extendProperty copies the property at path from the src property struct to
+// the dst one, appending lists and adding map entries, if the struct has it.
+func extendProperty(dst, src reflect.Value, path []string) {
+	field, ok := dst.Type().FieldByName(proptools.FieldNameForProperty(path[0]))
+	if !ok || field.PkgPath != "" {
+		return
+	}
+
+	dstField := dst.FieldByIndex(field.Index)
+	srcField := src.FieldByIndex(field.Index)
+
+	switch dstField.Kind() {
+	case reflect.Interface:
+		dstField, srcField = dstField.Elem(), srcField.Elem()
+		fallthrough
+	case reflect.Ptr:
+		dstField, srcField = dstField.Elem(), srcField.Elem()
+	}
+
+	if len(path) > 1 {
+		if dstField.Kind() == reflect.Struct {
+			extendProperty(dstField, srcField, path[1:])
+		}
+		return
+	}
+
+	switch dstField.Kind() {
+	case reflect.Struct:
+		// The properties within the struct are extended separately.
+	case reflect.Slice:
+		// The variants may share the backing array of the list, so the
+		// result is always a new one.
+		list := reflect.MakeSlice(dstField.Type(), 0, dstField.Len()+srcField.Len())
+		list = reflect.AppendSlice(list, dstField)
+		dstField.Set(reflect.AppendSlice(list, srcField))
+	case reflect.Map:
+		m := reflect.MakeMap(dstField.Type())
+		for _, key := range dstField.MapKeys() {
+			m.SetMapIndex(key, dstField.MapIndex(key))
+		}
+		for _, key := range srcField.MapKeys() {
+			m.SetMapIndex(key, srcField.MapIndex(key))
+		}
+		dstField.Set(m)
+	default:
+		dstField.Set(srcField)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type platformTestModule struct {
+	properties struct {
+		Srcs      []string
+		Cflags    string
+		Host_only bool
+	}
+
+	platform string
+	deps     []string
+}
+
+func newPlatformTestModule() (Module, []interface{}) {
+	m := &platformTestModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (m *platformTestModule) PlatformAxisValues(axis string, values []string) []string {
+	if axis == "arch" && m.properties.Host_only {
+		return []string{"x86"}
+	}
+	return values
+}
+
+func (m *platformTestModule) GenerateBuildActions(ctx ModuleContext) {
+	m.platform = ctx.Variation("os") + "_" + ctx.Variation("arch")
+	ctx.VisitDirectDeps(func(dep Module) {
+		m.deps = append(m.deps, ctx.OtherModuleName(dep)+" "+dep.(*platformTestModule).platform)
+	})
+}
+
+func newPlatformTestContext() *Context {
+	ctx := NewContext()
+	ctx.RegisterModuleType("platform_module", newPlatformTestModule)
+	ctx.RegisterPlatformAxis("os", "linux", "darwin")
+	ctx.RegisterPlatformAxis("arch", "arm", "x86")
+	return ctx
+}
+
+func TestPlatformAxes(t *testing.T) {
+	ctx := newPlatformTestContext()
+
+	r := bytes.NewBufferString(`
+		platform_module {
+			name: "lib",
+			srcs: ["lib.c"],
+			select: {
+				os: {
+					linux: { srcs: ["linux.c"] },
+					default: { enabled: false },
+				},
+				arch: {
+					arm: { cflags: "-marm" },
+				},
+			},
+		}
+
+		platform_module {
+			name: "tool",
+			host_only: true,
+			deps: ["lib"],
+			cflags: "-O2",
+			select: {
+				os: {
+					darwin: { enabled: false },
+				},
+				arch: {
+					arm: { srcs: ["unused.c"] },
+				},
+			},
+		}
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	var got []string
+	ctx.VisitAllModules(func(module Module) {
+		m := module.(*platformTestModule)
+		got = append(got, fmt.Sprintf("%s %s %q %q %q", ctx.ModuleName(module),
+			m.platform, m.properties.Srcs, m.properties.Cflags, m.deps))
+	})
+
+	expected := []string{
+		`lib linux_arm ["lib.c" "linux.c"] "-marm" []`,
+		`lib linux_x86 ["lib.c" "linux.c"] "" []`,
+		`tool linux_x86 [] "-O2" ["lib linux_x86"]`,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected:\n  %s\ngot:\n  %s", strings.Join(expected, "\n  "),
+			strings.Join(got, "\n  "))
+	}
+}
+
+func TestPlatformAxesErrors(t *testing.T) {
+	testCases := []struct {
+		bp  string
+		err string
+	}{
+		{
+			bp:  `platform_module { name: "a", select: { cpu: {} } }`,
+			err: `Blueprint:1:43: unknown platform axis or config variable "cpu"`,
+		},
+		{
+			bp:  `platform_module { name: "a", select: { os: { windows: {} } } }`,
+			err: `Blueprint:1:53: unknown value "windows" of platform axis "os"`,
+		},
+		{
+			bp:  `platform_module { name: "a", select: { os: { linux: { foo: "b" } } } }`,
+			err: `Blueprint:1:58: unrecognized property "foo"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		ctx := newPlatformTestContext()
+
+		r := bytes.NewBufferString(testCase.bp)
+		_, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		if expected := []string{testCase.err}; !reflect.DeepEqual(got, expected) {
+			t.Errorf("for %s\nexpected errors %q, got %q", testCase.bp, expected, got)
+		}
+	}
+}
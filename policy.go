@@ -0,0 +1,253 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A DependencyPolicy describes limits on the module dependency graph, which
+// can be used to enforce the layering of a source tree.
+type DependencyPolicy struct {
+	// MaxTransitiveDeps is the largest number of modules that a module may
+	// depend on, directly or indirectly.  Zero means no limit.
+	MaxTransitiveDeps int
+
+	// MaxDepth is the largest number of modules in a chain of dependencies,
+	// including the module at its start.  Zero means no limit.
+	MaxDepth int
+
+	// ForbiddenDeps lists groups of directories whose modules must not depend
+	// on the modules in other groups of directories.
+	ForbiddenDeps []ForbiddenDep
+}
+
+// A ForbiddenDep forbids the modules defined in the Blueprints files in the
+// From directories and their subdirectories from depending, directly or
+// indirectly, on the modules defined in the To directories and their
+// subdirectories.  Directories are relative to the directory of the root
+// Blueprints file.
+type ForbiddenDep struct {
+	From []string
+	To   []string
+}
+
+// SetDependencyPolicy sets the limits on the module dependency graph that
+// ResolveDependencies checks once it has resolved the dependencies.  Each
+// violation is reported as an error that shows the chain of dependencies
+// responsible for it.
+func (c *Context) SetDependencyPolicy(policy DependencyPolicy) {
+	c.dependencyPolicy = policy
+}
+
+// checkDependencyPolicy returns the violations of the policy set with
+// SetDependencyPolicy.
+func (c *Context) checkDependencyPolicy() (errs []error) {
+	policy := c.dependencyPolicy
+	if policy.MaxTransitiveDeps == 0 && policy.MaxDepth == 0 &&
+		len(policy.ForbiddenDeps) == 0 {
+		return nil
+	}
+
+	// Check the modules in a consistent order so that the errors are
+	// reported in the same order every time.
+	var names []string
+	for name := range c.moduleGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var modules []*moduleInfo
+	for _, name := range names {
+		modules = append(modules, c.moduleGroups[name].modules...)
+	}
+
+	if policy.MaxTransitiveDeps > 0 {
+		transitiveDeps := make(map[*moduleInfo]map[*moduleInfo]bool)
+
+		var visit func(module *moduleInfo) map[*moduleInfo]bool
+		visit = func(module *moduleInfo) map[*moduleInfo]bool {
+			if deps, ok := transitiveDeps[module]; ok {
+				return deps
+			}
+
+			deps := make(map[*moduleInfo]bool)
+			for _, dep := range module.directDeps {
+				deps[dep] = true
+				for d := range visit(dep) {
+					deps[d] = true
+				}
+			}
+
+			transitiveDeps[module] = deps
+			return deps
+		}
+
+		for _, module := range modules {
+			if count := len(visit(module)); count > policy.MaxTransitiveDeps {
+				errs = append(errs, &Error{
+					Err: fmt.Errorf("module %s depends on %d modules, more than the limit of %d",
+						policyModuleName(module), count, policy.MaxTransitiveDeps),
+					Pos: module.pos,
+				})
+			}
+		}
+	}
+
+	if policy.MaxDepth > 0 {
+		// deepest holds the dependency that starts the longest chain of
+		// dependencies of each module.
+		depths := make(map[*moduleInfo]int)
+		deepest := make(map[*moduleInfo]*moduleInfo)
+
+		var visit func(module *moduleInfo) int
+		visit = func(module *moduleInfo) int {
+			if depth, ok := depths[module]; ok {
+				return depth
+			}
+
+			depth := 1
+			for _, dep := range module.directDeps {
+				if d := visit(dep) + 1; d > depth {
+					depth = d
+					deepest[module] = dep
+				}
+			}
+
+			depths[module] = depth
+			return depth
+		}
+
+		for _, module := range modules {
+			if depth := visit(module); depth > policy.MaxDepth {
+				var chain []*moduleInfo
+				for m := module; m != nil; m = deepest[m] {
+					chain = append(chain, m)
+				}
+
+				errs = append(errs, &Error{
+					Err: fmt.Errorf("module %s has a chain of %d dependencies, more than the limit of %d: %s",
+						policyModuleName(module), depth, policy.MaxDepth, policyChain(chain)),
+					Pos: module.pos,
+				})
+			}
+		}
+	}
+
+	for _, forbidden := range policy.ForbiddenDeps {
+		errs = append(errs, checkForbiddenDep(forbidden, modules)...)
+	}
+
+	return errs
+}
+
+// checkForbiddenDep returns the errors for the modules in forbidden.From that
+// depend on modules in forbidden.To.  A module that reaches forbidden.To
+// through another module in forbidden.From isn't reported, only that module
+// is.
+func checkForbiddenDep(forbidden ForbiddenDep, modules []*moduleInfo) (errs []error) {
+	// next holds the dependency through which each module reaches a module in
+	// forbidden.To, or the module itself if it is in forbidden.To, or nil.
+	next := make(map[*moduleInfo]*moduleInfo)
+	visited := make(map[*moduleInfo]bool)
+
+	var visit func(module *moduleInfo) *moduleInfo
+	visit = func(module *moduleInfo) *moduleInfo {
+		if visited[module] {
+			return next[module]
+		}
+		visited[module] = true
+
+		if moduleInDirs(module, forbidden.To) {
+			next[module] = module
+			return module
+		}
+
+		for _, dep := range module.directDeps {
+			if visit(dep) != nil {
+				next[module] = dep
+				return dep
+			}
+		}
+
+		return nil
+	}
+
+	for _, module := range modules {
+		if !moduleInDirs(module, forbidden.From) || moduleInDirs(module, forbidden.To) {
+			continue
+		}
+
+		dep := visit(module)
+		if dep == nil || moduleInDirs(dep, forbidden.From) {
+			continue
+		}
+
+		chain := []*moduleInfo{module}
+		for m := dep; ; m = next[m] {
+			chain = append(chain, m)
+			if next[m] == m {
+				break
+			}
+		}
+
+		pos := module.pos
+		if depsPos, ok := module.propertyPos["deps"]; ok {
+			pos = depsPos
+		}
+
+		errs = append(errs, &Error{
+			Err: fmt.Errorf("module %s in %s must not depend on module %s in %s: %s",
+				policyModuleName(module), filepath.Dir(module.relBlueprintsFile),
+				policyModuleName(chain[len(chain)-1]),
+				filepath.Dir(chain[len(chain)-1].relBlueprintsFile), policyChain(chain)),
+			Pos: pos,
+		})
+	}
+
+	return errs
+}
+
+// moduleInDirs returns true if module is defined in one of dirs or their
+// subdirectories.
+func moduleInDirs(module *moduleInfo, dirs []string) bool {
+	moduleDir := filepath.Dir(module.relBlueprintsFile)
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if dir == "." || moduleDir == dir ||
+			strings.HasPrefix(moduleDir, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func policyModuleName(module *moduleInfo) string {
+	if module.variantName != "" {
+		return fmt.Sprintf("%q (variant %s)", module.properties.Name, module.variantName)
+	}
+	return fmt.Sprintf("%q", module.properties.Name)
+}
+
+func policyChain(chain []*moduleInfo) string {
+	names := make([]string, len(chain))
+	for i, module := range chain {
+		names[i] = fmt.Sprintf("%q", module.properties.Name)
+	}
+	return strings.Join(names, " -> ")
+}
@@ -0,0 +1,101 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+var policyTestCases = []struct {
+	policy DependencyPolicy
+	errs   []string
+}{
+	{
+		policy: DependencyPolicy{MaxTransitiveDeps: 2},
+		errs: []string{
+			`module "app" depends on 3 modules, more than the limit of 2`,
+		},
+	},
+	{
+		policy: DependencyPolicy{MaxDepth: 3},
+		errs: []string{
+			`module "app" has a chain of 4 dependencies, more than the limit of 3: ` +
+				`"app" -> "lib" -> "util" -> "vendor_lib"`,
+		},
+	},
+	{
+		policy: DependencyPolicy{
+			ForbiddenDeps: []ForbiddenDep{
+				{From: []string{"apps"}, To: []string{"vendor"}},
+				{From: []string{"lib", "util/"}, To: []string{"vendor"}},
+			},
+		},
+		errs: []string{
+			`module "app" in apps must not depend on module "vendor_lib" in vendor: ` +
+				`"app" -> "lib" -> "util" -> "vendor_lib"`,
+			`module "app2" in apps must not depend on module "vendor_lib" in vendor: ` +
+				`"app2" -> "vendor_lib"`,
+			`module "util" in util must not depend on module "vendor_lib" in vendor: ` +
+				`"util" -> "vendor_lib"`,
+		},
+	},
+	{
+		policy: DependencyPolicy{
+			MaxTransitiveDeps: 3,
+			MaxDepth:          4,
+			ForbiddenDeps: []ForbiddenDep{
+				{From: []string{"vendor"}, To: []string{"apps"}},
+			},
+		},
+	},
+}
+
+func TestDependencyPolicy(t *testing.T) {
+	for i, testCase := range policyTestCases {
+		ctx, errs := parseTestFiles(func(ctx *Context) {
+			ctx.RegisterModuleType("foo_module", newFooModule)
+			ctx.SetDependencyPolicy(testCase.policy)
+		}, []blueprintsTestFile{
+			{"apps/Blueprints", `
+				foo_module { name: "app", deps: ["lib"] }
+				foo_module { name: "app2", deps: ["vendor_lib"] }
+			`},
+			{"lib/Blueprints", `foo_module { name: "lib", deps: ["util"] }`},
+			{"util/Blueprints", `foo_module { name: "util", deps: ["vendor_lib"] }`},
+			{"vendor/Blueprints", `foo_module { name: "vendor_lib" }`},
+		})
+		if len(errs) > 0 {
+			t.Fatalf("unexpected errors: %v", errs)
+		}
+
+		errs = ctx.ResolveDependencies(nil)
+
+		var errStrings []string
+		for _, err := range errs {
+			errStrings = append(errStrings, err.(*Error).Err.Error())
+		}
+
+		if !reflect.DeepEqual(errStrings, testCase.errs) {
+			t.Errorf("test case %d: incorrect errors:", i)
+			for _, err := range errStrings {
+				t.Errorf("     got: %s", err)
+			}
+			for _, err := range testCase.errs {
+				t.Errorf("expected: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+
+	"github.com/google/blueprint/proptools"
+)
+
+// ModuleProperties returns the values of all of the properties of a module,
+// including the ones that weren't set in its Blueprints file, as a map from
+// property name to value that can be encoded as JSON.  Called after
+// PrepareBuildActions, the values include the changes made by mutators.
+// Nested property structs are returned as maps, and nil pointers to property
+// structs are left out.
+func (c *Context) ModuleProperties(logicModule Module) map[string]interface{} {
+	module := c.moduleInfo[logicModule]
+
+	props := make(map[string]interface{})
+	for _, p := range module.moduleProperties {
+		addPropertyValues(props, reflect.ValueOf(p).Elem())
+	}
+
+	return props
+}
+
+// addPropertyValues adds the values of the exported fields of structValue to
+// props.  Nested structs with the same name in different property structs are
+// merged.
+func addPropertyValues(props map[string]interface{}, structValue reflect.Value) {
+	typ := structValue.Type()
+
+	for i := 0; i < structValue.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			// The field is not exported so just skip it.
+			continue
+		}
+
+		name := proptools.PropertyNameForField(field.Name)
+		value := propertyValue(structValue.Field(i))
+		if value == nil {
+			continue
+		}
+
+		if nested, ok := value.(map[string]interface{}); ok {
+			if existing, ok := props[name].(map[string]interface{}); ok {
+				for k, v := range nested {
+					existing[k] = v
+				}
+				continue
+			}
+		}
+
+		props[name] = value
+	}
+}
+
+func propertyValue(value reflect.Value) interface{} {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+		return propertyValue(value.Elem())
+	case reflect.Struct:
+		props := make(map[string]interface{})
+		addPropertyValues(props, value)
+		return props
+	case reflect.Slice:
+		list := make([]interface{}, value.Len())
+		for i := range list {
+			list[i] = propertyValue(value.Index(i))
+		}
+		return list
+	case reflect.Map:
+		m := make(map[string]interface{})
+		for _, key := range value.MapKeys() {
+			m[key.String()] = propertyValue(value.MapIndex(key))
+		}
+		return m
+	default:
+		return value.Interface()
+	}
+}
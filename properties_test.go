@@ -0,0 +1,89 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+type propertiesModule struct {
+	properties struct {
+		Srcs   []string
+		Flags  map[string]string
+		Nested struct {
+			Enabled bool
+		}
+		Count int `blueprint:"mutated"`
+	}
+	archProperties struct {
+		Nested struct {
+			Arch string
+		}
+	}
+}
+
+func newPropertiesModule() (Module, []interface{}) {
+	m := &propertiesModule{}
+	return m, []interface{}{&m.properties, &m.archProperties}
+}
+
+func (p *propertiesModule) GenerateBuildActions(ModuleContext) {
+}
+
+func TestModuleProperties(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("properties_module", newPropertiesModule)
+	ctx.RegisterBottomUpMutator("count", func(mctx BottomUpMutatorContext) {
+		if m, ok := mctx.Module().(*propertiesModule); ok {
+			m.properties.Count = len(m.properties.Srcs)
+			m.archProperties.Nested.Arch = "arm"
+		}
+	})
+
+	r := bytes.NewBufferString(`
+		properties_module {
+			name: "a",
+			srcs: ["a.c", "b.c"],
+			flags: { "-O": "2" },
+			nested: { enabled: true },
+		}
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	data, err := json.Marshal(ctx.ModuleProperties(modules[0].logicModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"count":2,"deps":[],"enabled":true,"flags":{"-O":"2"},"name":"a",` +
+		`"nested":{"arch":"arm","enabled":true},"overrides":[],"srcs":["a.c","b.c"],"test_for":"","test_suites":[],"visibility":[]}`
+	if string(data) != expected {
+		t.Errorf("incorrect properties:")
+		t.Errorf("     got: %s", data)
+		t.Errorf("expected: %s", expected)
+	}
+}
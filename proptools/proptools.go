@@ -17,6 +17,8 @@ package proptools
 import (
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -82,6 +84,22 @@ func CopyProperties(dstValue, srcValue reflect.Value) {
 			} else {
 				dstFieldValue.Set(srcFieldValue)
 			}
+		case reflect.Map:
+			if !srcFieldValue.IsNil() {
+				if field.Type.Elem().Kind() != reflect.String {
+					panic(fmt.Errorf("can't copy field %q: map values are "+
+						"not strings", field.Name))
+				}
+				if srcFieldValue != dstFieldValue {
+					newMap := reflect.MakeMap(field.Type)
+					for _, key := range srcFieldValue.MapKeys() {
+						newMap.SetMapIndex(key, srcFieldValue.MapIndex(key))
+					}
+					dstFieldValue.Set(newMap)
+				}
+			} else {
+				dstFieldValue.Set(srcFieldValue)
+			}
 		case reflect.Ptr, reflect.Interface:
 			if !srcFieldValue.IsNil() {
 				if dstFieldValue.IsNil() ||
@@ -129,7 +147,7 @@ func ZeroProperties(structValue reflect.Value) {
 		fieldValue := structValue.Field(i)
 
 		switch fieldValue.Kind() {
-		case reflect.Bool, reflect.String, reflect.Struct, reflect.Slice, reflect.Int, reflect.Uint:
+		case reflect.Bool, reflect.String, reflect.Struct, reflect.Slice, reflect.Map, reflect.Int, reflect.Uint:
 			fieldValue.Set(reflect.Zero(fieldValue.Type()))
 		case reflect.Ptr, reflect.Interface:
 			if !fieldValue.IsNil() {
@@ -156,6 +174,97 @@ func ZeroProperties(structValue reflect.Value) {
 	}
 }
 
+// SetDefaults sets the fields of the property struct structValue, and of the
+// property structs nested in it, that have a `default:"value"` tag and are
+// still zero to their default values.  String values are used as they are,
+// bool values are parsed with strconv.ParseBool, int and uint values with
+// strconv.ParseInt and strconv.ParseUint, and list values are split at
+// commas.  A default that can't be parsed is a programming error, so it
+// causes a panic.
+func SetDefaults(structValue reflect.Value) {
+	typ := structValue.Type()
+
+	for i := 0; i < structValue.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" {
+			// The field is not exported so just skip it.
+			continue
+		}
+
+		fieldValue := structValue.Field(i)
+
+		switch fieldValue.Kind() {
+		case reflect.Struct:
+			SetDefaults(fieldValue)
+			continue
+		case reflect.Ptr, reflect.Interface:
+			if !fieldValue.IsNil() {
+				elem := fieldValue.Elem()
+				if fieldValue.Kind() == reflect.Interface {
+					elem = elem.Elem()
+				}
+				if elem.Kind() == reflect.Struct {
+					SetDefaults(elem)
+				}
+			}
+			continue
+		}
+
+		def := field.Tag.Get("default")
+		if def == "" {
+			continue
+		}
+
+		value, err := parseDefault(fieldValue.Type(), def)
+		if err != nil {
+			panic(fmt.Errorf("invalid default for property struct field %q: %s",
+				field.Name, err))
+		}
+
+		if reflect.DeepEqual(fieldValue.Interface(), reflect.Zero(fieldValue.Type()).Interface()) {
+			fieldValue.Set(value)
+		}
+	}
+}
+
+func parseDefault(typ reflect.Type, def string) (reflect.Value, error) {
+	value := reflect.New(typ).Elem()
+
+	switch typ.Kind() {
+	case reflect.String:
+		value.SetString(def)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(def)
+		if err != nil {
+			return value, err
+		}
+		value.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.ParseInt(def, 0, 0)
+		if err != nil {
+			return value, err
+		}
+		value.SetInt(n)
+	case reflect.Uint:
+		n, err := strconv.ParseUint(def, 0, 0)
+		if err != nil {
+			return value, err
+		}
+		value.SetUint(n)
+	case reflect.Slice:
+		if typ.Elem().Kind() != reflect.String {
+			return value, fmt.Errorf("unsupported type %s", typ)
+		}
+		for _, s := range strings.Split(def, ",") {
+			value = reflect.Append(value, reflect.ValueOf(s).Convert(typ.Elem()))
+		}
+	default:
+		return value, fmt.Errorf("unsupported type %s", typ)
+	}
+
+	return value, nil
+}
+
 func CloneEmptyProperties(structValue reflect.Value) reflect.Value {
 	result := reflect.New(structValue.Type())
 	cloneEmptyProperties(result.Elem(), structValue)
@@ -175,7 +284,7 @@ func cloneEmptyProperties(dstValue, srcValue reflect.Value) {
 		dstFieldValue := dstValue.Field(i)
 
 		switch srcFieldValue.Kind() {
-		case reflect.Bool, reflect.String, reflect.Slice, reflect.Int, reflect.Uint:
+		case reflect.Bool, reflect.String, reflect.Slice, reflect.Map, reflect.Int, reflect.Uint:
 			// Nothing
 		case reflect.Struct:
 			cloneEmptyProperties(dstFieldValue, srcFieldValue)
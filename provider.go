@@ -0,0 +1,158 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Providers pass data from a module to the modules that depend on it without
+// the dependent modules asserting that the module implements an interface and
+// calling its methods, which may read fields of the module that it is still
+// writing if it isn't a dependency.  A module sets the value of a provider
+// with ModuleContext.SetProvider during its GenerateBuildActions, and its
+// dependent modules, which generate their build actions after it, read it with
+// ModuleContext.OtherModuleProvider.  A value can only be read once the module
+// that set it has finished generating its build actions, and can't be changed
+// after it is set, so the readers see the same value regardless of the order
+// in which modules generate their build actions.
+//
+// For example, a library module type could declare:
+//
+//   var LibraryProvider = blueprint.NewProvider(LibraryInfo{})
+//
+//   type LibraryInfo struct {
+//       LibraryFile string
+//   }
+//
+// set it in its GenerateBuildActions:
+//
+//   ctx.SetProvider(LibraryProvider, LibraryInfo{LibraryFile: libFile})
+//
+// and a binary module that depends on libraries could collect them with:
+//
+//   ctx.VisitDirectDeps(func(dep blueprint.Module) {
+//       if info, ok := ctx.OtherModuleProvider(dep, LibraryProvider); ok {
+//           libFiles = append(libFiles, info.(LibraryInfo).LibraryFile)
+//       }
+//   })
+
+// A ProviderKey identifies a provider created with NewProvider.
+type ProviderKey struct {
+	*provider
+}
+
+type provider struct {
+	id  int
+	typ reflect.Type
+}
+
+func (p ProviderKey) String() string {
+	return p.typ.String()
+}
+
+// providerRegistry holds the providers in the order they were created, so
+// the id of a provider is its index in it.
+var providerRegistry []ProviderKey
+
+// NewProvider returns a ProviderKey for a provider whose values have the type
+// of typ, which is usually the zero value of a struct type.  It may only be
+// called during a Go package's initialization - either from the init()
+// function or as part of a package-scoped variable's initialization.
+func NewProvider(typ interface{}) ProviderKey {
+	checkCalledFromInit()
+
+	if typ == nil {
+		panic(fmt.Errorf("provider type must not be nil"))
+	}
+
+	key := ProviderKey{&provider{
+		id:  len(providerRegistry),
+		typ: reflect.TypeOf(typ),
+	}}
+	providerRegistry = append(providerRegistry, key)
+
+	return key
+}
+
+// setProvider sets the value of provider for module, which must be
+// generating its build actions.
+func (c *Context) setProvider(module *moduleInfo, provider ProviderKey, value interface{}) {
+	if !module.startedGenerateBuildActions || module.finishedGenerateBuildActions {
+		panic(fmt.Errorf("provider %s for module %q variant %q can only be set "+
+			"during its GenerateBuildActions", provider, module.properties.Name,
+			module.variantName))
+	}
+
+	if typ := reflect.TypeOf(value); typ != provider.typ {
+		panic(fmt.Errorf("value of provider %s for module %q variant %q has "+
+			"type %s", provider, module.properties.Name, module.variantName, typ))
+	}
+
+	if module.providers == nil {
+		module.providers = make([]interface{}, len(providerRegistry))
+	}
+
+	if module.providers[provider.id] != nil {
+		panic(fmt.Errorf("provider %s for module %q variant %q is already set",
+			provider, module.properties.Name, module.variantName))
+	}
+
+	module.providers[provider.id] = value
+}
+
+// provider returns the value of provider for module, which must have
+// finished generating its build actions, and whether it was set.
+func (c *Context) provider(module *moduleInfo, provider ProviderKey) (interface{}, bool) {
+	if !module.finishedGenerateBuildActions {
+		panic(fmt.Errorf("provider %s for module %q variant %q can't be read "+
+			"before its GenerateBuildActions has finished", provider,
+			module.properties.Name, module.variantName))
+	}
+
+	if module.providers == nil {
+		return nil, false
+	}
+
+	value := module.providers[provider.id]
+	return value, value != nil
+}
+
+// ModuleProvider returns the value of provider set by module, which must have
+// generated its build actions, and whether it was set.
+func (c *Context) ModuleProvider(logicModule Module, provider ProviderKey) (interface{}, bool) {
+	module := c.moduleInfo[logicModule]
+	if module == nil {
+		panic(fmt.Errorf("module %v is not defined", logicModule))
+	}
+	return c.provider(module, provider)
+}
+
+func (m *moduleContext) SetProvider(provider ProviderKey, value interface{}) {
+	m.context.setProvider(m.module, provider, value)
+}
+
+func (m *moduleContext) OtherModuleProvider(logicModule Module,
+	provider ProviderKey) (interface{}, bool) {
+
+	return m.context.ModuleProvider(logicModule, provider)
+}
+
+func (s *singletonContext) ModuleProvider(logicModule Module,
+	provider ProviderKey) (interface{}, bool) {
+
+	return s.context.ModuleProvider(logicModule, provider)
+}
@@ -0,0 +1,145 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type providerTestInfo struct {
+	Files []string
+}
+
+var providerTestProvider = NewProvider(providerTestInfo{})
+
+type providerTestModule struct {
+	properties struct {
+		Mode string
+	}
+}
+
+func newProviderTestModule() (Module, []interface{}) {
+	m := &providerTestModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (p *providerTestModule) GenerateBuildActions(ctx ModuleContext) {
+	files := []string{ctx.ModuleName()}
+	ctx.VisitDirectDeps(func(dep Module) {
+		if info, ok := ctx.OtherModuleProvider(dep, providerTestProvider); ok {
+			files = append(files, info.(providerTestInfo).Files...)
+		}
+	})
+
+	switch p.properties.Mode {
+	case "none":
+	case "twice":
+		ctx.SetProvider(providerTestProvider, providerTestInfo{files})
+		ctx.SetProvider(providerTestProvider, providerTestInfo{files})
+	case "wrong_type":
+		ctx.SetProvider(providerTestProvider, &providerTestInfo{files})
+	case "read_reverse":
+		ctx.VisitReverseDeps(func(rdep Module) {
+			ctx.OtherModuleProvider(rdep, providerTestProvider)
+		})
+	default:
+		ctx.SetProvider(providerTestProvider, providerTestInfo{files})
+	}
+}
+
+func runProviderTest(bp string) (*Context, []error) {
+	return prepareTestFiles(func(ctx *Context) {
+		ctx.RegisterModuleType("provider_module", newProviderTestModule)
+	}, []blueprintsTestFile{{"Blueprint", bp}})
+}
+
+func TestProviders(t *testing.T) {
+	ctx, errs := runProviderTest(`
+		provider_module { name: "a", deps: ["b", "c"] }
+		provider_module { name: "b", deps: ["d"] }
+		provider_module { name: "c", mode: "none", deps: ["d"] }
+		provider_module { name: "d" }
+	`)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	expected := map[string][]string{
+		"a": {"a", "b", "d"},
+		"b": {"b", "d"},
+		"d": {"d"},
+	}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		m := ctx.moduleGroups[name].modules[0].logicModule
+		info, ok := ctx.ModuleProvider(m, providerTestProvider)
+		if ok != (expected[name] != nil) {
+			t.Errorf("module %s: expected provider set %t, got %t",
+				name, expected[name] != nil, ok)
+			continue
+		}
+		if ok && !reflect.DeepEqual(info.(providerTestInfo).Files, expected[name]) {
+			t.Errorf("module %s: expected files %q, got %q",
+				name, expected[name], info.(providerTestInfo).Files)
+		}
+	}
+}
+
+func TestProviderErrors(t *testing.T) {
+	testCases := []struct {
+		bp  string
+		err string
+	}{
+		{
+			bp:  `provider_module { name: "a", mode: "twice" }`,
+			err: `provider blueprint.providerTestInfo for module "a" variant "" is already set`,
+		},
+		{
+			bp:  `provider_module { name: "a", mode: "wrong_type" }`,
+			err: `value of provider blueprint.providerTestInfo for module "a" variant "" has type *blueprint.providerTestInfo`,
+		},
+		{
+			bp: `
+				provider_module { name: "a", mode: "read_reverse" }
+				provider_module { name: "b", deps: ["a"] }
+			`,
+			err: `provider blueprint.providerTestInfo for module "b" variant "" can't be read before its GenerateBuildActions has finished`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, errs := runProviderTest(testCase.bp)
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), testCase.err) {
+			t.Errorf("test case: %s", testCase.bp)
+			t.Errorf("  expected error containing %q, got %v", testCase.err, errs)
+		}
+	}
+}
+
+func TestSetProviderAfterGenerateBuildActions(t *testing.T) {
+	ctx, errs := runProviderTest(`provider_module { name: "a", mode: "none" }`)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	module := ctx.moduleGroups["a"].modules[0]
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected SetProvider to panic after GenerateBuildActions")
+		}
+	}()
+	ctx.setProvider(module, providerTestProvider, providerTestInfo{})
+}
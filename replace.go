@@ -0,0 +1,166 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+)
+
+// A module may take the place of another one in the dependency graph, so that
+// a vendor tree can substitute its own implementation of a module without
+// editing the Blueprints file that defines the original.  A module declares
+// the modules it replaces with the built-in overrides property:
+//
+//	my_library {
+//	    name: "vendor_libfoo",
+//	    overrides: ["//external/foo:libfoo"],
+//	}
+//
+// and every dependency on an overridden module is changed to a dependency on
+// the module that overrides it once the dependencies are resolved.  The
+// overridden module is still defined, so its build actions are still
+// generated, but no module depends on it.  Mutators replace dependencies with
+// BottomUpMutatorContext.ReplaceDependencies.
+
+// A replacement replaces the dependencies on from with dependencies on to.
+type replacement struct {
+	from, to *moduleInfo
+}
+
+// ReplaceDependencies replaces all dependencies on the variant of the module
+// named name that is identical to the current variant of this module with
+// dependencies on this module.  The replacements take effect once the mutator
+// has been called on every module.
+func (mctx *mutatorContext) ReplaceDependencies(name string) {
+	from, err := mctx.context.replacedVariant(mctx.module, name)
+	if err != nil {
+		mctx.errs = append(mctx.errs, &Error{Err: err, Pos: mctx.module.pos})
+		return
+	}
+
+	mctx.replacements = append(mctx.replacements, replacement{from, mctx.module})
+}
+
+// replacedVariant returns the variant of the module named name, as it would
+// be named in a dependency of module, that is identical to module's variant.
+func (c *Context) replacedVariant(module *moduleInfo, name string) (*moduleInfo, error) {
+	group, err := c.lookupModuleGroup(module, name)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return nil, fmt.Errorf("%q replaces undefined module %q",
+			module.properties.Name, name)
+	}
+	if group == module.group {
+		return nil, fmt.Errorf("%q replaces itself", module.properties.Name)
+	}
+
+	for _, m := range group.modules {
+		if m.variant.equal(module.variant) {
+			return m, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%q replaces %q, which has no variant %q",
+		module.properties.Name, name, c.prettyPrintVariant(module.variant))
+}
+
+// overrideModules replaces the dependencies on the modules listed in the
+// overrides properties of the modules.
+func (c *Context) overrideModules() (errs []error) {
+	var replacements []replacement
+	overriddenBy := make(map[*moduleInfo]*moduleInfo)
+
+	for _, name := range c.sortedModuleNames() {
+		for _, module := range c.moduleGroups[name].modules {
+			for _, overridden := range module.properties.Overrides {
+				from, err := c.replacedVariant(module, overridden)
+				if err != nil {
+					errs = append(errs, &Error{
+						Err: err,
+						Pos: module.propertyPos["overrides"],
+					})
+					continue
+				}
+
+				if other, ok := overriddenBy[from]; ok {
+					errs = append(errs, &Error{
+						Err: fmt.Errorf("%q is overridden by both %q and %q",
+							from.properties.Name, other.properties.Name,
+							module.properties.Name),
+						Pos: module.propertyPos["overrides"],
+					})
+					continue
+				}
+				overriddenBy[from] = module
+
+				replacements = append(replacements, replacement{from, module})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	c.replaceDependencies(replacements)
+
+	return nil
+}
+
+// replaceDependencies applies replacements to the dependencies of every
+// module.
+func (c *Context) replaceDependencies(replacements []replacement) {
+	if len(replacements) == 0 {
+		return
+	}
+
+	replacedBy := make(map[*moduleInfo]*moduleInfo)
+	for _, r := range replacements {
+		replacedBy[r.from] = r.to
+	}
+
+	for _, group := range c.moduleGroups {
+		for _, module := range group.modules {
+			var deps []*moduleInfo
+			changed := false
+			for _, dep := range module.directDeps {
+				if to, ok := replacedBy[dep]; ok && to != module {
+					dep = to
+					changed = true
+				}
+				deps = append(deps, dep)
+			}
+			if changed {
+				module.directDeps = uniqueDeps(deps)
+			}
+		}
+	}
+}
+
+// uniqueDeps returns deps without the duplicates, which replacing a
+// dependency with one the module already has creates.
+func uniqueDeps(deps []*moduleInfo) []*moduleInfo {
+	seen := make(map[*moduleInfo]bool)
+	unique := deps[:0]
+	for _, dep := range deps {
+		if !seen[dep] {
+			seen[dep] = true
+			unique = append(unique, dep)
+		}
+	}
+	return unique
+}
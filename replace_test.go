@@ -0,0 +1,135 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+func prepareReplaceTest(files []blueprintsTestFile,
+	mutator BottomUpMutator) (*Context, []error) {
+
+	return prepareTestFiles(func(ctx *Context) {
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		if mutator != nil {
+			ctx.RegisterBottomUpMutator("replace", mutator)
+		}
+	}, files)
+}
+
+// directDepNames returns the names of the direct dependencies of the module
+// named name.
+func directDepNames(ctx *Context, name string) []string {
+	var names []string
+	for _, dep := range ctx.moduleGroups[name].modules[0].directDeps {
+		names = append(names, dep.properties.Name)
+	}
+	return names
+}
+
+func TestOverrides(t *testing.T) {
+	ctx, errs := prepareReplaceTest([]blueprintsTestFile{
+		{"Blueprints", `
+			foo_module { name: "app", deps: ["libfoo", "libbar"] }
+			foo_module { name: "libbar", deps: ["libfoo"] }
+			foo_module { name: "libfoo" }
+		`},
+		{"vendor/Blueprints", `
+			foo_module {
+				name: "vendor_libfoo",
+				deps: ["libfoo"],
+				overrides: ["libfoo"],
+			}
+		`},
+	}, nil)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	expected := map[string][]string{
+		"app":           {"vendor_libfoo", "libbar"},
+		"libbar":        {"vendor_libfoo"},
+		"vendor_libfoo": {"libfoo"},
+	}
+	for name, deps := range expected {
+		if got := directDepNames(ctx, name); !reflect.DeepEqual(got, deps) {
+			t.Errorf("expected deps of %q to be %q, got %q", name, deps, got)
+		}
+	}
+}
+
+func TestOverridesErrors(t *testing.T) {
+	testCases := []struct {
+		contents string
+		err      string
+	}{
+		{
+			contents: `foo_module { name: "a", overrides: ["b"] }`,
+			err:      `Blueprints:1:34: "a" replaces undefined module "b"`,
+		},
+		{
+			contents: `foo_module { name: "a", overrides: ["a"] }`,
+			err:      `Blueprints:1:34: "a" replaces itself`,
+		},
+		{
+			contents: `
+				foo_module { name: "a" }
+				foo_module { name: "b", overrides: ["a"] }
+				foo_module { name: "c", overrides: ["a"] }
+			`,
+			err: `Blueprints:4:38: "a" is overridden by both "b" and "c"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, errs := prepareReplaceTest([]blueprintsTestFile{
+			{"Blueprints", testCase.contents},
+		}, nil)
+		if len(errs) == 0 || errs[0].Error() != testCase.err {
+			t.Errorf("expected error %q, got %v", testCase.err, errs)
+		}
+	}
+}
+
+func TestReplaceDependencies(t *testing.T) {
+	ctx, errs := prepareReplaceTest([]blueprintsTestFile{
+		{"Blueprints", `
+			foo_module { name: "app", deps: ["libfoo", "libbar"] }
+			foo_module { name: "libbar", deps: ["libfoo"] }
+			foo_module { name: "libfoo" }
+			foo_module { name: "libfoo_fast", foo: "replace libfoo", deps: ["libbaz"] }
+			foo_module { name: "libbaz" }
+		`},
+	}, func(mctx BottomUpMutatorContext) {
+		if m, ok := mctx.Module().(*fooModule); ok && m.Foo() == "replace libfoo" {
+			mctx.ReplaceDependencies("libfoo")
+		}
+	})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	expected := map[string][]string{
+		"app":         {"libfoo_fast", "libbar"},
+		"libbar":      {"libfoo_fast"},
+		"libfoo_fast": {"libbaz"},
+	}
+	for name, deps := range expected {
+		if got := directDepNames(ctx, name); !reflect.DeepEqual(got, deps) {
+			t.Errorf("expected deps of %q to be %q, got %q", name, deps, got)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// RestrictModuleTypes limits the module types that may be used in the
+// Blueprints files in dir and its subdirectories to those that match one of
+// patterns, which use the syntax of filepath.Match.  For example
+//
+//	ctx.RestrictModuleTypes("vendor", "prebuilt_*")
+//
+// only allows prebuilt modules under vendor/.  dir is relative to the
+// directory of the root Blueprints file.  If restrictions are set for nested
+// directories the innermost one applies, so a subdirectory can allow module
+// types that its parent doesn't.  Modules of other types are reported as
+// errors when the Blueprints files are parsed.
+func (c *Context) RestrictModuleTypes(dir string, patterns ...string) {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			panic(fmt.Errorf("invalid module type pattern %q: %s", pattern, err))
+		}
+	}
+
+	if c.moduleTypeRestrictions == nil {
+		c.moduleTypeRestrictions = make(map[string][]string)
+	}
+
+	c.moduleTypeRestrictions[filepath.Clean(dir)] = patterns
+}
+
+// checkModuleTypeAllowed returns an error if the restriction set with
+// RestrictModuleTypes that applies to relBlueprintsFile doesn't allow
+// typeName.
+func (c *Context) checkModuleTypeAllowed(typeName, relBlueprintsFile string) error {
+	if len(c.moduleTypeRestrictions) == 0 {
+		return nil
+	}
+
+	dir := filepath.Dir(relBlueprintsFile)
+	for {
+		if patterns, ok := c.moduleTypeRestrictions[dir]; ok {
+			for _, pattern := range patterns {
+				if match, _ := filepath.Match(pattern, typeName); match {
+					return nil
+				}
+			}
+
+			return fmt.Errorf("module type %q is not allowed in %s (allowed: %s)",
+				typeName, dir, strings.Join(patterns, ", "))
+		}
+
+		if dir == "." || dir == "/" {
+			return nil
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"testing"
+)
+
+var restrictTestCases = []struct {
+	file string
+	typ  string
+	err  string
+}{
+	{
+		file: "Blueprints",
+		typ:  "foo_module",
+	},
+	{
+		file: "vendor/Blueprints",
+		typ:  "prebuilt_foo",
+	},
+	{
+		file: "vendor/a/b/Blueprints",
+		typ:  "prebuilt_foo",
+	},
+	{
+		file: "vendor/a/b/Blueprints",
+		typ:  "foo_module",
+		err:  `vendor/a/b/Blueprints:1:1: module type "foo_module" is not allowed in vendor (allowed: prebuilt_*)`,
+	},
+	{
+		file: "vendor/tools/Blueprints",
+		typ:  "foo_module",
+	},
+	{
+		file: "vendor/tools/x/Blueprints",
+		typ:  "prebuilt_foo",
+		err:  `vendor/tools/x/Blueprints:1:1: module type "prebuilt_foo" is not allowed in vendor/tools (allowed: foo_module, bar_module)`,
+	},
+	{
+		file: "vendored/Blueprints",
+		typ:  "foo_module",
+	},
+}
+
+func TestRestrictModuleTypes(t *testing.T) {
+	for _, testCase := range restrictTestCases {
+		ctx := NewContext()
+		ctx.RegisterModuleType("foo_module", newFooModule)
+		ctx.RegisterModuleType("bar_module", newBarModule)
+		ctx.RegisterModuleType("prebuilt_foo", newFooModule)
+		ctx.RestrictModuleTypes("vendor", "prebuilt_*")
+		ctx.RestrictModuleTypes("vendor/tools/", "foo_module", "bar_module")
+
+		r := bytes.NewBufferString(testCase.typ + ` { name: "a" }`)
+
+		_, _, _, errs := ctx.parse(".", testCase.file, r, nil)
+
+		if testCase.err == "" {
+			if len(errs) > 0 {
+				t.Errorf("%s in %s: unexpected errors: %v", testCase.typ,
+					testCase.file, errs)
+			}
+			continue
+		}
+
+		if len(errs) != 1 || errs[0].Error() != testCase.err {
+			t.Errorf("%s in %s: incorrect errors:", testCase.typ, testCase.file)
+			t.Errorf("     got: %v", errs)
+			t.Errorf("expected: %s", testCase.err)
+		}
+	}
+}
@@ -307,6 +307,33 @@ func (s *localScope) AddLocalVariable(name, value string) (*localVariable,
 	return v, nil
 }
 
+func (s *localScope) AddLocalPool(name string, params *PoolParams) (*localPool,
+	error) {
+
+	err := validateNinjaName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	def, err := parsePoolParams(s.scope, params)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &localPool{
+		namePrefix: s.namePrefix,
+		name_:      name,
+		def_:       def,
+	}
+
+	err = s.scope.AddPool(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
 func (s *localScope) AddLocalRule(name string, params *RuleParams,
 	argNames ...string) (*localRule, error) {
 
@@ -374,6 +401,32 @@ func (l *localVariable) String() string {
 	return "<local var>:" + l.namePrefix + l.name_
 }
 
+type localPool struct {
+	namePrefix string
+	name_      string
+	def_       *poolDef
+}
+
+func (l *localPool) packageContext() *PackageContext {
+	return nil
+}
+
+func (l *localPool) name() string {
+	return l.name_
+}
+
+func (l *localPool) fullName(pkgNames map[*PackageContext]string) string {
+	return l.namePrefix + l.name_
+}
+
+func (l *localPool) def(interface{}) (*poolDef, error) {
+	return l.def_, nil
+}
+
+func (l *localPool) String() string {
+	return "<local pool>:" + l.namePrefix + l.name_
+}
+
 type localRule struct {
 	namePrefix string
 	name_      string
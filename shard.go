@@ -0,0 +1,132 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// A ShardedBuildParams object describes a build that BuildSharded splits into
+// shards, each of which is built from some of the inputs by a separate Ninja
+// build statement, and a build statement that combines the outputs of the
+// shards into the output.
+type ShardedBuildParams struct {
+	Rule      Rule              // The rule that builds a shard from its inputs.
+	Inputs    []string          // The inputs to split between the shards.
+	Implicits []string          // The implicit dependencies of each shard.
+	OrderOnly []string          // The order-only dependencies of each shard.
+	Args      map[string]string // The variables to set for each shard.
+
+	// ShardOutput returns the output of the shard with the given index.  If
+	// it is nil the outputs of the shards are Output with the suffix
+	// ".shard<index>".
+	ShardOutput func(shard int) string
+
+	// MaxShardInputs is the number of inputs that a shard is meant to have.
+	// It is used to compute the number of shards with ShardCount.
+	MaxShardInputs int
+
+	CombineRule Rule              // The rule that combines the outputs of the shards.
+	Output      string            // The output of the combining build statement.
+	CombineArgs map[string]string // The variables to set for the combining build statement.
+	Optional    bool              // Skip outputting a default statement for Output.
+}
+
+// ShardCount returns the number of shards to split numInputs inputs between so
+// that each shard has about maxShardInputs inputs.  The count is a power of
+// two, so that it only changes when the number of inputs doubles or halves,
+// rather than every time an input is added.
+func ShardCount(numInputs, maxShardInputs int) int {
+	if maxShardInputs <= 0 {
+		panic(fmt.Errorf("invalid maximum shard inputs %d", maxShardInputs))
+	}
+
+	shards := 1
+	for shards*maxShardInputs < numInputs {
+		shards *= 2
+	}
+	return shards
+}
+
+// ShardInputs splits inputs between shards shards.  Each input is assigned to
+// a shard by a hash of its path, so that adding or removing an input only
+// changes the inputs of its own shard and the others don't need to be rebuilt.
+// The inputs of each shard are in the order they appear in inputs, and a shard
+// may have no inputs.
+func ShardInputs(inputs []string, shards int) [][]string {
+	if shards <= 0 {
+		panic(fmt.Errorf("invalid shard count %d", shards))
+	}
+
+	sharded := make([][]string, shards)
+	for _, input := range inputs {
+		h := fnv.New32a()
+		h.Write([]byte(input))
+		shard := int(h.Sum32() % uint32(shards))
+		sharded[shard] = append(sharded[shard], input)
+	}
+	return sharded
+}
+
+// BuildSharded splits the inputs of params between a number of shards computed
+// with ShardCount and assigned with ShardInputs, adds a build statement to ctx
+// for each shard that has inputs, and one that combines their outputs into
+// params.Output.  It returns the outputs of the shards that are built.
+func BuildSharded(ctx ActionEmitter, pctx *PackageContext,
+	params ShardedBuildParams) []string {
+
+	if params.Output == "" {
+		panic(fmt.Errorf("sharded build has no output"))
+	}
+
+	shardOutput := params.ShardOutput
+	if shardOutput == nil {
+		shardOutput = func(shard int) string {
+			return fmt.Sprintf("%s.shard%d", params.Output, shard)
+		}
+	}
+
+	shards := ShardCount(len(params.Inputs), params.MaxShardInputs)
+
+	var outputs []string
+	for shard, inputs := range ShardInputs(params.Inputs, shards) {
+		if len(inputs) == 0 {
+			continue
+		}
+
+		output := shardOutput(shard)
+		ctx.Build(pctx, BuildParams{
+			Rule:      params.Rule,
+			Outputs:   []string{output},
+			Inputs:    inputs,
+			Implicits: params.Implicits,
+			OrderOnly: params.OrderOnly,
+			Args:      params.Args,
+			Optional:  true,
+		})
+		outputs = append(outputs, output)
+	}
+
+	ctx.Build(pctx, BuildParams{
+		Rule:     params.CombineRule,
+		Outputs:  []string{params.Output},
+		Inputs:   outputs,
+		Args:     params.CombineArgs,
+		Optional: params.Optional,
+	})
+
+	return outputs
+}
@@ -0,0 +1,161 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	shardTestPctx = NewPackageContext("github.com/google/blueprint/shardtest")
+
+	shardTestCompile = shardTestPctx.StaticRule("compile",
+		RuleParams{
+			Command: "compile $in > $out",
+		})
+	shardTestCombine = shardTestPctx.StaticRule("combine",
+		RuleParams{
+			Command: "cat $in > $out",
+		})
+)
+
+type shardModule struct {
+	properties struct {
+		Srcs []string
+	}
+	shardOutputs []string
+}
+
+func newShardModule() (Module, []interface{}) {
+	m := &shardModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (m *shardModule) GenerateBuildActions(ctx ModuleContext) {
+	m.shardOutputs = BuildSharded(ctx, shardTestPctx, ShardedBuildParams{
+		Rule:           shardTestCompile,
+		Inputs:         m.properties.Srcs,
+		MaxShardInputs: 2,
+		CombineRule:    shardTestCombine,
+		Output:         ctx.ModuleName() + ".out",
+	})
+}
+
+func TestShardCount(t *testing.T) {
+	testCases := []struct {
+		inputs, max, expected int
+	}{
+		{0, 10, 1},
+		{10, 10, 1},
+		{11, 10, 2},
+		{20, 10, 2},
+		{21, 10, 4},
+		{1000, 10, 128},
+	}
+
+	for _, testCase := range testCases {
+		if got := ShardCount(testCase.inputs, testCase.max); got != testCase.expected {
+			t.Errorf("ShardCount(%d, %d): expected %d, got %d", testCase.inputs,
+				testCase.max, testCase.expected, got)
+		}
+	}
+}
+
+func TestShardInputsStable(t *testing.T) {
+	var inputs []string
+	for i := 0; i < 100; i++ {
+		inputs = append(inputs, fmt.Sprintf("src/file%d.c", i))
+	}
+
+	sharded := ShardInputs(inputs, 8)
+	count := 0
+	for _, shard := range sharded {
+		count += len(shard)
+	}
+	if count != len(inputs) {
+		t.Fatalf("expected %d sharded inputs, got %d", len(inputs), count)
+	}
+
+	// Adding an input only changes the inputs of the shard it is added to.
+	added := ShardInputs(append([]string{"src/new.c"}, inputs...), 8)
+	changed := 0
+	for i := range sharded {
+		if !reflect.DeepEqual(sharded[i], added[i]) {
+			changed++
+		}
+	}
+	if changed != 1 {
+		t.Errorf("expected 1 shard to change, got %d", changed)
+	}
+}
+
+func TestBuildSharded(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("shard_module", newShardModule)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", bytes.NewBufferString(`
+		shard_module {
+			name: "a",
+			srcs: ["a.c", "b.c", "c.c", "d.c", "e.c"],
+		}
+	`), nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	m := ctx.moduleGroups["a"].modules[0].logicModule.(*shardModule)
+
+	var expected []string
+	for i, inputs := range ShardInputs(m.properties.Srcs, 4) {
+		if len(inputs) > 0 {
+			expected = append(expected, fmt.Sprintf("a.out.shard%d", i))
+		}
+	}
+	if !reflect.DeepEqual(m.shardOutputs, expected) {
+		t.Errorf("expected shard outputs %q, got %q", expected, m.shardOutputs)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	ck(ctx.WriteBuildFile(buf))
+	manifest := strings.Replace(buf.String(), " $\n        ", " ", -1)
+
+	combine := "build a.out: g.shardtest.combine " + strings.Join(expected, " ")
+	if !strings.Contains(manifest, combine) {
+		t.Errorf("expected manifest to contain %q:\n%s", combine, manifest)
+	}
+	for i, inputs := range ShardInputs(m.properties.Srcs, 4) {
+		if len(inputs) == 0 {
+			continue
+		}
+		shard := fmt.Sprintf("build a.out.shard%d: g.shardtest.compile %s", i,
+			strings.Join(inputs, " "))
+		if !strings.Contains(manifest, shard) {
+			t.Errorf("expected manifest to contain %q:\n%s", shard, manifest)
+		}
+	}
+}
@@ -16,6 +16,7 @@ package blueprint
 
 import (
 	"fmt"
+	"sync"
 )
 
 type Singleton interface {
@@ -23,6 +24,8 @@ type Singleton interface {
 }
 
 type SingletonContext interface {
+	ActionEmitter
+
 	Config() interface{}
 
 	ModuleName(module Module) string
@@ -32,9 +35,10 @@ type SingletonContext interface {
 	ModuleErrorf(module Module, format string, args ...interface{})
 	Errorf(format string, args ...interface{})
 
-	Variable(pctx *PackageContext, name, value string)
-	Rule(pctx *PackageContext, name string, params RuleParams, argNames ...string) Rule
-	Build(pctx *PackageContext, params BuildParams)
+	// ModuleProvider returns the value of provider set by module with
+	// ModuleContext.SetProvider, and whether it was set.
+	ModuleProvider(module Module, provider ProviderKey) (interface{}, bool)
+
 	RequireNinjaVersion(major, minor, micro int)
 
 	// SetBuildDir sets the value of the top-level "builddir" Ninja variable
@@ -43,90 +47,137 @@ type SingletonContext interface {
 	// across different singletons) will result in a panic.
 	SetBuildDir(pctx *PackageContext, value string)
 
+	// SetSubninjaDir splits the Ninja manifest that Context.WriteBuildFiles
+	// writes: the build actions of the modules defined in each directory are
+	// written to a separate Ninja file in dir, which the manifest includes
+	// with a subninja statement.  This value can be set at most one time for
+	// a single build.  Setting it multiple times will result in a panic.
+	SetSubninjaDir(dir string)
+
 	VisitAllModules(visit func(Module))
 	VisitAllModulesIf(pred func(Module) bool, visit func(Module))
 	VisitDepsDepthFirst(module Module, visit func(Module))
 	VisitDepsDepthFirstIf(module Module, pred func(Module) bool,
 		visit func(Module))
 
-	AddNinjaFileDeps(deps ...string)
+	// VisitReverseDeps calls visit for each module that directly depends on
+	// module, which is cheaper than searching the dependencies of every
+	// module with VisitAllModules.
+	VisitReverseDeps(module Module, visit func(Module))
+	VisitReverseDepsIf(module Module, pred func(Module) bool,
+		visit func(Module))
+
+	// Globs returns the globs performed by modules with ModuleContext.Glob.
+	// Singletons run after all modules have generated their build actions, so
+	// the list is complete.
+	Globs() []GlobPath
+}
+
+// A ParallelSingleton is a Singleton that collects data from every module
+// concurrently before its GenerateBuildActions method is called.  It is
+// registered with Context.RegisterParallelSingletonType.
+type ParallelSingleton interface {
+	Singleton
+
+	// VisitModule is called once for each module, from multiple goroutines at
+	// the same time, and returns the data collected from the module or nil.
+	// It must not modify the module or any state shared with other calls.
+	VisitModule(ctx SingletonVisitContext, module Module) interface{}
+
+	// MergeModuleData is called once, after VisitModule has been called for
+	// every module and before GenerateBuildActions, with the non-nil values
+	// returned by VisitModule in the order that VisitAllModules visits the
+	// modules.
+	MergeModuleData(data []interface{})
+}
+
+// A SingletonVisitContext is passed to ParallelSingleton.VisitModule.  Its
+// methods may be called from multiple goroutines.
+type SingletonVisitContext interface {
+	Config() interface{}
+
+	ModuleName(module Module) string
+	ModuleDir(module Module) string
+	BlueprintFile(module Module) string
+
+	ModuleErrorf(module Module, format string, args ...interface{})
 }
 
 var _ SingletonContext = (*singletonContext)(nil)
+var _ SingletonVisitContext = (*singletonVisitContext)(nil)
 
-type singletonContext struct {
+type singletonVisitContext struct {
 	context *Context
 	config  interface{}
-	scope   *localScope
-
-	ninjaFileDeps []string
-	errs          []error
 
-	actionDefs localBuildActions
+	errsLock sync.Mutex
+	errs     []error
 }
 
-func (s *singletonContext) Config() interface{} {
+func (s *singletonVisitContext) Config() interface{} {
 	return s.config
 }
 
-func (s *singletonContext) ModuleName(logicModule Module) string {
+func (s *singletonVisitContext) ModuleName(logicModule Module) string {
 	return s.context.ModuleName(logicModule)
 }
 
-func (s *singletonContext) ModuleDir(logicModule Module) string {
+func (s *singletonVisitContext) ModuleDir(logicModule Module) string {
 	return s.context.ModuleDir(logicModule)
 }
 
-func (s *singletonContext) BlueprintFile(logicModule Module) string {
+func (s *singletonVisitContext) BlueprintFile(logicModule Module) string {
 	return s.context.BlueprintFile(logicModule)
 }
 
-func (s *singletonContext) ModuleErrorf(logicModule Module, format string,
+func (s *singletonVisitContext) ModuleErrorf(logicModule Module, format string,
 	args ...interface{}) {
 
-	s.errs = append(s.errs, s.context.ModuleErrorf(logicModule, format, args...))
+	s.addError(s.context.ModuleErrorf(logicModule, format, args...))
 }
 
-func (s *singletonContext) Errorf(format string, args ...interface{}) {
-	// TODO: Make this not result in the error being printed as "internal error"
-	s.errs = append(s.errs, fmt.Errorf(format, args...))
+func (s *singletonVisitContext) addError(err error) {
+	s.errsLock.Lock()
+	defer s.errsLock.Unlock()
+
+	s.errs = append(s.errs, err)
 }
 
-func (s *singletonContext) Variable(pctx *PackageContext, name, value string) {
-	s.scope.ReparentTo(pctx)
+type singletonContext struct {
+	localActions
 
-	v, err := s.scope.AddLocalVariable(name, value)
-	if err != nil {
-		panic(err)
-	}
+	context *Context
+	config  interface{}
 
-	s.actionDefs.variables = append(s.actionDefs.variables, v)
+	ninjaFileDeps []string
+	errs          []error
 }
 
-func (s *singletonContext) Rule(pctx *PackageContext, name string,
-	params RuleParams, argNames ...string) Rule {
-
-	s.scope.ReparentTo(pctx)
+func (s *singletonContext) Config() interface{} {
+	return s.config
+}
 
-	r, err := s.scope.AddLocalRule(name, &params, argNames...)
-	if err != nil {
-		panic(err)
-	}
+func (s *singletonContext) ModuleName(logicModule Module) string {
+	return s.context.ModuleName(logicModule)
+}
 
-	s.actionDefs.rules = append(s.actionDefs.rules, r)
+func (s *singletonContext) ModuleDir(logicModule Module) string {
+	return s.context.ModuleDir(logicModule)
+}
 
-	return r
+func (s *singletonContext) BlueprintFile(logicModule Module) string {
+	return s.context.BlueprintFile(logicModule)
 }
 
-func (s *singletonContext) Build(pctx *PackageContext, params BuildParams) {
-	s.scope.ReparentTo(pctx)
+func (s *singletonContext) ModuleErrorf(logicModule Module, format string,
+	args ...interface{}) {
 
-	def, err := parseBuildParams(s.scope, &params)
-	if err != nil {
-		panic(err)
-	}
+	s.errs = append(s.errs, s.context.ModuleErrorf(logicModule, format, args...))
+}
 
-	s.actionDefs.buildDefs = append(s.actionDefs.buildDefs, def)
+func (s *singletonContext) Errorf(format string, args ...interface{}) {
+	// TODO: Make this not result in the error being printed as "internal error"
+	s.errs = append(s.errs, fmt.Errorf(format, args...))
 }
 
 func (s *singletonContext) RequireNinjaVersion(major, minor, micro int) {
@@ -144,6 +195,10 @@ func (s *singletonContext) SetBuildDir(pctx *PackageContext, value string) {
 	s.context.setBuildDir(ninjaValue)
 }
 
+func (s *singletonContext) SetSubninjaDir(dir string) {
+	s.context.setSubninjaDir(dir)
+}
+
 func (s *singletonContext) VisitAllModules(visit func(Module)) {
 	s.context.VisitAllModules(visit)
 }
@@ -166,6 +221,22 @@ func (s *singletonContext) VisitDepsDepthFirstIf(module Module,
 	s.context.VisitDepsDepthFirstIf(module, pred, visit)
 }
 
+func (s *singletonContext) VisitReverseDeps(module Module,
+	visit func(Module)) {
+
+	s.context.VisitReverseDeps(module, visit)
+}
+
+func (s *singletonContext) VisitReverseDepsIf(module Module,
+	pred func(Module) bool, visit func(Module)) {
+
+	s.context.VisitReverseDepsIf(module, pred, visit)
+}
+
 func (s *singletonContext) AddNinjaFileDeps(deps ...string) {
 	s.ninjaFileDeps = append(s.ninjaFileDeps, deps...)
 }
+
+func (s *singletonContext) Globs() []GlobPath {
+	return s.context.Globs()
+}
@@ -0,0 +1,146 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var parityTestPctx = NewPackageContext("github.com/google/blueprint/paritytest")
+
+// emitParityActions uses every ActionEmitter method and BuildParams feature,
+// with paths in dir.
+func emitParityActions(ctx ActionEmitter, dir string) {
+	ctx.Variable(parityTestPctx, "dir", dir)
+	pool := ctx.Pool(parityTestPctx, "pool", PoolParams{Depth: 2})
+	rule := ctx.Rule(parityTestPctx, "cc", RuleParams{
+		Command:     "cc -MD -MF $out.d $flags -o $out $in",
+		Depfile:     "$out.d",
+		Deps:        DepsGCC,
+		Description: "cc $out",
+		Pool:        pool,
+		Restat:      true,
+	}, "flags")
+
+	ctx.Build(parityTestPctx, BuildParams{
+		Rule:           rule,
+		Outputs:        []string{"$dir/a.o", "$dir/a.so"},
+		SymlinkOutputs: []string{"$dir/a.so"},
+		Inputs:         []string{"a.c"},
+		Implicits:      []string{"$dir/gen.h"},
+		OrderOnly:      []string{"$dir/stamp"},
+		Validations:    []string{"$dir/a.lint"},
+		Args:           map[string]string{"flags": "-I$dir"},
+		Optional:       true,
+	})
+}
+
+type parityModule struct{}
+
+func newParityModule() (Module, []interface{}) {
+	return &parityModule{}, nil
+}
+
+func (m *parityModule) GenerateBuildActions(ctx ModuleContext) {
+	emitParityActions(ctx, "module")
+}
+
+type paritySingleton struct{}
+
+func (s *paritySingleton) GenerateBuildActions(ctx SingletonContext) {
+	emitParityActions(ctx, "singleton")
+}
+
+func TestSingletonBuildParity(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("parity_module", newParityModule)
+	ctx.RegisterSingletonType("parity", func() Singleton {
+		return &paritySingleton{}
+	})
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", bytes.NewBufferString(`
+		parity_module { name: "a" }
+	`), nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	ck(ctx.WriteActionGraph(buf))
+
+	graph := &ActionGraph{}
+	ck(json.Unmarshal(buf.Bytes(), graph))
+
+	if len(graph.Actions) != 2 {
+		t.Fatalf("expected 2 actions, got %d", len(graph.Actions))
+	}
+
+	// The names of the local rules and pools of modules and singletons have
+	// different prefixes, and their paths are in different directories, but
+	// everything else must be the same.
+	var normalized []string
+	for _, action := range graph.Actions {
+		if graph.Pools[action.Pool] != 2 {
+			t.Errorf("expected pool %q of depth 2, got pools %v", action.Pool, graph.Pools)
+		}
+		action.Rule = ""
+		action.Pool = ""
+
+		data, err := json.Marshal(action)
+		ck(err)
+		s := strings.Replace(string(data), "singleton/", "module/", -1)
+		normalized = append(normalized, strings.Replace(s, "-Isingleton", "-Imodule", -1))
+	}
+
+	if normalized[0] != normalized[1] {
+		t.Errorf("expected the same module and singleton actions, got:\n%s\n%s",
+			normalized[0], normalized[1])
+	}
+
+	expected := &Action{
+		Command:        "cc -MD -MF module/a.o module/a.so.d -Imodule -o module/a.o module/a.so a.c",
+		Outputs:        []string{"module/a.o", "module/a.so"},
+		SymlinkOutputs: []string{"module/a.so"},
+		Inputs:         []string{"a.c"},
+		Implicits:      []string{"module/gen.h"},
+		OrderOnly:      []string{"module/stamp"},
+		Validations:    []string{"module/a.lint"},
+		Variables: map[string]string{
+			"deps":        "gcc",
+			"depfile":     "module/a.o module/a.so.d",
+			"description": "cc module/a.o module/a.so",
+			"restat":      "true",
+		},
+		Optional: true,
+	}
+	got := &Action{}
+	ck(json.Unmarshal([]byte(normalized[0]), got))
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected action %+v, got %+v", expected, got)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// A StatusEvent describes the progress of one phase of the work done by a
+// Context, such as parsing Blueprints files or generating build actions.
+type StatusEvent struct {
+	Time     time.Time     // When the event occurred.
+	Phase    string        // The phase, e.g. "parse" or "generate".
+	Unit     string        // What is being counted, e.g. "files" or "modules".
+	Done     int           // The number of units finished so far.
+	Total    int           // The number of units known so far.
+	Elapsed  time.Duration // The time since the phase started.
+	Rate     float64       // The number of units finished per second.
+	ETA      time.Duration // The estimated time remaining, or 0 if unknown.
+	Finished bool          // Whether this is the last event for the phase.
+}
+
+// A StatusLogger receives StatusEvents from a Context as it makes progress.
+// Event may be called from multiple goroutines, but is never called
+// concurrently for the same Context.
+type StatusLogger interface {
+	Event(event StatusEvent)
+}
+
+// SetStatusLogger sets a StatusLogger that will receive progress updates while
+// the Context parses Blueprints files, runs mutators, and generates build
+// actions.  Passing nil removes any previously set StatusLogger.
+func (c *Context) SetStatusLogger(logger StatusLogger) {
+	c.statusLogger = logger
+}
+
+// A statusTracker counts the progress of a single phase and reports it to a
+// StatusLogger.  All of its methods do nothing if there is no StatusLogger.
+type statusTracker struct {
+	logger StatusLogger
+	phase  string
+	unit   string
+	start  time.Time
+
+	lock  sync.Mutex
+	done  int
+	total int
+}
+
+func (c *Context) startStatus(phase, unit string, total int) *statusTracker {
+	s := &statusTracker{
+		logger: c.statusLogger,
+		phase:  phase,
+		unit:   unit,
+		start:  time.Now(),
+		total:  total,
+	}
+
+	s.update(0, 0, false)
+
+	return s
+}
+
+// add records that done more units have been finished and that total more
+// units have been discovered.
+func (s *statusTracker) add(done, total int) {
+	s.update(done, total, false)
+}
+
+func (s *statusTracker) finish() {
+	s.update(0, 0, true)
+}
+
+func (s *statusTracker) update(done, total int, finished bool) {
+	if s.logger == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.done += done
+	s.total += total
+
+	now := time.Now()
+	event := StatusEvent{
+		Time:     now,
+		Phase:    s.phase,
+		Unit:     s.unit,
+		Done:     s.done,
+		Total:    s.total,
+		Elapsed:  now.Sub(s.start),
+		Finished: finished,
+	}
+
+	if seconds := event.Elapsed.Seconds(); seconds > 0 {
+		event.Rate = float64(event.Done) / seconds
+	}
+
+	if event.Rate > 0 && event.Total > event.Done {
+		remaining := float64(event.Total-event.Done) / event.Rate
+		event.ETA = time.Duration(remaining * float64(time.Second))
+	}
+
+	s.logger.Event(event)
+}
+
+// NewConsoleStatusLogger returns a StatusLogger that writes a timestamped line
+// of text to w for each phase as it finishes, and at most once per interval
+// while a phase is in progress.
+func NewConsoleStatusLogger(w io.Writer, interval time.Duration) StatusLogger {
+	return &consoleStatusLogger{
+		w:        w,
+		interval: interval,
+	}
+}
+
+type consoleStatusLogger struct {
+	w        io.Writer
+	interval time.Duration
+	last     time.Time
+}
+
+func (l *consoleStatusLogger) Event(event StatusEvent) {
+	if !event.Finished && event.Time.Sub(l.last) < l.interval {
+		return
+	}
+	l.last = event.Time
+
+	fmt.Fprintln(l.w, formatStatusEvent(event))
+}
+
+func formatStatusEvent(event StatusEvent) string {
+	s := fmt.Sprintf("[%s] %s: %d/%d %s", event.Time.Format("15:04:05.000"),
+		event.Phase, event.Done, event.Total, event.Unit)
+
+	if event.Finished {
+		return s + fmt.Sprintf(" in %s", event.Elapsed.Round(time.Millisecond))
+	}
+
+	s += fmt.Sprintf(", %.1f/s", event.Rate)
+	if event.ETA > 0 {
+		s += fmt.Sprintf(", ETA %s", event.ETA.Round(time.Second))
+	}
+
+	return s
+}
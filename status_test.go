@@ -0,0 +1,134 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+type recordingStatusLogger struct {
+	events []StatusEvent
+}
+
+func (l *recordingStatusLogger) Event(event StatusEvent) {
+	l.events = append(l.events, event)
+}
+
+func TestStatusLogger(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+
+	logger := &recordingStatusLogger{}
+	ctx.SetStatusLogger(logger)
+
+	r := bytes.NewBufferString(`
+		foo_module {
+			name: "MyFooModule",
+			deps: ["MyBarModule"],
+		}
+
+		bar_module {
+			name: "MyBarModule",
+		}
+	`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	var generate []StatusEvent
+	for _, event := range logger.events {
+		if event.Phase == "generate" {
+			generate = append(generate, event)
+		}
+	}
+
+	if len(generate) != 4 {
+		t.Fatalf("expected 4 generate events, got %d: %#v", len(generate), generate)
+	}
+
+	for i, event := range generate {
+		expectedDone := i
+		if event.Finished {
+			expectedDone = 2
+		}
+		if event.Done != expectedDone || event.Total != 2 || event.Unit != "modules" {
+			t.Errorf("incorrect event %d: %#v", i, event)
+		}
+	}
+
+	if !generate[3].Finished {
+		t.Errorf("expected last generate event to be finished")
+	}
+}
+
+func TestConsoleStatusLogger(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	logger := NewConsoleStatusLogger(buf, time.Hour)
+
+	start := time.Date(2015, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	logger.Event(StatusEvent{
+		Time:  start,
+		Phase: "generate",
+		Unit:  "modules",
+		Done:  10,
+		Total: 30,
+		Rate:  5,
+		ETA:   4 * time.Second,
+	})
+
+	// Throttled by the interval.
+	logger.Event(StatusEvent{
+		Time:  start.Add(time.Second),
+		Phase: "generate",
+		Unit:  "modules",
+		Done:  20,
+		Total: 30,
+	})
+
+	logger.Event(StatusEvent{
+		Time:     start.Add(2 * time.Second),
+		Phase:    "generate",
+		Unit:     "modules",
+		Done:     30,
+		Total:    30,
+		Elapsed:  6 * time.Second,
+		Finished: true,
+	})
+
+	expected := "[12:00:00.000] generate: 10/30 modules, 5.0/s, ETA 4s\n" +
+		"[12:00:02.000] generate: 30/30 modules in 6s\n"
+
+	if buf.String() != expected {
+		t.Errorf("incorrect output")
+		t.Errorf("  expected: %q", expected)
+		t.Errorf("       got: %q", buf.String())
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// subninjaFileName is the name of the Ninja file for each directory in the
+// subninja directory.
+const subninjaFileName = "build.ninja"
+
+// WriteBuildFiles writes the Ninja manifest text for the generated build
+// actions like WriteBuildFile.  If a singleton called
+// SingletonContext.SetSubninjaDir, the build actions of the modules defined in
+// each directory are instead written to a separate Ninja file, which is created
+// with create, and w includes the files with subninja statements.
+//
+// The global variables, pools and rules of the PackageContexts are written to
+// w before the subninja statements, so that every file can use them, while the
+// variables and rules of a module are local to the file of its directory.  The
+// build actions of the singletons are written to w after the subninja
+// statements.  If this is called before PrepareBuildActions successfully
+// completes then ErrBuildActionsNotReady is returned.
+func (c *Context) WriteBuildFiles(w io.Writer,
+	create func(path string) (io.WriteCloser, error)) (err error) {
+
+	if !c.buildActionsReady {
+		return ErrBuildActionsNotReady
+	}
+
+	var traceErrs []error
+	defer func() {
+		if err == nil && len(traceErrs) > 0 {
+			err = traceErrs[0]
+		}
+	}()
+	defer c.traceTopLevel("write", &traceErrs)()
+
+	mw := newNinjaManifestWriter(w, c.ninjaSymlinkOutputs)
+
+	writeModules := c.writeAllModuleActions
+	if c.subninjaDir != "" {
+		writeModules = func(ManifestWriter) error {
+			return c.writeSubninjas(mw, create)
+		}
+	}
+
+	err = c.writeManifest(mw, writeModules)
+	if err != nil {
+		return err
+	}
+	return mw.Flush()
+}
+
+// SubninjaFiles returns the paths of the Ninja files that WriteBuildFiles
+// writes the build actions of the modules to, or nil if the manifest is not
+// split.
+func (c *Context) SubninjaFiles() []string {
+	if c.subninjaDir == "" {
+		return nil
+	}
+
+	var files []string
+	for _, dir := range c.subninjaDirs() {
+		files = append(files, c.subninjaFile(dir))
+	}
+	return files
+}
+
+func (c *Context) setSubninjaDir(dir string) {
+	if c.subninjaDir != "" {
+		panic("subninjaDir set multiple times")
+	}
+	if dir == "" {
+		panic("subninjaDir must not be empty")
+	}
+	c.subninjaDir = filepath.Clean(dir)
+}
+
+// subninjaDirs returns the sorted directories of the Blueprints files that
+// define modules.
+func (c *Context) subninjaDirs() []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, module := range c.moduleInfo {
+		dir := filepath.Dir(module.relBlueprintsFile)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+func (c *Context) subninjaFile(dir string) string {
+	return filepath.Join(c.subninjaDir, dir, subninjaFileName)
+}
+
+// writeSubninjas writes the build actions of the modules in each directory to
+// the subninja file of the directory, and includes the files in mw.
+func (c *Context) writeSubninjas(mw *ninjaManifestWriter,
+	create func(path string) (io.WriteCloser, error)) error {
+
+	dirModules := make(map[string][]*moduleInfo)
+	for _, module := range c.moduleInfo {
+		dir := filepath.Dir(module.relBlueprintsFile)
+		dirModules[dir] = append(dirModules[dir], module)
+	}
+
+	for _, dir := range c.subninjaDirs() {
+		modules := dirModules[dir]
+		sort.Sort(moduleSorter(modules))
+
+		file := c.subninjaFile(dir)
+		err := c.writeSubninja(file, dir, modules, create)
+		if err != nil {
+			return err
+		}
+
+		err = mw.nw.Subninja(inputEscaper.Replace(file))
+		if err != nil {
+			return err
+		}
+	}
+
+	return mw.BlankLine()
+}
+
+func (c *Context) writeSubninja(file, dir string, modules []*moduleInfo,
+	create func(path string) (io.WriteCloser, error)) (err error) {
+
+	w, err := create(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := w.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+
+	mw := newNinjaManifestWriter(w, c.ninjaSymlinkOutputs)
+
+	err = mw.Comment(fmt.Sprintf(subninjaHeaderTemplate, dir))
+	if err != nil {
+		return err
+	}
+
+	err = mw.BlankLine()
+	if err != nil {
+		return err
+	}
+
+	err = c.writeModuleActions(mw, modules)
+	if err != nil {
+		return err
+	}
+	return mw.Flush()
+}
+
+var subninjaHeaderTemplate = `******************************************************************************
+***            This file is generated and should not be edited             ***
+******************************************************************************
+
+This file contains the build actions of the modules defined in %s.
+It is included by the top-level Ninja manifest with a subninja statement, and
+uses the variables, pools and rules that the manifest defines.
+`
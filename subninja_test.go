@@ -0,0 +1,186 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	subninjaTestPctx = NewPackageContext("github.com/google/blueprint/subninjatest")
+
+	subninjaTestTool = subninjaTestPctx.StaticVariable("tool", "subninja_tool")
+
+	subninjaTestRule = subninjaTestPctx.StaticRule("copy",
+		RuleParams{
+			Command: "$tool $in > $out",
+		})
+)
+
+type subninjaModule struct {
+	properties struct {
+		Out string
+	}
+}
+
+func newSubninjaModule() (Module, []interface{}) {
+	m := &subninjaModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (m *subninjaModule) GenerateBuildActions(ctx ModuleContext) {
+	rule := ctx.Rule(subninjaTestPctx, "local", RuleParams{
+		Command: "$tool -local $in > $out",
+	})
+
+	ctx.Build(subninjaTestPctx, BuildParams{
+		Rule:    subninjaTestRule,
+		Outputs: []string{m.properties.Out},
+	})
+	ctx.Build(subninjaTestPctx, BuildParams{
+		Rule:    rule,
+		Outputs: []string{m.properties.Out + ".local"},
+	})
+}
+
+type subninjaSingleton struct {
+	dir string
+}
+
+func (s *subninjaSingleton) GenerateBuildActions(ctx SingletonContext) {
+	if s.dir != "" {
+		ctx.SetSubninjaDir(s.dir)
+	}
+}
+
+type subninjaBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *subninjaBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func runSubninjaTest(t *testing.T, dir string) (*Context, string, map[string]*subninjaBuffer) {
+	ctx, errs := prepareTestFiles(func(ctx *Context) {
+		ctx.RegisterModuleType("subninja_module", newSubninjaModule)
+		ctx.RegisterSingletonType("subninja", func() Singleton {
+			return &subninjaSingleton{dir}
+		})
+	}, []blueprintsTestFile{
+		{"Blueprints", `subninja_module { name: "a", out: "a.out" }`},
+		{"b/Blueprints", `
+			subninja_module { name: "b2", out: "b2.out" }
+			subninja_module { name: "b1", out: "b1.out" }
+		`},
+	})
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	files := make(map[string]*subninjaBuffer)
+	buf := bytes.NewBuffer(nil)
+	err := ctx.WriteBuildFiles(buf, func(path string) (io.WriteCloser, error) {
+		if files[path] != nil {
+			t.Errorf("subninja file %q created twice", path)
+		}
+		files[path] = &subninjaBuffer{}
+		return files[path], nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	return ctx, buf.String(), files
+}
+
+func TestSubninja(t *testing.T) {
+	ctx, manifest, files := runSubninjaTest(t, "out/subninja")
+
+	expectedFiles := []string{
+		"out/subninja/build.ninja",
+		"out/subninja/b/build.ninja",
+	}
+	if got := ctx.SubninjaFiles(); !reflect.DeepEqual(got, expectedFiles) {
+		t.Errorf("expected subninja files %q, got %q", expectedFiles, got)
+	}
+	if len(files) != len(expectedFiles) {
+		t.Errorf("expected %d subninja files to be written, got %d", len(expectedFiles), len(files))
+	}
+
+	expectedSubninjas := "subninja out/subninja/build.ninja\nsubninja out/subninja/b/build.ninja\n"
+	if !strings.Contains(manifest, expectedSubninjas) {
+		t.Errorf("expected manifest to contain %q:\n%s", expectedSubninjas, manifest)
+	}
+
+	// The globals are written once, before the subninja statements that use them.
+	subninjaIndex := strings.Index(manifest, "subninja ")
+	for _, global := range []string{"g.subninjatest.tool = ", "rule g.subninjatest.copy"} {
+		if i := strings.Index(manifest, global); i < 0 || i > subninjaIndex {
+			t.Errorf("expected %q before the subninja statements:\n%s", global, manifest)
+		}
+	}
+	if strings.Contains(manifest, "build a.out") {
+		t.Errorf("unexpected module build actions in manifest:\n%s", manifest)
+	}
+
+	b := files["out/subninja/b/build.ninja"]
+	if b == nil || !b.closed {
+		t.Fatalf("expected out/subninja/b/build.ninja to be written and closed")
+	}
+	for _, s := range []string{
+		"rule m.b1_.local",
+		"rule m.b2_.local",
+		"build b1.out: g.subninjatest.copy",
+		"build b2.out.local: m.b2_.local",
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected b/build.ninja to contain %q:\n%s", s, b.String())
+		}
+	}
+	if strings.Index(b.String(), "b1.out") > strings.Index(b.String(), "b2.out") {
+		t.Errorf("expected modules sorted by name:\n%s", b.String())
+	}
+	for _, s := range []string{"rule g.subninjatest.copy", "a.out"} {
+		if strings.Contains(b.String(), s) {
+			t.Errorf("unexpected %q in b/build.ninja:\n%s", s, b.String())
+		}
+	}
+}
+
+func TestSubninjaNotSplit(t *testing.T) {
+	ctx, manifest, files := runSubninjaTest(t, "")
+
+	if got := ctx.SubninjaFiles(); got != nil {
+		t.Errorf("expected no subninja files, got %q", got)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected no subninja files to be written, got %d", len(files))
+	}
+
+	plain := bytes.NewBuffer(nil)
+	ck(ctx.WriteBuildFile(plain))
+	if manifest != plain.String() {
+		t.Errorf("expected WriteBuildFiles to match WriteBuildFile")
+		t.Errorf("  expected: %q", plain.String())
+		t.Errorf("       got: %q", manifest)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+)
+
+// A module declares that it tests another module with the built-in test_for
+// property, and the test suites that it belongs to with the built-in
+// test_suites property, for example:
+//
+//	my_test {
+//	    name: "libfoo_test",
+//	    test_for: "libfoo",
+//	    test_suites: ["unit", "presubmit"],
+//	}
+//
+// The modules that set either property are the tests.  WriteTestManifest
+// lists them, and the singleton created by NewTestSuitesSingleton creates
+// phony Ninja targets that build them, so that tests can be found without
+// relying on the conventions for naming them.
+
+// testSuiteRegexp matches the valid names of test suites, which are used in
+// the names of Ninja targets.
+var testSuiteRegexp = regexp.MustCompile("^[a-zA-Z0-9_.-]+$")
+
+// isTest returns true if module is a test.
+func (module *moduleInfo) isTest() bool {
+	return module.properties.Test_for != "" || len(module.properties.Test_suites) > 0
+}
+
+// resolveTests finds the modules named by the test_for properties, and checks
+// the test_suites properties.
+func (c *Context) resolveTests() (errs []error) {
+	for _, name := range c.sortedModuleNames() {
+		for _, module := range c.moduleGroups[name].modules {
+			if testFor := module.properties.Test_for; testFor != "" {
+				group, err := c.lookupModuleGroup(module, testFor)
+				if err == nil && group == nil {
+					err = fmt.Errorf("%q tests undefined module %q",
+						module.properties.Name, testFor)
+				} else if err == nil && group == module.group {
+					err = fmt.Errorf("%q tests itself", module.properties.Name)
+				}
+				if err != nil {
+					errs = append(errs, &Error{
+						Err: err,
+						Pos: module.propertyPos["test_for"],
+					})
+				}
+				module.testFor = group
+			}
+
+			for _, suite := range module.properties.Test_suites {
+				if !testSuiteRegexp.MatchString(suite) {
+					errs = append(errs, &Error{
+						Err: fmt.Errorf("invalid test suite name %q", suite),
+						Pos: module.propertyPos["test_suites"],
+					})
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// A TestManifest lists the tests, as written by Context.WriteTestManifest.
+type TestManifest struct {
+	Tests []*TestInfo `json:"tests"`
+}
+
+// A TestInfo is the entry for a single test module variant in a TestManifest.
+// Paths are not Ninja-escaped.
+type TestInfo struct {
+	Name       string   `json:"name"`
+	Variant    string   `json:"variant,omitempty"`
+	Blueprints string   `json:"blueprints"`         // The Blueprints file that defines the test.
+	TestFor    string   `json:"test_for,omitempty"` // The name of the module that it tests.
+	Suites     []string `json:"suites,omitempty"`
+	Outputs    []string `json:"outputs"`
+}
+
+// TestManifest returns the tests, in name order.  If this is called before
+// PrepareBuildActions successfully completes then ErrBuildActionsNotReady is
+// returned.
+func (c *Context) TestManifest() (*TestManifest, error) {
+	if !c.buildActionsReady {
+		return nil, ErrBuildActionsNotReady
+	}
+
+	manifest := &TestManifest{
+		Tests: []*TestInfo{},
+	}
+
+	for _, name := range c.sortedModuleNames() {
+		for _, module := range c.moduleGroups[name].modules {
+			if !module.isTest() {
+				continue
+			}
+
+			outputs, err := c.evalModuleOutputs(module)
+			if err != nil {
+				return nil, err
+			}
+
+			info := &TestInfo{
+				Name:       module.properties.Name,
+				Variant:    module.variantName,
+				Blueprints: module.relBlueprintsFile,
+				Suites:     module.properties.Test_suites,
+				Outputs:    outputs,
+			}
+			if module.testFor != nil {
+				info.TestFor = module.testFor.name
+			}
+
+			manifest.Tests = append(manifest.Tests, info)
+		}
+	}
+
+	return manifest, nil
+}
+
+// WriteTestManifest writes the tests to w as a JSON encoded TestManifest.  If
+// this is called before PrepareBuildActions successfully completes then
+// ErrBuildActionsNotReady is returned.
+func (c *Context) WriteTestManifest(w io.Writer) error {
+	manifest, err := c.TestManifest()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// NewTestSuitesSingleton returns a singleton that creates a phony Ninja target
+// called "suite-<suite>" for each test suite, which builds the outputs of the
+// tests in it, and one called "tests-<module>" for each module that has tests,
+// which builds the outputs of the tests for it.  The phony targets are not
+// built by default.  It is registered with RegisterSingletonType, for example:
+//
+//	ctx.RegisterSingletonType("test_suites", blueprint.NewTestSuitesSingleton)
+func NewTestSuitesSingleton() Singleton {
+	return &testSuitesSingleton{}
+}
+
+type testSuitesSingleton struct{}
+
+func (s *testSuitesSingleton) GenerateBuildActions(ctx SingletonContext) {
+	sctx := ctx.(*singletonContext)
+	c := sctx.context
+
+	targets := make(map[string][]*ninjaString)
+	for _, name := range c.sortedModuleNames() {
+		for _, module := range c.moduleGroups[name].modules {
+			if !module.isTest() {
+				continue
+			}
+
+			outputs := moduleOutputs(module)
+			for _, suite := range module.properties.Test_suites {
+				target := "suite-" + suite
+				targets[target] = append(targets[target], outputs...)
+			}
+			if module.testFor != nil {
+				target := "tests-" + module.testFor.name
+				targets[target] = append(targets[target], outputs...)
+			}
+		}
+	}
+
+	var names []string
+	for target := range targets {
+		names = append(names, target)
+	}
+	sort.Strings(names)
+
+	for _, target := range names {
+		sctx.actionDefs.buildDefs = append(sctx.actionDefs.buildDefs, &buildDef{
+			Rule:     Phony,
+			Outputs:  []*ninjaString{simpleNinjaString(target)},
+			Inputs:   targets[target],
+			Optional: true,
+		})
+	}
+}
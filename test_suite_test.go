@@ -0,0 +1,129 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTestSuites(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("verify_module", newVerifyModule)
+	ctx.RegisterSingletonType("test_suites", NewTestSuitesSingleton)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", bytes.NewBufferString(`
+		verify_module { name: "libfoo", outs: ["$outDir/libfoo.a"], ins: ["foo.c"] }
+		verify_module {
+			name: "libfoo_test",
+			outs: ["$outDir/libfoo_test"],
+			ins: ["foo_test.c"],
+			test_for: "libfoo",
+			test_suites: ["unit"],
+		}
+		verify_module {
+			name: "integration_test",
+			outs: ["$outDir/integration_test"],
+			ins: ["integration_test.c"],
+			test_suites: ["unit", "presubmit"],
+		}
+	`), nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		errs = ctx.ResolveDependencies(nil)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	manifest, err := ctx.TestManifest()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := &TestManifest{
+		Tests: []*TestInfo{
+			{
+				Name:       "integration_test",
+				Blueprints: "Blueprint",
+				Suites:     []string{"unit", "presubmit"},
+				Outputs:    []string{"out/integration_test"},
+			},
+			{
+				Name:       "libfoo_test",
+				Blueprints: "Blueprint",
+				TestFor:    "libfoo",
+				Suites:     []string{"unit"},
+				Outputs:    []string{"out/libfoo_test"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(manifest, expected) {
+		t.Errorf("expected manifest %+v, got %+v", expected.Tests, manifest.Tests)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	ck(ctx.WriteBuildFile(buf))
+	manifestText := strings.Replace(buf.String(), " $\n        ", " ", -1)
+
+	for _, build := range []string{
+		"build suite-presubmit: phony out/integration_test\n",
+		"build suite-unit: phony out/integration_test out/libfoo_test\n",
+		"build tests-libfoo: phony out/libfoo_test\n",
+	} {
+		if !strings.Contains(manifestText, build) {
+			t.Errorf("expected manifest to contain %q:\n%s", build, manifestText)
+		}
+	}
+	if strings.Contains(manifestText, "default suite-") {
+		t.Errorf("expected the suites not to be built by default:\n%s", manifestText)
+	}
+}
+
+func TestTestSuitesErrors(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("verify_module", newVerifyModule)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", bytes.NewBufferString(`
+		verify_module { name: "a", test_for: "missing" }
+		verify_module { name: "b", test_for: "b" }
+		verify_module { name: "c", test_suites: ["bad suite"] }
+	`), nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		errs = ctx.ResolveDependencies(nil)
+	}
+
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	expected := []string{
+		`Blueprint:2:38: "a" tests undefined module "missing"`,
+		`Blueprint:3:38: "b" tests itself`,
+		`Blueprint:4:41: invalid test suite name "bad suite"`,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected errors %q, got %q", expected, got)
+	}
+}
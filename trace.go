@@ -0,0 +1,214 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SetTraceFile makes the Context record how long each of its phases takes and
+// write them to filename as a Chrome trace, which can be loaded into
+// chrome://tracing.  The phases are parsing the Blueprints files, resolving
+// dependencies, each mutator, generating the build actions of each module and
+// singleton, and writing the Ninja file.  The file is rewritten at the end of
+// ParseBlueprintsFiles, ResolveDependencies, PrepareBuildActions and
+// WriteBuildFile, so that it is complete no matter which of them is called
+// last.  Passing "" disables tracing.
+func (c *Context) SetTraceFile(filename string) {
+	if filename == "" {
+		c.tracer = nil
+		return
+	}
+
+	c.tracer = &tracer{
+		filename: filename,
+		start:    time.Now(),
+	}
+}
+
+// A traceSpan is a period of time spent on a phase, or on a single file,
+// module or singleton within a phase.
+type traceSpan struct {
+	name  string
+	cat   string
+	phase bool
+	start time.Time
+	end   time.Time
+}
+
+// A tracer records the spans of a Context.  Its methods may be called from
+// multiple goroutines.
+type tracer struct {
+	filename string
+	start    time.Time
+
+	lock  sync.Mutex
+	spans []traceSpan
+}
+
+// tracePhase starts a span for a phase, and returns a function that ends it.
+// The phases are shown nested on a single thread in the trace.
+func (c *Context) tracePhase(name string) func() {
+	return c.trace(name, "phase", true)
+}
+
+// traceItem starts a span for a file, module or singleton within a phase, and
+// returns a function that ends it.  Items may be processed concurrently, so
+// they are shown on as many threads as are needed.
+func (c *Context) traceItem(name, cat string) func() {
+	return c.trace(name, cat, false)
+}
+
+func (c *Context) trace(name, cat string, phase bool) func() {
+	t := c.tracer
+	if t == nil {
+		return func() {}
+	}
+
+	span := traceSpan{
+		name:  name,
+		cat:   cat,
+		phase: phase,
+		start: time.Now(),
+	}
+
+	return func() {
+		span.end = time.Now()
+
+		t.lock.Lock()
+		t.spans = append(t.spans, span)
+		t.lock.Unlock()
+	}
+}
+
+// traceTopLevel starts a span for a phase run by a public method of the
+// Context, and returns a function to be deferred that ends it and rewrites the
+// trace file, appending any error to errs.
+func (c *Context) traceTopLevel(name string, errs *[]error) func() {
+	end := c.tracePhase(name)
+
+	return func() {
+		end()
+		if c.tracer != nil {
+			err := c.tracer.writeFile()
+			if err != nil {
+				*errs = append(*errs, err)
+			}
+		}
+	}
+}
+
+// A traceEvent is a complete event in the Chrome trace event format.
+type traceEvent struct {
+	Name string `json:"name"`
+	Cat  string `json:"cat"`
+	Ph   string `json:"ph"`
+	Ts   int64  `json:"ts"`
+	Dur  int64  `json:"dur"`
+	Pid  int    `json:"pid"`
+	Tid  int    `json:"tid"`
+}
+
+// writeFile writes the spans recorded so far to a temporary file that is
+// renamed to the trace file.
+func (t *tracer) writeFile() error {
+	f, err := ioutil.TempFile(filepath.Dir(t.filename), filepath.Base(t.filename))
+	if err != nil {
+		return err
+	}
+
+	err = t.write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), t.filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// write writes the spans recorded so far to w as a Chrome trace.  Each item
+// span is assigned to the first thread after the one used by the phases that
+// is idle when it starts.
+func (t *tracer) write(w io.Writer) error {
+	t.lock.Lock()
+	spans := append([]traceSpan(nil), t.spans...)
+	t.lock.Unlock()
+
+	sort.Stable(spansByStart(spans))
+
+	micros := func(d time.Duration) int64 {
+		return int64(d / time.Microsecond)
+	}
+
+	var threadEnds []time.Time
+	events := []traceEvent{}
+	for _, s := range spans {
+		tid := 0
+		if !s.phase {
+			tid = -1
+			for i, end := range threadEnds {
+				if !end.After(s.start) {
+					tid = i
+					break
+				}
+			}
+			if tid == -1 {
+				tid = len(threadEnds)
+				threadEnds = append(threadEnds, time.Time{})
+			}
+			threadEnds[tid] = s.end
+			tid++
+		}
+
+		events = append(events, traceEvent{
+			Name: s.name,
+			Cat:  s.cat,
+			Ph:   "X",
+			Ts:   micros(s.start.Sub(t.start)),
+			Dur:  micros(s.end.Sub(s.start)),
+			Pid:  0,
+			Tid:  tid,
+		})
+	}
+
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}{events})
+}
+
+// spansByStart sorts spans by their start time, and spans that start at the
+// same time with the longest first so that they enclose the others.
+type spansByStart []traceSpan
+
+func (s spansByStart) Len() int { return len(s) }
+func (s spansByStart) Less(i, j int) bool {
+	if !s[i].start.Equal(s[j].start) {
+		return s[i].start.Before(s[j].start)
+	}
+	return s[i].end.After(s[j].end)
+}
+func (s spansByStart) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
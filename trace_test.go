@@ -0,0 +1,118 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTraceFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	blueprints := filepath.Join(dir, "Blueprints")
+	err = ioutil.WriteFile(blueprints, []byte(`
+		foo_module {
+			name: "MyFooModule",
+			deps: ["MyBarModule"],
+		}
+
+		bar_module {
+			name: "MyBarModule",
+		}
+	`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	traceFile := filepath.Join(dir, "trace.json")
+
+	ctx := NewContext()
+	ctx.RegisterModuleType("foo_module", newFooModule)
+	ctx.RegisterModuleType("bar_module", newBarModule)
+	ctx.RegisterBottomUpMutator("nop", func(BottomUpMutatorContext) {})
+	ctx.SetTraceFile(traceFile)
+
+	_, errs := ctx.ParseBlueprintsFiles(blueprints)
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions(nil)
+	}
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors:")
+		for _, err := range errs {
+			t.Errorf("  %s", err)
+		}
+		t.FailNow()
+	}
+
+	if err := ctx.WriteBuildFile(&bytes.Buffer{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(traceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var trace struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(data, &trace); err != nil {
+		t.Fatalf("invalid trace %s: %s", data, err)
+	}
+
+	found := make(map[string]traceEvent)
+	for _, event := range trace.TraceEvents {
+		found[event.Cat+" "+event.Name] = event
+	}
+
+	expected := []string{
+		"phase parse",
+		"parse " + blueprints,
+		"phase resolve dependencies",
+		"phase dependencies",
+		"phase prepare build actions",
+		"phase mutator nop",
+		"phase generate",
+		"module MyFooModule",
+		"module MyBarModule",
+		"phase singletons",
+		"phase write",
+	}
+	for _, name := range expected {
+		event, ok := found[name]
+		if !ok {
+			t.Errorf("missing %q event in trace %s", name, data)
+			continue
+		}
+		if event.Ph != "X" {
+			t.Errorf("expected %q event to be complete, got phase %q", name, event.Ph)
+		}
+		if event.Cat == "phase" && event.Tid != 0 {
+			t.Errorf("expected %q event on thread 0, got %d", name, event.Tid)
+		}
+		if event.Cat != "phase" && event.Tid == 0 {
+			t.Errorf("expected %q event on an item thread", name)
+		}
+	}
+}
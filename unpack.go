@@ -27,15 +27,24 @@ import (
 type packedProperty struct {
 	property *parser.Property
 	unpacked bool
+
+	// deprecated is true if the property was unpacked into a field tagged
+	// blueprint:"deprecated:<message>", and message is its message.
+	deprecated bool
+	message    string
 }
 
+// unpackProperties unpacks the properties into the property structs.  It
+// returns the properties that were set, and the messages of the ones that were
+// unpacked into fields tagged blueprint:"deprecated:<message>".
 func unpackProperties(propertyDefs []*parser.Property,
-	propertiesStructs ...interface{}) (map[string]*parser.Property, []error) {
+	propertiesStructs ...interface{}) (map[string]*parser.Property,
+	map[string]string, []error) {
 
 	propertyMap := make(map[string]*packedProperty)
 	errs := buildPropertyMap("", propertyDefs, propertyMap)
 	if len(errs) > 0 {
-		return nil, errs
+		return nil, nil, errs
 	}
 
 	for _, properties := range propertiesStructs {
@@ -49,19 +58,29 @@ func unpackProperties(propertyDefs []*parser.Property,
 			panic("properties must be a pointer to a struct")
 		}
 
+		// The defaults are overwritten by the properties that are set.
+		proptools.SetDefaults(propertiesValue)
+
 		newErrs := unpackStructValue("", propertiesValue, propertyMap, "", "")
 		errs = append(errs, newErrs...)
 
 		if len(errs) >= maxErrors {
-			return nil, errs
+			return nil, nil, errs
 		}
 	}
 
 	// Report any properties that didn't have corresponding struct fields as
 	// errors.
 	result := make(map[string]*parser.Property)
+	var deprecated map[string]string
 	for name, packedProperty := range propertyMap {
 		result[name] = packedProperty.property
+		if packedProperty.deprecated {
+			if deprecated == nil {
+				deprecated = make(map[string]string)
+			}
+			deprecated[name] = packedProperty.message
+		}
 		if !packedProperty.unpacked {
 			err := &Error{
 				Err: fmt.Errorf("unrecognized property %q", name),
@@ -72,10 +91,10 @@ func unpackProperties(propertyDefs []*parser.Property,
 	}
 
 	if len(errs) > 0 {
-		return nil, errs
+		return nil, nil, errs
 	}
 
-	return result, nil
+	return result, deprecated, nil
 }
 
 func buildPropertyMap(namePrefix string, propertyDefs []*parser.Property,
@@ -148,6 +167,12 @@ func unpackStructValue(namePrefix string, structValue reflect.Value,
 			if elemType.Kind() != reflect.String {
 				panic(fmt.Errorf("field %s is a non-string slice", field.Name))
 			}
+		case reflect.Map:
+			if field.Type.Key().Kind() != reflect.String ||
+				field.Type.Elem().Kind() != reflect.String {
+				panic(fmt.Errorf("field %s is not a map of strings to strings",
+					field.Name))
+			}
 		case reflect.Interface:
 			if fieldValue.IsNil() {
 				panic(fmt.Errorf("field %s contains a nil interface",
@@ -192,6 +217,11 @@ func unpackStructValue(namePrefix string, structValue reflect.Value,
 
 		packedProperty.unpacked = true
 
+		if message, ok := deprecationMessage(field.Tag); ok {
+			packedProperty.deprecated = true
+			packedProperty.message = message
+		}
+
 		if hasTag(field, "blueprint", "mutated") {
 			errs = append(errs,
 				&Error{
@@ -225,6 +255,8 @@ func unpackStructValue(namePrefix string, structValue reflect.Value,
 			newErrs = unpackString(fieldValue, packedProperty.property)
 		case reflect.Slice:
 			newErrs = unpackSlice(fieldValue, packedProperty.property)
+		case reflect.Map:
+			newErrs = unpackMap(fieldValue, packedProperty.property)
 		case reflect.Ptr, reflect.Interface:
 			fieldValue = fieldValue.Elem()
 			fallthrough
@@ -306,6 +338,47 @@ func unpackSlice(sliceValue reflect.Value, property *parser.Property) []error {
 	return nil
 }
 
+func unpackMap(mapValue reflect.Value, property *parser.Property) []error {
+	if property.Value.Type != parser.Map {
+		return []error{
+			fmt.Errorf("%s: can't assign %s value to %s property %q",
+				property.Value.Pos, property.Value.Type, parser.Map,
+				property.Name.Name),
+		}
+	}
+
+	var errs []error
+	mapType := mapValue.Type()
+	m := reflect.MakeMap(mapType)
+	entries := make(map[string]*parser.Property)
+	for _, entry := range property.Value.MapValue {
+		if first, present := entries[entry.Name.Name]; present {
+			errs = append(errs, fmt.Errorf("%s: entry %q of property %q already defined at %s",
+				entry.Pos, entry.Name.Name, property.Name.Name, first.Pos))
+			continue
+		}
+		entries[entry.Name.Name] = entry
+
+		if entry.Value.Type != parser.String {
+			errs = append(errs, fmt.Errorf("%s: can't assign %s value to %s entry %q of property %q",
+				entry.Value.Pos, entry.Value.Type, parser.String, entry.Name.Name,
+				property.Name.Name))
+			continue
+		}
+
+		// The key and element types may be named string types.
+		m.SetMapIndex(reflect.ValueOf(entry.Name.Name).Convert(mapType.Key()),
+			reflect.ValueOf(entry.Value.StringValue).Convert(mapType.Elem()))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	mapValue.Set(m)
+	return nil
+}
+
 func unpackStruct(namePrefix string, structValue reflect.Value,
 	property *parser.Property, propertyMap map[string]*packedProperty,
 	filterKey, filterValue string) []error {
@@ -337,6 +410,26 @@ func hasTag(field reflect.StructField, name, value string) bool {
 	return false
 }
 
+// deprecationMessage returns the message of a blueprint:"deprecated:<message>"
+// tag entry and true, or "" and false if there isn't one.  The message extends
+// to the end of the tag so that it may contain commas, which means that the
+// deprecated entry must be the last one.
+func deprecationMessage(field reflect.StructTag) (string, bool) {
+	tag := field.Get("blueprint")
+	entries := strings.Split(tag, ",")
+	for i, entry := range entries {
+		if entry == "deprecated" {
+			return "", true
+		}
+		if strings.HasPrefix(entry, "deprecated:") {
+			message := strings.Join(entries[i:], ",")
+			return strings.TrimPrefix(message, "deprecated:"), true
+		}
+	}
+
+	return "", false
+}
+
 func HasFilter(field reflect.StructTag) (k, v string, err error) {
 	tag := field.Get("blueprint")
 	for _, entry := range strings.Split(tag, ",") {
@@ -25,6 +25,10 @@ import (
 	"github.com/google/blueprint/proptools"
 )
 
+// unpackTestEnvName is a named string type used as the key type of a map
+// property.
+type unpackTestEnvName string
+
 var validUnpackTestCases = []struct {
 	input  string
 	output interface{}
@@ -70,6 +74,73 @@ var validUnpackTestCases = []struct {
 		nil,
 	},
 
+	{`
+		m {
+			env: {
+				"CC": "clang",
+				path: "/usr/bin",
+			}
+		}
+		`,
+		struct {
+			Env map[string]string
+		}{
+			Env: map[string]string{
+				"CC":   "clang",
+				"path": "/usr/bin",
+			},
+		},
+		nil,
+	},
+
+	{`
+		m {
+			env: {
+				CC: true,
+			}
+		}
+		`,
+		struct {
+			Env map[string]string
+		}{},
+		[]error{
+			fmt.Errorf("<input>:4:9: can't assign bool value to string entry \"CC\" of property \"env\""),
+		},
+	},
+
+	{`
+		m {
+			env: {
+				CC: "clang",
+			}
+		}
+		`,
+		struct {
+			Env map[unpackTestEnvName]string
+		}{
+			Env: map[unpackTestEnvName]string{
+				"CC": "clang",
+			},
+		},
+		nil,
+	},
+
+	{`
+		m {
+			env: {
+				CC: "clang",
+				"CC": "gcc",
+			}
+		}
+		`,
+		struct {
+			Env map[string]string
+		}{},
+		[]error{
+			fmt.Errorf("<input>:5:9: entry \"CC\" of property \"env\" already defined at <input>:4:7"),
+		},
+	},
+
 	{`
 		m {
 			nested: {
@@ -187,6 +258,44 @@ var validUnpackTestCases = []struct {
 			},
 		},
 	},
+
+	{`
+		m {
+			set: "abc",
+			off: false,
+			nested: {
+				list: [],
+			},
+		}
+		`,
+		struct {
+			Set    string   `default:"def"`
+			Unset  string   `default:"ghi"`
+			Off    bool     `default:"true"`
+			On     bool     `default:"true"`
+			Count  int      `default:"3" blueprint:"mutated"`
+			List   []string `default:"a,b"`
+			Nested struct {
+				List []string `default:"c"`
+				Foo  string   `default:"jkl"`
+			}
+		}{
+			Set:   "abc",
+			Unset: "ghi",
+			Off:   false,
+			On:    true,
+			Count: 3,
+			List:  []string{"a", "b"},
+			Nested: struct {
+				List []string `default:"c"`
+				Foo  string   `default:"jkl"`
+			}{
+				List: nil,
+				Foo:  "jkl",
+			},
+		},
+		nil,
+	},
 }
 
 func TestUnpackProperties(t *testing.T) {
@@ -205,7 +314,7 @@ func TestUnpackProperties(t *testing.T) {
 		module := file.Defs[0].(*parser.Module)
 		properties := proptools.CloneProperties(reflect.ValueOf(testCase.output))
 		proptools.ZeroProperties(properties.Elem())
-		_, errs = unpackProperties(module.Properties, properties.Interface())
+		_, _, errs = unpackProperties(module.Properties, properties.Interface())
 		if len(errs) != 0 && len(testCase.errs) == 0 {
 			t.Errorf("test case: %s", testCase.input)
 			t.Errorf("unexpected unpack errors:")
@@ -0,0 +1,213 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxNinjaPathLength is the longest path that the outputs and inputs of
+	// build statements may have, which is PATH_MAX on Linux.
+	maxNinjaPathLength = 4096
+
+	// maxNinjaValueLength is the longest value that variables and rule
+	// arguments may have.  Ninja runs each command as a single argument to
+	// the shell, which Linux limits to MAX_ARG_STRLEN bytes.
+	maxNinjaValueLength = 128 * 1024
+)
+
+// SetValidateManifest sets whether PrepareBuildActions checks that the strings
+// written to the Ninja file are valid: every path, variable value, rule
+// argument and rule variable must be valid UTF-8 without NUL bytes, paths must
+// be at most 4096 bytes and values at most 128KiB.  Ninja would otherwise fail
+// to parse the file, or fail to run the commands, with an error that doesn't
+// say where the string came from.  Each problem is reported as an error that
+// names the module or singleton responsible for it.
+func (c *Context) SetValidateManifest(validate bool) {
+	c.validateManifest = validate
+}
+
+// checkNinjaValue returns a description of the problem with value, or "" if it
+// is valid and at most max bytes long.
+func checkNinjaValue(value string, max int) string {
+	switch {
+	case !utf8.ValidString(value):
+		return "is not valid UTF-8"
+	case strings.IndexByte(value, 0) >= 0:
+		return "contains a NUL byte"
+	case len(value) > max:
+		return fmt.Sprintf("is %d bytes, more than the limit of %d bytes",
+			len(value), max)
+	default:
+		return ""
+	}
+}
+
+// quoteNinjaValue quotes value for an error message, shortening long values.
+func quoteNinjaValue(value string) string {
+	const max = 64
+	if len(value) > max {
+		return fmt.Sprintf("%q...", value[:max])
+	}
+	return fmt.Sprintf("%q", value)
+}
+
+// checkRuleDef returns the problems with the unevaluated variables of a rule,
+// whose values can't be evaluated until Ninja substitutes $in and $out.
+func checkRuleDef(def *ruleDef) []string {
+	var problems []string
+	check := func(kind string, values map[string]*ninjaString) {
+		var names []string
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			value := strings.Join(values[name].strings, "")
+			if problem := checkNinjaValue(value, maxNinjaValueLength); problem != "" {
+				problems = append(problems, fmt.Sprintf("%s %s %s %s", kind,
+					name, quoteNinjaValue(value), problem))
+			}
+		}
+	}
+
+	check("variable", def.Variables)
+	check("environment variable", def.Env)
+
+	return problems
+}
+
+// checkManifest returns the problems with the strings written to the Ninja
+// file described by SetValidateManifest.  It must be called after the global
+// variables have been collected by PrepareBuildActions.
+func (c *Context) checkManifest() (errs []error) {
+	errorf := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	var globalVariables, globalRules []string
+	variables := make(map[string]*ninjaString)
+	for v, value := range c.globalVariables {
+		name := v.fullName(c.pkgNames)
+		globalVariables = append(globalVariables, name)
+		variables[name] = value
+	}
+	sort.Strings(globalVariables)
+
+	for _, name := range globalVariables {
+		value, err := variables[name].Eval(c.globalVariables)
+		if err != nil {
+			errorf("global variable %s: %s", name, err)
+		} else if problem := checkNinjaValue(value, maxNinjaValueLength); problem != "" {
+			errorf("global variable %s value %s %s", name,
+				quoteNinjaValue(value), problem)
+		}
+	}
+
+	rules := make(map[string]*ruleDef)
+	for r, def := range c.globalRules {
+		name := r.fullName(c.pkgNames)
+		globalRules = append(globalRules, name)
+		rules[name] = def
+	}
+	sort.Strings(globalRules)
+
+	for _, name := range globalRules {
+		for _, problem := range checkRuleDef(rules[name]) {
+			errorf("global rule %s: %s", name, problem)
+		}
+	}
+
+	for _, owner := range c.actionsOwners() {
+		eval := c.evalFunc(owner)
+
+		ownerErrorf := func(format string, args ...interface{}) {
+			errs = append(errs, &Error{
+				Err: fmt.Errorf("%s: %s", owner.desc, fmt.Sprintf(format, args...)),
+				Pos: owner.pos,
+			})
+		}
+
+		for _, v := range owner.actionDefs.variables {
+			values, err := eval([]*ninjaString{v.value_})
+			if err != nil {
+				ownerErrorf("variable %s: %s", v.name(), err)
+			} else if problem := checkNinjaValue(values[0], maxNinjaValueLength); problem != "" {
+				ownerErrorf("variable %s value %s %s", v.name(),
+					quoteNinjaValue(values[0]), problem)
+			}
+		}
+
+		for _, r := range owner.actionDefs.rules {
+			for _, problem := range checkRuleDef(r.def_) {
+				ownerErrorf("rule %s: %s", r.name(), problem)
+			}
+		}
+
+		for _, def := range owner.actionDefs.buildDefs {
+			rule := def.Rule.name()
+
+			paths := []struct {
+				kind string
+				strs []*ninjaString
+			}{
+				{"output", def.Outputs},
+				{"symlink output", def.SymlinkOutputs},
+				{"input", def.Inputs},
+				{"implicit input", def.Implicits},
+				{"order-only input", def.OrderOnly},
+				{"validation", def.Validations},
+			}
+			for _, p := range paths {
+				values, err := eval(p.strs)
+				if err != nil {
+					ownerErrorf("rule %s: %s", rule, err)
+					continue
+				}
+				for _, value := range values {
+					if problem := checkNinjaValue(value, maxNinjaPathLength); problem != "" {
+						ownerErrorf("%s %s of rule %s %s", p.kind,
+							quoteNinjaValue(value), rule, problem)
+					}
+				}
+			}
+
+			var args []Variable
+			for v := range def.Args {
+				args = append(args, v)
+			}
+			sort.Slice(args, func(i, j int) bool {
+				return args[i].name() < args[j].name()
+			})
+
+			for _, v := range args {
+				values, err := eval([]*ninjaString{def.Args[v]})
+				if err != nil {
+					ownerErrorf("rule %s: %s", rule, err)
+				} else if problem := checkNinjaValue(values[0], maxNinjaValueLength); problem != "" {
+					ownerErrorf("argument %s %s of rule %s %s", v.name(),
+						quoteNinjaValue(values[0]), rule, problem)
+				}
+			}
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,119 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var longPath = "out/" + strings.Repeat("x", maxNinjaPathLength)
+
+var validateManifestTestCases = []struct {
+	validate bool
+	bp       string
+	errs     []string
+}{
+	{
+		validate: true,
+		bp: `
+			verify_module { name: "a", outs: ["$outDir/é"], ins: ["a.in"] }
+		`,
+	},
+	{
+		validate: true,
+		bp: `
+			verify_module { name: "a", outs: ["$outDir/a\xff"], ins: ["a.in"] }
+			verify_module { name: "b", outs: ["$outDir/b"], ins: ["b\x00.in"] }
+		`,
+		errs: []string{
+			`module a: output "out/a\xff" of rule usedRule is not valid UTF-8`,
+			`module b: input "b\x00.in" of rule usedRule contains a NUL byte`,
+		},
+	},
+	{
+		validate: true,
+		bp: `
+			verify_module { name: "a", outs: ["` + longPath + `"] }
+		`,
+		errs: []string{
+			`module a: output "out/xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"... ` +
+				`of rule usedRule is 4100 bytes, more than the limit of 4096 bytes`,
+		},
+	},
+	{
+		validate: false,
+		bp: `
+			verify_module { name: "a", outs: ["$outDir/a\xff"], ins: ["a.in"] }
+		`,
+	},
+}
+
+func TestValidateManifest(t *testing.T) {
+	for i, testCase := range validateManifestTestCases {
+		ctx := NewContext()
+		ctx.RegisterModuleType("verify_module", newVerifyModule)
+		ctx.SetValidateManifest(testCase.validate)
+
+		r := bytes.NewBufferString(testCase.bp)
+		modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+		if len(errs) == 0 {
+			errs = ctx.addModules(modules)
+		}
+		if len(errs) == 0 {
+			errs = ctx.ResolveDependencies(nil)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("test case %d: unexpected errors: %v", i, errs)
+		}
+
+		_, errs = ctx.PrepareBuildActions(nil)
+
+		var msgs []string
+		for _, err := range errs {
+			msgs = append(msgs, err.(*Error).Err.Error())
+		}
+
+		if !reflect.DeepEqual(msgs, testCase.errs) {
+			t.Errorf("test case %d: expected errors %q, got %q", i, testCase.errs, msgs)
+		}
+
+		if ready := len(errs) == 0; ctx.buildActionsReady != ready {
+			t.Errorf("test case %d: expected buildActionsReady to be %v", i, ready)
+		}
+	}
+}
+
+func TestCheckNinjaValue(t *testing.T) {
+	testCases := []struct {
+		value    string
+		max      int
+		expected string
+	}{
+		{"abc", 3, ""},
+		{"abcd", 3, "is 4 bytes, more than the limit of 3 bytes"},
+		{"a\xc3", 10, "is not valid UTF-8"},
+		{"a\x00b", 10, "contains a NUL byte"},
+	}
+
+	for _, testCase := range testCases {
+		if got := checkNinjaValue(testCase.value, testCase.max); got != testCase.expected {
+			t.Errorf("checkNinjaValue(%q, %d): expected %q, got %q", testCase.value,
+				testCase.max, testCase.expected, got)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"sort"
+	"text/scanner"
+)
+
+// SetVerifyBuildActions sets whether PrepareBuildActions checks the build
+// actions for problems that would make Ninja reject the generated file or
+// build the wrong thing: build statements with empty outputs, outputs that
+// are also inputs of the same build statement, and outputs that are built by
+// more than one build statement.  Each problem is reported as an error that
+// names the module or singleton responsible for it.  Arguments that a rule
+// doesn't declare are always rejected by Build.
+func (c *Context) SetVerifyBuildActions(verify bool) {
+	c.verifyBuildActions = verify
+}
+
+// An actionsOwner is a module or singleton whose build actions are checked by
+// checkBuildActions.
+type actionsOwner struct {
+	desc       string
+	pos        scanner.Position
+	actionDefs *localBuildActions
+}
+
+// actionsOwners returns the modules and singletons that have build actions.
+// The modules are in name order, rather than in dependency order, so that
+// the problems found in their build actions are the same every time.
+func (c *Context) actionsOwners() []actionsOwner {
+	var owners []actionsOwner
+	for _, name := range c.sortedModuleNames() {
+		for _, module := range c.moduleGroups[name].modules {
+			desc := fmt.Sprintf("module %s", module.properties.Name)
+			if module.variantName != "" {
+				desc += fmt.Sprintf(" variant %s", module.variantName)
+			}
+			owners = append(owners, actionsOwner{desc, module.pos, &module.actionDefs})
+		}
+	}
+
+	var singletonNames []string
+	for name := range c.singletonInfo {
+		singletonNames = append(singletonNames, name)
+	}
+	sort.Strings(singletonNames)
+
+	for _, name := range singletonNames {
+		owners = append(owners, actionsOwner{"singleton " + name,
+			scanner.Position{}, &c.singletonInfo[name].actionDefs})
+	}
+
+	return owners
+}
+
+// evalFunc returns a function that evaluates the values of the owner's build
+// actions, using the values of its local variables and the global variables.
+func (c *Context) evalFunc(owner actionsOwner) func(strs []*ninjaString) ([]string, error) {
+	locals := make(map[Variable]*ninjaString)
+	for _, v := range owner.actionDefs.variables {
+		locals[v] = v.value_
+	}
+
+	return func(strs []*ninjaString) ([]string, error) {
+		var values []string
+		for _, s := range strs {
+			value, err := s.substitute(locals).Eval(c.globalVariables)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	}
+}
+
+// checkBuildActions returns the problems with the build actions described by
+// SetVerifyBuildActions.  It must be called after the global variables have
+// been collected by PrepareBuildActions.
+func (c *Context) checkBuildActions() (errs []error) {
+	writers := make(map[string]string)
+	for _, owner := range c.actionsOwners() {
+		eval := c.evalFunc(owner)
+
+		ownerErrorf := func(format string, args ...interface{}) {
+			errs = append(errs, &Error{
+				Err: fmt.Errorf("%s: %s", owner.desc, fmt.Sprintf(format, args...)),
+				Pos: owner.pos,
+			})
+		}
+
+		for _, def := range owner.actionDefs.buildDefs {
+			rule := def.Rule.name()
+
+			outputs, err := eval(def.Outputs)
+			if err != nil {
+				ownerErrorf("rule %s: %s", rule, err)
+				continue
+			}
+
+			var inputs []string
+			for _, strs := range [][]*ninjaString{def.Inputs, def.Implicits, def.OrderOnly,
+				def.Validations} {
+				values, err := eval(strs)
+				if err != nil {
+					ownerErrorf("rule %s: %s", rule, err)
+					continue
+				}
+				inputs = append(inputs, values...)
+			}
+
+			isInput := make(map[string]bool)
+			for _, input := range inputs {
+				isInput[input] = true
+			}
+
+			for _, output := range outputs {
+				if output == "" {
+					ownerErrorf("rule %s has an empty output", rule)
+					continue
+				}
+
+				if isInput[output] {
+					ownerErrorf("output %q of rule %s is also one of its inputs",
+						output, rule)
+				}
+
+				if writer, ok := writers[output]; ok {
+					ownerErrorf("output %q of rule %s is also built by %s",
+						output, rule, writer)
+				} else {
+					writers[output] = owner.desc
+				}
+			}
+		}
+	}
+
+	return errs
+}
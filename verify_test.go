@@ -0,0 +1,127 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// verifyModule builds its outputs from its inputs.
+type verifyModule struct {
+	properties struct {
+		Outs []string
+		Ins  []string
+	}
+}
+
+func newVerifyModule() (Module, []interface{}) {
+	m := &verifyModule{}
+	return m, []interface{}{&m.properties}
+}
+
+func (v *verifyModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Variable(usageTestPctx, "outDir", "out")
+	ctx.Build(usageTestPctx, BuildParams{
+		Rule:    usageTestRule,
+		Outputs: v.properties.Outs,
+		Inputs:  v.properties.Ins,
+	})
+}
+
+var verifyBuildActionsTestCases = []struct {
+	verify bool
+	bp     string
+	errs   []string
+}{
+	{
+		verify: true,
+		bp: `
+			verify_module { name: "a", outs: ["$outDir/a"], ins: ["a.in"] }
+			verify_module { name: "b", outs: ["$outDir/b"], ins: ["out/a"] }
+		`,
+	},
+	{
+		verify: true,
+		bp: `
+			verify_module { name: "a", outs: ["$outDir/x"] }
+			verify_module { name: "b", outs: ["out/x"] }
+		`,
+		errs: []string{
+			`module b: output "out/x" of rule usedRule is also built by module a`,
+		},
+	},
+	{
+		verify: true,
+		bp: `
+			verify_module { name: "a", outs: ["a", "$outDir/a"], ins: ["out/a"] }
+		`,
+		errs: []string{
+			`module a: output "out/a" of rule usedRule is also one of its inputs`,
+		},
+	},
+	{
+		verify: true,
+		bp: `
+			verify_module { name: "a", outs: [""] }
+		`,
+		errs: []string{
+			`module a: rule usedRule has an empty output`,
+		},
+	},
+	{
+		verify: false,
+		bp: `
+			verify_module { name: "a", outs: ["x"] }
+			verify_module { name: "b", outs: ["x"] }
+		`,
+	},
+}
+
+func TestVerifyBuildActions(t *testing.T) {
+	for i, testCase := range verifyBuildActionsTestCases {
+		ctx := NewContext()
+		ctx.RegisterModuleType("verify_module", newVerifyModule)
+		ctx.SetVerifyBuildActions(testCase.verify)
+
+		r := bytes.NewBufferString(testCase.bp)
+		modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+		if len(errs) == 0 {
+			errs = ctx.addModules(modules)
+		}
+		if len(errs) == 0 {
+			errs = ctx.ResolveDependencies(nil)
+		}
+		if len(errs) > 0 {
+			t.Fatalf("test case %d: unexpected errors: %v", i, errs)
+		}
+
+		_, errs = ctx.PrepareBuildActions(nil)
+
+		var msgs []string
+		for _, err := range errs {
+			msgs = append(msgs, err.(*Error).Err.Error())
+		}
+
+		if !reflect.DeepEqual(msgs, testCase.errs) {
+			t.Errorf("test case %d: expected errors %q, got %q", i, testCase.errs, msgs)
+		}
+
+		if ready := len(errs) == 0; ctx.buildActionsReady != ready {
+			t.Errorf("test case %d: expected buildActionsReady to be %v", i, ready)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Every module has a built-in visibility property that limits which modules
+// may depend on it.  Each entry of the list allows some modules to:
+//
+//	"//visibility:public"          all modules, which is the default
+//	"//visibility:private"         only the modules in the same directory
+//	"//<dir>:__pkg__"              the modules in the Blueprints file in dir
+//	"//<dir>:__subpackages__"      the modules in dir and its subdirectories
+//	"//<dir>:<name>"               the module called name in dir
+//
+// Directories are relative to the directory of the root Blueprints file, and
+// the modules in the same directory as a module may always depend on it.
+// The dependencies are checked by ResolveDependencies and again once the
+// mutators have run.
+
+// A visibilityRule is a parsed entry of the visibility property.
+type visibilityRule struct {
+	dir  string
+	name string // "__pkg__", "__subpackages__" or a module name
+}
+
+func (r visibilityRule) allows(dep *moduleInfo) bool {
+	dir := filepath.Dir(dep.relBlueprintsFile)
+	switch r.name {
+	case "__pkg__":
+		return dir == r.dir
+	case "__subpackages__":
+		return r.dir == "." || dir == r.dir ||
+			strings.HasPrefix(dir, r.dir+string(filepath.Separator))
+	default:
+		return dir == r.dir && dep.properties.Name == r.name
+	}
+}
+
+// parseVisibility parses the visibility property of module.  It returns nil
+// rules for modules that are visible to all modules.
+func parseVisibility(module *moduleInfo) ([]visibilityRule, []error) {
+	visibility := module.properties.Visibility
+	if len(visibility) == 0 {
+		return nil, nil
+	}
+
+	pos := module.propertyPos["visibility"]
+
+	var rules []visibilityRule
+	var errs []error
+	for _, entry := range visibility {
+		switch entry {
+		case "//visibility:public", "//visibility:private":
+			if len(visibility) > 1 {
+				errs = append(errs, &Error{
+					Err: fmt.Errorf("visibility %q can't be combined with other entries", entry),
+					Pos: pos,
+				})
+			}
+			if entry == "//visibility:private" {
+				rules = []visibilityRule{}
+			}
+			continue
+		}
+
+		i := strings.LastIndex(entry, ":")
+		if !strings.HasPrefix(entry, "//") || i == -1 || i == len(entry)-1 {
+			errs = append(errs, &Error{
+				Err: fmt.Errorf("invalid visibility %q, expected //<dir>:__pkg__, "+
+					"//<dir>:__subpackages__ or //<dir>:<module name>", entry),
+				Pos: pos,
+			})
+			continue
+		}
+
+		rules = append(rules, visibilityRule{
+			dir:  namespaceDir(entry[:i]),
+			name: entry[i+1:],
+		})
+	}
+
+	return rules, errs
+}
+
+// checkVisibility returns an error for each dependency on a module whose
+// visibility property doesn't allow it.
+func (c *Context) checkVisibility() (errs []error) {
+	for _, name := range c.sortedModuleNames() {
+		for _, module := range c.moduleGroups[name].modules {
+			for _, dep := range module.directDeps {
+				if visibleTo(dep, module) {
+					continue
+				}
+
+				errs = append(errs, &Error{
+					Err: fmt.Errorf("%q in %s may not depend on %q in %s, which is only visible to %q",
+						module.properties.Name, module.relBlueprintsFile,
+						dep.properties.Name, dep.relBlueprintsFile,
+						dep.properties.Visibility),
+					Pos: module.propertyPos["deps"],
+				})
+				if len(errs) > maxErrors {
+					return errs
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// visibleTo returns true if the visibility of dep allows module to depend on
+// it.
+func visibleTo(dep, module *moduleInfo) bool {
+	if dep.visibility == nil {
+		return true
+	}
+
+	if filepath.Dir(dep.relBlueprintsFile) == filepath.Dir(module.relBlueprintsFile) {
+		return true
+	}
+
+	for _, rule := range dep.visibility {
+		if rule.allows(module) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,90 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+var visibilityTestLibs = blueprintsTestFile{"lib/Blueprints", `
+	foo_module { name: "private", visibility: ["//visibility:private"] }
+	foo_module { name: "pkg", visibility: ["//app:__pkg__"] }
+	foo_module { name: "sub", visibility: ["//app:__subpackages__"] }
+	foo_module { name: "one", visibility: ["//app/tool:t"] }
+	foo_module { name: "public", visibility: ["//visibility:public"] }
+	foo_module { name: "local", deps: ["private"] }
+`}
+
+func TestVisibility(t *testing.T) {
+	testCases := []struct {
+		file blueprintsTestFile
+		errs []string
+	}{
+		{
+			file: blueprintsTestFile{"app/Blueprints", `
+				foo_module { name: "a", deps: ["pkg", "sub", "public"] }
+			`},
+		},
+		{
+			file: blueprintsTestFile{"app/tool/Blueprints", `
+				foo_module { name: "t", deps: ["sub", "one"] }
+			`},
+		},
+		{
+			file: blueprintsTestFile{"app/tool/Blueprints", `
+				foo_module { name: "u", deps: ["pkg", "one", "private"] }
+			`},
+			errs: []string{
+				`app/tool/Blueprints:2:33: "u" in app/tool/Blueprints may not depend on "pkg" in ` +
+					`lib/Blueprints, which is only visible to ["//app:__pkg__"]`,
+				`app/tool/Blueprints:2:33: "u" in app/tool/Blueprints may not depend on "one" in ` +
+					`lib/Blueprints, which is only visible to ["//app/tool:t"]`,
+				`app/tool/Blueprints:2:33: "u" in app/tool/Blueprints may not depend on "private" in ` +
+					`lib/Blueprints, which is only visible to ["//visibility:private"]`,
+			},
+		},
+		{
+			file: blueprintsTestFile{"app/Blueprints", `
+				foo_module { name: "a", visibility: ["//visibility:public", "//app:__pkg__"] }
+			`},
+			errs: []string{
+				`app/Blueprints:2:39: visibility "//visibility:public" can't be combined with other entries`,
+			},
+		},
+		{
+			file: blueprintsTestFile{"app/Blueprints", `
+				foo_module { name: "a", visibility: ["app"] }
+			`},
+			errs: []string{
+				`app/Blueprints:2:39: invalid visibility "app", expected //<dir>:__pkg__, ` +
+					`//<dir>:__subpackages__ or //<dir>:<module name>`,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, errs := prepareNamespaceTest([]blueprintsTestFile{visibilityTestLibs, testCase.file})
+
+		var got []string
+		for _, err := range errs {
+			got = append(got, err.Error())
+		}
+		if !reflect.DeepEqual(got, testCase.errs) {
+			t.Errorf("for %s\nexpected errors %q\ngot %q", testCase.file.contents,
+				testCase.errs, got)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// Values like the source revision or the build number change on every build.
+// If they were the values of ordinary Ninja variables, every build statement
+// whose command uses them would be rerun whenever they change, and the Ninja
+// file itself would change on every build.  A volatile variable instead
+// expands to the path of a file that holds its value, which the primary
+// builder only rewrites when the value actually changes.  Commands read the
+// value from the file, and the build statements that use the variable depend
+// on the file, so the stamped outputs are rebuilt exactly when the value they
+// stamp changes.
+
+// volatileDir is the directory, relative to the directory set by SetOutDir,
+// that holds the files with the values of the volatile variables.
+const volatileDir = ".volatile"
+
+type volatileVariable struct {
+	pctx   *PackageContext
+	name_  string
+	value_ func(interface{}) (string, error)
+}
+
+// VolatileVariable returns a Variable for a value that changes often, like a
+// source revision or build number, that is determined by a function that
+// takes a config object as input and returns either the value or an error.
+// It may only be called during a Go package's initialization - either from
+// the init() function or as part of a package-scoped variable's
+// initialization.
+//
+// Unlike the other variables, the Ninja value of the variable is the path of
+// a file that contains the value returned by f, so commands must read it, for
+// example with "$$(cat $buildNumber)".  The file is only rewritten when the
+// value changes, and every build statement whose rule or arguments refer to
+// the variable, directly or through other variables, has the file added to
+// its implicit dependencies.  The value returned by f is not parsed as a Ninja
+// string.
+func (p *PackageContext) VolatileVariable(name string,
+	f func(config interface{}) (string, error)) Variable {
+
+	checkCalledFromInit()
+
+	err := validateNinjaName(name)
+	if err != nil {
+		panic(err)
+	}
+
+	v := &volatileVariable{p, name, f}
+	err = p.scope.AddVariable(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+func (v *volatileVariable) packageContext() *PackageContext {
+	return v.pctx
+}
+
+func (v *volatileVariable) name() string {
+	return v.name_
+}
+
+func (v *volatileVariable) fullName(pkgNames map[*PackageContext]string) string {
+	return packageNamespacePrefix(pkgNames[v.pctx]) + v.name_
+}
+
+// value returns the path of the file that holds the value of the variable.
+func (v *volatileVariable) value(interface{}) (*ninjaString, error) {
+	return simpleNinjaString(v.path("")), nil
+}
+
+// path returns the path of the file that holds the value of the variable when
+// the output directory is outDir.
+func (v *volatileVariable) path(outDir string) string {
+	return filepath.Join(outDir, volatileDir, v.pctx.pkgPath, v.name_)
+}
+
+func (v *volatileVariable) String() string {
+	return v.pctx.pkgPath + "." + v.name_
+}
+
+// A VolatileFile is the file that holds the value of a live variable created
+// with PackageContext.VolatileVariable.
+type VolatileFile struct {
+	Path  string
+	Value string
+}
+
+// VolatileFiles returns the files that hold the values of the volatile
+// variables used by the build actions generated by PrepareBuildActions,
+// sorted by path.  The primary builder writes them before Ninja runs, and
+// must only rewrite the ones whose values changed so that the build
+// statements that depend on the others aren't rerun.
+func (c *Context) VolatileFiles() []VolatileFile {
+	values := make(map[string]string, len(c.volatileValues))
+	paths := make([]string, 0, len(c.volatileValues))
+	for v, value := range c.volatileValues {
+		path := v.path(c.outDir)
+		values[path] = value
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	files := make([]VolatileFile, len(paths))
+	for i, path := range paths {
+		files[i] = VolatileFile{path, values[path]}
+	}
+	return files
+}
+
+// volatileRef returns a Ninja string that refers to v.
+func volatileRef(v *volatileVariable) *ninjaString {
+	return &ninjaString{
+		strings:   []string{"", ""},
+		variables: []Variable{v},
+	}
+}
+
+// addVolatileVariable computes the value of v and records it.
+func (l *liveTracker) addVolatileVariable(v *volatileVariable) error {
+	value, err := v.value_(l.config)
+	if err != nil {
+		return fmt.Errorf("error evaluating volatile variable %s: %s", v, err)
+	}
+
+	if l.volatileValues == nil {
+		l.volatileValues = make(map[*volatileVariable]string)
+	}
+	l.volatileValues[v] = value
+	l.variables[v] = simpleNinjaString(v.path(l.outDir))
+
+	return nil
+}
+
+// addVolatileImplicits adds the files of the volatile variables that the rule
+// and arguments of def refer to, directly or through other live variables, to
+// the implicit dependencies of def.
+func (l *liveTracker) addVolatileImplicits(def *buildDef) {
+	found := make(map[*volatileVariable]bool)
+	visited := make(map[Variable]bool)
+
+	if ruleDef := l.rules[def.Rule]; ruleDef != nil {
+		for _, value := range ruleDef.Variables {
+			l.findVolatileVariables(value, found, visited)
+		}
+	}
+	for _, value := range def.Args {
+		l.findVolatileVariables(value, found, visited)
+	}
+
+	if len(found) == 0 {
+		return
+	}
+
+	byName := make(map[string]*volatileVariable, len(found))
+	names := make([]string, 0, len(found))
+	for v := range found {
+		byName[v.String()] = v
+		names = append(names, v.String())
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		def.Implicits = append(def.Implicits, volatileRef(byName[name]))
+	}
+}
+
+func (l *liveTracker) findVolatileVariables(str *ninjaString,
+	found map[*volatileVariable]bool, visited map[Variable]bool) {
+
+	for _, v := range str.variables {
+		if visited[v] {
+			continue
+		}
+		visited[v] = true
+
+		if volatile, ok := v.(*volatileVariable); ok {
+			found[volatile] = true
+		} else if value, ok := l.variables[v]; ok {
+			l.findVolatileVariables(value, found, visited)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2015 Google Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blueprint
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var (
+	volatileTestPctx = NewPackageContext("github.com/google/blueprint/volatiletest")
+
+	volatileTestBuildNumber = volatileTestPctx.VolatileVariable("buildNumber",
+		func(config interface{}) (string, error) {
+			return config.(string), nil
+		})
+	volatileTestReadBuildNumber = volatileTestPctx.StaticVariable("readBuildNumber",
+		"cat $buildNumber")
+
+	volatileTestStamp = volatileTestPctx.StaticRule("stamp",
+		RuleParams{
+			Command: "stamp -n $$($readBuildNumber) $in $out",
+		})
+	volatileTestCopy = volatileTestPctx.StaticRule("copy",
+		RuleParams{
+			Command: "cp $in $out",
+		})
+)
+
+type volatileTestModule struct{}
+
+func newVolatileTestModule() (Module, []interface{}) {
+	return &volatileTestModule{}, nil
+}
+
+func (v *volatileTestModule) GenerateBuildActions(ctx ModuleContext) {
+	ctx.Build(volatileTestPctx, BuildParams{
+		Rule:    volatileTestCopy,
+		Outputs: []string{"out/a"},
+		Inputs:  []string{"a"},
+	})
+	ctx.Build(volatileTestPctx, BuildParams{
+		Rule:    volatileTestStamp,
+		Outputs: []string{"out/a.stamped"},
+		Inputs:  []string{"out/a"},
+	})
+}
+
+func TestVolatileVariable(t *testing.T) {
+	ctx := NewContext()
+	ctx.RegisterModuleType("volatile_module", newVolatileTestModule)
+	ctx.SetOutDir("out")
+
+	r := bytes.NewBufferString(`volatile_module { name: "a" }`)
+
+	modules, _, _, errs := ctx.parse(".", "Blueprint", r, nil)
+	if len(errs) == 0 {
+		errs = ctx.addModules(modules)
+	}
+	if len(errs) == 0 {
+		_, errs = ctx.PrepareBuildActions("1234")
+	}
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	expectedFiles := []VolatileFile{
+		{"out/.volatile/github.com/google/blueprint/volatiletest/buildNumber", "1234"},
+	}
+	if files := ctx.VolatileFiles(); !reflect.DeepEqual(files, expectedFiles) {
+		t.Errorf("expected volatile files %v, got %v", expectedFiles, files)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := ctx.WriteBuildFile(buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, expected := range []string{
+		"g.volatiletest.buildNumber = out/.volatile/github.com/google/blueprint/volatiletest/buildNumber\n",
+		"build out/a: g.volatiletest.copy a\n",
+		"build out/a.stamped: g.volatiletest.stamp out/a | $\n        ${g.volatiletest.buildNumber}\n",
+	} {
+		if !bytes.Contains(buf.Bytes(), []byte(expected)) {
+			t.Errorf("expected %q in:\n%s", expected, buf)
+		}
+	}
+
+	if bytes.Contains(buf.Bytes(), []byte("1234")) {
+		t.Errorf("expected the value of the volatile variable not to be in:\n%s", buf)
+	}
+}